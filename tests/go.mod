@@ -2,11 +2,23 @@ module github.com/bos-ai/infrastructure/tests
 
 go 1.21
 
-require github.com/stretchr/testify v1.8.4
+require (
+	github.com/aws/aws-sdk-go v1.55.8
+	github.com/hashicorp/hcl/v2 v2.21.0
+	github.com/leanovate/gopter v0.2.11
+	github.com/stretchr/testify v1.8.4
+	github.com/zclconf/go-cty v1.13.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
+	github.com/agext/levenshtein v1.2.1 // indirect
+	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
+	github.com/apparentlymart/go-textseg/v15 v15.0.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/leanovate/gopter v0.2.11 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/tools v0.7.0 // indirect
 )
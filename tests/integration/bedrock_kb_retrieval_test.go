@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/retrieval"
+)
+
+const (
+	goldenSetPath = "../../test_rtl/rag_result/golden.yaml"
+	baselineDir   = "../../test_rtl/rag_result/baseline"
+
+	// retrievalTolerance is how far recall@k, MRR and citation correctness
+	// may fall below the baseline before the test fails; the ranking of a
+	// knowledge base changes slightly between ingestion jobs.
+	retrievalTolerance = 0.05
+	retrievalTimeout   = 2 * time.Minute
+)
+
+// TestBedrockKnowledgeBaseRetrievalQuality asks the knowledge base the golden
+// questions and compares recall@k, MRR and citation correctness with the
+// baseline of the backend under test_rtl/rag_result/baseline. Without
+// BEDROCK_KB_ID it runs against the in-memory stand-in. Set
+// RETRIEVAL_UPDATE_BASELINE to save the results as the new baseline instead.
+func TestBedrockKnowledgeBaseRetrievalQuality(t *testing.T) {
+	data, err := os.ReadFile(goldenSetPath)
+	require.NoError(t, err)
+	golden, err := retrieval.ParseGolden(string(data))
+	require.NoError(t, err)
+	backend, name := RetrievalBackend(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), retrievalTimeout)
+	defer cancel()
+	report, err := retrieval.Evaluate(ctx, backend, name, golden)
+	require.NoError(t, err, "Retrieve should succeed for every golden question")
+	t.Logf("%s: recall@%d %.3f, MRR %.3f, citation correctness %.3f", name, report.K, report.Recall, report.MRR, report.Citation)
+
+	path := filepath.Join(baselineDir, name+".json")
+	if os.Getenv("RETRIEVAL_UPDATE_BASELINE") != "" {
+		out, err := report.JSON()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, out, 0o644))
+		t.Logf("Saved the results as the baseline %s", path)
+		return
+	}
+	data, err = os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("No baseline %s; run with RETRIEVAL_UPDATE_BASELINE=1 to save one", path)
+	}
+	require.NoError(t, err)
+	baseline, err := retrieval.ParseReport(string(data))
+	require.NoError(t, err)
+	assert.Empty(t, retrieval.Compare(baseline, report, retrievalTolerance), "Retrieval quality should not regress from %s", path)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/bedrockagent"
+	"github.com/aws/aws-sdk-go/service/bedrockagentruntime"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
@@ -141,9 +142,9 @@ func TestBedrockKnowledgeBase(t *testing.T) {
 			// For a fresh deployment, this may return empty results
 			
 			bedrockRuntimeClient := createBedrockAgentRuntimeClient(t, "us-east-1")
-			result, err := bedrockRuntimeClient.Retrieve(&bedrockagent.RetrieveInput{
+			result, err := bedrockRuntimeClient.Retrieve(&bedrockagentruntime.RetrieveInput{
 				KnowledgeBaseId: aws.String(kbID),
-				RetrievalQuery: &bedrockagent.KnowledgeBaseQuery{
+				RetrievalQuery: &bedrockagentruntime.KnowledgeBaseQuery{
 					Text: aws.String("test query"),
 				},
 			})
@@ -229,10 +230,3 @@ func createBedrockAgentClient(t *testing.T, region string) *bedrockagent.Bedrock
 	sess := createAWSSession(t, region)
 	return bedrockagent.New(sess)
 }
-
-func createBedrockAgentRuntimeClient(t *testing.T, region string) *bedrockagent.BedrockAgent {
-	// Note: In actual implementation, this would use bedrockagentruntime package
-	// For now, using the same client for simplicity
-	sess := createAWSSession(t, region)
-	return bedrockagent.New(sess)
-}
@@ -1,11 +1,21 @@
 package integration
 
 import (
+	"context"
+	"net"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/bedrockagentruntime"
 	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/dnszone"
+	"github.com/bos-ai/infrastructure/tests/internal/retrieval"
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
 )
 
 // createAWSSession creates an AWS session for the specified region
@@ -16,3 +26,101 @@ func createAWSSession(t *testing.T, region string) *session.Session {
 	require.NoError(t, err, "Failed to create AWS session")
 	return sess
 }
+
+// createBedrockAgentRuntimeClient creates a Bedrock agent runtime client,
+// which serves the knowledge base Retrieve API, for the specified region
+func createBedrockAgentRuntimeClient(t *testing.T, region string) *bedrockagentruntime.BedrockAgentRuntime {
+	sess := createAWSSession(t, region)
+	return bedrockagentruntime.New(sess)
+}
+
+// DNSResolver returns the resolver DNS tests query: the servers listed in
+// DNS_RESOLVER_ADDRS (comma-separated host[:port], e.g. the Route53 Resolver
+// inbound endpoint IPs) when set, and otherwise an in-process DNS server
+// that answers from the Terraform configuration the way the inbound
+// endpoint does for on-premises clients. It is exported for the
+// connectivity tests in package integration_test.
+func DNSResolver(t *testing.T) *net.Resolver {
+	t.Helper()
+
+	if addrs := os.Getenv("DNS_RESOLVER_ADDRS"); addrs != "" {
+		var servers []string
+		for _, a := range strings.Split(addrs, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				servers = append(servers, a)
+			}
+		}
+		return dnszone.NewResolver(servers...)
+	}
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Failed to load the Terraform configuration")
+	network, err := routing.Build(tree, routing.Options{})
+	require.NoError(t, err)
+	md := dnszone.Build(tree, network, dnszone.Options{})
+	require.Empty(t, md.Unresolved)
+	inbound := md.Inbound()
+	require.NotEmpty(t, inbound, "No inbound Route53 Resolver endpoint to answer as")
+
+	srv, err := dnszone.NewServer(md, inbound[0].VPC)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+	t.Logf("DNS_RESOLVER_ADDRS is not set; resolving through an in-process server at %s", srv.Addr())
+	return srv.Resolver()
+}
+
+// RetrievalBackend returns the backend retrieval quality tests query, and
+// its name: the Bedrock knowledge base BEDROCK_KB_ID in AWS_REGION
+// (us-east-1 by default) when set, and otherwise a retrieval.Memory index
+// of the module hierarchy in test_rtl/trinity_hierarchy.json.
+func RetrievalBackend(t *testing.T) (retrieval.Backend, string) {
+	t.Helper()
+
+	if kbID := os.Getenv("BEDROCK_KB_ID"); kbID != "" {
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &bedrockRetriever{client: createBedrockAgentRuntimeClient(t, region), kbID: kbID}, "bedrock"
+	}
+
+	data, err := os.ReadFile("../../test_rtl/trinity_hierarchy.json")
+	require.NoError(t, err)
+	docs, err := retrieval.HierarchyDocuments(string(data))
+	require.NoError(t, err)
+	t.Logf("BEDROCK_KB_ID is not set; retrieving from an in-memory index of %d hierarchy documents", len(docs))
+	return retrieval.NewMemory(docs), "memory"
+}
+
+// bedrockRetriever retrieves from a knowledge base with the Retrieve API.
+type bedrockRetriever struct {
+	client *bedrockagentruntime.BedrockAgentRuntime
+	kbID   string
+}
+
+func (b *bedrockRetriever) Retrieve(ctx context.Context, query string, k int) ([]retrieval.Result, error) {
+	out, err := b.client.RetrieveWithContext(ctx, &bedrockagentruntime.RetrieveInput{
+		KnowledgeBaseId: aws.String(b.kbID),
+		RetrievalQuery:  &bedrockagentruntime.KnowledgeBaseQuery{Text: aws.String(query)},
+		RetrievalConfiguration: &bedrockagentruntime.KnowledgeBaseRetrievalConfiguration{
+			VectorSearchConfiguration: &bedrockagentruntime.KnowledgeBaseVectorSearchConfiguration{
+				NumberOfResults: aws.Int64(int64(k)),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []retrieval.Result
+	for _, r := range out.RetrievalResults {
+		res := retrieval.Result{Score: aws.Float64Value(r.Score)}
+		if r.Content != nil {
+			res.Text = aws.StringValue(r.Content.Text)
+		}
+		if r.Location != nil && r.Location.S3Location != nil {
+			res.URI = aws.StringValue(r.Location.S3Location.Uri)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -15,6 +15,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/integration"
 )
 
 const connectivityTimeout = 10 * time.Second
@@ -116,11 +118,14 @@ func TestRoute53LLMResolves(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
 	defer cancel()
 
-	resolver := net.DefaultResolver
+	resolver := integration.DNSResolver(t)
 	ips, err := resolver.LookupHost(ctx, "llm.corp.bos-semi.com")
 	require.NoError(t, err, "DNS lookup for llm.corp.bos-semi.com failed")
 
 	assert.NotEmpty(t, ips, "llm.corp.bos-semi.com should resolve to at least one IP")
+	for _, ip := range ips {
+		assert.True(t, net.ParseIP(ip).IsPrivate(), "llm.corp.bos-semi.com should resolve to private IPs, got %s", ip)
+	}
 	t.Logf("llm.corp.bos-semi.com resolved to: %v", ips)
 }
 
@@ -130,11 +135,14 @@ func TestRoute53MCPResolves(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
 	defer cancel()
 
-	resolver := net.DefaultResolver
+	resolver := integration.DNSResolver(t)
 	ips, err := resolver.LookupHost(ctx, "mcp.corp.bos-semi.com")
 	require.NoError(t, err, "DNS lookup for mcp.corp.bos-semi.com failed")
 
 	assert.NotEmpty(t, ips, "mcp.corp.bos-semi.com should resolve to at least one IP")
+	for _, ip := range ips {
+		assert.True(t, net.ParseIP(ip).IsPrivate(), "mcp.corp.bos-semi.com should resolve to private IPs, got %s", ip)
+	}
 	t.Logf("mcp.corp.bos-semi.com resolved to: %v", ips)
 }
 
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"net"
 	"testing"
 
@@ -105,11 +106,8 @@ func TestQuickSightDNSResolution(t *testing.T) {
 	})
 
 	t.Run("DNSResolvesToPrivateIP", func(t *testing.T) {
-		ips, err := net.LookupHost(quickDNSName)
-		if err != nil {
-			t.Skipf("DNS lookup failed (VPN not connected?): %v", err)
-			return
-		}
+		ips, err := DNSResolver(t).LookupHost(context.Background(), quickDNSName)
+		require.NoError(t, err, "DNS lookup for %s failed", quickDNSName)
 		require.NotEmpty(t, ips)
 		ip := net.ParseIP(ips[0])
 		require.NotNil(t, ip)
@@ -0,0 +1,452 @@
+// Package aoss evaluates the OpenSearch Serverless policies of a Terraform
+// tree: the encryption and network security policies that select each
+// collection, and the data access policies that grant Bedrock knowledge
+// bases and ingestion Lambda functions their collection and index
+// permissions. Keys, VPC endpoints and principals in the policies are
+// resolved to the resources of the tree.
+package aoss
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Policy types.
+const (
+	Encryption = "encryption"
+	Network    = "network"
+	Data       = "data"
+)
+
+// Rule selects resources of a type by name pattern, e.g.
+// "collection/bos-ai-*" or "index/bos-ai-vectors/*". Permission is only
+// set in data access policies.
+type Rule struct {
+	ResourceType string
+	Resource     []string
+	Permission   []string
+}
+
+// Matches reports whether the rule selects a resource of a type, e.g.
+// Matches("index", "index/bos-ai-vectors/documents").
+func (r Rule) Matches(typ, resource string) bool {
+	if r.ResourceType != typ {
+		return false
+	}
+	for _, p := range r.Resource {
+		if glob(p, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// Statement is an element of a network or data access policy.
+// References to resources of the tree are canonicalized to "${<node ID>}".
+type Statement struct {
+	Rules           []Rule
+	Principal       []string
+	AllowFromPublic bool
+	SourceVPCEs     []string
+}
+
+// Policy is an OpenSearch Serverless security or access policy. An
+// encryption policy has a single statement of rules and a key.
+type Policy struct {
+	ID    string // node ID
+	Name  string
+	Type  string
+	Stack string
+
+	Rules       []Rule // encryption policies only
+	AWSOwnedKey bool
+	KmsARN      string
+
+	Statements []Statement
+}
+
+// Collection is an OpenSearch Serverless collection of the tree.
+type Collection struct {
+	ID    string // node ID
+	Name  string
+	Stack string
+}
+
+// Consumer is a knowledge base or ingestion Lambda function that reads or
+// writes an index of a collection.
+type Consumer struct {
+	ID         string // node ID
+	Needs      Needs
+	Role       string // canonical role ARN
+	Stack      string
+	Collection string // collection name
+	Index      string
+}
+
+// Needs are the permissions a consumer needs on its collection and index.
+type Needs struct {
+	Collection []string
+	Index      []string
+}
+
+// KnowledgeBaseNeeds are the permissions a Bedrock knowledge base role needs
+// to create its index and ingest and retrieve documents.
+var KnowledgeBaseNeeds = Needs{
+	Collection: []string{"aoss:CreateCollectionItems", "aoss:DescribeCollectionItems", "aoss:UpdateCollectionItems"},
+	Index:      []string{"aoss:CreateIndex", "aoss:DescribeIndex", "aoss:ReadDocument", "aoss:UpdateIndex", "aoss:WriteDocument"},
+}
+
+// WriterNeeds are the permissions a Lambda function needs to write
+// documents to an index that already exists.
+var WriterNeeds = Needs{
+	Collection: []string{"aoss:DescribeCollectionItems"},
+	Index:      []string{"aoss:DescribeIndex", "aoss:ReadDocument", "aoss:WriteDocument"},
+}
+
+// Model is every OpenSearch Serverless collection, policy, VPC endpoint and
+// consumer of a tree. Policies apply to the collections of their stack.
+type Model struct {
+	Collections []*Collection // sorted by ID
+	Policies    []*Policy     // sorted by ID
+	Consumers   []*Consumer   // sorted by ID
+	Endpoints   []string      // node IDs of the VPC endpoints, sorted
+
+	// Unknown lists attributes that are set but not statically known.
+	Unknown []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+}
+
+// Build collects the OpenSearch Serverless resources of every module in the
+// tree, and the knowledge bases and Lambda functions that use them. A Lambda
+// function is an ingestion function when its environment names an
+// OPENSEARCH_INDEX and refers to a collection. Resources with count = 0 are
+// skipped.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph()}
+	for _, m := range tree.Modules {
+		stack := stackOf(m).Name
+		for _, b := range instantiated(m.Resources("aws_opensearchserverless_collection")) {
+			c := &Collection{ID: nodeID(m, b), Stack: stack}
+			var ok bool
+			if c.Name, ok = b.String("name"); !ok {
+				md.unknown(c.ID, b, "name")
+			}
+			md.Collections = append(md.Collections, c)
+		}
+		for _, b := range instantiated(m.Resources("aws_opensearchserverless_vpc_endpoint")) {
+			md.Endpoints = append(md.Endpoints, nodeID(m, b))
+		}
+		for _, kind := range []string{"aws_opensearchserverless_security_policy", "aws_opensearchserverless_access_policy"} {
+			for _, b := range instantiated(m.Resources(kind)) {
+				md.Policies = append(md.Policies, md.policy(m, b))
+			}
+		}
+		for _, b := range instantiated(m.Resources("aws_bedrockagent_knowledge_base")) {
+			if c := md.knowledgeBase(m, b); c != nil {
+				md.Consumers = append(md.Consumers, c)
+			}
+		}
+		for _, b := range instantiated(m.Resources("aws_lambda_function")) {
+			if c := md.writer(m, b); c != nil {
+				md.Consumers = append(md.Consumers, c)
+			}
+		}
+	}
+	sort.Slice(md.Collections, func(i, j int) bool { return md.Collections[i].ID < md.Collections[j].ID })
+	sort.Slice(md.Policies, func(i, j int) bool { return md.Policies[i].ID < md.Policies[j].ID })
+	sort.Slice(md.Consumers, func(i, j int) bool { return md.Consumers[i].ID < md.Consumers[j].ID })
+	sort.Strings(md.Endpoints)
+	sort.Strings(md.Unknown)
+	return md
+}
+
+func (md *Model) policy(m *tfconfig.Module, b *tfconfig.Block) *Policy {
+	p := &Policy{ID: nodeID(m, b), Stack: stackOf(m).Name}
+	p.Name, _ = b.String("name")
+	p.Type, _ = b.String("type")
+	call, ok := b.Expr("policy").(*hclsyntax.FunctionCallExpr)
+	if !ok || call.Name != "jsonencode" || len(call.Args) != 1 {
+		md.unknown(p.ID, b, "policy")
+		return p
+	}
+	doc := m.Symbolic(call.Args[0])
+	if p.Type == Encryption {
+		obj, _ := doc.(map[string]interface{})
+		p.Rules = rules(obj["Rules"])
+		p.AWSOwnedKey, _ = obj["AWSOwnedKey"].(bool)
+		if arn, ok := obj["KmsARN"].(string); ok {
+			p.KmsARN = md.canonical(m, arn)
+		}
+		return p
+	}
+	list, _ := doc.([]interface{})
+	for i, v := range list {
+		obj, _ := v.(map[string]interface{})
+		st := Statement{Rules: rules(obj["Rules"])}
+		st.AllowFromPublic, _ = obj["AllowFromPublic"].(bool)
+		for _, s := range stringList(obj["SourceVPCEs"]) {
+			st.SourceVPCEs = append(st.SourceVPCEs, md.canonical(m, s))
+		}
+		if principals, ok := obj["Principal"].([]interface{}); ok {
+			for _, s := range stringList(principals) {
+				st.Principal = append(st.Principal, md.canonical(m, s))
+			}
+		} else if obj["Principal"] != nil {
+			// e.g. distinct([var.a, var.b]): resolve what it refers to.
+			for _, n := range md.Refs.ResolveExpr(m, element(call.Args[0], i, "Principal")) {
+				st.Principal = append(st.Principal, "${"+n.String()+"}")
+			}
+			if len(st.Principal) == 0 {
+				md.unknown(p.ID, b, fmt.Sprintf("policy[%d].Principal", i))
+			}
+		}
+		p.Statements = append(p.Statements, st)
+	}
+	return p
+}
+
+func rules(v interface{}) []Rule {
+	var out []Rule
+	list, _ := v.([]interface{})
+	for _, r := range list {
+		obj, _ := r.(map[string]interface{})
+		rule := Rule{Resource: stringList(obj["Resource"]), Permission: stringList(obj["Permission"])}
+		rule.ResourceType, _ = obj["ResourceType"].(string)
+		out = append(out, rule)
+	}
+	return out
+}
+
+func stringList(v interface{}) []string {
+	var out []string
+	switch v := v.(type) {
+	case string:
+		out = append(out, v)
+	case []interface{}:
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// element returns the expression of an attribute of the i-th object of a
+// tuple constructor, or nil.
+func element(expr hclsyntax.Expression, i int, attr string) hclsyntax.Expression {
+	tuple, ok := expr.(*hclsyntax.TupleConsExpr)
+	if !ok || i >= len(tuple.Exprs) {
+		return nil
+	}
+	obj, ok := tuple.Exprs[i].(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return nil
+	}
+	for _, item := range obj.Items {
+		if hcl.ExprAsKeyword(item.KeyExpr) == attr {
+			return item.ValueExpr
+		}
+	}
+	return nil
+}
+
+func (md *Model) knowledgeBase(m *tfconfig.Module, b *tfconfig.Block) *Consumer {
+	c := &Consumer{ID: nodeID(m, b), Needs: KnowledgeBaseNeeds, Stack: stackOf(m).Name}
+	for _, sc := range b.Nested("storage_configuration") {
+		for _, oc := range sc.Nested("opensearch_serverless_configuration") {
+			c.Collection = md.collectionName(m, oc.Expr("collection_arn"))
+			if c.Collection == "" {
+				md.unknown(c.ID, oc, "collection_arn")
+			}
+			var ok bool
+			if c.Index, ok = oc.String("vector_index_name"); !ok {
+				md.unknown(c.ID, oc, "vector_index_name")
+			}
+		}
+	}
+	if c.Collection == "" && c.Index == "" {
+		return nil
+	}
+	c.Role = md.role(m, b, "role_arn")
+	return c
+}
+
+// writer returns the function as a Consumer when its environment names an
+// index and refers to a collection.
+func (md *Model) writer(m *tfconfig.Module, b *tfconfig.Block) *Consumer {
+	c := &Consumer{ID: nodeID(m, b), Needs: WriterNeeds, Stack: stackOf(m).Name}
+	for _, env := range b.Nested("environment") {
+		vars, _ := env.Partial("variables").(map[string]interface{})
+		c.Index, _ = vars["OPENSEARCH_INDEX"].(string)
+		c.Collection = md.collectionName(m, env.Expr("variables"))
+	}
+	if c.Index == "" || c.Collection == "" {
+		return nil
+	}
+	c.Role = md.role(m, b, "role")
+	return c
+}
+
+// collectionName returns the name of the collection resource or data source
+// an expression refers to, or "".
+func (md *Model) collectionName(m *tfconfig.Module, expr hclsyntax.Expression) string {
+	for _, n := range md.Refs.ResolveExpr(m, expr) {
+		if n.Kind() == "aws_opensearchserverless_collection" {
+			name, _ := n.Block().String("name")
+			return name
+		}
+	}
+	return ""
+}
+
+func (md *Model) role(m *tfconfig.Module, b *tfconfig.Block, attr string) string {
+	for _, n := range md.Refs.ResolveExpr(m, b.Expr(attr)) {
+		if n.Kind() == "aws_iam_role" {
+			return "${" + n.String() + "}"
+		}
+	}
+	if s, ok := b.String(attr); ok {
+		return md.canonical(m, s)
+	}
+	md.unknown(nodeID(m, b), b, attr)
+	return ""
+}
+
+var (
+	reference = regexp.MustCompile(`^\$\{([^}]+)\}$`)
+	roleARN   = regexp.MustCompile(`^arn:aws:iam::\d{12}:role/(?:.*/)?([^/]+)$`)
+)
+
+// canonical returns "${<node ID>}" for a reference to a single resource of
+// the tree, or for the ARN of a role of the module's stack whose name is
+// statically known, and s otherwise.
+func (md *Model) canonical(m *tfconfig.Module, s string) string {
+	if sm := reference.FindStringSubmatch(s); sm != nil {
+		expr, diags := hclsyntax.ParseExpression([]byte(sm[1]), "", hcl.InitialPos)
+		if diags.HasErrors() {
+			return s
+		}
+		if nodes := md.Refs.ResolveExpr(m, expr); len(nodes) == 1 {
+			return "${" + nodes[0].String() + "}"
+		}
+		return s
+	}
+	if sm := roleARN.FindStringSubmatch(s); sm != nil {
+		stack := stackOf(m)
+		for _, other := range md.Tree.Modules {
+			if stackOf(other) != stack {
+				continue
+			}
+			for _, b := range other.Resources("aws_iam_role") {
+				if name, ok := b.String("name"); ok && name == sm[1] {
+					return "${" + nodeID(other, b) + "}"
+				}
+			}
+		}
+	}
+	return s
+}
+
+// Granted returns the permissions the data access policies of a stack
+// grant a principal on a resource, e.g. "index/bos-ai-vectors/documents",
+// sorted. Wildcard permissions are returned as written.
+func (md *Model) Granted(stack, principal, resource string) []string {
+	typ := resource[:strings.Index(resource, "/")]
+	seen := map[string]bool{}
+	for _, p := range md.Policies {
+		if p.Type != Data || p.Stack != stack {
+			continue
+		}
+		for _, st := range p.Statements {
+			if !contains(st.Principal, principal) {
+				continue
+			}
+			for _, r := range st.Rules {
+				if r.Matches(typ, resource) {
+					for _, perm := range r.Permission {
+						seen[perm] = true
+					}
+				}
+			}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for perm := range seen {
+		out = append(out, perm)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// glob matches a name against a pattern in which "*" matches any run of
+// characters.
+func glob(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unknown records an attribute as "<id>: <attr> at <file>:<line>", with the
+// attribute prefixed by the block type for nested blocks.
+func (md *Model) unknown(id string, b *tfconfig.Block, attr string) {
+	if b.Type != "resource" {
+		attr = b.Type + "." + attr
+	}
+	rng := b.Body.SrcRange
+	md.Unknown = append(md.Unknown, fmt.Sprintf("%s: %s at %s:%d", id, attr, filepath.Base(rng.Filename), rng.Start.Line))
+}
+
+func stackOf(m *tfconfig.Module) *tfconfig.Module {
+	for m.Parent != nil {
+		m = m.Parent
+	}
+	return m
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,286 @@
+package aoss
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleAOSS has a docs collection encrypted with the stack's key and
+// reachable through its VPC endpoint, except that the dashboard names a
+// literal endpoint, and a logs collection with an AWS owned key and no
+// network policy. The knowledge base is granted exactly what it needs; the
+// ingest function, granted by role ARN, gets aoss:* on the index and
+// nothing on the collection.
+const sampleAOSS = `
+resource "aws_kms_key" "main" {}
+
+resource "aws_iam_role" "kb" {
+  name = "kb-role"
+}
+
+resource "aws_iam_role" "ingest" {
+  name = "ingest-role"
+}
+
+resource "aws_opensearchserverless_collection" "docs" {
+  name = "docs"
+}
+
+resource "aws_opensearchserverless_collection" "logs" {
+  name = "app-logs"
+}
+
+resource "aws_opensearchserverless_collection" "old" {
+  count = 0
+  name  = "old"
+}
+
+resource "aws_opensearchserverless_vpc_endpoint" "main" {
+  name = "docs-vpce"
+}
+
+resource "aws_opensearchserverless_security_policy" "encryption" {
+  name = "docs-encryption"
+  type = "encryption"
+  policy = jsonencode({
+    Rules = [
+      {
+        ResourceType = "collection"
+        Resource     = ["collection/docs"]
+      }
+    ]
+    AWSOwnedKey = false
+    KmsARN      = aws_kms_key.main.arn
+  })
+}
+
+resource "aws_opensearchserverless_security_policy" "logs_encryption" {
+  name = "logs-encryption"
+  type = "encryption"
+  policy = jsonencode({
+    Rules = [
+      {
+        ResourceType = "collection"
+        Resource     = ["collection/app-*"]
+      }
+    ]
+    AWSOwnedKey = true
+  })
+}
+
+resource "aws_opensearchserverless_security_policy" "network" {
+  name = "docs-network"
+  type = "network"
+  policy = jsonencode([
+    {
+      Rules = [
+        {
+          ResourceType = "collection"
+          Resource     = ["collection/docs"]
+        }
+      ]
+      AllowFromPublic = false
+      SourceVPCEs     = [aws_opensearchserverless_vpc_endpoint.main.id]
+    },
+    {
+      Rules = [
+        {
+          ResourceType = "dashboard"
+          Resource     = ["collection/docs"]
+        }
+      ]
+      AllowFromPublic = false
+      SourceVPCEs     = ["vpce-0123456789abcdef0"]
+    }
+  ])
+}
+
+resource "aws_opensearchserverless_access_policy" "data" {
+  name = "docs-data"
+  type = "data"
+  policy = jsonencode([
+    {
+      Rules = [
+        {
+          ResourceType = "collection"
+          Resource     = ["collection/docs"]
+          Permission   = ["aoss:CreateCollectionItems", "aoss:DescribeCollectionItems", "aoss:UpdateCollectionItems"]
+        },
+        {
+          ResourceType = "index"
+          Resource     = ["index/docs/*"]
+          Permission   = ["aoss:CreateIndex", "aoss:DescribeIndex", "aoss:ReadDocument", "aoss:UpdateIndex", "aoss:WriteDocument"]
+        }
+      ]
+      Principal = distinct([aws_iam_role.kb.arn, aws_iam_role.kb.arn])
+    },
+    {
+      Rules = [
+        {
+          ResourceType = "index"
+          Resource     = ["index/docs/docs-*"]
+          Permission   = ["aoss:*"]
+        }
+      ]
+      Principal = ["arn:aws:iam::123456789012:role/ingest-role", "bedrock.amazonaws.com"]
+    }
+  ])
+}
+
+resource "aws_bedrockagent_knowledge_base" "docs" {
+  name     = "docs"
+  role_arn = aws_iam_role.kb.arn
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = aws_opensearchserverless_collection.docs.arn
+      vector_index_name = "docs-index"
+    }
+  }
+}
+
+resource "aws_lambda_function" "ingest" {
+  function_name = "ingest"
+  role          = aws_iam_role.ingest.arn
+  environment {
+    variables = {
+      OPENSEARCH_ENDPOINT = aws_opensearchserverless_collection.docs.collection_endpoint
+      OPENSEARCH_INDEX    = "docs-index"
+    }
+  }
+}
+
+resource "aws_lambda_function" "other" {
+  function_name = "other"
+  role          = aws_iam_role.ingest.arn
+}
+`
+
+// sampleSearch grants a role of the same name the collection permission the
+// app stack's ingest function lacks; policies only apply to their stack.
+const sampleSearch = `
+resource "aws_iam_role" "ingest" {
+  name = "ingest-role"
+}
+
+resource "aws_opensearchserverless_access_policy" "data" {
+  name = "search-data"
+  type = "data"
+  policy = jsonencode([
+    {
+      Rules = [
+        {
+          ResourceType = "collection"
+          Resource     = ["collection/*"]
+          Permission   = ["aoss:DescribeCollectionItems"]
+        }
+      ]
+      Principal = [aws_iam_role.ingest.arn]
+    }
+  ])
+}
+`
+
+const (
+	docs   = "environments/app:aws_opensearchserverless_collection.docs"
+	logs   = "environments/app:aws_opensearchserverless_collection.logs"
+	kb     = "environments/app:aws_bedrockagent_knowledge_base.docs"
+	ingest = "environments/app:aws_lambda_function.ingest"
+	data   = "environments/app:aws_opensearchserverless_access_policy.data"
+)
+
+func loadSample(t *testing.T) (*tfconfig.Tree, *Model) {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(root, "environments", "app", "main.tf"):    sampleAOSS,
+		filepath.Join(root, "environments", "search", "main.tf"): sampleSearch,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unknown)
+	require.Len(t, md.Collections, 2)
+	return tree, md
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	assert.Equal(t, []string{"environments/app:aws_opensearchserverless_vpc_endpoint.main"}, md.Endpoints)
+	require.Len(t, md.Consumers, 2)
+	assert.Equal(t, Consumer{ID: kb, Needs: KnowledgeBaseNeeds, Role: "${environments/app:aws_iam_role.kb}",
+		Stack: "environments/app", Collection: "docs", Index: "docs-index"}, *md.Consumers[0])
+	assert.Equal(t, Consumer{ID: ingest, Needs: WriterNeeds, Role: "${environments/app:aws_iam_role.ingest}",
+		Stack: "environments/app", Collection: "docs", Index: "docs-index"}, *md.Consumers[1])
+
+	require.Len(t, md.Policies, 5)
+	p := md.Policies[0]
+	require.Equal(t, data, p.ID)
+	require.Len(t, p.Statements, 2)
+	assert.Equal(t, []string{"${environments/app:aws_iam_role.kb}"}, p.Statements[0].Principal,
+		"distinct() over references should resolve to the roles it refers to")
+	assert.Equal(t, []string{"${environments/app:aws_iam_role.ingest}", "bedrock.amazonaws.com"}, p.Statements[1].Principal,
+		"a role ARN should resolve to the role of the stack with that name")
+
+	assert.Equal(t, []string{"aoss:CreateIndex", "aoss:DescribeIndex", "aoss:ReadDocument", "aoss:UpdateIndex", "aoss:WriteDocument"},
+		md.Granted("environments/app", "${environments/app:aws_iam_role.kb}", "index/docs/docs-index"))
+	assert.Empty(t, md.Granted("environments/app", "${environments/app:aws_iam_role.ingest}", "collection/docs"))
+	assert.Equal(t, []string{"aoss:DescribeCollectionItems"},
+		md.Granted("environments/search", "${environments/search:aws_iam_role.ingest}", "collection/docs"))
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	findings := md.Check()
+	var keys []string
+	for _, f := range findings {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		docs + " network",
+		logs + " encryption",
+		logs + " network",
+		ingest + " data-access",
+		ingest + " least-privilege",
+		data + " principal",
+	}, keys)
+
+	assert.Contains(t, findings, finding.Finding{Subject: docs, Check: CheckNetwork,
+		Detail: `environments/app:aws_opensearchserverless_security_policy.network statement 1 allows "vpce-0123456789abcdef0", which is not an aws_opensearchserverless_vpc_endpoint of the tree`})
+	assert.Contains(t, findings, finding.Finding{Subject: logs, Check: CheckEncryption,
+		Detail: `environments/app:aws_opensearchserverless_security_policy.logs_encryption encrypts it with an AWS owned key, not the project CMK`})
+	assert.Contains(t, findings, finding.Finding{Subject: logs, Check: CheckNetwork,
+		Detail: `no network policy of environments/app selects collection/app-logs`})
+	assert.Contains(t, findings, finding.Finding{Subject: ingest, Check: CheckDataAccess,
+		Detail: `role ${environments/app:aws_iam_role.ingest} is not granted aoss:DescribeCollectionItems on collection/docs`})
+	assert.Contains(t, findings, finding.Finding{Subject: ingest, Check: CheckLeastPrivilege,
+		Detail: `role ${environments/app:aws_iam_role.ingest} is granted aoss:* on index/docs/docs-index, beyond what it needs`})
+	assert.Contains(t, findings, finding.Finding{Subject: data, Check: CheckPrincipal,
+		Detail: `statement 1 grants "bedrock.amazonaws.com", which is not an IAM or SAML principal`})
+}
+
+func TestRuleMatches(t *testing.T) {
+	t.Parallel()
+
+	r := Rule{ResourceType: "index", Resource: []string{"index/bos-ai-*/*"}}
+	assert.True(t, r.Matches("index", "index/bos-ai-vectors/documents"))
+	assert.False(t, r.Matches("index", "index/docs/documents"))
+	assert.False(t, r.Matches("collection", "index/bos-ai-vectors/documents"))
+	assert.True(t, Rule{ResourceType: "collection", Resource: []string{"collection/docs"}}.Matches("collection", "collection/docs"))
+	assert.False(t, Rule{ResourceType: "collection", Resource: []string{"collection/docs"}}.Matches("collection", "collection/docs2"))
+}
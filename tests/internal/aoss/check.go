@@ -0,0 +1,192 @@
+package aoss
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+)
+
+// Checks reported by Check.
+const (
+	CheckEncryption     = "encryption"
+	CheckNetwork        = "network"
+	CheckDataAccess     = "data-access"
+	CheckLeastPrivilege = "least-privilege"
+	CheckPrincipal      = "principal"
+	CheckUnknownValue   = "unknown-value"
+)
+
+// iamPrincipal matches the principals a data access policy accepts besides
+// roles of the tree: IAM users and roles, and SAML users and groups.
+var iamPrincipal = regexp.MustCompile(`^(arn:aws:iam::\d{12}:(role|user)/.+|saml/\d{12}/.+)$`)
+
+// Check evaluates the policies of every stack:
+//
+//   - Every collection is selected by an encryption policy, and every
+//     encryption policy that selects it uses a KMS key of the tree rather
+//     than an AWS owned key.
+//   - Every collection is selected by a network policy, and no network
+//     policy allows public access to the collection or its dashboard:
+//     every statement that selects it names VPC endpoints of the tree in
+//     SourceVPCEs.
+//   - Every consumer's role is granted exactly the collection and index
+//     permissions of its Needs on its collection and index.
+//   - Every principal of a data access policy is an IAM user or role, or a
+//     SAML user or group.
+//
+// Values that are not statically known are reported as CheckUnknownValue.
+func (md *Model) Check() []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+	for _, u := range md.Unknown {
+		i := strings.Index(u, ": ")
+		add(u[:i], CheckUnknownValue, "%s is not statically known", u[i+2:])
+	}
+
+	for _, c := range md.Collections {
+		if c.Name == "" {
+			continue
+		}
+		resource := "collection/" + c.Name
+		encrypted, networked := false, false
+		for _, p := range md.Policies {
+			if p.Stack != c.Stack {
+				continue
+			}
+			switch p.Type {
+			case Encryption:
+				if !selects(p.Rules, "collection", resource) {
+					continue
+				}
+				encrypted = true
+				switch {
+				case p.AWSOwnedKey:
+					add(c.ID, CheckEncryption, "%s encrypts it with an AWS owned key, not the project CMK", p.ID)
+				case !md.isKey(p.KmsARN):
+					add(c.ID, CheckEncryption, "%s encrypts it with %q, which is not a KMS key of the tree", p.ID, p.KmsARN)
+				}
+			case Network:
+				for i, st := range p.Statements {
+					var types []string
+					for _, typ := range []string{"collection", "dashboard"} {
+						if selects(st.Rules, typ, resource) {
+							types = append(types, typ)
+						}
+					}
+					if len(types) == 0 {
+						continue
+					}
+					networked = true
+					what := strings.Join(types, " and ")
+					if st.AllowFromPublic {
+						add(c.ID, CheckNetwork, "%s statement %d allows public access to the %s", p.ID, i, what)
+						continue
+					}
+					if len(st.SourceVPCEs) == 0 {
+						add(c.ID, CheckNetwork, "%s statement %d allows no VPC endpoint to the %s", p.ID, i, what)
+					}
+					for _, vpce := range st.SourceVPCEs {
+						if !md.isEndpoint(vpce) {
+							add(c.ID, CheckNetwork, "%s statement %d allows %q, which is not an aws_opensearchserverless_vpc_endpoint of the tree", p.ID, i, vpce)
+						}
+					}
+				}
+			}
+		}
+		if !encrypted {
+			add(c.ID, CheckEncryption, "no encryption policy of %s selects %s", c.Stack, resource)
+		}
+		if !networked {
+			add(c.ID, CheckNetwork, "no network policy of %s selects %s", c.Stack, resource)
+		}
+	}
+
+	for _, c := range md.Consumers {
+		if c.Role == "" || c.Collection == "" || c.Index == "" {
+			continue
+		}
+		for _, want := range []struct {
+			resource string
+			needs    []string
+		}{
+			{"collection/" + c.Collection, c.Needs.Collection},
+			{"index/" + c.Collection + "/" + c.Index, c.Needs.Index},
+		} {
+			granted := md.Granted(c.Stack, c.Role, want.resource)
+			var missing, excess []string
+			for _, need := range want.needs {
+				if !allows(granted, need) {
+					missing = append(missing, need)
+				}
+			}
+			for _, g := range granted {
+				if !contains(want.needs, g) {
+					excess = append(excess, g)
+				}
+			}
+			if len(missing) > 0 {
+				add(c.ID, CheckDataAccess, "role %s is not granted %s on %s", c.Role, strings.Join(missing, ", "), want.resource)
+			}
+			if len(excess) > 0 {
+				add(c.ID, CheckLeastPrivilege, "role %s is granted %s on %s, beyond what it needs", c.Role, strings.Join(excess, ", "), want.resource)
+			}
+		}
+	}
+
+	for _, p := range md.Policies {
+		if p.Type != Data {
+			continue
+		}
+		for i, st := range p.Statements {
+			for _, principal := range st.Principal {
+				if !strings.HasPrefix(principal, "${") && !iamPrincipal.MatchString(principal) {
+					add(p.ID, CheckPrincipal, "statement %d grants %q, which is not an IAM or SAML principal", i, principal)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// selects reports whether any rule selects a resource of a type.
+func selects(rules []Rule, typ, resource string) bool {
+	for _, r := range rules {
+		if r.Matches(typ, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether granted permissions, which may contain wildcards,
+// allow a permission.
+func allows(granted []string, perm string) bool {
+	for _, g := range granted {
+		if glob(g, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+func (md *Model) isKey(s string) bool {
+	return md.resolvesTo(s, "aws_kms_key")
+}
+
+func (md *Model) isEndpoint(s string) bool {
+	return contains(md.Endpoints, strings.TrimSuffix(strings.TrimPrefix(s, "${"), "}"))
+}
+
+// resolvesTo reports whether a canonical value is a resource of a type.
+func (md *Model) resolvesTo(s, kind string) bool {
+	id := strings.TrimSuffix(strings.TrimPrefix(s, "${"), "}")
+	if id == s {
+		return false
+	}
+	i := strings.LastIndex(id, ":")
+	return i >= 0 && strings.HasPrefix(id[i+1:], kind+".")
+}
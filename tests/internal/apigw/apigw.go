@@ -0,0 +1,454 @@
+// Package apigw rebuilds the REST APIs of a Terraform tree from their
+// aws_api_gateway_* resources: the resource tree with its paths, the methods
+// on each resource with their authorization, and the integration behind each
+// method. It checks the resource policy of private APIs and exports the
+// routes as an OpenAPI 3 document.
+package apigw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// API is an aws_api_gateway_rest_api with everything attached to it.
+type API struct {
+	ID    string // node ID of the rest API, e.g. "environments/app-layer/bedrock-rag:aws_api_gateway_rest_api.private_rag"
+	Name  string
+	Types []string // endpoint_configuration types, e.g. ["PRIVATE"]
+
+	// EndpointIDs are the vpc_endpoint_ids of the endpoint configuration.
+	// IDs that are not known statically are kept as the reference they come
+	// from, e.g. "${local.frontend_execute_api_endpoint_id}", as are the
+	// values in Policy, so that the two can be compared.
+	EndpointIDs []string
+	// Endpoints are the aws_vpc_endpoint nodes EndpointIDs resolve to.
+	Endpoints []tfconfig.Node
+
+	Policy *iampolicy.Document // nil when the API has no resource policy
+	// policyJSON is the decoded inline policy, for the OpenAPI export.
+	policyJSON map[string]interface{}
+
+	Root      *Resource
+	Resources []*Resource // every resource but the root, in path order
+	Methods   []*Method   // in route order
+
+	Deployments []*Deployment
+	Stages      []*Stage
+
+	Block *tfconfig.Block
+}
+
+// StageNames returns the names of the API's stages.
+func (api *API) StageNames() []string {
+	var out []string
+	for _, s := range api.Stages {
+		out = append(out, s.Name)
+	}
+	return out
+}
+
+// Unauthenticated returns the methods anyone who can reach the API may call.
+func (api *API) Unauthenticated() []*Method {
+	var out []*Method
+	for _, m := range api.Methods {
+		if !m.Authenticated() {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Private reports whether the API has a PRIVATE endpoint type.
+func (api *API) Private() bool {
+	for _, t := range api.Types {
+		if t == "PRIVATE" {
+			return true
+		}
+	}
+	return false
+}
+
+// Resource is an aws_api_gateway_resource, or the root resource of an API.
+type Resource struct {
+	Path    string // "/" for the root, e.g. "/rag/documents" otherwise
+	Parent  *Resource
+	Methods []*Method
+	Block   *tfconfig.Block // nil for the root
+}
+
+// Method is an aws_api_gateway_method.
+type Method struct {
+	Resource       *Resource
+	HTTPMethod     string // e.g. "GET", "ANY"
+	Authorization  string // "NONE", "AWS_IAM", "CUSTOM" or "COGNITO_USER_POOLS"
+	Authorizer     string // address of the aws_api_gateway_authorizer, or ""
+	APIKeyRequired bool
+	// Parameters are the request_parameters, e.g.
+	// "method.request.path.proxy" to whether it is required.
+	Parameters  map[string]bool
+	Integration *Integration // nil when the method has none
+	Block       *tfconfig.Block
+}
+
+// Route returns the method and path, e.g. "POST /rag/query".
+func (m *Method) Route() string {
+	return m.HTTPMethod + " " + m.Resource.Path
+}
+
+// Authenticated reports whether callers must present credentials: an IAM
+// signature, an authorizer token or an API key.
+func (m *Method) Authenticated() bool {
+	return m.Authorization != "NONE" || m.APIKeyRequired
+}
+
+// Integration is an aws_api_gateway_integration.
+type Integration struct {
+	Type           string // "AWS_PROXY", "AWS", "HTTP_PROXY", "HTTP" or "MOCK"
+	HTTPMethod     string // integration_http_method
+	URI            string // unknown references kept symbolic, as in API.EndpointIDs
+	ConnectionType string // "INTERNET" or "VPC_LINK"
+	// Targets are the resources the URI refers to, e.g.
+	// "aws_lambda_function.document_processor" or "aws_instance.mcp_server".
+	Targets []string
+	// Backends are the resources the URI resolves to through locals,
+	// variables and remote state.
+	Backends []tfconfig.Node
+	// Parameters are the request_parameters, e.g.
+	// "integration.request.path.proxy" to "method.request.path.proxy".
+	Parameters map[string]string
+	Block      *tfconfig.Block
+}
+
+// Inventory holds the REST APIs of a tree.
+type Inventory struct {
+	APIs        []*API
+	Permissions []*Permission
+
+	// Unresolved lists resources, methods and integrations that could not be
+	// attached to an API, a parent resource or a method.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+}
+
+// Build collects the REST APIs of every module in the tree.
+func Build(tree *tfconfig.Tree) *Inventory {
+	inv := &Inventory{Tree: tree, Refs: tree.RefGraph()}
+	for _, m := range tree.Modules {
+		inv.collect(m)
+	}
+	inv.collectThrottles()
+	for _, m := range tree.Modules {
+		inv.collectPermissions(m)
+	}
+	sort.Slice(inv.APIs, func(i, j int) bool { return inv.APIs[i].ID < inv.APIs[j].ID })
+	sort.Strings(inv.Unresolved)
+	return inv
+}
+
+// API returns the API with the given resource name, e.g. "private_rag", or
+// nil. Names are assumed to be unique across the tree.
+func (inv *Inventory) API(name string) *API {
+	for _, api := range inv.APIs {
+		if api.Block.Name() == name {
+			return api
+		}
+	}
+	return nil
+}
+
+// builder attaches the resources, methods and integrations of a module to
+// the APIs declared in it.
+type builder struct {
+	inv       *Inventory
+	m         *tfconfig.Module
+	apis      map[string]*API // by address
+	resources map[string]*Resource
+	methods   map[string]*Method
+}
+
+func (inv *Inventory) collect(m *tfconfig.Module) {
+	bd := &builder{inv: inv, m: m, apis: map[string]*API{}, resources: map[string]*Resource{}, methods: map[string]*Method{}}
+	for _, b := range instantiated(m.Resources("aws_api_gateway_rest_api")) {
+		api := inv.newAPI(m, b)
+		bd.apis[b.Address()] = api
+		inv.APIs = append(inv.APIs, api)
+	}
+	if len(bd.apis) == 0 {
+		return
+	}
+	bd.collectResources()
+	bd.collectMethods()
+	bd.collectIntegrations()
+	bd.collectDeployments()
+	bd.collectStages()
+}
+
+func (bd *builder) fail(b *tfconfig.Block, what string) {
+	bd.inv.Unresolved = append(bd.inv.Unresolved, nodeID(bd.m, b)+": "+what)
+}
+
+func (bd *builder) api(b *tfconfig.Block) *API {
+	for _, ref := range b.Refs("rest_api_id") {
+		if api := bd.apis[ref]; api != nil {
+			return api
+		}
+	}
+	bd.fail(b, "rest_api_id")
+	return nil
+}
+
+// resource returns the resource a method or integration is attached to.
+// The root_resource_id of the API refers to its root.
+func (bd *builder) resource(api *API, b *tfconfig.Block) *Resource {
+	for _, ref := range b.Refs("resource_id") {
+		if bd.apis[ref] == api {
+			return api.Root
+		}
+		if r := bd.resources[ref]; r != nil && r.Path != "" {
+			return r
+		}
+	}
+	bd.fail(b, "resource_id")
+	return nil
+}
+
+func (bd *builder) collectResources() {
+	// Resources may be declared in any order and across files, so parents
+	// are linked once every resource is known.
+	parents := map[*Resource]string{}
+	for _, b := range instantiated(bd.m.Resources("aws_api_gateway_resource")) {
+		api := bd.api(b)
+		if api == nil {
+			continue
+		}
+		r := &Resource{Block: b}
+		bd.resources[b.Address()] = r
+		for _, ref := range b.Refs("parent_id") {
+			if bd.apis[ref] == api {
+				r.Parent = api.Root
+			} else if strings.HasPrefix(ref, "aws_api_gateway_resource.") {
+				parents[r] = ref
+			}
+		}
+		api.Resources = append(api.Resources, r)
+	}
+	for r, ref := range parents {
+		r.Parent = bd.resources[ref]
+	}
+	for _, api := range bd.apis {
+		var linked []*Resource
+		for _, r := range api.Resources {
+			if err := r.resolvePath(); err != nil {
+				bd.fail(r.Block, err.Error())
+				continue
+			}
+			linked = append(linked, r)
+		}
+		api.Resources = linked
+		sort.Slice(api.Resources, func(i, j int) bool { return api.Resources[i].Path < api.Resources[j].Path })
+	}
+}
+
+func (bd *builder) collectMethods() {
+	for _, b := range instantiated(bd.m.Resources("aws_api_gateway_method")) {
+		api := bd.api(b)
+		if api == nil {
+			continue
+		}
+		r := bd.resource(api, b)
+		if r == nil {
+			continue
+		}
+		me := &Method{Resource: r, Block: b, Authorization: "NONE"}
+		me.HTTPMethod, _ = b.String("http_method")
+		if me.HTTPMethod == "" {
+			bd.fail(b, "http_method")
+			continue
+		}
+		if s, ok := b.String("authorization"); ok {
+			me.Authorization = s
+		}
+		for _, ref := range b.Refs("authorizer_id") {
+			if strings.HasPrefix(ref, "aws_api_gateway_authorizer.") {
+				me.Authorizer = ref
+			}
+		}
+		me.APIKeyRequired, _ = b.Bool("api_key_required")
+		if params, ok := b.Partial("request_parameters").(map[string]interface{}); ok {
+			me.Parameters = map[string]bool{}
+			for k, v := range params {
+				me.Parameters[k] = v == true
+			}
+		}
+		bd.methods[b.Address()] = me
+		r.Methods = append(r.Methods, me)
+		api.Methods = append(api.Methods, me)
+	}
+	for _, api := range bd.apis {
+		sort.Slice(api.Methods, func(i, j int) bool { return api.Methods[i].Route() < api.Methods[j].Route() })
+	}
+}
+
+func (bd *builder) collectIntegrations() {
+	for _, b := range instantiated(bd.m.Resources("aws_api_gateway_integration")) {
+		me := bd.method(b)
+		if me == nil {
+			continue
+		}
+		in := &Integration{Block: b, ConnectionType: "INTERNET"}
+		in.Type, _ = b.String("type")
+		in.HTTPMethod, _ = b.String("integration_http_method")
+		if s, ok := bd.m.Symbolic(b.Expr("uri")).(string); ok {
+			in.URI = s
+		}
+		if s, ok := b.String("connection_type"); ok {
+			in.ConnectionType = s
+		}
+		in.Targets = b.Refs("uri")
+		in.Backends = bd.inv.Refs.ResolveExpr(bd.m, b.Expr("uri"))
+		if params, ok := b.Partial("request_parameters").(map[string]interface{}); ok {
+			in.Parameters = map[string]string{}
+			for k, v := range params {
+				in.Parameters[k] = fmt.Sprint(v)
+			}
+		}
+		me.Integration = in
+	}
+}
+
+// method finds the method an integration belongs to: the method its
+// http_method refers to, or the method with that verb on its resource.
+func (bd *builder) method(b *tfconfig.Block) *Method {
+	for _, ref := range b.Refs("http_method") {
+		if me := bd.methods[ref]; me != nil {
+			return me
+		}
+	}
+	api := bd.api(b)
+	if api == nil {
+		return nil
+	}
+	r := bd.resource(api, b)
+	if r == nil {
+		return nil
+	}
+	verb, _ := b.String("http_method")
+	for _, me := range r.Methods {
+		if me.HTTPMethod == verb {
+			return me
+		}
+	}
+	bd.fail(b, "http_method")
+	return nil
+}
+
+func (inv *Inventory) newAPI(m *tfconfig.Module, b *tfconfig.Block) *API {
+	api := &API{ID: nodeID(m, b), Block: b, Root: &Resource{Path: "/"}}
+	api.Name, _ = m.PartialString(b.Expr("name"))
+	for _, ec := range b.Nested("endpoint_configuration") {
+		api.Types, _ = ec.Strings("types")
+		for _, id := range asList(m.Symbolic(ec.Expr("vpc_endpoint_ids"))) {
+			if s, ok := id.(string); ok {
+				api.EndpointIDs = append(api.EndpointIDs, s)
+			}
+		}
+		for _, n := range inv.Refs.ResolveExpr(m, ec.Expr("vpc_endpoint_ids")) {
+			if n.Kind() == "aws_vpc_endpoint" {
+				api.Endpoints = append(api.Endpoints, n)
+			}
+		}
+	}
+	if b.Has("policy") {
+		doc, raw, err := policy(inv.Refs, m, api.ID, b.Expr("policy"))
+		api.policyJSON = raw
+		if err != nil {
+			inv.Unresolved = append(inv.Unresolved, err.Error())
+		}
+		api.Policy = doc
+	}
+	return api
+}
+
+// policy parses a resource policy with unknown references kept symbolic.
+// Policies that are not an inline jsonencode are resolved as iampolicy does
+// and have no decoded form.
+func policy(g *tfconfig.Graph, m *tfconfig.Module, source string, expr hclsyntax.Expression) (*iampolicy.Document, map[string]interface{}, error) {
+	call, ok := expr.(*hclsyntax.FunctionCallExpr)
+	if !ok || call.Name != "jsonencode" || len(call.Args) != 1 {
+		doc, err := iampolicy.FromExpr(g, m, source, expr)
+		return doc, nil, err
+	}
+	raw, _ := m.Symbolic(call.Args[0]).(map[string]interface{})
+	doc, err := iampolicy.Parse(source, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Condition keys are case-insensitive: aws:sourceVpce is KeySourceVpce.
+	// Endpoint IDs are compared as Invoke passes them.
+	for i := range doc.Statements {
+		for j, c := range doc.Statements[i].Conditions {
+			if !strings.EqualFold(c.Key, KeySourceVpce) {
+				continue
+			}
+			c.Key = KeySourceVpce
+			c.Values = append([]string(nil), c.Values...)
+			for k, v := range c.Values {
+				c.Values[k] = endpointValue(v)
+			}
+			doc.Statements[i].Conditions[j] = c
+		}
+	}
+	return doc, raw, nil
+}
+
+// resolvePath sets the path of a resource from its ancestors.
+func (r *Resource) resolvePath() error {
+	var parts []string
+	seen := map[*Resource]bool{}
+	for cur := r; cur.Block != nil; cur = cur.Parent {
+		if seen[cur] {
+			return fmt.Errorf("parent_id cycle")
+		}
+		seen[cur] = true
+		part, ok := cur.Block.String("path_part")
+		if !ok {
+			return fmt.Errorf("path_part of %s is not statically known", cur.Block.Address())
+		}
+		parts = append([]string{part}, parts...)
+		if cur.Parent == nil {
+			return fmt.Errorf("parent_id of %s", cur.Block.Address())
+		}
+	}
+	r.Path = "/" + strings.Join(parts, "/")
+	return nil
+}
+
+func asList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return nil
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,404 @@
+package apigw
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleAPIs has a private API restricted to its execute-api endpoint with
+// a Lambda proxy route, an HTTP proxy route, an IAM route and a route with
+// an authorizer and API key, and a second private API whose policy lets in
+// any endpoint. Child resources are declared before their parents. The
+// private API's deployment misses the HTTP integration in its triggers and
+// its HTTP route reaches an instance over the internet; the leaky API's
+// stage has neither logging, tracing nor a throttle that covers keyless
+// calls.
+const sampleAPIs = `
+locals {
+  endpoint_id = aws_vpc_endpoint.execute_api.id
+}
+
+resource "aws_vpc_endpoint" "execute_api" {
+  service_name      = "com.amazonaws.ap-northeast-2.execute-api"
+  vpc_endpoint_type = "Interface"
+}
+
+resource "aws_api_gateway_rest_api" "private" {
+  name = "private-api"
+
+  endpoint_configuration {
+    types            = ["PRIVATE"]
+    vpc_endpoint_ids = [local.endpoint_id]
+  }
+
+  policy = jsonencode({
+    Statement = [{
+      Effect    = "Allow"
+      Principal = "*"
+      Action    = "execute-api:Invoke"
+      Resource  = "execute-api:/*"
+      Condition = {
+        StringEquals = { "aws:sourceVpce" = local.endpoint_id }
+      }
+    }]
+  })
+}
+
+resource "aws_api_gateway_resource" "query" {
+  rest_api_id = aws_api_gateway_rest_api.private.id
+  parent_id   = aws_api_gateway_resource.api.id
+  path_part   = "query"
+}
+
+resource "aws_api_gateway_resource" "api" {
+  rest_api_id = aws_api_gateway_rest_api.private.id
+  parent_id   = aws_api_gateway_rest_api.private.root_resource_id
+  path_part   = "api"
+}
+
+resource "aws_api_gateway_resource" "proxy" {
+  rest_api_id = aws_api_gateway_rest_api.private.id
+  parent_id   = aws_api_gateway_resource.api.id
+  path_part   = "{proxy+}"
+}
+
+resource "aws_api_gateway_method" "root_get" {
+  rest_api_id   = aws_api_gateway_rest_api.private.id
+  resource_id   = aws_api_gateway_rest_api.private.root_resource_id
+  http_method   = "GET"
+  authorization = "AWS_IAM"
+}
+
+resource "aws_api_gateway_method" "query_post" {
+  rest_api_id   = aws_api_gateway_rest_api.private.id
+  resource_id   = aws_api_gateway_resource.query.id
+  http_method   = "POST"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_integration" "query_lambda" {
+  rest_api_id             = aws_api_gateway_rest_api.private.id
+  resource_id             = aws_api_gateway_resource.query.id
+  http_method             = aws_api_gateway_method.query_post.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = aws_lambda_function.handler.invoke_arn
+}
+
+resource "aws_api_gateway_method" "proxy_any" {
+  rest_api_id      = aws_api_gateway_rest_api.private.id
+  resource_id      = aws_api_gateway_resource.proxy.id
+  http_method      = "ANY"
+  authorization    = "CUSTOM"
+  authorizer_id    = aws_api_gateway_authorizer.token.id
+  api_key_required = true
+
+  request_parameters = {
+    "method.request.path.proxy"          = true
+    "method.request.querystring.version" = false
+  }
+}
+
+resource "aws_api_gateway_integration" "proxy_http" {
+  rest_api_id             = aws_api_gateway_rest_api.private.id
+  resource_id             = aws_api_gateway_resource.proxy.id
+  http_method             = "ANY"
+  integration_http_method = "ANY"
+  type                    = "HTTP_PROXY"
+  uri                     = "http://${aws_instance.backend.private_ip}:3000/{proxy}"
+
+  request_parameters = {
+    "integration.request.path.proxy" = "method.request.path.proxy"
+  }
+}
+
+resource "aws_lambda_function" "handler" {
+  function_name = "handler"
+}
+
+resource "aws_lambda_permission" "api_gateway" {
+  function_name = aws_lambda_function.handler.function_name
+  principal     = "apigateway.amazonaws.com"
+  action        = "lambda:InvokeFunction"
+  source_arn    = "${aws_api_gateway_rest_api.private.execution_arn}/*/POST/api/query"
+}
+
+resource "aws_instance" "backend" {
+  instance_type = "t3.small"
+}
+
+resource "aws_cloudwatch_log_group" "access" {
+  name = "/aws/apigateway/private"
+}
+
+resource "aws_api_gateway_deployment" "main" {
+  rest_api_id = aws_api_gateway_rest_api.private.id
+
+  triggers = {
+    redeployment = sha1(jsonencode([
+      aws_api_gateway_method.root_get,
+      aws_api_gateway_method.query_post,
+      aws_api_gateway_integration.query_lambda,
+      aws_api_gateway_method.proxy_any,
+    ]))
+  }
+}
+
+resource "aws_api_gateway_stage" "prod" {
+  rest_api_id          = aws_api_gateway_rest_api.private.id
+  deployment_id        = aws_api_gateway_deployment.main.id
+  stage_name           = "prod"
+  xray_tracing_enabled = true
+
+  access_log_settings {
+    destination_arn = aws_cloudwatch_log_group.access.arn
+    format          = "$context.requestId"
+  }
+}
+
+resource "aws_api_gateway_method_settings" "all" {
+  rest_api_id = aws_api_gateway_rest_api.private.id
+  stage_name  = aws_api_gateway_stage.prod.stage_name
+  method_path = "*/*"
+
+  settings {
+    throttling_rate_limit  = 100
+    throttling_burst_limit = 50
+  }
+}
+
+resource "aws_api_gateway_rest_api" "leaky" {
+  name = "leaky-api"
+
+  endpoint_configuration {
+    types            = ["PRIVATE"]
+    vpc_endpoint_ids = [local.endpoint_id]
+  }
+
+  policy = jsonencode({
+    Statement = [{
+      Effect    = "Allow"
+      Principal = "*"
+      Action    = "execute-api:Invoke"
+      Resource  = "execute-api:/*"
+      Condition = {
+        StringLike = { "aws:SourceVpce" = "vpce-*" }
+      }
+    }]
+  })
+}
+
+resource "aws_api_gateway_resource" "leaky_health" {
+  rest_api_id = aws_api_gateway_rest_api.leaky.id
+  parent_id   = aws_api_gateway_rest_api.leaky.root_resource_id
+  path_part   = "health"
+}
+
+resource "aws_api_gateway_method" "leaky_health_get" {
+  rest_api_id   = aws_api_gateway_rest_api.leaky.id
+  resource_id   = aws_api_gateway_resource.leaky_health.id
+  http_method   = "GET"
+  authorization = "NONE"
+}
+
+resource "aws_api_gateway_deployment" "leaky" {
+  rest_api_id = aws_api_gateway_rest_api.leaky.id
+
+  triggers = {
+    redeployment = sha1(jsonencode([aws_api_gateway_method.leaky_health_get]))
+  }
+}
+
+resource "aws_api_gateway_stage" "dev" {
+  rest_api_id   = aws_api_gateway_rest_api.leaky.id
+  deployment_id = aws_api_gateway_deployment.leaky.id
+  stage_name    = "dev"
+}
+
+resource "aws_api_gateway_usage_plan" "partners" {
+  name = "partners"
+
+  api_stages {
+    api_id = aws_api_gateway_rest_api.leaky.id
+    stage  = aws_api_gateway_stage.dev.stage_name
+  }
+
+  throttle_settings {
+    rate_limit  = 10
+    burst_limit = 5
+  }
+}
+`
+
+func loadSample(t *testing.T) *Inventory {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleAPIs), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	inv := Build(tree)
+	require.Empty(t, inv.Unresolved)
+	require.Len(t, inv.APIs, 2)
+	return inv
+}
+
+func routes(methods []*Method) []string {
+	var out []string
+	for _, m := range methods {
+		out = append(out, m.Route())
+	}
+	return out
+}
+
+func TestBuild_RebuildsResourceTree(t *testing.T) {
+	t.Parallel()
+
+	api := loadSample(t).API("private")
+	require.NotNil(t, api)
+	assert.True(t, api.Private())
+	assert.Equal(t, []string{"${local.endpoint_id}"}, api.EndpointIDs)
+	require.Len(t, api.Endpoints, 1)
+	assert.Equal(t, "aws_vpc_endpoint.execute_api", api.Endpoints[0].Addr)
+
+	var paths []string
+	for _, r := range api.Resources {
+		paths = append(paths, r.Path)
+	}
+	assert.Equal(t, []string{"/api", "/api/query", "/api/{proxy+}"}, paths)
+	assert.Equal(t, []string{"ANY /api/{proxy+}", "GET /", "POST /api/query"}, routes(api.Methods))
+	assert.Equal(t, []string{"prod"}, api.StageNames())
+
+	proxy := api.Methods[0]
+	assert.Equal(t, "aws_api_gateway_authorizer.token", proxy.Authorizer)
+	assert.True(t, proxy.APIKeyRequired)
+	assert.Equal(t, map[string]bool{"method.request.path.proxy": true, "method.request.querystring.version": false}, proxy.Parameters)
+	require.NotNil(t, proxy.Integration, "integrations match methods by verb when http_method is a literal")
+	assert.Equal(t, "http://${aws_instance.backend.private_ip}:3000/{proxy}", proxy.Integration.URI)
+	assert.Equal(t, "INTERNET", proxy.Integration.ConnectionType)
+
+	query := api.Methods[2]
+	require.NotNil(t, query.Integration)
+	assert.Equal(t, "AWS_PROXY", query.Integration.Type)
+	assert.Equal(t, []string{"aws_lambda_function.handler"}, query.Integration.Targets)
+
+	assert.Nil(t, api.Methods[1].Integration)
+	assert.Equal(t, []string{"POST /api/query"}, routes(api.Unauthenticated()))
+}
+
+func TestCheckPolicy(t *testing.T) {
+	t.Parallel()
+
+	inv := loadSample(t)
+	assert.Empty(t, inv.API("private").CheckPolicy())
+
+	// vpce-* admits the configured endpoint, and every other one.
+	leaky := inv.API("leaky")
+	assert.Equal(t, []string{
+		"environments/app:aws_api_gateway_rest_api.leaky: GET /health on stage dev is allowed through endpoints other than ${local.endpoint_id}",
+	}, leaky.CheckPolicy())
+
+	leaky.Policy = nil
+	assert.Equal(t, []string{
+		"environments/app:aws_api_gateway_rest_api.leaky: private API has no resource policy, so every call is denied",
+	}, leaky.CheckPolicy())
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	doc := loadSample(t).API("private").OpenAPI()
+	require.NoError(t, doc.Validate())
+
+	assert.Equal(t, []string{"/", "/api", "/api/query", "/api/{proxy+}"}, sortedKeys(doc.Paths))
+	assert.Empty(t, doc.Paths["/api"])
+
+	any := doc.Paths["/api/{proxy+}"][AnyMethod]
+	require.NotNil(t, any)
+	assert.Equal(t, "proxy_any", any.OperationID)
+	assert.Equal(t, []Parameter{
+		{Name: "proxy", In: "path", Required: true, Schema: map[string]string{"type": "string"}},
+		{Name: "version", In: "query", Schema: map[string]string{"type": "string"}},
+	}, any.Parameters)
+	assert.Equal(t, []map[string][]string{{SchemeAPIKey: {}}, {"token": {}}}, any.Security)
+	assert.Equal(t, "http_proxy", any.Integration.Type)
+	assert.Equal(t, "custom", doc.Components.SecuritySchemes["token"].AuthType)
+	assert.Equal(t, "awsSigv4", doc.Components.SecuritySchemes[SchemeSigV4].AuthType)
+	assert.Equal(t, []string{"${local.endpoint_id}"}, doc.Endpoint.VPCEndpointIDs)
+
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"aws:sourceVpce":"${local.endpoint_id}"`, "the policy is exported as written")
+
+	doc.Paths["/api/{id}"] = PathItem{"get": {OperationID: "proxy_any", Responses: map[string]Response{"200": {}}}}
+	assert.Error(t, doc.Validate(), "path parameter id is not declared and the operation ID is taken")
+}
+
+func keys(findings []finding.Finding) []string {
+	var out []string
+	for _, f := range findings {
+		out = append(out, f.Key())
+	}
+	return out
+}
+
+func TestConsistency(t *testing.T) {
+	t.Parallel()
+
+	inv := loadSample(t)
+	private := inv.API("private")
+	require.Len(t, private.Deployments, 1)
+	assert.Equal(t, []string{"aws_api_gateway_integration.proxy_http"}, private.Deployments[0].Untracked(private))
+	prod := private.Stages[0]
+	assert.Same(t, private.Deployments[0], prod.Deployment)
+	assert.Equal(t, "${aws_cloudwatch_log_group.access.arn}", prod.AccessLogs)
+	assert.Equal(t, []Throttle{{Source: "environments/app:aws_api_gateway_method_settings.all", RateLimit: 100, Burst: 50}}, prod.Throttles)
+	require.Len(t, inv.Permissions, 1)
+	assert.Equal(t, "${aws_api_gateway_rest_api.private.execution_arn}/*/POST/api/query", inv.Permissions[0].SourceARN)
+
+	assert.Equal(t, []string{
+		"environments/app:aws_api_gateway_rest_api.leaky aws_api_gateway_stage.dev access-logs",
+		"environments/app:aws_api_gateway_rest_api.leaky aws_api_gateway_stage.dev throttling",
+		"environments/app:aws_api_gateway_rest_api.leaky aws_api_gateway_stage.dev xray",
+		"environments/app:aws_api_gateway_rest_api.private ANY /api/{proxy+} connection-type",
+		"environments/app:aws_api_gateway_rest_api.private ANY /api/{proxy+} http-target",
+		"environments/app:aws_api_gateway_rest_api.private aws_api_gateway_deployment.main redeploy-triggers",
+	}, keys(inv.Consistency(Options{})))
+
+	// A known backend is allowed, but still needs a VPC link.
+	opts := Options{PrivateTargets: []string{"environments/app:aws_instance.backend"}}
+	assert.NotContains(t, keys(inv.Consistency(opts)), "environments/app:aws_api_gateway_rest_api.private ANY /api/{proxy+} http-target")
+	proxy := private.Methods[0].Integration
+	proxy.ConnectionType = "VPC_LINK"
+	assert.Contains(t, inv.Consistency(opts), finding.Finding{
+		Scope:   "environments/app:aws_api_gateway_rest_api.private",
+		Subject: "ANY /api/{proxy+}",
+		Check:   CheckConnectionType,
+		Detail:  "connection_id does not refer to an aws_api_gateway_vpc_link",
+	})
+
+	// The permission only covers POST, so widening the method breaks it.
+	private.Methods[2].HTTPMethod = "ANY"
+	assert.Contains(t, inv.Consistency(opts), finding.Finding{
+		Scope:   "environments/app:aws_api_gateway_rest_api.private",
+		Subject: "ANY /api/query",
+		Check:   CheckLambdaPermission,
+		Detail: "no aws_lambda_permission lets apigateway.amazonaws.com invoke environments/app:aws_lambda_function.handler " +
+			"for ${aws_api_gateway_rest_api.private.execution_arn}/prod/DELETE/api/query",
+	})
+
+	// Usage plans throttle the stage once every method needs a key.
+	leaky := inv.API("leaky")
+	leaky.Methods[0].APIKeyRequired = true
+	assert.NotContains(t, keys(inv.Consistency(opts)), "environments/app:aws_api_gateway_rest_api.leaky aws_api_gateway_stage.dev throttling")
+}
@@ -0,0 +1,230 @@
+package apigw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Permission is an aws_lambda_permission.
+type Permission struct {
+	ID        string
+	Functions []tfconfig.Node // the aws_lambda_function nodes function_name resolves to
+	Principal string
+	// SourceARN is the source_arn with unknown references kept symbolic,
+	// e.g. "${aws_api_gateway_rest_api.private_rag.execution_arn}/*/*", or
+	// "" when the permission is not limited to a source.
+	SourceARN string
+	Block     *tfconfig.Block
+}
+
+func (inv *Inventory) collectPermissions(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_permission")) {
+		p := &Permission{ID: nodeID(m, b), Block: b}
+		p.Principal, _ = b.String("principal")
+		if s, ok := m.Symbolic(b.Expr("source_arn")).(string); ok {
+			p.SourceARN = s
+		}
+		for _, n := range inv.Refs.ResolveExpr(m, b.Expr("function_name")) {
+			if n.Kind() == "aws_lambda_function" {
+				p.Functions = append(p.Functions, n)
+			}
+		}
+		inv.Permissions = append(inv.Permissions, p)
+	}
+}
+
+// Covers reports whether the permission lets API Gateway invoke fn for a
+// call with the given source ARN. Symbolic ARNs only compare within one
+// module, so a permission in another module than the API must not be
+// limited to a source.
+func (p *Permission) Covers(fn tfconfig.Node, api *API, sourceARN string) bool {
+	if p.Principal != "apigateway.amazonaws.com" {
+		return false
+	}
+	found := false
+	for _, n := range p.Functions {
+		found = found || n == fn
+	}
+	switch {
+	case !found:
+		return false
+	case p.SourceARN == "":
+		return true
+	case p.Block.Module() != api.Block.Module():
+		return false
+	}
+	return iampolicy.Wildcard(p.SourceARN, sourceARN)
+}
+
+// SourceARN returns the ARN API Gateway invokes integrations with for a call
+// to the method on a stage, with the execution ARN of the API symbolic,
+// e.g. "${aws_api_gateway_rest_api.private_rag.execution_arn}/prod/POST/rag/query".
+func (api *API) SourceARN(stage, verb, path string) string {
+	return "${" + api.Block.Address() + ".execution_arn}/" + stage + "/" + verb + path
+}
+
+// Checks reported by Consistency.
+const (
+	CheckLambdaPermission = "lambda-permission"
+	CheckHTTPTarget       = "http-target"
+	CheckConnectionType   = "connection-type"
+	CheckRedeployTriggers = "redeploy-triggers"
+	CheckAccessLogs       = "access-logs"
+	CheckXRay             = "xray"
+	CheckThrottling       = "throttling"
+)
+
+// Options configures Consistency.
+type Options struct {
+	// PrivateTargets are the node IDs of the resources HTTP integrations may
+	// target, such as the nginx instance. Internal load balancers are always
+	// allowed.
+	PrivateTargets []string
+}
+
+// Consistency ties every API to what it is wired to:
+//
+//   - Lambda integrations need an aws_lambda_permission for API Gateway
+//     whose source_arn covers the method on every stage.
+//   - HTTP integrations must target a known private endpoint, and reach a
+//     private address through a VPC link.
+//   - Every deployment's triggers must refer to every method and
+//     integration, or changes to them are planned but never deployed.
+//   - Every stage needs access logging, X-Ray tracing and a stage-wide
+//     throttle.
+func (inv *Inventory) Consistency(opts Options) []finding.Finding {
+	allowed := map[string]bool{}
+	for _, id := range opts.PrivateTargets {
+		allowed[id] = true
+	}
+	var out []finding.Finding
+	for _, api := range inv.APIs {
+		add := func(subject, check, format string, args ...interface{}) {
+			out = append(out, finding.Finding{Scope: api.ID, Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+		}
+		for _, m := range api.Methods {
+			in := m.Integration
+			if in == nil {
+				continue
+			}
+			switch in.Type {
+			case "AWS", "AWS_PROXY":
+				for _, fn := range in.Backends {
+					if fn.Kind() != "aws_lambda_function" {
+						continue
+					}
+					if arn := inv.uncovered(api, m, fn); arn != "" {
+						add(m.Route(), CheckLambdaPermission, "no aws_lambda_permission lets apigateway.amazonaws.com invoke %s for %s", fn, arn)
+					}
+				}
+			case "HTTP", "HTTP_PROXY":
+				inv.checkHTTP(m, allowed, add)
+			}
+		}
+		for _, d := range api.Deployments {
+			if missing := d.Untracked(api); len(missing) > 0 {
+				add(d.Block.Address(), CheckRedeployTriggers, "triggers do not refer to %d methods and integrations: %s",
+					len(missing), strings.Join(missing, ", "))
+			}
+		}
+		for _, st := range api.Stages {
+			subject := st.Block.Address()
+			if st.AccessLogs == "" {
+				add(subject, CheckAccessLogs, "no access_log_settings")
+			}
+			if !st.XRay {
+				add(subject, CheckXRay, "xray_tracing_enabled is not set")
+			}
+			if !st.throttled(api) {
+				add(subject, CheckThrottling, `no "*/*" aws_api_gateway_method_settings throttle the stage`)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope < out[j].Scope
+		}
+		return out[i].Key() < out[j].Key()
+	})
+	return out
+}
+
+// uncovered returns the first source ARN of a method that no permission
+// covers for fn, or "".
+func (inv *Inventory) uncovered(api *API, m *Method, fn tfconfig.Node) string {
+	stages := api.StageNames()
+	if len(stages) == 0 {
+		stages = []string{tfconfig.Unknown}
+	}
+	for _, stage := range stages {
+		for _, verb := range m.Verbs() {
+			arn := api.SourceARN(stage, verb, m.Resource.Path)
+			covered := false
+			for _, p := range inv.Permissions {
+				covered = covered || p.Covers(fn, api, arn)
+			}
+			if !covered {
+				return arn
+			}
+		}
+	}
+	return ""
+}
+
+func (inv *Inventory) checkHTTP(m *Method, allowed map[string]bool, add func(subject, check, format string, args ...interface{})) {
+	in := m.Integration
+	if len(in.Backends) == 0 {
+		add(m.Route(), CheckHTTPTarget, "URI %s does not refer to a resource in this configuration", in.URI)
+	}
+	private := false
+	for _, n := range in.Backends {
+		internalLB := isLoadBalancer(n) && internal(n)
+		if !allowed[n.String()] && !internalLB {
+			add(m.Route(), CheckHTTPTarget, "URI %s targets %s, which is not a known private endpoint", in.URI, n)
+		}
+		private = private || internalLB ||
+			n.Kind() == "aws_instance" && (strings.Contains(in.URI, n.Addr+".private_ip}") || strings.Contains(in.URI, n.Addr+".private_dns}"))
+	}
+	switch {
+	case in.ConnectionType == "VPC_LINK":
+		link := false
+		for _, n := range inv.Refs.ResolveExpr(in.Block.Module(), in.Block.Expr("connection_id")) {
+			link = link || n.Kind() == "aws_api_gateway_vpc_link"
+		}
+		if !link {
+			add(m.Route(), CheckConnectionType, "connection_id does not refer to an aws_api_gateway_vpc_link")
+		}
+	case private:
+		add(m.Route(), CheckConnectionType, "URI %s is a private address, which API Gateway cannot reach over connection_type %s; use a VPC link to an NLB",
+			in.URI, in.ConnectionType)
+	}
+}
+
+func isLoadBalancer(n tfconfig.Node) bool {
+	return n.Kind() == "aws_lb" || n.Kind() == "aws_alb"
+}
+
+func internal(n tfconfig.Node) bool {
+	v, _ := n.Block().Bool("internal")
+	return v
+}
+
+// throttled reports whether a throttle applies to every method of the stage.
+// Usage plans only do when every method requires an API key.
+func (st *Stage) throttled(api *API) bool {
+	keysOnly := true
+	for _, m := range api.Methods {
+		keysOnly = keysOnly && m.APIKeyRequired
+	}
+	for _, t := range st.Throttles {
+		if !t.APIKeysOnly || keysOnly {
+			return true
+		}
+	}
+	return false
+}
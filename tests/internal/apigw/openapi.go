@@ -0,0 +1,276 @@
+package apigw
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// OpenAPI is an OpenAPI 3.0 document with the API Gateway extensions an
+// export from the console carries.
+type OpenAPI struct {
+	OpenAPI    string                 `json:"openapi"`
+	Info       Info                   `json:"info"`
+	Paths      map[string]PathItem    `json:"paths"`
+	Components Components             `json:"components"`
+	Endpoint   *EndpointExtension     `json:"x-amazon-apigateway-endpoint-configuration,omitempty"`
+	Policy     map[string]interface{} `json:"x-amazon-apigateway-policy,omitempty"`
+}
+
+// Info is the info object of the document.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lower-case HTTP methods, or
+// "x-amazon-apigateway-any-method" for ANY, to operations.
+type PathItem map[string]*Operation
+
+// Operation is a method of a resource.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Integration *IntegrationExtension `json:"x-amazon-apigateway-integration,omitempty"`
+}
+
+// Parameter is a path, query string or header parameter.
+type Parameter struct {
+	Name     string            `json:"name"`
+	In       string            `json:"in"` // "path", "query" or "header"
+	Required bool              `json:"required"`
+	Schema   map[string]string `json:"schema"`
+}
+
+// Response is a response of an operation.
+type Response struct {
+	Description string `json:"description"`
+}
+
+// Components holds the security schemes operations refer to.
+type Components struct {
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes an API key, IAM signature or authorizer.
+type SecurityScheme struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	AuthType string `json:"x-amazon-apigateway-authtype,omitempty"`
+}
+
+// EndpointExtension lists the VPC endpoints of a private API.
+type EndpointExtension struct {
+	VPCEndpointIDs []string `json:"vpcEndpointIds"`
+}
+
+// IntegrationExtension is the integration behind an operation.
+type IntegrationExtension struct {
+	Type              string            `json:"type"` // lower case, e.g. "aws_proxy"
+	HTTPMethod        string            `json:"httpMethod,omitempty"`
+	URI               string            `json:"uri,omitempty"`
+	ConnectionType    string            `json:"connectionType,omitempty"`
+	RequestParameters map[string]string `json:"requestParameters,omitempty"`
+}
+
+// AnyMethod is the path item key API Gateway uses for ANY methods.
+const AnyMethod = "x-amazon-apigateway-any-method"
+
+// Security scheme names used for methods that need an API key or an IAM
+// signature. Authorizers are named after their resource.
+const (
+	SchemeAPIKey = "api_key"
+	SchemeSigV4  = "sigv4"
+)
+
+// OpenAPI exports the API. Every resource is a path, with its methods as
+// operations named after their Terraform resources; resources without
+// methods are empty path items so that the tree is complete.
+func (api *API) OpenAPI() *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: "3.0.1",
+		Info:    Info{Title: api.Name, Version: "1.0"},
+		Paths:   map[string]PathItem{},
+	}
+	if len(api.EndpointIDs) > 0 {
+		doc.Endpoint = &EndpointExtension{VPCEndpointIDs: api.EndpointIDs}
+	}
+	doc.Policy = api.policyJSON
+	schemes := map[string]SecurityScheme{}
+	for _, r := range append([]*Resource{api.Root}, api.Resources...) {
+		item := PathItem{}
+		for _, m := range r.Methods {
+			op := &Operation{
+				OperationID: m.Block.Name(),
+				Parameters:  parameters(m),
+				Responses:   map[string]Response{"200": {Description: "200 response"}},
+			}
+			for name, scheme := range security(m) {
+				schemes[name] = scheme
+				op.Security = append(op.Security, map[string][]string{name: {}})
+			}
+			sort.Slice(op.Security, func(i, j int) bool { return firstKey(op.Security[i]) < firstKey(op.Security[j]) })
+			if in := m.Integration; in != nil {
+				op.Integration = &IntegrationExtension{
+					Type:              strings.ToLower(in.Type),
+					HTTPMethod:        in.HTTPMethod,
+					URI:               in.URI,
+					ConnectionType:    in.ConnectionType,
+					RequestParameters: in.Parameters,
+				}
+			}
+			key := strings.ToLower(m.HTTPMethod)
+			if m.HTTPMethod == "ANY" {
+				key = AnyMethod
+			}
+			item[key] = op
+		}
+		if len(r.Methods) > 0 || r != api.Root {
+			doc.Paths[r.Path] = item
+		}
+	}
+	if len(schemes) > 0 {
+		doc.Components.SecuritySchemes = schemes
+	}
+	return doc
+}
+
+// security returns the schemes a method requires.
+func security(m *Method) map[string]SecurityScheme {
+	out := map[string]SecurityScheme{}
+	if m.APIKeyRequired {
+		out[SchemeAPIKey] = SecurityScheme{Type: "apiKey", Name: "x-api-key", In: "header"}
+	}
+	switch m.Authorization {
+	case "AWS_IAM":
+		out[SchemeSigV4] = SecurityScheme{Type: "apiKey", Name: "Authorization", In: "header", AuthType: "awsSigv4"}
+	case "CUSTOM", "COGNITO_USER_POOLS":
+		name := strings.TrimPrefix(m.Authorizer, "aws_api_gateway_authorizer.")
+		if name == "" {
+			name = strings.ToLower(m.Authorization)
+		}
+		out[name] = SecurityScheme{Type: "apiKey", Name: "Authorization", In: "header", AuthType: strings.ToLower(m.Authorization)}
+	}
+	return out
+}
+
+var pathParam = regexp.MustCompile(`\{([^{}+]+)\+?\}`)
+
+// parameters returns the path parameters of a method's resource and the
+// query string and header parameters it declares.
+func parameters(m *Method) []Parameter {
+	var out []Parameter
+	seen := map[string]bool{}
+	for _, match := range pathParam.FindAllStringSubmatch(m.Resource.Path, -1) {
+		seen["path."+match[1]] = true
+		out = append(out, Parameter{Name: match[1], In: "path", Required: true, Schema: map[string]string{"type": "string"}})
+	}
+	for _, k := range sortedKeys(m.Parameters) {
+		rest := strings.TrimPrefix(k, "method.request.")
+		var in string
+		switch {
+		case strings.HasPrefix(rest, "path."):
+			in = "path"
+		case strings.HasPrefix(rest, "querystring."):
+			in = "query"
+		case strings.HasPrefix(rest, "header."):
+			in = "header"
+		default:
+			continue
+		}
+		name := rest[strings.Index(rest, ".")+1:]
+		if seen[in+"."+name] {
+			continue
+		}
+		seen[in+"."+name] = true
+		out = append(out, Parameter{Name: name, In: in, Required: m.Parameters[k] || in == "path", Schema: map[string]string{"type": "string"}})
+	}
+	return out
+}
+
+// Validate checks the parts of the OpenAPI 3.0 specification an export can
+// get wrong: paths are absolute and unique after template normalization,
+// every path template variable is declared as a required path parameter of
+// each operation, operation IDs are unique, every operation has a response,
+// and security requirements name defined schemes.
+func (d *OpenAPI) Validate() error {
+	if !strings.HasPrefix(d.OpenAPI, "3.0.") {
+		return fmt.Errorf("openapi version %q is not 3.0.x", d.OpenAPI)
+	}
+	if d.Info.Title == "" || d.Info.Version == "" {
+		return fmt.Errorf("info.title and info.version are required")
+	}
+	ops := map[string]string{}
+	templates := map[string]string{}
+	for _, path := range sortedKeys(d.Paths) {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q is not absolute", path)
+		}
+		norm := pathParam.ReplaceAllString(path, "{}")
+		if other, dup := templates[norm]; dup {
+			return fmt.Errorf("paths %q and %q are equivalent", other, path)
+		}
+		templates[norm] = path
+		for _, method := range sortedKeys(d.Paths[path]) {
+			op := d.Paths[path][method]
+			where := method + " " + path
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace", AnyMethod:
+			default:
+				return fmt.Errorf("%s: %q is not an HTTP method", where, method)
+			}
+			if op.OperationID == "" {
+				return fmt.Errorf("%s: operationId is empty", where)
+			}
+			if other, dup := ops[op.OperationID]; dup {
+				return fmt.Errorf("%s: operationId %q is also used by %s", where, op.OperationID, other)
+			}
+			ops[op.OperationID] = where
+			if len(op.Responses) == 0 {
+				return fmt.Errorf("%s: no responses", where)
+			}
+			declared := map[string]bool{}
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					if !p.Required {
+						return fmt.Errorf("%s: path parameter %s must be required", where, p.Name)
+					}
+					declared[p.Name] = true
+				}
+			}
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] {
+					return fmt.Errorf("%s: path parameter %s is not declared", where, m[1])
+				}
+			}
+			for _, req := range op.Security {
+				for name := range req {
+					if _, ok := d.Components.SecuritySchemes[name]; !ok {
+						return fmt.Errorf("%s: security scheme %s is not defined", where, name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func firstKey(m map[string][]string) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package apigw
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// KeySourceVpce is the condition key carrying the VPC endpoint a call to a
+// private API came through.
+const KeySourceVpce = "aws:SourceVpce"
+
+// OtherEndpoint is the endpoint ID CheckPolicy calls through to stand for
+// any endpoint the API is not configured with, such as one in another
+// account's VPC.
+const OtherEndpoint = "vpce-0ffffffffffffffff"
+
+// Verbs returns the HTTP methods a method answers: every method for ANY.
+func (m *Method) Verbs() []string {
+	if m.HTTPMethod == "ANY" {
+		return []string{"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT"}
+	}
+	return []string{m.HTTPMethod}
+}
+
+// PolicyResource returns the resource a call to the method on a stage is
+// authorized against, in the account-relative form resource policies use,
+// e.g. "execute-api:/prod/POST/rag/query".
+func PolicyResource(stage, verb, path string) string {
+	return "execute-api:/" + stage + "/" + verb + path
+}
+
+// Invoke evaluates the resource policy for an anonymous call to a resource
+// (see PolicyResource) through the VPC endpoint with the given ID, which
+// may be symbolic as in EndpointIDs. It returns iampolicy.None when the API
+// has no resource policy.
+func (api *API) Invoke(resource, endpointID string) string {
+	if api.Policy == nil {
+		return iampolicy.None
+	}
+	return api.Policy.Evaluate(iampolicy.Request{
+		Principal: "*",
+		Action:    "execute-api:Invoke",
+		Resource:  resource,
+		Context:   map[string]string{KeySourceVpce: endpointValue(endpointID)},
+	})
+}
+
+// CheckPolicy checks that the resource policy of a private API lets every
+// route be called through each of its configured VPC endpoints and through
+// no other endpoint, on every stage. An API without stages is checked on a
+// stage named tfconfig.Unknown, which only matches policies that do not
+// name a stage. Other APIs are not checked.
+func (api *API) CheckPolicy() []string {
+	if !api.Private() {
+		return nil
+	}
+	if api.Policy == nil {
+		return []string{api.ID + ": private API has no resource policy, so every call is denied"}
+	}
+	stages := api.StageNames()
+	if len(stages) == 0 {
+		stages = []string{tfconfig.Unknown}
+	}
+	var out []string
+	for _, m := range api.Methods {
+		for _, stage := range stages {
+			for _, id := range api.EndpointIDs {
+				for _, verb := range m.Verbs() {
+					if effect := api.Invoke(PolicyResource(stage, verb, m.Resource.Path), id); effect != iampolicy.Allow {
+						out = append(out, fmt.Sprintf("%s: %s on stage %s is %s through configured endpoint %s",
+							api.ID, m.Route(), stage, describe(effect), id))
+						break
+					}
+				}
+			}
+			for _, verb := range m.Verbs() {
+				if api.Invoke(PolicyResource(stage, verb, m.Resource.Path), OtherEndpoint) == iampolicy.Allow {
+					out = append(out, fmt.Sprintf("%s: %s on stage %s is allowed through endpoints other than %s",
+						api.ID, m.Route(), stage, strings.Join(api.EndpointIDs, ", ")))
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// endpointValue returns the value of aws:SourceVpce for a call through an
+// endpoint. A symbolic ID becomes a made-up endpoint ID derived from the
+// reference, so that it equals the policy values naming the same reference
+// and matches patterns such as "vpce-*".
+func endpointValue(id string) string {
+	if !strings.HasPrefix(id, "${") || strings.Contains(id, tfconfig.Unknown) {
+		return id
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return fmt.Sprintf("vpce-%017x", h.Sum64())
+}
+
+func describe(effect string) string {
+	if effect == iampolicy.Deny {
+		return "explicitly denied"
+	}
+	return "not allowed"
+}
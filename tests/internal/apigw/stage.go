@@ -0,0 +1,191 @@
+package apigw
+
+import (
+	"sort"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Deployment is an aws_api_gateway_deployment.
+type Deployment struct {
+	// Tracked are the addresses the redeployment triggers refer to, e.g.
+	// "aws_api_gateway_method.query_post". A change to anything else does
+	// not create a new deployment.
+	Tracked map[string]bool
+	Block   *tfconfig.Block
+}
+
+// Untracked returns the addresses of the API's methods and integrations
+// that the deployment's triggers do not refer to, in route order.
+func (d *Deployment) Untracked(api *API) []string {
+	var out []string
+	for _, m := range api.Methods {
+		if addr := m.Block.Address(); !d.Tracked[addr] {
+			out = append(out, addr)
+		}
+		if m.Integration != nil {
+			if addr := m.Integration.Block.Address(); !d.Tracked[addr] {
+				out = append(out, addr)
+			}
+		}
+	}
+	return out
+}
+
+// Stage is an aws_api_gateway_stage.
+type Stage struct {
+	Name       string      // stage_name, or tfconfig.Unknown
+	Deployment *Deployment // nil when deployment_id does not resolve
+	// AccessLogs is the destination_arn of access_log_settings, with
+	// unknown references kept symbolic, or "" when access logging is off.
+	AccessLogs string
+	XRay       bool
+	// Throttles are the method settings and usage plans that throttle
+	// every method of the stage.
+	Throttles []Throttle
+	Block     *tfconfig.Block
+}
+
+// Throttle is a stage-wide rate limit.
+type Throttle struct {
+	Source    string // node ID of the aws_api_gateway_method_settings or aws_api_gateway_usage_plan
+	RateLimit float64
+	Burst     int
+	// APIKeysOnly is set for usage plans, which only throttle requests
+	// carrying one of the plan's API keys.
+	APIKeysOnly bool
+}
+
+func (bd *builder) collectDeployments() {
+	for _, b := range instantiated(bd.m.Resources("aws_api_gateway_deployment")) {
+		api := bd.api(b)
+		if api == nil {
+			continue
+		}
+		d := &Deployment{Tracked: map[string]bool{}, Block: b}
+		for _, ref := range b.Refs("triggers") {
+			d.Tracked[ref] = true
+		}
+		api.Deployments = append(api.Deployments, d)
+	}
+	for _, api := range bd.apis {
+		sort.Slice(api.Deployments, func(i, j int) bool {
+			return api.Deployments[i].Block.Address() < api.Deployments[j].Block.Address()
+		})
+	}
+}
+
+func (bd *builder) collectStages() {
+	for _, b := range instantiated(bd.m.Resources("aws_api_gateway_stage")) {
+		api := bd.api(b)
+		if api == nil {
+			continue
+		}
+		st := &Stage{Name: tfconfig.Unknown, Block: b}
+		if s, ok := bd.m.PartialString(b.Expr("stage_name")); ok {
+			st.Name = s
+		}
+		for _, ref := range b.Refs("deployment_id") {
+			for _, d := range api.Deployments {
+				if d.Block.Address() == ref {
+					st.Deployment = d
+				}
+			}
+		}
+		if st.Deployment == nil {
+			bd.fail(b, "deployment_id")
+		}
+		for _, logs := range b.Nested("access_log_settings") {
+			if s, ok := bd.m.Symbolic(logs.Expr("destination_arn")).(string); ok {
+				st.AccessLogs = s
+			}
+		}
+		st.XRay, _ = b.Bool("xray_tracing_enabled")
+		api.Stages = append(api.Stages, st)
+	}
+	for _, api := range bd.apis {
+		sort.Slice(api.Stages, func(i, j int) bool { return api.Stages[i].Name < api.Stages[j].Name })
+	}
+}
+
+// collectThrottles attaches "*/*" method settings and usage plan
+// throttle_settings to the stages they throttle. Both may live in another stack than the API, reaching it
+// through remote state, so they are matched by reference resolution.
+func (inv *Inventory) collectThrottles() {
+	stageOf := func(m *tfconfig.Module, b *tfconfig.Block, apiAttr, stageAttr string) *Stage {
+		var api *API
+		for _, n := range inv.Refs.ResolveExpr(m, b.Expr(apiAttr)) {
+			if a := inv.byNode(n); a != nil {
+				api = a
+			}
+		}
+		if api == nil {
+			return nil
+		}
+		for _, n := range inv.Refs.ResolveExpr(m, b.Expr(stageAttr)) {
+			for _, st := range api.Stages {
+				if n.Block() == st.Block {
+					return st
+				}
+			}
+		}
+		if name, ok := b.String(stageAttr); ok {
+			for _, st := range api.Stages {
+				if st.Name == name {
+					return st
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, m := range inv.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_api_gateway_method_settings")) {
+			if path, _ := b.String("method_path"); path != "*/*" {
+				continue
+			}
+			st := stageOf(m, b, "rest_api_id", "stage_name")
+			if st == nil {
+				continue
+			}
+			for _, s := range b.Nested("settings") {
+				if t, ok := throttle(nodeID(m, b), s, "throttling_rate_limit", "throttling_burst_limit"); ok {
+					st.Throttles = append(st.Throttles, t)
+				}
+			}
+		}
+		for _, b := range instantiated(m.Resources("aws_api_gateway_usage_plan")) {
+			for _, as := range b.Nested("api_stages") {
+				st := stageOf(m, as, "api_id", "stage")
+				if st == nil {
+					continue
+				}
+				for _, s := range b.Nested("throttle_settings") {
+					if t, ok := throttle(nodeID(m, b), s, "rate_limit", "burst_limit"); ok {
+						t.APIKeysOnly = true
+						st.Throttles = append(st.Throttles, t)
+					}
+				}
+			}
+		}
+	}
+}
+
+// throttle reads a rate and burst limit. Both must be set and positive.
+func throttle(source string, b *tfconfig.Block, rateAttr, burstAttr string) (Throttle, bool) {
+	t := Throttle{Source: source}
+	rate, ok := tfconfig.ToGo(b.Value(rateAttr)).(float64)
+	burst, hasBurst := b.Int(burstAttr)
+	t.RateLimit, t.Burst = rate, burst
+	return t, ok && hasBurst && rate > 0 && burst > 0
+}
+
+// byNode returns the API declared by a node, or nil.
+func (inv *Inventory) byNode(n tfconfig.Node) *API {
+	for _, api := range inv.APIs {
+		if api.ID == n.String() {
+			return api
+		}
+	}
+	return nil
+}
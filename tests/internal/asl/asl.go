@@ -0,0 +1,411 @@
+// Package asl reads the Amazon States Language definitions of the
+// aws_sfn_state_machine resources in a Terraform tree, validates them, runs
+// them in a local simulator with stubbed task results and injected failures,
+// and checks their roles, logging and alarms against the service calls they
+// make.
+package asl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// StateMachine is a parsed definition, or a Parallel branch or Map
+// iterator within one.
+type StateMachine struct {
+	Comment        string
+	StartAt        string
+	States         map[string]*State
+	TimeoutSeconds int
+
+	// problems are fields of the wrong type, reported by Validate.
+	problems []Problem
+}
+
+// State is a state of a state machine. Paths are "$" when absent and ""
+// when null.
+type State struct {
+	Name    string
+	Type    string // Task, Pass, Choice, Wait, Succeed, Fail, Parallel or Map
+	Comment string
+	Next    string
+	End     bool
+
+	InputPath  string
+	OutputPath string
+	ResultPath string
+
+	// Parameters and ResultSelector are payload templates: keys ending in
+	// ".$" take their value from a path or an intrinsic function.
+	Parameters     interface{}
+	ResultSelector interface{}
+
+	Resource string      // Task
+	Result   interface{} // Pass
+	Retry    []Retrier   // Task, Parallel and Map
+	Catch    []Catcher   // Task, Parallel and Map
+
+	Choices []Choice // Choice
+	Default string   // Choice
+
+	Seconds float64 // Wait
+
+	Error string // Fail
+	Cause string // Fail
+
+	Branches  []*StateMachine // Parallel
+	Iterator  *StateMachine   // Map, from Iterator or ItemProcessor
+	ItemsPath string          // Map
+
+	hasResult bool
+	has       map[string]bool // fields present in the definition
+}
+
+// Retrier is an element of Retry.
+type Retrier struct {
+	ErrorEquals     []string
+	IntervalSeconds float64
+	MaxAttempts     int
+	BackoffRate     float64
+}
+
+// Catcher is an element of Catch.
+type Catcher struct {
+	ErrorEquals []string
+	Next        string
+	ResultPath  string
+}
+
+// Choice is a choice rule with its Next state. Rule is the rule as written,
+// e.g. {"Variable": "$.status", "StringEquals": "done"}.
+type Choice struct {
+	Rule map[string]interface{}
+	Next string
+}
+
+// Parse reads a decoded definition, as returned by encoding/json or by
+// tfconfig.Module.Symbolic for a jsonencode argument.
+func Parse(v interface{}) (*StateMachine, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("definition: not an object")
+	}
+	sm := &StateMachine{States: map[string]*State{}}
+	sm.parse("", obj)
+	return sm, nil
+}
+
+// ParseJSON parses a definition in its JSON form.
+func ParseJSON(data []byte) (*StateMachine, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("definition: %w", err)
+	}
+	return Parse(v)
+}
+
+func (sm *StateMachine) parse(prefix string, obj map[string]interface{}) {
+	f := fields{sm: sm, state: prefix}
+	sm.Comment = f.str(obj, "Comment")
+	sm.StartAt = f.str(obj, "StartAt")
+	sm.TimeoutSeconds = int(f.num(obj, "TimeoutSeconds", 0))
+	states, ok := obj["States"].(map[string]interface{})
+	if !ok {
+		sm.problems = append(sm.problems, Problem{State: strings.TrimSuffix(prefix, "."), Field: "States", Detail: "must be an object"})
+		return
+	}
+	for name, v := range states {
+		so, ok := v.(map[string]interface{})
+		if !ok {
+			sm.problems = append(sm.problems, Problem{State: prefix + name, Detail: "must be an object"})
+			continue
+		}
+		sm.States[name] = sm.parseState(prefix, name, so)
+	}
+}
+
+func (sm *StateMachine) parseState(prefix, name string, obj map[string]interface{}) *State {
+	f := fields{sm: sm, state: prefix + name}
+	st := &State{Name: name, has: map[string]bool{}}
+	for k := range obj {
+		st.has[k] = true
+	}
+	st.Type = f.str(obj, "Type")
+	st.Comment = f.str(obj, "Comment")
+	st.Next = f.str(obj, "Next")
+	st.End, _ = obj["End"].(bool)
+	st.InputPath = f.path(obj, "InputPath")
+	st.OutputPath = f.path(obj, "OutputPath")
+	st.ResultPath = f.path(obj, "ResultPath")
+	st.Parameters = obj["Parameters"]
+	st.ResultSelector = obj["ResultSelector"]
+	st.Resource = f.str(obj, "Resource")
+	st.Result, st.hasResult = obj["Result"]
+	st.Default = f.str(obj, "Default")
+	st.Seconds = f.num(obj, "Seconds", 0)
+	st.Error = f.str(obj, "Error")
+	st.Cause = f.str(obj, "Cause")
+	st.ItemsPath = f.path(obj, "ItemsPath")
+
+	for i, r := range f.list(obj, "Retry") {
+		ro, _ := r.(map[string]interface{})
+		rf := fields{sm: sm, state: fmt.Sprintf("%s.Retry[%d]", f.state, i)}
+		st.Retry = append(st.Retry, Retrier{
+			ErrorEquals:     rf.strs(ro, "ErrorEquals"),
+			IntervalSeconds: rf.num(ro, "IntervalSeconds", 1),
+			MaxAttempts:     int(rf.num(ro, "MaxAttempts", 3)),
+			BackoffRate:     rf.num(ro, "BackoffRate", 2),
+		})
+	}
+	for i, c := range f.list(obj, "Catch") {
+		co, _ := c.(map[string]interface{})
+		cf := fields{sm: sm, state: fmt.Sprintf("%s.Catch[%d]", f.state, i)}
+		st.Catch = append(st.Catch, Catcher{
+			ErrorEquals: cf.strs(co, "ErrorEquals"),
+			Next:        cf.str(co, "Next"),
+			ResultPath:  cf.path(co, "ResultPath"),
+		})
+	}
+	for _, c := range f.list(obj, "Choices") {
+		co, _ := c.(map[string]interface{})
+		next, _ := co["Next"].(string)
+		rule := map[string]interface{}{}
+		for k, v := range co {
+			if k != "Next" {
+				rule[k] = v
+			}
+		}
+		st.Choices = append(st.Choices, Choice{Rule: rule, Next: next})
+	}
+	for i, b := range f.list(obj, "Branches") {
+		bo, _ := b.(map[string]interface{})
+		branch := &StateMachine{States: map[string]*State{}}
+		branch.parse(fmt.Sprintf("%s.Branches[%d].", f.state, i), bo)
+		sm.problems = append(sm.problems, branch.problems...)
+		branch.problems = nil
+		st.Branches = append(st.Branches, branch)
+	}
+	for _, key := range []string{"ItemProcessor", "Iterator"} {
+		if io, ok := obj[key].(map[string]interface{}); ok {
+			st.Iterator = &StateMachine{States: map[string]*State{}}
+			st.Iterator.parse(f.state+"."+key+".", io)
+			sm.problems = append(sm.problems, st.Iterator.problems...)
+			st.Iterator.problems = nil
+		}
+	}
+	return st
+}
+
+// fields reads typed fields of a definition object, recording fields of
+// the wrong type as problems.
+type fields struct {
+	sm    *StateMachine
+	state string
+}
+
+func (f fields) wrong(field, want string) {
+	f.sm.problems = append(f.sm.problems, Problem{State: f.state, Field: field, Detail: "must be " + want})
+}
+
+func (f fields) str(obj map[string]interface{}, key string) string {
+	v, ok := obj[key]
+	if !ok {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		f.wrong(key, "a string")
+	}
+	return s
+}
+
+func (f fields) num(obj map[string]interface{}, key string, def float64) float64 {
+	v, ok := obj[key]
+	if !ok {
+		return def
+	}
+	n, ok := v.(float64)
+	if !ok {
+		f.wrong(key, "a number")
+		return def
+	}
+	return n
+}
+
+// path reads a path field: "$" when absent and "" when null.
+func (f fields) path(obj map[string]interface{}, key string) string {
+	v, ok := obj[key]
+	switch {
+	case !ok:
+		return "$"
+	case v == nil:
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		f.wrong(key, "a path or null")
+		return "$"
+	}
+	return s
+}
+
+func (f fields) list(obj map[string]interface{}, key string) []interface{} {
+	v, ok := obj[key]
+	if !ok {
+		return nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		f.wrong(key, "an array")
+	}
+	return list
+}
+
+func (f fields) strs(obj map[string]interface{}, key string) []string {
+	var out []string
+	for _, v := range f.list(obj, key) {
+		s, ok := v.(string)
+		if !ok {
+			f.wrong(key, "an array of strings")
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Names returns the state names in order.
+func (sm *StateMachine) Names() []string {
+	var out []string
+	for name := range sm.States {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Machine is an aws_sfn_state_machine.
+type Machine struct {
+	ID         string
+	Name       string
+	Type       string // STANDARD or EXPRESS
+	Definition *StateMachine
+	// Roles are the nodes role_arn resolves to.
+	Roles []tfconfig.Node
+	// LogLevel and LogDestination are the logging_configuration, with the
+	// destination symbolic, e.g. "${aws_cloudwatch_log_group.sfn.arn}:*".
+	LogLevel             string
+	LogDestination       string
+	IncludeExecutionData bool
+	// Alarms are the alarms on the AWS/States metrics of the machine.
+	Alarms []*Alarm
+	Block  *tfconfig.Block
+}
+
+// Model holds the state machines of a tree.
+type Model struct {
+	Machines []*Machine
+
+	// Unresolved lists state machines whose definition is not statically
+	// known.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+}
+
+// Build collects the state machines of every module in the tree.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph()}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_sfn_state_machine")) {
+			md.collect(m, b)
+		}
+	}
+	sort.Slice(md.Machines, func(i, j int) bool { return md.Machines[i].ID < md.Machines[j].ID })
+	sort.Strings(md.Unresolved)
+	md.collectAlarms()
+	return md
+}
+
+// Machine returns the state machine with the given node ID, or nil.
+func (md *Model) Machine(id string) *Machine {
+	for _, sm := range md.Machines {
+		if sm.ID == id {
+			return sm
+		}
+	}
+	return nil
+}
+
+func (md *Model) collect(m *tfconfig.Module, b *tfconfig.Block) {
+	mc := &Machine{ID: nodeID(m, b), Type: "STANDARD", Block: b}
+	if b.Has("name") {
+		mc.Name, _ = m.PartialString(b.Expr("name"))
+	}
+	if s, ok := b.String("type"); ok {
+		mc.Type = s
+	}
+	mc.Roles = md.Refs.ResolveExpr(m, b.Expr("role_arn"))
+	for _, lc := range b.Nested("logging_configuration") {
+		mc.LogLevel, _ = lc.String("level")
+		mc.IncludeExecutionData, _ = lc.Bool("include_execution_data")
+		if lc.Has("log_destination") {
+			mc.LogDestination, _ = m.Symbolic(lc.Expr("log_destination")).(string)
+		}
+	}
+	def, err := definition(m, b.Expr("definition"))
+	if err == nil {
+		mc.Definition, err = Parse(def)
+	}
+	if err != nil {
+		md.Unresolved = append(md.Unresolved, mc.ID+": "+err.Error())
+	}
+	md.Machines = append(md.Machines, mc)
+}
+
+// definition decodes a definition attribute with unknown references kept
+// symbolic: a jsonencode call, or a JSON string such as a heredoc or a
+// rendered template.
+func definition(m *tfconfig.Module, expr hclsyntax.Expression) (interface{}, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("definition: missing")
+	}
+	if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok && call.Name == "jsonencode" && len(call.Args) == 1 {
+		return m.Symbolic(call.Args[0]), nil
+	}
+	if v, ok := m.JSON(expr); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("definition: not statically known")
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
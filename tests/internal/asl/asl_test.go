@@ -0,0 +1,562 @@
+package asl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleMachines has an ingest machine that routes on the document type,
+// fans a PDF out to a Map over its pages and a Parallel of two Lambda
+// tasks, and catches failures into a Fail state; and a broken machine with
+// one of each definition defect.
+const sampleMachines = `
+resource "aws_sfn_state_machine" "ingest" {
+  name     = "ingest"
+  role_arn = aws_iam_role.sfn.arn
+
+  logging_configuration {
+    level                  = "ALL"
+    include_execution_data = true
+    log_destination        = "${aws_cloudwatch_log_group.sfn.arn}:*"
+  }
+
+  definition = jsonencode({
+    StartAt = "Route"
+    States = {
+      Route = {
+        Type = "Choice"
+        Choices = [
+          {
+            And = [
+              { Variable = "$.doc.type", StringEquals = "pdf" },
+              { Variable = "$.doc.pages", NumericGreaterThan = 0 },
+            ]
+            Next = "Pages"
+          },
+          { Variable = "$.doc.type", StringMatches = "image/*", Next = "Skip" },
+        ]
+        Default = "Unsupported"
+      }
+      Skip = {
+        Type       = "Pass"
+        Result     = { skipped = true }
+        ResultPath = "$.status"
+        End        = true
+      }
+      Unsupported = {
+        Type  = "Fail"
+        Error = "Ingest.Unsupported"
+        Cause = "unsupported document type"
+      }
+      Pages = {
+        Type      = "Map"
+        ItemsPath = "$.doc.page_list"
+        Parameters = {
+          "page.$"  = "$$.Map.Item.Value"
+          "index.$" = "$$.Map.Item.Index"
+        }
+        Iterator = {
+          StartAt = "Render"
+          States = {
+            Render = {
+              Type       = "Pass"
+              Parameters = { "label.$" = "States.Format('page {} of {}', $.index, $.page)" }
+              End        = true
+            }
+          }
+        }
+        ResultPath = "$.pages"
+        Next       = "Index"
+      }
+      Index = {
+        Type = "Parallel"
+        Branches = [
+          {
+            StartAt = "Embed"
+            States = {
+              Embed = {
+                Type     = "Task"
+                Resource = "arn:aws:states:::lambda:invoke"
+                Parameters = {
+                  FunctionName = aws_lambda_function.embed.arn
+                  "Payload.$"  = "$.doc"
+                }
+                ResultSelector = { "vectors.$" = "$.Payload.vectors" }
+                Retry = [
+                  { ErrorEquals = ["Lambda.TooManyRequestsException"], IntervalSeconds = 2, MaxAttempts = 3, BackoffRate = 2 },
+                ]
+                End = true
+              }
+            }
+          },
+          {
+            StartAt = "Tag"
+            States = {
+              Tag = {
+                Type     = "Task"
+                Resource = aws_lambda_function.tag.arn
+                End      = true
+              }
+            }
+          },
+        ]
+        ResultPath = "$.index"
+        Catch = [
+          { ErrorEquals = ["States.ALL"], ResultPath = "$.error", Next = "Failed" },
+        ]
+        Next = "Done"
+      }
+      Done = {
+        Type       = "Succeed"
+        OutputPath = "$.index"
+      }
+      Failed = {
+        Type  = "Fail"
+        Error = "Ingest.Failed"
+      }
+    }
+  })
+}
+
+resource "aws_sfn_state_machine" "broken" {
+  name     = "broken"
+  role_arn = aws_iam_role.broken.arn
+
+  definition = <<-EOF
+    {
+      "StartAt": "Start",
+      "States": {
+        "Start": {
+          "Type": "Task",
+          "Resource": "arn:aws:states:::lambda:invoke",
+          "Parameters": {
+            "Payload.$": "$.items[?(@.ok)]",
+            "Name.$": "States.Upper($.name)"
+          },
+          "ResultPath": "$.out[*]",
+          "Retry": [
+            {"ErrorEquals": ["States.ALL"]},
+            {"ErrorEquals": ["Lambda.ServiceException"], "BackoffRate": 0.5}
+          ],
+          "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Missing"}],
+          "Next": "Loop"
+        },
+        "Loop": {"Type": "Wait", "Seconds": 5, "Next": "Loop"},
+        "Orphan": {"Type": "Succeed"},
+        "Check": {"Type": "Pass", "Next": "Orphan", "End": true}
+      }
+    }
+  EOF
+}
+
+resource "aws_iam_role" "sfn" {
+  name = "sfn"
+}
+
+resource "aws_iam_role" "broken" {
+  name = "broken"
+}
+
+resource "aws_iam_role_policy" "invoke" {
+  role = aws_iam_role.sfn.id
+  policy = jsonencode({
+    Statement = [{
+      Effect   = "Allow"
+      Action   = "lambda:InvokeFunction"
+      Resource = [aws_lambda_function.embed.arn, "${aws_lambda_function.embed.arn}:*"]
+    }]
+  })
+}
+
+resource "aws_iam_role_policy" "logs" {
+  role = aws_iam_role.sfn.id
+  policy = jsonencode({
+    Statement = [
+      {
+        Effect = "Allow"
+        Action = [
+          "logs:CreateLogDelivery", "logs:CreateLogStream", "logs:GetLogDelivery", "logs:UpdateLogDelivery",
+          "logs:DeleteLogDelivery", "logs:ListLogDeliveries", "logs:PutLogEvents", "logs:PutResourcePolicy",
+          "logs:DescribeResourcePolicies", "logs:DescribeLogGroups",
+        ]
+        Resource = "*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["dynamodb:PutItem", "lambda:GetFunction"]
+        Resource = ["*", aws_dynamodb_table.jobs.arn]
+      },
+    ]
+  })
+}
+
+resource "aws_dynamodb_table" "jobs" {
+  name = "jobs"
+}
+
+resource "aws_cloudwatch_metric_alarm" "ingest_failed" {
+  alarm_name  = "ingest-failed"
+  metric_name = "ExecutionsFailed"
+  namespace   = "AWS/States"
+  dimensions = {
+    StateMachineArn = aws_sfn_state_machine.ingest.arn
+  }
+}
+
+resource "aws_cloudwatch_metric_alarm" "stale" {
+  alarm_name = "stale"
+  metric_query {
+    id = "failed"
+    metric {
+      metric_name = "ExecutionsTimedOut"
+      namespace   = "AWS/States"
+      dimensions = {
+        StateMachineArn = aws_sfn_state_machine.removed.arn
+      }
+    }
+  }
+}
+
+resource "aws_cloudwatch_log_group" "sfn" {
+  name = "/aws/vendedlogs/states/ingest"
+}
+
+resource "aws_lambda_function" "embed" {
+  function_name = "embed"
+}
+
+resource "aws_lambda_function" "tag" {
+  function_name = "tag"
+}
+
+resource "aws_sfn_state_machine" "disabled" {
+  count      = 0
+  definition = "{}"
+}
+`
+
+func loadSample(t *testing.T) *Model {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleMachines), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unresolved)
+	require.Len(t, md.Machines, 2)
+	return md
+}
+
+func TestPath(t *testing.T) {
+	t.Parallel()
+
+	doc := map[string]interface{}{
+		"a":      map[string]interface{}{"b": []interface{}{"x", "y"}},
+		"name":   "n",
+		"with.x": float64(1),
+	}
+	for path, want := range map[string]interface{}{
+		"$":           doc,
+		"$.name":      "n",
+		"$.a.b[1]":    "y",
+		"$['with.x']": float64(1),
+		"$.a.b[*]":    []interface{}{"x", "y"},
+		"$.a.*[0]":    []interface{}{"x"},
+		"$.a.*[5]":    []interface{}{},
+	} {
+		p, err := ParsePath(path)
+		require.NoError(t, err, path)
+		got, err := p.Get(doc)
+		require.NoError(t, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	for path, want := range map[string]string{
+		"$.missing": `$.missing: no field "missing"`,
+		"$.a.b[2]":  "$.a.b[2]: no element 2",
+		"$.name.x":  `$.name.x: no field "x"`,
+	} {
+		p, err := ParsePath(path)
+		require.NoError(t, err, path)
+		_, err = p.Get(doc)
+		assert.EqualError(t, err, want, path)
+	}
+
+	for path, want := range map[string]string{
+		"name":        `"name" does not start with $`,
+		"$.a..b":      `"$.a..b" has an empty field name`,
+		"$.a[?(@.x)]": `"$.a[?(@.x)]": filter expressions are not supported`,
+		"$.a[-1]":     `"$.a[-1]" has an invalid subscript [-1]`,
+		"$.a[0":       `"$.a[0" has an unclosed [`,
+		"$.a b":       `"$.a b" has ' ' in a field name`,
+		"$a":          `"$a" has an unexpected 'a'`,
+	} {
+		_, err := ParsePath(path)
+		assert.EqualError(t, err, want, path)
+	}
+
+	p, err := ParsePath("$.a.c.d")
+	require.NoError(t, err)
+	out, err := p.Set(doc, true)
+	require.NoError(t, err)
+	assert.Equal(t, true, out.(map[string]interface{})["a"].(map[string]interface{})["c"].(map[string]interface{})["d"])
+	assert.NotContains(t, doc["a"], "c", "Set does not modify its input")
+
+	p, err = ParsePath("$.a.b[*]")
+	require.NoError(t, err)
+	_, err = p.Set(doc, true)
+	assert.EqualError(t, err, "$.a.b[*]: not a reference path into the input")
+}
+
+func TestBuild_ReadsDefinitions(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	mc := md.Machine("environments/app:aws_sfn_state_machine.ingest")
+	require.NotNil(t, mc)
+	assert.Equal(t, "ingest", mc.Name)
+	assert.Equal(t, "STANDARD", mc.Type)
+	require.Len(t, mc.Roles, 1)
+	assert.Equal(t, "environments/app:aws_iam_role.sfn", mc.Roles[0].String())
+	assert.Equal(t, "ALL", mc.LogLevel)
+	assert.True(t, mc.IncludeExecutionData)
+	assert.Equal(t, "${aws_cloudwatch_log_group.sfn.arn}:*", mc.LogDestination)
+
+	sm := mc.Definition
+	assert.Equal(t, []string{"Done", "Failed", "Index", "Pages", "Route", "Skip", "Unsupported"}, sm.Names())
+	index := sm.States["Index"]
+	require.Len(t, index.Branches, 2)
+	embed := index.Branches[0].States["Embed"]
+	assert.Equal(t, "${aws_lambda_function.embed.arn}", embed.Parameters.(map[string]interface{})["FunctionName"])
+	assert.Equal(t, []Retrier{{ErrorEquals: []string{"Lambda.TooManyRequestsException"}, IntervalSeconds: 2, MaxAttempts: 3, BackoffRate: 2}}, embed.Retry)
+	assert.Equal(t, "$", embed.ResultPath, "an absent ResultPath is $")
+	assert.Equal(t, "${aws_lambda_function.tag.arn}", index.Branches[1].States["Tag"].Resource)
+	require.NotNil(t, sm.States["Pages"].Iterator)
+
+	assert.Empty(t, sm.Validate())
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	var got []string
+	for _, p := range md.Machine("environments/app:aws_sfn_state_machine.broken").Definition.Validate() {
+		got = append(got, p.String())
+	}
+	assert.ElementsMatch(t, []string{
+		"Check: has both Next and End",
+		"Check: unreachable from StartAt",
+		"Loop: cannot reach a terminal state",
+		"Orphan: unreachable from StartAt",
+		`Start: Catch[0].Next: no state "Missing"`,
+		`Start: Parameters.Name.$: "States.Upper($.name)": unknown intrinsic function States.Upper`,
+		`Start: Parameters.Payload.$: "$.items[?(@.ok)]": filter expressions are not supported`,
+		`Start: ResultPath: "$.out[*]" is not a reference path`,
+		"Start: Retry[0]: States.ALL must be in the last element; the ones after it never apply",
+		"Start: Retry[1]: BackoffRate must be at least 1",
+		"Start: cannot reach a terminal state",
+	}, got)
+}
+
+func TestValidate_DefinitionShape(t *testing.T) {
+	t.Parallel()
+
+	for def, want := range map[string][]string{
+		`{"States": {}}`: {"States: no states"},
+		`{"StartAt": "A", "States": {"B": {"Type": "Succeed"}}}`:            {`StartAt: no state "A"`, "B: unreachable from StartAt"},
+		`{"StartAt": "A", "States": {"A": {"Type": "Sleep", "End": true}}}`: {`A: Type: unknown state type "Sleep"`},
+		`{"StartAt": "A", "States": {"A": {"Type": "Pass", "End": "yes"}}}`: {"A: has neither Next nor End", "A: cannot reach a terminal state"},
+		`{"StartAt": "A", "States": {"A": {"Type": "Pass", "Retry": [], "Next": 1}}}`: {
+			"A: Next: must be a string", "A: has neither Next nor End", "A: cannot reach a terminal state",
+		},
+		`{"StartAt": "A", "States": {"A": {"Type": "Succeed", "Next": "A"}}}`: {
+			"A: a Succeed state is terminal and must have neither Next nor End",
+		},
+		`{"StartAt": "A", "States": {"A": {"Type": "Choice", "Choices": [{"Variable": "$.x", "StringEquals": 1, "Next": "B"}, {"Variable": "$.x", "Next": "B"}]}, "B": {"Type": "Succeed"}}}`: {
+			"A: Choices[0]: StringEquals: invalid operand 1",
+			"A: Choices[1]: $.x has no comparison",
+		},
+		`{"StartAt": "A", "States": {"A": {"Type": "Map", "ItemsPath": "$.items", "End": true}}}`: {
+			"A: ItemProcessor: a Map state needs an ItemProcessor",
+		},
+		`{"StartAt": "A", "States": {"A": {"Type": "Parallel", "Branches": [{"StartAt": "B", "States": {"B": {"Type": "Pass"}}}], "End": true}}}`: {
+			"A.Branches[0].B: has neither Next nor End",
+			"A.Branches[0].B: cannot reach a terminal state",
+		},
+	} {
+		sm, err := ParseJSON([]byte(def))
+		require.NoError(t, err, def)
+		var got []string
+		for _, p := range sm.Validate() {
+			got = append(got, p.String())
+		}
+		assert.ElementsMatch(t, want, got, def)
+	}
+}
+
+func ingestMachine(t *testing.T) *StateMachine {
+	t.Helper()
+	sm := loadSample(t).Machine("environments/app:aws_sfn_state_machine.ingest").Definition
+	require.NotNil(t, sm)
+	return sm
+}
+
+func TestSimulate(t *testing.T) {
+	t.Parallel()
+
+	sm := ingestMachine(t)
+	pdf := map[string]interface{}{
+		"doc": map[string]interface{}{"type": "pdf", "pages": float64(2), "page_list": []interface{}{"p1", "p2"}},
+	}
+	stubs := Stubs{Results: map[string]interface{}{
+		"Embed": map[string]interface{}{"vectors": []interface{}{float64(1), float64(2)}},
+		"Tag":   map[string]interface{}{"tags": []interface{}{"manual"}},
+	}}
+
+	t.Run("happy path", func(t *testing.T) {
+		t.Parallel()
+
+		ex, err := sm.Simulate(pdf, stubs.Run)
+		require.NoError(t, err)
+		require.Equal(t, Succeeded, ex.Status, ex.String())
+		assert.Equal(t, []string{
+			"Route", "Pages",
+			"Pages.Items[0].Render", "Pages.Items[1].Render",
+			"Index", "Index.Branches[0].Embed", "Index.Branches[1].Tag", "Done",
+		}, ex.Path())
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"vectors": []interface{}{float64(1), float64(2)}},
+			map[string]interface{}{"tags": []interface{}{"manual"}},
+		}, ex.Output, "OutputPath selects the Parallel results; ResultSelector reshapes the Lambda response")
+	})
+
+	t.Run("retry then succeed", func(t *testing.T) {
+		t.Parallel()
+
+		flaky := stubs
+		flaky.Failures = map[string][]Failure{"Embed": {
+			{Name: "Lambda.TooManyRequestsException", Cause: "Rate exceeded"},
+			{Name: "Lambda.TooManyRequestsException", Cause: "Rate exceeded"},
+		}}
+		ex, err := sm.Simulate(pdf, flaky.Run)
+		require.NoError(t, err)
+		require.Equal(t, Succeeded, ex.Status, ex.String())
+		var waits []time.Duration
+		for _, s := range ex.Steps {
+			if s.State == "Index.Branches[0].Embed" {
+				waits = append(waits, s.RetryAfter)
+			}
+		}
+		assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 0}, waits, "IntervalSeconds grows by BackoffRate")
+	})
+
+	t.Run("caught", func(t *testing.T) {
+		t.Parallel()
+
+		broken := stubs
+		broken.Failures = map[string][]Failure{"Tag": {{Name: "Lambda.ServiceException", Cause: "boom"}}}
+		ex, err := sm.Simulate(pdf, broken.Run)
+		require.NoError(t, err)
+		assert.Equal(t, Failed, ex.Status)
+		assert.Equal(t, "Ingest.Failed", ex.Error)
+		assert.Equal(t, "Failed", ex.Path()[len(ex.Path())-1])
+		assert.Contains(t, ex.String(), "Index (Lambda.ServiceException, caught) -> Index.Branches[0].Embed -> Index.Branches[1].Tag (Lambda.ServiceException) -> Failed",
+			"steps are listed as they are entered, so a Parallel state comes before its branches")
+	})
+
+	t.Run("choice", func(t *testing.T) {
+		t.Parallel()
+
+		ex, err := sm.Simulate(map[string]interface{}{"doc": map[string]interface{}{"type": "image/png"}}, stubs.Run)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Route", "Skip"}, ex.Path())
+		assert.Equal(t, map[string]interface{}{
+			"doc":    map[string]interface{}{"type": "image/png"},
+			"status": map[string]interface{}{"skipped": true},
+		}, ex.Output)
+
+		ex, err = sm.Simulate(map[string]interface{}{"doc": map[string]interface{}{"type": "pdf", "pages": float64(0)}}, stubs.Run)
+		require.NoError(t, err)
+		assert.Equal(t, "Route -> Unsupported (Ingest.Unsupported): FAILED (Ingest.Unsupported: unsupported document type)", ex.String())
+	})
+
+	t.Run("missing input path", func(t *testing.T) {
+		t.Parallel()
+
+		ex, err := sm.Simulate(map[string]interface{}{"doc": map[string]interface{}{"type": "pdf", "pages": float64(1)}}, stubs.Run)
+		require.NoError(t, err)
+		assert.Equal(t, Failed, ex.Status)
+		assert.Equal(t, "States.Runtime", ex.Error, "a failure in input processing is not retried or caught")
+		assert.Equal(t, `$.doc.page_list: no field "page_list"`, ex.Cause)
+	})
+}
+
+func TestSimulate_DoesNotTerminate(t *testing.T) {
+	t.Parallel()
+
+	sm, err := ParseJSON([]byte(`{"StartAt": "A", "States": {"A": {"Type": "Pass", "Next": "A"}}}`))
+	require.NoError(t, err)
+	_, err = sm.Simulate(map[string]interface{}{}, Stubs{}.Run)
+	assert.EqualError(t, err, "more than 1000 transitions; the machine does not terminate")
+}
+
+func TestIntegrations(t *testing.T) {
+	t.Parallel()
+
+	sm, err := ParseJSON([]byte(`{
+	  "StartAt": "Put",
+	  "States": {
+	    "Put": {"Type": "Task", "Resource": "arn:aws:states:::dynamodb:putItem", "Parameters": {"TableName": "${aws_dynamodb_table.jobs.name}"}, "Next": "Query"},
+	    "Query": {"Type": "Task", "Resource": "arn:aws:states:::aws-sdk:dynamodb:query", "Parameters": {"TableName": "jobs"}, "Next": "Notify"},
+	    "Notify": {"Type": "Task", "Resource": "arn:aws:states:::sns:publish.waitForTaskToken", "Parameters": {"TopicArn.$": "$.topic"}, "Next": "Run"},
+	    "Run": {"Type": "Task", "Resource": "arn:aws:states:::ecs:runTask.sync", "End": true}
+	  }
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Integration{
+		{State: "Notify", Resource: "arn:aws:states:::sns:publish.waitForTaskToken", Action: "sns:Publish"},
+		{State: "Put", Resource: "arn:aws:states:::dynamodb:putItem", Action: "dynamodb:PutItem", Target: "${aws_dynamodb_table.jobs.name}"},
+		{State: "Query", Resource: "arn:aws:states:::aws-sdk:dynamodb:query", Action: "dynamodb:Query", Target: "jobs"},
+		{State: "Run", Resource: "arn:aws:states:::ecs:runTask.sync"},
+	}, sm.Integrations())
+
+	assert.True(t, sameResource("${aws_dynamodb_table.jobs.arn}", "${aws_dynamodb_table.jobs.name}"))
+	assert.True(t, sameResource("${aws_lambda_function.f.arn}:*", "${aws_lambda_function.f.arn}"))
+	assert.False(t, sameResource("${aws_lambda_function.f.arn}:*", "${aws_lambda_function.g.arn}"))
+	assert.True(t, sameResource("arn:aws:dynamodb:*:*:table/jobs*", "arn:aws:dynamodb:ap-northeast-2:111122223333:table/jobs"))
+}
+
+func TestConsistency(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	ingest := md.Machine("environments/app:aws_sfn_state_machine.ingest")
+	require.Len(t, ingest.Alarms, 1)
+	assert.Equal(t, Alarm{ID: "environments/app:aws_cloudwatch_metric_alarm.ingest_failed", Metrics: []string{"ExecutionsFailed"}}, *ingest.Alarms[0])
+	assert.Contains(t, ingest.Required(), Grant{Action: "lambda:InvokeFunction", Resource: "${aws_lambda_function.tag.arn}"})
+
+	got := map[string]string{}
+	for _, f := range md.Consistency() {
+		got[f.Key()] = f.Detail
+	}
+	assert.Equal(t, map[string]string{
+		"environments/app:aws_sfn_state_machine.broken Start integration":                        "arn:aws:states:::lambda:invoke does not name its target statically",
+		"environments/app:aws_sfn_state_machine.broken logging_configuration logging":            "execution logging is off",
+		"environments/app:aws_sfn_state_machine.broken aws_sfn_state_machine.broken alarm":       "no alarm watches the AWS/States metrics of the machine",
+		"environments/app:aws_sfn_state_machine.ingest Index.Branches[1].Tag missing-permission": "the role does not allow lambda:InvokeFunction on ${aws_lambda_function.tag.arn}",
+		"environments/app:aws_sfn_state_machine.ingest aws_iam_role_policy.logs extra-permission": "allows dynamodb:PutItem on *, dynamodb:PutItem on ${aws_dynamodb_table.jobs.arn}, " +
+			"lambda:GetFunction on *, lambda:GetFunction on ${aws_dynamodb_table.jobs.arn}, which the machine does not need",
+		"environments/app:aws_cloudwatch_metric_alarm.stale aws_cloudwatch_metric_alarm.stale alarm": "ExecutionsTimedOut has no StateMachineArn of a state machine in this module",
+	}, got)
+}
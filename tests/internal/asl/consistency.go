@@ -0,0 +1,535 @@
+package asl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Integration is a service call a Task state makes.
+type Integration struct {
+	State    string // e.g. "HierarchyExtraction" or "Fanout.Branches[0].Load"
+	Resource string // the Task Resource, e.g. "arn:aws:states:::lambda:invoke"
+	// Action is the IAM action the call needs, e.g. "lambda:InvokeFunction",
+	// or "" for integrations this package does not know.
+	Action string
+	// Target is the resource the call is made on, symbolic, e.g.
+	// "${aws_lambda_function.rtl_parser.arn}", or "" when the state does not
+	// name it.
+	Target string
+}
+
+// serviceIntegrations are the optimized integrations this package knows, by
+// service and API, with the IAM action they need and the parameter naming
+// their target.
+var serviceIntegrations = map[string]struct {
+	action, param string
+}{
+	"lambda:invoke":       {"lambda:InvokeFunction", "FunctionName"},
+	"dynamodb:getItem":    {"dynamodb:GetItem", "TableName"},
+	"dynamodb:putItem":    {"dynamodb:PutItem", "TableName"},
+	"dynamodb:updateItem": {"dynamodb:UpdateItem", "TableName"},
+	"dynamodb:deleteItem": {"dynamodb:DeleteItem", "TableName"},
+	"sns:publish":         {"sns:Publish", "TopicArn"},
+	"sqs:sendMessage":     {"sqs:SendMessage", "QueueUrl"},
+}
+
+// integrationARN splits an arn:aws:states::: Resource into its service and
+// API, without a .sync or .waitForTaskToken suffix.
+var integrationARN = regexp.MustCompile(`^arn:aws:states:::(aws-sdk:)?([a-z0-9-]+):([A-Za-z0-9]+)(\.sync(:2)?|\.waitForTaskToken)?$`)
+
+// Integrations lists the service calls of the Task states, in Parallel
+// branches and Map iterators too, by state name.
+func (sm *StateMachine) Integrations() []Integration {
+	var out []Integration
+	sm.integrations("", &out)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].State < out[j].State })
+	return out
+}
+
+func (sm *StateMachine) integrations(prefix string, out *[]Integration) {
+	for _, name := range sm.Names() {
+		st := sm.States[name]
+		at := prefix + name
+		for i, b := range st.Branches {
+			b.integrations(fmt.Sprintf("%s.Branches[%d].", at, i), out)
+		}
+		if st.Iterator != nil {
+			st.Iterator.integrations(at+".Iterator.", out)
+		}
+		if st.Type != "Task" {
+			continue
+		}
+		*out = append(*out, st.integration(at))
+	}
+}
+
+func (st *State) integration(at string) Integration {
+	in := Integration{State: at, Resource: st.Resource}
+	params, _ := st.Parameters.(map[string]interface{})
+	m := integrationARN.FindStringSubmatch(st.Resource)
+	switch {
+	case m == nil:
+		// A Lambda function or activity ARN as the Resource.
+		if strings.HasPrefix(st.Resource, "arn:aws:lambda:") || strings.HasPrefix(st.Resource, "${aws_lambda_function.") {
+			in.Action, in.Target = "lambda:InvokeFunction", st.Resource
+		}
+	case m[1] != "":
+		// The AWS SDK integrations call the API of the same name.
+		in.Action = m[2] + ":" + strings.ToUpper(m[3][:1]) + m[3][1:]
+		if m[2] == "dynamodb" {
+			in.Target, _ = params["TableName"].(string)
+		}
+	default:
+		si, ok := serviceIntegrations[m[2]+":"+m[3]]
+		if !ok {
+			break
+		}
+		in.Action = si.action
+		in.Target, _ = params[si.param].(string)
+	}
+	return in
+}
+
+// logDeliveryActions are the actions Step Functions needs to deliver
+// execution logs to CloudWatch Logs. They do not support resource-level
+// permissions.
+var logDeliveryActions = []string{
+	"logs:CreateLogDelivery",
+	"logs:CreateLogStream",
+	"logs:DeleteLogDelivery",
+	"logs:DescribeLogGroups",
+	"logs:DescribeResourcePolicies",
+	"logs:GetLogDelivery",
+	"logs:ListLogDeliveries",
+	"logs:PutLogEvents",
+	"logs:PutResourcePolicy",
+	"logs:UpdateLogDelivery",
+}
+
+// Grant is an action on a resource, with the resource symbolic.
+type Grant struct {
+	Action   string
+	Resource string
+}
+
+func (g Grant) String() string {
+	return g.Action + " on " + g.Resource
+}
+
+// Checks reported by Consistency.
+const (
+	CheckIntegration       = "integration"
+	CheckMissingPermission = "missing-permission"
+	CheckExtraPermission   = "extra-permission"
+	CheckLogging           = "logging"
+	CheckAlarm             = "alarm"
+)
+
+// Required returns what the machine's role must be allowed to do: the
+// action of every integration on its target, and log delivery when
+// logging is on.
+func (mc *Machine) Required() []Grant {
+	var out []Grant
+	seen := map[Grant]bool{}
+	add := func(g Grant) {
+		if !seen[g] {
+			seen[g] = true
+			out = append(out, g)
+		}
+	}
+	if mc.Definition != nil {
+		for _, in := range mc.Definition.Integrations() {
+			if in.Action != "" && in.Target != "" {
+				add(Grant{Action: in.Action, Resource: in.Target})
+			}
+		}
+	}
+	if mc.logging() {
+		for _, a := range logDeliveryActions {
+			add(Grant{Action: a, Resource: "*"})
+		}
+	}
+	return out
+}
+
+func (mc *Machine) logging() bool {
+	return mc.LogLevel != "" && mc.LogLevel != "OFF"
+}
+
+// Consistency ties every state machine to its role, logging and alarms:
+//
+//   - Every integration is one this package knows and names its target.
+//   - The role's policies allow exactly the Required grants: each of them,
+//     and no action or resource beyond them.
+//   - Logging is on and goes to a log group of the tree, as
+//     "<log group ARN>:*".
+//   - An alarm watches the AWS/States metrics of the machine, and every
+//     AWS/States alarm metric names a machine of the tree.
+//
+// Symbolic ARNs only compare within one module, so policies in another
+// module than the state machine grant nothing it needs.
+func (md *Model) Consistency() []finding.Finding {
+	var out []finding.Finding
+	for _, mc := range md.Machines {
+		out = append(out, md.machineConsistency(mc)...)
+	}
+	return append(out, md.alarmConsistency()...)
+}
+
+func (md *Model) machineConsistency(mc *Machine) []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Scope: mc.ID, Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if mc.Definition != nil {
+		for _, in := range mc.Definition.Integrations() {
+			switch {
+			case in.Action == "":
+				add(in.State, CheckIntegration, "the IAM action for %s is not known", in.Resource)
+			case in.Target == "":
+				add(in.State, CheckIntegration, "%s does not name its target statically", in.Resource)
+			}
+		}
+	}
+
+	required := mc.Required()
+	var policies []*policy
+	for _, role := range mc.Roles {
+		ps, errs := md.rolePolicies(role)
+		for _, err := range errs {
+			add(role.Addr, CheckMissingPermission, "%v", err)
+		}
+		policies = append(policies, ps...)
+	}
+	m := mc.Block.Module()
+	for _, g := range required {
+		allowed := false
+		for _, p := range policies {
+			allowed = allowed || p.allows(m, g)
+		}
+		if !allowed {
+			subject := "logging_configuration"
+			if g.Resource != "*" {
+				for _, in := range mc.Definition.Integrations() {
+					if in.Action == g.Action && in.Target == g.Resource {
+						subject = in.State
+						break
+					}
+				}
+			}
+			add(subject, CheckMissingPermission, "the role does not allow %s", g)
+		}
+	}
+	for _, p := range policies {
+		if extra := p.extra(m, required); len(extra) > 0 {
+			add(p.address, CheckExtraPermission, "allows %s, which the machine does not need", strings.Join(extra, ", "))
+		}
+	}
+
+	switch {
+	case !mc.logging():
+		add("logging_configuration", CheckLogging, "execution logging is off")
+	case !strings.HasSuffix(mc.LogDestination, ":*"):
+		add("logging_configuration", CheckLogging, "log_destination %q must be a log group ARN followed by :*", mc.LogDestination)
+	default:
+		if addr, _ := reference(strings.TrimSuffix(mc.LogDestination, ":*")); !strings.HasPrefix(addr, "aws_cloudwatch_log_group.") {
+			add("logging_configuration", CheckLogging, "log_destination %q is not a log group of the tree", mc.LogDestination)
+		}
+	}
+	if len(mc.Alarms) == 0 {
+		add(mc.Block.Address(), CheckAlarm, "no alarm watches the AWS/States metrics of the machine")
+	}
+	return out
+}
+
+// alarmConsistency reports AWS/States alarm metrics that name no state
+// machine of the tree.
+func (md *Model) alarmConsistency() []finding.Finding {
+	var out []finding.Finding
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_cloudwatch_metric_alarm")) {
+			for _, mt := range alarmMetrics(m, b) {
+				if mt.namespace != "AWS/States" || md.watched(m, mt) != nil {
+					continue
+				}
+				out = append(out, finding.Finding{
+					Scope:   nodeID(m, b),
+					Subject: b.Address(),
+					Check:   CheckAlarm,
+					Detail:  fmt.Sprintf("%s has no StateMachineArn of a state machine in this module", mt.name),
+				})
+			}
+		}
+	}
+	return out
+}
+
+// Alarm is an aws_cloudwatch_metric_alarm on the metrics of a state
+// machine.
+type Alarm struct {
+	ID      string
+	Metrics []string // e.g. ["ExecutionsFailed", "ExecutionsStarted"]
+}
+
+type alarmMetric struct {
+	name, namespace string
+	dimensions      map[string]interface{} // symbolic
+}
+
+// alarmMetrics returns the metrics of an alarm: its own, or those of its
+// metric_query blocks.
+func alarmMetrics(m *tfconfig.Module, b *tfconfig.Block) []alarmMetric {
+	read := func(b *tfconfig.Block) alarmMetric {
+		var mt alarmMetric
+		mt.name, _ = b.String("metric_name")
+		mt.namespace, _ = b.String("namespace")
+		if b.Has("dimensions") {
+			mt.dimensions, _ = m.Symbolic(b.Expr("dimensions")).(map[string]interface{})
+		}
+		return mt
+	}
+	if b.Has("metric_name") {
+		return []alarmMetric{read(b)}
+	}
+	var out []alarmMetric
+	for _, q := range b.Nested("metric_query") {
+		for _, mb := range q.Nested("metric") {
+			out = append(out, read(mb))
+		}
+	}
+	return out
+}
+
+// watched returns the state machine an AWS/States metric is for, or nil.
+func (md *Model) watched(m *tfconfig.Module, mt alarmMetric) *Machine {
+	arn, _ := mt.dimensions["StateMachineArn"].(string)
+	addr, attr := reference(arn)
+	if attr != "arn" && attr != "id" {
+		return nil
+	}
+	for _, mc := range md.Machines {
+		if mc.Block.Module() == m && mc.Block.Address() == addr {
+			return mc
+		}
+	}
+	return nil
+}
+
+// collectAlarms sets the Alarms of every machine.
+func (md *Model) collectAlarms() {
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_cloudwatch_metric_alarm")) {
+			alarms := map[*Machine]*Alarm{}
+			for _, mt := range alarmMetrics(m, b) {
+				mc := md.watched(m, mt)
+				if mt.namespace != "AWS/States" || mc == nil {
+					continue
+				}
+				if alarms[mc] == nil {
+					alarms[mc] = &Alarm{ID: nodeID(m, b)}
+					mc.Alarms = append(mc.Alarms, alarms[mc])
+				}
+				alarms[mc].Metrics = append(alarms[mc].Metrics, mt.name)
+			}
+		}
+	}
+}
+
+// symbolicRef matches a whole symbolic reference to a resource attribute,
+// e.g. "${aws_lambda_function.rtl_parser.arn}".
+var symbolicRef = regexp.MustCompile(`^\$\{([a-z0-9_]+\.[A-Za-z0-9_-]+)(\[[^\]]*\])?\.([a-z_]+)\}`)
+
+// reference returns the resource address and attribute a symbolic ARN
+// starts with, or "" when it does not start with one.
+func reference(s string) (addr, attr string) {
+	m := symbolicRef.FindStringSubmatch(s)
+	if m == nil {
+		return "", ""
+	}
+	return m[1], m[3]
+}
+
+// sameResource reports whether a policy resource covers a target. Targets
+// and resources that refer to a resource compare by address, whatever the
+// attribute, so that a TableName of "${aws_dynamodb_table.t.name}" matches
+// "${aws_dynamodb_table.t.arn}"; a ":*" suffix also covers the function
+// versions and aliases of Lambda ARNs. Other values compare as wildcards.
+func sameResource(resource, target string) bool {
+	if resource == target {
+		return true
+	}
+	ra, _ := reference(resource)
+	ta, _ := reference(target)
+	if ra == "" || ta == "" {
+		return ra == "" && ta == "" && iampolicy.Wildcard(resource, target)
+	}
+	rest := symbolicRef.ReplaceAllString(resource, "")
+	return ra == ta && (rest == "" || rest == ":*") && symbolicRef.ReplaceAllString(target, "") == ""
+}
+
+// policy is an identity policy of a state machine's role, with references
+// kept symbolic.
+type policy struct {
+	address string // e.g. "aws_iam_role_policy.sfn_dynamodb"
+	module  *tfconfig.Module
+	doc     *iampolicy.Document
+}
+
+// allows reports whether the policy grants g to a machine in module m. A
+// Deny statement for the grant wins.
+func (p *policy) allows(m *tfconfig.Module, g Grant) bool {
+	if p.module != m && g.Resource != "*" {
+		return false
+	}
+	allowed := false
+	for _, st := range p.doc.Statements {
+		if !matchesAny(st.Actions, g.Action) || !coversAny(st.Resources, g.Resource) {
+			continue
+		}
+		switch st.Effect {
+		case iampolicy.Deny:
+			return false
+		case iampolicy.Allow:
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// extra returns the actions and resources of Allow statements that no
+// required grant needs, such as an action no state calls or a wildcard
+// resource where the grant names one. Statements with NotAction or
+// NotResource always grant more than any list of grants.
+func (p *policy) extra(m *tfconfig.Module, required []Grant) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	for _, st := range p.doc.Statements {
+		if st.Effect != iampolicy.Allow {
+			continue
+		}
+		if len(st.NotActions) > 0 || len(st.NotResources) > 0 {
+			add("NotAction or NotResource")
+			continue
+		}
+		for _, a := range st.Actions {
+			for _, r := range st.Resources {
+				needed := false
+				for _, g := range required {
+					if !iampolicy.MatchAction(a, g.Action) || (p.module != m && g.Resource != "*") {
+						continue
+					}
+					// A wildcard resource is only needed by grants on "*".
+					if r == "*" && g.Resource != "*" {
+						continue
+					}
+					needed = needed || sameResource(r, g.Resource)
+				}
+				if !needed {
+					add(a + " on " + r)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func matchesAny(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if iampolicy.MatchAction(p, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func coversAny(resources []string, target string) bool {
+	for _, r := range resources {
+		if r == "*" || sameResource(r, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// rolePolicies returns the inline policies of a role, its
+// aws_iam_role_policy resources and its attached aws_iam_policy resources
+// in any module, with references kept symbolic. AWS managed policies
+// cannot be compared with the tree's resources and are returned as errors.
+func (md *Model) rolePolicies(role tfconfig.Node) ([]*policy, []error) {
+	var out []*policy
+	var errs []error
+	add := func(m *tfconfig.Module, address string, expr hclsyntax.Expression) {
+		doc, err := symbolicPolicy(md.Refs, m, tfconfig.Node{Module: m, Addr: address}.String(), expr)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		out = append(out, &policy{address: address, module: m, doc: doc})
+	}
+
+	rb := role.Block()
+	if rb == nil {
+		return nil, []error{fmt.Errorf("%s: role not found", role)}
+	}
+	for _, ib := range rb.Nested("inline_policy") {
+		add(role.Module, role.Addr+".inline_policy", ib.Expr("policy"))
+	}
+	refersToRole := func(m *tfconfig.Module, b *tfconfig.Block) bool {
+		for _, n := range md.Refs.ResolveExpr(m, b.Expr("role")) {
+			if n == role {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_iam_role_policy")) {
+			if refersToRole(m, b) {
+				add(m, b.Address(), b.Expr("policy"))
+			}
+		}
+		for _, b := range instantiated(m.Resources("aws_iam_role_policy_attachment")) {
+			if !refersToRole(m, b) {
+				continue
+			}
+			resolved := false
+			for _, n := range md.Refs.ResolveExpr(m, b.Expr("policy_arn")) {
+				if n.Kind() == "aws_iam_policy" {
+					add(n.Module, n.Addr, n.Block().Expr("policy"))
+					resolved = true
+				}
+			}
+			if !resolved {
+				errs = append(errs, fmt.Errorf("%s: policy_arn is not an aws_iam_policy of the tree", nodeID(m, b)))
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].address < out[j].address })
+	return out, errs
+}
+
+// symbolicPolicy parses a policy with unknown references kept symbolic.
+// Policies that are not an inline jsonencode are resolved as iampolicy
+// does.
+func symbolicPolicy(g *tfconfig.Graph, m *tfconfig.Module, source string, expr hclsyntax.Expression) (*iampolicy.Document, error) {
+	call, ok := expr.(*hclsyntax.FunctionCallExpr)
+	if !ok || call.Name != "jsonencode" || len(call.Args) != 1 {
+		return iampolicy.FromExpr(g, m, source, expr)
+	}
+	return iampolicy.Parse(source, m.Symbolic(call.Args[0]))
+}
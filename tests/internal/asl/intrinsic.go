@@ -0,0 +1,220 @@
+package asl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// intrinsic is a parsed intrinsic function call such as
+// "States.Format('s3://{}/{}', $.bucket, $.key)". Arguments are literal
+// values, *Path or *intrinsic.
+type intrinsic struct {
+	name string
+	args []interface{}
+}
+
+// intrinsics are the intrinsic functions the simulator evaluates, by name.
+var intrinsics = map[string]func(args []interface{}) (interface{}, error){
+	"States.Format": func(args []interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("needs a template")
+		}
+		tmpl, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("the template must be a string")
+		}
+		parts := strings.Split(tmpl, "{}")
+		if len(parts)-1 != len(args)-1 {
+			return nil, fmt.Errorf("%d placeholders for %d arguments", len(parts)-1, len(args)-1)
+		}
+		var sb strings.Builder
+		for i, p := range parts {
+			sb.WriteString(p)
+			if i < len(args)-1 {
+				switch a := args[i+1].(type) {
+				case string:
+					sb.WriteString(a)
+				default:
+					data, _ := json.Marshal(a)
+					sb.Write(data)
+				}
+			}
+		}
+		return sb.String(), nil
+	},
+	"States.StringToJson": func(args []interface{}) (interface{}, error) {
+		s, ok := one(args).(string)
+		if !ok {
+			return nil, fmt.Errorf("takes one string")
+		}
+		var out interface{}
+		err := json.Unmarshal([]byte(s), &out)
+		return out, err
+	},
+	"States.JsonToString": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes one argument")
+		}
+		data, err := json.Marshal(args[0])
+		return string(data), err
+	},
+	"States.Array": func(args []interface{}) (interface{}, error) {
+		return append([]interface{}{}, args...), nil
+	},
+	"States.ArrayLength": func(args []interface{}) (interface{}, error) {
+		list, ok := one(args).([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("takes one array")
+		}
+		return float64(len(list)), nil
+	},
+	"States.MathAdd": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("takes two numbers")
+		}
+		a, ok1 := args[0].(float64)
+		b, ok2 := args[1].(float64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("takes two numbers")
+		}
+		return a + b, nil
+	},
+	"States.UUID": func(args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("takes no arguments")
+		}
+		return "00000000-0000-4000-8000-000000000000", nil
+	},
+}
+
+func one(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return nil
+	}
+	return args[0]
+}
+
+// parseIntrinsic parses an intrinsic function call.
+func parseIntrinsic(s string) (*intrinsic, error) {
+	in, rest, err := parseCall(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", s, err)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("%q: unexpected %q after the call", s, rest)
+	}
+	return in, nil
+}
+
+func parseCall(s string) (*intrinsic, string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		return nil, "", fmt.Errorf("not a function call")
+	}
+	in := &intrinsic{name: strings.TrimSpace(s[:open])}
+	if _, ok := intrinsics[in.name]; !ok {
+		return nil, "", fmt.Errorf("unknown intrinsic function %s", in.name)
+	}
+	rest := strings.TrimSpace(s[open+1:])
+	if strings.HasPrefix(rest, ")") {
+		return in, rest[1:], nil
+	}
+	for {
+		arg, r, err := parseArg(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		in.args = append(in.args, arg)
+		r = strings.TrimSpace(r)
+		switch {
+		case strings.HasPrefix(r, ","):
+			rest = strings.TrimSpace(r[1:])
+		case strings.HasPrefix(r, ")"):
+			return in, r[1:], nil
+		default:
+			return nil, "", fmt.Errorf("expected , or ) in the arguments of %s", in.name)
+		}
+	}
+}
+
+func parseArg(s string) (interface{}, string, error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					sb.WriteByte(s[i])
+				}
+			case '\'':
+				return sb.String(), s[i+1:], nil
+			default:
+				sb.WriteByte(s[i])
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(s, "$"):
+		end := strings.IndexAny(s, ",)")
+		if end < 0 {
+			end = len(s)
+		}
+		p, err := ParsePath(strings.TrimSpace(s[:end]))
+		return p, s[end:], err
+	case strings.HasPrefix(s, "States."):
+		in, rest, err := parseCall(s)
+		return in, rest, err
+	}
+	end := strings.IndexAny(s, ",)")
+	if end < 0 {
+		end = len(s)
+	}
+	lit := strings.TrimSpace(s[:end])
+	switch lit {
+	case "true":
+		return true, s[end:], nil
+	case "false":
+		return false, s[end:], nil
+	case "null":
+		return nil, s[end:], nil
+	}
+	n, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid argument %q", lit)
+	}
+	return n, s[end:], nil
+}
+
+func (in *intrinsic) eval(input, ctx interface{}) (interface{}, error) {
+	var args []interface{}
+	for _, a := range in.args {
+		switch v := a.(type) {
+		case *Path:
+			doc := input
+			if v.Context {
+				doc = ctx
+			}
+			got, err := v.Get(doc)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, got)
+		case *intrinsic:
+			got, err := v.eval(input, ctx)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, got)
+		default:
+			args = append(args, v)
+		}
+	}
+	out, err := intrinsics[in.name](args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", in.name, err)
+	}
+	return out, nil
+}
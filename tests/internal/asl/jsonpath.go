@@ -0,0 +1,185 @@
+package asl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a JSONPath as Amazon States Language uses it: "$" for the state
+// input or "$$" for the context object, followed by field names (.name or
+// ['name']), array indexes ([0]) and wildcards (.* or [*]). Filter
+// expressions and slices are not supported.
+type Path struct {
+	Raw     string
+	Context bool // the path starts with $$
+	steps   []pathStep
+}
+
+type pathStep struct {
+	name     string
+	index    int // when isIndex
+	isIndex  bool
+	wildcard bool
+}
+
+// ParsePath parses a JSONPath.
+func ParsePath(s string) (*Path, error) {
+	p := &Path{Raw: s}
+	i := 0
+	switch {
+	case strings.HasPrefix(s, "$$"):
+		p.Context, i = true, 2
+	case strings.HasPrefix(s, "$"):
+		i = 1
+	default:
+		return nil, fmt.Errorf("%q does not start with $", s)
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			i++
+			if i < len(s) && s[i] == '*' {
+				p.steps = append(p.steps, pathStep{wildcard: true})
+				i++
+				continue
+			}
+			j := i
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				if strings.ContainsRune(" \t\n'\"]()?@", rune(s[j])) {
+					return nil, fmt.Errorf("%q has %q in a field name", s, s[j])
+				}
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("%q has an empty field name", s)
+			}
+			p.steps = append(p.steps, pathStep{name: s[i:j]})
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unclosed [", s)
+			}
+			inner := s[i+1 : i+end]
+			switch {
+			case inner == "*":
+				p.steps = append(p.steps, pathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p.steps = append(p.steps, pathStep{name: inner[1 : len(inner)-1]})
+			case strings.HasPrefix(inner, "?"):
+				return nil, fmt.Errorf("%q: filter expressions are not supported", s)
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("%q has an invalid subscript [%s]", s, inner)
+				}
+				p.steps = append(p.steps, pathStep{index: n, isIndex: true})
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("%q has an unexpected %q", s, s[i])
+		}
+	}
+	return p, nil
+}
+
+// Reference reports whether the path names a single node: it has no
+// wildcards. ResultPath must be a reference path.
+func (p *Path) Reference() bool {
+	for _, st := range p.steps {
+		if st.wildcard {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Path) String() string {
+	return p.Raw
+}
+
+// Get returns the node the path selects in doc. Wildcards select a list of
+// the matching nodes. A missing node is an error, as it is for Step
+// Functions.
+func (p *Path) Get(doc interface{}) (interface{}, error) {
+	nodes := []interface{}{doc}
+	multi := false
+	for _, st := range p.steps {
+		var next []interface{}
+		for _, n := range nodes {
+			switch {
+			case st.wildcard:
+				multi = true
+				switch v := n.(type) {
+				case map[string]interface{}:
+					for _, k := range sortedKeys(v) {
+						next = append(next, v[k])
+					}
+				case []interface{}:
+					next = append(next, v...)
+				}
+			case st.isIndex:
+				list, ok := n.([]interface{})
+				if ok && st.index < len(list) {
+					next = append(next, list[st.index])
+				} else if !multi {
+					return nil, fmt.Errorf("%s: no element %d", p.Raw, st.index)
+				}
+			default:
+				obj, ok := n.(map[string]interface{})
+				v, found := obj[st.name]
+				if ok && found {
+					next = append(next, v)
+				} else if !multi {
+					return nil, fmt.Errorf("%s: no field %q", p.Raw, st.name)
+				}
+			}
+		}
+		nodes = next
+	}
+	if multi {
+		if nodes == nil {
+			nodes = []interface{}{}
+		}
+		return nodes, nil
+	}
+	return nodes[0], nil
+}
+
+// Set returns a copy of doc with the node the path names replaced by value,
+// creating the objects on the way. "$" replaces the whole document.
+func (p *Path) Set(doc, value interface{}) (interface{}, error) {
+	if p.Context || !p.Reference() {
+		return nil, fmt.Errorf("%s: not a reference path into the input", p.Raw)
+	}
+	return set(doc, p.steps, value, p.Raw)
+}
+
+func set(doc interface{}, steps []pathStep, value interface{}, raw string) (interface{}, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	st := steps[0]
+	if st.isIndex {
+		list, ok := doc.([]interface{})
+		if !ok || st.index >= len(list) {
+			return nil, fmt.Errorf("%s: no element %d", raw, st.index)
+		}
+		out := append([]interface{}{}, list...)
+		v, err := set(list[st.index], steps[1:], value, raw)
+		out[st.index] = v
+		return out, err
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok && doc != nil {
+		return nil, fmt.Errorf("%s: cannot set %q on a %T", raw, st.name, doc)
+	}
+	out := make(map[string]interface{}, len(obj)+1)
+	for k, v := range obj {
+		out[k] = v
+	}
+	v, err := set(obj[st.name], steps[1:], value, raw)
+	out[st.name] = v
+	return out, err
+}
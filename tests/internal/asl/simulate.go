@@ -0,0 +1,705 @@
+package asl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Execution statuses.
+const (
+	Succeeded = "SUCCEEDED"
+	Failed    = "FAILED"
+)
+
+// maxTransitions bounds a simulated execution, so that loops end.
+const maxTransitions = 1000
+
+// Failure is an error a task fails with, e.g.
+// &Failure{Name: "Lambda.ServiceException", Cause: "Rate exceeded"}.
+type Failure struct {
+	Name  string
+	Cause string
+}
+
+func (f *Failure) Error() string {
+	return f.Name + ": " + f.Cause
+}
+
+// TaskFunc runs a Task state in the simulator. It gets the state, the
+// effective parameters and the attempt number, starting at 1, and returns
+// the task result or a *Failure to fail the attempt with. Other errors stop
+// the simulation.
+type TaskFunc func(st *State, params interface{}, attempt int) (interface{}, error)
+
+// Stubs is a TaskFunc built from canned results. Tasks of the
+// arn:aws:states:::lambda:invoke integration return their result as the
+// Payload of an Invoke response.
+type Stubs struct {
+	// Results are the results of each Task state, by state name. States
+	// without one return {}.
+	Results map[string]interface{}
+	// Failures are the failures of the first attempts of each Task state,
+	// in order. Attempts after them succeed.
+	Failures map[string][]Failure
+}
+
+// Run implements TaskFunc.
+func (s Stubs) Run(st *State, params interface{}, attempt int) (interface{}, error) {
+	if fs := s.Failures[st.Name]; attempt <= len(fs) {
+		f := fs[attempt-1]
+		return nil, &f
+	}
+	result, ok := s.Results[st.Name]
+	if !ok {
+		result = map[string]interface{}{}
+	}
+	if strings.HasPrefix(st.Resource, "arn:aws:states:::lambda:invoke") {
+		return map[string]interface{}{"ExecutedVersion": "$LATEST", "Payload": result, "StatusCode": float64(200)}, nil
+	}
+	return result, nil
+}
+
+// Step is a state entered during an execution, or one attempt of a Task,
+// Parallel or Map state.
+type Step struct {
+	State   string // e.g. "ClockDomainAnalysis" or "Fanout.Branches[1].Load"
+	Attempt int
+	Error   string // set when the attempt failed
+	Cause   string
+	// RetryAfter is the wait before the next attempt, when the failure is
+	// retried.
+	RetryAfter time.Duration
+	// CaughtBy is the Next state of the catcher that handled the failure.
+	CaughtBy string
+}
+
+func (s Step) String() string {
+	switch {
+	case s.Error == "":
+		return s.State
+	case s.RetryAfter > 0:
+		return fmt.Sprintf("%s (%s, retry in %s)", s.State, s.Error, s.RetryAfter)
+	case s.CaughtBy != "":
+		return fmt.Sprintf("%s (%s, caught)", s.State, s.Error)
+	}
+	return fmt.Sprintf("%s (%s)", s.State, s.Error)
+}
+
+// Execution is the result of a simulated run.
+type Execution struct {
+	Status string
+	Steps  []Step
+	Output interface{} // the final output document, when Succeeded
+	Error  string      // when Failed
+	Cause  string
+}
+
+// Path returns the states the execution went through, once per visit.
+func (ex *Execution) Path() []string {
+	var out []string
+	for _, s := range ex.Steps {
+		if s.Attempt <= 1 {
+			out = append(out, s.State)
+		}
+	}
+	return out
+}
+
+func (ex *Execution) String() string {
+	var steps []string
+	for _, s := range ex.Steps {
+		steps = append(steps, s.String())
+	}
+	out := strings.Join(steps, " -> ") + ": " + ex.Status
+	if ex.Status == Failed {
+		out += fmt.Sprintf(" (%s: %s)", ex.Error, ex.Cause)
+	}
+	return out
+}
+
+// Simulate runs the state machine on an input document, calling run for
+// every Task state. Paths that select nothing fail the execution with
+// States.Runtime, as they do in Step Functions. The error is for
+// definitions the simulator cannot run.
+func (sm *StateMachine) Simulate(input interface{}, run TaskFunc) (*Execution, error) {
+	ex := &Execution{}
+	r := &runner{ex: ex, run: run, ctx: map[string]interface{}{
+		"Execution": map[string]interface{}{
+			"Id":        "arn:aws:states:local:000000000000:execution:simulation:1",
+			"Name":      "1",
+			"Input":     input,
+			"StartTime": "2026-01-01T00:00:00Z",
+		},
+		"StateMachine": map[string]interface{}{"Id": "arn:aws:states:local:000000000000:stateMachine:simulation", "Name": "simulation"},
+	}}
+	out, fail, err := r.machine("", sm, input)
+	if err != nil {
+		return ex, err
+	}
+	if fail != nil {
+		ex.Status, ex.Error, ex.Cause = Failed, fail.Name, fail.Cause
+		return ex, nil
+	}
+	ex.Status, ex.Output = Succeeded, out
+	return ex, nil
+}
+
+type runner struct {
+	ex          *Execution
+	run         TaskFunc
+	ctx         map[string]interface{}
+	transitions int
+}
+
+// runtimeError is a failure no Retry or Catch handles.
+const runtimeError = "States.Runtime"
+
+// machine runs a state machine, or a branch or iterator within one, and
+// returns its output or the failure it ended with.
+func (r *runner) machine(prefix string, sm *StateMachine, input interface{}) (interface{}, *Failure, error) {
+	doc := input
+	name := sm.StartAt
+	for {
+		r.transitions++
+		if r.transitions > maxTransitions {
+			return nil, nil, fmt.Errorf("more than %d transitions; the machine does not terminate", maxTransitions)
+		}
+		st := sm.States[name]
+		if st == nil {
+			return nil, nil, fmt.Errorf("%s%s: no such state", prefix, name)
+		}
+		at := prefix + name
+		r.ctx["State"] = map[string]interface{}{"Name": name, "EnteredTime": "2026-01-01T00:00:00Z", "RetryCount": float64(0)}
+		out, next, fail, err := r.state(at, sm, st, doc)
+		if err != nil || fail != nil {
+			return nil, fail, err
+		}
+		if next == "" {
+			if st.Type == "Succeed" || st.End {
+				return out, nil, nil
+			}
+			return nil, nil, fmt.Errorf("%s: no next state", at)
+		}
+		doc, name = out, next
+	}
+}
+
+// state runs one state and returns its output and the next state.
+func (r *runner) state(at string, sm *StateMachine, st *State, raw interface{}) (interface{}, string, *Failure, error) {
+	input, fail := r.apply(st.InputPath, raw)
+	if fail != nil {
+		r.step(Step{State: at, Attempt: 1, Error: fail.Name, Cause: fail.Cause})
+		return nil, "", fail, nil
+	}
+	switch st.Type {
+	case "Succeed", "Wait":
+		r.step(Step{State: at, Attempt: 1})
+		out, fail := r.apply(st.OutputPath, input)
+		return out, st.Next, fail, nil
+	case "Fail":
+		r.step(Step{State: at, Attempt: 1, Error: st.Error, Cause: st.Cause})
+		return nil, "", &Failure{Name: st.Error, Cause: st.Cause}, nil
+	case "Choice":
+		r.step(Step{State: at, Attempt: 1})
+		next := st.Default
+		for _, c := range st.Choices {
+			rule, err := compileRule(c.Rule)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("%s: %w", at, err)
+			}
+			if rule.eval(input, r.ctx) {
+				next = c.Next
+				break
+			}
+		}
+		if next == "" {
+			return nil, "", &Failure{Name: "States.NoChoiceMatched", Cause: at + ": no choice rule matched and there is no Default"}, nil
+		}
+		out, fail := r.apply(st.OutputPath, input)
+		return out, next, fail, nil
+	case "Pass":
+		r.step(Step{State: at, Attempt: 1})
+		result := input
+		if st.Parameters != nil {
+			var fail *Failure
+			if result, fail = r.template(st.Parameters, input); fail != nil {
+				return nil, "", fail, nil
+			}
+		}
+		if st.hasResult {
+			result = st.Result
+		}
+		out, fail := r.output(st, raw, result)
+		return out, st.Next, fail, nil
+	case "Task", "Parallel", "Map":
+		params := input
+		if st.Parameters != nil && st.Type != "Map" {
+			var fail *Failure
+			if params, fail = r.template(st.Parameters, input); fail != nil {
+				r.step(Step{State: at, Attempt: 1, Error: fail.Name, Cause: fail.Cause})
+				return nil, "", fail, nil
+			}
+		}
+		return r.attempts(at, st, raw, func(attempt int) (interface{}, *Failure, error) {
+			return r.invoke(at, st, params, attempt)
+		})
+	}
+	return nil, "", nil, fmt.Errorf("%s: cannot simulate a %q state", at, st.Type)
+}
+
+// invoke runs one attempt of a Task, Parallel or Map state.
+func (r *runner) invoke(at string, st *State, params interface{}, attempt int) (interface{}, *Failure, error) {
+	switch st.Type {
+	case "Task":
+		result, err := r.run(st, params, attempt)
+		var f *Failure
+		if errors.As(err, &f) {
+			return nil, f, nil
+		}
+		return result, nil, err
+	case "Parallel":
+		var out []interface{}
+		for i, b := range st.Branches {
+			result, fail, err := r.machine(fmt.Sprintf("%s.Branches[%d].", at, i), b, params)
+			if err != nil || fail != nil {
+				return nil, fail, err
+			}
+			out = append(out, result)
+		}
+		return out, nil, nil
+	}
+	items, fail := r.apply(st.ItemsPath, params)
+	if fail != nil {
+		return nil, fail, nil
+	}
+	list, ok := items.([]interface{})
+	if !ok {
+		return nil, &Failure{Name: runtimeError, Cause: fmt.Sprintf("%s: ItemsPath %s is not an array", at, st.ItemsPath)}, nil
+	}
+	out := []interface{}{}
+	for i, item := range list {
+		// Parameters select each item's input, with the item in $$.Map.Item.
+		r.ctx["Map"] = map[string]interface{}{"Item": map[string]interface{}{"Index": float64(i), "Value": item}}
+		if st.Parameters != nil {
+			if item, fail = r.template(st.Parameters, params); fail != nil {
+				return nil, fail, nil
+			}
+		}
+		delete(r.ctx, "Map")
+		result, fail, err := r.machine(fmt.Sprintf("%s.Items[%d].", at, i), st.Iterator, item)
+		if err != nil || fail != nil {
+			return nil, fail, err
+		}
+		out = append(out, result)
+	}
+	return out, nil, nil
+}
+
+// attempts runs a state until an attempt succeeds, a failure is caught or
+// no retrier applies, and returns the output and next state.
+func (r *runner) attempts(at string, st *State, raw interface{}, try func(attempt int) (interface{}, *Failure, error)) (interface{}, string, *Failure, error) {
+	retries := make([]int, len(st.Retry))
+	for attempt := 1; ; attempt++ {
+		r.ctx["State"].(map[string]interface{})["RetryCount"] = float64(attempt - 1)
+		i := len(r.ex.Steps)
+		r.step(Step{State: at, Attempt: attempt})
+		result, fail, err := try(attempt)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if fail == nil {
+			if st.ResultSelector != nil {
+				if result, fail = r.template(st.ResultSelector, result); fail != nil {
+					return nil, "", fail, nil
+				}
+			}
+			out, fail := r.output(st, raw, result)
+			return out, st.Next, fail, nil
+		}
+
+		step := &r.ex.Steps[i]
+		step.Error, step.Cause = fail.Name, fail.Cause
+		if fail.Name == runtimeError {
+			return nil, "", fail, nil
+		}
+		if j := retrier(st.Retry, fail.Name); j >= 0 && retries[j] < st.Retry[j].MaxAttempts {
+			rt := st.Retry[j]
+			wait := rt.IntervalSeconds * math.Pow(rt.BackoffRate, float64(retries[j]))
+			step.RetryAfter = time.Duration(wait * float64(time.Second))
+			retries[j]++
+			continue
+		}
+		for _, c := range st.Catch {
+			if !matchesError(c.ErrorEquals, fail.Name) {
+				continue
+			}
+			step.CaughtBy = c.Next
+			out := raw
+			if c.ResultPath != "" {
+				p, err := ParsePath(c.ResultPath)
+				if err != nil {
+					return nil, "", nil, fmt.Errorf("%s: %w", at, err)
+				}
+				if out, err = p.Set(raw, map[string]interface{}{"Error": fail.Name, "Cause": fail.Cause}); err != nil {
+					return nil, "", &Failure{Name: runtimeError, Cause: err.Error()}, nil
+				}
+			}
+			return out, c.Next, nil, nil
+		}
+		return nil, "", fail, nil
+	}
+}
+
+// retrier returns the index of the first retrier for an error, or -1.
+func retrier(retry []Retrier, errName string) int {
+	for i, rt := range retry {
+		if matchesError(rt.ErrorEquals, errName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchesError reports whether ErrorEquals covers an error. States.ALL
+// covers every error and States.TaskFailed every error but States.Timeout.
+func matchesError(errorEquals []string, errName string) bool {
+	for _, e := range errorEquals {
+		switch {
+		case e == errName, e == "States.ALL":
+			return true
+		case e == "States.TaskFailed" && errName != "States.Timeout":
+			return true
+		}
+	}
+	return false
+}
+
+func (r *runner) step(s Step) {
+	r.ex.Steps = append(r.ex.Steps, s)
+}
+
+// output applies ResultPath and OutputPath to a state's result.
+func (r *runner) output(st *State, raw, result interface{}) (interface{}, *Failure) {
+	out := raw
+	if st.ResultPath != "" {
+		p, err := ParsePath(st.ResultPath)
+		if err == nil {
+			out, err = p.Set(raw, result)
+		}
+		if err != nil {
+			return nil, &Failure{Name: runtimeError, Cause: err.Error()}
+		}
+	}
+	return r.apply(st.OutputPath, out)
+}
+
+// apply selects an InputPath, OutputPath or ItemsPath; null selects {}.
+func (r *runner) apply(path string, doc interface{}) (interface{}, *Failure) {
+	if path == "" {
+		return map[string]interface{}{}, nil
+	}
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, &Failure{Name: runtimeError, Cause: err.Error()}
+	}
+	src := doc
+	if p.Context {
+		src = r.ctx
+	}
+	out, err := p.Get(src)
+	if err != nil {
+		return nil, &Failure{Name: runtimeError, Cause: err.Error()}
+	}
+	return out, nil
+}
+
+// template evaluates a payload template against the effective input.
+func (r *runner) template(tmpl, input interface{}) (interface{}, *Failure) {
+	switch t := tmpl.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			if !strings.HasSuffix(k, ".$") {
+				got, fail := r.template(v, input)
+				if fail != nil {
+					return nil, fail
+				}
+				out[k] = got
+				continue
+			}
+			s, _ := v.(string)
+			d, err := dynamic(s)
+			if err != nil {
+				return nil, &Failure{Name: runtimeError, Cause: err.Error()}
+			}
+			var got interface{}
+			switch d := d.(type) {
+			case *Path:
+				src := input
+				if d.Context {
+					src = r.ctx
+				}
+				got, err = d.Get(src)
+			case *intrinsic:
+				got, err = d.eval(input, r.ctx)
+			}
+			if err != nil {
+				return nil, &Failure{Name: runtimeError, Cause: fmt.Sprintf("%s: %v", k, err)}
+			}
+			out[strings.TrimSuffix(k, ".$")] = got
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, 0, len(t))
+		for _, e := range t {
+			got, fail := r.template(e, input)
+			if fail != nil {
+				return nil, fail
+			}
+			out = append(out, got)
+		}
+		return out, nil
+	}
+	return tmpl, nil
+}
+
+// choiceRule is a compiled Choice rule.
+type choiceRule struct {
+	and, or []*choiceRule
+	not     *choiceRule
+
+	variable  *Path
+	op        string      // comparison without the Path suffix
+	value     interface{} // the literal to compare with
+	valuePath *Path       // or the path to it
+}
+
+func compileRule(rule map[string]interface{}) (*choiceRule, error) {
+	c := &choiceRule{}
+	for _, key := range []string{"And", "Or"} {
+		v, ok := rule[key]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s must be a non-empty array of rules", key)
+		}
+		for _, e := range list {
+			sub, _ := e.(map[string]interface{})
+			compiled, err := compileRule(sub)
+			if err != nil {
+				return nil, err
+			}
+			if key == "And" {
+				c.and = append(c.and, compiled)
+			} else {
+				c.or = append(c.or, compiled)
+			}
+		}
+		return c, nil
+	}
+	if v, ok := rule["Not"]; ok {
+		sub, _ := v.(map[string]interface{})
+		compiled, err := compileRule(sub)
+		if err != nil {
+			return nil, err
+		}
+		c.not = compiled
+		return c, nil
+	}
+
+	s, _ := rule["Variable"].(string)
+	if s == "" {
+		return nil, fmt.Errorf("a rule needs a Variable, And, Or or Not")
+	}
+	p, err := ParsePath(s)
+	if err != nil {
+		return nil, err
+	}
+	c.variable = p
+	for k, v := range rule {
+		if k == "Variable" {
+			continue
+		}
+		op := strings.TrimSuffix(k, "Path")
+		if !comparisons[op] {
+			return nil, fmt.Errorf("unknown comparison %s", k)
+		}
+		if c.op != "" {
+			return nil, fmt.Errorf("a rule has one comparison")
+		}
+		c.op = op
+		if op != k {
+			ps, _ := v.(string)
+			if c.valuePath, err = ParsePath(ps); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := checkOperand(op, v); err != nil {
+			return nil, err
+		}
+		c.value = v
+	}
+	if c.op == "" {
+		return nil, fmt.Errorf("%s has no comparison", s)
+	}
+	return c, nil
+}
+
+func checkOperand(op string, v interface{}) error {
+	var ok bool
+	switch {
+	case strings.HasPrefix(op, "Is"), op == "BooleanEquals":
+		_, ok = v.(bool)
+	case strings.HasPrefix(op, "Numeric"):
+		_, ok = v.(float64)
+	case strings.HasPrefix(op, "Timestamp"):
+		var s string
+		if s, ok = v.(string); ok {
+			_, err := time.Parse(time.RFC3339, s)
+			ok = err == nil
+		}
+	default:
+		_, ok = v.(string)
+	}
+	if !ok {
+		return fmt.Errorf("%s: invalid operand %v", op, v)
+	}
+	return nil
+}
+
+func (c *choiceRule) eval(input interface{}, ctx map[string]interface{}) bool {
+	switch {
+	case c.and != nil:
+		for _, sub := range c.and {
+			if !sub.eval(input, ctx) {
+				return false
+			}
+		}
+		return true
+	case c.or != nil:
+		for _, sub := range c.or {
+			if sub.eval(input, ctx) {
+				return true
+			}
+		}
+		return false
+	case c.not != nil:
+		return !c.not.eval(input, ctx)
+	}
+
+	get := func(p *Path) (interface{}, bool) {
+		src := input
+		if p.Context {
+			src = ctx
+		}
+		v, err := p.Get(src)
+		return v, err == nil
+	}
+	v, present := get(c.variable)
+	if c.op == "IsPresent" {
+		return present == c.value.(bool)
+	}
+	if !present {
+		return false
+	}
+	want := c.value
+	if c.valuePath != nil {
+		var ok bool
+		if want, ok = get(c.valuePath); !ok {
+			return false
+		}
+	}
+	switch c.op {
+	case "IsNull":
+		return (v == nil) == want.(bool)
+	case "IsString", "IsNumeric", "IsBoolean", "IsTimestamp":
+		var is bool
+		switch c.op {
+		case "IsString":
+			_, is = v.(string)
+		case "IsNumeric":
+			_, is = v.(float64)
+		case "IsBoolean":
+			_, is = v.(bool)
+		case "IsTimestamp":
+			s, ok := v.(string)
+			_, err := time.Parse(time.RFC3339, s)
+			is = ok && err == nil
+		}
+		return is == want.(bool)
+	case "BooleanEquals":
+		b, ok := v.(bool)
+		return ok && b == want
+	case "StringMatches":
+		s, ok1 := v.(string)
+		pattern, ok2 := want.(string)
+		return ok1 && ok2 && stringMatches(pattern, s)
+	}
+	if strings.HasPrefix(c.op, "Numeric") {
+		a, ok1 := v.(float64)
+		b, ok2 := want.(float64)
+		return ok1 && ok2 && compare(c.op, cmpFloat(a, b))
+	}
+	a, ok1 := v.(string)
+	b, ok2 := want.(string)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if strings.HasPrefix(c.op, "Timestamp") {
+		ta, err1 := time.Parse(time.RFC3339, a)
+		tb, err2 := time.Parse(time.RFC3339, b)
+		return err1 == nil && err2 == nil && compare(c.op, ta.Compare(tb))
+	}
+	return compare(c.op, strings.Compare(a, b))
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compare interprets a comparison result for an operator by its suffix.
+func compare(op string, c int) bool {
+	switch {
+	case strings.HasSuffix(op, "LessThanEquals"):
+		return c <= 0
+	case strings.HasSuffix(op, "GreaterThanEquals"):
+		return c >= 0
+	case strings.HasSuffix(op, "LessThan"):
+		return c < 0
+	case strings.HasSuffix(op, "GreaterThan"):
+		return c > 0
+	}
+	return c == 0
+}
+
+// stringMatches matches StringMatches patterns, where * matches any
+// sequence and \* a literal star.
+func stringMatches(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	if strings.HasPrefix(pattern, `\*`) {
+		return strings.HasPrefix(s, "*") && stringMatches(pattern[2:], s[1:])
+	}
+	if pattern[0] == '*' {
+		for i := 0; i <= len(s); i++ {
+			if stringMatches(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return s != "" && s[0] == pattern[0] && stringMatches(pattern[1:], s[1:])
+}
@@ -0,0 +1,336 @@
+package asl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problem is a defect in a definition.
+type Problem struct {
+	State  string // e.g. "HierarchyExtraction", "Fanout.Branches[0].Load", or "" for the machine
+	Field  string // e.g. "Next", "Retry[1]", "Parameters.Payload.stage.$"
+	Detail string
+}
+
+func (p Problem) String() string {
+	var parts []string
+	for _, s := range []string{p.State, p.Field, p.Detail} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+// stateTypes are the valid values of Type.
+var stateTypes = map[string]bool{
+	"Task": true, "Pass": true, "Choice": true, "Wait": true,
+	"Succeed": true, "Fail": true, "Parallel": true, "Map": true,
+}
+
+// Validate checks the definition, and its Parallel branches and Map
+// iterators:
+//
+//   - StartAt and every Next, Default and Catch target name a state.
+//   - Every state but Choice, Succeed and Fail has exactly one of Next and
+//     End; every state is reachable from StartAt and can reach a terminal
+//     state.
+//   - Paths are valid JSONPath, ResultPath a reference path into the input,
+//     and every ".$" field of Parameters and ResultSelector a path or a
+//     known intrinsic function.
+//   - In Retry and Catch, every ErrorEquals is non-empty and States.ALL
+//     appears alone in the last element; retriers have sane limits.
+func (sm *StateMachine) Validate() []Problem {
+	v := &validator{}
+	v.problems = append(v.problems, sm.problems...)
+	v.machine("", sm)
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].State < v.problems[j].State })
+	return v.problems
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(state, field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{State: state, Field: field, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) machine(prefix string, sm *StateMachine) {
+	where := strings.TrimSuffix(prefix, ".")
+	switch {
+	case len(sm.States) == 0:
+		v.add(where, "States", "no states")
+		return
+	case sm.StartAt == "":
+		v.add(where, "StartAt", "missing")
+	case sm.States[sm.StartAt] == nil:
+		v.add(where, "StartAt", "no state %q", sm.StartAt)
+	}
+	for _, name := range sm.Names() {
+		v.state(prefix+name, sm, sm.States[name])
+	}
+
+	reachable := sm.reachable()
+	for _, name := range sm.Names() {
+		if !reachable[name] {
+			v.add(prefix+name, "", "unreachable from StartAt")
+		}
+	}
+	finishes := sm.finishing()
+	for _, name := range sm.Names() {
+		if reachable[name] && !finishes[name] {
+			v.add(prefix+name, "", "cannot reach a terminal state")
+		}
+	}
+}
+
+func (v *validator) state(at string, sm *StateMachine, st *State) {
+	if !stateTypes[st.Type] {
+		v.add(at, "Type", "unknown state type %q", st.Type)
+		return
+	}
+	v.target(at, "Next", sm, st.Next)
+	switch st.Type {
+	case "Choice":
+		if len(st.Choices) == 0 {
+			v.add(at, "Choices", "a Choice state needs at least one rule")
+		}
+		for i, c := range st.Choices {
+			field := fmt.Sprintf("Choices[%d]", i)
+			if c.Next == "" {
+				v.add(at, field, "no Next")
+			}
+			v.target(at, field+".Next", sm, c.Next)
+			v.rule(at, field, c.Rule)
+		}
+		v.target(at, "Default", sm, st.Default)
+		if st.has["Next"] || st.has["End"] {
+			v.add(at, "", "a Choice state must have neither Next nor End")
+		}
+	case "Succeed", "Fail":
+		if st.has["Next"] || st.has["End"] {
+			v.add(at, "", "a %s state is terminal and must have neither Next nor End", st.Type)
+		}
+	default:
+		switch {
+		case st.Next != "" && st.End:
+			v.add(at, "", "has both Next and End")
+		case st.Next == "" && !st.End:
+			v.add(at, "", "has neither Next nor End")
+		}
+	}
+	if st.Type == "Task" && st.Resource == "" {
+		v.add(at, "Resource", "a Task state needs a Resource")
+	}
+
+	v.path(at, "InputPath", st.InputPath, false)
+	v.path(at, "OutputPath", st.OutputPath, false)
+	v.path(at, "ResultPath", st.ResultPath, true)
+	if st.Type == "Map" {
+		v.path(at, "ItemsPath", st.ItemsPath, false)
+	}
+	v.template(at, "Parameters", st.Parameters)
+	v.template(at, "ResultSelector", st.ResultSelector)
+
+	if len(st.Retry) > 0 || len(st.Catch) > 0 {
+		switch st.Type {
+		case "Task", "Parallel", "Map":
+		default:
+			v.add(at, "", "a %s state cannot have Retry or Catch", st.Type)
+		}
+	}
+	for i, r := range st.Retry {
+		field := fmt.Sprintf("Retry[%d]", i)
+		v.errorEquals(at, field, r.ErrorEquals, i == len(st.Retry)-1)
+		if r.MaxAttempts < 0 {
+			v.add(at, field, "MaxAttempts must not be negative")
+		}
+		if r.BackoffRate < 1 {
+			v.add(at, field, "BackoffRate must be at least 1")
+		}
+		if r.IntervalSeconds < 1 || r.IntervalSeconds != float64(int(r.IntervalSeconds)) {
+			v.add(at, field, "IntervalSeconds must be a positive integer")
+		}
+	}
+	for i, c := range st.Catch {
+		field := fmt.Sprintf("Catch[%d]", i)
+		v.errorEquals(at, field, c.ErrorEquals, i == len(st.Catch)-1)
+		if c.Next == "" {
+			v.add(at, field, "no Next")
+		}
+		v.target(at, field+".Next", sm, c.Next)
+		v.path(at, field+".ResultPath", c.ResultPath, true)
+	}
+
+	for i, b := range st.Branches {
+		v.machine(fmt.Sprintf("%s.Branches[%d].", at, i), b)
+	}
+	switch {
+	case st.Type == "Parallel" && len(st.Branches) == 0:
+		v.add(at, "Branches", "a Parallel state needs at least one branch")
+	case st.Type == "Map" && st.Iterator == nil:
+		v.add(at, "ItemProcessor", "a Map state needs an ItemProcessor")
+	case st.Iterator != nil:
+		key := "ItemProcessor"
+		if st.has["Iterator"] {
+			key = "Iterator"
+		}
+		v.machine(at+"."+key+".", st.Iterator)
+	}
+}
+
+func (v *validator) target(at, field string, sm *StateMachine, next string) {
+	if next != "" && sm.States[next] == nil {
+		v.add(at, field, "no state %q", next)
+	}
+}
+
+// path checks a path field; reference paths are those ResultPath takes.
+func (v *validator) path(at, field, s string, reference bool) {
+	if s == "" {
+		return // null
+	}
+	p, err := ParsePath(s)
+	switch {
+	case err != nil:
+		v.add(at, field, "%v", err)
+	case reference && p.Context:
+		v.add(at, field, "%q cannot write to the context object", s)
+	case reference && !p.Reference():
+		v.add(at, field, "%q is not a reference path", s)
+	}
+}
+
+// template checks the ".$" fields of a payload template.
+func (v *validator) template(at, field string, tmpl interface{}) {
+	switch t := tmpl.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			f := field + "." + k
+			if !strings.HasSuffix(k, ".$") {
+				v.template(at, f, t[k])
+				continue
+			}
+			s, ok := t[k].(string)
+			if !ok {
+				v.add(at, f, "must be a path or an intrinsic function")
+				continue
+			}
+			if _, err := dynamic(s); err != nil {
+				v.add(at, f, "%v", err)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			v.template(at, fmt.Sprintf("%s[%d]", field, i), e)
+		}
+	}
+}
+
+func (v *validator) errorEquals(at, field string, errs []string, last bool) {
+	if len(errs) == 0 {
+		v.add(at, field, "ErrorEquals must not be empty")
+	}
+	for _, e := range errs {
+		if e != "States.ALL" {
+			continue
+		}
+		if len(errs) > 1 {
+			v.add(at, field, "States.ALL must appear alone in ErrorEquals")
+		}
+		if !last {
+			v.add(at, field, "States.ALL must be in the last element; the ones after it never apply")
+		}
+	}
+}
+
+// comparisons are the Choice rule operators, without their Path variants.
+var comparisons = map[string]bool{
+	"StringEquals": true, "StringLessThan": true, "StringGreaterThan": true,
+	"StringLessThanEquals": true, "StringGreaterThanEquals": true, "StringMatches": true,
+	"NumericEquals": true, "NumericLessThan": true, "NumericGreaterThan": true,
+	"NumericLessThanEquals": true, "NumericGreaterThanEquals": true,
+	"BooleanEquals":   true,
+	"TimestampEquals": true, "TimestampLessThan": true, "TimestampGreaterThan": true,
+	"TimestampLessThanEquals": true, "TimestampGreaterThanEquals": true,
+	"IsNull": true, "IsPresent": true, "IsNumeric": true, "IsString": true, "IsBoolean": true, "IsTimestamp": true,
+}
+
+func (v *validator) rule(at, field string, rule map[string]interface{}) {
+	if _, err := compileRule(rule); err != nil {
+		v.add(at, field, "%v", err)
+	}
+}
+
+// dynamic parses the value of a ".$" field: a path or an intrinsic
+// function.
+func dynamic(s string) (interface{}, error) {
+	if strings.HasPrefix(s, "$") {
+		return ParsePath(s)
+	}
+	return parseIntrinsic(s)
+}
+
+// reachable returns the states reachable from StartAt.
+func (sm *StateMachine) reachable() map[string]bool {
+	seen := map[string]bool{}
+	queue := []string{sm.StartAt}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] || sm.States[name] == nil {
+			continue
+		}
+		seen[name] = true
+		queue = append(queue, sm.States[name].transitions()...)
+	}
+	return seen
+}
+
+// finishing returns the states from which a terminal state is reachable.
+func (sm *StateMachine) finishing() map[string]bool {
+	done := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for name, st := range sm.States {
+			if done[name] {
+				continue
+			}
+			ok := st.End || st.Type == "Succeed" || st.Type == "Fail"
+			for _, next := range st.transitions() {
+				ok = ok || done[next]
+			}
+			if ok {
+				done[name] = true
+				changed = true
+			}
+		}
+	}
+	return done
+}
+
+// transitions returns the states a state may go to.
+func (st *State) transitions() []string {
+	var out []string
+	for _, n := range append([]string{st.Next, st.Default}, st.catchTargets()...) {
+		if n != "" {
+			out = append(out, n)
+		}
+	}
+	for _, c := range st.Choices {
+		if c.Next != "" {
+			out = append(out, c.Next)
+		}
+	}
+	return out
+}
+
+func (st *State) catchTargets() []string {
+	var out []string
+	for _, c := range st.Catch {
+		out = append(out, c.Next)
+	}
+	return out
+}
@@ -0,0 +1,379 @@
+// Package bedrockkb audits the Bedrock knowledge bases of a Terraform tree
+// and their data sources: embedding model ARNs against the region of the
+// provider they are created with, chunking strategies against the model's
+// input limit, S3 inclusion prefixes against the buckets and prefixes the
+// upload pipeline writes to, field mappings, encryption and deletion
+// policies. Uploads follow the replication rules of the tree, so a data
+// source that reads a replica bucket matches uploads to the source bucket.
+package bedrockkb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/replication"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// KnowledgeBase is an aws_bedrockagent_knowledge_base of the tree.
+type KnowledgeBase struct {
+	ID     string // node ID
+	Stack  string
+	Region string // region of its aws provider, or "" when not configured
+
+	Model         string // embedding_model_arn
+	StorageType   string
+	VectorField   string
+	TextField     string
+	MetadataField string
+
+	DataSources []*DataSource // sorted by ID
+}
+
+// DataSource is an aws_bedrockagent_data_source of a knowledge base.
+type DataSource struct {
+	ID                string // node ID
+	Type              string
+	Bucket            string // canonical ARN of an S3 data source's bucket
+	InclusionPrefixes []string
+	Chunking          Chunking
+	DeletionPolicy    string
+	KMSKey            string // canonical kms_key_arn of server_side_encryption_configuration
+}
+
+// Chunking is the chunking_configuration of a data source. Strategy is ""
+// when the data source leaves chunking to Bedrock.
+type Chunking struct {
+	Strategy string
+
+	MaxTokens         int // FIXED_SIZE and SEMANTIC
+	OverlapPercentage int // FIXED_SIZE
+
+	Levels        []int // HIERARCHICAL: max_tokens of the parent, then the child level
+	OverlapTokens int   // HIERARCHICAL
+
+	BufferSize                    int // SEMANTIC
+	BreakpointPercentileThreshold int // SEMANTIC
+}
+
+func (c Chunking) String() string {
+	switch c.Strategy {
+	case "":
+		return "default"
+	case "FIXED_SIZE":
+		return fmt.Sprintf("FIXED_SIZE %d tokens, %d%% overlap", c.MaxTokens, c.OverlapPercentage)
+	case "HIERARCHICAL":
+		return fmt.Sprintf("HIERARCHICAL %v tokens, %d overlap tokens", c.Levels, c.OverlapTokens)
+	case "SEMANTIC":
+		return fmt.Sprintf("SEMANTIC %d tokens, buffer %d, breakpoint %d", c.MaxTokens, c.BufferSize, c.BreakpointPercentileThreshold)
+	}
+	return c.Strategy
+}
+
+// Upload is a bucket and key prefix that uploaded documents land in.
+type Upload struct {
+	Bucket string // canonical ARN
+	Prefix string
+	Via    string // "" for an upload added with AddUpload, or the replication configuration that copies it
+}
+
+// Model is every knowledge base and data source of a tree, and the uploads
+// that reach each bucket.
+type Model struct {
+	KnowledgeBases []*KnowledgeBase // sorted by ID
+
+	// Unknown lists attributes that are set but not statically known.
+	Unknown []string
+
+	Tree        *tfconfig.Tree
+	Refs        *tfconfig.Graph
+	Replication *replication.Model
+
+	uploads []Upload
+}
+
+// Build collects the knowledge bases and data sources of every module in
+// the tree. Resources with count = 0 are skipped.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph(), Replication: replication.Build(tree)}
+	kbs := map[tfconfig.Node]*KnowledgeBase{}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_bedrockagent_knowledge_base")) {
+			kb := md.knowledgeBase(m, b)
+			kbs[tfconfig.Node{Module: m, Addr: b.Address()}] = kb
+			md.KnowledgeBases = append(md.KnowledgeBases, kb)
+		}
+	}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_bedrockagent_data_source")) {
+			ds := md.dataSource(m, b)
+			var kb *KnowledgeBase
+			for _, n := range md.Refs.ResolveExpr(m, b.Expr("knowledge_base_id")) {
+				if k, ok := kbs[n]; ok {
+					kb = k
+				}
+			}
+			if kb == nil {
+				md.unknown(ds.ID, b, "knowledge_base_id")
+				continue
+			}
+			kb.DataSources = append(kb.DataSources, ds)
+		}
+	}
+	sort.Slice(md.KnowledgeBases, func(i, j int) bool { return md.KnowledgeBases[i].ID < md.KnowledgeBases[j].ID })
+	for _, kb := range md.KnowledgeBases {
+		sort.Slice(kb.DataSources, func(i, j int) bool { return kb.DataSources[i].ID < kb.DataSources[j].ID })
+	}
+	sort.Strings(md.Unknown)
+	return md
+}
+
+// fieldMappings are the storage_configuration blocks with a field_mapping.
+var fieldMappings = []string{
+	"opensearch_serverless_configuration",
+	"rds_configuration",
+	"pinecone_configuration",
+	"redis_enterprise_cloud_configuration",
+	"mongo_db_atlas_configuration",
+}
+
+func (md *Model) knowledgeBase(m *tfconfig.Module, b *tfconfig.Block) *KnowledgeBase {
+	kb := &KnowledgeBase{ID: nodeID(m, b), Stack: stackOf(m).Name, Region: Region(m, b)}
+	for _, kc := range b.Nested("knowledge_base_configuration") {
+		for _, vc := range kc.Nested("vector_knowledge_base_configuration") {
+			kb.Model = md.str(kb.ID, vc, "embedding_model_arn")
+		}
+	}
+	for _, sc := range b.Nested("storage_configuration") {
+		kb.StorageType = md.str(kb.ID, sc, "type")
+		for _, typ := range fieldMappings {
+			for _, c := range sc.Nested(typ) {
+				for _, fm := range c.Nested("field_mapping") {
+					kb.VectorField = md.str(kb.ID, fm, "vector_field")
+					kb.TextField = md.str(kb.ID, fm, "text_field")
+					kb.MetadataField = md.str(kb.ID, fm, "metadata_field")
+				}
+			}
+		}
+	}
+	return kb
+}
+
+func (md *Model) dataSource(m *tfconfig.Module, b *tfconfig.Block) *DataSource {
+	ds := &DataSource{ID: nodeID(m, b)}
+	ds.DeletionPolicy = md.str(ds.ID, b, "data_deletion_policy")
+	for _, dc := range b.Nested("data_source_configuration") {
+		ds.Type = md.str(ds.ID, dc, "type")
+		for _, s3 := range dc.Nested("s3_configuration") {
+			if ds.Bucket = md.Replication.Canonical(m, s3.Expr("bucket_arn")); strings.Contains(ds.Bucket, "${") {
+				md.unknown(ds.ID, s3, "bucket_arn")
+			}
+			if s3.Has("inclusion_prefixes") {
+				var ok bool
+				if ds.InclusionPrefixes, ok = s3.Strings("inclusion_prefixes"); !ok {
+					md.unknown(ds.ID, s3, "inclusion_prefixes")
+				}
+			}
+		}
+	}
+	for _, sse := range b.Nested("server_side_encryption_configuration") {
+		if sse.Has("kms_key_arn") {
+			ds.KMSKey = md.Replication.Canonical(m, sse.Expr("kms_key_arn"))
+		}
+	}
+	for _, vic := range b.Nested("vector_ingestion_configuration") {
+		for _, cc := range vic.Nested("chunking_configuration") {
+			ds.Chunking = md.chunking(ds.ID, cc)
+		}
+	}
+	return ds
+}
+
+func (md *Model) chunking(id string, cc *tfconfig.Block) Chunking {
+	c := Chunking{Strategy: md.str(id, cc, "chunking_strategy")}
+	for _, fc := range cc.Nested("fixed_size_chunking_configuration") {
+		c.MaxTokens = md.int(id, fc, "max_tokens")
+		c.OverlapPercentage = md.int(id, fc, "overlap_percentage")
+	}
+	for _, hc := range cc.Nested("hierarchical_chunking_configuration") {
+		for _, lc := range hc.Nested("level_configuration") {
+			c.Levels = append(c.Levels, md.int(id, lc, "max_tokens"))
+		}
+		c.OverlapTokens = md.int(id, hc, "overlap_tokens")
+	}
+	for _, sc := range cc.Nested("semantic_chunking_configuration") {
+		c.MaxTokens = md.int(id, sc, "max_token")
+		c.BufferSize = md.int(id, sc, "buffer_size")
+		c.BreakpointPercentileThreshold = md.int(id, sc, "breakpoint_percentile_threshold")
+	}
+	return c
+}
+
+// AddUpload records that the upload pipeline writes documents under a
+// prefix of a bucket, given by name or ARN. The upload is copied to the
+// destination of every enabled replication rule whose prefix overlaps it,
+// and on from there.
+func (md *Model) AddUpload(bucket, prefix string) {
+	if !strings.HasPrefix(bucket, "arn:") {
+		bucket = "arn:aws:s3:::" + bucket
+	}
+	queue := []Upload{{Bucket: bucket, Prefix: prefix}}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if md.uploaded(u) {
+			continue
+		}
+		md.uploads = append(md.uploads, u)
+		for _, c := range md.Replication.Configs {
+			if c.Staged || c.Source != u.Bucket {
+				continue
+			}
+			for _, r := range c.Rules {
+				if !r.Enabled || !prefixesOverlap(u.Prefix, r.Prefix) {
+					continue
+				}
+				p := u.Prefix
+				if len(r.Prefix) > len(p) {
+					p = r.Prefix
+				}
+				queue = append(queue, Upload{Bucket: r.Destination, Prefix: p, Via: c.ID})
+			}
+		}
+	}
+}
+
+func (md *Model) uploaded(u Upload) bool {
+	for _, x := range md.uploads {
+		if x.Bucket == u.Bucket && x.Prefix == u.Prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// Uploads returns the uploads that reach a bucket, by canonical ARN.
+func (md *Model) Uploads(bucket string) []Upload {
+	var out []Upload
+	for _, u := range md.uploads {
+		if u.Bucket == bucket {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// Region returns the region of the aws provider a resource is created
+// with, following its provider argument and the providers maps of module
+// calls up to the stack, or "" when no provider configuration sets it.
+func Region(m *tfconfig.Module, b *tfconfig.Block) string {
+	name := "aws"
+	if b.Has("provider") {
+		name = traversalName(b.Expr("provider"))
+	}
+	for m != nil {
+		for _, p := range m.Blocks {
+			if p.Type != "provider" || len(p.Labels) != 1 || p.Labels[0] != "aws" {
+				continue
+			}
+			if alias, _ := p.String("alias"); (alias == "" && name == "aws") || "aws."+alias == name {
+				region, _ := p.String("region")
+				return region
+			}
+		}
+		if m.Call == nil {
+			break
+		}
+		if providers, ok := m.Call.Expr("providers").(*hclsyntax.ObjectConsExpr); ok {
+			for _, item := range providers.Items {
+				if traversalName(item.KeyExpr) == name {
+					name = traversalName(item.ValueExpr)
+				}
+			}
+		}
+		m = m.Parent
+	}
+	return ""
+}
+
+// traversalName returns a provider reference such as "aws.seoul", or "".
+func traversalName(expr hcl.Expression) string {
+	t, diags := hcl.AbsTraversalForExpr(expr)
+	if diags.HasErrors() {
+		return ""
+	}
+	name := t.RootName()
+	for _, step := range t[1:] {
+		if attr, ok := step.(hcl.TraverseAttr); ok {
+			name += "." + attr.Name
+		}
+	}
+	return name
+}
+
+// str returns a string attribute, recording it as unknown when it is set
+// but not statically known.
+func (md *Model) str(id string, b *tfconfig.Block, attr string) string {
+	if !b.Has(attr) {
+		return ""
+	}
+	s, ok := b.String(attr)
+	if !ok {
+		md.unknown(id, b, attr)
+	}
+	return s
+}
+
+// int is str for whole-number attributes.
+func (md *Model) int(id string, b *tfconfig.Block, attr string) int {
+	if !b.Has(attr) {
+		return 0
+	}
+	n, ok := b.Int(attr)
+	if !ok {
+		md.unknown(id, b, attr)
+	}
+	return n
+}
+
+// unknown records an attribute as "<id>: <attr> at <file>:<line>", with the
+// attribute prefixed by the block type for nested blocks.
+func (md *Model) unknown(id string, b *tfconfig.Block, attr string) {
+	if b.Type != "resource" {
+		attr = b.Type + "." + attr
+	}
+	rng := b.Body.SrcRange
+	md.Unknown = append(md.Unknown, fmt.Sprintf("%s: %s at %s:%d", id, attr, filepath.Base(rng.Filename), rng.Start.Line))
+}
+
+// prefixesOverlap reports whether some key can start with both prefixes.
+func prefixesOverlap(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+func stackOf(m *tfconfig.Module) *tfconfig.Module {
+	for m.Parent != nil {
+		m = m.Parent
+	}
+	return m
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,349 @@
+package bedrockkb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleTree is an app stack in us-east-1 whose uploads land in
+// docs-upload under inbox/docs/ and are replicated to docs-replica. The main
+// knowledge base reads the replica through a clean data source and the
+// upload bucket through one that gets everything wrong; the seoul knowledge
+// base and the search module, both created through the seoul provider,
+// embed with us-east-1 models.
+var sampleTree = map[string]string{
+	"environments/app/main.tf": `
+provider "aws" {
+  region = "us-east-1"
+}
+
+provider "aws" {
+  alias  = "seoul"
+  region = "ap-northeast-2"
+}
+
+variable "enable_archive" {
+  default = false
+}
+
+module "search" {
+  source = "../../modules/kb"
+  providers = {
+    aws = aws.seoul
+  }
+}
+
+resource "aws_kms_key" "kb" {}
+
+resource "aws_s3_bucket" "uploads" {
+  bucket = "docs-upload"
+}
+
+resource "aws_s3_bucket" "replica" {
+  bucket = "docs-replica"
+}
+
+resource "aws_s3_bucket_replication_configuration" "copy" {
+  bucket = aws_s3_bucket.uploads.id
+  role   = "arn:aws:iam::123456789012:role/replication"
+  rule {
+    id     = "inbox"
+    status = "Enabled"
+    filter {
+      prefix = "inbox/"
+    }
+    destination {
+      bucket = aws_s3_bucket.replica.arn
+    }
+  }
+}
+
+resource "aws_bedrockagent_knowledge_base" "main" {
+  name     = "main"
+  role_arn = "arn:aws:iam::123456789012:role/kb"
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v2:0"
+    }
+  }
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = "arn:aws:aoss:us-east-1:123456789012:collection/docs"
+      vector_index_name = "docs"
+      field_mapping {
+        vector_field   = "vector"
+        text_field     = "text"
+        metadata_field = "metadata"
+      }
+    }
+  }
+}
+
+resource "aws_bedrockagent_data_source" "docs" {
+  knowledge_base_id    = aws_bedrockagent_knowledge_base.main.id
+  name                 = "docs"
+  data_deletion_policy = "RETAIN"
+  data_source_configuration {
+    type = "S3"
+    s3_configuration {
+      bucket_arn         = aws_s3_bucket.replica.arn
+      inclusion_prefixes = ["inbox/"]
+    }
+  }
+  server_side_encryption_configuration {
+    kms_key_arn = aws_kms_key.kb.arn
+  }
+  vector_ingestion_configuration {
+    chunking_configuration {
+      chunking_strategy = "FIXED_SIZE"
+      fixed_size_chunking_configuration {
+        max_tokens         = 300
+        overlap_percentage = 20
+      }
+    }
+  }
+}
+
+resource "aws_bedrockagent_data_source" "raw" {
+  knowledge_base_id    = aws_bedrockagent_knowledge_base.main.id
+  name                 = "raw"
+  data_deletion_policy = "KEEP"
+  data_source_configuration {
+    type = "S3"
+    s3_configuration {
+      bucket_arn = "arn:aws:s3:::docs-upload"
+    }
+  }
+  vector_ingestion_configuration {
+    chunking_configuration {
+      chunking_strategy = "FIXED_SIZE"
+      fixed_size_chunking_configuration {
+        max_tokens         = 9000
+        overlap_percentage = 0
+      }
+    }
+  }
+}
+
+resource "aws_bedrockagent_data_source" "archive" {
+  count             = var.enable_archive ? 1 : 0
+  knowledge_base_id = aws_bedrockagent_knowledge_base.main.id
+  name              = "archive"
+  data_source_configuration {
+    type = "S3"
+    s3_configuration {
+      bucket_arn         = aws_s3_bucket.replica.arn
+      inclusion_prefixes = ["archive/"]
+    }
+  }
+}
+
+resource "aws_bedrockagent_knowledge_base" "seoul" {
+  provider = aws.seoul
+  name     = "seoul"
+  role_arn = "arn:aws:iam::123456789012:role/kb"
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = "arn:aws:bedrock:us-east-1::foundation-model/cohere.embed-multilingual-v3"
+    }
+  }
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = "arn:aws:aoss:ap-northeast-2:123456789012:collection/docs"
+      vector_index_name = "docs"
+      field_mapping {
+        vector_field   = "vector"
+        text_field     = "text"
+        metadata_field = "text"
+      }
+    }
+  }
+}
+
+resource "aws_bedrockagent_data_source" "seoul" {
+  provider             = aws.seoul
+  knowledge_base_id    = aws_bedrockagent_knowledge_base.seoul.id
+  name                 = "seoul"
+  data_deletion_policy = "RETAIN"
+  data_source_configuration {
+    type = "S3"
+    s3_configuration {
+      bucket_arn = "arn:aws:s3:::docs-archive"
+    }
+  }
+  server_side_encryption_configuration {
+    kms_key_arn = aws_kms_key.kb.arn
+  }
+  vector_ingestion_configuration {
+    chunking_configuration {
+      chunking_strategy = "HIERARCHICAL"
+      hierarchical_chunking_configuration {
+        level_configuration {
+          max_tokens = 1500
+        }
+        level_configuration {
+          max_tokens = 300
+        }
+        overlap_tokens = 60
+      }
+    }
+  }
+}
+`,
+	"modules/kb/main.tf": `
+resource "aws_bedrockagent_knowledge_base" "main" {
+  name     = "search"
+  role_arn = "arn:aws:iam::123456789012:role/kb"
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v2:0"
+    }
+  }
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = "arn:aws:aoss:ap-northeast-2:123456789012:collection/search"
+      vector_index_name = "search"
+      field_mapping {
+        vector_field   = "vector"
+        text_field     = "text"
+        metadata_field = "metadata"
+      }
+    }
+  }
+}
+`,
+}
+
+const (
+	searchKB = "environments/app/module.search:aws_bedrockagent_knowledge_base.main"
+	mainKB   = "environments/app:aws_bedrockagent_knowledge_base.main"
+	seoulKB  = "environments/app:aws_bedrockagent_knowledge_base.seoul"
+	docsDS   = "environments/app:aws_bedrockagent_data_source.docs"
+	rawDS    = "environments/app:aws_bedrockagent_data_source.raw"
+	seoulDS  = "environments/app:aws_bedrockagent_data_source.seoul"
+)
+
+func loadSample(t *testing.T) (*tfconfig.Tree, *Model) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range sampleTree {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unknown)
+	require.Len(t, md.KnowledgeBases, 3)
+	md.AddUpload("docs-upload", "inbox/docs/")
+	return tree, md
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	var regions []string
+	for _, kb := range md.KnowledgeBases {
+		regions = append(regions, kb.ID+" "+kb.Region)
+	}
+	assert.Equal(t, []string{
+		searchKB + " ap-northeast-2",
+		mainKB + " us-east-1",
+		seoulKB + " ap-northeast-2",
+	}, regions)
+
+	main := md.KnowledgeBases[1]
+	require.Len(t, main.DataSources, 2, "the archive data source has count = 0")
+	assert.Equal(t, DataSource{
+		ID:                docsDS,
+		Type:              "S3",
+		Bucket:            "arn:aws:s3:::docs-replica",
+		InclusionPrefixes: []string{"inbox/"},
+		Chunking:          Chunking{Strategy: "FIXED_SIZE", MaxTokens: 300, OverlapPercentage: 20},
+		DeletionPolicy:    "RETAIN",
+		KMSKey:            "${environments/app:aws_kms_key.kb}",
+	}, *main.DataSources[0])
+	assert.Equal(t, Chunking{Strategy: "HIERARCHICAL", Levels: []int{1500, 300}, OverlapTokens: 60},
+		md.KnowledgeBases[2].DataSources[0].Chunking)
+
+	assert.Equal(t, []Upload{{Bucket: "arn:aws:s3:::docs-replica", Prefix: "inbox/docs/",
+		Via: "environments/app:aws_s3_bucket_replication_configuration.copy"}}, md.Uploads("arn:aws:s3:::docs-replica"),
+		"uploads should follow the replication rule whose prefix covers them")
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tree, md := loadSample(t)
+	findings := md.Check()
+	var keys []string
+	for _, f := range findings {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		searchKB + " embedding-model",
+		rawDS + " chunking",
+		rawDS + " chunking",
+		rawDS + " inclusion-prefix",
+		rawDS + " encryption",
+		rawDS + " deletion-policy",
+		seoulKB + " embedding-model",
+		seoulKB + " field-mapping",
+		seoulDS + " chunking",
+		seoulDS + " inclusion-prefix",
+		seoulKB + " drift",
+		rawDS + " drift",
+		seoulDS + " drift",
+	}, keys)
+
+	assert.Contains(t, findings, finding.Finding{Subject: searchKB, Check: CheckEmbeddingModel,
+		Detail: `"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v2:0" is in us-east-1, but the knowledge base is created in ap-northeast-2`})
+	assert.Contains(t, findings, finding.Finding{Subject: rawDS, Check: CheckChunking,
+		Detail: `chunks of 9000 tokens are longer than the 8192 tokens amazon.titan-embed-text-v2:0 embeds`})
+	assert.Contains(t, findings, finding.Finding{Subject: rawDS, Check: CheckInclusionPrefix,
+		Detail: `reads all of arn:aws:s3:::docs-upload, but uploads only reach "inbox/docs/"`})
+	assert.Contains(t, findings, finding.Finding{Subject: seoulDS, Check: CheckInclusionPrefix,
+		Detail: `reads arn:aws:s3:::docs-archive, which no upload reaches`})
+	assert.Contains(t, findings, finding.Finding{Subject: seoulDS, Check: CheckChunking,
+		Detail: `chunks of 1500 tokens are longer than the 512 tokens cohere.embed-multilingual-v3 embeds`})
+	assert.Contains(t, findings, finding.Finding{Subject: seoulKB, Check: CheckDrift,
+		Detail: `embedding model is cohere.embed-multilingual-v3 here but amazon.titan-embed-text-v2:0 in ` + searchKB +
+			`; field mapping is vector/text/text here but vector/text/metadata in ` + searchKB})
+	assert.Contains(t, findings, finding.Finding{Subject: rawDS, Check: CheckDrift,
+		Detail: `chunking is FIXED_SIZE 9000 tokens, 0% overlap here but FIXED_SIZE 300 tokens, 20% overlap in ` + docsDS +
+			`; encryption is AWS owned key here but customer managed key in ` + docsDS +
+			`; data_deletion_policy is "KEEP" here but "RETAIN" in ` + docsDS})
+
+	// An archive prefix of the replica selects nothing uploaded.
+	tree.Stack("environments/app").SetVars(map[string]cty.Value{"enable_archive": cty.True})
+	md = Build(tree)
+	md.AddUpload("docs-upload", "inbox/docs/")
+	assert.Contains(t, md.Check(), finding.Finding{Subject: "environments/app:aws_bedrockagent_data_source.archive", Check: CheckInclusionPrefix,
+		Detail: `inclusion prefix "archive/" of arn:aws:s3:::docs-replica selects no upload; uploads reach "inbox/docs/"`})
+}
+
+func TestRegion(t *testing.T) {
+	t.Parallel()
+
+	tree, _ := loadSample(t)
+	app := tree.Stack("environments/app")
+	assert.Equal(t, "us-east-1", Region(app, app.Resource("aws_kms_key", "kb")))
+	assert.Equal(t, "ap-northeast-2", Region(app, app.Resource("aws_bedrockagent_data_source", "seoul")))
+}
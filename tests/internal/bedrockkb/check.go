@@ -0,0 +1,259 @@
+package bedrockkb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/vectorindex"
+)
+
+// Checks reported by Check.
+const (
+	CheckEmbeddingModel  = "embedding-model"
+	CheckChunking        = "chunking"
+	CheckInclusionPrefix = "inclusion-prefix"
+	CheckFieldMapping    = "field-mapping"
+	CheckEncryption      = "encryption"
+	CheckDeletionPolicy  = "deletion-policy"
+	CheckDrift           = "drift"
+	CheckUnknownValue    = "unknown-value"
+)
+
+// Chunking strategies Bedrock accepts.
+var chunkingStrategies = []string{"FIXED_SIZE", "HIERARCHICAL", "SEMANTIC", "NONE"}
+
+// deletionPolicies are the values of data_deletion_policy.
+var deletionPolicies = []string{"RETAIN", "DELETE"}
+
+// modelARN matches the ARN of a foundation model, which has no account, or
+// of an inference profile, custom or provisioned model, which has one.
+var modelARN = regexp.MustCompile(`^arn:aws:bedrock:([a-z]{2}(?:-[a-z]+)+-\d):(\d{12})?:(foundation-model|inference-profile|application-inference-profile|custom-model|provisioned-model)/(.+)$`)
+
+// Check audits every knowledge base and data source:
+//
+//   - The embedding model ARN is a well-formed Bedrock model ARN in the
+//     region of the knowledge base's provider, when the stack configures
+//     one.
+//   - The chunking strategy is one Bedrock accepts, with sizes in range and
+//     chunks no longer than the embedding model embeds.
+//   - An S3 data source reads a bucket uploads reach, and its inclusion
+//     prefixes select uploaded keys; a data source without prefixes reads
+//     the whole bucket, so every upload into it must cover the bucket.
+//   - The vector store's field mapping names distinct vector, text and
+//     metadata fields.
+//   - Every data source encrypts transient data with a KMS key of the tree
+//     and sets a data deletion policy.
+//   - Knowledge bases agree on the embedding model, storage type and field
+//     mapping, and data sources on chunking, encryption and deletion
+//     policy; each that differs from the first is reported.
+//
+// Values that are not statically known are reported as CheckUnknownValue.
+func (md *Model) Check() []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+	for _, u := range md.Unknown {
+		i := strings.Index(u, ": ")
+		add(u[:i], CheckUnknownValue, "%s is not statically known", u[i+2:])
+	}
+
+	for _, kb := range md.KnowledgeBases {
+		if sm := modelARN.FindStringSubmatch(kb.Model); sm == nil {
+			add(kb.ID, CheckEmbeddingModel, "%q is not a Bedrock model ARN", kb.Model)
+		} else {
+			switch foundation := sm[3] == "foundation-model"; {
+			case foundation && sm[2] != "":
+				add(kb.ID, CheckEmbeddingModel, "%q: a foundation-model ARN has no account ID", kb.Model)
+			case !foundation && sm[2] == "":
+				add(kb.ID, CheckEmbeddingModel, "%q: a %s ARN needs an account ID", kb.Model, sm[3])
+			}
+			if kb.Region != "" && sm[1] != kb.Region {
+				add(kb.ID, CheckEmbeddingModel, "%q is in %s, but the knowledge base is created in %s", kb.Model, sm[1], kb.Region)
+			}
+		}
+		switch {
+		case kb.StorageType == "":
+		case kb.VectorField == "" || kb.TextField == "" || kb.MetadataField == "":
+			add(kb.ID, CheckFieldMapping, "%s field mapping needs vector, text and metadata fields, got %q, %q, %q",
+				kb.StorageType, kb.VectorField, kb.TextField, kb.MetadataField)
+		case kb.VectorField == kb.TextField || kb.TextField == kb.MetadataField || kb.VectorField == kb.MetadataField:
+			add(kb.ID, CheckFieldMapping, "field mapping reuses a field: %q, %q, %q", kb.VectorField, kb.TextField, kb.MetadataField)
+		}
+
+		for _, ds := range kb.DataSources {
+			for _, p := range chunkingProblems(ds.Chunking, kb.Model) {
+				add(ds.ID, CheckChunking, "%s", p)
+			}
+			if ds.Type == "S3" {
+				for _, p := range md.inclusionProblems(ds) {
+					add(ds.ID, CheckInclusionPrefix, "%s", p)
+				}
+			}
+			switch {
+			case ds.KMSKey == "":
+				add(ds.ID, CheckEncryption, "no server_side_encryption_configuration: transient data is encrypted with an AWS owned key")
+			case !isKey(ds.KMSKey):
+				add(ds.ID, CheckEncryption, "kms_key_arn %q is not a KMS key of the tree", ds.KMSKey)
+			}
+			switch {
+			case ds.DeletionPolicy == "":
+				add(ds.ID, CheckDeletionPolicy, "data_deletion_policy is not set, so deleting the data source deletes its vectors")
+			case !contains(deletionPolicies, ds.DeletionPolicy):
+				add(ds.ID, CheckDeletionPolicy, "data_deletion_policy is %q, not one of %s", ds.DeletionPolicy, strings.Join(deletionPolicies, ", "))
+			}
+		}
+	}
+
+	// Drift: every knowledge base and data source against the first.
+	if len(md.KnowledgeBases) > 0 {
+		first := md.KnowledgeBases[0]
+		for _, kb := range md.KnowledgeBases[1:] {
+			if d := diff(kbProfile(first), kbProfile(kb), first.ID); d != "" {
+				add(kb.ID, CheckDrift, "%s", d)
+			}
+		}
+	}
+	var sources []*DataSource
+	for _, kb := range md.KnowledgeBases {
+		sources = append(sources, kb.DataSources...)
+	}
+	for i := 1; i < len(sources); i++ {
+		if d := diff(dsProfile(sources[0]), dsProfile(sources[i]), sources[0].ID); d != "" {
+			add(sources[i].ID, CheckDrift, "%s", d)
+		}
+	}
+	return out
+}
+
+// chunkingProblems describes the settings of a chunking configuration
+// that Bedrock rejects or the embedding model truncates.
+func chunkingProblems(c Chunking, model string) []string {
+	var out []string
+	if c.Strategy == "" || c.Strategy == "NONE" {
+		return nil
+	}
+	if !contains(chunkingStrategies, c.Strategy) {
+		return []string{fmt.Sprintf("chunking_strategy %q is not one of %s", c.Strategy, strings.Join(chunkingStrategies, ", "))}
+	}
+	longest := c.MaxTokens
+	switch c.Strategy {
+	case "FIXED_SIZE":
+		if c.MaxTokens < 1 {
+			out = append(out, fmt.Sprintf("max_tokens is %d", c.MaxTokens))
+		}
+		if c.OverlapPercentage < 1 || c.OverlapPercentage > 99 {
+			out = append(out, fmt.Sprintf("overlap_percentage is %d, not within 1-99", c.OverlapPercentage))
+		}
+	case "HIERARCHICAL":
+		if len(c.Levels) != 2 {
+			return append(out, fmt.Sprintf("%d level_configuration blocks, not a parent and a child", len(c.Levels)))
+		}
+		longest = c.Levels[0]
+		if c.Levels[1] < 1 || c.Levels[1] >= c.Levels[0] {
+			out = append(out, fmt.Sprintf("child chunks of %d tokens are not shorter than parent chunks of %d", c.Levels[1], c.Levels[0]))
+		}
+		if c.OverlapTokens < 1 || c.OverlapTokens >= c.Levels[1] {
+			out = append(out, fmt.Sprintf("overlap_tokens is %d, not within 1-%d", c.OverlapTokens, c.Levels[1]-1))
+		}
+	case "SEMANTIC":
+		if c.MaxTokens < 1 {
+			out = append(out, fmt.Sprintf("max_token is %d", c.MaxTokens))
+		}
+		if c.BufferSize < 0 || c.BufferSize > 1 {
+			out = append(out, fmt.Sprintf("buffer_size is %d, not 0 or 1", c.BufferSize))
+		}
+		if c.BreakpointPercentileThreshold < 50 || c.BreakpointPercentileThreshold > 99 {
+			out = append(out, fmt.Sprintf("breakpoint_percentile_threshold is %d, not within 50-99", c.BreakpointPercentileThreshold))
+		}
+	}
+	if em, ok := vectorindex.LookupModel(model); ok && longest > em.MaxTokens {
+		out = append(out, fmt.Sprintf("chunks of %d tokens are longer than the %d tokens %s embeds", longest, em.MaxTokens, em.ID))
+	}
+	return out
+}
+
+// inclusionProblems describes how the bucket and inclusion prefixes of an
+// S3 data source miss the uploads.
+func (md *Model) inclusionProblems(ds *DataSource) []string {
+	uploads := md.Uploads(ds.Bucket)
+	if len(uploads) == 0 {
+		return []string{fmt.Sprintf("reads %s, which no upload reaches", ds.Bucket)}
+	}
+	var prefixes []string
+	for _, u := range uploads {
+		prefixes = append(prefixes, fmt.Sprintf("%q", u.Prefix))
+	}
+	list := strings.Join(prefixes, ", ")
+	var out []string
+	if len(ds.InclusionPrefixes) == 0 {
+		whole := false
+		for _, u := range uploads {
+			whole = whole || u.Prefix == ""
+		}
+		if !whole {
+			out = append(out, fmt.Sprintf("reads all of %s, but uploads only reach %s", ds.Bucket, list))
+		}
+	}
+	for _, p := range ds.InclusionPrefixes {
+		matched := false
+		for _, u := range uploads {
+			matched = matched || prefixesOverlap(p, u.Prefix)
+		}
+		if !matched {
+			out = append(out, fmt.Sprintf("inclusion prefix %q of %s selects no upload; uploads reach %s", p, ds.Bucket, list))
+		}
+	}
+	return out
+}
+
+type profile [][2]string
+
+func kbProfile(kb *KnowledgeBase) profile {
+	return profile{
+		{"embedding model", vectorindex.ModelID(kb.Model)},
+		{"storage type", kb.StorageType},
+		{"field mapping", fmt.Sprintf("%s/%s/%s", kb.VectorField, kb.TextField, kb.MetadataField)},
+	}
+}
+
+func dsProfile(ds *DataSource) profile {
+	encryption := "AWS owned key"
+	if ds.KMSKey != "" {
+		encryption = "customer managed key"
+	}
+	return profile{
+		{"chunking", ds.Chunking.String()},
+		{"encryption", encryption},
+		{"data_deletion_policy", fmt.Sprintf("%q", ds.DeletionPolicy)},
+	}
+}
+
+// diff describes the entries of b that differ from a, or "".
+func diff(a, b profile, from string) string {
+	var out []string
+	for i := range a {
+		if a[i][1] != b[i][1] {
+			out = append(out, fmt.Sprintf("%s is %s here but %s in %s", a[i][0], b[i][1], a[i][1], from))
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+// isKey reports whether a canonical ARN refers to a KMS key of the tree.
+func isKey(arn string) bool {
+	id := strings.TrimSuffix(strings.TrimPrefix(arn, "${"), "}")
+	i := strings.LastIndex(id, ":")
+	return id != arn && i >= 0 && strings.HasPrefix(id[i+1:], "aws_kms_key.")
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
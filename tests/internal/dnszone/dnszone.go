@@ -0,0 +1,579 @@
+// Package dnszone models the Route 53 private hosted zones, records and
+// Resolver endpoints and rules of a Terraform tree, and answers A queries
+// the way the VPC resolver would from inside a VPC, or from on-premises
+// through an inbound Resolver endpoint.
+//
+// The private IPs of VPC endpoint, load balancer and Resolver ENIs are
+// assigned by AWS and do not appear in the configuration. Unless they are
+// passed in through Options they are synthesized: one stable address per
+// subnet the ENIs live in, so that answers still land in the right VPC.
+package dnszone
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vpcendpoint"
+)
+
+// Zone is an aws_route53_zone, or a zone that is only looked up with
+// data "aws_route53_zone". Lookups of a zone the tree manages share its Zone.
+type Zone struct {
+	ID      string // node ID of the managed zone, or of the first lookup
+	Name    string // lower case, without the trailing dot
+	Private bool
+	// External is set for zones that are looked up but not managed here;
+	// only the associations and records in this tree are known.
+	External bool
+	VPCs     []*routing.VPC
+	Records  []*Record
+	Block    *tfconfig.Block
+}
+
+// AssociatedWith reports whether the zone is associated with the VPC.
+func (z *Zone) AssociatedWith(v *routing.VPC) bool {
+	for _, zv := range z.VPCs {
+		if zv == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Record is an aws_route53_record.
+type Record struct {
+	ID   string
+	Zone *Zone
+	Name string // fully qualified, lower case, without the trailing dot
+	Type string // "A", "CNAME", ...
+	TTL  int    // 0 for alias records
+	// Values are the literal records, e.g. "10.10.1.21" or a CNAME target.
+	Values []string
+	// Targets are the endpoints and load balancers the records or the
+	// alias refer to. A CNAME to an endpoint's DNS name is answered with
+	// the endpoint's addresses.
+	Targets []*Target
+	Alias   bool
+	Block   *tfconfig.Block
+}
+
+// Target is a VPC endpoint or load balancer a record points at.
+type Target struct {
+	ID        string // node ID of the aws_vpc_endpoint or aws_lb
+	VPC       *routing.VPC
+	Subnets   []*net.IPNet // the subnets the ENIs are in
+	Addresses []net.IP
+	// Synthetic is set when Addresses are stand-ins for the ENIs, one per
+	// subnet, rather than addresses passed in through Options.
+	Synthetic bool
+}
+
+// ResolverEndpoint is an aws_route53_resolver_endpoint.
+type ResolverEndpoint struct {
+	ID        string
+	Direction string // "INBOUND" or "OUTBOUND"
+	VPC       *routing.VPC
+	Addresses []net.IP // one per ip_address block
+	Synthetic bool     // some ip_address blocks have no ip
+	Block     *tfconfig.Block
+}
+
+// Rule is an aws_route53_resolver_rule with the VPCs it is associated with.
+type Rule struct {
+	ID        string
+	Domain    string // lower case, without the trailing dot
+	Type      string // "FORWARD", "SYSTEM" or "RECURSIVE"
+	TargetIPs []string
+	Endpoint  *ResolverEndpoint // outbound endpoint of forwarding rules
+	VPCs      []*routing.VPC
+	Block     *tfconfig.Block
+}
+
+// EndpointZone is the implicit zone an interface endpoint with private DNS
+// enabled adds to its VPC, e.g. "execute-api.ap-northeast-2.amazonaws.com".
+// It answers its name and every name below it.
+type EndpointZone struct {
+	Name   string
+	Target *Target
+}
+
+// Options configures Build.
+type Options struct {
+	// Addresses are known ENI addresses, keyed by the node ID of a VPC
+	// endpoint, load balancer or Resolver endpoint. They replace the
+	// synthesized ones.
+	Addresses map[string][]string
+}
+
+// Model is the DNS model of a tree.
+type Model struct {
+	Zones         []*Zone
+	Records       []*Record
+	Resolvers     []*ResolverEndpoint
+	Rules         []*Rule
+	EndpointZones []*EndpointZone
+
+	// Unresolved lists records, associations, endpoints and rules whose
+	// zone, VPC or target could not be resolved statically.
+	Unresolved []string
+
+	Net  *routing.Network
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+
+	opts      Options
+	zones     map[string]*Zone // by node ID of the resource or lookup
+	targets   map[string]*Target
+	resolvers map[string]*ResolverEndpoint
+	rules     map[string]*Rule
+}
+
+// Build collects the DNS model of every stack in the tree.
+func Build(tree *tfconfig.Tree, network *routing.Network, opts Options) *Model {
+	md := &Model{
+		Net:       network,
+		Tree:      tree,
+		Refs:      tree.RefGraph(),
+		opts:      opts,
+		zones:     map[string]*Zone{},
+		targets:   map[string]*Target{},
+		resolvers: map[string]*ResolverEndpoint{},
+		rules:     map[string]*Rule{},
+	}
+	// Managed zones first, so that lookups anywhere in the tree share them.
+	for _, m := range tree.Modules {
+		md.collectZones(m)
+	}
+	for _, m := range tree.Modules {
+		md.collectLookups(m)
+	}
+	for _, m := range tree.Modules {
+		md.collectAssociations(m)
+		md.collectResolvers(m)
+		md.collectEndpointZones(m)
+	}
+	for _, m := range tree.Modules {
+		md.collectRecords(m)
+		md.collectRules(m)
+	}
+
+	sort.Slice(md.Zones, func(i, j int) bool { return md.Zones[i].ID < md.Zones[j].ID })
+	sort.Slice(md.Records, func(i, j int) bool { return md.Records[i].ID < md.Records[j].ID })
+	sort.Slice(md.EndpointZones, func(i, j int) bool { return md.EndpointZones[i].Name < md.EndpointZones[j].Name })
+	sort.Strings(md.Unresolved)
+	return md
+}
+
+func (md *Model) collectZones(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route53_zone")) {
+		z := &Zone{ID: nodeID(m, b), Block: b}
+		if s, ok := b.String("name"); ok {
+			z.Name = canonical(s)
+		}
+		for _, v := range b.Nested("vpc") {
+			z.Private = true
+			md.associate(z, m, v.Expr("vpc_id"), z.ID)
+		}
+		md.zones[z.ID] = z
+		md.Zones = append(md.Zones, z)
+	}
+}
+
+// collectLookups attaches data "aws_route53_zone" lookups to the managed zone
+// with the same name and visibility, or adds them as external zones.
+func (md *Model) collectLookups(m *tfconfig.Module) {
+	for _, b := range instantiated(m.DataSources("aws_route53_zone")) {
+		id := nodeID(m, b)
+		name, ok := b.String("name")
+		if !ok {
+			md.Unresolved = append(md.Unresolved, id+": name")
+			continue
+		}
+		private, _ := b.Bool("private_zone")
+		var zone *Zone
+		for _, z := range md.Zones {
+			if z.Name == canonical(name) && z.Private == private {
+				zone = z
+				break
+			}
+		}
+		if zone == nil {
+			zone = &Zone{ID: id, Name: canonical(name), Private: private, External: true, Block: b}
+			md.Zones = append(md.Zones, zone)
+		}
+		md.zones[id] = zone
+	}
+}
+
+func (md *Model) collectAssociations(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route53_zone_association")) {
+		id := nodeID(m, b)
+		z := md.zoneOf(m, b.Expr("zone_id"))
+		if z == nil {
+			md.Unresolved = append(md.Unresolved, id+": zone_id")
+			continue
+		}
+		md.associate(z, m, b.Expr("vpc_id"), id)
+	}
+}
+
+func (md *Model) associate(z *Zone, m *tfconfig.Module, expr hclsyntax.Expression, id string) {
+	v := md.Net.VPCOf(m, expr)
+	if v == nil {
+		md.Unresolved = append(md.Unresolved, id+": vpc_id")
+		return
+	}
+	if !z.AssociatedWith(v) {
+		z.VPCs = append(z.VPCs, v)
+	}
+}
+
+func (md *Model) collectResolvers(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route53_resolver_endpoint")) {
+		ep := &ResolverEndpoint{ID: nodeID(m, b), Block: b}
+		ep.Direction, _ = b.String("direction")
+		known := md.known(ep.ID)
+		for i, ip := range b.Nested("ip_address") {
+			if ep.VPC == nil {
+				ep.VPC = md.Net.VPCOf(m, ip.Expr("subnet_id"))
+			}
+			if s, ok := ip.String("ip"); ok {
+				ep.Addresses = append(ep.Addresses, net.ParseIP(s))
+				continue
+			}
+			if i < len(known) {
+				ep.Addresses = append(ep.Addresses, known[i])
+				continue
+			}
+			subnets := md.subnetsOf(m, ip.Expr("subnet_id"))
+			if len(subnets) == 0 {
+				md.Unresolved = append(md.Unresolved, ep.ID+": ip_address.subnet_id")
+				continue
+			}
+			ep.Addresses = append(ep.Addresses, synthesize(ep.ID, subnets[0]))
+			ep.Synthetic = true
+		}
+		if ep.VPC == nil {
+			md.Unresolved = append(md.Unresolved, ep.ID+": ip_address.subnet_id")
+		}
+		md.resolvers[ep.ID] = ep
+		md.Resolvers = append(md.Resolvers, ep)
+	}
+}
+
+// collectEndpointZones adds the private DNS zones of interface endpoints.
+func (md *Model) collectEndpointZones(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_vpc_endpoint")) {
+		if typ, _ := b.String("vpc_endpoint_type"); typ != "Interface" {
+			continue
+		}
+		if enabled, _ := b.Bool("private_dns_enabled"); !enabled {
+			continue
+		}
+		name, ok := m.PartialString(b.Expr("service_name"))
+		parts := strings.Split(name, ".")
+		if !ok || len(parts) < 4 || strings.Contains(name, tfconfig.Unknown) {
+			md.Unresolved = append(md.Unresolved, nodeID(m, b)+": service_name")
+			continue
+		}
+		// com.amazonaws.<region>.<service> serves <service>.<region>.amazonaws.com.
+		region := parts[2]
+		service := vpcendpoint.ServiceOf(name)
+		if t := md.target(tfconfig.Node{Module: m, Addr: b.Address()}); t != nil {
+			md.EndpointZones = append(md.EndpointZones, &EndpointZone{Name: service + "." + region + ".amazonaws.com", Target: t})
+		}
+	}
+}
+
+func (md *Model) collectRecords(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route53_record")) {
+		r := &Record{ID: nodeID(m, b), Block: b}
+		r.Zone = md.zoneOf(m, b.Expr("zone_id"))
+		name, ok := m.PartialString(b.Expr("name"))
+		if r.Zone == nil || !ok || strings.Contains(name, tfconfig.Unknown) {
+			md.Unresolved = append(md.Unresolved, r.ID+": zone_id/name")
+			continue
+		}
+		r.Name = canonical(name)
+		if r.Name != r.Zone.Name && !strings.HasSuffix(r.Name, "."+r.Zone.Name) {
+			r.Name += "." + r.Zone.Name
+		}
+		r.Type, _ = b.String("type")
+		r.TTL, _ = b.Int("ttl")
+
+		var exprs []hclsyntax.Expression
+		if alias := b.Nested("alias"); len(alias) > 0 {
+			r.Alias = true
+			exprs = append(exprs, alias[0].Expr("name"))
+		} else if b.Has("records") {
+			exprs = append(exprs, b.Expr("records"))
+			if values, ok := m.Partial(b.Expr("records")).([]interface{}); ok {
+				for _, v := range values {
+					if s, ok := v.(string); ok && !strings.Contains(s, tfconfig.Unknown) {
+						r.Values = append(r.Values, strings.TrimSuffix(s, "."))
+					}
+				}
+			}
+		}
+		for _, expr := range exprs {
+			for _, n := range md.Refs.ResolveExpr(m, expr) {
+				if t := md.target(n); t != nil {
+					r.Targets = append(r.Targets, t)
+				}
+			}
+		}
+		if len(r.Values) == 0 && len(r.Targets) == 0 {
+			md.Unresolved = append(md.Unresolved, r.ID+": records/alias")
+		}
+		r.Zone.Records = append(r.Zone.Records, r)
+		md.Records = append(md.Records, r)
+	}
+}
+
+func (md *Model) collectRules(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route53_resolver_rule")) {
+		r := &Rule{ID: nodeID(m, b), Block: b}
+		if s, ok := b.String("domain_name"); ok {
+			r.Domain = canonical(s)
+		}
+		r.Type, _ = b.String("rule_type")
+		for _, t := range b.Nested("target_ip") {
+			if s, ok := t.String("ip"); ok {
+				r.TargetIPs = append(r.TargetIPs, s)
+			}
+		}
+		for _, n := range md.Refs.ResolveExpr(m, b.Expr("resolver_endpoint_id")) {
+			if ep := md.resolvers[n.String()]; ep != nil {
+				r.Endpoint = ep
+			}
+		}
+		if r.Type == "FORWARD" && r.Endpoint == nil {
+			md.Unresolved = append(md.Unresolved, r.ID+": resolver_endpoint_id")
+		}
+		md.rules[r.ID] = r
+		md.Rules = append(md.Rules, r)
+	}
+	for _, b := range instantiated(m.Resources("aws_route53_resolver_rule_association")) {
+		id := nodeID(m, b)
+		var rule *Rule
+		for _, n := range md.Refs.ResolveExpr(m, b.Expr("resolver_rule_id")) {
+			if r := md.rules[n.String()]; r != nil {
+				rule = r
+			}
+		}
+		v := md.Net.VPCOf(m, b.Expr("vpc_id"))
+		if rule == nil || v == nil {
+			md.Unresolved = append(md.Unresolved, id+": resolver_rule_id/vpc_id")
+			continue
+		}
+		rule.VPCs = append(rule.VPCs, v)
+	}
+}
+
+// zoneOf resolves a zone_id expression to a zone.
+func (md *Model) zoneOf(m *tfconfig.Module, expr hclsyntax.Expression) *Zone {
+	for _, n := range md.Refs.ResolveExpr(m, expr) {
+		if z := md.zones[n.String()]; z != nil {
+			return z
+		}
+	}
+	return nil
+}
+
+// target returns the VPC endpoint or load balancer a node declares, following
+// data "aws_vpc_endpoint" lookups to the endpoint they look up. It returns
+// nil for other nodes.
+func (md *Model) target(n tfconfig.Node) *Target {
+	b := n.Block()
+	if b == nil {
+		return nil
+	}
+	m := b.Module()
+	if b.Type == "data" {
+		if b.Kind() != "aws_vpc_endpoint" {
+			return nil
+		}
+		for _, looked := range md.Refs.ResolveExpr(m, b.Expr("id")) {
+			if lb := looked.Block(); lb != nil && lb.Type == "resource" {
+				return md.target(looked)
+			}
+		}
+		return nil
+	}
+	var subnetExpr hclsyntax.Expression
+	switch b.Kind() {
+	case "aws_vpc_endpoint":
+		subnetExpr = b.Expr("subnet_ids")
+	case "aws_lb", "aws_alb":
+		subnetExpr = b.Expr("subnets")
+		for _, sm := range b.Nested("subnet_mapping") {
+			if subnetExpr == nil {
+				subnetExpr = sm.Expr("subnet_id")
+			}
+		}
+	default:
+		return nil
+	}
+	id := n.String()
+	if t := md.targets[id]; t != nil {
+		return t
+	}
+	t := &Target{ID: id, Addresses: md.known(id)}
+	if b.Kind() == "aws_vpc_endpoint" {
+		t.VPC = md.Net.VPCOf(m, b.Expr("vpc_id"))
+	} else if subnetExpr != nil {
+		t.VPC = md.Net.VPCOf(m, subnetExpr)
+	}
+	if subnetExpr != nil {
+		t.Subnets = md.subnetsOf(m, subnetExpr)
+	}
+	if len(t.Addresses) == 0 {
+		for _, s := range t.Subnets {
+			t.Addresses = append(t.Addresses, synthesize(id, s))
+			t.Synthetic = true
+		}
+	}
+	if t.VPC == nil || len(t.Addresses) == 0 {
+		md.Unresolved = append(md.Unresolved, id+": vpc_id/subnets")
+	}
+	md.targets[id] = t
+	return t
+}
+
+// known returns the addresses passed in for a node.
+func (md *Model) known(id string) []net.IP {
+	var out []net.IP
+	for _, s := range md.opts.Addresses[id] {
+		if ip := net.ParseIP(s); ip != nil {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// subnetsOf resolves an expression to the CIDRs of the subnets it refers to.
+// Counted subnets yield one CIDR per instance, or only the indexed one when
+// the expression ends in a literal index, e.g. private_subnet_ids[0].
+func (md *Model) subnetsOf(m *tfconfig.Module, expr hclsyntax.Expression) []*net.IPNet {
+	var out []*net.IPNet
+	for _, n := range md.Refs.ResolveExpr(m, expr) {
+		b := n.Block()
+		if b == nil || b.Type != "resource" || b.Kind() != "aws_subnet" {
+			continue
+		}
+		count := 1
+		if b.Has("count") {
+			c, ok := b.Count()
+			if !ok {
+				continue
+			}
+			count = c
+		}
+		var cidrs []*net.IPNet
+		for i := 0; i < count; i++ {
+			v := b.Module().EvalWith(b.Expr("cidr_block"), map[string]cty.Value{
+				"count": cty.ObjectVal(map[string]cty.Value{"index": cty.NumberIntVal(int64(i))}),
+			})
+			if !v.IsWhollyKnown() || v.IsNull() || v.Type() != cty.String {
+				continue
+			}
+			if _, cidr, err := net.ParseCIDR(v.AsString()); err == nil {
+				cidrs = append(cidrs, cidr)
+			}
+		}
+		if i, ok := literalIndex(expr); ok && len(cidrs) > 1 {
+			if i >= len(cidrs) {
+				continue
+			}
+			cidrs = cidrs[i : i+1]
+		}
+		out = append(out, cidrs...)
+	}
+	return out
+}
+
+// literalIndex returns the index an expression ends with, e.g. 0 for
+// module.vpc.private_subnet_ids[0].
+func literalIndex(expr hclsyntax.Expression) (int, bool) {
+	var key cty.Value
+	switch e := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		idx, ok := e.Traversal[len(e.Traversal)-1].(hcl.TraverseIndex)
+		if !ok {
+			return 0, false
+		}
+		key = idx.Key
+	case *hclsyntax.IndexExpr:
+		v, diags := e.Key.Value(nil)
+		if diags.HasErrors() {
+			return 0, false
+		}
+		key = v
+	default:
+		return 0, false
+	}
+	return tfconfig.AsInt(key)
+}
+
+// synthesize returns a stable address for an ENI of id in a subnet, avoiding
+// the network address, the four addresses AWS reserves and the broadcast
+// address.
+func synthesize(id string, subnet *net.IPNet) net.IP {
+	base := subnet.IP.To4()
+	ones, bits := subnet.Mask.Size()
+	if base == nil || bits-ones < 3 {
+		return base
+	}
+	size := uint32(1) << uint(bits-ones)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	host := 4 + h.Sum32()%(size-5)
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+host)
+	return ip
+}
+
+// canonical lower-cases a DNS name and drops the trailing dot.
+func canonical(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+// instantiated drops blocks whose count is known to be zero.
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// Resolver returns a Resolver endpoint by node ID.
+func (md *Model) Resolver(id string) *ResolverEndpoint {
+	return md.resolvers[id]
+}
+
+// Zone returns the zone a resource or lookup declares, by node ID.
+func (md *Model) Zone(id string) *Zone {
+	return md.zones[id]
+}
+
+func (t *Target) String() string {
+	return fmt.Sprintf("%s %v", t.ID, t.Addresses)
+}
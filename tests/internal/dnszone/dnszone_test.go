@@ -0,0 +1,343 @@
+package dnszone
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleZones has a hub VPC with an interface endpoint, an inbound and an
+// outbound Resolver endpoint, and a spoke VPC. The private zone is created
+// for the hub and associated with the spoke; a lookup of it adds records.
+// The spoke forwards onprem.example.com to on-premises, except for
+// ad.onprem.example.com which a SYSTEM rule keeps in the private zone.
+const sampleZones = `
+variable "hub_subnets" {
+  default = ["10.1.1.0/24", "10.1.2.0/24"]
+}
+
+resource "aws_vpc" "hub" {
+  cidr_block = "10.1.0.0/16"
+}
+
+resource "aws_subnet" "hub" {
+  count      = length(var.hub_subnets)
+  vpc_id     = aws_vpc.hub.id
+  cidr_block = var.hub_subnets[count.index]
+}
+
+resource "aws_vpc" "spoke" {
+  cidr_block = "10.2.0.0/16"
+}
+
+resource "aws_vpc_endpoint" "api" {
+  vpc_id              = aws_vpc.hub.id
+  service_name        = "com.amazonaws.ap-northeast-2.execute-api"
+  vpc_endpoint_type   = "Interface"
+  private_dns_enabled = true
+  subnet_ids          = aws_subnet.hub[*].id
+}
+
+data "aws_vpc_endpoint" "api" {
+  id = aws_vpc_endpoint.api.id
+}
+
+resource "aws_route53_zone" "corp" {
+  name = "Example.com."
+
+  vpc {
+    vpc_id = aws_vpc.hub.id
+  }
+}
+
+resource "aws_route53_zone_association" "spoke" {
+  zone_id = aws_route53_zone.corp.zone_id
+  vpc_id  = aws_vpc.spoke.id
+}
+
+data "aws_route53_zone" "corp" {
+  name         = "example.com"
+  private_zone = true
+}
+
+resource "aws_route53_record" "app" {
+  zone_id = aws_route53_zone.corp.zone_id
+  name    = "app"
+  type    = "A"
+  ttl     = 60
+  records = ["10.1.1.10", "10.1.2.10"]
+}
+
+resource "aws_route53_record" "api" {
+  zone_id = aws_route53_zone.corp.zone_id
+  name    = "api.example.com"
+  type    = "A"
+
+  alias {
+    name                   = data.aws_vpc_endpoint.api.dns_entry[0]["dns_name"]
+    zone_id                = data.aws_vpc_endpoint.api.dns_entry[0]["hosted_zone_id"]
+    evaluate_target_health = true
+  }
+}
+
+resource "aws_route53_record" "www" {
+  zone_id = data.aws_route53_zone.corp.zone_id
+  name    = "www.example.com"
+  type    = "CNAME"
+  ttl     = 300
+  records = ["app.example.com."]
+}
+
+resource "aws_route53_record" "gateway" {
+  zone_id = data.aws_route53_zone.corp.zone_id
+  name    = "gateway.example.com"
+  type    = "CNAME"
+  ttl     = 300
+  records = [aws_vpc_endpoint.api.dns_entry[0]["dns_name"]]
+}
+
+resource "aws_route53_record" "tenants" {
+  zone_id = aws_route53_zone.corp.zone_id
+  name    = "*.tenants.example.com"
+  type    = "CNAME"
+  ttl     = 300
+  records = ["app.example.com"]
+}
+
+resource "aws_route53_record" "ad" {
+  zone_id = aws_route53_zone.corp.zone_id
+  name    = "ad.onprem.example.com"
+  type    = "A"
+  ttl     = 300
+  records = ["10.1.1.53"]
+}
+
+resource "aws_route53_resolver_endpoint" "inbound" {
+  direction = "INBOUND"
+
+  ip_address {
+    subnet_id = aws_subnet.hub[0].id
+    ip        = "10.1.1.5"
+  }
+
+  ip_address {
+    subnet_id = aws_subnet.hub[1].id
+  }
+}
+
+resource "aws_route53_resolver_endpoint" "outbound" {
+  direction = "OUTBOUND"
+
+  ip_address {
+    subnet_id = aws_subnet.hub[0].id
+  }
+}
+
+resource "aws_route53_resolver_rule" "onprem" {
+  domain_name          = "onprem.example.com"
+  rule_type            = "FORWARD"
+  resolver_endpoint_id = aws_route53_resolver_endpoint.outbound.id
+
+  target_ip {
+    ip = "192.168.0.2"
+  }
+}
+
+resource "aws_route53_resolver_rule" "ad" {
+  domain_name = "ad.onprem.example.com"
+  rule_type   = "SYSTEM"
+}
+
+resource "aws_route53_resolver_rule_association" "onprem" {
+  resolver_rule_id = aws_route53_resolver_rule.onprem.id
+  vpc_id           = aws_vpc.spoke.id
+}
+
+resource "aws_route53_resolver_rule_association" "ad" {
+  resolver_rule_id = aws_route53_resolver_rule.ad.id
+  vpc_id           = aws_vpc.spoke.id
+}
+`
+
+func loadSample(t *testing.T, opts Options) (*Model, *routing.VPC, *routing.VPC) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleZones), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	n, err := routing.Build(tree, routing.Options{})
+	require.NoError(t, err)
+	md := Build(tree, n, opts)
+	require.Empty(t, md.Unresolved)
+	return md, n.VPC("environments/app:aws_vpc.hub"), n.VPC("environments/app:aws_vpc.spoke")
+}
+
+func addresses(a Answer) []string {
+	var out []string
+	for _, ip := range a.Addresses {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+func TestBuild_CollectsZonesAndRecords(t *testing.T) {
+	t.Parallel()
+
+	md, hub, spoke := loadSample(t, Options{})
+	require.Len(t, md.Zones, 1)
+	zone := md.Zones[0]
+	assert.Equal(t, "example.com", zone.Name)
+	assert.True(t, zone.Private)
+	assert.Equal(t, []*routing.VPC{hub, spoke}, zone.VPCs)
+	assert.Same(t, zone, md.Zone("environments/app:data.aws_route53_zone.corp"), "the lookup shares the managed zone")
+	assert.Len(t, zone.Records, 6)
+
+	api := md.Records[1]
+	require.Equal(t, "api.example.com", api.Name)
+	assert.True(t, api.Alias)
+	require.Len(t, api.Targets, 1)
+	target := api.Targets[0]
+	assert.Equal(t, "environments/app:aws_vpc_endpoint.api", target.ID, "the lookup is followed to the endpoint")
+	assert.Same(t, hub, target.VPC)
+	require.Len(t, target.Subnets, 2)
+	assert.True(t, target.Synthetic)
+	for i, ip := range target.Addresses {
+		assert.True(t, target.Subnets[i].Contains(ip), "%s should be in %s", ip, target.Subnets[i])
+	}
+
+	inbound := md.Resolver("environments/app:aws_route53_resolver_endpoint.inbound")
+	require.NotNil(t, inbound)
+	assert.Same(t, hub, inbound.VPC)
+	require.Len(t, inbound.Addresses, 2)
+	assert.Equal(t, "10.1.1.5", inbound.Addresses[0].String())
+	assert.True(t, hub.CIDR.Contains(inbound.Addresses[1]))
+	assert.Equal(t, []*ResolverEndpoint{inbound}, md.Inbound())
+
+	require.Len(t, md.EndpointZones, 1)
+	assert.Equal(t, "execute-api.ap-northeast-2.amazonaws.com", md.EndpointZones[0].Name)
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	md, hub, spoke := loadSample(t, Options{Addresses: map[string][]string{
+		"environments/app:aws_vpc_endpoint.api": {"10.1.1.21", "10.1.2.75"},
+	}})
+
+	tests := []struct {
+		vpc    *routing.VPC
+		name   string
+		source Source
+		want   string
+	}{
+		{hub, "APP.example.com.", FromZone, "app.example.com: 10.1.1.10, 10.1.2.10"},
+		{hub, "api.example.com", FromZone, "api.example.com: 10.1.1.21, 10.1.2.75"},
+		{hub, "www.example.com", FromZone, "www.example.com: app.example.com -> 10.1.1.10, 10.1.2.10"},
+		{hub, "gateway.example.com", FromZone, "gateway.example.com: 10.1.1.21, 10.1.2.75"},
+		{hub, "a.b.tenants.example.com", FromZone, "a.b.tenants.example.com: app.example.com -> 10.1.1.10, 10.1.2.10"},
+		{hub, "missing.example.com", FromZone, "missing.example.com: NXDOMAIN"},
+		{hub, "abc123.execute-api.ap-northeast-2.amazonaws.com", FromEndpoint, "abc123.execute-api.ap-northeast-2.amazonaws.com: 10.1.1.21, 10.1.2.75"},
+		{hub, "host.onprem.example.com", FromZone, "host.onprem.example.com: NXDOMAIN"},
+		{spoke, "app.example.com", FromZone, "app.example.com: 10.1.1.10, 10.1.2.10"},
+		{spoke, "host.onprem.example.com", Forwarded, "host.onprem.example.com: forwarded by environments/app:aws_route53_resolver_rule.onprem to 192.168.0.2"},
+		{spoke, "ad.onprem.example.com", FromZone, "ad.onprem.example.com: 10.1.1.53"},
+		{spoke, "abc123.execute-api.ap-northeast-2.amazonaws.com", Public, "abc123.execute-api.ap-northeast-2.amazonaws.com: not in a private zone"},
+	}
+	for _, tc := range tests {
+		a := md.Resolve(tc.vpc, tc.name)
+		assert.Equal(t, tc.source, a.Source, "%s", a)
+		assert.Equal(t, tc.want, a.String())
+		assert.False(t, a.Synthetic, "%s", a)
+	}
+
+	assert.True(t, md.Resolve(spoke, "api.example.com").In(hub))
+	assert.False(t, md.Resolve(hub, "api.example.com").In(spoke))
+
+	inbound := md.Resolver("environments/app:aws_route53_resolver_endpoint.inbound")
+	assert.Equal(t, []string{"10.1.1.21", "10.1.2.75"}, addresses(md.ResolveInbound(inbound, "api.example.com")))
+	outbound := md.Resolver("environments/app:aws_route53_resolver_endpoint.outbound")
+	assert.NotEmpty(t, md.ResolveInbound(outbound, "api.example.com").Err)
+}
+
+func TestResolve_StopsLongCNAMEChains(t *testing.T) {
+	t.Parallel()
+
+	md, hub, _ := loadSample(t, Options{})
+	zone := md.Zones[0]
+	zone.Records = append(zone.Records, &Record{Zone: zone, Name: "loop.example.com", Type: "CNAME", Values: []string{"loop.example.com"}})
+	assert.Equal(t, "loop.example.com: CNAME chain longer than 8", md.Resolve(hub, "loop.example.com").String())
+}
+
+func TestSynthesize_StaysInsideUsableRange(t *testing.T) {
+	t.Parallel()
+
+	_, subnet, _ := net.ParseCIDR("10.1.1.0/28")
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		ip := synthesize(id, subnet)
+		assert.True(t, subnet.Contains(ip))
+		last := ip.To4()[3]
+		assert.True(t, last >= 4 && last < 15, "%s should skip reserved addresses", ip)
+	}
+}
+
+func TestServer_AnswersFromModel(t *testing.T) {
+	t.Parallel()
+
+	md, _, spoke := loadSample(t, Options{Addresses: map[string][]string{
+		"environments/app:aws_vpc_endpoint.api": {"10.1.1.21", "10.1.2.75"},
+	}})
+	srv, err := NewServer(md, spoke)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Close() })
+	r := srv.Resolver()
+	ctx := context.Background()
+
+	// Rooted names keep the search domains of the host out of the way.
+	ips, err := r.LookupHost(ctx, "www.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.1.10", "10.1.2.10"}, ips)
+	cname, err := r.LookupCNAME(ctx, "www.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com.", cname)
+
+	ips, err = r.LookupHost(ctx, "API.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.1.21", "10.1.2.75"}, ips)
+
+	_, err = r.LookupHost(ctx, "missing.example.com.")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound, "a private zone answers NXDOMAIN for names it does not have")
+
+	_, err = r.LookupHost(ctx, "host.onprem.example.com.")
+	require.ErrorAs(t, err, &dnsErr)
+	assert.False(t, dnsErr.IsNotFound, "forwarded names cannot be answered offline")
+}
+
+func TestServer_RespondsToMalformedQueries(t *testing.T) {
+	t.Parallel()
+
+	md, hub, _ := loadSample(t, Options{})
+	s := &Server{Model: md, VPC: hub}
+	assert.Nil(t, s.respond([]byte{0, 1}), "too short")
+
+	resp := s.respond([]byte{0, 7, 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'a', 'p'})
+	require.Len(t, resp, 12)
+	assert.Equal(t, []byte{0, 7}, resp[:2])
+	assert.Equal(t, byte(rcodeServFail), resp[3]&0xf)
+
+	query := append([]byte{0, 8, 0x10, 0, 0, 1, 0, 0, 0, 0, 0, 0}, encodeName("app.example.com")...)
+	query = append(query, 0, typeA, 0, classIN)
+	assert.Equal(t, byte(rcodeNotImp), s.respond(query)[3]&0xf, "only standard queries are answered")
+}
@@ -0,0 +1,230 @@
+package dnszone
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+)
+
+// Source is what answers a query.
+type Source string
+
+const (
+	FromZone     Source = "zone"      // a private hosted zone associated with the VPC
+	FromEndpoint Source = "endpoint"  // the private DNS of an interface endpoint in the VPC
+	Forwarded    Source = "forwarded" // a forwarding rule sends the query elsewhere
+	Public       Source = "public"    // nothing private matches; public DNS answers
+)
+
+// maxChain bounds the CNAME chain Resolve follows, as resolvers do.
+const maxChain = 8
+
+// Answer is the result of an A query.
+type Answer struct {
+	Name   string
+	Source Source
+	Zone   *Zone         // FromZone only
+	Rule   *Rule         // Forwarded only
+	Via    *EndpointZone // FromEndpoint only
+	// NXDomain is set when a private zone is authoritative for the name but
+	// has no record for it. Private zones do not fall back to public DNS.
+	NXDomain bool
+	// CNAMEs are the names the query was redirected to, in order. CNAMEs to
+	// an endpoint or load balancer are answered with its addresses instead.
+	CNAMEs    []string
+	Records   []*Record // the records of the last name
+	Addresses []net.IP
+	Synthetic bool // some addresses are synthesized
+	Err       string
+}
+
+func (a Answer) String() string {
+	switch {
+	case a.Err != "":
+		return fmt.Sprintf("%s: %s", a.Name, a.Err)
+	case a.Source == Forwarded:
+		return fmt.Sprintf("%s: forwarded by %s to %s", a.Name, a.Rule.ID, strings.Join(a.Rule.TargetIPs, ", "))
+	case a.Source == Public:
+		return fmt.Sprintf("%s: not in a private zone", a.Name)
+	case a.NXDomain:
+		return fmt.Sprintf("%s: NXDOMAIN", a.Name)
+	}
+	var ips []string
+	for _, ip := range a.Addresses {
+		ips = append(ips, ip.String())
+	}
+	return fmt.Sprintf("%s: %s", a.Name, strings.Join(append(a.CNAMEs, strings.Join(ips, ", ")), " -> "))
+}
+
+// In reports whether the answer has addresses and all of them are in the
+// VPC's CIDR.
+func (a Answer) In(v *routing.VPC) bool {
+	if len(a.Addresses) == 0 || v == nil || v.CIDR == nil {
+		return false
+	}
+	for _, ip := range a.Addresses {
+		if !v.CIDR.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve answers an A query for name from inside a VPC. The most specific
+// of the associated forwarding rules, associated private zones and private
+// DNS zones of the VPC's interface endpoints answers; a rule wins a tie with
+// a zone, and a SYSTEM rule hands its domain back to the zones.
+func (md *Model) Resolve(v *routing.VPC, name string) Answer {
+	a := Answer{Name: canonical(name)}
+	current := a.Name
+	for step := 0; ; step++ {
+		if step == maxChain {
+			a.Err = fmt.Sprintf("CNAME chain longer than %d", maxChain)
+			return a
+		}
+		rule, zone, ez := md.authority(v, current)
+		switch {
+		case rule != nil:
+			a.Source, a.Rule = Forwarded, rule
+			return a
+		case zone != nil:
+			a.Source, a.Zone = FromZone, zone
+		case ez != nil:
+			a.Source, a.Via = FromEndpoint, ez
+			a.add(ez.Target)
+			return a
+		default:
+			a.Source = Public
+			return a
+		}
+
+		a.Records = zone.lookup(current)
+		if len(a.Records) == 0 {
+			a.NXDomain = true
+			return a
+		}
+		var next string
+		for _, r := range a.Records {
+			switch r.Type {
+			case "A":
+				for _, s := range r.Values {
+					if ip := net.ParseIP(s); ip != nil {
+						a.Addresses = append(a.Addresses, ip)
+					}
+				}
+				for _, t := range r.Targets {
+					a.add(t)
+				}
+			case "CNAME":
+				for _, t := range r.Targets {
+					a.add(t)
+				}
+				if len(r.Targets) == 0 && len(r.Values) > 0 {
+					next = canonical(r.Values[0])
+				}
+			}
+		}
+		if next == "" {
+			return a
+		}
+		a.CNAMEs = append(a.CNAMEs, next)
+		current = next
+	}
+}
+
+// ResolveInbound answers an A query sent from on-premises to an inbound
+// Resolver endpoint, which resolves it as its VPC does.
+func (md *Model) ResolveInbound(ep *ResolverEndpoint, name string) Answer {
+	if ep.Direction != "INBOUND" {
+		return Answer{Name: canonical(name), Err: ep.ID + " is not an inbound endpoint"}
+	}
+	return md.Resolve(ep.VPC, name)
+}
+
+// Inbound returns the inbound Resolver endpoints.
+func (md *Model) Inbound() []*ResolverEndpoint {
+	var out []*ResolverEndpoint
+	for _, ep := range md.Resolvers {
+		if ep.Direction == "INBOUND" {
+			out = append(out, ep)
+		}
+	}
+	return out
+}
+
+// authority returns the most specific forwarding rule, zone or endpoint zone
+// that covers name from the VPC. At most one result is set; within the
+// domain of a more specific SYSTEM rule, forwarding rules are ignored.
+func (md *Model) authority(v *routing.VPC, name string) (*Rule, *Zone, *EndpointZone) {
+	var rule *Rule
+	for _, r := range md.Rules {
+		if within(name, r.Domain) && associated(r.VPCs, v) && (rule == nil || len(r.Domain) > len(rule.Domain)) {
+			rule = r
+		}
+	}
+	var zone *Zone
+	for _, z := range md.Zones {
+		if z.Private && within(name, z.Name) && z.AssociatedWith(v) && (zone == nil || len(z.Name) > len(zone.Name)) {
+			zone = z
+		}
+	}
+	var ez *EndpointZone
+	for _, e := range md.EndpointZones {
+		if within(name, e.Name) && e.Target.VPC == v && (ez == nil || len(e.Name) > len(ez.Name)) {
+			ez = e
+		}
+	}
+
+	best := 0
+	if zone != nil {
+		best = len(zone.Name)
+	}
+	if ez != nil && len(ez.Name) > best {
+		return nil, nil, ez
+	}
+	if rule != nil && rule.Type == "FORWARD" && len(rule.Domain) >= best {
+		return rule, nil, nil
+	}
+	return nil, zone, nil
+}
+
+// lookup returns the records for name, or for the closest wildcard above
+// it when there are none.
+func (z *Zone) lookup(name string) []*Record {
+	var out []*Record
+	for _, r := range z.Records {
+		if r.Name == name {
+			out = append(out, r)
+		}
+	}
+	for parent := name; len(out) == 0 && parent != z.Name && strings.Contains(parent, "."); {
+		parent = parent[strings.Index(parent, ".")+1:]
+		for _, r := range z.Records {
+			if r.Name == "*."+parent {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+func (a *Answer) add(t *Target) {
+	a.Addresses = append(a.Addresses, t.Addresses...)
+	a.Synthetic = a.Synthetic || t.Synthetic
+}
+
+// within reports whether name is domain or below it.
+func within(name, domain string) bool {
+	return domain != "" && (name == domain || strings.HasSuffix(name, "."+domain))
+}
+
+func associated(vpcs []*routing.VPC, v *routing.VPC) bool {
+	for _, x := range vpcs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package dnszone
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+)
+
+// DNS message constants (RFC 1035).
+const (
+	typeA     = 1
+	typeCNAME = 5
+	classIN   = 1
+
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+
+	// cnameTTL is the TTL of CNAME answers, which Answer does not carry.
+	cnameTTL = 300
+	// aliasTTL is the TTL of alias answers; Route 53 uses the target's.
+	aliasTTL = 60
+)
+
+// Server is an in-process DNS server that answers A queries over UDP the way
+// the resolver of one VPC would, from a Model. Names a private zone or
+// endpoint answers get their records, or NXDOMAIN; names the model cannot
+// answer offline, public or forwarded ones, are REFUSED.
+type Server struct {
+	Model *Model
+	VPC   *routing.VPC
+
+	conn net.PacketConn
+	done chan struct{}
+}
+
+// NewServer starts a server on a loopback port. Close stops it.
+func NewServer(md *Model, v *routing.VPC) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Model: md, VPC: v, conn: conn, done: make(chan struct{})}
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on.
+func (s *Server) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+// Resolver returns a resolver that sends every query to the server.
+func (s *Server) Resolver() *net.Resolver {
+	return NewResolver(s.Addr())
+}
+
+// NewResolver returns a pure Go resolver that sends queries to the given
+// servers in turn, e.g. the Route 53 Resolver inbound endpoint addresses.
+// Servers without a port use port 53.
+func NewResolver(servers ...string) *net.Resolver {
+	var addrs []string
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		addrs = append(addrs, s)
+	}
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if len(addrs) == 0 {
+				return nil, errors.New("no DNS servers")
+			}
+			addr := addrs[int(atomic.AddUint32(&next, 1)-1)%len(addrs)]
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n]); resp != nil {
+			_, _ = s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// respond builds the response to a query, or returns nil for messages that
+// are not queries.
+func (s *Server) respond(query []byte) []byte {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil
+	}
+	id := binary.BigEndian.Uint16(query)
+	opcode := query[2] >> 3 & 0xf
+	rd := query[2] & 1
+	name, qtype, qclass, end, ok := question(query)
+	if !ok || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return header(id, 0, rd, rcodeServFail)
+	}
+
+	msg := header(id, 1, rd, 0)
+	msg = append(msg, query[12:end]...)
+	if opcode != 0 {
+		msg[3] = msg[3]&0xf0 | rcodeNotImp
+		return msg
+	}
+	if qclass != classIN {
+		msg[3] = msg[3]&0xf0 | rcodeRefused
+		return msg
+	}
+
+	a := s.Model.Resolve(s.VPC, name)
+	switch {
+	case a.Err != "":
+		msg[3] = msg[3]&0xf0 | rcodeServFail
+		return msg
+	case a.Source == Public || a.Source == Forwarded:
+		msg[3] = msg[3]&0xf0 | rcodeRefused
+		return msg
+	}
+	msg[2] |= 0x04 // authoritative
+	if a.NXDomain && len(a.CNAMEs) == 0 {
+		msg[3] = msg[3]&0xf0 | rcodeNXDomain
+		return msg
+	}
+
+	var answers uint16
+	owner := name
+	for _, cname := range a.CNAMEs {
+		msg = appendRR(msg, owner, typeCNAME, cnameTTL, encodeName(cname))
+		owner = cname
+		answers++
+	}
+	if qtype == typeA {
+		ttl := uint32(aliasTTL)
+		for _, r := range a.Records {
+			if r.TTL > 0 {
+				ttl = uint32(r.TTL)
+			}
+		}
+		for _, ip := range a.Addresses {
+			if ip4 := ip.To4(); ip4 != nil {
+				msg = appendRR(msg, owner, typeA, ttl, ip4)
+				answers++
+			}
+		}
+	}
+	if a.NXDomain {
+		msg[3] = msg[3]&0xf0 | rcodeNXDomain
+	}
+	binary.BigEndian.PutUint16(msg[6:], answers)
+	return msg
+}
+
+// question parses the first question of a message and returns its name,
+// type, class and the offset after it.
+func question(msg []byte) (string, uint16, uint16, int, bool) {
+	var labels []string
+	i := 12
+	for {
+		if i >= len(msg) {
+			return "", 0, 0, 0, false
+		}
+		l := int(msg[i])
+		i++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 || i+l > len(msg) {
+			return "", 0, 0, 0, false
+		}
+		labels = append(labels, string(msg[i:i+l]))
+		i += l
+	}
+	if i+4 > len(msg) {
+		return "", 0, 0, 0, false
+	}
+	name := canonical(strings.Join(labels, "."))
+	return name, binary.BigEndian.Uint16(msg[i:]), binary.BigEndian.Uint16(msg[i+2:]), i + 4, true
+}
+
+func header(id, qdcount uint16, rd, rcode byte) []byte {
+	h := make([]byte, 12)
+	binary.BigEndian.PutUint16(h, id)
+	h[2] = 0x80 | rd    // response
+	h[3] = 0x80 | rcode // recursion available
+	binary.BigEndian.PutUint16(h[4:], qdcount)
+	return h
+}
+
+func appendRR(msg []byte, name string, typ uint16, ttl uint32, rdata []byte) []byte {
+	msg = append(msg, encodeName(name)...)
+	msg = binary.BigEndian.AppendUint16(msg, typ)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+func encodeName(name string) []byte {
+	var out []byte
+	for _, label := range strings.Split(canonical(name), ".") {
+		if label == "" {
+			continue
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
@@ -0,0 +1,437 @@
+// Package eventbridge evaluates EventBridge event patterns and rebuilds the
+// rules of a Terraform tree with their targets, so that tests can state
+// which events trigger a rule and check that what a rule delivers to lets
+// EventBridge in.
+package eventbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// DefaultBus is the Bus of rules and targets without an event_bus_name.
+const DefaultBus = "default"
+
+// Rule is an aws_cloudwatch_event_rule.
+type Rule struct {
+	ID   string
+	Name string // unknown parts kept as tfconfig.Unknown
+	// Bus is the node ID of the aws_cloudwatch_event_bus the rule is on, the
+	// literal event_bus_name, or DefaultBus.
+	Bus      string
+	Pattern  *Pattern // nil for schedule-only rules
+	Schedule string
+	Enabled  bool
+	Targets  []*Target
+	Block    *tfconfig.Block
+}
+
+// Target is an aws_cloudwatch_event_target.
+type Target struct {
+	ID       string
+	TargetID string
+	Rule     *Rule
+	Bus      string // as Rule.Bus
+	// Destinations are the resources the arn resolves to, e.g. an
+	// aws_lambda_function or aws_sns_topic.
+	Destinations []tfconfig.Node
+	// InputPaths and InputTemplate are the input_transformer, if any.
+	InputPaths    map[string]string
+	InputTemplate string
+	Block         *tfconfig.Block
+}
+
+// Permission is an aws_lambda_permission.
+type Permission struct {
+	ID        string
+	Functions []tfconfig.Node // the aws_lambda_function nodes function_name resolves to
+	Principal string
+	// SourceARN is the source_arn with unknown references kept symbolic,
+	// e.g. "${aws_cloudwatch_event_rule.kiro_prompt_received.arn}", or ""
+	// when the permission is not limited to a source.
+	SourceARN string
+	Sources   []tfconfig.Node // the nodes source_arn resolves to
+	Block     *tfconfig.Block
+}
+
+// Model holds the rules of a tree and what their targets rely on.
+type Model struct {
+	Rules       []*Rule
+	Targets     []*Target
+	Permissions []*Permission
+
+	// Unresolved lists rules whose pattern does not compile and targets
+	// that could not be attached to a rule.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+}
+
+// Build collects the rules, targets and Lambda permissions of every module
+// in the tree.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph()}
+	rules := map[string]*Rule{}
+	for _, m := range tree.Modules {
+		for _, r := range md.collectRules(m) {
+			rules[r.ID] = r
+		}
+	}
+	for _, m := range tree.Modules {
+		md.collectTargets(m, rules)
+		md.collectPermissions(m)
+	}
+	sort.Slice(md.Rules, func(i, j int) bool { return md.Rules[i].ID < md.Rules[j].ID })
+	sort.Strings(md.Unresolved)
+	return md
+}
+
+// Rule returns the rule with the given node ID, or nil.
+func (md *Model) Rule(id string) *Rule {
+	for _, r := range md.Rules {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (md *Model) collectRules(m *tfconfig.Module) []*Rule {
+	var out []*Rule
+	for _, b := range instantiated(m.Resources("aws_cloudwatch_event_rule")) {
+		r := &Rule{ID: nodeID(m, b), Bus: md.bus(m, b), Enabled: true, Block: b}
+		if b.Has("name") {
+			r.Name, _ = m.PartialString(b.Expr("name"))
+		}
+		r.Schedule, _ = b.String("schedule_expression")
+		if state, ok := b.String("state"); ok {
+			r.Enabled = state != "DISABLED"
+		} else if enabled, ok := b.Bool("is_enabled"); ok {
+			r.Enabled = enabled
+		}
+		if b.Has("event_pattern") {
+			v, ok := b.JSON("event_pattern")
+			var err error
+			if !ok {
+				err = fmt.Errorf("event pattern: not statically known")
+			} else {
+				r.Pattern, err = Compile(v)
+			}
+			if err != nil {
+				md.Unresolved = append(md.Unresolved, r.ID+": "+err.Error())
+			}
+		}
+		md.Rules = append(md.Rules, r)
+		out = append(out, r)
+	}
+	return out
+}
+
+func (md *Model) collectTargets(m *tfconfig.Module, rules map[string]*Rule) {
+	for _, b := range instantiated(m.Resources("aws_cloudwatch_event_target")) {
+		t := &Target{ID: nodeID(m, b), Bus: md.bus(m, b), Block: b}
+		t.TargetID, _ = b.String("target_id")
+		for _, n := range md.Refs.ResolveExpr(m, b.Expr("rule")) {
+			if r := rules[n.String()]; r != nil {
+				t.Rule = r
+			}
+		}
+		if t.Rule == nil {
+			md.Unresolved = append(md.Unresolved, t.ID+": rule")
+			continue
+		}
+		t.Destinations = md.Refs.ResolveExpr(m, b.Expr("arn"))
+		for _, it := range b.Nested("input_transformer") {
+			t.InputPaths = map[string]string{}
+			if paths, ok := it.Partial("input_paths").(map[string]interface{}); ok {
+				for k, v := range paths {
+					t.InputPaths[k] = fmt.Sprint(v)
+				}
+			}
+			if it.Has("input_template") {
+				t.InputTemplate, _ = m.PartialString(it.Expr("input_template"))
+			}
+		}
+		t.Rule.Targets = append(t.Rule.Targets, t)
+		md.Targets = append(md.Targets, t)
+	}
+}
+
+func (md *Model) collectPermissions(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_permission")) {
+		p := &Permission{ID: nodeID(m, b), Block: b}
+		p.Principal, _ = b.String("principal")
+		if s, ok := m.Symbolic(b.Expr("source_arn")).(string); ok {
+			p.SourceARN = s
+		}
+		p.Sources = md.Refs.ResolveExpr(m, b.Expr("source_arn"))
+		for _, n := range md.Refs.ResolveExpr(m, b.Expr("function_name")) {
+			if n.Kind() == "aws_lambda_function" {
+				p.Functions = append(p.Functions, n)
+			}
+		}
+		md.Permissions = append(md.Permissions, p)
+	}
+}
+
+// bus returns the bus a rule or target is on.
+func (md *Model) bus(m *tfconfig.Module, b *tfconfig.Block) string {
+	if !b.Has("event_bus_name") {
+		return DefaultBus
+	}
+	for _, n := range md.Refs.ResolveExpr(m, b.Expr("event_bus_name")) {
+		if n.Kind() == "aws_cloudwatch_event_bus" {
+			return n.String()
+		}
+	}
+	s, _ := m.PartialString(b.Expr("event_bus_name"))
+	return s
+}
+
+// Triggers returns the enabled rules on a bus whose pattern matches the
+// event.
+func (md *Model) Triggers(bus string, event map[string]interface{}) []*Rule {
+	var out []*Rule
+	for _, r := range md.Rules {
+		if r.Bus == bus && r.Enabled && r.Pattern != nil && r.Pattern.Match(event) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+var inputPlaceholder = regexp.MustCompile(`<([A-Za-z0-9_.-]+)>`)
+
+// Input renders what the target delivers for an event: the event itself
+// without an input_transformer, the input_template with the input_paths
+// substituted otherwise. Paths the event does not have are an error, since
+// EventBridge would deliver them empty.
+func (t *Target) Input(event map[string]interface{}) (string, error) {
+	if t.InputPaths == nil {
+		data, err := json.Marshal(event)
+		return string(data), err
+	}
+	values := map[string]string{}
+	var missing []string
+	for name, path := range t.InputPaths {
+		v, ok := lookup(event, path)
+		if !ok {
+			missing = append(missing, path)
+			continue
+		}
+		values[name] = fmt.Sprint(v)
+	}
+	var unknown []string
+	out := inputPlaceholder.ReplaceAllStringFunc(t.InputTemplate, func(s string) string {
+		name := s[1 : len(s)-1]
+		if strings.HasPrefix(name, "aws.events.") {
+			return s // predefined variables such as <aws.events.rule-name>
+		}
+		if _, ok := t.InputPaths[name]; !ok {
+			unknown = append(unknown, name)
+			return s
+		}
+		return values[name]
+	})
+	sort.Strings(missing)
+	switch {
+	case len(unknown) > 0:
+		return out, fmt.Errorf("%s: input_template uses %s, which input_paths do not define", t.ID, strings.Join(unknown, ", "))
+	case len(missing) > 0:
+		return out, fmt.Errorf("%s: the event has no %s", t.ID, strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// lookup follows a simple JSONPath such as "$.detail.prompt_id".
+func lookup(event map[string]interface{}, path string) (interface{}, bool) {
+	if path == "$" {
+		return event, true
+	}
+	var v interface{} = event
+	for _, part := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Checks reported by Wiring.
+const (
+	CheckNoTarget         = "no-target"
+	CheckEventBus         = "event-bus"
+	CheckLambdaPermission = "lambda-permission"
+	CheckResourcePolicy   = "resource-policy"
+)
+
+// Wiring checks that every enabled rule delivers somewhere and that each
+// target lets EventBridge in:
+//
+//   - A target must be on the bus of its rule.
+//   - Lambda functions need an aws_lambda_permission for
+//     events.amazonaws.com that is open to any source or names the rule.
+//   - SNS topics, SQS queues and CloudWatch Logs log groups need a resource
+//     policy that lets events.amazonaws.com publish, send or write.
+func (md *Model) Wiring() []finding.Finding {
+	var out []finding.Finding
+	for _, r := range md.Rules {
+		add := func(subject, check, format string, args ...interface{}) {
+			out = append(out, finding.Finding{Scope: r.ID, Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+		}
+		if r.Enabled && len(r.Targets) == 0 {
+			add(r.Block.Address(), CheckNoTarget, "the rule has no aws_cloudwatch_event_target")
+		}
+		for _, t := range r.Targets {
+			if t.Bus != r.Bus {
+				add(t.Block.Address(), CheckEventBus, "the target is on %s but its rule is on %s", t.Bus, r.Bus)
+			}
+			for _, d := range t.Destinations {
+				switch d.Kind() {
+				case "aws_lambda_function":
+					if !md.permitted(d, r) {
+						add(t.Block.Address(), CheckLambdaPermission, "no aws_lambda_permission lets events.amazonaws.com invoke %s from %s", d, r.ID)
+					}
+				case "aws_sns_topic", "aws_sqs_queue", "aws_cloudwatch_log_group":
+					if detail := md.resourcePolicy(d); detail != "" {
+						add(t.Block.Address(), CheckResourcePolicy, "%s", detail)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (md *Model) permitted(fn tfconfig.Node, r *Rule) bool {
+	for _, p := range md.Permissions {
+		if p.Principal != "events.amazonaws.com" || !contains(p.Functions, fn) {
+			continue
+		}
+		if p.SourceARN == "" {
+			return true
+		}
+		for _, s := range p.Sources {
+			if s.String() == r.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resourcePolicies lists, per destination type, the policy resources that
+// attach to it, the attribute that names the destination and the action
+// EventBridge needs. Log group resource policies are account-wide and name
+// their log groups in the policy.
+var resourcePolicies = map[string]struct {
+	policy, attr, action string
+}{
+	"aws_sns_topic":            {"aws_sns_topic_policy", "arn", "sns:Publish"},
+	"aws_sqs_queue":            {"aws_sqs_queue_policy", "queue_url", "sqs:SendMessage"},
+	"aws_cloudwatch_log_group": {"aws_cloudwatch_log_resource_policy", "", "logs:PutLogEvents"},
+}
+
+// resourcePolicy returns why a destination's resource policy does not let
+// EventBridge deliver to it, or "".
+func (md *Model) resourcePolicy(d tfconfig.Node) string {
+	rp := resourcePolicies[d.Kind()]
+	m := d.Block().Module()
+	found := false
+	for _, b := range instantiated(m.Resources(rp.policy)) {
+		if rp.attr != "" && !contains(md.Refs.ResolveExpr(m, b.Expr(rp.attr)), d) {
+			continue
+		}
+		expr := b.Expr("policy")
+		if rp.attr == "" {
+			expr = b.Expr("policy_document")
+		}
+		doc, err := iampolicy.FromExpr(md.Refs, m, nodeID(m, b), expr)
+		if err != nil {
+			return err.Error()
+		}
+		found = true
+		if allowsService(doc, "events.amazonaws.com", rp.action) {
+			return ""
+		}
+	}
+	if !found {
+		return fmt.Sprintf("%s has no %s", d, rp.policy)
+	}
+	return fmt.Sprintf("no %s lets events.amazonaws.com call %s on %s", rp.policy, rp.action, d)
+}
+
+// allowsService reports whether an Allow statement grants a service
+// principal the action and no Deny statement for it applies.
+func allowsService(doc *iampolicy.Document, service, action string) bool {
+	allowed := false
+	for _, st := range doc.Statements {
+		if !serviceIn(st.Principals, service) || !matchesAny(st.Actions, action) {
+			continue
+		}
+		switch st.Effect {
+		case iampolicy.Deny:
+			return false
+		case iampolicy.Allow:
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func serviceIn(principals map[string][]string, service string) bool {
+	if _, ok := principals["*"]; ok {
+		return true
+	}
+	for _, s := range append(principals["Service"], principals["AWS"]...) {
+		if s == "*" || s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if iampolicy.MatchAction(p, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(nodes []tfconfig.Node, n tfconfig.Node) bool {
+	for _, x := range nodes {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
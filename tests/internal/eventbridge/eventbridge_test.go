@@ -0,0 +1,341 @@
+package eventbridge
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleRules has a custom bus with an upload rule that invokes a Lambda
+// function through an input transformer, an error rule that notifies an SNS
+// topic whose policy only lets CloudWatch in, a catch-all rule that logs to
+// a log group with a resource policy for EventBridge, and a rule without a
+// target. One target is declared on the default bus.
+const sampleRules = `
+resource "aws_cloudwatch_event_bus" "app" {
+  name = "app"
+}
+
+resource "aws_cloudwatch_event_rule" "upload" {
+  name           = "upload"
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+
+  event_pattern = jsonencode({
+    source      = ["app.storage"]
+    detail-type = ["Object Created"]
+    detail = {
+      key  = [{ suffix = ".pdf" }]
+      size = [{ numeric = [">", 0, "<=", 1048576] }]
+    }
+  })
+}
+
+resource "aws_cloudwatch_event_target" "upload" {
+  rule           = aws_cloudwatch_event_rule.upload.name
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+  arn            = aws_lambda_function.indexer.arn
+
+  input_transformer {
+    input_paths = {
+      key  = "$.detail.key"
+      user = "$.detail.user.id"
+    }
+    input_template = "{\"key\": \"<key>\", \"user\": \"<user>\", \"rule\": \"<aws.events.rule-name>\"}"
+  }
+}
+
+resource "aws_lambda_function" "indexer" {
+  function_name = "indexer"
+}
+
+resource "aws_lambda_permission" "indexer" {
+  function_name = aws_lambda_function.indexer.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.upload.arn
+}
+
+resource "aws_cloudwatch_event_rule" "errors" {
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+
+  event_pattern = jsonencode({
+    source      = [{ prefix = "app." }]
+    detail-type = [{ anything-but = ["Object Created", "Heartbeat"] }]
+  })
+}
+
+resource "aws_cloudwatch_event_target" "errors_topic" {
+  rule = aws_cloudwatch_event_rule.errors.name
+  arn  = aws_sns_topic.errors.arn
+}
+
+resource "aws_cloudwatch_event_target" "errors_indexer" {
+  rule           = aws_cloudwatch_event_rule.errors.name
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+  arn            = aws_lambda_function.indexer.arn
+}
+
+resource "aws_sns_topic" "errors" {
+  name = "errors"
+}
+
+resource "aws_sns_topic_policy" "errors" {
+  arn = aws_sns_topic.errors.arn
+  policy = jsonencode({
+    Statement = [{
+      Effect    = "Allow"
+      Principal = { Service = "cloudwatch.amazonaws.com" }
+      Action    = "SNS:Publish"
+      Resource  = aws_sns_topic.errors.arn
+    }]
+  })
+}
+
+resource "aws_cloudwatch_event_rule" "all" {
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+
+  event_pattern = jsonencode({
+    source = [{ wildcard = "app.*" }]
+  })
+}
+
+resource "aws_cloudwatch_event_target" "all" {
+  rule           = aws_cloudwatch_event_rule.all.name
+  event_bus_name = aws_cloudwatch_event_bus.app.name
+  arn            = aws_cloudwatch_log_group.events.arn
+}
+
+resource "aws_cloudwatch_log_group" "events" {
+  name = "/aws/events/app"
+}
+
+resource "aws_cloudwatch_log_resource_policy" "events" {
+  policy_name = "events"
+  policy_document = jsonencode({
+    Statement = [{
+      Effect    = "Allow"
+      Principal = { Service = ["events.amazonaws.com", "delivery.logs.amazonaws.com"] }
+      Action    = ["logs:CreateLogStream", "logs:PutLogEvents"]
+      Resource  = "${aws_cloudwatch_log_group.events.arn}:*"
+    }]
+  })
+}
+
+resource "aws_cloudwatch_event_rule" "nightly" {
+  schedule_expression = "cron(0 18 * * ? *)"
+}
+
+resource "aws_cloudwatch_event_rule" "off" {
+  state         = "DISABLED"
+  event_pattern = jsonencode({ source = ["app.storage"] })
+}
+`
+
+func loadSample(t *testing.T) *Model {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleRules), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unresolved)
+	require.Len(t, md.Rules, 5)
+	return md
+}
+
+func event(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &out))
+	return out
+}
+
+func TestPattern_Match(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{
+			name:    "exact",
+			pattern: `{"source": ["kiro.subscription"], "detail": {"status": ["success"], "retries": [0], "final": [true], "error": [null]}}`,
+			match:   []string{`{"source": "kiro.subscription", "detail": {"status": "success", "retries": 0, "final": true, "error": null}}`},
+			noMatch: []string{
+				`{"source": "kiro.subscription", "detail": {"status": "Success", "retries": 0, "final": true, "error": null}}`,
+				`{"source": "kiro.subscription", "detail": {"status": "success", "retries": "0", "final": true, "error": null}}`,
+				`{"source": "kiro.subscription", "detail": {"status": "success", "retries": 0, "final": true}}`,
+				`{"source": "kiro.subscription"}`,
+			},
+		},
+		{
+			name:    "arrays match any element",
+			pattern: `{"resources": ["arn:aws:s3:::docs"]}`,
+			match:   []string{`{"resources": ["arn:aws:s3:::logs", "arn:aws:s3:::docs"]}`},
+			noMatch: []string{`{"resources": []}`, `{"resources": "arn:aws:s3:::logs"}`},
+		},
+		{
+			name:    "prefix and suffix",
+			pattern: `{"source": [{"prefix": "kiro."}], "detail": {"key": [{"suffix": {"equals-ignore-case": ".PDF"}}]}}`,
+			match:   []string{`{"source": "kiro.sub", "detail": {"key": "a/b.pdf"}}`, `{"source": "kiro.", "detail": {"key": "B.Pdf"}}`},
+			noMatch: []string{`{"source": "aws.kiro.sub", "detail": {"key": "a.pdf"}}`, `{"source": "kiro.sub", "detail": {"key": "a.pdf.txt"}}`},
+		},
+		{
+			name:    "anything-but",
+			pattern: `{"detail": {"status": [{"anything-but": ["success", "skipped"]}], "code": [{"anything-but": 200}], "env": [{"anything-but": {"prefix": "prod"}}]}}`,
+			match:   []string{`{"detail": {"status": "failed", "code": 500, "env": "dev"}}`},
+			noMatch: []string{
+				`{"detail": {"status": "success", "code": 500, "env": "dev"}}`,
+				`{"detail": {"status": "failed", "code": 200, "env": "dev"}}`,
+				`{"detail": {"status": "failed", "code": 500, "env": "prod-kr"}}`,
+				`{"detail": {"code": 500, "env": "dev"}}`,
+			},
+		},
+		{
+			name:    "numeric",
+			pattern: `{"detail": {"size": [{"numeric": [">", 0, "<=", 5]}], "tokens": [{"numeric": ["=", 100]}, 7]}}`,
+			match:   []string{`{"detail": {"size": 5, "tokens": 100}}`, `{"detail": {"size": 0.5, "tokens": 7}}`},
+			noMatch: []string{`{"detail": {"size": 0, "tokens": 100}}`, `{"detail": {"size": 5.5, "tokens": 100}}`, `{"detail": {"size": "3", "tokens": 100}}`},
+		},
+		{
+			name:    "exists",
+			pattern: `{"detail": {"prompt_id": [{"exists": true}], "error": [{"exists": false}]}}`,
+			match:   []string{`{"detail": {"prompt_id": "p-1"}}`, `{"detail": {"prompt_id": null, "error": []}}`},
+			noMatch: []string{`{"detail": {}}`, `{"detail": {"prompt_id": "p-1", "error": "boom"}}`, `{"detail": {"prompt_id": {"id": "p-1"}}}`},
+		},
+		{
+			name:    "wildcard",
+			pattern: `{"detail": {"key": [{"wildcard": "prompts/*/2024-*.json"}], "name": [{"wildcard": "a\\*b*"}]}}`,
+			match:   []string{`{"detail": {"key": "prompts/u1/2024-01-01.json", "name": "a*bc"}}`, `{"detail": {"key": "prompts//2024-.json", "name": "a*b"}}`},
+			noMatch: []string{`{"detail": {"key": "prompts/u1/2023-01-01.json", "name": "a*b"}}`, `{"detail": {"key": "prompts/u1/2024-01-01.json", "name": "axb"}}`},
+		},
+		{
+			name:    "cidr and equals-ignore-case",
+			pattern: `{"detail": {"ip": [{"cidr": "10.10.0.0/16"}], "region": [{"equals-ignore-case": "AP-NORTHEAST-2"}]}}`,
+			match:   []string{`{"detail": {"ip": "10.10.3.4", "region": "ap-northeast-2"}}`},
+			noMatch: []string{`{"detail": {"ip": "10.11.0.1", "region": "ap-northeast-2"}}`, `{"detail": {"ip": "10.10.3.4", "region": "us-east-1"}}`},
+		},
+		{
+			name:    "$or",
+			pattern: `{"source": ["kiro.subscription"], "$or": [{"detail-type": ["Prompt Error"]}, {"detail": {"status": ["failed"]}}]}`,
+			match:   []string{`{"source": "kiro.subscription", "detail-type": "Prompt Error"}`, `{"source": "kiro.subscription", "detail": {"status": "failed"}}`},
+			noMatch: []string{`{"source": "kiro.subscription", "detail-type": "Prompt Received", "detail": {"status": "success"}}`, `{"source": "other", "detail-type": "Prompt Error"}`},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := Parse([]byte(tc.pattern))
+			require.NoError(t, err)
+			for _, e := range tc.match {
+				ok, err := p.MatchJSON([]byte(e))
+				require.NoError(t, err)
+				assert.True(t, ok, "%s should match %s", tc.pattern, e)
+			}
+			for _, e := range tc.noMatch {
+				ok, err := p.MatchJSON([]byte(e))
+				require.NoError(t, err)
+				assert.False(t, ok, "%s should not match %s", tc.pattern, e)
+			}
+		})
+	}
+}
+
+func TestParse_RejectsInvalidPatterns(t *testing.T) {
+	t.Parallel()
+
+	for pattern, want := range map[string]string{
+		`[]`:                                               "event pattern: must be a non-empty object",
+		`{"source": "kiro"}`:                               "source: leaf values must be arrays, got kiro",
+		`{"source": []}`:                                   "source: empty array matches nothing",
+		`{"source": [{"regex": "k.*"}]}`:                   "source regex: unknown operator",
+		`{"source": [{"prefix": 1}]}`:                      "source prefix: 1 is not a string",
+		`{"a": [{"numeric": ["<", 5, ">", 9]}]}`:           "a numeric: a range needs a lower and an upper bound in order",
+		`{"a": [{"numeric": ["~", 5]}]}`:                   "a numeric: unknown comparison ~",
+		`{"a": [{"anything-but": {"numeric": [">", 1]}}]}`: "a anything-but: numeric cannot be negated",
+		`{"a": [{"wildcard": "a**"}]}`:                     `a wildcard: "a**" has consecutive wildcards`,
+		`{"a": [{"exists": "yes"}]}`:                       "a exists: must be true or false",
+		`{"d": {"$or": [{"a": ["x"]}]}}`:                   "d.$or: needs at least two alternatives",
+		`{"a": ["${unknown}"]}`:                            `a: "${unknown}" is not statically known`,
+	} {
+		_, err := Parse([]byte(pattern))
+		if assert.Error(t, err, pattern) {
+			assert.Equal(t, want, err.Error())
+		}
+	}
+}
+
+func TestBuild_CollectsRulesAndTargets(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	upload := md.Rule("environments/app:aws_cloudwatch_event_rule.upload")
+	require.NotNil(t, upload)
+	assert.Equal(t, "environments/app:aws_cloudwatch_event_bus.app", upload.Bus)
+	require.Len(t, upload.Targets, 1)
+	target := upload.Targets[0]
+	require.Len(t, target.Destinations, 1)
+	assert.Equal(t, "environments/app:aws_lambda_function.indexer", target.Destinations[0].String())
+
+	nightly := md.Rule("environments/app:aws_cloudwatch_event_rule.nightly")
+	assert.Nil(t, nightly.Pattern)
+	assert.Equal(t, DefaultBus, nightly.Bus)
+	assert.False(t, md.Rule("environments/app:aws_cloudwatch_event_rule.off").Enabled)
+
+	pdf := event(t, `{"source": "app.storage", "detail-type": "Object Created", "detail": {"key": "a.pdf", "size": 10, "user": {"id": "u-1"}}}`)
+	var triggered []string
+	for _, r := range md.Triggers(upload.Bus, pdf) {
+		triggered = append(triggered, r.Block.Address())
+	}
+	assert.Equal(t, []string{"aws_cloudwatch_event_rule.all", "aws_cloudwatch_event_rule.upload"}, triggered,
+		"the disabled rule and rules on other buses are not triggered")
+
+	input, err := target.Input(pdf)
+	require.NoError(t, err)
+	assert.Equal(t, `{"key": "a.pdf", "user": "u-1", "rule": "<aws.events.rule-name>"}`, input)
+
+	delete(pdf["detail"].(map[string]interface{}), "user")
+	_, err = target.Input(pdf)
+	assert.EqualError(t, err, "environments/app:aws_cloudwatch_event_target.upload: the event has no $.detail.user.id")
+}
+
+func TestWiring(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	var keys []string
+	for _, f := range md.Wiring() {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		"environments/app:aws_cloudwatch_event_rule.errors aws_cloudwatch_event_target.errors_topic event-bus",
+		"environments/app:aws_cloudwatch_event_rule.errors aws_cloudwatch_event_target.errors_topic resource-policy",
+		"environments/app:aws_cloudwatch_event_rule.errors aws_cloudwatch_event_target.errors_indexer lambda-permission",
+		"environments/app:aws_cloudwatch_event_rule.nightly aws_cloudwatch_event_rule.nightly no-target",
+	}, keys)
+
+	// A permission open to any source covers every rule.
+	require.Len(t, md.Permissions, 1)
+	assert.Equal(t, "${aws_cloudwatch_event_rule.upload.arn}", md.Permissions[0].SourceARN)
+	md.Permissions[0].SourceARN = ""
+	assert.NotContains(t, md.Wiring(), finding.Finding{
+		Scope:   "environments/app:aws_cloudwatch_event_rule.errors",
+		Subject: "aws_cloudwatch_event_target.errors_indexer",
+		Check:   CheckLambdaPermission,
+		Detail: "no aws_lambda_permission lets events.amazonaws.com invoke environments/app:aws_lambda_function.indexer " +
+			"from environments/app:aws_cloudwatch_event_rule.errors",
+	})
+}
@@ -0,0 +1,484 @@
+package eventbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Pattern is a compiled event pattern. An event matches when every field of
+// the pattern matches and, for each $or, at least one of its alternatives.
+//
+// A field whose pattern value is an object matches the event field of the
+// same name as a nested pattern. A field whose pattern value is an array
+// matches when any of its matchers matches the event value, or any element
+// of it when the event value is an array. Matchers are literals (strings,
+// numbers, booleans and null, matched exactly) and the content filters
+// prefix, suffix, equals-ignore-case, anything-but, numeric, exists,
+// wildcard and cidr.
+type Pattern struct {
+	fields []*field
+	or     [][]*Pattern
+}
+
+type field struct {
+	name     string
+	nested   *Pattern
+	matchers []matcher
+}
+
+// matcher matches a leaf of an event. present is false when the event has
+// no field of that name; v is never an array.
+type matcher func(v interface{}, present bool) bool
+
+// Parse compiles a pattern from its JSON form.
+func Parse(data []byte) (*Pattern, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("event pattern: %w", err)
+	}
+	return Compile(v)
+}
+
+// Compile compiles a decoded pattern, as returned by encoding/json or by
+// tfconfig.Module.JSON for an event_pattern attribute. Parts that are not
+// statically known are an error.
+func Compile(v interface{}) (*Pattern, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok || len(obj) == 0 {
+		return nil, fmt.Errorf("event pattern: must be a non-empty object")
+	}
+	return compileObject("", obj)
+}
+
+func compileObject(path string, obj map[string]interface{}) (*Pattern, error) {
+	p := &Pattern{}
+	for _, name := range sortedKeys(obj) {
+		at := join(path, name)
+		switch val := obj[name].(type) {
+		case map[string]interface{}:
+			if len(val) == 0 {
+				return nil, fmt.Errorf("%s: empty object", at)
+			}
+			nested, err := compileObject(at, val)
+			if err != nil {
+				return nil, err
+			}
+			p.fields = append(p.fields, &field{name: name, nested: nested})
+		case []interface{}:
+			if name == "$or" {
+				alts, err := compileOr(path, val)
+				if err != nil {
+					return nil, err
+				}
+				p.or = append(p.or, alts)
+				continue
+			}
+			if len(val) == 0 {
+				return nil, fmt.Errorf("%s: empty array matches nothing", at)
+			}
+			f := &field{name: name}
+			for _, item := range val {
+				m, err := compileMatcher(at, item)
+				if err != nil {
+					return nil, err
+				}
+				f.matchers = append(f.matchers, m)
+			}
+			p.fields = append(p.fields, f)
+		default:
+			return nil, fmt.Errorf("%s: leaf values must be arrays, got %v", at, val)
+		}
+	}
+	return p, nil
+}
+
+// compileOr compiles the alternatives of a $or, whose fields are relative
+// to the object that holds it.
+func compileOr(path string, alts []interface{}) ([]*Pattern, error) {
+	at := join(path, "$or")
+	if len(alts) < 2 {
+		return nil, fmt.Errorf("%s: needs at least two alternatives", at)
+	}
+	var out []*Pattern
+	for _, alt := range alts {
+		obj, ok := alt.(map[string]interface{})
+		if !ok || len(obj) == 0 {
+			return nil, fmt.Errorf("%s: alternatives must be non-empty objects", at)
+		}
+		p, err := compileObject(path, obj)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func compileMatcher(path string, item interface{}) (matcher, error) {
+	op, ok := item.(map[string]interface{})
+	if !ok {
+		if s, ok := item.(string); ok && strings.Contains(s, tfconfig.Unknown) {
+			return nil, fmt.Errorf("%s: %q is not statically known", path, s)
+		}
+		return exact(item), nil
+	}
+	if len(op) != 1 {
+		return nil, fmt.Errorf("%s: a content filter has exactly one operator, got %v", path, sortedKeys(op))
+	}
+	for name, arg := range op {
+		at := path + " " + name
+		switch name {
+		case "prefix", "suffix":
+			return compileAffix(at, name, arg)
+		case "equals-ignore-case":
+			s, err := stringArg(at, arg)
+			if err != nil {
+				return nil, err
+			}
+			return func(v interface{}, present bool) bool {
+				e, ok := v.(string)
+				return present && ok && strings.EqualFold(e, s)
+			}, nil
+		case "anything-but":
+			return compileAnythingBut(at, arg)
+		case "numeric":
+			return compileNumeric(at, arg)
+		case "exists":
+			want, ok := arg.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be true or false", at)
+			}
+			return func(v interface{}, present bool) bool {
+				if _, isObject := v.(map[string]interface{}); isObject {
+					present = false // exists only matches leaves
+				}
+				return present == want
+			}, nil
+		case "wildcard":
+			s, err := stringArg(at, arg)
+			if err != nil {
+				return nil, err
+			}
+			w, err := compileWildcard(at, s)
+			if err != nil {
+				return nil, err
+			}
+			return func(v interface{}, present bool) bool {
+				e, ok := v.(string)
+				return present && ok && w.match(e)
+			}, nil
+		case "cidr":
+			s, err := stringArg(at, arg)
+			if err != nil {
+				return nil, err
+			}
+			_, cidr, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", at, err)
+			}
+			return func(v interface{}, present bool) bool {
+				e, _ := v.(string)
+				ip := net.ParseIP(e)
+				return present && ip != nil && cidr.Contains(ip)
+			}, nil
+		default:
+			return nil, fmt.Errorf("%s: unknown operator", at)
+		}
+	}
+	panic("unreachable")
+}
+
+// exact matches a literal. Numbers compare by value.
+func exact(want interface{}) matcher {
+	return func(v interface{}, present bool) bool {
+		if !present {
+			return false
+		}
+		switch w := want.(type) {
+		case nil:
+			return v == nil
+		case float64:
+			e, ok := v.(float64)
+			return ok && e == w
+		default:
+			return v == want
+		}
+	}
+}
+
+// compileAffix compiles prefix and suffix, whose argument is a string or an
+// equals-ignore-case filter.
+func compileAffix(path, name string, arg interface{}) (matcher, error) {
+	fold := false
+	if obj, ok := arg.(map[string]interface{}); ok && len(obj) == 1 && obj["equals-ignore-case"] != nil {
+		fold, arg = true, obj["equals-ignore-case"]
+	}
+	s, err := stringArg(path, arg)
+	if err != nil {
+		return nil, err
+	}
+	has := strings.HasPrefix
+	if name == "suffix" {
+		has = strings.HasSuffix
+	}
+	return func(v interface{}, present bool) bool {
+		e, ok := v.(string)
+		if !present || !ok {
+			return false
+		}
+		if fold {
+			return has(strings.ToLower(e), strings.ToLower(s))
+		}
+		return has(e, s)
+	}, nil
+}
+
+// compileAnythingBut matches present values none of its arguments match:
+// a literal, a list of literals, or a prefix, suffix, wildcard or
+// equals-ignore-case filter.
+func compileAnythingBut(path string, arg interface{}) (matcher, error) {
+	var excluded []matcher
+	switch a := arg.(type) {
+	case []interface{}:
+		if len(a) == 0 {
+			return nil, fmt.Errorf("%s: empty list", path)
+		}
+		for _, item := range a {
+			if _, ok := item.(map[string]interface{}); ok {
+				return nil, fmt.Errorf("%s: lists hold literals only", path)
+			}
+			m, err := compileMatcher(path, item)
+			if err != nil {
+				return nil, err
+			}
+			excluded = append(excluded, m)
+		}
+	case map[string]interface{}:
+		for name := range a {
+			switch name {
+			case "prefix", "suffix", "wildcard", "equals-ignore-case":
+			default:
+				return nil, fmt.Errorf("%s: %s cannot be negated", path, name)
+			}
+		}
+		m, err := compileMatcher(path, a)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, m)
+	default:
+		m, err := compileMatcher(path, a)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, m)
+	}
+	return func(v interface{}, present bool) bool {
+		if _, isObject := v.(map[string]interface{}); !present || isObject {
+			return false
+		}
+		for _, m := range excluded {
+			if m(v, present) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileNumeric compiles a list of comparisons, e.g. [">", 0, "<=", 5],
+// that must all hold.
+func compileNumeric(path string, arg interface{}) (matcher, error) {
+	list, ok := arg.([]interface{})
+	if !ok || len(list) == 0 || len(list)%2 != 0 || len(list) > 4 {
+		return nil, fmt.Errorf("%s: must be one or two operator and number pairs", path)
+	}
+	type bound struct {
+		op string
+		n  float64
+	}
+	var bounds []bound
+	for i := 0; i < len(list); i += 2 {
+		op, _ := list[i].(string)
+		n, ok := list[i+1].(float64)
+		switch {
+		case op != "<" && op != "<=" && op != "=" && op != ">" && op != ">=":
+			return nil, fmt.Errorf("%s: unknown comparison %v", path, list[i])
+		case !ok:
+			return nil, fmt.Errorf("%s: %v is not a number", path, list[i+1])
+		}
+		bounds = append(bounds, bound{op, n})
+	}
+	if len(bounds) == 2 {
+		lo, hi := bounds[0], bounds[1]
+		if strings.HasPrefix(lo.op, "<") {
+			lo, hi = hi, lo
+		}
+		if !strings.HasPrefix(lo.op, ">") || !strings.HasPrefix(hi.op, "<") || lo.n > hi.n {
+			return nil, fmt.Errorf("%s: a range needs a lower and an upper bound in order", path)
+		}
+	}
+	return func(v interface{}, present bool) bool {
+		e, ok := v.(float64)
+		if !present || !ok {
+			return false
+		}
+		for _, b := range bounds {
+			var holds bool
+			switch b.op {
+			case "<":
+				holds = e < b.n
+			case "<=":
+				holds = e <= b.n
+			case "=":
+				holds = e == b.n
+			case ">":
+				holds = e > b.n
+			case ">=":
+				holds = e >= b.n
+			}
+			if !holds {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// wildcard is a wildcard filter split at its stars.
+type wildcard struct {
+	literals []string // literals[0] before the first star, and so on
+}
+
+// compileWildcard parses a wildcard filter, where * matches any sequence and
+// \* and \\ stand for a literal star and backslash.
+func compileWildcard(path, s string) (*wildcard, error) {
+	w := &wildcard{}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			if i+1 == len(s) || (s[i+1] != '*' && s[i+1] != '\\') {
+				return nil, fmt.Errorf("%s: %q has an invalid escape", path, s)
+			}
+			i++
+			sb.WriteByte(s[i])
+		case c == '*':
+			if i > 0 && s[i-1] == '*' {
+				return nil, fmt.Errorf("%s: %q has consecutive wildcards", path, s)
+			}
+			w.literals = append(w.literals, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	w.literals = append(w.literals, sb.String())
+	return w, nil
+}
+
+func (w *wildcard) match(s string) bool {
+	first, last := w.literals[0], w.literals[len(w.literals)-1]
+	if len(w.literals) == 1 {
+		return s == first
+	}
+	if len(s) < len(first)+len(last) || !strings.HasPrefix(s, first) || !strings.HasSuffix(s, last) {
+		return false
+	}
+	// Leftmost matches of the middle literals leave the most room for the
+	// rest, so no backtracking is needed.
+	rest := s[len(first) : len(s)-len(last)]
+	for _, lit := range w.literals[1 : len(w.literals)-1] {
+		i := strings.Index(rest, lit)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(lit):]
+	}
+	return true
+}
+
+// Match reports whether a decoded event matches the pattern.
+func (p *Pattern) Match(event map[string]interface{}) bool {
+	for _, f := range p.fields {
+		v, present := event[f.name]
+		if !f.match(v, present) {
+			return false
+		}
+	}
+	for _, alts := range p.or {
+		matched := false
+		for _, alt := range alts {
+			matched = matched || alt.Match(event)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchJSON reports whether an event in its JSON form matches the pattern.
+func (p *Pattern) MatchJSON(data []byte) (bool, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false, fmt.Errorf("event: %w", err)
+	}
+	return p.Match(event), nil
+}
+
+func (f *field) match(v interface{}, present bool) bool {
+	if f.nested != nil {
+		obj, _ := v.(map[string]interface{})
+		return f.nested.Match(obj)
+	}
+	// An array matches when any element does; an empty one is treated as
+	// a missing field.
+	values := []interface{}{v}
+	if list, ok := v.([]interface{}); ok {
+		values = list
+		if len(list) == 0 {
+			values, present = []interface{}{nil}, false
+		}
+	}
+	for _, m := range f.matchers {
+		for _, e := range values {
+			if m(e, present) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringArg(path string, arg interface{}) (string, error) {
+	s, ok := arg.(string)
+	switch {
+	case !ok:
+		return "", fmt.Errorf("%s: %v is not a string", path, arg)
+	case strings.Contains(s, tfconfig.Unknown):
+		return "", fmt.Errorf("%s: %q is not statically known", path, s)
+	}
+	return s, nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
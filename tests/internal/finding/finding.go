@@ -0,0 +1,34 @@
+// Package finding holds the defect type of the analyzers under internal/
+// whose findings are a check failing on a resource, so that the property
+// tests can waive them the same way. Analyzers that key findings by more
+// than that, such as secgroup by rule and routing by destination, keep
+// their own types with the same Key and String methods.
+package finding
+
+import "fmt"
+
+// Finding is a defect of a resource, reported by a check of an analyzer.
+type Finding struct {
+	Scope   string // node ID of the resource Subject belongs to, if any
+	Subject string // node ID of the resource, or an address or route within Scope
+	Check   string
+	Detail  string
+}
+
+// Key identifies a finding in the tree, e.g.
+// "environments/app-layer:aws_lambda_function.document_processor dead-letter".
+// It starts with the Scope when there is one, since a Subject within it,
+// such as a route, may name other resources in other scopes.
+func (f Finding) Key() string {
+	if f.Scope == "" {
+		return f.Subject + " " + f.Check
+	}
+	return f.Scope + " " + f.Subject + " " + f.Check
+}
+
+func (f Finding) String() string {
+	if f.Scope == "" {
+		return fmt.Sprintf("%s: %s: %s", f.Subject, f.Check, f.Detail)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", f.Scope, f.Subject, f.Check, f.Detail)
+}
@@ -0,0 +1,19 @@
+package finding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinding(t *testing.T) {
+	t.Parallel()
+
+	f := Finding{Subject: "environments/app:aws_sqs_queue.dlq", Check: "encryption", Detail: "no CMK"}
+	assert.Equal(t, "environments/app:aws_sqs_queue.dlq encryption", f.Key())
+	assert.Equal(t, "environments/app:aws_sqs_queue.dlq: encryption: no CMK", f.String())
+
+	f = Finding{Scope: "environments/app:aws_api_gateway_rest_api.rag", Subject: "POST /rag/query", Check: "lambda-permission", Detail: "none"}
+	assert.Equal(t, "environments/app:aws_api_gateway_rest_api.rag POST /rag/query lambda-permission", f.Key())
+	assert.Equal(t, "environments/app:aws_api_gateway_rest_api.rag: POST /rag/query: lambda-permission: none", f.String())
+}
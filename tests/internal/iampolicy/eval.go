@@ -0,0 +1,220 @@
+package iampolicy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Effects returned by Document.Evaluate.
+const (
+	Allow = "Allow"
+	Deny  = "Deny"
+	None  = "" // no statement applies (implicit deny)
+)
+
+// Request is an API call made by a role in the same account as the
+// policies being evaluated.
+type Request struct {
+	Principal string // ARN of the calling role
+	Action    string
+	Resource  string
+	Context   map[string]string // condition keys, e.g. "aws:PrincipalAccount"
+
+	// KnownResources makes Unknown parts of the resources of Allow
+	// statements match nothing, so that only statically known patterns
+	// allow the request. It is for checks that a request is allowed;
+	// checks that one is not must let Unknown parts match.
+	KnownResources bool
+}
+
+// Evaluate returns Deny if any statement that applies to the request denies
+// it, Allow if one allows it, and None otherwise.
+//
+// Statements with a Principal (resource and endpoint policies) apply when the
+// principal is "*", the caller's ARN or its account; statements without one
+// (identity policies) always apply. Condition operators other than the
+// String, Arn, Bool and Null families never match. Unknown values in the
+// policy or in the request context match anything, except in the resources
+// of Allow statements when the request sets KnownResources.
+func (d *Document) Evaluate(req Request) string {
+	effect := None
+	for _, st := range d.Statements {
+		if !st.applies(req) {
+			continue
+		}
+		if st.Effect == Deny {
+			return Deny
+		}
+		if st.Effect == Allow {
+			effect = Allow
+		}
+	}
+	return effect
+}
+
+func (st Statement) applies(req Request) bool {
+	resourceMatch := Wildcard
+	if req.KnownResources && st.Effect == Allow {
+		resourceMatch = knownWildcard
+	}
+	switch {
+	case len(st.Actions) > 0 && !anyMatch(st.Actions, req.Action, MatchAction),
+		len(st.NotActions) > 0 && anyMatch(st.NotActions, req.Action, MatchAction),
+		len(st.Resources) > 0 && !anyMatch(st.Resources, req.Resource, resourceMatch),
+		len(st.NotResources) > 0 && anyMatch(st.NotResources, req.Resource, Wildcard):
+		return false
+	}
+	if st.Principals != nil && !principalMatches(st.Principals, req) {
+		return false
+	}
+	if st.NotPrincipals != nil && principalMatches(st.NotPrincipals, req) {
+		return false
+	}
+	for _, c := range st.Conditions {
+		if !c.matches(req.Context) {
+			return false
+		}
+	}
+	return true
+}
+
+// knownWildcard is Wildcard for patterns without Unknown parts; patterns
+// with one match nothing.
+func knownWildcard(pattern, s string) bool {
+	return !strings.Contains(pattern, tfconfig.Unknown) && Wildcard(pattern, s)
+}
+
+func anyMatch(patterns []string, s string, match func(pattern, s string) bool) bool {
+	for _, p := range patterns {
+		if match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func principalMatches(principals map[string][]string, req Request) bool {
+	if _, ok := principals["*"]; ok {
+		return true
+	}
+	account := accountOf(req.Principal)
+	for _, id := range principals["AWS"] {
+		switch {
+		case id == "*", Wildcard(id, req.Principal):
+			return true
+		case id == account, Wildcard(id, "arn:aws:iam::"+account+":root"):
+			return true
+		}
+	}
+	return false
+}
+
+// accountOf returns the account ID of an IAM ARN.
+func accountOf(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) == 6 {
+		return parts[4]
+	}
+	return ""
+}
+
+func (c Condition) matches(ctx map[string]string) bool {
+	op := strings.TrimPrefix(strings.TrimPrefix(c.Operator, "ForAnyValue:"), "ForAllValues:")
+	ifExists := strings.HasSuffix(op, "IfExists")
+	op = strings.TrimSuffix(op, "IfExists")
+
+	value, present := ctx[c.Key]
+	if op == "Null" {
+		return anyMatch(c.Values, fmt.Sprint(!present), strings.EqualFold)
+	}
+	// Negated operators match when the key is absent.
+	negate := strings.Contains(op, "Not")
+	op = strings.Replace(op, "Not", "", 1)
+	if !present {
+		return ifExists || negate
+	}
+	if strings.Contains(value, tfconfig.Unknown) {
+		return true
+	}
+
+	equals := func(p, s string) bool {
+		if strings.Contains(p, tfconfig.Unknown) {
+			return Wildcard(p, s)
+		}
+		return p == s
+	}
+	var match func(pattern, s string) bool
+	switch op {
+	case "StringEquals", "ArnEquals", "Bool":
+		match = equals
+	case "StringEqualsIgnoreCase":
+		match = func(p, s string) bool { return equals(strings.ToLower(p), strings.ToLower(s)) }
+	case "StringLike", "ArnLike":
+		match = Wildcard
+	default:
+		return false
+	}
+	return anyMatch(c.Values, value, match) != negate
+}
+
+// Decision is the outcome of evaluating a request made through a VPC
+// endpoint, with the effect of each policy layer.
+type Decision struct {
+	Identity string // combined effect of the caller's identity policies
+	Endpoint string // effect of the endpoint policy; Allow when it has none
+	Resource string // effect of the target's resource policy; None when it has none
+}
+
+// Allowed reports whether the request is allowed: no layer denies it
+// explicitly, the endpoint policy allows it, and the identity or resource
+// policy allows it.
+func (d Decision) Allowed() bool {
+	if d.Identity == Deny || d.Endpoint == Deny || d.Resource == Deny {
+		return false
+	}
+	return d.Endpoint == Allow && (d.Identity == Allow || d.Resource == Allow)
+}
+
+func (d Decision) String() string {
+	if d.Allowed() {
+		return "allowed"
+	}
+	switch {
+	case d.Identity == Deny:
+		return "explicitly denied by the identity policy"
+	case d.Endpoint == Deny:
+		return "explicitly denied by the endpoint policy"
+	case d.Resource == Deny:
+		return "explicitly denied by the resource policy"
+	case d.Endpoint != Allow:
+		return "not allowed by the endpoint policy"
+	}
+	return "not allowed by the identity or resource policy"
+}
+
+// EvaluateThrough evaluates a request against the caller's identity
+// policies, the policy of the VPC endpoint it goes through (nil for the
+// default full-access policy) and the target's resource policy (nil when
+// there is none).
+func EvaluateThrough(req Request, identity []*Document, endpoint, resource *Document) Decision {
+	d := Decision{Identity: None, Endpoint: Allow, Resource: None}
+	for _, doc := range identity {
+		switch doc.Evaluate(req) {
+		case Deny:
+			d.Identity = Deny
+		case Allow:
+			if d.Identity != Deny {
+				d.Identity = Allow
+			}
+		}
+	}
+	if endpoint != nil {
+		d.Endpoint = endpoint.Evaluate(req)
+	}
+	if resource != nil {
+		d.Resource = resource.Evaluate(req)
+	}
+	return d
+}
@@ -0,0 +1,378 @@
+// Package iampolicy parses IAM policy documents written in Terraform, either
+// as jsonencode/JSON policies or as aws_iam_policy_document data sources,
+// and collects the policies attached to roles.
+//
+// Values that cannot be evaluated statically keep their known parts, with
+// unknown interpolations replaced by tfconfig.Unknown (see
+// tfconfig.Module.Partial), so an ARN like
+// "arn:aws:s3:::${aws_s3_bucket.docs.id}/*" is still usable as a pattern.
+package iampolicy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Condition is a single condition operator/key pair.
+type Condition struct {
+	Operator string // e.g. "StringEquals", "ArnLike", "StringLikeIfExists"
+	Key      string // e.g. "aws:PrincipalAccount"
+	Values   []string
+}
+
+// Statement is a policy statement with its fields normalized to lists.
+type Statement struct {
+	Sid           string
+	Effect        string // "Allow" or "Deny"
+	Actions       []string
+	NotActions    []string
+	Resources     []string
+	NotResources  []string
+	Principals    map[string][]string // type ("AWS", "Service", "*") to identifiers
+	NotPrincipals map[string][]string
+	Conditions    []Condition
+}
+
+// Document is a parsed policy.
+type Document struct {
+	Source     string // address of the resource or data source it came from
+	Statements []Statement
+}
+
+// Parse converts a decoded JSON policy (as returned by tfconfig.Module.JSON)
+// into a Document.
+func Parse(source string, v interface{}) (*Document, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: policy is not an object", source)
+	}
+	doc := &Document{Source: source}
+
+	var stmts []interface{}
+	switch s := obj["Statement"].(type) {
+	case []interface{}:
+		stmts = s
+	case map[string]interface{}:
+		stmts = []interface{}{s}
+	default:
+		return nil, fmt.Errorf("%s: Statement is not statically known", source)
+	}
+
+	for i, raw := range stmts {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: statement %d is not statically known", source, i)
+		}
+		st := Statement{
+			Effect:       str(m["Effect"]),
+			Actions:      strs(m["Action"]),
+			NotActions:   strs(m["NotAction"]),
+			Resources:    strs(m["Resource"]),
+			NotResources: strs(m["NotResource"]),
+		}
+		st.Sid = str(m["Sid"])
+		st.Principals = principals(m["Principal"])
+		st.NotPrincipals = principals(m["NotPrincipal"])
+		if cond, ok := m["Condition"].(map[string]interface{}); ok {
+			for _, op := range sortedKeys(cond) {
+				keys, _ := cond[op].(map[string]interface{})
+				for _, key := range sortedKeys(keys) {
+					st.Conditions = append(st.Conditions, Condition{Operator: op, Key: key, Values: strs(keys[key])})
+				}
+			}
+		}
+		doc.Statements = append(doc.Statements, st)
+	}
+	return doc, nil
+}
+
+// FromPolicyDocument converts a data "aws_iam_policy_document" block.
+func FromPolicyDocument(b *tfconfig.Block) *Document {
+	doc := &Document{Source: b.Address()}
+	for _, sb := range b.Nested("statement") {
+		st := Statement{Effect: "Allow"}
+		if e, ok := sb.String("effect"); ok {
+			st.Effect = e
+		}
+		st.Sid, _ = sb.String("sid")
+		st.Actions = strs(sb.Partial("actions"))
+		st.NotActions = strs(sb.Partial("not_actions"))
+		st.Resources = strs(sb.Partial("resources"))
+		st.NotResources = strs(sb.Partial("not_resources"))
+		for _, kind := range []string{"principals", "not_principals"} {
+			for _, pb := range sb.Nested(kind) {
+				typ, _ := pb.String("type")
+				target := &st.Principals
+				if kind == "not_principals" {
+					target = &st.NotPrincipals
+				}
+				if *target == nil {
+					*target = map[string][]string{}
+				}
+				(*target)[typ] = append((*target)[typ], strs(pb.Partial("identifiers"))...)
+			}
+		}
+		for _, cb := range sb.Nested("condition") {
+			c := Condition{Values: strs(cb.Partial("values"))}
+			c.Operator, _ = cb.String("test")
+			c.Key, _ = cb.String("variable")
+			st.Conditions = append(st.Conditions, c)
+		}
+		doc.Statements = append(doc.Statements, st)
+	}
+	return doc
+}
+
+// FromExpr resolves a policy attribute: an inline jsonencode or JSON
+// policy, or a reference to an aws_iam_policy_document data source or an
+// aws_iam_policy resource.
+func FromExpr(g *tfconfig.Graph, m *tfconfig.Module, source string, expr hclsyntax.Expression) (*Document, error) {
+	if expr == nil {
+		return nil, fmt.Errorf("%s: no policy", source)
+	}
+	if v, ok := m.JSON(expr); ok {
+		return Parse(source, v)
+	}
+	for _, n := range g.ResolveExpr(m, expr) {
+		b := n.Block()
+		switch {
+		case b.Type == "data" && b.Kind() == "aws_iam_policy_document":
+			return FromPolicyDocument(b), nil
+		case b.Type == "resource" && b.Kind() == "aws_iam_policy":
+			return FromExpr(g, b.Module(), n.String(), b.Expr("policy"))
+		}
+	}
+	return nil, fmt.Errorf("%s: policy is not statically known", source)
+}
+
+// managedPolicies lists the parts of AWS managed policies that matter for
+// the checks in this repository. Managed policies not listed here are
+// reported as unknown by RolePolicies.
+var managedPolicies = map[string][]string{
+	"AWSLambdaBasicExecutionRole": {"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents"},
+	"AWSLambdaVPCAccessExecutionRole": {
+		"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents",
+		"ec2:CreateNetworkInterface", "ec2:DescribeNetworkInterfaces", "ec2:DescribeSubnets",
+		"ec2:DeleteNetworkInterface", "ec2:AssignPrivateIpAddresses", "ec2:UnassignPrivateIpAddresses",
+	},
+	"AmazonSSMManagedInstanceCore": {
+		"ssm:UpdateInstanceInformation", "ssm:ListInstanceAssociations", "ssm:GetDocument",
+		"ssmmessages:CreateControlChannel", "ssmmessages:CreateDataChannel",
+		"ssmmessages:OpenControlChannel", "ssmmessages:OpenDataChannel",
+		"ec2messages:GetMessages", "ec2messages:AcknowledgeMessage", "ec2messages:SendReply",
+	},
+	"CloudWatchAgentServerPolicy": {"cloudwatch:PutMetricData", "logs:CreateLogStream", "logs:PutLogEvents", "logs:DescribeLogStreams"},
+	"AWSXRayDaemonWriteAccess":    {"xray:PutTraceSegments", "xray:PutTelemetryRecords"},
+	"AdministratorAccess":         {"*"},
+}
+
+// Managed returns the document of an AWS managed policy ARN, or false when
+// the policy is not known.
+func Managed(arn string) (*Document, bool) {
+	if !strings.HasPrefix(arn, "arn:aws:iam::aws:policy/") {
+		return nil, false
+	}
+	name := arn[strings.LastIndex(arn, "/")+1:]
+	actions, ok := managedPolicies[name]
+	if !ok {
+		return nil, false
+	}
+	return &Document{Source: arn, Statements: []Statement{{Effect: "Allow", Actions: actions, Resources: []string{"*"}}}}, true
+}
+
+// RolePolicies returns the identity policies of an aws_iam_role: inline
+// policies, aws_iam_role_policy resources and attached customer or AWS
+// managed policies, in any module of the tree. Policies that cannot be
+// resolved are returned as errors.
+func RolePolicies(tree *tfconfig.Tree, g *tfconfig.Graph, role tfconfig.Node) ([]*Document, []error) {
+	var docs []*Document
+	var errs []error
+	add := func(doc *Document, err error) {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			docs = append(docs, doc)
+		}
+	}
+
+	rb := role.Block()
+	if rb == nil {
+		return nil, []error{fmt.Errorf("%s: role not found", role)}
+	}
+	for _, ib := range rb.Nested("inline_policy") {
+		add(FromExpr(g, role.Module, role.String()+".inline_policy", ib.Expr("policy")))
+	}
+	if arns, ok := rb.Partial("managed_policy_arns").([]interface{}); ok {
+		for _, a := range arns {
+			add(managedByARN(role.String(), a))
+		}
+	}
+
+	refersToRole := func(m *tfconfig.Module, b *tfconfig.Block) bool {
+		for _, n := range g.ResolveExpr(m, b.Expr("role")) {
+			if n == role {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range tree.Modules {
+		for _, b := range m.Resources("aws_iam_role_policy") {
+			if refersToRole(m, b) {
+				add(FromExpr(g, m, tfconfig.Node{Module: m, Addr: b.Address()}.String(), b.Expr("policy")))
+			}
+		}
+		for _, b := range m.Resources("aws_iam_role_policy_attachment") {
+			if !refersToRole(m, b) {
+				continue
+			}
+			source := tfconfig.Node{Module: m, Addr: b.Address()}.String()
+			if arn, ok := b.String("policy_arn"); ok {
+				add(managedByARN(source, arn))
+				continue
+			}
+			resolved := false
+			for _, n := range g.ResolveExpr(m, b.Expr("policy_arn")) {
+				if pb := n.Block(); pb.Type == "resource" && pb.Kind() == "aws_iam_policy" {
+					add(FromExpr(g, pb.Module(), n.String(), pb.Expr("policy")))
+					resolved = true
+				}
+			}
+			if !resolved {
+				errs = append(errs, fmt.Errorf("%s: policy_arn is not statically known", source))
+			}
+		}
+	}
+	return docs, errs
+}
+
+func managedByARN(source string, arn interface{}) (*Document, error) {
+	s, _ := arn.(string)
+	if doc, ok := Managed(s); ok {
+		return doc, nil
+	}
+	return nil, fmt.Errorf("%s: managed policy %q is not known", source, s)
+}
+
+// AllowedActions returns the actions granted by Allow statements of the
+// documents, deduplicated and sorted.
+func AllowedActions(docs []*Document) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, d := range docs {
+		for _, st := range d.Statements {
+			if st.Effect != "Allow" {
+				continue
+			}
+			for _, a := range st.Actions {
+				if !seen[a] {
+					seen[a] = true
+					out = append(out, a)
+				}
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ServicePrefix returns the service prefix of an action ("s3" for
+// "s3:GetObject"), or "*" for the wildcard action.
+func ServicePrefix(action string) string {
+	if i := strings.Index(action, ":"); i >= 0 {
+		return strings.ToLower(action[:i])
+	}
+	return action
+}
+
+// MatchAction reports whether an action pattern with * and ? wildcards
+// matches an action. Action names are case-insensitive.
+func MatchAction(pattern, action string) bool {
+	return Wildcard(strings.ToLower(pattern), strings.ToLower(action))
+}
+
+// Wildcard matches s against a pattern where * matches any sequence and ?
+// any single character. An Unknown part of the pattern matches anything.
+func Wildcard(pattern, s string) bool {
+	pattern = strings.ReplaceAll(pattern, tfconfig.Unknown, "*")
+	// Iterative matching with backtracking on the last star.
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// strs normalizes a string or list of strings. Non-string elements become
+// Unknown so that they still count as present.
+func strs(v interface{}) []string {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{x}
+	case []interface{}:
+		out := make([]string, 0, len(x))
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			} else {
+				out = append(out, tfconfig.Unknown)
+			}
+		}
+		return out
+	}
+	return []string{tfconfig.Unknown}
+}
+
+func principals(v interface{}) map[string][]string {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return map[string][]string{"*": {x}}
+	case map[string]interface{}:
+		out := map[string][]string{}
+		for k, ids := range x {
+			out[k] = strs(ids)
+		}
+		return out
+	}
+	return map[string][]string{"*": {tfconfig.Unknown}}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
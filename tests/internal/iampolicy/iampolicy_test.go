@@ -0,0 +1,197 @@
+package iampolicy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleRole gets one policy from each source RolePolicies understands.
+const sampleRole = `
+resource "aws_iam_role" "app" {
+  name                = "app"
+  managed_policy_arns = ["arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"]
+
+  inline_policy {
+    name = "inline"
+    policy = jsonencode({
+      Version   = "2012-10-17"
+      Statement = [{ Effect = "Allow", Action = "sqs:SendMessage", Resource = aws_sqs_queue.jobs.arn }]
+    })
+  }
+}
+
+resource "aws_sqs_queue" "jobs" {
+  name = "jobs"
+}
+
+data "aws_iam_policy_document" "read" {
+  statement {
+    sid       = "Read"
+    actions   = ["s3:GetObject", "s3:ListBucket"]
+    resources = ["arn:aws:s3:::docs", "arn:aws:s3:::docs/*"]
+
+    condition {
+      test     = "StringEquals"
+      variable = "aws:PrincipalAccount"
+      values   = ["111122223333"]
+    }
+  }
+}
+
+resource "aws_iam_role_policy" "read" {
+  role   = aws_iam_role.app.id
+  policy = data.aws_iam_policy_document.read.json
+}
+
+resource "aws_iam_policy" "invoke" {
+  policy = jsonencode({
+    Statement = [
+      { Effect = "Allow", Action = ["bedrock:InvokeModel"], Resource = "*" },
+      { Effect = "Deny", Action = "s3:DeleteObject", Resource = "*" },
+    ]
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "invoke" {
+  role       = aws_iam_role.app.name
+  policy_arn = aws_iam_policy.invoke.arn
+}
+
+resource "aws_iam_role_policy_attachment" "unknown" {
+  role       = aws_iam_role.app.name
+  policy_arn = var.extra_policy_arn
+}
+
+variable "extra_policy_arn" {
+  type = string
+}
+`
+
+func TestRolePolicies_CollectsEverySource(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleRole), 0o644))
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+
+	m := tree.Stack("environments/app")
+	role := tfconfig.Node{Module: m, Addr: "aws_iam_role.app"}
+	docs, errs := RolePolicies(tree, tree.RefGraph(), role)
+
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "aws_iam_role_policy_attachment.unknown")
+	assert.Len(t, docs, 4)
+	assert.Equal(t, []string{
+		"bedrock:InvokeModel",
+		"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents",
+		"s3:GetObject", "s3:ListBucket",
+		"sqs:SendMessage",
+	}, AllowedActions(docs))
+
+	for _, d := range docs {
+		if d.Source != "environments/app:aws_iam_role_policy.read" {
+			continue
+		}
+		require.Len(t, d.Statements, 1)
+		st := d.Statements[0]
+		assert.Equal(t, "Read", st.Sid)
+		assert.Equal(t, []Condition{{Operator: "StringEquals", Key: "aws:PrincipalAccount", Values: []string{"111122223333"}}}, st.Conditions)
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:PutObject", false},
+		{"arn:aws:s3:::docs-?/*", "arn:aws:s3:::docs-a/key", true},
+		{"arn:aws:s3:::docs-?/*", "arn:aws:s3:::docs-ab/key", false},
+		{"arn:aws:s3:::" + tfconfig.Unknown + "/*", "arn:aws:s3:::anything/key", true},
+		{"*", "", true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.match, Wildcard(tc.pattern, tc.s), "%q ~ %q", tc.pattern, tc.s)
+	}
+	assert.True(t, MatchAction("S3:getobject", "s3:GetObject"))
+	assert.Equal(t, "bedrock", ServicePrefix("bedrock:InvokeModel"))
+}
+
+func TestEvaluate_CombinesIdentityEndpointAndResourcePolicies(t *testing.T) {
+	t.Parallel()
+
+	parse := func(policy string) *Document {
+		var v interface{}
+		require.NoError(t, json.Unmarshal([]byte(policy), &v))
+		doc, err := Parse("test", v)
+		require.NoError(t, err)
+		return doc
+	}
+	identity := parse(`{"Statement": [
+		{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::proj-*"},
+		{"Effect": "Deny", "Action": "s3:DeleteObject", "Resource": "*"}]}`)
+	endpoint := parse(`{"Statement": [{
+		"Effect": "Allow", "Principal": "*", "Action": ["s3:GetObject", "s3:DeleteObject"],
+		"Resource": "arn:aws:s3:::proj-*/*",
+		"Condition": {"StringEquals": {"aws:PrincipalAccount": "111122223333"}}}]}`)
+	bucket := parse(`{"Statement": [
+		{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::111122223333:role/reader"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::shared/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::proj-docs/private/*",
+		 "Condition": {"StringNotEquals": {"aws:PrincipalTag/team": "docs"}}}]}`)
+
+	request := func(action, resource string) Request {
+		return Request{
+			Principal: "arn:aws:iam::111122223333:role/reader",
+			Action:    action,
+			Resource:  resource,
+			Context:   map[string]string{"aws:PrincipalAccount": "111122223333"},
+		}
+	}
+	testCases := []struct {
+		name     string
+		req      Request
+		endpoint *Document
+		want     string
+	}{
+		{"identity and endpoint allow", request("s3:GetObject", "arn:aws:s3:::proj-docs/a"), endpoint, "allowed"},
+		{"endpoint restricts the action", request("s3:PutObject", "arn:aws:s3:::proj-docs/a"), endpoint, "not allowed by the endpoint policy"},
+		{"no endpoint policy", request("s3:PutObject", "arn:aws:s3:::proj-docs/a"), nil, "allowed"},
+		{"identity deny wins", request("s3:DeleteObject", "arn:aws:s3:::proj-docs/a"), endpoint, "explicitly denied by the identity policy"},
+		{"resource policy grants access", request("s3:GetObject", "arn:aws:s3:::shared/a"), nil, "allowed"},
+		{"endpoint limits the bucket", request("s3:GetObject", "arn:aws:s3:::shared/a"), endpoint, "not allowed by the endpoint policy"},
+		{"negated condition on a missing key", request("s3:GetObject", "arn:aws:s3:::proj-docs/private/a"), endpoint, "explicitly denied by the resource policy"},
+	}
+	for _, tc := range testCases {
+		d := EvaluateThrough(tc.req, []*Document{identity}, tc.endpoint, bucket)
+		assert.Equal(t, tc.want, d.String(), tc.name)
+	}
+
+	other := request("s3:GetObject", "arn:aws:s3:::proj-docs/a")
+	other.Context["aws:PrincipalAccount"] = "444455556666"
+	assert.Equal(t, None, endpoint.Evaluate(other), "condition on another account")
+	other.Context["aws:PrincipalAccount"] = tfconfig.Unknown
+	assert.Equal(t, Allow, endpoint.Evaluate(other), "unknown account matches")
+
+	unknown := parse(`{"Statement": [
+		{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::` + tfconfig.Unknown + `-*/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::` + tfconfig.Unknown + `/*"}]}`)
+	assert.Equal(t, Allow, unknown.Evaluate(request("s3:GetObject", "arn:aws:s3:::other-docs/a")), "unknown resources match anything")
+	known := request("s3:GetObject", "arn:aws:s3:::other-docs/a")
+	known.KnownResources = true
+	assert.Equal(t, None, unknown.Evaluate(known), "unknown resources of Allow statements match nothing")
+	known.Action = "s3:DeleteObject"
+	assert.Equal(t, Deny, unknown.Evaluate(known), "unknown resources of Deny statements still match anything")
+}
@@ -0,0 +1,185 @@
+package lifecycle
+
+import (
+	"fmt"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+)
+
+// Checks reported by Check.
+const (
+	CheckTransition   = "transition"
+	CheckNoncurrent   = "noncurrent-expiration"
+	CheckObjectLock   = "object-lock"
+	CheckTiering      = "intelligent-tiering"
+	CheckUnknownValue = "unknown-value"
+)
+
+// Check checks the evaluated settings of every bucket of the model:
+//
+//   - Every transition of an enabled rule is reached, and objects stay in
+//     each class for its MinimumDuration before the next transition or the
+//     expiration moves them out.
+//   - The same holds for noncurrent transitions and the noncurrent
+//     expiration, which need versioning, and a versioned bucket whose rules
+//     expire current objects also expires the noncurrent versions that
+//     expiration leaves behind.
+//   - On a bucket with object lock, noncurrent versions do not expire
+//     before the default retention ends.
+//   - An Intelligent-Tiering configuration selects objects that a rule
+//     moves to INTELLIGENT_TIERING, and its archive tiers are reached
+//     before a rule moves the objects out of the class.
+//
+// Values that are not statically known are reported as CheckUnknownValue.
+func (md *Model) Check() []finding.Finding {
+	var out []finding.Finding
+	for _, id := range md.Buckets {
+		bk := md.Bucket(id)
+		add := func(check, format string, args ...interface{}) {
+			out = append(out, finding.Finding{Subject: id, Check: check, Detail: fmt.Sprintf(format, args...)})
+		}
+		for _, attr := range bk.Unknown {
+			add(CheckUnknownValue, "%s is not statically known", attr)
+		}
+
+		for _, r := range bk.Rules {
+			if !r.Enabled {
+				continue
+			}
+			for _, d := range durations(r.Transitions, r.Expiration, "objects", "day") {
+				add(CheckTransition, "rule %q %s", r.ID, d)
+			}
+			if (len(r.NoncurrentTransitions) > 0 || r.NoncurrentExpiration > 0) && bk.Versioning == "" {
+				add(CheckNoncurrent, "rule %q sets noncurrent version actions on a bucket without versioning", r.ID)
+				continue
+			}
+			for _, d := range durations(r.NoncurrentTransitions, r.NoncurrentExpiration, "noncurrent versions", "noncurrent day") {
+				add(CheckNoncurrent, "rule %q %s", r.ID, d)
+			}
+			if r.Expiration > 0 && bk.Versioning == "Enabled" && !expiresNoncurrent(bk, r) {
+				add(CheckNoncurrent, "rule %q expires current objects after %d days, but no rule expires the noncurrent versions that expiration leaves behind", r.ID, r.Expiration)
+			}
+			if bk.Lock != nil && r.NoncurrentExpiration > 0 && r.NoncurrentExpiration < bk.Lock.Days {
+				add(CheckObjectLock, "rule %q expires noncurrent versions after %d days, before the %d-day %s retention ends",
+					r.ID, r.NoncurrentExpiration, bk.Lock.Days, bk.Lock.Mode)
+			}
+		}
+
+		for _, t := range bk.Tiering {
+			if !t.Enabled {
+				continue
+			}
+			var into []*Rule
+			for _, r := range bk.Rules {
+				if r.Enabled && overlaps(r.Filter, t.Filter) && movesTo(r.Transitions, IntelligentTiering) >= 0 {
+					into = append(into, r)
+				}
+			}
+			if len(into) == 0 {
+				add(CheckTiering, "configuration %q only tiers objects uploaded as INTELLIGENT_TIERING: no rule moves objects to it", t.Name)
+				continue
+			}
+			for _, r := range into {
+				entered := r.Transitions[movesTo(r.Transitions, IntelligentTiering)].Days
+				for _, next := range r.Transitions {
+					if coldness[next.StorageClass] <= coldness[IntelligentTiering] {
+						continue
+					}
+					for _, tier := range []string{ArchiveAccess, DeepArchiveAccess} {
+						if days, ok := t.Tiers[tier]; ok && entered+days >= next.Days {
+							add(CheckTiering, "configuration %q moves objects to %s at day %d, after rule %q moves them to %s at day %d",
+								t.Name, tier, entered+days, r.ID, next.StorageClass, next.Days)
+						}
+					}
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// durations describes the transitions, sorted by days, that are never
+// reached, and those whose objects leave the class before its minimum
+// storage duration, for a colder class or by expiring after the given
+// days. What names the objects and day the days they count.
+func durations(ts []Transition, expiration int, what, day string) []string {
+	var out []string
+	var steps []Transition
+	for _, t := range ts {
+		var last Transition
+		if len(steps) > 0 {
+			last = steps[len(steps)-1]
+		}
+		switch {
+		case expiration > 0 && t.Days >= expiration:
+			out = append(out, fmt.Sprintf("expires %s at %s %d, before they move to %s at %s %d", what, day, expiration, t.StorageClass, day, t.Days))
+		case len(steps) > 0 && coldness[t.StorageClass] <= coldness[last.StorageClass]:
+			out = append(out, fmt.Sprintf("never moves %s to %s at %s %d: they are in the colder %s from %s %d", what, t.StorageClass, day, t.Days, last.StorageClass, day, last.Days))
+		case len(steps) > 0 && t.Days == last.Days:
+			out = append(out, fmt.Sprintf("never moves %s to %s at %s %d: they move to the colder %s the same day", what, last.StorageClass, day, last.Days, t.StorageClass))
+			steps[len(steps)-1] = t
+		default:
+			steps = append(steps, t)
+		}
+	}
+	for i, t := range steps {
+		next, to := expiration, "expire"
+		if i+1 < len(steps) {
+			next, to = steps[i+1].Days, "move to "+steps[i+1].StorageClass
+		}
+		if min := MinimumDuration[t.StorageClass]; next > 0 && next-t.Days < min {
+			out = append(out, fmt.Sprintf("moves %s to %s at %s %d and they %s at %s %d, before its %d-day minimum storage duration",
+				what, t.StorageClass, day, t.Days, to, day, next, min))
+		}
+	}
+	return out
+}
+
+// expiresNoncurrent reports whether an enabled rule of a bucket expires the
+// noncurrent versions of every object a rule selects.
+func expiresNoncurrent(bk *Bucket, r *Rule) bool {
+	for _, o := range bk.Rules {
+		if o.Enabled && o.NoncurrentExpiration > 0 && contains(o.Filter, r.Filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// movesTo returns the index of the transition to a class, or -1.
+func movesTo(ts []Transition, class string) int {
+	for i, t := range ts {
+		if t.StorageClass == class {
+			return i
+		}
+	}
+	return -1
+}
+
+// contains reports whether filter a selects every object b selects, as far
+// as prefixes and tags tell.
+func contains(a, b Filter) bool {
+	if len(b.Prefix) < len(a.Prefix) || b.Prefix[:len(a.Prefix)] != a.Prefix {
+		return false
+	}
+	for k, v := range a.Tags {
+		if b.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether two filters can select the same object.
+func overlaps(a, b Filter) bool {
+	if !contains(Filter{Prefix: a.Prefix}, Filter{Prefix: b.Prefix}) && !contains(Filter{Prefix: b.Prefix}, Filter{Prefix: a.Prefix}) {
+		return false
+	}
+	for k, v := range a.Tags {
+		if w, ok := b.Tags[k]; ok && w != v {
+			return false
+		}
+	}
+	return true
+}
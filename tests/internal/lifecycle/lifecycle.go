@@ -0,0 +1,405 @@
+// Package lifecycle models the storage-class settings of the S3 buckets of
+// a Terraform tree: their aws_s3_bucket_lifecycle_configuration rules,
+// aws_s3_bucket_intelligent_tiering_configuration archive tiers, versioning
+// and object lock. Bucket settings are evaluated when a bucket is looked up,
+// so they follow Module.SetVars, and can be projected over a distribution
+// of objects to see which storage class holds them on a given day and what
+// they cost.
+package lifecycle
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Storage classes.
+const (
+	Standard           = "STANDARD"
+	IntelligentTiering = "INTELLIGENT_TIERING"
+	StandardIA         = "STANDARD_IA"
+	OneZoneIA          = "ONEZONE_IA"
+	GlacierIR          = "GLACIER_IR"
+	Glacier            = "GLACIER"
+	DeepArchive        = "DEEP_ARCHIVE"
+)
+
+// Access tiers of Intelligent-Tiering. The first three are automatic, the
+// archive tiers are opted into by an Intelligent-Tiering configuration.
+const (
+	FrequentAccess       = "FREQUENT_ACCESS"
+	InfrequentAccess     = "INFREQUENT_ACCESS"
+	ArchiveInstantAccess = "ARCHIVE_INSTANT_ACCESS"
+	ArchiveAccess        = "ARCHIVE_ACCESS"
+	DeepArchiveAccess    = "DEEP_ARCHIVE_ACCESS"
+)
+
+// coldness orders storage classes the way lifecycle transitions may move
+// objects: only to a class that comes later.
+var coldness = map[string]int{
+	Standard:           0,
+	IntelligentTiering: 1,
+	StandardIA:         2,
+	OneZoneIA:          3,
+	GlacierIR:          4,
+	Glacier:            5,
+	DeepArchive:        6,
+}
+
+// MinimumDuration is the minimum storage duration of a storage class, in
+// days. Objects that leave the class earlier are billed for the rest of it.
+var MinimumDuration = map[string]int{
+	StandardIA:  30,
+	OneZoneIA:   30,
+	GlacierIR:   90,
+	Glacier:     90,
+	DeepArchive: 180,
+}
+
+// automaticTiers are the days without access after which Intelligent-Tiering
+// moves an object to an automatic tier.
+var automaticTiers = map[string]int{
+	InfrequentAccess:     30,
+	ArchiveInstantAccess: 90,
+}
+
+// tierColdness orders the access tiers of Intelligent-Tiering.
+var tierColdness = map[string]int{
+	FrequentAccess:       0,
+	InfrequentAccess:     1,
+	ArchiveInstantAccess: 2,
+	ArchiveAccess:        3,
+	DeepArchiveAccess:    4,
+}
+
+// SmallObject is the size below which lifecycle rules do not transition an
+// object unless a rule sets object_size_greater_than, and below which
+// Intelligent-Tiering neither monitors nor tiers it.
+const SmallObject = 128 * 1024
+
+// Filter selects the objects a rule or Intelligent-Tiering configuration
+// applies to. The zero Filter selects every object.
+type Filter struct {
+	Prefix string
+	Tags   map[string]string
+	// SizeGreaterThan and SizeLessThan are object_size_greater_than and
+	// object_size_less_than in bytes, or 0 when unset.
+	SizeGreaterThan int64
+	SizeLessThan    int64
+}
+
+// Matches reports whether the filter selects an object.
+func (f Filter) Matches(key string, tags map[string]string, size int64) bool {
+	if len(key) < len(f.Prefix) || key[:len(f.Prefix)] != f.Prefix {
+		return false
+	}
+	for k, v := range f.Tags {
+		if tags[k] != v {
+			return false
+		}
+	}
+	if f.SizeGreaterThan > 0 && size <= f.SizeGreaterThan {
+		return false
+	}
+	if f.SizeLessThan > 0 && size >= f.SizeLessThan {
+		return false
+	}
+	return true
+}
+
+// Transition is a transition or noncurrent_version_transition of a rule.
+// Days counts from creation for current objects and from becoming
+// noncurrent for noncurrent versions.
+type Transition struct {
+	Days         int
+	StorageClass string
+}
+
+// Rule is a rule of a lifecycle configuration. Days are 0 when unset.
+type Rule struct {
+	ID      string
+	Enabled bool
+	Filter
+
+	Transitions           []Transition // sorted by days
+	Expiration            int
+	NoncurrentTransitions []Transition // sorted by days
+	NoncurrentExpiration  int
+
+	Block *tfconfig.Block
+}
+
+// Tiering is an Intelligent-Tiering configuration.
+type Tiering struct {
+	Name    string
+	Enabled bool
+	Filter
+	Tiers map[string]int // days without access by archive access tier
+
+	Block *tfconfig.Block
+}
+
+// Lock is the object lock default retention of a bucket. Days is 0 when
+// object lock is enabled without a default retention.
+type Lock struct {
+	Mode string
+	Days int
+}
+
+// Bucket is the evaluated storage-class settings of a bucket.
+type Bucket struct {
+	ID         string
+	Versioning string // status of versioning, or "" when it was never enabled
+	Lock       *Lock  // nil without object lock
+	Rules      []*Rule
+	Tiering    []*Tiering
+	Unknown    []string // attributes that are set but not statically known
+}
+
+// configures are the resources whose bucket makes a bucket part of the
+// model; versioning alone does not.
+var configures = []string{
+	"aws_s3_bucket_lifecycle_configuration",
+	"aws_s3_bucket_intelligent_tiering_configuration",
+	"aws_s3_bucket_object_lock_configuration",
+}
+
+// Model is every bucket of a tree with a lifecycle configuration, an
+// Intelligent-Tiering configuration or object lock.
+type Model struct {
+	Buckets []string // node IDs, sorted
+
+	// Unresolved lists configurations whose bucket is not a bucket of the
+	// tree.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+
+	nodes    map[string]tfconfig.Node
+	attached map[string][]*tfconfig.Block // resources that configure a bucket, by bucket node ID
+}
+
+// Build collects the buckets of every module in the tree that have
+// lifecycle, Intelligent-Tiering or object lock settings. Resources with
+// count = 0 are skipped.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph(), nodes: map[string]tfconfig.Node{}, attached: map[string][]*tfconfig.Block{}}
+	add := func(n tfconfig.Node) {
+		if _, ok := md.nodes[n.String()]; !ok {
+			md.nodes[n.String()] = n
+			md.Buckets = append(md.Buckets, n.String())
+		}
+	}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_s3_bucket")) {
+			if on, _ := b.Bool("object_lock_enabled"); on || len(b.Nested("object_lock_configuration")) > 0 {
+				add(tfconfig.Node{Module: m, Addr: b.Address()})
+			}
+		}
+		for _, kind := range append([]string{"aws_s3_bucket_versioning"}, configures...) {
+			for _, b := range instantiated(m.Resources(kind)) {
+				var bucket tfconfig.Node
+				found := false
+				for _, n := range md.Refs.ResolveExpr(m, b.Expr("bucket")) {
+					if n.Kind() == "aws_s3_bucket" {
+						bucket, found = n, true
+					}
+				}
+				switch {
+				case found:
+					md.attached[bucket.String()] = append(md.attached[bucket.String()], b)
+					if kind != "aws_s3_bucket_versioning" {
+						add(bucket)
+					}
+				case kind != "aws_s3_bucket_versioning":
+					md.Unresolved = append(md.Unresolved, fmt.Sprintf("%s: bucket is not a bucket of the tree", nodeID(m, b)))
+				}
+			}
+		}
+	}
+	sort.Strings(md.Buckets)
+	sort.Strings(md.Unresolved)
+	return md
+}
+
+// Bucket returns the evaluated settings of a bucket of the model, or nil.
+func (md *Model) Bucket(id string) *Bucket {
+	n, ok := md.nodes[id]
+	if !ok {
+		return nil
+	}
+	bk := &Bucket{ID: id}
+	if b := n.Block(); b != nil {
+		if on, _ := b.Bool("object_lock_enabled"); on {
+			bk.Lock = &Lock{}
+		}
+		for _, lb := range b.Nested("object_lock_configuration") {
+			bk.Lock = &Lock{}
+			bk.readLock(lb)
+		}
+	}
+	for _, b := range md.attached[id] {
+		switch b.Kind() {
+		case "aws_s3_bucket_versioning":
+			for _, vc := range b.Nested("versioning_configuration") {
+				if s, ok := vc.String("status"); ok {
+					bk.Versioning = s
+				} else {
+					bk.unknown(vc, "status")
+				}
+			}
+		case "aws_s3_bucket_object_lock_configuration":
+			if bk.Lock == nil {
+				bk.Lock = &Lock{}
+			}
+			bk.readLock(b)
+		case "aws_s3_bucket_lifecycle_configuration":
+			for _, rb := range b.Nested("rule") {
+				bk.Rules = append(bk.Rules, bk.rule(rb))
+			}
+		case "aws_s3_bucket_intelligent_tiering_configuration":
+			bk.Tiering = append(bk.Tiering, bk.tiering(b))
+		}
+	}
+	if bk.Lock != nil && bk.Versioning == "" {
+		// S3 enables versioning on buckets created with object lock.
+		bk.Versioning = "Enabled"
+	}
+	return bk
+}
+
+// unknown records an attribute of a nested block as "<block>.<attr> at
+// <file>:<line>".
+func (bk *Bucket) unknown(b *tfconfig.Block, attr string) {
+	rng := b.Body.SrcRange
+	bk.Unknown = append(bk.Unknown, fmt.Sprintf("%s.%s at %s:%d", b.Type, attr, filepath.Base(rng.Filename), rng.Start.Line))
+}
+
+// int reads a day count or size, recording it as unknown when it is set
+// but not statically known.
+func (bk *Bucket) int(b *tfconfig.Block, attr string) int {
+	if !b.Has(attr) {
+		return 0
+	}
+	n, ok := b.Int(attr)
+	if !ok {
+		bk.unknown(b, attr)
+	}
+	return n
+}
+
+func (bk *Bucket) readLock(b *tfconfig.Block) {
+	for _, rb := range b.Nested("rule") {
+		for _, d := range rb.Nested("default_retention") {
+			bk.Lock.Mode, _ = d.String("mode")
+			bk.Lock.Days = bk.int(d, "days") + 365*bk.int(d, "years")
+		}
+	}
+}
+
+func (bk *Bucket) rule(rb *tfconfig.Block) *Rule {
+	r := &Rule{Block: rb}
+	r.ID, _ = rb.String("id")
+	status, _ := rb.String("status")
+	r.Enabled = status == "Enabled"
+	if rb.Has("prefix") {
+		r.Prefix, _ = rb.String("prefix")
+	}
+	for _, fb := range rb.Nested("filter") {
+		bk.readFilter(&r.Filter, fb)
+		for _, and := range fb.Nested("and") {
+			bk.readFilter(&r.Filter, and)
+		}
+	}
+	for _, tb := range rb.Nested("transition") {
+		r.Transitions = append(r.Transitions, bk.transition(tb, "days"))
+	}
+	for _, eb := range rb.Nested("expiration") {
+		r.Expiration = bk.int(eb, "days")
+	}
+	for _, tb := range rb.Nested("noncurrent_version_transition") {
+		r.NoncurrentTransitions = append(r.NoncurrentTransitions, bk.transition(tb, "noncurrent_days"))
+	}
+	for _, eb := range rb.Nested("noncurrent_version_expiration") {
+		r.NoncurrentExpiration = bk.int(eb, "noncurrent_days")
+	}
+	sortTransitions(r.Transitions)
+	sortTransitions(r.NoncurrentTransitions)
+	return r
+}
+
+func (bk *Bucket) transition(b *tfconfig.Block, days string) Transition {
+	t := Transition{Days: bk.int(b, days)}
+	var ok bool
+	if t.StorageClass, ok = b.String("storage_class"); !ok {
+		bk.unknown(b, "storage_class")
+	}
+	return t
+}
+
+func (bk *Bucket) tiering(b *tfconfig.Block) *Tiering {
+	t := &Tiering{Enabled: true, Tiers: map[string]int{}, Block: b}
+	t.Name, _ = b.String("name")
+	if s, ok := b.String("status"); ok {
+		t.Enabled = s == "Enabled"
+	}
+	for _, fb := range b.Nested("filter") {
+		bk.readFilter(&t.Filter, fb)
+	}
+	for _, tb := range b.Nested("tiering") {
+		tier, ok := tb.String("access_tier")
+		if !ok {
+			bk.unknown(tb, "access_tier")
+			continue
+		}
+		t.Tiers[tier] = bk.int(tb, "days")
+	}
+	return t
+}
+
+func (bk *Bucket) readFilter(f *Filter, b *tfconfig.Block) {
+	if p, ok := b.String("prefix"); ok {
+		f.Prefix = p
+	}
+	if f.Tags == nil {
+		f.Tags = map[string]string{}
+	}
+	for _, tb := range b.Nested("tag") {
+		k, _ := tb.String("key")
+		v, _ := tb.String("value")
+		f.Tags[k] = v
+	}
+	if v, ok := b.Partial("tags").(map[string]interface{}); ok {
+		for k, tv := range v {
+			f.Tags[k] = fmt.Sprint(tv)
+		}
+	}
+	if b.Has("object_size_greater_than") {
+		f.SizeGreaterThan = int64(bk.int(b, "object_size_greater_than"))
+	}
+	if b.Has("object_size_less_than") {
+		f.SizeLessThan = int64(bk.int(b, "object_size_less_than"))
+	}
+}
+
+func sortTransitions(ts []Transition) {
+	sort.SliceStable(ts, func(i, j int) bool { return ts[i].Days < ts[j].Days })
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,389 @@
+package lifecycle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleLifecycle has three buckets. The versioned docs bucket moves docs/
+// to STANDARD_IA, GLACIER after var.glacier_days and DEEP_ARCHIVE 60 days
+// later, moves noncurrent versions to GLACIER and expires them 30 days
+// after that, and expires tmp/ without expiring its noncurrent versions.
+// Its Intelligent-Tiering configuration is never used. The unversioned
+// reports bucket moves objects to INTELLIGENT_TIERING on upload and to
+// GLACIER after a year, before its deep archive tier, and sets a noncurrent
+// expiration for logs/. The locked bucket has a one-year COMPLIANCE
+// retention and expires noncurrent versions after 30 days.
+const sampleLifecycle = `
+variable "glacier_days" {
+  default = 60
+}
+
+resource "aws_s3_bucket" "docs" {
+  bucket = "docs"
+}
+
+resource "aws_s3_bucket_versioning" "docs" {
+  bucket = aws_s3_bucket.docs.id
+  versioning_configuration {
+    status = "Enabled"
+  }
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "docs" {
+  bucket = aws_s3_bucket.docs.id
+
+  rule {
+    id     = "archive"
+    status = "Enabled"
+
+    filter {
+      prefix = "docs/"
+    }
+
+    transition {
+      days          = 120
+      storage_class = "DEEP_ARCHIVE"
+    }
+
+    transition {
+      days          = 30
+      storage_class = "STANDARD_IA"
+    }
+
+    transition {
+      days          = var.glacier_days
+      storage_class = "GLACIER"
+    }
+
+    noncurrent_version_transition {
+      noncurrent_days = 30
+      storage_class   = "GLACIER"
+    }
+
+    noncurrent_version_expiration {
+      noncurrent_days = 60
+    }
+  }
+
+  rule {
+    id     = "tmp"
+    status = "Enabled"
+
+    filter {
+      prefix = "tmp/"
+    }
+
+    expiration {
+      days = 7
+    }
+  }
+
+  rule {
+    id     = "disabled"
+    status = "Disabled"
+
+    filter {}
+
+    expiration {
+      days = 1
+    }
+  }
+}
+
+resource "aws_s3_bucket_intelligent_tiering_configuration" "docs" {
+  bucket = aws_s3_bucket.docs.id
+  name   = "archive"
+
+  filter {
+    prefix = "docs/"
+  }
+
+  tiering {
+    access_tier = "ARCHIVE_ACCESS"
+    days        = 90
+  }
+}
+
+resource "aws_s3_bucket" "reports" {
+  bucket = "reports"
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "reports" {
+  bucket = aws_s3_bucket.reports.id
+
+  rule {
+    id     = "tiering"
+    status = "Enabled"
+
+    filter {}
+
+    transition {
+      days          = 0
+      storage_class = "INTELLIGENT_TIERING"
+    }
+
+    transition {
+      days          = 365
+      storage_class = "GLACIER"
+    }
+  }
+
+  rule {
+    id     = "logs"
+    status = "Enabled"
+
+    filter {
+      prefix = "logs/"
+    }
+
+    noncurrent_version_expiration {
+      noncurrent_days = 30
+    }
+  }
+}
+
+resource "aws_s3_bucket_intelligent_tiering_configuration" "reports" {
+  bucket = aws_s3_bucket.reports.id
+  name   = "EntireBucket"
+
+  tiering {
+    access_tier = "ARCHIVE_ACCESS"
+    days        = 180
+  }
+
+  tiering {
+    access_tier = "DEEP_ARCHIVE_ACCESS"
+    days        = 400
+  }
+}
+
+resource "aws_s3_bucket" "locked" {
+  bucket              = "locked"
+  object_lock_enabled = true
+}
+
+resource "aws_s3_bucket_object_lock_configuration" "locked" {
+  bucket = aws_s3_bucket.locked.id
+
+  rule {
+    default_retention {
+      mode  = "COMPLIANCE"
+      years = 1
+    }
+  }
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "locked" {
+  bucket = aws_s3_bucket.locked.id
+
+  rule {
+    id     = "cleanup"
+    status = "Enabled"
+
+    filter {}
+
+    noncurrent_version_expiration {
+      noncurrent_days = 30
+    }
+  }
+}
+`
+
+const (
+	docs    = "environments/app:aws_s3_bucket.docs"
+	locked  = "environments/app:aws_s3_bucket.locked"
+	reports = "environments/app:aws_s3_bucket.reports"
+
+	megabyte = 1 << 20
+)
+
+func loadSample(t *testing.T) (*tfconfig.Tree, *Model) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleLifecycle), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unresolved)
+	require.Len(t, md.Buckets, 3)
+	return tree, md
+}
+
+func TestBucket_ReadsSettings(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	assert.Equal(t, []string{docs, locked, reports}, md.Buckets)
+	assert.Nil(t, md.Bucket("environments/app:aws_s3_bucket.missing"))
+
+	d := md.Bucket(docs)
+	assert.Equal(t, "Enabled", d.Versioning)
+	assert.Nil(t, d.Lock)
+	require.Len(t, d.Rules, 3)
+	archive := d.Rules[0]
+	assert.Equal(t, "docs/", archive.Prefix)
+	assert.Equal(t, []Transition{{30, StandardIA}, {60, Glacier}, {120, DeepArchive}}, archive.Transitions)
+	assert.Equal(t, []Transition{{30, Glacier}}, archive.NoncurrentTransitions)
+	assert.Equal(t, 60, archive.NoncurrentExpiration)
+	assert.Equal(t, 7, d.Rules[1].Expiration)
+	assert.False(t, d.Rules[2].Enabled)
+	require.Len(t, d.Tiering, 1)
+	assert.Equal(t, map[string]int{ArchiveAccess: 90}, d.Tiering[0].Tiers)
+
+	l := md.Bucket(locked)
+	assert.Equal(t, &Lock{Mode: "COMPLIANCE", Days: 365}, l.Lock)
+	assert.Equal(t, "Enabled", l.Versioning, "object lock turns versioning on")
+	assert.Equal(t, "", md.Bucket(reports).Versioning)
+}
+
+func TestPlace(t *testing.T) {
+	t.Parallel()
+
+	tree, md := loadSample(t)
+	doc := Cohort{Key: "docs/a.pdf", Count: 1, Size: megabyte}
+	small := Cohort{Key: "docs/a.txt", Count: 1, Size: 1024}
+	tmp := Cohort{Key: "tmp/a", Count: 1, Size: megabyte}
+	replaced := Cohort{Key: "docs/b.pdf", Count: 1, Size: megabyte, Age: 10, Replaced: 5}
+	report := Cohort{Key: "q1.csv", Count: 1, Size: megabyte}
+	hot := Cohort{Key: "q2.csv", Count: 1, Size: megabyte, Hot: true}
+	lockedVersion := Cohort{Key: "a", Count: 1, Size: megabyte, Age: 10, Replaced: 1}
+
+	tests := []struct {
+		bucket string
+		cohort Cohort
+		day    int
+		want   string // Placement.String(), or "" when deleted
+	}{
+		{docs, doc, 0, "STANDARD"},
+		{docs, doc, 30, "STANDARD_IA"},
+		{docs, doc, 60, "GLACIER"},
+		{docs, doc, 119, "GLACIER"},
+		{docs, doc, 120, "DEEP_ARCHIVE"},
+		{docs, small, 200, "STANDARD"},
+		{docs, tmp, 6, "STANDARD"},
+		{docs, tmp, 10, "STANDARD (noncurrent)"},
+		{docs, replaced, 0, "STANDARD (noncurrent)"},
+		{docs, replaced, 25, "GLACIER (noncurrent)"},
+		{docs, replaced, 55, ""},
+		{reports, report, 10, "INTELLIGENT_TIERING/FREQUENT_ACCESS"},
+		{reports, report, 30, "INTELLIGENT_TIERING/INFREQUENT_ACCESS"},
+		{reports, report, 90, "INTELLIGENT_TIERING/ARCHIVE_INSTANT_ACCESS"},
+		{reports, report, 180, "INTELLIGENT_TIERING/ARCHIVE_ACCESS"},
+		{reports, report, 365, "GLACIER"},
+		{reports, hot, 200, "INTELLIGENT_TIERING/FREQUENT_ACCESS"},
+		{locked, lockedVersion, 100, "STANDARD (noncurrent)"},
+		{locked, lockedVersion, 355, ""},
+	}
+	for _, tt := range tests {
+		p, ok := md.Bucket(tt.bucket).Place(tt.cohort, tt.day)
+		got := ""
+		if ok {
+			got = p.String()
+		}
+		assert.Equal(t, tt.want, got, "%s %s on day %d", tt.bucket, tt.cohort.Key, tt.day)
+	}
+
+	tree.Stack("environments/app").SetVars(map[string]cty.Value{"glacier_days": cty.NumberIntVal(90)})
+	p, _ := md.Bucket(docs).Place(doc, 60)
+	assert.Equal(t, "STANDARD_IA", p.String(), "settings follow SetVars")
+}
+
+func TestProject_MonthlyCost(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	dist := []Cohort{
+		{Key: "docs/a.pdf", Count: 1000, Size: megabyte},
+		{Key: "docs/a.txt", Count: 1000, Size: 1024},
+		{Key: "tmp/a", Count: 10, Size: megabyte},
+	}
+	d := md.Bucket(docs)
+
+	p := d.Project(dist, 0)
+	assert.Equal(t, 2010, p.Objects(Standard))
+	assert.InDelta(t, float64(1010*megabyte+1000*1024)/gigabyte*0.025, p.MonthlyCost(SeoulPrices), 1e-9)
+
+	p = d.Project(dist, 60)
+	assert.Equal(t, 1000, p.Objects(Glacier))
+	assert.Equal(t, Usage{Objects: 10, Bytes: 10 * megabyte, Billed: 10 * megabyte}, p.Usage[Placement{Class: Standard, Noncurrent: true}])
+	assert.Equal(t, int64(1000*archiveMetadata), p.Overhead)
+	want := float64(1000*(megabyte+archiveIndex))/gigabyte*0.0045 +
+		float64(1000*archiveMetadata+1000*1024+10*megabyte)/gigabyte*0.025
+	assert.InDelta(t, want, p.MonthlyCost(SeoulPrices), 1e-9)
+	assert.Less(t, p.MonthlyCost(SeoulPrices), d.Project(dist, 0).MonthlyCost(SeoulPrices))
+
+	r := md.Bucket(reports).Project([]Cohort{
+		{Key: "q1.csv", Count: 2000, Size: megabyte},
+		{Key: "q1.json", Count: 500, Size: 1024},
+	}, 30)
+	assert.Equal(t, 2000, r.Usage[Placement{Class: IntelligentTiering, Tier: InfrequentAccess}].Objects)
+	assert.Equal(t, 500, r.Objects(Standard), "small objects are not moved to INTELLIGENT_TIERING")
+	assert.Equal(t, 2000, r.Monitored)
+
+	l := md.Bucket(locked).Project([]Cohort{{Key: "a", Count: 5, Size: megabyte, Age: 400, Replaced: 300}}, 0)
+	assert.Equal(t, 5, l.Expired)
+	assert.Empty(t, l.Usage)
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	findings := md.Check()
+	var keys []string
+	for _, f := range findings {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		docs + " transition",
+		docs + " noncurrent-expiration",
+		docs + " noncurrent-expiration",
+		docs + " intelligent-tiering",
+		locked + " object-lock",
+		reports + " noncurrent-expiration",
+		reports + " intelligent-tiering",
+	}, keys)
+
+	assert.Contains(t, findings, finding.Finding{Subject: docs, Check: CheckTransition,
+		Detail: `rule "archive" moves objects to GLACIER at day 60 and they move to DEEP_ARCHIVE at day 120, before its 90-day minimum storage duration`})
+	assert.Contains(t, findings, finding.Finding{Subject: docs, Check: CheckNoncurrent,
+		Detail: `rule "archive" moves noncurrent versions to GLACIER at noncurrent day 30 and they expire at noncurrent day 60, before its 90-day minimum storage duration`})
+	assert.Contains(t, findings, finding.Finding{Subject: docs, Check: CheckNoncurrent,
+		Detail: `rule "tmp" expires current objects after 7 days, but no rule expires the noncurrent versions that expiration leaves behind`})
+	assert.Contains(t, findings, finding.Finding{Subject: docs, Check: CheckTiering,
+		Detail: `configuration "archive" only tiers objects uploaded as INTELLIGENT_TIERING: no rule moves objects to it`})
+	assert.Contains(t, findings, finding.Finding{Subject: locked, Check: CheckObjectLock,
+		Detail: `rule "cleanup" expires noncurrent versions after 30 days, before the 365-day COMPLIANCE retention ends`})
+	assert.Contains(t, findings, finding.Finding{Subject: reports, Check: CheckNoncurrent,
+		Detail: `rule "logs" sets noncurrent version actions on a bucket without versioning`})
+	assert.Contains(t, findings, finding.Finding{Subject: reports, Check: CheckTiering,
+		Detail: `configuration "EntireBucket" moves objects to DEEP_ARCHIVE_ACCESS at day 400, after rule "tiering" moves them to GLACIER at day 365`})
+}
+
+func TestDurations(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		"never moves objects to GLACIER at day 90: they move to the colder DEEP_ARCHIVE the same day",
+	}, durations([]Transition{{90, Glacier}, {90, DeepArchive}}, 0, "objects", "day"))
+	assert.Equal(t, []string{
+		"never moves objects to GLACIER at day 200: they are in the colder DEEP_ARCHIVE from day 100",
+	}, durations([]Transition{{100, DeepArchive}, {200, Glacier}}, 0, "objects", "day"))
+	assert.Equal(t, []string{
+		"expires objects at day 150, before they move to GLACIER at day 200",
+		"moves objects to STANDARD_IA at day 130 and they expire at day 150, before its 30-day minimum storage duration",
+	}, durations([]Transition{{130, StandardIA}, {200, Glacier}}, 150, "objects", "day"))
+	assert.Empty(t, durations([]Transition{{90, Glacier}, {180, DeepArchive}}, 0, "objects", "day"))
+}
@@ -0,0 +1,304 @@
+package lifecycle
+
+// Cohort is a group of objects of a bucket that share a key, tags, size and
+// age.
+type Cohort struct {
+	Key   string // key, or key prefix, the filters are matched against
+	Tags  map[string]string
+	Count int
+	Size  int64  // bytes per object
+	Age   int    // days since the objects were created, on day 0
+	Class string // storage class they are uploaded with; "" is STANDARD
+
+	// Replaced is the age at which the objects were overwritten or deleted
+	// and became noncurrent versions, or 0 for current objects.
+	Replaced int
+	// Hot objects are read often enough to stay in the Frequent Access tier
+	// of Intelligent-Tiering. The others are not read after upload.
+	Hot bool
+}
+
+// Placement is where an object is stored on a day.
+type Placement struct {
+	Class      string
+	Tier       string // access tier when Class is INTELLIGENT_TIERING
+	Noncurrent bool
+}
+
+// Rate returns the key of the placement in Prices.GBMonth: the storage
+// class, or "INTELLIGENT_TIERING/<tier>".
+func (p Placement) Rate() string {
+	if p.Tier != "" {
+		return p.Class + "/" + p.Tier
+	}
+	return p.Class
+}
+
+func (p Placement) String() string {
+	if p.Noncurrent {
+		return p.Rate() + " (noncurrent)"
+	}
+	return p.Rate()
+}
+
+// Place returns where the objects of a cohort are stored on a day, or false
+// when lifecycle rules have deleted them by then.
+//
+// Expiration makes a current object noncurrent on a versioned bucket and
+// deletes it otherwise, and a bucket that was never versioned keeps no
+// noncurrent versions. Transitions only move objects to colder classes,
+// the coldest due wins, and objects smaller than SmallObject stay where
+// they are unless the rule filters on object_size_greater_than. Noncurrent
+// versions keep the class they had and follow the noncurrent transitions,
+// and are not expired before the default retention of object lock ends.
+// Intelligent-Tiering objects that are not hot move down the automatic
+// tiers, and the archive tiers of the configurations that select them,
+// from the day they entered the class.
+func (bk *Bucket) Place(c Cohort, day int) (Placement, bool) {
+	age := c.Age + day
+	rules := bk.matching(c)
+	replaced := c.Replaced
+	if replaced > 0 && bk.Versioning == "" {
+		return Placement{}, false
+	}
+	if replaced == 0 {
+		if exp := earliest(rules, func(r *Rule) int { return r.Expiration }); exp > 0 && age >= exp {
+			if bk.Versioning != "Enabled" {
+				return Placement{}, false
+			}
+			replaced = exp
+		}
+	}
+
+	class := c.Class
+	if class == "" {
+		class = Standard
+	}
+	current := age
+	if replaced > 0 {
+		current = replaced
+	}
+	class, entered := transition(c, rules, class, 0, current, func(r *Rule) []Transition { return r.Transitions })
+	p := Placement{Class: class}
+	if replaced > 0 {
+		p.Noncurrent = true
+		since := age - replaced
+		exp := earliest(rules, func(r *Rule) int { return r.NoncurrentExpiration })
+		if exp > 0 && since >= exp && (bk.Lock == nil || age >= bk.Lock.Days) {
+			return Placement{}, false
+		}
+		var moved int
+		p.Class, moved = transition(c, rules, class, replaced, since, func(r *Rule) []Transition { return r.NoncurrentTransitions })
+		if p.Class != class {
+			entered = moved
+		}
+	}
+	if p.Class == IntelligentTiering {
+		p.Tier = bk.tier(c, age-entered)
+	}
+	return p, true
+}
+
+// matching returns the enabled rules that select a cohort.
+func (bk *Bucket) matching(c Cohort) []*Rule {
+	var out []*Rule
+	for _, r := range bk.Rules {
+		if r.Enabled && r.Matches(c.Key, c.Tags, c.Size) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// earliest returns the smallest non-zero day count of the rules, or 0.
+func earliest(rules []*Rule, days func(*Rule) int) int {
+	min := 0
+	for _, r := range rules {
+		if d := days(r); d > 0 && (min == 0 || d < min) {
+			min = d
+		}
+	}
+	return min
+}
+
+// transition returns the coldest class the transitions of the rules have
+// moved a cohort to after the given number of days, starting in class at
+// age offset, and the age at which the cohort entered it.
+func transition(c Cohort, rules []*Rule, class string, offset, days int, transitions func(*Rule) []Transition) (string, int) {
+	entered, start := offset, class
+	for _, r := range rules {
+		if c.Size < SmallObject && r.SizeGreaterThan == 0 {
+			continue
+		}
+		for _, t := range transitions(r) {
+			if t.Days > days || coldness[t.StorageClass] <= coldness[start] {
+				continue
+			}
+			if coldness[t.StorageClass] > coldness[class] || (t.StorageClass == class && offset+t.Days < entered) {
+				class, entered = t.StorageClass, offset+t.Days
+			}
+		}
+	}
+	return class, entered
+}
+
+// tier returns the access tier of an Intelligent-Tiering object after days
+// without access.
+func (bk *Bucket) tier(c Cohort, idle int) string {
+	tier := FrequentAccess
+	if c.Hot || c.Size < SmallObject {
+		return tier
+	}
+	reach := func(t string, days int) {
+		if days > 0 && idle >= days && tierColdness[t] > tierColdness[tier] {
+			tier = t
+		}
+	}
+	for t, days := range automaticTiers {
+		reach(t, days)
+	}
+	for _, cfg := range bk.Tiering {
+		if cfg.Enabled && cfg.Matches(c.Key, c.Tags, c.Size) {
+			for t, days := range cfg.Tiers {
+				reach(t, days)
+			}
+		}
+	}
+	return tier
+}
+
+// Usage is what a placement holds.
+type Usage struct {
+	Objects int
+	Bytes   int64 // stored bytes
+	// Billed is the bytes billed at the rate of the placement: objects
+	// smaller than the minimum billable size of STANDARD_IA, ONEZONE_IA and
+	// GLACIER_IR count as that size, and GLACIER and DEEP_ARCHIVE objects
+	// carry 32 KB of index each.
+	Billed int64
+}
+
+// Projection is where the objects of a distribution are stored on a day.
+type Projection struct {
+	Bucket  string
+	Day     int
+	Usage   map[Placement]Usage
+	Expired int // objects deleted by lifecycle rules
+
+	// Overhead is the metadata of GLACIER and DEEP_ARCHIVE objects, 8 KB
+	// each, billed at the STANDARD rate.
+	Overhead int64
+	// Monitored counts Intelligent-Tiering objects charged for monitoring.
+	Monitored int
+}
+
+// Billing overheads of storage classes, in bytes.
+const (
+	minimumBillable = 128 * 1024
+	archiveIndex    = 32 * 1024
+	archiveMetadata = 8 * 1024
+)
+
+// Project places every cohort of a distribution on a day.
+func (bk *Bucket) Project(dist []Cohort, day int) *Projection {
+	p := &Projection{Bucket: bk.ID, Day: day, Usage: map[Placement]Usage{}}
+	for _, c := range dist {
+		pl, ok := bk.Place(c, day)
+		if !ok {
+			p.Expired += c.Count
+			continue
+		}
+		billed := c.Size
+		switch pl.Class {
+		case StandardIA, OneZoneIA, GlacierIR:
+			if billed < minimumBillable {
+				billed = minimumBillable
+			}
+		case Glacier, DeepArchive:
+			billed += archiveIndex
+			p.Overhead += int64(c.Count) * archiveMetadata
+		case IntelligentTiering:
+			if c.Size >= SmallObject {
+				p.Monitored += c.Count
+			}
+		}
+		u := p.Usage[pl]
+		u.Objects += c.Count
+		u.Bytes += int64(c.Count) * c.Size
+		u.Billed += int64(c.Count) * billed
+		p.Usage[pl] = u
+	}
+	return p
+}
+
+// Objects returns the number of objects stored in a class, current and
+// noncurrent, in any access tier.
+func (p *Projection) Objects(class string) int {
+	n := 0
+	for pl, u := range p.Usage {
+		if pl.Class == class {
+			n += u.Objects
+		}
+	}
+	return n
+}
+
+// Prices are storage prices of a region.
+type Prices struct {
+	// GBMonth is USD per GB-month by Placement.Rate.
+	GBMonth map[string]float64
+	// Monitoring is USD per 1,000 monitored Intelligent-Tiering objects a
+	// month.
+	Monitoring float64
+}
+
+// SeoulPrices and VirginiaPrices are the S3 list prices of ap-northeast-2
+// and us-east-1 for the first 50 TB a month.
+var (
+	SeoulPrices = Prices{
+		GBMonth: map[string]float64{
+			Standard:    0.025,
+			StandardIA:  0.0138,
+			OneZoneIA:   0.011,
+			GlacierIR:   0.005,
+			Glacier:     0.0045,
+			DeepArchive: 0.002,
+
+			IntelligentTiering + "/" + FrequentAccess:       0.025,
+			IntelligentTiering + "/" + InfrequentAccess:     0.0138,
+			IntelligentTiering + "/" + ArchiveInstantAccess: 0.005,
+			IntelligentTiering + "/" + ArchiveAccess:        0.0045,
+			IntelligentTiering + "/" + DeepArchiveAccess:    0.002,
+		},
+		Monitoring: 0.0025,
+	}
+	VirginiaPrices = Prices{
+		GBMonth: map[string]float64{
+			Standard:    0.023,
+			StandardIA:  0.0125,
+			OneZoneIA:   0.01,
+			GlacierIR:   0.004,
+			Glacier:     0.0036,
+			DeepArchive: 0.00099,
+
+			IntelligentTiering + "/" + FrequentAccess:       0.023,
+			IntelligentTiering + "/" + InfrequentAccess:     0.0125,
+			IntelligentTiering + "/" + ArchiveInstantAccess: 0.004,
+			IntelligentTiering + "/" + ArchiveAccess:        0.0036,
+			IntelligentTiering + "/" + DeepArchiveAccess:    0.00099,
+		},
+		Monitoring: 0.0025,
+	}
+)
+
+const gigabyte = 1 << 30
+
+// MonthlyCost estimates the monthly storage cost of a projection in USD.
+// Requests, retrievals and early deletion are not included.
+func (p *Projection) MonthlyCost(prices Prices) float64 {
+	cost := float64(p.Overhead) / gigabyte * prices.GBMonth[Standard]
+	for pl, u := range p.Usage {
+		cost += float64(u.Billed) / gigabyte * prices.GBMonth[pl.Rate()]
+	}
+	return cost + float64(p.Monitored)/1000*prices.Monitoring
+}
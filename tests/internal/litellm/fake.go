@@ -0,0 +1,319 @@
+package litellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Fake is an in-process LiteLLM proxy serving the models of a Config. It
+// answers deterministically: chat completions echo the last user message and
+// embeddings are derived from a hash of the input.
+type Fake struct {
+	Config    *Config
+	MasterKey string
+
+	seq atomic.Int64
+}
+
+// embeddingDimensions are the default output sizes of the embedding models
+// and the sizes they can be asked for.
+var embeddingDimensions = map[string][]int{
+	"amazon.titan-embed-text-v2:0": {1024, 512, 256},
+	"amazon.titan-embed-text-v1":   {1536},
+	"cohere.embed-multilingual-v3": {1024},
+	"text-embedding-3-small":       {1536},
+	"text-embedding-3-large":       {3072},
+}
+
+// EmbeddingDimensions returns the default dimensions of an embedding model,
+// or 0 if it is not known.
+func EmbeddingDimensions(m Model) int {
+	if d := embeddingDimensions[m.Upstream()]; len(d) > 0 {
+		return d[0]
+	}
+	return 0
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/health/liveliness" {
+		writeJSON(w, http.StatusOK, "I'm alive!")
+		return
+	}
+	var handle func(http.ResponseWriter, *http.Request)
+	switch r.Method + " " + r.URL.Path {
+	case "GET /health":
+		handle = f.health
+	case "GET /v1/models", "GET /models":
+		handle = f.models
+	case "POST /v1/chat/completions", "POST /chat/completions":
+		handle = f.chat
+	case "POST /v1/embeddings", "POST /embeddings":
+		handle = f.embeddings
+	default:
+		writeError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("Route %s %s not found", r.Method, r.URL.Path))
+		return
+	}
+	if !f.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "auth_error", "Authentication Error, invalid proxy server token passed.")
+		return
+	}
+	handle(w, r)
+}
+
+// authorized accepts the master key as a bearer token.
+func (f *Fake) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && f.MasterKey != "" && token == f.MasterKey
+}
+
+func (f *Fake) health(w http.ResponseWriter, _ *http.Request) {
+	var healthy []map[string]string
+	for _, m := range f.Config.ModelList {
+		healthy = append(healthy, map[string]string{"model": m.Params.Model})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"healthy_endpoints":   healthy,
+		"unhealthy_endpoints": []any{},
+		"healthy_count":       len(healthy),
+		"unhealthy_count":     0,
+	})
+}
+
+func (f *Fake) models(w http.ResponseWriter, _ *http.Request) {
+	var data []map[string]any
+	for _, m := range f.Config.ModelList {
+		data = append(data, map[string]any{"id": m.Name, "object": "model", "created": 1677610602, "owned_by": "openai"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+// model resolves the model of a request, writing the error response when it
+// cannot be served.
+func (f *Fake) model(w http.ResponseWriter, name string, embedding bool) *Model {
+	m := f.Config.Model(name)
+	switch {
+	case name == "":
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
+	case m == nil:
+		writeError(w, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("Invalid model name passed in model=%s. Call `/v1/models` to view available models for your key.", name))
+	case m.Embedding() != embedding:
+		writeError(w, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("model=%s does not support this endpoint", name))
+	default:
+		return m
+	}
+	return nil
+}
+
+type chatRequest struct {
+	Model         string            `json:"model"`
+	Messages      []json.RawMessage `json:"messages"`
+	Stream        bool              `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	MaxTokens *int `json:"max_tokens"`
+}
+
+func (f *Fake) chat(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body: "+err.Error())
+		return
+	}
+	m := f.model(w, req.Model, false)
+	if m == nil {
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "messages must be a non-empty array")
+		return
+	}
+	var last string
+	for i, raw := range req.Messages {
+		var msg struct {
+			Role    string `json:"role"`
+			Content any    `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Role == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("messages[%d] needs a role", i))
+			return
+		}
+		switch msg.Role {
+		case "system", "user", "assistant", "tool":
+		default:
+			writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("messages[%d]: invalid role %s", i, msg.Role))
+			return
+		}
+		if s, ok := msg.Content.(string); ok && msg.Role == "user" {
+			last = s
+		}
+	}
+	if req.MaxTokens != nil && *req.MaxTokens < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "max_tokens must be at least 1")
+		return
+	}
+
+	words := strings.Fields("echo: " + last)
+	if req.MaxTokens != nil && *req.MaxTokens < len(words) {
+		words = words[:*req.MaxTokens]
+	}
+	finish := "stop"
+	if len(words) < len(strings.Fields("echo: "+last)) {
+		finish = "length"
+	}
+	usage := Usage{PromptTokens: tokens(req.Messages), CompletionTokens: len(words)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	id := fmt.Sprintf("chatcmpl-%d", f.seq.Add(1))
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id": id, "object": "chat.completion", "created": created, "model": m.Upstream(),
+			"choices": []any{map[string]any{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": strings.Join(words, " ")},
+				"finish_reason": finish,
+			}},
+			"usage": usage,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(v any) {
+		b, _ := json.Marshal(v)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	chunk := func(delta map[string]any, finish any) map[string]any {
+		return map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": m.Upstream(),
+			"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+	send(chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+	for i, word := range words {
+		if i > 0 {
+			word = " " + word
+		}
+		send(chunk(map[string]any{"content": word}, nil))
+	}
+	send(chunk(map[string]any{}, finish))
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		send(map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": m.Upstream(),
+			"choices": []any{}, "usage": usage,
+		})
+	}
+	_, _ = fmt.Fprintf(w, "data: %s\n\n", Done)
+}
+
+func (f *Fake) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model      string          `json:"model"`
+		Input      json.RawMessage `json:"input"`
+		Dimensions *int            `json:"dimensions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body: "+err.Error())
+		return
+	}
+	m := f.model(w, req.Model, true)
+	if m == nil {
+		return
+	}
+	var inputs []string
+	var single string
+	if err := json.Unmarshal(req.Input, &single); err == nil {
+		inputs = []string{single}
+	} else if err := json.Unmarshal(req.Input, &inputs); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "input must be a string or an array of strings")
+		return
+	}
+	if len(inputs) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "input must not be empty")
+		return
+	}
+	sizes := embeddingDimensions[m.Upstream()]
+	if len(sizes) == 0 {
+		sizes = []int{1024}
+	}
+	dims := sizes[0]
+	if req.Dimensions != nil {
+		dims = 0
+		for _, s := range sizes {
+			if s == *req.Dimensions {
+				dims = s
+			}
+		}
+		if dims == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error",
+				fmt.Sprintf("dimensions=%d is not supported by %s, supported: %v", *req.Dimensions, m.Name, sizes))
+			return
+		}
+	}
+
+	var data []any
+	total := 0
+	for i, in := range inputs {
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": Embed(in, dims)})
+		total += len(strings.Fields(in))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object": "list", "model": m.Upstream(), "data": data,
+		"usage": Usage{PromptTokens: total, TotalTokens: total},
+	})
+}
+
+// Embed returns a deterministic unit vector for a text, so equal inputs get
+// equal embeddings.
+func Embed(text string, dims int) []float64 {
+	v := make([]float64, dims)
+	var norm float64
+	for i := range v {
+		h := fnv.New64a()
+		_, _ = fmt.Fprintf(h, "%d:%s", i, text)
+		v[i] = float64(h.Sum64()%2000)/1000 - 1
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func tokens(messages []json.RawMessage) int {
+	n := 0
+	for _, m := range messages {
+		n += len(strings.Fields(string(m)))
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes LiteLLM's error envelope, whose code is the HTTP status
+// as a string.
+func writeError(w http.ResponseWriter, status int, typ, message string) {
+	writeJSON(w, status, map[string]any{"error": map[string]any{
+		"message": message, "type": typ, "param": nil, "code": fmt.Sprint(status),
+	}})
+}
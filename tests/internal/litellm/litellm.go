@@ -0,0 +1,108 @@
+// Package litellm models the OpenAI-compatible interface the LiteLLM gateway
+// exposes: the model list from its config.yaml, validators for the response
+// and error schemas clients rely on, a server-sent events reader, and Fake,
+// an in-process stand-in built from the same config.
+package litellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Model is a model_list entry of config.yaml.
+type Model struct {
+	Name   string `yaml:"model_name"`
+	Params struct {
+		Model         string `yaml:"model"`
+		APIKey        string `yaml:"api_key"`
+		AWSRegionName string `yaml:"aws_region_name"`
+	} `yaml:"litellm_params"`
+}
+
+// Provider returns the provider prefix of the underlying model, e.g.
+// "bedrock" for bedrock/anthropic.claude-3-haiku-20240307-v1:0.
+func (m Model) Provider() string {
+	p, _, _ := strings.Cut(m.Params.Model, "/")
+	return p
+}
+
+// Upstream returns the underlying model without its provider prefix.
+func (m Model) Upstream() string {
+	_, u, found := strings.Cut(m.Params.Model, "/")
+	if !found {
+		return m.Params.Model
+	}
+	return u
+}
+
+// Embedding reports whether the model produces embeddings rather than chat
+// completions.
+func (m Model) Embedding() bool {
+	return strings.Contains(m.Params.Model, "embed")
+}
+
+// Config is the part of LiteLLM's config.yaml the gateway relies on.
+type Config struct {
+	ModelList       []Model `yaml:"model_list"`
+	GeneralSettings struct {
+		MasterKey           string `yaml:"master_key"`
+		MaxParallelRequests int    `yaml:"max_parallel_requests"`
+	} `yaml:"general_settings"`
+	RouterSettings struct {
+		NumRetries int `yaml:"num_retries"`
+		Timeout    int `yaml:"timeout"`
+	} `yaml:"router_settings"`
+}
+
+// ParseConfig parses config.yaml.
+func ParseConfig(data string) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal([]byte(data), &c); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, m := range c.ModelList {
+		if m.Name == "" || m.Params.Model == "" {
+			return nil, fmt.Errorf("model_list entry without model_name or model")
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("duplicate model_name %s", m.Name)
+		}
+		seen[m.Name] = true
+	}
+	return &c, nil
+}
+
+// Model returns the model_list entry for a model name, or nil.
+func (c *Config) Model(name string) *Model {
+	for i := range c.ModelList {
+		if c.ModelList[i].Name == name {
+			return &c.ModelList[i]
+		}
+	}
+	return nil
+}
+
+// Names returns the model names, in config order.
+func (c *Config) Names() []string {
+	var out []string
+	for _, m := range c.ModelList {
+		out = append(out, m.Name)
+	}
+	return out
+}
+
+// Error is the error envelope: {"error": {...}}.
+type Error struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Param   json.RawMessage `json:"param"`
+	Code    json.RawMessage `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
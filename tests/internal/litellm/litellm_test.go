@@ -0,0 +1,145 @@
+package litellm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleConfig = `
+model_list:
+  - model_name: fast
+    litellm_params:
+      model: openai/gpt-4o-mini
+      api_key: os.environ/OPENAI_API_KEY
+  - model_name: embed
+    litellm_params:
+      model: bedrock/amazon.titan-embed-text-v2:0
+      aws_region_name: us-east-1
+general_settings:
+  master_key: os.environ/LITELLM_MASTER_KEY
+`
+
+func newFake(t *testing.T) (*Config, *httptest.Server) {
+	t.Helper()
+	cfg, err := ParseConfig(sampleConfig)
+	require.NoError(t, err)
+	srv := httptest.NewServer(&Fake{Config: cfg, MasterKey: "k"})
+	t.Cleanup(srv.Close)
+	return cfg, srv
+}
+
+func post(t *testing.T, srv *httptest.Server, path string, body any) (int, []byte) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer k")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, out
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := ParseConfig(sampleConfig)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fast", "embed"}, cfg.Names())
+	m := cfg.Model("embed")
+	require.NotNil(t, m)
+	assert.Equal(t, "bedrock", m.Provider())
+	assert.Equal(t, "amazon.titan-embed-text-v2:0", m.Upstream())
+	assert.True(t, m.Embedding())
+	assert.Equal(t, 1024, EmbeddingDimensions(*m))
+
+	_, err = ParseConfig("model_list:\n  - model_name: a\n    litellm_params: {model: x}\n  - model_name: a\n    litellm_params: {model: y}\n")
+	assert.ErrorContains(t, err, "duplicate model_name a")
+}
+
+func TestValidators_RejectMalformedDocuments(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseChatCompletion([]byte(`{"id":"x","object":"chat.completion","created":1,"model":"m","choices":[]}`))
+	assert.ErrorContains(t, err, "no choices")
+	_, err = ParseChatCompletion([]byte(`{"id":"x","object":"chat.completion","created":1,"model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"a"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":3}}`))
+	assert.ErrorContains(t, err, "usage does not add up")
+	_, err = ParseChatCompletion([]byte(`{"object":"chat.completion"}`))
+	assert.ErrorContains(t, err, `missing "id"`)
+
+	_, err = ParseError([]byte(`{"detail":"Not Found"}`))
+	assert.ErrorContains(t, err, `missing "error"`)
+	_, err = ParseError([]byte(`{"error":{"message":"m","code":{"x":1}}}`))
+	assert.ErrorContains(t, err, "error.code")
+	e, err := ParseError([]byte(`{"error":{"message":"m","type":"auth_error","param":null,"code":"401"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "auth_error", e.Type)
+
+	_, err = ParseEmbeddings([]byte(`{"object":"list","model":"m","data":[{"object":"embedding","index":0,"embedding":[1,2]},{"object":"embedding","index":1,"embedding":[1]}]}`))
+	assert.ErrorContains(t, err, "data[1]: 1 dimensions")
+}
+
+func TestParseStream(t *testing.T) {
+	t.Parallel()
+
+	chunk := func(delta, finish string) string {
+		return `data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":` + delta + `,"finish_reason":` + finish + `}]}` + "\n\n"
+	}
+	good := ": keep-alive\n\n" + chunk(`{"role":"assistant"}`, "null") + chunk(`{"content":"hi"}`, "null") + chunk(`{}`, `"stop"`) + "data: [DONE]\n\n"
+	chunks, content, err := ParseStream([]byte(good))
+	require.NoError(t, err)
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, "hi", content)
+
+	_, _, err = ParseStream([]byte(chunk(`{"role":"assistant"}`, `"stop"`)))
+	assert.ErrorContains(t, err, "does not end with data: [DONE]")
+	_, _, err = ParseStream([]byte(chunk(`{"content":"hi"}`, `"stop"`) + "data: [DONE]\n\n"))
+	assert.ErrorContains(t, err, "first delta")
+	_, _, err = ParseStream([]byte(chunk(`{"role":"assistant"}`, `"stop"`) + chunk(`{"content":"late"}`, "null") + "data: [DONE]\n\n"))
+	assert.ErrorContains(t, err, "content after finish_reason")
+
+	events, err := ReadEvents(strings.NewReader("event: a\ndata: 1\ndata: 2\n\ndata: 3\n"))
+	assert.Error(t, err, "unterminated event")
+	assert.Equal(t, []Event{{Name: "a", Data: "1\n2"}}, events)
+}
+
+func TestFake_ServesConfigModels(t *testing.T) {
+	t.Parallel()
+
+	_, srv := newFake(t)
+
+	status, body := post(t, srv, "/v1/chat/completions", map[string]any{
+		"model": "fast", "max_tokens": 2,
+		"messages": []map[string]string{{"role": "user", "content": "one two three"}},
+	})
+	require.Equal(t, http.StatusOK, status, string(body))
+	c, err := ParseChatCompletion(body)
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", c.Model, "aliases are answered by the upstream model")
+	assert.Equal(t, "echo: one", c.Choices[0].Message.Content)
+	assert.Equal(t, "length", c.Choices[0].FinishReason)
+
+	status, body = post(t, srv, "/v1/embeddings", map[string]any{"model": "embed", "input": []string{"a", "a"}, "dimensions": 256})
+	require.Equal(t, http.StatusOK, status, string(body))
+	e, err := ParseEmbeddings(body)
+	require.NoError(t, err)
+	assert.Len(t, e.Data[0].Embedding, 256)
+	assert.Equal(t, e.Data[0].Embedding, e.Data[1].Embedding, "embeddings are deterministic")
+
+	status, body = post(t, srv, "/v1/embeddings", map[string]any{"model": "embed", "input": "a", "dimensions": 300})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, string(body), "supported: [1024 512 256]")
+
+	status, _ = post(t, srv, "/v1/embeddings", map[string]any{"model": "fast", "input": "a"})
+	assert.Equal(t, http.StatusBadRequest, status, "chat models do not embed")
+	status, _ = post(t, srv, "/v1/chat/completions", map[string]any{"model": "embed", "messages": []map[string]string{{"role": "user", "content": "a"}}})
+	assert.Equal(t, http.StatusBadRequest, status, "embedding models do not chat")
+}
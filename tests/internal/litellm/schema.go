@@ -0,0 +1,308 @@
+package litellm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Usage is the token accounting of a response.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Message is a chat message.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatCompletion is a non-streaming chat completion response.
+type ChatCompletion struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int     `json:"index"`
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+// ChatCompletionChunk is a streamed chat completion event.
+type ChatCompletionChunk struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role    string `json:"role,omitempty"`
+			Content string `json:"content,omitempty"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Embeddings is an embeddings response.
+type Embeddings struct {
+	Object string `json:"object"`
+	Model  string `json:"model"`
+	Data   []struct {
+		Object    string    `json:"object"`
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage *Usage `json:"usage"`
+}
+
+// ModelList is the /v1/models response.
+type ModelList struct {
+	Object string `json:"object"`
+	Data   []struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	} `json:"data"`
+}
+
+// fields decodes body into v after checking every required top-level field
+// is present and not null.
+func fields(body []byte, v any, required ...string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return fmt.Errorf("not a JSON object: %v", err)
+	}
+	for _, f := range required {
+		if r, ok := raw[f]; !ok || string(r) == "null" {
+			return fmt.Errorf("missing %q", f)
+		}
+	}
+	return json.Unmarshal(body, v)
+}
+
+// ParseChatCompletion decodes and validates a chat completion.
+func ParseChatCompletion(body []byte) (*ChatCompletion, error) {
+	var c ChatCompletion
+	if err := fields(body, &c, "id", "object", "created", "model", "choices"); err != nil {
+		return nil, err
+	}
+	switch {
+	case c.Object != "chat.completion":
+		return nil, fmt.Errorf("object is %q, want chat.completion", c.Object)
+	case c.Created <= 0:
+		return nil, fmt.Errorf("created is not a timestamp")
+	case len(c.Choices) == 0:
+		return nil, fmt.Errorf("no choices")
+	}
+	for i, ch := range c.Choices {
+		if ch.Index != i {
+			return nil, fmt.Errorf("choice %d has index %d", i, ch.Index)
+		}
+		if ch.Message.Role != "assistant" {
+			return nil, fmt.Errorf("choice %d: role is %q, want assistant", i, ch.Message.Role)
+		}
+		if ch.FinishReason == "" {
+			return nil, fmt.Errorf("choice %d: no finish_reason", i)
+		}
+	}
+	if err := c.Usage.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ParseChunk decodes and validates a streamed chunk.
+func ParseChunk(data []byte) (*ChatCompletionChunk, error) {
+	var c ChatCompletionChunk
+	if err := fields(data, &c, "id", "object", "created", "model", "choices"); err != nil {
+		return nil, err
+	}
+	if c.Object != "chat.completion.chunk" {
+		return nil, fmt.Errorf("object is %q, want chat.completion.chunk", c.Object)
+	}
+	if c.Usage != nil {
+		if err := c.Usage.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// ParseEmbeddings decodes and validates an embeddings response.
+func ParseEmbeddings(body []byte) (*Embeddings, error) {
+	var e Embeddings
+	if err := fields(body, &e, "object", "model", "data"); err != nil {
+		return nil, err
+	}
+	if e.Object != "list" {
+		return nil, fmt.Errorf("object is %q, want list", e.Object)
+	}
+	for i, d := range e.Data {
+		switch {
+		case d.Object != "embedding":
+			return nil, fmt.Errorf("data[%d]: object is %q, want embedding", i, d.Object)
+		case d.Index != i:
+			return nil, fmt.Errorf("data[%d] has index %d", i, d.Index)
+		case len(d.Embedding) == 0:
+			return nil, fmt.Errorf("data[%d]: empty embedding", i)
+		case len(d.Embedding) != len(e.Data[0].Embedding):
+			return nil, fmt.Errorf("data[%d]: %d dimensions, data[0] has %d", i, len(d.Embedding), len(e.Data[0].Embedding))
+		}
+	}
+	return &e, nil
+}
+
+// ParseModelList decodes and validates a /v1/models response.
+func ParseModelList(body []byte) (*ModelList, error) {
+	var l ModelList
+	if err := fields(body, &l, "object", "data"); err != nil {
+		return nil, err
+	}
+	if l.Object != "list" {
+		return nil, fmt.Errorf("object is %q, want list", l.Object)
+	}
+	for i, m := range l.Data {
+		if m.ID == "" || m.Object != "model" {
+			return nil, fmt.Errorf("data[%d]: want an id and object model", i)
+		}
+	}
+	return &l, nil
+}
+
+// ParseError decodes and validates an error envelope. param and code may be
+// strings, numbers or null.
+func ParseError(body []byte) (*Error, error) {
+	var env struct {
+		Error *Error `json:"error"`
+	}
+	if err := fields(body, &env, "error"); err != nil {
+		return nil, err
+	}
+	e := env.Error
+	if e.Message == "" {
+		return nil, fmt.Errorf("error without a message")
+	}
+	for name, raw := range map[string]json.RawMessage{"param": e.Param, "code": e.Code} {
+		if len(raw) == 0 {
+			continue
+		}
+		var v any
+		_ = json.Unmarshal(raw, &v)
+		switch v.(type) {
+		case nil, string, float64:
+		default:
+			return nil, fmt.Errorf("error.%s is %s, want a string, number or null", name, raw)
+		}
+	}
+	return e, nil
+}
+
+func (u *Usage) validate() error {
+	if u == nil {
+		return fmt.Errorf("missing usage")
+	}
+	if u.PromptTokens < 0 || u.CompletionTokens < 0 || u.TotalTokens != u.PromptTokens+u.CompletionTokens {
+		return fmt.Errorf("usage does not add up: %+v", *u)
+	}
+	return nil
+}
+
+// Event is a server-sent event.
+type Event struct {
+	Name string
+	Data string
+}
+
+// Done is the data of the event that ends an OpenAI-style stream.
+const Done = "[DONE]"
+
+// ReadEvents reads server-sent events until EOF. Multi-line data is joined
+// with newlines; comments and retry/id fields are ignored.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var out []Event
+	var cur Event
+	var data []string
+	flush := func() {
+		if data != nil {
+			cur.Data = strings.Join(data, "\n")
+			out = append(out, cur)
+		}
+		cur, data = Event{}, nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			cur.Name = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return out, fmt.Errorf("stream ended in the middle of an event")
+	}
+	return out, nil
+}
+
+// ParseStream validates a chat completion stream: chunks sharing one id,
+// the first delta carrying the assistant role, exactly one finish_reason on
+// the last content chunk, and a final [DONE]. It returns the chunks and the
+// assembled content.
+func ParseStream(body []byte) ([]*ChatCompletionChunk, string, error) {
+	events, err := ReadEvents(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(events) == 0 || events[len(events)-1].Data != Done {
+		return nil, "", fmt.Errorf("stream does not end with data: %s", Done)
+	}
+	var chunks []*ChatCompletionChunk
+	var content strings.Builder
+	finished := false
+	for i, ev := range events[:len(events)-1] {
+		c, err := ParseChunk([]byte(ev.Data))
+		if err != nil {
+			return nil, "", fmt.Errorf("event %d: %v", i, err)
+		}
+		if len(chunks) > 0 && c.ID != chunks[0].ID {
+			return nil, "", fmt.Errorf("event %d: id %s differs from %s", i, c.ID, chunks[0].ID)
+		}
+		for _, ch := range c.Choices {
+			if i == 0 && ch.Delta.Role != "assistant" {
+				return nil, "", fmt.Errorf("first delta has role %q, want assistant", ch.Delta.Role)
+			}
+			if finished && (ch.Delta.Content != "" || ch.FinishReason != nil) {
+				return nil, "", fmt.Errorf("event %d: content after finish_reason", i)
+			}
+			content.WriteString(ch.Delta.Content)
+			finished = finished || ch.FinishReason != nil
+		}
+		chunks = append(chunks, c)
+	}
+	if !finished {
+		return nil, "", fmt.Errorf("no chunk has a finish_reason")
+	}
+	return chunks, content.String(), nil
+}
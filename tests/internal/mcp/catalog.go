@@ -0,0 +1,310 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ToolSpec is a tool as registered in a bridge's server.js with
+// mcp.tool(name, description, zodShape, handler).
+type ToolSpec struct {
+	Name string
+	Args []Arg
+	// Envelope is set when the handler answers through withEnvelope.
+	Envelope bool
+	// Structured is set when the handler appends its own structured block.
+	Structured bool
+	// LegacyErrors counts isError results built inline rather than by
+	// lib/errors.renderError.
+	LegacyErrors int
+	// ErrorCodes are the lib/errors codes the handler renders explicitly.
+	ErrorCodes []string
+}
+
+// Arg is a property of a zod shape.
+type Arg struct {
+	Name        string
+	Type        string // string, number, boolean
+	Enum        []string
+	Optional    bool
+	Default     json.RawMessage
+	Description string
+}
+
+// InputSchema is the JSON Schema the SDK derives from the zod shape.
+func (t ToolSpec) InputSchema() *Schema {
+	s := &Schema{
+		SchemaURI:            "http://json-schema.org/draft-07/schema#",
+		Type:                 json.RawMessage(`"object"`),
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: json.RawMessage("false"),
+	}
+	for _, a := range t.Args {
+		typ, _ := json.Marshal(a.Type)
+		p := &Schema{Type: typ, Description: a.Description, Default: a.Default}
+		for _, e := range a.Enum {
+			p.Enum = append(p.Enum, e)
+		}
+		s.Properties[a.Name] = p
+		if !a.Optional && a.Default == nil {
+			s.Required = append(s.Required, a.Name)
+		}
+	}
+	return s
+}
+
+var (
+	serverInfo    = regexp.MustCompile(`new McpServer\(\{\s*name:\s*"([^"]+)",\s*version:\s*"([^"]+)"`)
+	toolCall      = regexp.MustCompile(`\bmcp\.tool\(\s*"([^"]+)"\s*,`)
+	errorCodeRef  = regexp.MustCompile(`ERROR_CODES\.(\w+)`)
+	legacyIsError = regexp.MustCompile(`isError:\s*true`)
+)
+
+// ParseServerInfo reads the name and version server.js gives McpServer.
+func ParseServerInfo(src string) (Implementation, error) {
+	m := serverInfo.FindStringSubmatch(src)
+	if m == nil {
+		return Implementation{}, fmt.Errorf("no new McpServer({ name, version })")
+	}
+	return Implementation{Name: m[1], Version: m[2]}, nil
+}
+
+// ParseServerJS reads the tool registrations of server.js. It is a static
+// reading of the source: comments are ignored, and each tool's handler
+// argument, up to the end of its mcp.tool call, is scanned for how it
+// answers. What the handler reaches through helpers defined elsewhere is
+// not seen.
+func ParseServerJS(src string) ([]ToolSpec, error) {
+	src = stripComments(src)
+	locs := toolCall.FindAllStringSubmatchIndex(src, -1)
+	if len(locs) == 0 {
+		return nil, fmt.Errorf("no mcp.tool registrations")
+	}
+	var out []ToolSpec
+	seen := map[string]bool{}
+	for _, loc := range locs {
+		spec := ToolSpec{Name: src[loc[2]:loc[3]]}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("tool %s is registered twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		open := loc[0] + strings.IndexByte(src[loc[0]:], '(')
+		end, err := balanced(src, open)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", spec.Name, err)
+		}
+		call := src[:end-1]
+		comma, err := argEnd(call, loc[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: description: %v", spec.Name, err)
+		}
+		body := strings.TrimLeft(call[comma+1:], " \t\r\n")
+
+		if !strings.HasPrefix(body, "{") {
+			return nil, fmt.Errorf("%s: the third argument is not a zod shape literal", spec.Name)
+		}
+		n, err := balanced(body, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", spec.Name, err)
+		}
+		if spec.Args, err = parseShape(body[1 : n-1]); err != nil {
+			return nil, fmt.Errorf("%s: %v", spec.Name, err)
+		}
+
+		handler := body[n:]
+		spec.Envelope = strings.Contains(handler, "withEnvelope(")
+		spec.Structured = strings.Contains(handler, "--- structured ---")
+		spec.LegacyErrors = len(legacyIsError.FindAllString(handler, -1))
+		codes := map[string]bool{}
+		for _, m := range errorCodeRef.FindAllStringSubmatch(handler, -1) {
+			c := strings.ToLower(m[1])
+			if !codes[c] {
+				codes[c] = true
+				spec.ErrorCodes = append(spec.ErrorCodes, c)
+			}
+		}
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+// stripComments blanks the // and /* */ comments of JavaScript source
+// outside string literals, keeping line breaks and offsets.
+func stripComments(src string) string {
+	b := []byte(src)
+	blank := func(from, to int) {
+		for ; from < to; from++ {
+			if b[from] != '\n' {
+				b[from] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '"' || b[i] == '\'' || b[i] == '`':
+			i = stringEnd(src, i)
+		case strings.HasPrefix(src[i:], "//"):
+			n := strings.IndexByte(src[i:], '\n')
+			if n < 0 {
+				n = len(src) - i
+			}
+			blank(i, i+n)
+			i += n
+		case strings.HasPrefix(src[i:], "/*"):
+			n := strings.Index(src[i+2:], "*/")
+			if n < 0 {
+				n = len(src) - i - 4
+			}
+			blank(i, i+n+4)
+			i += n + 3
+		}
+	}
+	return string(b)
+}
+
+// parseShape parses the entries of a zod shape: name: z.type(...).mod(...).
+func parseShape(src string) ([]Arg, error) {
+	var args []Arg
+	i := 0
+	skip := func() {
+		for i < len(src) && strings.ContainsRune(" \t\r\n,", rune(src[i])) {
+			i++
+		}
+	}
+	ident := func() string {
+		start := i
+		for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+			i++
+		}
+		return src[start:i]
+	}
+	for skip(); i < len(src); skip() {
+		a := Arg{Name: ident()}
+		for i < len(src) && src[i] == ' ' {
+			i++
+		}
+		if a.Name == "" || i >= len(src) || src[i] != ':' {
+			return nil, fmt.Errorf("unexpected %.30q in zod shape", src[i:])
+		}
+		i++
+		skip()
+		if ident() != "z" {
+			return nil, fmt.Errorf("%s: not a zod schema", a.Name)
+		}
+		for {
+			for i < len(src) && strings.ContainsRune(" \t\r\n", rune(src[i])) {
+				i++
+			}
+			if i >= len(src) || src[i] != '.' {
+				break
+			}
+			i++
+			method := ident()
+			if i >= len(src) || src[i] != '(' {
+				return nil, fmt.Errorf("%s: .%s is not a call", a.Name, method)
+			}
+			n, err := balanced(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", a.Name, err)
+			}
+			arg := strings.TrimSpace(src[i+1 : n-1])
+			i = n
+			if err := a.apply(method, arg); err != nil {
+				return nil, fmt.Errorf("%s: %v", a.Name, err)
+			}
+		}
+		if a.Type == "" {
+			return nil, fmt.Errorf("%s: no zod type", a.Name)
+		}
+		args = append(args, a)
+	}
+	return args, nil
+}
+
+func (a *Arg) apply(method, arg string) error {
+	switch method {
+	case "string", "number", "boolean":
+		a.Type = method
+	case "enum":
+		a.Type = "string"
+		if err := json.Unmarshal([]byte(arg), &a.Enum); err != nil || len(a.Enum) == 0 {
+			return fmt.Errorf("z.enum(%s) is not a literal list of strings", arg)
+		}
+	case "optional":
+		a.Optional = true
+	case "default":
+		if !json.Valid([]byte(arg)) {
+			return fmt.Errorf(".default(%s) is not a literal", arg)
+		}
+		a.Default = json.RawMessage(arg)
+	case "describe":
+		if err := json.Unmarshal([]byte(arg), &a.Description); err != nil {
+			return fmt.Errorf(".describe(%.30s) is not a string literal", arg)
+		}
+	default:
+		return fmt.Errorf("unsupported zod method .%s", method)
+	}
+	return nil
+}
+
+// argEnd returns the offset of the comma ending the call argument that
+// starts at src[start]. String literals and bracket groups are skipped, so
+// a description concatenated from literals holding commas, or taken from an
+// identifier or a call, is passed over whole.
+func argEnd(src string, start int) (int, error) {
+	for i := start; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"', '\'', '`':
+			i = stringEnd(src, i)
+		case '(', '[', '{':
+			n, err := balanced(src, i)
+			if err != nil {
+				return 0, err
+			}
+			i = n - 1
+		case ')', ']', '}':
+			return 0, fmt.Errorf("unexpected %q at offset %d", c, i)
+		case ',':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated argument at offset %d", start)
+}
+
+// stringEnd returns the offset of the quote closing the string literal
+// opening at src[start], or len(src) when it is unterminated.
+func stringEnd(src string, start int) int {
+	i := start + 1
+	for ; i < len(src) && src[i] != src[start]; i++ {
+		if src[i] == '\\' {
+			i++
+		}
+	}
+	return i
+}
+
+// balanced returns the offset just past the bracket group opening at
+// src[start], skipping string literals.
+func balanced(src string, start int) (int, error) {
+	var stack []byte
+	closing := map[byte]byte{'(': ')', '[': ']', '{': '}'}
+	for i := start; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"', '\'', '`':
+			i = stringEnd(src, i)
+		case '(', '[', '{':
+			stack = append(stack, closing[c])
+		case ')', ']', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return 0, fmt.Errorf("unbalanced %q at offset %d", c, i)
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated group at offset %d", start)
+}
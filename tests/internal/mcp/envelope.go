@@ -0,0 +1,196 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Separator precedes the structured block appended to a tool's text
+// (ENVELOPE_SEPARATOR in lib/envelope.js).
+const Separator = "\n\n--- structured ---\n"
+
+// Latest is the snapshot name resolved_snapshot must never carry.
+const Latest = "latest"
+
+// Schemes are the Resource_URI schemes of lib/uri.js.
+var Schemes = []string{"rag", "rtl", "graph", "claim", "job", "index"}
+
+// The closed error_code set of lib/errors.js.
+const (
+	ErrInvalidURI = "invalid_uri"
+	ErrNotFound   = "not_found"
+	ErrUpstream   = "upstream_error"
+)
+
+// ErrorCodes are the error codes a tool error may carry.
+var ErrorCodes = []string{ErrInvalidURI, ErrNotFound, ErrUpstream}
+
+// WellFormedURI reports whether s is "<scheme>://<id>" with a known scheme
+// and a non-empty id without whitespace, as lib/uri.isWellFormed does.
+func WellFormedURI(s string) bool {
+	scheme, id, ok := strings.Cut(s, "://")
+	if !ok || id == "" || strings.IndexFunc(id, unicode.IsSpace) >= 0 {
+		return false
+	}
+	for _, known := range Schemes {
+		if scheme == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Structured is a tool text split at its structured block.
+type Structured struct {
+	Prefix string
+	Keys   []string
+	Fields map[string]json.RawMessage
+}
+
+// ParseStructured splits text at the last Separator and decodes the JSON
+// object after it, keeping the key order.
+func ParseStructured(text string) (*Structured, error) {
+	i := strings.LastIndex(text, Separator)
+	if i < 0 {
+		return nil, fmt.Errorf("no structured block")
+	}
+	s := &Structured{Prefix: text[:i], Fields: map[string]json.RawMessage{}}
+	block := text[i+len(Separator):]
+	dec := json.NewDecoder(strings.NewReader(block))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("structured block is not a JSON object: %.100s", block)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("structured block: %v", err)
+		}
+		key := tok.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("structured block: %s: %v", key, err)
+		}
+		if _, dup := s.Fields[key]; dup {
+			return nil, fmt.Errorf("structured block: duplicate key %s", key)
+		}
+		s.Keys = append(s.Keys, key)
+		s.Fields[key] = v
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("structured block: %v", err)
+	}
+	if rest := strings.TrimSpace(block[dec.InputOffset():]); rest != "" {
+		return nil, fmt.Errorf("text after the structured block: %.100s", rest)
+	}
+	return s, nil
+}
+
+// String decodes a string field, reporting whether it is a string.
+func (s *Structured) String(key string) (string, bool) {
+	var v string
+	err := json.Unmarshal(s.Fields[key], &v)
+	return v, err == nil
+}
+
+// Envelope is the structured block of a successful tool result.
+type Envelope struct {
+	IndexVersion     string   `json:"index_version"`
+	ResolvedSnapshot string   `json:"resolved_snapshot"`
+	ResourceURIs     []string `json:"resource_uris,omitempty"`
+	RequestID        string   `json:"request_id"`
+}
+
+// ParseEnvelope checks the envelope appendEnvelope produces: keys in the
+// order index_version, resolved_snapshot, resource_uris (only when there is
+// one), request_id; a non-empty index_version; a concrete snapshot; and
+// well-formed, unique resource URIs. It returns the envelope and the text
+// before it.
+func ParseEnvelope(text string) (*Envelope, string, error) {
+	s, err := ParseStructured(text)
+	if err != nil {
+		return nil, "", err
+	}
+	want := []string{"index_version", "resolved_snapshot", "request_id"}
+	if _, ok := s.Fields["resource_uris"]; ok {
+		want = []string{"index_version", "resolved_snapshot", "resource_uris", "request_id"}
+	}
+	if strings.Join(s.Keys, ",") != strings.Join(want, ",") {
+		return nil, "", fmt.Errorf("envelope keys are %v, want %v", s.Keys, want)
+	}
+	var e Envelope
+	for _, f := range []struct {
+		key string
+		dst *string
+	}{{"index_version", &e.IndexVersion}, {"resolved_snapshot", &e.ResolvedSnapshot}, {"request_id", &e.RequestID}} {
+		v, ok := s.String(f.key)
+		if !ok || strings.TrimSpace(v) == "" {
+			return nil, "", fmt.Errorf("envelope %s is %s, want a non-empty string", f.key, s.Fields[f.key])
+		}
+		*f.dst = v
+	}
+	if e.ResolvedSnapshot == Latest {
+		return nil, "", fmt.Errorf("envelope resolved_snapshot is %q, want a concrete snapshot", Latest)
+	}
+	if raw, ok := s.Fields["resource_uris"]; ok {
+		if err := json.Unmarshal(raw, &e.ResourceURIs); err != nil || len(e.ResourceURIs) == 0 {
+			return nil, "", fmt.Errorf("envelope resource_uris is %s, want a non-empty array of strings", raw)
+		}
+		seen := map[string]bool{}
+		for _, u := range e.ResourceURIs {
+			if !WellFormedURI(u) {
+				return nil, "", fmt.Errorf("envelope resource_uris: %q is not a well-formed Resource_URI", u)
+			}
+			if seen[u] {
+				return nil, "", fmt.Errorf("envelope resource_uris: %q is listed twice", u)
+			}
+			seen[u] = true
+		}
+	}
+	return &e, s.Prefix, nil
+}
+
+// ErrorSchema is the payload of a tool error (lib/errors.renderError).
+type ErrorSchema struct {
+	ErrorCode string `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// ParseToolError checks a tool error: isError set and a single text item
+// holding exactly {error_code, message}, with a code from the closed set, a
+// non-empty message, and none of the envelope fields.
+func ParseToolError(r *CallToolResult) (*ErrorSchema, error) {
+	if !r.IsError {
+		return nil, fmt.Errorf("result does not set isError")
+	}
+	if len(r.Content) != 1 || r.Content[0].Type != "text" {
+		return nil, fmt.Errorf("error result has %d content items, want one text item", len(r.Content))
+	}
+	text := r.Content[0].Text
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, fmt.Errorf("error text is not the error schema: %.100s", text)
+	}
+	if len(raw) != 2 || raw["error_code"] == nil || raw["message"] == nil {
+		return nil, fmt.Errorf("error schema has keys %v, want exactly error_code and message", sortedKeys(raw))
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(text)))
+	dec.DisallowUnknownFields()
+	var e ErrorSchema
+	if err := dec.Decode(&e); err != nil {
+		return nil, fmt.Errorf("error schema: %v", err)
+	}
+	known := false
+	for _, c := range ErrorCodes {
+		known = known || e.ErrorCode == c
+	}
+	if !known {
+		return nil, fmt.Errorf("error_code %q is not one of %v", e.ErrorCode, ErrorCodes)
+	}
+	if strings.TrimSpace(e.Message) == "" {
+		return nil, fmt.Errorf("error schema with an empty message")
+	}
+	return &e, nil
+}
@@ -0,0 +1,287 @@
+// Package mcp is a conformance kit for the Model Context Protocol servers the
+// platform runs: a JSON-RPC client for the Streamable HTTP transport with
+// recording and replay, validators for tool input schemas and for the result
+// envelope and error schema of mcp-bridge/lib, a reader for the tool
+// registrations in mcp-bridge/server.js, and StandIn, an in-process server
+// built from them.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ProtocolVersion is the protocol revision the client asks for. It is the
+// first revision with the Streamable HTTP transport.
+const ProtocolVersion = "2025-03-26"
+
+// ProtocolVersions are the revisions a server may negotiate.
+var ProtocolVersions = []string{"2024-11-05", "2025-03-26", "2025-06-18"}
+
+// Request is a JSON-RPC request, or a notification when ID is nil.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  any             `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error member of a JSON-RPC response.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// ParseResponse decodes a JSON-RPC response and checks its shape: version
+// 2.0, an id, and exactly one of result and error.
+func ParseResponse(data []byte) (*Response, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("response is not a JSON object: %v", err)
+	}
+	var r Response
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	_, hasResult := raw["result"]
+	switch {
+	case r.JSONRPC != "2.0":
+		return nil, fmt.Errorf("jsonrpc is %q, want 2.0", r.JSONRPC)
+	case raw["id"] == nil:
+		return nil, fmt.Errorf("response without an id")
+	case hasResult == (r.Error != nil):
+		return nil, fmt.Errorf("response must have exactly one of result and error")
+	case r.Error != nil && r.Error.Message == "":
+		return nil, fmt.Errorf("error %d without a message", r.Error.Code)
+	}
+	return &r, nil
+}
+
+// Implementation names a client or server.
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// InitializeResult is the server's answer to initialize.
+type InitializeResult struct {
+	ProtocolVersion string `json:"protocolVersion"`
+	Capabilities    struct {
+		Tools *struct {
+			ListChanged bool `json:"listChanged"`
+		} `json:"tools"`
+		Resources json.RawMessage `json:"resources,omitempty"`
+		Prompts   json.RawMessage `json:"prompts,omitempty"`
+		Logging   json.RawMessage `json:"logging,omitempty"`
+	} `json:"capabilities"`
+	ServerInfo   Implementation `json:"serverInfo"`
+	Instructions string         `json:"instructions,omitempty"`
+}
+
+// Tool is a tools/list entry.
+type Tool struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	InputSchema *Schema `json:"inputSchema"`
+}
+
+// Content is an item of a tool result.
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// CallToolResult is the result of tools/call.
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Text returns the concatenated text content.
+func (r *CallToolResult) Text() string {
+	var s string
+	for _, c := range r.Content {
+		s += c.Text
+	}
+	return s
+}
+
+// Client is an MCP client over a Transport.
+type Client struct {
+	Transport Transport
+	Info      Implementation
+
+	nextID atomic.Int64
+}
+
+// NewClient returns a client identifying itself as the conformance kit.
+func NewClient(t Transport) *Client {
+	return &Client{Transport: t, Info: Implementation{Name: "bos-ai-conformance", Version: "1.0.0"}}
+}
+
+// Call sends a request and decodes its result into out (which may be nil).
+// A JSON-RPC error is returned as *RPCError.
+func (c *Client) Call(ctx context.Context, method string, params, out any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	body, err := json.Marshal(Request{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	reply, err := c.Transport.RoundTrip(ctx, body)
+	if err != nil {
+		return err
+	}
+	resp, err := reply.Response(id)
+	if err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("%s: decoding result: %v", method, err)
+	}
+	return nil
+}
+
+// Notify sends a notification, which the server acknowledges without a
+// response.
+func (c *Client) Notify(ctx context.Context, method string, params any) error {
+	body, err := json.Marshal(Request{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	reply, err := c.Transport.RoundTrip(ctx, body)
+	if err != nil {
+		return err
+	}
+	if reply.Status/100 != 2 || len(reply.Messages) != 0 {
+		return fmt.Errorf("%s: notification answered with HTTP %d and %d messages", method, reply.Status, len(reply.Messages))
+	}
+	return nil
+}
+
+// Raw sends body as is, for requests the client would never build.
+func (c *Client) Raw(ctx context.Context, body string) (*Reply, error) {
+	return c.Transport.RoundTrip(ctx, []byte(body))
+}
+
+// Initialize runs the handshake: initialize, a check of the negotiated
+// version and capabilities, then notifications/initialized.
+func (c *Client) Initialize(ctx context.Context) (*InitializeResult, error) {
+	var res InitializeResult
+	err := c.Call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      c.Info,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if !supported(res.ProtocolVersion) {
+		return nil, fmt.Errorf("server negotiated protocol version %q, client supports %v", res.ProtocolVersion, ProtocolVersions)
+	}
+	if res.ServerInfo.Name == "" || res.ServerInfo.Version == "" {
+		return nil, fmt.Errorf("serverInfo needs a name and a version, got %+v", res.ServerInfo)
+	}
+	if err := c.Notify(ctx, "notifications/initialized", nil); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func supported(version string) bool {
+	for _, v := range ProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ListTools returns every tool, following nextCursor.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	var cursor string
+	for page := 0; ; page++ {
+		if page == 100 {
+			return nil, fmt.Errorf("tools/list: more than %d pages", page)
+		}
+		var params any
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+		var res struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.Call(ctx, "tools/list", params, &res); err != nil {
+			return nil, err
+		}
+		tools = append(tools, res.Tools...)
+		if cursor = res.NextCursor; cursor == "" {
+			return tools, nil
+		}
+	}
+}
+
+// CallTool calls a tool and checks the result shape: at least one content
+// item, each of a known type carrying its payload.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]any) (*CallToolResult, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+	var res CallToolResult
+	if err := c.Call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Content) == 0 {
+		return nil, fmt.Errorf("tools/call %s: result without content", name)
+	}
+	for i, item := range res.Content {
+		switch item.Type {
+		case "text":
+			if item.Text == "" {
+				return nil, fmt.Errorf("tools/call %s: content[%d] is empty text", name, i)
+			}
+		case "image", "audio":
+			if item.Data == "" || item.MimeType == "" {
+				return nil, fmt.Errorf("tools/call %s: content[%d] needs data and mimeType", name, i)
+			}
+		case "resource", "resource_link":
+		default:
+			return nil, fmt.Errorf("tools/call %s: content[%d] has type %q", name, i, item.Type)
+		}
+	}
+	return &res, nil
+}
@@ -0,0 +1,328 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleServerJS = `
+function createMcpServer() {
+  const mcp = new McpServer({ name: "sample", version: "1.0.0" });
+
+  mcp.tool(
+    "search",
+    "[목적] 키워드, 심볼 검색 (예: GICD_CHIPR, local_chip_addr). " +
+      "[예시] query=\"a, b\" -> 'c, d'",
+    {
+      query: z.string().describe("검색어 (\"quoted\")"),
+      scope: z.enum(["chip", "module"]).describe("범위"),
+      max_results: z.number().optional().default(5).describe("최대 결과 수")
+    },
+    withTool("search", async (args, extra) => {
+      const resp = await ragApi("POST", "/search", args);
+      if (resp.error) return { content: [{ type: "text", text: "오류: " + resp.error }], isError: true };
+      return withEnvelope(text, resp, [], extra);
+    })
+  );
+
+  mcp.tool(
+    "rag_read_resource",
+    TOOL_DESCRIPTIONS.rag_read_resource,
+    {
+      resource_uri: z
+        .string()
+        .describe("Resource_URI"),
+    },
+    withTool("rag_read_resource", async (args, extra) => {
+      // Unlike search, this never answers withEnvelope(...) or { isError: true }.
+      if (!uri.isWellFormed(args.resource_uri)) {
+        return errors.renderError(errors.makeError(errors.ERROR_CODES.INVALID_URI, "malformed"));
+      }
+      text += "\n\n--- structured ---\n" + JSON.stringify(structured);
+      return { content: [{ type: "text", text }] };
+    })
+  );
+
+  return mcp;
+}
+
+app.get("/x", (req, res) => res.json({ isError: true }));
+`
+
+func TestParseServerJS(t *testing.T) {
+	t.Parallel()
+
+	info, err := ParseServerInfo(sampleServerJS)
+	require.NoError(t, err)
+	assert.Equal(t, Implementation{Name: "sample", Version: "1.0.0"}, info)
+
+	specs, err := ParseServerJS(sampleServerJS)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+
+	search := specs[0]
+	assert.Equal(t, "search", search.Name)
+	assert.True(t, search.Envelope)
+	assert.Equal(t, 1, search.LegacyErrors)
+	require.Len(t, search.Args, 3)
+	assert.Equal(t, `검색어 ("quoted")`, search.Args[0].Description)
+	assert.Equal(t, []string{"chip", "module"}, search.Args[1].Enum)
+
+	s := search.InputSchema()
+	require.NoError(t, CheckInputSchema(s))
+	assert.Equal(t, []string{"query", "scope"}, s.Required, "optional and defaulted args are not required")
+	assert.Equal(t, "5", string(s.Properties["max_results"].Default))
+	assert.Equal(t, map[string]any{"query": "conformance", "scope": "chip"}, s.Example())
+
+	read := specs[1]
+	assert.False(t, read.Envelope)
+	assert.True(t, read.Structured)
+	assert.Zero(t, read.LegacyErrors, "comments and code after the mcp.tool call are not scanned")
+	assert.Equal(t, []string{ErrInvalidURI}, read.ErrorCodes)
+	assert.Equal(t, []string{"resource_uri"}, read.InputSchema().Required)
+
+	_, err = ParseServerJS(`mcp.tool("x", D.x, { n: z.number().int() }, h)`)
+	assert.ErrorContains(t, err, "unsupported zod method .int")
+	_, err = ParseServerJS(`mcp.tool("x", D.x, shape, h)`)
+	assert.ErrorContains(t, err, "not a zod shape literal")
+	_, err = ParseServerJS(`mcp.tool("x", describe("a, b"), { n: z.number() }, h)`)
+	assert.NoError(t, err, "calls are skipped whole")
+	_, err = ParseServerJS(`mcp.tool("x", "a, b")`)
+	assert.ErrorContains(t, err, "x: description: unterminated argument")
+	_, err = ParseServerInfo(`new McpServer(options)`)
+	assert.ErrorContains(t, err, "no new McpServer")
+}
+
+func TestCheckInputSchema_RejectsMalformedSchemas(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		schema string
+		err    string
+	}{
+		{`{"type":"object","properties":{"q":{"type":"string"}},"required":["q"]}`, ""},
+		{`{"type":"object"}`, ""},
+		{`{"type":"array","items":{"type":"string"}}`, `want "object"`},
+		{`{"type":"object","properties":{"q":{"type":"text"}}}`, `unknown type "text"`},
+		{`{"type":"object","properties":{"q":{"description":"no type"}}}`, "inputSchema.q: no type"},
+		{`{"type":"object","properties":{},"required":["q"]}`, "required property q is not declared"},
+		{`{"type":"object","properties":{"q":{"type":"string"}},"required":["q","q"]}`, "q is required twice"},
+		{`{"type":"object","properties":{"n":{"type":"number","default":"5"}}}`, "default: inputSchema.n: got string, want number"},
+		{`{"type":"object","properties":{"s":{"type":"string","enum":["a",1]}}}`, "enum value 1"},
+		{`{"type":"object","properties":{"n":{"type":"integer","minimum":5,"maximum":1}}}`, "minimum 5 exceeds maximum 1"},
+	}
+	for _, tc := range testCases {
+		var s Schema
+		require.NoError(t, json.Unmarshal([]byte(tc.schema), &s))
+		err := CheckInputSchema(&s)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.schema)
+		} else {
+			assert.ErrorContains(t, err, tc.err, tc.schema)
+		}
+	}
+
+	var s Schema
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"object","additionalProperties":false,"required":["scope"],
+		"properties":{"scope":{"type":"string","enum":["chip","module"]},"depth":{"type":"integer","minimum":1}}}`), &s))
+	assert.NoError(t, s.Validate(map[string]any{"scope": "chip", "depth": float64(2)}))
+	assert.ErrorContains(t, s.Validate(map[string]any{}), "missing required property scope")
+	assert.ErrorContains(t, s.Validate(map[string]any{"scope": "soc"}), "not one of")
+	assert.ErrorContains(t, s.Validate(map[string]any{"scope": "chip", "depth": 1.5}), "got number, want integer")
+	assert.ErrorContains(t, s.Validate(map[string]any{"scope": "chip", "depth": float64(0)}), "below 1")
+	assert.ErrorContains(t, s.Validate(map[string]any{"scope": "chip", "extra": true}), "unknown property extra")
+}
+
+func TestParseEnvelope(t *testing.T) {
+	t.Parallel()
+
+	text := Envelope{IndexVersion: "v3", ResolvedSnapshot: "snap-7", ResourceURIs: []string{"rtl://module/tt_noc#L1-L9"}, RequestID: "r1"}.Append("answer")
+	e, prefix, err := ParseEnvelope(text)
+	require.NoError(t, err)
+	assert.Equal(t, "answer", prefix)
+	assert.Equal(t, []string{"rtl://module/tt_noc#L1-L9"}, e.ResourceURIs)
+
+	_, _, err = ParseEnvelope("answer" + Separator + `{"index_version":"v3","resolved_snapshot":"s","request_id":"r"}`)
+	assert.NoError(t, err, "resource_uris is omitted when nothing is addressable")
+
+	testCases := []struct {
+		block string
+		err   string
+	}{
+		{`{"resolved_snapshot":"s","index_version":"v","request_id":"r"}`, "envelope keys are [resolved_snapshot index_version request_id]"},
+		{`{"index_version":"","resolved_snapshot":"s","request_id":"r"}`, "index_version is"},
+		{`{"index_version":"v","resolved_snapshot":"latest","request_id":"r"}`, "want a concrete snapshot"},
+		{`{"index_version":"v","resolved_snapshot":"s","resource_uris":[],"request_id":"r"}`, "want a non-empty array"},
+		{`{"index_version":"v","resolved_snapshot":"s","resource_uris":["s3://b/k"],"request_id":"r"}`, "not a well-formed Resource_URI"},
+		{`{"index_version":"v","resolved_snapshot":"s","resource_uris":["rag://a","rag://a"],"request_id":"r"}`, "listed twice"},
+		{`{"index_version":"v","resolved_snapshot":"s","request_id":null}`, "request_id is null"},
+		{`{"index_version":"v","resolved_snapshot":"s","request_id":"r"} trailing`, "text after the structured block"},
+	}
+	for _, tc := range testCases {
+		_, _, err := ParseEnvelope("answer" + Separator + tc.block)
+		assert.ErrorContains(t, err, tc.err, tc.block)
+	}
+	_, _, err = ParseEnvelope("answer without a block")
+	assert.ErrorContains(t, err, "no structured block")
+
+	assert.True(t, WellFormedURI("job://1f0c"))
+	assert.False(t, WellFormedURI("rag://has space"))
+	assert.False(t, WellFormedURI("RAG://upper"))
+	assert.False(t, WellFormedURI("rag://"))
+}
+
+func TestParseToolError(t *testing.T) {
+	t.Parallel()
+
+	e, err := ParseToolError(ErrorResult(ErrNotFound, "없음"))
+	require.NoError(t, err)
+	assert.Equal(t, ErrNotFound, e.ErrorCode)
+
+	legacy := &CallToolResult{Content: []Content{{Type: "text", Text: "오류: timeout"}}, IsError: true}
+	testCases := []struct {
+		result *CallToolResult
+		err    string
+	}{
+		{&CallToolResult{Content: []Content{{Type: "text", Text: `{"error_code":"not_found","message":"m"}`}}}, "does not set isError"},
+		{legacy, "not the error schema"},
+		{errorText(`{"error_code":"timeout","message":"m"}`), `"timeout" is not one of`},
+		{errorText(`{"error_code":"not_found","message":"  "}`), "empty message"},
+		{errorText(`{"error_code":"not_found","message":"m","request_id":"r"}`), "want exactly error_code and message"},
+		{errorText(`{"error_code":"not_found","resolved_snapshot":"s"}`), "want exactly error_code and message"},
+	}
+	for _, tc := range testCases {
+		_, err := ParseToolError(tc.result)
+		assert.ErrorContains(t, err, tc.err, tc.result.Text())
+	}
+}
+
+func errorText(text string) *CallToolResult {
+	return &CallToolResult{Content: []Content{{Type: "text", Text: text}}, IsError: true}
+}
+
+func newStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	info, err := ParseServerInfo(sampleServerJS)
+	require.NoError(t, err)
+	specs, err := ParseServerJS(sampleServerJS)
+	require.NoError(t, err)
+	srv := httptest.NewServer(NewBridge(info, specs))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStandIn_SpeaksStreamableHTTP(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := newStandIn(t)
+	tr := &HTTPTransport{URL: srv.URL + "/mcp"}
+	c := NewClient(tr)
+
+	// Requests before the handshake have no session.
+	var rpcErr *RPCError
+	_, err := c.ListTools(ctx)
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, -32000, rpcErr.Code)
+
+	info, err := c.Initialize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolVersion, info.ProtocolVersion)
+	assert.Equal(t, "sample", info.ServerInfo.Name)
+	assert.NotNil(t, info.Capabilities.Tools)
+	assert.NotEmpty(t, tr.Session())
+
+	tools, err := c.ListTools(ctx)
+	require.NoError(t, err)
+	require.Len(t, tools, 2)
+	require.NoError(t, CheckInputSchema(tools[0].InputSchema))
+
+	res, err := c.CallTool(ctx, "search", map[string]any{"query": "noc", "scope": "chip"})
+	require.NoError(t, err)
+	e, _, err := ParseEnvelope(res.Text())
+	require.NoError(t, err)
+	assert.NotEmpty(t, e.RequestID)
+
+	res, err = c.CallTool(ctx, "rag_read_resource", map[string]any{"resource_uri": "not a uri"})
+	require.NoError(t, err)
+	te, err := ParseToolError(res)
+	require.NoError(t, err)
+	assert.Equal(t, ErrInvalidURI, te.ErrorCode)
+
+	for _, tc := range []struct {
+		method string
+		params any
+		code   int
+	}{
+		{"resources/subscribe", nil, CodeMethodNotFound},
+		{"tools/call", map[string]any{"name": "nope"}, CodeInvalidParams},
+		{"tools/call", map[string]any{"name": "search", "arguments": map[string]any{"query": "x"}}, CodeInvalidParams},
+		{"tools/call", map[string]any{"name": "search", "arguments": map[string]any{"query": 1, "scope": "chip"}}, CodeInvalidParams},
+	} {
+		err := c.Call(ctx, tc.method, tc.params, nil)
+		if assert.ErrorAs(t, err, &rpcErr, tc.method) {
+			assert.Equal(t, tc.code, rpcErr.Code, "%s %v", tc.method, tc.params)
+		}
+	}
+
+	reply, err := c.Raw(ctx, `{"jsonrpc":"2.0","id":1,"method":`)
+	require.NoError(t, err)
+	assert.Equal(t, 400, reply.Status)
+	assert.Equal(t, CodeParseError, reply.Error().Code)
+	reply, err = c.Raw(ctx, `{"jsonrpc":"1.0","id":1,"method":"ping"}`)
+	require.NoError(t, err)
+	assert.Equal(t, CodeInvalidRequest, reply.Error().Code)
+
+	require.NoError(t, tr.Close(ctx))
+	err = c.Call(ctx, "ping", nil, nil)
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, -32001, rpcErr.Code, "a closed session is not found")
+}
+
+func TestRecording_ReplaysWithoutServer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := newStandIn(t)
+	rec := &Recording{}
+	live := NewClient(&Recorder{Transport: &HTTPTransport{URL: srv.URL + "/mcp"}, Log: rec})
+	_, err := live.ListTools(ctx)
+	require.Error(t, err)
+	_, err = live.Initialize(ctx)
+	require.NoError(t, err)
+	_, err = live.CallTool(ctx, "search", map[string]any{"query": "noc", "scope": "chip"})
+	require.NoError(t, err)
+	_, err = live.CallTool(ctx, "search", map[string]any{"query": "fpu", "scope": "chip"})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	require.NoError(t, rec.Save(path))
+	srv.Close()
+	loaded, err := LoadRecording(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Exchanges, 5)
+
+	// A fresh client numbers its requests differently; replies follow it.
+	c := NewClient(NewReplay(loaded))
+	c.nextID.Store(100)
+	var rpcErr *RPCError
+	_, err = c.ListTools(ctx)
+	require.ErrorAs(t, err, &rpcErr, "session-less requests replay the session-less answer")
+	_, err = c.Initialize(ctx)
+	require.NoError(t, err)
+	res, err := c.CallTool(ctx, "search", map[string]any{"scope": "chip", "query": "fpu"})
+	require.NoError(t, err)
+	_, _, err = ParseEnvelope(res.Text())
+	assert.NoError(t, err)
+
+	_, err = c.CallTool(ctx, "search", map[string]any{"query": "edc", "scope": "chip"})
+	assert.ErrorContains(t, err, "replay: no recorded exchange")
+	assert.False(t, errors.As(err, &rpcErr))
+}
@@ -0,0 +1,283 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema that tool input schemas use.
+type Schema struct {
+	SchemaURI            string             `json:"$schema,omitempty"`
+	Type                 json.RawMessage    `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Default              json.RawMessage    `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+var schemaTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Types returns the declared types; "type" may be a string or an array.
+func (s *Schema) Types() []string {
+	if len(s.Type) == 0 {
+		return nil
+	}
+	var one string
+	if json.Unmarshal(s.Type, &one) == nil {
+		return []string{one}
+	}
+	var many []string
+	_ = json.Unmarshal(s.Type, &many)
+	return many
+}
+
+// closed reports whether additionalProperties is false.
+func (s *Schema) closed() bool {
+	return strings.TrimSpace(string(s.AdditionalProperties)) == "false"
+}
+
+// CheckInputSchema checks a tool input schema: an object schema whose
+// properties are well-formed, whose required names are declared properties,
+// and whose enums and defaults are values of their property.
+func CheckInputSchema(s *Schema) error {
+	if s == nil {
+		return fmt.Errorf("missing inputSchema")
+	}
+	if t := s.Types(); len(t) != 1 || t[0] != "object" {
+		return fmt.Errorf("inputSchema type is %s, want \"object\"", s.Type)
+	}
+	return s.check("inputSchema")
+}
+
+func (s *Schema) check(path string) error {
+	types := s.Types()
+	if len(types) == 0 && len(s.Enum) == 0 {
+		return fmt.Errorf("%s: no type", path)
+	}
+	for _, t := range types {
+		if !schemaTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+	if ap := strings.TrimSpace(string(s.AdditionalProperties)); ap != "" && ap != "true" && ap != "false" && !strings.HasPrefix(ap, "{") {
+		return fmt.Errorf("%s: additionalProperties is %s", path, ap)
+	}
+	seen := map[string]bool{}
+	for _, r := range s.Required {
+		if seen[r] {
+			return fmt.Errorf("%s: %s is required twice", path, r)
+		}
+		seen[r] = true
+		if s.Properties[r] == nil {
+			return fmt.Errorf("%s: required property %s is not declared", path, r)
+		}
+	}
+	for _, name := range sortedKeys(s.Properties) {
+		p := s.Properties[name]
+		if p == nil {
+			return fmt.Errorf("%s.%s: not a schema", path, name)
+		}
+		if err := p.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.check(path + "[]"); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.Enum {
+		if err := s.validateType(e); err != nil {
+			return fmt.Errorf("%s: enum value %v: %v", path, e, err)
+		}
+	}
+	if len(s.Default) > 0 {
+		var d any
+		if err := json.Unmarshal(s.Default, &d); err != nil {
+			return fmt.Errorf("%s: default: %v", path, err)
+		}
+		if err := s.validate(path, d); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("%s: minimum %v exceeds maximum %v", path, *s.Minimum, *s.Maximum)
+	}
+	if s.MinLength != nil && s.MaxLength != nil && *s.MinLength > *s.MaxLength {
+		return fmt.Errorf("%s: minLength %d exceeds maxLength %d", path, *s.MinLength, *s.MaxLength)
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value against the schema.
+func (s *Schema) Validate(v any) error {
+	return s.validate("arguments", v)
+}
+
+func (s *Schema) validate(path string, v any) error {
+	if err := s.validateType(v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, s.Enum)
+		}
+	}
+	switch x := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d characters", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && x < *s.Minimum {
+			return fmt.Errorf("%s: %v is below %v", path, x, *s.Minimum)
+		}
+		if s.Maximum != nil && x > *s.Maximum {
+			return fmt.Errorf("%s: %v is above %v", path, x, *s.Maximum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range x {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, r := range s.Required {
+			if _, ok := x[r]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, r)
+			}
+		}
+		for _, name := range sortedKeys(x) {
+			p := s.Properties[name]
+			if p == nil {
+				if s.closed() {
+					return fmt.Errorf("%s: unknown property %s", path, name)
+				}
+				continue
+			}
+			if err := p.validate(path+"."+name, x[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateType(v any) error {
+	types := s.Types()
+	if len(types) == 0 {
+		return nil
+	}
+	got := jsonType(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("got %s, want %s", got, strings.Join(types, " or "))
+}
+
+func jsonType(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Example returns a value satisfying the schema, filling in required
+// properties only. It prefers the first enum value and the default.
+func (s *Schema) Example() any {
+	if len(s.Enum) > 0 {
+		return s.Enum[0]
+	}
+	if len(s.Default) > 0 {
+		var d any
+		if json.Unmarshal(s.Default, &d) == nil {
+			return d
+		}
+	}
+	types := s.Types()
+	if len(types) == 0 {
+		return nil
+	}
+	switch types[0] {
+	case "string":
+		v := "conformance"
+		if s.MaxLength != nil && utf8.RuneCountInString(v) > *s.MaxLength {
+			v = strings.Repeat("x", *s.MaxLength)
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(v) < *s.MinLength {
+			v = strings.Repeat("x", *s.MinLength)
+		}
+		return v
+	case "number", "integer":
+		if s.Minimum != nil {
+			return math.Ceil(*s.Minimum)
+		}
+		return float64(1)
+	case "boolean":
+		return true
+	case "array":
+		if s.Items == nil {
+			return []any{}
+		}
+		return []any{s.Items.Example()}
+	case "object":
+		out := map[string]any{}
+		for _, r := range s.Required {
+			if p := s.Properties[r]; p != nil {
+				out[r] = p.Example()
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
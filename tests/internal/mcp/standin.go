@@ -0,0 +1,283 @@
+package mcp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CallFunc answers a tools/call whose arguments satisfy the tool's input
+// schema, with defaults applied.
+type CallFunc func(name string, args map[string]any, requestID string) *CallToolResult
+
+// StandIn is an in-process MCP server speaking the Streamable HTTP transport
+// the bridge serves on /mcp: initialize opens a session returned in
+// Mcp-Session-Id, later requests must carry it, notifications get 202, and
+// responses are sent as an event stream.
+type StandIn struct {
+	Info  Implementation
+	Tools []Tool
+	Call  CallFunc
+
+	mu       sync.Mutex
+	sessions map[string]bool
+	seq      atomic.Int64
+}
+
+func (s *StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		writeRPC(w, http.StatusOK, map[string]string{"status": "ok"})
+	case r.URL.Path == "/mcp" && r.Method == http.MethodPost:
+		s.post(w, r)
+	case r.URL.Path == "/mcp" && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.sessions, r.Header.Get(SessionHeader))
+		s.mu.Unlock()
+		writeRPC(w, http.StatusOK, map[string]string{"status": "closed"})
+	case r.URL.Path == "/mcp":
+		writeRPC(w, http.StatusMethodNotAllowed, errorResponse(nil, -32000, "Method not allowed."))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *StandIn) post(w http.ResponseWriter, r *http.Request) {
+	accept := r.Header.Get("Accept")
+	if !strings.Contains(accept, "application/json") || !strings.Contains(accept, "text/event-stream") {
+		writeRPC(w, http.StatusNotAcceptable, errorResponse(nil, -32000, "Not Acceptable: Client must accept both application/json and text/event-stream"))
+		return
+	}
+	if ctype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ctype != "application/json" {
+		writeRPC(w, http.StatusUnsupportedMediaType, errorResponse(nil, -32000, "Unsupported Media Type: Content-Type must be application/json"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	msgs := splitMessages(body)
+	if msgs == nil {
+		writeRPC(w, http.StatusBadRequest, errorResponse(nil, CodeParseError, "Parse error"))
+		return
+	}
+
+	var reqs []Request
+	initialize := false
+	for _, m := range msgs {
+		var req Request
+		var shape struct {
+			Method json.RawMessage `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		_ = json.Unmarshal(m, &shape)
+		if shape.Method == nil && (shape.Result != nil || shape.Error != nil) {
+			continue // a client response to a server request
+		}
+		if err := json.Unmarshal(m, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+			writeRPC(w, http.StatusBadRequest, errorResponse(req.ID, CodeInvalidRequest, "Invalid Request"))
+			return
+		}
+		initialize = initialize || req.Method == "initialize"
+		reqs = append(reqs, req)
+	}
+
+	session := r.Header.Get(SessionHeader)
+	switch {
+	case initialize && len(msgs) > 1:
+		writeRPC(w, http.StatusBadRequest, errorResponse(nil, CodeInvalidRequest, "Invalid Request: Only one initialization request is allowed"))
+		return
+	case initialize:
+		session = newSessionID()
+		s.mu.Lock()
+		if s.sessions == nil {
+			s.sessions = map[string]bool{}
+		}
+		s.sessions[session] = true
+		s.mu.Unlock()
+	case session == "":
+		writeRPC(w, http.StatusBadRequest, errorResponse(nil, -32000, "Bad Request: Mcp-Session-Id header is required"))
+		return
+	default:
+		s.mu.Lock()
+		known := s.sessions[session]
+		s.mu.Unlock()
+		if !known {
+			writeRPC(w, http.StatusNotFound, errorResponse(nil, -32001, "Session not found"))
+			return
+		}
+	}
+	w.Header().Set(SessionHeader, session)
+
+	var out []any
+	for _, req := range reqs {
+		if req.ID == nil {
+			continue
+		}
+		out = append(out, s.handle(req))
+	}
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, resp := range out {
+		data, _ := json.Marshal(resp)
+		_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	}
+}
+
+func (s *StandIn) handle(req Request) any {
+	params, _ := json.Marshal(req.Params)
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(params, &p)
+		version := p.ProtocolVersion
+		if !supported(version) {
+			version = ProtocolVersion
+		}
+		return result(req.ID, map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]bool{"listChanged": true}},
+			"serverInfo":      s.Info,
+		})
+	case "ping":
+		return result(req.ID, map[string]any{})
+	case "tools/list":
+		return result(req.ID, map[string]any{"tools": s.Tools})
+	case "tools/call":
+		var p struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return errorResponse(req.ID, CodeInvalidParams, "Invalid params: "+err.Error())
+		}
+		tool := s.tool(p.Name)
+		if tool == nil {
+			return errorResponse(req.ID, CodeInvalidParams, fmt.Sprintf("MCP error -32602: Tool %s not found", p.Name))
+		}
+		args := map[string]any{}
+		for k, v := range p.Arguments {
+			args[k] = v
+		}
+		if err := tool.InputSchema.Validate(args); err != nil {
+			return errorResponse(req.ID, CodeInvalidParams, fmt.Sprintf("MCP error -32602: Invalid arguments for tool %s: %v", p.Name, err))
+		}
+		for name, prop := range tool.InputSchema.Properties {
+			if _, ok := args[name]; !ok && len(prop.Default) > 0 {
+				var d any
+				_ = json.Unmarshal(prop.Default, &d)
+				args[name] = d
+			}
+		}
+		requestID := fmt.Sprintf("standin-%d", s.seq.Add(1))
+		var res *CallToolResult
+		if s.Call != nil {
+			res = s.Call(p.Name, args, requestID)
+		}
+		if res == nil {
+			res = &CallToolResult{Content: []Content{{Type: "text", Text: p.Name + ": ok"}}}
+		}
+		return result(req.ID, res)
+	}
+	return errorResponse(req.ID, CodeMethodNotFound, "Method not found")
+}
+
+func (s *StandIn) tool(name string) *Tool {
+	for i := range s.Tools {
+		if s.Tools[i].Name == name {
+			return &s.Tools[i]
+		}
+	}
+	return nil
+}
+
+// NewBridge returns a stand-in for a bridge's server.js, announcing itself
+// as info and serving the given tools against an empty backend: searches
+// succeed with nothing found, rag_read_resource rejects malformed URIs with
+// invalid_uri and finds no resource, and rag_task_status knows no job.
+func NewBridge(info Implementation, specs []ToolSpec) *StandIn {
+	s := &StandIn{Info: info}
+	bySpec := map[string]ToolSpec{}
+	for _, spec := range specs {
+		bySpec[spec.Name] = spec
+		s.Tools = append(s.Tools, Tool{Name: spec.Name, Description: spec.Name + " (stand-in)", InputSchema: spec.InputSchema()})
+	}
+	s.Call = func(name string, args map[string]any, requestID string) *CallToolResult {
+		switch name {
+		case "rag_read_resource":
+			u, _ := args["resource_uri"].(string)
+			if !WellFormedURI(u) {
+				return ErrorResult(ErrInvalidURI, "malformed Resource_URI: "+u)
+			}
+			return ErrorResult(ErrNotFound, "Resource_URI가 가리키는 자원을 찾을 수 없습니다: "+u)
+		case "rag_task_status":
+			return ErrorResult(ErrNotFound, fmt.Sprintf("알려지지 않은 job_id입니다: %v", args["job_id"]))
+		}
+		text := name + ": 결과가 없습니다."
+		spec := bySpec[name]
+		switch {
+		case spec.Envelope:
+			text = Envelope{
+				IndexVersion:     "unknown",
+				ResolvedSnapshot: "unknown",
+				ResourceURIs:     []string{"index://standin"},
+				RequestID:        requestID,
+			}.Append(text)
+		case spec.Structured:
+			block, _ := json.Marshal(map[string]any{"count": 0, "request_id": requestID})
+			text += Separator + string(block)
+		}
+		return &CallToolResult{Content: []Content{{Type: "text", Text: text}}}
+	}
+	return s
+}
+
+// Append adds the envelope to text as lib/envelope.appendEnvelope does.
+func (e Envelope) Append(text string) string {
+	data, _ := json.Marshal(e)
+	return text + Separator + string(data)
+}
+
+// ErrorResult renders a tool error as lib/errors.renderError does.
+func ErrorResult(code, message string) *CallToolResult {
+	data, _ := json.Marshal(ErrorSchema{ErrorCode: code, Message: message})
+	return &CallToolResult{Content: []Content{{Type: "text", Text: string(data)}}, IsError: true}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func result(id json.RawMessage, v any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "id": id, "result": v}
+}
+
+func errorResponse(id json.RawMessage, code int, message string) map[string]any {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return map[string]any{"jsonrpc": "2.0", "id": id, "error": RPCError{Code: code, Message: message}}
+}
+
+func writeRPC(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
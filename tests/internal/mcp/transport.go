@@ -0,0 +1,367 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SessionHeader carries the session id of the Streamable HTTP transport.
+const SessionHeader = "Mcp-Session-Id"
+
+// Transport carries one JSON-RPC message, or a batch, to a server.
+type Transport interface {
+	// RoundTrip posts body and returns the reply.
+	RoundTrip(ctx context.Context, body []byte) (*Reply, error)
+	// Session returns the session id the server assigned, or "".
+	Session() string
+}
+
+// Reply is the HTTP answer to a posted message: its status and the JSON-RPC
+// messages it carried, from a JSON body or from a server-sent event stream.
+// Body keeps any other payload, such as an HTML error page.
+type Reply struct {
+	Status      int               `json:"status"`
+	ContentType string            `json:"content_type,omitempty"`
+	Messages    []json.RawMessage `json:"messages,omitempty"`
+	Body        string            `json:"body,omitempty"`
+}
+
+// Response returns the response to the request with the given id. Server
+// notifications streamed ahead of it are skipped.
+func (r *Reply) Response(id json.RawMessage) (*Response, error) {
+	for _, m := range r.Messages {
+		var probe struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if json.Unmarshal(m, &probe) != nil || probe.Method != "" {
+			continue
+		}
+		// A null id answers a request the server could not read the id of.
+		if bytes.Equal(probe.ID, id) || string(probe.ID) == "null" {
+			return ParseResponse(m)
+		}
+	}
+	if r.Status/100 != 2 {
+		return nil, fmt.Errorf("HTTP %d without a JSON-RPC response: %.200s", r.Status, r.Body)
+	}
+	return nil, fmt.Errorf("no response with id %s among %d messages", id, len(r.Messages))
+}
+
+// Error returns the JSON-RPC error of a reply to a single request, or nil.
+func (r *Reply) Error() *RPCError {
+	if len(r.Messages) != 1 {
+		return nil
+	}
+	resp, err := ParseResponse(r.Messages[0])
+	if err != nil {
+		return nil
+	}
+	return resp.Error
+}
+
+// HTTPTransport is the Streamable HTTP transport: every message is POSTed
+// to URL, the session id from the initialize reply is sent back on later
+// requests, and replies are either JSON or an event stream.
+type HTTPTransport struct {
+	URL    string
+	Client *http.Client
+	Header http.Header
+
+	mu      sync.Mutex
+	session string
+}
+
+func (t *HTTPTransport) Session() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.session
+}
+
+func (t *HTTPTransport) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return http.DefaultClient
+}
+
+func (t *HTTPTransport) RoundTrip(ctx context.Context, body []byte) (*Reply, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if s := t.Session(); s != "" {
+		req.Header.Set(SessionHeader, s)
+	}
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if s := resp.Header.Get(SessionHeader); s != "" {
+		t.mu.Lock()
+		t.session = s
+		t.mu.Unlock()
+	}
+	reply := &Reply{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	ctype, _, _ := mime.ParseMediaType(reply.ContentType)
+	switch ctype {
+	case "text/event-stream":
+		events, err := readEvents(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading event stream: %v", err)
+		}
+		for _, ev := range events {
+			if ev.name == "" || ev.name == "message" {
+				reply.Messages = append(reply.Messages, json.RawMessage(ev.data))
+			}
+		}
+	default:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if ctype == "application/json" {
+			reply.Messages = splitMessages(data)
+		}
+		if reply.Messages == nil {
+			reply.Body = string(data)
+		}
+	}
+	return reply, nil
+}
+
+// Close ends the session with DELETE, as a client does when it is done.
+func (t *HTTPTransport) Close(ctx context.Context) error {
+	s := t.Session()
+	if s == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SessionHeader, s)
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// splitMessages splits a JSON body into messages, unpacking a batch.
+func splitMessages(data []byte) []json.RawMessage {
+	var batch []json.RawMessage
+	if json.Unmarshal(data, &batch) == nil {
+		return batch
+	}
+	var one map[string]json.RawMessage
+	if json.Unmarshal(data, &one) == nil {
+		return []json.RawMessage{bytes.TrimSpace(data)}
+	}
+	return nil
+}
+
+type event struct {
+	name, data string
+}
+
+// readEvents reads server-sent events until EOF, joining multi-line data.
+func readEvents(r io.Reader) ([]event, error) {
+	var out []event
+	var cur event
+	var data []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 4<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if data != nil {
+				cur.data = strings.Join(data, "\n")
+				out = append(out, cur)
+			}
+			cur, data = event{}, nil
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			cur.name = value
+		}
+	}
+	return out, sc.Err()
+}
+
+// Exchange is a recorded round trip. Session records whether the request
+// carried a session id, so that replays tell a handshake-less request from
+// the same request inside a session.
+type Exchange struct {
+	Request string `json:"request"`
+	Session bool   `json:"session"`
+	Reply   Reply  `json:"reply"`
+}
+
+// Recording is a session log shared by the Recorders of one test run.
+type Recording struct {
+	mu        sync.Mutex
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// Recorder is a Transport that logs every round trip to a Recording.
+type Recorder struct {
+	Transport Transport
+	Log       *Recording
+}
+
+func (r *Recorder) Session() string { return r.Transport.Session() }
+
+func (r *Recorder) RoundTrip(ctx context.Context, body []byte) (*Reply, error) {
+	session := r.Transport.Session() != ""
+	reply, err := r.Transport.RoundTrip(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	r.Log.mu.Lock()
+	r.Log.Exchanges = append(r.Log.Exchanges, Exchange{Request: string(body), Session: session, Reply: *reply})
+	r.Log.mu.Unlock()
+	return reply, nil
+}
+
+// Save writes the recording as indented JSON.
+func (rec *Recording) Save(path string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadRecording reads a recording written by Save.
+func LoadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &rec, nil
+}
+
+// Replay is a Transport answering from a Recording. Requests match recorded
+// ones regardless of their ids; response ids are rewritten to the id of the
+// request. Identical requests are answered in recorded order, the last
+// answer repeating. Initialize opens a replayed session.
+type Replay struct {
+	rec *Recording
+
+	mu      sync.Mutex
+	used    map[int]bool
+	session string
+}
+
+// NewReplay returns a fresh client-side view of a recording.
+func NewReplay(rec *Recording) *Replay {
+	return &Replay{rec: rec, used: map[int]bool{}}
+}
+
+func (r *Replay) Session() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session
+}
+
+func (r *Replay) RoundTrip(_ context.Context, body []byte) (*Reply, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := exchangeKey(string(body))
+	session := r.session != ""
+	match := -1
+	for i, ex := range r.rec.Exchanges {
+		if ex.Session != session || exchangeKey(ex.Request) != key {
+			continue
+		}
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("replay: no recorded exchange for %.200s", body)
+	}
+	r.used[match] = true
+	ex := r.rec.Exchanges[match]
+
+	var req Request
+	_ = json.Unmarshal(body, &req)
+	reply := ex.Reply
+	if req.ID != nil {
+		reply.Messages = nil
+		for _, m := range ex.Reply.Messages {
+			reply.Messages = append(reply.Messages, withID(m, req.ID))
+		}
+	}
+	if req.Method == "initialize" && reply.Status/100 == 2 && reply.Error() == nil {
+		r.session = "replay"
+	}
+	return &reply, nil
+}
+
+// exchangeKey is a request without its id, in canonical form.
+func exchangeKey(body string) string {
+	var v any
+	if json.Unmarshal([]byte(body), &v) != nil {
+		return body
+	}
+	strip := func(v any) {
+		if m, ok := v.(map[string]any); ok {
+			delete(m, "id")
+		}
+	}
+	if batch, ok := v.([]any); ok {
+		for _, m := range batch {
+			strip(m)
+		}
+	} else {
+		strip(v)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// withID replaces the id of a response; other messages are kept as is.
+func withID(m, id json.RawMessage) json.RawMessage {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(m, &obj) != nil || obj["method"] != nil {
+		return m
+	}
+	if _, ok := obj["id"]; !ok {
+		return m
+	}
+	obj["id"] = id
+	out, _ := json.Marshal(obj)
+	return out
+}
@@ -0,0 +1,465 @@
+// Package nacl simulates AWS network ACL evaluation for Terraform
+// configurations.
+//
+// Network ACLs are stateless and evaluated per direction in ascending rule
+// number order; the first rule that matches a packet decides it, and a packet
+// that matches no rule is denied by the implicit "*" rule. The simulator
+// rebuilds each aws_network_acl from its aws_network_acl_rule resources (and
+// inline ingress/egress blocks), evaluates sample flows, reports rules that
+// can never match, checks that return traffic is permitted for allowed flows
+// and warns when a rule set is close to the per-direction quota.
+package nacl
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// DefaultRuleQuota is the default number of rules AWS allows per direction
+// in a network ACL.
+const DefaultRuleQuota = 20
+
+// Ephemeral port range used by Lambda, NAT gateways and most Linux clients.
+const (
+	EphemeralFrom = 1024
+	EphemeralTo   = 65535
+)
+
+// Direction of traffic relative to the subnet.
+type Direction bool
+
+const (
+	Ingress Direction = false
+	Egress  Direction = true
+)
+
+func (d Direction) String() string {
+	if d == Egress {
+		return "egress"
+	}
+	return "ingress"
+}
+
+// Rule is a single network ACL entry.
+type Rule struct {
+	Name     string
+	Number   int
+	Egress   bool
+	Protocol string // "-1" (all), "tcp", "udp", "icmp" or an IP protocol number
+	Allow    bool
+	CIDR     *net.IPNet
+	FromPort int
+	ToPort   int
+}
+
+func (r Rule) String() string {
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+	return fmt.Sprintf("#%d %s %s %s %s %d-%d (%s)",
+		r.Number, Direction(r.Egress), action, r.Protocol, r.CIDR, r.FromPort, r.ToPort, r.Name)
+}
+
+// allPorts reports whether the rule applies to every port, which is the case
+// for protocol -1 and for protocols without ports.
+func (r Rule) allPorts() bool {
+	return r.Protocol == "-1" || (r.Protocol != "tcp" && r.Protocol != "udp")
+}
+
+func (r Rule) matchesProtocol(proto string) bool {
+	return r.Protocol == "-1" || r.Protocol == proto
+}
+
+func (r Rule) matchesPort(port int) bool {
+	return r.allPorts() || (port >= r.FromPort && port <= r.ToPort)
+}
+
+// ACL is a network ACL with its rules.
+type ACL struct {
+	Name  string
+	Rules []Rule
+}
+
+// Flow is a packet seen by the ACL. Ports are ignored for protocols without
+// ports.
+type Flow struct {
+	Protocol string
+	Src      net.IP
+	Dst      net.IP
+	SrcPort  int
+	DstPort  int
+}
+
+func (f Flow) String() string {
+	return fmt.Sprintf("%s %s:%d -> %s:%d", f.Protocol, f.Src, f.SrcPort, f.Dst, f.DstPort)
+}
+
+// Reverse returns the response packet of a flow.
+func (f Flow) Reverse() Flow {
+	return Flow{Protocol: f.Protocol, Src: f.Dst, Dst: f.Src, SrcPort: f.DstPort, DstPort: f.SrcPort}
+}
+
+// Decision is the outcome of evaluating a flow. Rule is nil when the implicit
+// default deny rule decided it.
+type Decision struct {
+	Allowed bool
+	Rule    *Rule
+}
+
+func (d Decision) String() string {
+	verdict := "DENY"
+	if d.Allowed {
+		verdict = "ALLOW"
+	}
+	if d.Rule == nil {
+		return verdict + " by default rule *"
+	}
+	return fmt.Sprintf("%s by rule #%d (%s)", verdict, d.Rule.Number, d.Rule.Name)
+}
+
+// Ordered returns the rules of one direction in evaluation order.
+func (a *ACL) Ordered(dir Direction) []Rule {
+	var out []Rule
+	for _, r := range a.Rules {
+		if r.Egress == bool(dir) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	return out
+}
+
+// Evaluate returns the first-match decision for a flow. Inbound rules match
+// on the source address, outbound rules on the destination address; both
+// match on the destination port.
+func (a *ACL) Evaluate(dir Direction, f Flow) Decision {
+	return firstMatch(a.Ordered(dir), dir, f)
+}
+
+// firstMatch evaluates a flow against the rules of one direction, already in
+// evaluation order.
+func firstMatch(rules []Rule, dir Direction, f Flow) Decision {
+	addr := f.Src
+	if dir == Egress {
+		addr = f.Dst
+	}
+	for _, r := range rules {
+		r := r
+		if r.matchesProtocol(f.Protocol) && r.CIDR.Contains(addr) && r.matchesPort(f.DstPort) {
+			return Decision{Allowed: r.Allow, Rule: &r}
+		}
+	}
+	return Decision{Allowed: false}
+}
+
+// Finding describes a problem found by Analyze or the return-traffic checks.
+type Finding struct {
+	Kind    string // "shadowed", "redundant", "return-traffic", "quota"
+	Rule    *Rule
+	By      []Rule
+	Flow    *Flow
+	Message string
+}
+
+func (f Finding) String() string {
+	return f.Kind + ": " + f.Message
+}
+
+// Analyze reports rules that can never be the first match because earlier
+// rules in the same direction already cover their whole match space. A rule
+// covered only by rules with the same action is "redundant"; one covered by
+// at least one rule with the opposite action is "shadowed", meaning it does
+// not do what its author intended.
+func (a *ACL) Analyze() []Finding {
+	var findings []Finding
+	for _, dir := range []Direction{Ingress, Egress} {
+		rules := a.Ordered(dir)
+		for i := range rules {
+			r := rules[i]
+			covering := coveringRules(r, rules[:i])
+			if covering == nil {
+				continue
+			}
+			kind := "redundant"
+			for _, c := range covering {
+				if c.Allow != r.Allow {
+					kind = "shadowed"
+				}
+			}
+			var nums []string
+			for _, c := range covering {
+				nums = append(nums, "#"+strconv.Itoa(c.Number))
+			}
+			findings = append(findings, Finding{
+				Kind:    kind,
+				Rule:    &rules[i],
+				By:      covering,
+				Message: fmt.Sprintf("%s: rule %s is unreachable, fully covered by %s", a.Name, r, strings.Join(nums, ", ")),
+			})
+		}
+	}
+	return findings
+}
+
+// coveringRules returns the earlier rules that together cover every packet r
+// could match, or nil when some packet would still reach r. Only earlier
+// rules whose CIDR contains r's CIDR and whose protocol includes r's protocol
+// are considered; their port ranges must then cover r's port range.
+func coveringRules(r Rule, earlier []Rule) []Rule {
+	var candidates []Rule
+	for _, e := range earlier {
+		if !e.matchesProtocol(r.Protocol) || !cidrContains(e.CIDR, r.CIDR) {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Every candidate covering all ports covers r on its own.
+	for _, c := range candidates {
+		if c.allPorts() {
+			return []Rule{c}
+		}
+	}
+	if r.allPorts() {
+		return nil
+	}
+
+	// Sweep r's port range with the candidates' ranges.
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].FromPort < candidates[j].FromPort })
+	next := r.FromPort
+	var used []Rule
+	for _, c := range candidates {
+		if c.FromPort > next {
+			break
+		}
+		if c.ToPort >= next {
+			used = append(used, c)
+			next = c.ToPort + 1
+		}
+		if next > r.ToPort {
+			return used
+		}
+	}
+	return nil
+}
+
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// CheckReturnTraffic verifies that the response to a flow permitted in dir is
+// permitted in the opposite direction for every client ephemeral port in
+// [ephemeralFrom, ephemeralTo]. For an inbound flow the client is the source
+// and the response leaves the subnet; for an outbound flow the response
+// arrives from the server's port to the subnet's ephemeral port.
+func (a *ACL) CheckReturnTraffic(dir Direction, f Flow, ephemeralFrom, ephemeralTo int) []Finding {
+	if !a.Evaluate(dir, f).Allowed {
+		return nil
+	}
+	back := !dir
+	rules := a.Ordered(back)
+	var findings []Finding
+	for port := ephemeralFrom; port <= ephemeralTo; port++ {
+		fwd := f
+		fwd.SrcPort = port
+		resp := fwd.Reverse()
+		d := firstMatch(rules, back, resp)
+		if d.Allowed {
+			continue
+		}
+		// Report the first blocked port and the extent of the blocked run.
+		last := port
+		for last+1 <= ephemeralTo {
+			fwd.SrcPort = last + 1
+			if firstMatch(rules, back, fwd.Reverse()).Allowed {
+				break
+			}
+			last++
+		}
+		flow := f
+		findings = append(findings, Finding{
+			Kind: "return-traffic",
+			Flow: &flow,
+			Rule: d.Rule,
+			Message: fmt.Sprintf("%s: %s allowed %s but %s response to ports %d-%d is %s",
+				a.Name, f, dir, back, port, last, d),
+		})
+		port = last
+	}
+	return findings
+}
+
+// CheckQuota warns when either direction uses more than limit-headroom rules.
+// The implicit default rule does not count towards the quota.
+func (a *ACL) CheckQuota(limit, headroom int) []Finding {
+	var findings []Finding
+	for _, dir := range []Direction{Ingress, Egress} {
+		n := len(a.Ordered(dir))
+		if n > limit-headroom {
+			findings = append(findings, Finding{
+				Kind:    "quota",
+				Message: fmt.Sprintf("%s: %d %s rules, quota is %d (headroom %d)", a.Name, n, dir, limit, headroom),
+			})
+		}
+	}
+	return findings
+}
+
+// FromModule rebuilds every aws_network_acl in a module. Standalone rules are
+// attached through their network_acl_id reference, one rule per count
+// instance, named with its index and evaluated with its count.index. Rules
+// that cannot be evaluated statically (unknown CIDR, rule number or ports)
+// are returned as an error so that callers supply the missing variables
+// instead of silently simulating a partial rule set.
+func FromModule(m *tfconfig.Module) ([]*ACL, error) {
+	byAddr := map[string]*ACL{}
+	var acls []*ACL
+	for _, b := range m.Resources("aws_network_acl") {
+		acl := &ACL{Name: b.Address()}
+		for _, dir := range []string{"ingress", "egress"} {
+			for _, nb := range b.Nested(dir) {
+				r, err := ruleFromAttrs(nb.Value, dir == "egress", "rule_no", "action")
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", acl.Name, dir, err)
+				}
+				r.Name = fmt.Sprintf("%s.%s[%d]", acl.Name, dir, r.Number)
+				acl.Rules = append(acl.Rules, r)
+			}
+		}
+		byAddr[acl.Name] = acl
+		acls = append(acls, acl)
+	}
+
+	for _, b := range m.Resources("aws_network_acl_rule") {
+		n, ok := b.Count()
+		if !ok {
+			return nil, fmt.Errorf("%s: count is not statically known", b.Address())
+		}
+		if n == 0 {
+			continue
+		}
+
+		var acl *ACL
+		for _, ref := range b.Refs("network_acl_id") {
+			if a, ok := byAddr[ref]; ok {
+				acl = a
+			}
+		}
+		if acl == nil {
+			return nil, fmt.Errorf("%s: network_acl_id does not reference an aws_network_acl in this module", b.Address())
+		}
+
+		for i := 0; i < n; i++ {
+			i := i
+			attr := func(name string) cty.Value {
+				expr := b.Expr(name)
+				if expr == nil {
+					return cty.NilVal
+				}
+				return b.Module().EvalWith(expr, map[string]cty.Value{
+					"count": cty.ObjectVal(map[string]cty.Value{"index": cty.NumberIntVal(int64(i))}),
+				})
+			}
+			name := b.Address()
+			if b.Has("count") {
+				name = fmt.Sprintf("%s[%d]", name, i)
+			}
+			egress := false
+			if v := attr("egress"); v != cty.NilVal && v.IsKnown() && !v.IsNull() && v.Type() == cty.Bool {
+				egress = v.True()
+			}
+			r, err := ruleFromAttrs(attr, egress, "rule_number", "rule_action")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			r.Name = name
+			acl.Rules = append(acl.Rules, r)
+		}
+	}
+	return acls, nil
+}
+
+// ruleFromAttrs builds a rule from the attributes of an ingress or egress
+// block or an aws_network_acl_rule instance, as evaluated by attr.
+func ruleFromAttrs(attr func(string) cty.Value, egress bool, numberAttr, actionAttr string) (Rule, error) {
+	r := Rule{Egress: egress}
+
+	num, ok := tfconfig.AsInt(attr(numberAttr))
+	if !ok {
+		return r, fmt.Errorf("%s is not statically known", numberAttr)
+	}
+	r.Number = num
+
+	action, ok := asString(attr(actionAttr))
+	if !ok {
+		return r, fmt.Errorf("%s is not statically known", actionAttr)
+	}
+	r.Allow = strings.EqualFold(action, "allow")
+
+	proto, ok := asString(attr("protocol"))
+	if !ok {
+		return r, fmt.Errorf("protocol is not statically known")
+	}
+	r.Protocol = normalizeProtocol(proto)
+
+	cidr, ok := asString(attr("cidr_block"))
+	if !ok {
+		return r, fmt.Errorf("cidr_block is not statically known")
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return r, fmt.Errorf("cidr_block: %w", err)
+	}
+	r.CIDR = ipnet
+
+	if !r.allPorts() {
+		if r.FromPort, ok = tfconfig.AsInt(attr("from_port")); !ok {
+			return r, fmt.Errorf("from_port is not statically known")
+		}
+		if r.ToPort, ok = tfconfig.AsInt(attr("to_port")); !ok {
+			return r, fmt.Errorf("to_port is not statically known")
+		}
+	}
+	return r, nil
+}
+
+// asString returns a statically known string, or number as written in HCL.
+func asString(v cty.Value) (string, bool) {
+	if v == cty.NilVal || !v.IsWhollyKnown() || v.IsNull() {
+		return "", false
+	}
+	switch v.Type() {
+	case cty.String:
+		return v.AsString(), true
+	case cty.Number:
+		return v.AsBigFloat().Text('f', -1), true
+	}
+	return "", false
+}
+
+func normalizeProtocol(p string) string {
+	switch strings.ToLower(p) {
+	case "-1", "all":
+		return "-1"
+	case "6", "tcp":
+		return "tcp"
+	case "17", "udp":
+		return "udp"
+	case "1", "icmp":
+		return "icmp"
+	}
+	return strings.ToLower(p)
+}
@@ -0,0 +1,144 @@
+package nacl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	return n
+}
+
+func TestEvaluate_FirstMatchWins(t *testing.T) {
+	t.Parallel()
+
+	acl := &ACL{Name: "test", Rules: []Rule{
+		{Name: "deny-ssh", Number: 90, Protocol: "tcp", CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 22, ToPort: 22},
+		{Name: "allow-vpc", Number: 100, Protocol: "-1", Allow: true, CIDR: mustCIDR(t, "10.10.0.0/16")},
+	}}
+
+	ssh := Flow{Protocol: "tcp", Src: net.ParseIP("10.10.1.5"), Dst: net.ParseIP("10.10.2.5"), SrcPort: 40000, DstPort: 22}
+	d := acl.Evaluate(Ingress, ssh)
+	assert.False(t, d.Allowed, "lower-numbered deny should win over the VPC allow")
+	require.NotNil(t, d.Rule)
+	assert.Equal(t, 90, d.Rule.Number)
+
+	https := ssh
+	https.DstPort = 443
+	assert.True(t, acl.Evaluate(Ingress, https).Allowed)
+
+	outside := https
+	outside.Src = net.ParseIP("192.0.2.1")
+	d = acl.Evaluate(Ingress, outside)
+	assert.False(t, d.Allowed)
+	assert.Nil(t, d.Rule, "unmatched traffic should fall through to the default rule")
+}
+
+func TestAnalyze_DetectsShadowedAndRedundantRules(t *testing.T) {
+	t.Parallel()
+
+	acl := &ACL{Name: "test", Rules: []Rule{
+		{Name: "https", Number: 100, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 443, ToPort: 443},
+		{Name: "low", Number: 110, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 0, ToPort: 442},
+		{Name: "high", Number: 120, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "10.0.0.0/8"), FromPort: 444, ToPort: 65535},
+		{Name: "deny-https-from-vpc", Number: 130, Protocol: "tcp", CIDR: mustCIDR(t, "10.10.0.0/16"), FromPort: 443, ToPort: 443},
+		{Name: "allow-vpc-tcp", Number: 140, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "10.10.0.0/16"), FromPort: 0, ToPort: 65535},
+		{Name: "deny-udp", Number: 150, Protocol: "udp", CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 0, ToPort: 65535},
+	}}
+
+	findings := acl.Analyze()
+	byRule := map[string]string{}
+	for _, f := range findings {
+		byRule[f.Rule.Name] = f.Kind
+	}
+
+	assert.Equal(t, "shadowed", byRule["deny-https-from-vpc"], "deny is fully covered by an earlier allow")
+	assert.Equal(t, "redundant", byRule["allow-vpc-tcp"], "allow is covered by the union of three earlier allows")
+	assert.NotContains(t, byRule, "deny-udp", "udp is not covered by any earlier rule")
+	assert.NotContains(t, byRule, "high")
+}
+
+func TestCheckReturnTraffic_ReportsBlockedEphemeralPorts(t *testing.T) {
+	t.Parallel()
+
+	acl := &ACL{Name: "test", Rules: []Rule{
+		{Name: "in-https", Number: 100, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 443, ToPort: 443},
+		{Name: "out-ephemeral", Number: 100, Egress: true, Protocol: "tcp", Allow: true, CIDR: mustCIDR(t, "0.0.0.0/0"), FromPort: 32768, ToPort: 65535},
+	}}
+
+	flow := Flow{Protocol: "tcp", Src: net.ParseIP("192.0.2.10"), Dst: net.ParseIP("10.10.1.5"), DstPort: 443}
+	findings := acl.CheckReturnTraffic(Ingress, flow, EphemeralFrom, EphemeralTo)
+	require.Len(t, findings, 1)
+	assert.Contains(t, findings[0].Message, "ports 1024-32767")
+
+	// A denied flow has no return traffic to check.
+	flow.DstPort = 22
+	assert.Empty(t, acl.CheckReturnTraffic(Ingress, flow, EphemeralFrom, EphemeralTo))
+}
+
+func TestCheckQuota(t *testing.T) {
+	t.Parallel()
+
+	acl := &ACL{Name: "test"}
+	for i := 0; i < 18; i++ {
+		acl.Rules = append(acl.Rules, Rule{Number: 100 + i, Protocol: "-1", Allow: true, CIDR: mustCIDR(t, "10.0.0.0/8")})
+	}
+
+	findings := acl.CheckQuota(DefaultRuleQuota, 4)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "quota", findings[0].Kind)
+	assert.Contains(t, findings[0].Message, "18 ingress rules")
+	assert.Empty(t, acl.CheckQuota(DefaultRuleQuota, 2))
+}
+
+func TestFromModule_ExpandsCountedRules(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(`
+variable "peer_cidrs" {
+  default = ["10.20.0.0/16", "10.30.0.0/16"]
+}
+resource "aws_network_acl" "private" {
+  ingress {
+    rule_no    = 100
+    action     = "allow"
+    protocol   = "tcp"
+    cidr_block = "10.10.0.0/16"
+    from_port  = 443
+    to_port    = 443
+  }
+}
+resource "aws_network_acl_rule" "peer" {
+  count          = length(var.peer_cidrs)
+  network_acl_id = aws_network_acl.private.id
+  rule_number    = 200 + count.index
+  rule_action    = "allow"
+  protocol       = "-1"
+  cidr_block     = var.peer_cidrs[count.index]
+}
+`), 0o644))
+	m, err := tfconfig.Load(dir)
+	require.NoError(t, err)
+
+	acls, err := FromModule(m)
+	require.NoError(t, err)
+	require.Len(t, acls, 1)
+	require.Len(t, acls[0].Rules, 3)
+	assert.Equal(t, "aws_network_acl_rule.peer[0]", acls[0].Rules[1].Name)
+	assert.Equal(t, 200, acls[0].Rules[1].Number)
+	assert.Equal(t, "10.20.0.0/16", acls[0].Rules[1].CIDR.String())
+	assert.Equal(t, "aws_network_acl_rule.peer[1]", acls[0].Rules[2].Name)
+	assert.Equal(t, 201, acls[0].Rules[2].Number)
+	assert.Equal(t, "10.30.0.0/16", acls[0].Rules[2].CIDR.String())
+}
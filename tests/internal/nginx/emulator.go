@@ -0,0 +1,377 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Emulator is an http.Handler that serves a Config the way nginx would for
+// the directives modelled here: server and location selection, return codes
+// (444 closes the connection), client_max_body_size, path rewriting,
+// proxy_set_header, proxy_read_timeout, response buffering and protocol
+// upgrades.
+type Emulator struct {
+	Config *Config
+
+	// Port is the port requests are treated as arriving on. It defaults to
+	// 443.
+	Port int
+
+	// Dial connects to upstreams. Tests use it to send every proxy_pass host
+	// to a local server.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TimeScale multiplies every timeout, so tests can exercise a 60s
+	// proxy_read_timeout in milliseconds. It defaults to 1.
+	TimeScale float64
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// Exchange records a request the emulator handled, like a line in nginx's
+// access.log.
+type Exchange struct {
+	Host     string
+	Path     string
+	Server   *Server
+	Location *Location
+	Upstream string // the upstream URL, "" when not proxied
+	Status   int    // 0 when the connection was closed without a response
+}
+
+// Log returns the exchanges so far.
+func (e *Emulator) Log() []Exchange {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Exchange(nil), e.exchanges...)
+}
+
+func (e *Emulator) record(x Exchange) {
+	e.mu.Lock()
+	e.exchanges = append(e.exchanges, x)
+	e.mu.Unlock()
+}
+
+// hopByHop headers are not passed between client and upstream.
+var hopByHop = []string{"Connection", "Keep-Alive", "Proxy-Connection", "TE", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	x := Exchange{Host: host, Path: r.URL.Path}
+	defer func() { e.record(x) }()
+
+	x.Server = e.Config.Server(host, e.port())
+	if x.Server == nil {
+		x.Status = http.StatusNotFound
+		http.Error(w, "no server listens on this port", x.Status)
+		return
+	}
+	if x.Server.Return != 0 {
+		x.Status = respond(w, x.Server.Return)
+		return
+	}
+	x.Location = x.Server.Location(r.URL.Path)
+	if x.Location == nil || (x.Location.ProxyPass == nil && x.Location.Return == 0) {
+		x.Status = http.StatusNotFound
+		http.Error(w, "404 Not Found", x.Status)
+		return
+	}
+	if x.Location.Return != 0 {
+		x.Status = respond(w, x.Location.Return)
+		return
+	}
+	x.Status = e.proxy(w, r, host, x.Server, x.Location, &x.Upstream)
+}
+
+func (e *Emulator) port() int {
+	if e.Port == 0 {
+		return 443
+	}
+	return e.Port
+}
+
+func (e *Emulator) scale(d time.Duration) time.Duration {
+	if e.TimeScale == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * e.TimeScale)
+}
+
+// respond writes a return status. 444 closes the connection without a
+// response, as nginx does.
+func respond(w http.ResponseWriter, code int) int {
+	if code != 444 {
+		w.WriteHeader(code)
+		return code
+	}
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return 0
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func (e *Emulator) proxy(w http.ResponseWriter, r *http.Request, host string, s *Server, loc *Location, upstream *string) int {
+	// nginx reads the whole request body before contacting the upstream
+	// (proxy_request_buffering on), rejecting it once it exceeds the limit.
+	if loc.MaxBodySize > 0 && r.ContentLength > loc.MaxBodySize {
+		http.Error(w, "413 Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return http.StatusRequestEntityTooLarge
+	}
+	body, err := io.ReadAll(limitReader(r.Body, loc.MaxBodySize))
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	if loc.MaxBodySize > 0 && int64(len(body)) > loc.MaxBodySize {
+		http.Error(w, "413 Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return http.StatusRequestEntityTooLarge
+	}
+
+	u := loc.UpstreamURL(r.URL.Path, r.URL.RawQuery)
+	*upstream = u.String()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	out, err := http.NewRequestWithContext(ctx, r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	out.Header = r.Header.Clone()
+	for _, h := range hopByHop {
+		out.Header.Del(h)
+	}
+	out.Host = loc.ProxyPass.Host
+	out.Header.Set("Connection", "close")
+	vars := e.variables(r, host, s, loc)
+	for _, h := range loc.Headers {
+		v := variableRef.ReplaceAllStringFunc(h.Value, func(ref string) string {
+			m := variableRef.FindStringSubmatch(ref)
+			return vars(m[1] + m[2])
+		})
+		if strings.EqualFold(h.Name, "Host") {
+			out.Host = v
+			continue
+		}
+		if v == "" {
+			out.Header.Del(h.Name)
+		} else {
+			out.Header.Set(h.Name, v)
+		}
+	}
+
+	transport := e.transport(loc)
+	defer transport.CloseIdleConnections()
+
+	// proxy_read_timeout bounds the wait for the response headers and then
+	// between two reads of the body.
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		if isTimeout(err) {
+			http.Error(w, "504 Gateway Time-out", http.StatusGatewayTimeout)
+			return http.StatusGatewayTimeout
+		}
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return upgrade(w, resp)
+	}
+	timer := time.AfterFunc(e.scale(loc.ReadTimeout), cancel)
+	defer timer.Stop()
+
+	buffered := loc.Buffering && !strings.EqualFold(resp.Header.Get("X-Accel-Buffering"), "no")
+	for k, vs := range resp.Header {
+		w.Header()[k] = append([]string(nil), vs...)
+	}
+	for _, h := range append(hopByHop, "X-Accel-Buffering") {
+		w.Header().Del(h)
+	}
+	read := func(p []byte) (int, error) {
+		n, err := resp.Body.Read(p)
+		timer.Reset(e.scale(loc.ReadTimeout))
+		return n, err
+	}
+
+	if buffered {
+		var all bytes.Buffer
+		if _, err := io.Copy(&all, readerFunc(read)); err != nil {
+			if ctx.Err() != nil {
+				http.Error(w, "504 Gateway Time-out", http.StatusGatewayTimeout)
+				return http.StatusGatewayTimeout
+			}
+			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+			return http.StatusBadGateway
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(all.Len()))
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(all.Bytes())
+		return resp.StatusCode
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return resp.StatusCode
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return resp.StatusCode
+		}
+		if err != nil {
+			// The response has started; nginx closes the connection.
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+func (e *Emulator) variables(r *http.Request, host string, s *Server, loc *Location) func(string) string {
+	remote, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return func(name string) string {
+		switch name {
+		case "host":
+			return strings.ToLower(host)
+		case "proxy_host":
+			return loc.ProxyPass.Host
+		case "remote_addr":
+			return remote
+		case "scheme":
+			if l, _ := s.listens(e.port()); l.SSL {
+				return "https"
+			}
+			return "http"
+		case "request_uri":
+			return r.RequestURI
+		case "uri":
+			return r.URL.Path
+		case "server_name":
+			if len(s.Names) > 0 {
+				return s.Names[0]
+			}
+			return ""
+		case "proxy_add_x_forwarded_for":
+			if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+				return xff + ", " + remote
+			}
+			return remote
+		}
+		if strings.HasPrefix(name, "http_") {
+			return r.Header.Get(strings.ReplaceAll(strings.TrimPrefix(name, "http_"), "_", "-"))
+		}
+		return ""
+	}
+}
+
+// transport connects like nginx does: SNI only with proxy_ssl_server_name
+// on, and no certificate verification (proxy_ssl_verify defaults to off).
+func (e *Emulator) transport(loc *Location) *http.Transport {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, e.scale(loc.ConnectTimeout))
+		defer cancel()
+		if e.Dial != nil {
+			return e.Dial(ctx, network, addr)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	return &http.Transport{
+		DialContext: dial,
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg := &tls.Config{InsecureSkipVerify: true}
+			if loc.SSLServerName {
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tc, nil
+		},
+		ResponseHeaderTimeout: e.scale(loc.ReadTimeout),
+		DisableCompression:    true,
+	}
+}
+
+// upgrade relays a 101 response and tunnels the connection.
+func upgrade(w http.ResponseWriter, resp *http.Response) int {
+	up, ok := resp.Body.(io.ReadWriteCloser)
+	hj, hok := w.(http.Hijacker)
+	if !ok || !hok {
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		return 0
+	}
+	defer client.Close()
+	resp.Body = nil
+	if err := resp.Write(client); err != nil {
+		return 0
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(up, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, up)
+		done <- struct{}{}
+	}()
+	<-done
+	up.Close()
+	return http.StatusSwitchingProtocols
+}
+
+func limitReader(r io.Reader, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return io.LimitReader(r, max+1)
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func (x Exchange) String() string {
+	return fmt.Sprintf("%s %s -> %s (%d)", x.Host, x.Path, x.Upstream, x.Status)
+}
@@ -0,0 +1,592 @@
+// Package nginx parses the reverse proxy configuration the LLM gateway nginx
+// instance is given (environments/app-layer/bedrock-rag/templates/
+// nginx-user-data.sh.tpl) into a routing table, and serves it through
+// Emulator so routing, path rewriting, header forwarding and streaming can be
+// tested against local upstreams.
+//
+// Only the directives the gateway uses are modelled; anything else inside a
+// server or location block is kept in Directives but has no effect.
+package nginx
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directive is a parsed nginx directive with its block, if any.
+type Directive struct {
+	Name  string
+	Args  []string
+	Block []*Directive
+	Line  int
+}
+
+// Header is a proxy_set_header directive.
+type Header struct {
+	Name  string
+	Value string // may reference variables
+}
+
+// Listen is a listen directive.
+type Listen struct {
+	Port    int
+	SSL     bool
+	Default bool
+}
+
+// Location is a location block with the proxy settings in effect for it,
+// including those inherited from the server and http levels.
+type Location struct {
+	Match   string // "", "=", "^~", "~" or "~*"
+	Pattern string
+
+	// ProxyPass is the proxy_pass URL, nil when the location does not proxy.
+	ProxyPass *url.URL
+
+	Return int // return status, 0 when not set
+
+	Headers        []Header
+	HTTPVersion    string
+	SSLServerName  bool
+	Buffering      bool
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	SendTimeout    time.Duration
+	MaxBodySize    int64 // client_max_body_size, 0 for unlimited
+
+	Directives []*Directive
+
+	re *regexp.Regexp
+}
+
+// Server is a server block.
+type Server struct {
+	Names     []string
+	Listen    []Listen
+	Locations []*Location
+
+	// Return is a server-level return status, applied before locations.
+	Return int
+
+	Directives []*Directive
+}
+
+// Config is a parsed configuration: the server blocks of an http context.
+type Config struct {
+	Servers []*Server
+}
+
+// Defaults nginx applies when a directive is not set at any level.
+const (
+	DefaultTimeout     = 60 * time.Second
+	DefaultMaxBodySize = 1 << 20
+	DefaultHTTPVersion = "1.0"
+)
+
+// variables are the nginx variables header values may reference.
+var variables = map[string]bool{
+	"host": true, "proxy_host": true, "remote_addr": true, "scheme": true,
+	"request_uri": true, "uri": true, "server_name": true,
+	"proxy_add_x_forwarded_for": true,
+}
+
+var variableRef = regexp.MustCompile(`\$(\w+)|\$\{(\w+)\}`)
+
+// Parse parses a configuration. It accepts either a full nginx.conf with an
+// http block or a file included into the http context, like conf.d/*.conf.
+func Parse(conf string) (*Config, error) {
+	directives, err := parseDirectives(conf)
+	if err != nil {
+		return nil, err
+	}
+	http := &Directive{Name: "http", Block: directives}
+	for _, d := range directives {
+		if d.Name == "http" {
+			http = d
+		}
+	}
+
+	c := &Config{}
+	for _, d := range http.Block {
+		if d.Name != "server" {
+			continue
+		}
+		s, err := parseServer(http, d)
+		if err != nil {
+			return nil, err
+		}
+		c.Servers = append(c.Servers, s)
+	}
+	return c, nil
+}
+
+func parseServer(http, d *Directive) (*Server, error) {
+	s := &Server{Directives: d.Block}
+	for _, sd := range d.Block {
+		switch sd.Name {
+		case "listen":
+			l, err := parseListen(sd)
+			if err != nil {
+				return nil, err
+			}
+			s.Listen = append(s.Listen, l)
+		case "server_name":
+			s.Names = append(s.Names, sd.Args...)
+		case "return":
+			code, err := returnCode(sd)
+			if err != nil {
+				return nil, err
+			}
+			s.Return = code
+		case "location":
+			loc, err := parseLocation(http, d, sd)
+			if err != nil {
+				return nil, err
+			}
+			s.Locations = append(s.Locations, loc)
+		}
+	}
+	if len(s.Listen) == 0 {
+		s.Listen = []Listen{{Port: 80}}
+	}
+	return s, nil
+}
+
+func parseListen(d *Directive) (Listen, error) {
+	if len(d.Args) == 0 {
+		return Listen{}, fmt.Errorf("line %d: listen without an address", d.Line)
+	}
+	addr := d.Args[0]
+	port, err := strconv.Atoi(addr[strings.LastIndex(addr, ":")+1:])
+	if err != nil {
+		return Listen{}, fmt.Errorf("line %d: unsupported listen address %s", d.Line, addr)
+	}
+	l := Listen{Port: port}
+	for _, a := range d.Args[1:] {
+		switch a {
+		case "ssl":
+			l.SSL = true
+		case "default_server", "default":
+			l.Default = true
+		}
+	}
+	return l, nil
+}
+
+func returnCode(d *Directive) (int, error) {
+	if len(d.Args) == 0 {
+		return 0, fmt.Errorf("line %d: return without a code", d.Line)
+	}
+	code, err := strconv.Atoi(d.Args[0])
+	if err != nil {
+		return 0, fmt.Errorf("line %d: unsupported return %s", d.Line, d.Args[0])
+	}
+	return code, nil
+}
+
+func parseLocation(http, server, d *Directive) (*Location, error) {
+	loc := &Location{Directives: d.Block}
+	switch len(d.Args) {
+	case 1:
+		loc.Pattern = d.Args[0]
+	case 2:
+		loc.Match, loc.Pattern = d.Args[0], d.Args[1]
+	default:
+		return nil, fmt.Errorf("line %d: malformed location", d.Line)
+	}
+	switch loc.Match {
+	case "", "=", "^~":
+	case "~", "~*":
+		expr := loc.Pattern
+		if loc.Match == "~*" {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", d.Line, err)
+		}
+		loc.re = re
+	default:
+		return nil, fmt.Errorf("line %d: unsupported location modifier %s", d.Line, loc.Match)
+	}
+
+	// Settings are inherited from the enclosing levels unless redefined.
+	levels := [][]*Directive{http.Block, server.Block, d.Block}
+	var err error
+	last := func(name string) *Directive {
+		var found *Directive
+		for _, level := range levels {
+			for _, ld := range level {
+				if ld.Name == name {
+					found = ld
+				}
+			}
+		}
+		return found
+	}
+	duration := func(name string) time.Duration {
+		dd := last(name)
+		if dd == nil {
+			return DefaultTimeout
+		}
+		v, e := parseDuration(dd.Args)
+		if e != nil && err == nil {
+			err = fmt.Errorf("line %d: %s: %v", dd.Line, name, e)
+		}
+		return v
+	}
+	flag := func(name string, def bool) bool {
+		dd := last(name)
+		if dd == nil || len(dd.Args) != 1 {
+			return def
+		}
+		return dd.Args[0] == "on"
+	}
+
+	loc.ConnectTimeout = duration("proxy_connect_timeout")
+	loc.ReadTimeout = duration("proxy_read_timeout")
+	loc.SendTimeout = duration("proxy_send_timeout")
+	loc.SSLServerName = flag("proxy_ssl_server_name", false)
+	loc.Buffering = flag("proxy_buffering", true)
+	loc.HTTPVersion = DefaultHTTPVersion
+	if dd := last("proxy_http_version"); dd != nil && len(dd.Args) == 1 {
+		loc.HTTPVersion = dd.Args[0]
+	}
+	loc.MaxBodySize = DefaultMaxBodySize
+	if dd := last("client_max_body_size"); dd != nil {
+		if loc.MaxBodySize, err = parseSize(dd.Args); err != nil {
+			return nil, fmt.Errorf("line %d: client_max_body_size: %v", dd.Line, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// proxy_set_header is inherited only when the level defines none.
+	for _, level := range levels {
+		var headers []Header
+		for _, ld := range level {
+			if ld.Name != "proxy_set_header" {
+				continue
+			}
+			if len(ld.Args) != 2 {
+				return nil, fmt.Errorf("line %d: malformed proxy_set_header", ld.Line)
+			}
+			for _, ref := range variableRef.FindAllStringSubmatch(ld.Args[1], -1) {
+				name := ref[1] + ref[2]
+				if !variables[name] && !strings.HasPrefix(name, "http_") {
+					return nil, fmt.Errorf("line %d: unsupported variable $%s", ld.Line, name)
+				}
+			}
+			headers = append(headers, Header{Name: ld.Args[0], Value: ld.Args[1]})
+		}
+		if headers != nil {
+			loc.Headers = headers
+		}
+	}
+
+	for _, ld := range d.Block {
+		switch ld.Name {
+		case "proxy_pass":
+			if len(ld.Args) != 1 {
+				return nil, fmt.Errorf("line %d: malformed proxy_pass", ld.Line)
+			}
+			u, err := url.Parse(ld.Args[0])
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("line %d: unsupported proxy_pass %s", ld.Line, ld.Args[0])
+			}
+			loc.ProxyPass = u
+		case "return":
+			if loc.Return, err = returnCode(ld); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return loc, nil
+}
+
+func parseDuration(args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected one value")
+	}
+	v := args[0]
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	if strings.HasSuffix(v, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(v)
+}
+
+func parseSize(args []string) (int64, error) {
+	if len(args) != 1 || args[0] == "" {
+		return 0, fmt.Errorf("expected one value")
+	}
+	v := strings.ToLower(args[0])
+	unit := int64(1)
+	switch v[len(v)-1] {
+	case 'k':
+		unit = 1 << 10
+	case 'm':
+		unit = 1 << 20
+	case 'g':
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n * unit, err
+}
+
+// parseDirectives tokenizes a configuration into nested directives.
+func parseDirectives(conf string) ([]*Directive, error) {
+	toks, err := tokenize(conf)
+	if err != nil {
+		return nil, err
+	}
+	root := &Directive{}
+	stack := []*Directive{root}
+	var cur *Directive
+	for _, tok := range toks {
+		parent := stack[len(stack)-1]
+		switch {
+		case tok.quoted:
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected string", tok.line)
+			}
+			cur.Args = append(cur.Args, tok.text)
+		case tok.text == ";":
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected ;", tok.line)
+			}
+			parent.Block = append(parent.Block, cur)
+			cur = nil
+		case tok.text == "{":
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected {", tok.line)
+			}
+			parent.Block = append(parent.Block, cur)
+			stack = append(stack, cur)
+			cur = nil
+		case tok.text == "}":
+			if cur != nil || len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: unexpected }", tok.line)
+			}
+			stack = stack[:len(stack)-1]
+		case cur == nil:
+			cur = &Directive{Name: tok.text, Line: tok.line}
+		default:
+			cur.Args = append(cur.Args, tok.text)
+		}
+	}
+	if cur != nil || len(stack) != 1 {
+		return nil, fmt.Errorf("unexpected end of configuration")
+	}
+	return root.Block, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+func tokenize(conf string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(conf); {
+		c := conf[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(conf) && conf[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			toks = append(toks, token{text: string(c), line: line})
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			start := line
+			for i++; i < len(conf) && conf[i] != c; i++ {
+				if conf[i] == '\\' && i+1 < len(conf) {
+					i++
+				}
+				if conf[i] == '\n' {
+					line++
+				}
+				b.WriteByte(conf[i])
+			}
+			if i == len(conf) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			i++
+			toks = append(toks, token{text: b.String(), quoted: true, line: start})
+		default:
+			j := i
+			for j < len(conf) && !strings.ContainsRune(" \t\r\n;{}#", rune(conf[j])) {
+				j++
+			}
+			toks = append(toks, token{text: conf[i:j], line: line})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// Server returns the server that handles a request to host on port: the one
+// with a matching server_name, else the default server for the port, else
+// the first server listening on it.
+func (c *Config) Server(host string, port int) *Server {
+	host = strings.ToLower(host)
+	var first, def *Server
+	for _, s := range c.Servers {
+		l, ok := s.listens(port)
+		if !ok {
+			continue
+		}
+		for _, n := range s.Names {
+			if strings.ToLower(n) == host || (strings.HasPrefix(n, "*.") && strings.HasSuffix(host, n[1:])) {
+				return s
+			}
+		}
+		if first == nil {
+			first = s
+		}
+		if l.Default && def == nil {
+			def = s
+		}
+	}
+	if def != nil {
+		return def
+	}
+	return first
+}
+
+func (s *Server) listens(port int) (Listen, bool) {
+	for _, l := range s.Listen {
+		if l.Port == port {
+			return l, true
+		}
+	}
+	return Listen{}, false
+}
+
+// Location returns the location that handles a request path, following
+// nginx's order: an exact match, then the longest prefix if it is marked ^~,
+// then the first matching regular expression, then the longest prefix.
+func (s *Server) Location(path string) *Location {
+	var longest *Location
+	for _, l := range s.Locations {
+		switch l.Match {
+		case "=":
+			if path == l.Pattern {
+				return l
+			}
+		case "", "^~":
+			if strings.HasPrefix(path, l.Pattern) && (longest == nil || len(l.Pattern) > len(longest.Pattern)) {
+				longest = l
+			}
+		}
+	}
+	if longest != nil && longest.Match == "^~" {
+		return longest
+	}
+	for _, l := range s.Locations {
+		if l.re != nil && l.re.MatchString(path) {
+			return l
+		}
+	}
+	return longest
+}
+
+// UpstreamURL returns the URL a request for path?query is proxied to. When
+// proxy_pass has a URI, the part of the path matching a prefix location is
+// replaced with it; otherwise the path is passed unchanged.
+func (l *Location) UpstreamURL(path, query string) *url.URL {
+	u := *l.ProxyPass
+	u.RawQuery = query
+	if u.Path == "" || l.re != nil {
+		u.Path = path
+		return &u
+	}
+	u.Path = l.ProxyPass.Path + strings.TrimPrefix(path, l.Pattern)
+	return &u
+}
+
+// Route is a row of the routing table.
+type Route struct {
+	Server      string // server names, or "_" for a nameless server
+	Listen      string
+	Location    string
+	Upstream    string // proxy_pass, or "return <code>"
+	Headers     []string
+	ReadTimeout time.Duration
+	MaxBodySize int64
+	Buffering   bool
+}
+
+// Routes returns the routing table, one row per location, sorted by server
+// and location.
+func (c *Config) Routes() []Route {
+	var out []Route
+	for _, s := range c.Servers {
+		name := strings.Join(s.Names, " ")
+		if name == "" {
+			name = "_"
+		}
+		var listen []string
+		for _, l := range s.Listen {
+			v := strconv.Itoa(l.Port)
+			if l.SSL {
+				v += " ssl"
+			}
+			if l.Default {
+				v += " default_server"
+			}
+			listen = append(listen, v)
+		}
+		if s.Return != 0 || len(s.Locations) == 0 {
+			out = append(out, Route{Server: name, Listen: strings.Join(listen, ", "), Upstream: fmt.Sprintf("return %d", s.Return)})
+			continue
+		}
+		for _, l := range s.Locations {
+			r := Route{
+				Server:      name,
+				Listen:      strings.Join(listen, ", "),
+				Location:    strings.TrimSpace(l.Match + " " + l.Pattern),
+				ReadTimeout: l.ReadTimeout,
+				MaxBodySize: l.MaxBodySize,
+				Buffering:   l.Buffering,
+			}
+			if l.ProxyPass != nil {
+				r.Upstream = l.ProxyPass.String()
+			} else {
+				r.Upstream = fmt.Sprintf("return %d", l.Return)
+			}
+			for _, h := range l.Headers {
+				r.Headers = append(r.Headers, h.Name+": "+h.Value)
+			}
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Server != out[j].Server {
+			return out[i].Server < out[j].Server
+		}
+		return out[i].Location < out[j].Location
+	})
+	return out
+}
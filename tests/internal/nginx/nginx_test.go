@@ -0,0 +1,344 @@
+package nginx
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleConf routes api.example.com to two upstreams and drops every other
+// host. Headers set at the server level apply only to locations that set
+// none of their own.
+const sampleConf = `
+server {
+    listen 443 ssl;
+    server_name api.example.com;
+    client_max_body_size 16k;
+    proxy_set_header X-Server "api";  # inherited by /static/ only
+
+    location / {
+        proxy_pass https://backend.internal/v1/;
+        proxy_ssl_server_name on;
+        proxy_set_header Host $proxy_host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_read_timeout 2s;
+    }
+    location /stream/ {
+        proxy_pass https://backend.internal;
+        proxy_buffering off;
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection "upgrade";
+        proxy_read_timeout 1h;
+    }
+    location = /health {
+        return 204;
+    }
+    location ~* \.(png|css)$ {
+        return 403;
+    }
+    location ^~ /static/ {
+        proxy_pass https://assets.internal/;
+        client_max_body_size 0;
+    }
+}
+server {
+    listen 443 ssl default_server;
+    return 444;
+}
+`
+
+func loadSample(t *testing.T) *Config {
+	t.Helper()
+	c, err := Parse(sampleConf)
+	require.NoError(t, err)
+	return c
+}
+
+func TestParse_BuildsRoutingTable(t *testing.T) {
+	t.Parallel()
+
+	c := loadSample(t)
+	routes := c.Routes()
+	require.Len(t, routes, 6)
+	assert.Equal(t, Route{Server: "_", Listen: "443 ssl default_server", Upstream: "return 444"}, routes[0])
+
+	byLocation := map[string]Route{}
+	for _, r := range routes[1:] {
+		assert.Equal(t, "api.example.com", r.Server)
+		byLocation[r.Location] = r
+	}
+	root := byLocation["/"]
+	assert.Equal(t, "https://backend.internal/v1/", root.Upstream)
+	assert.Equal(t, []string{"Host: $proxy_host", "X-Real-IP: $remote_addr", "X-Forwarded-Proto: $scheme"}, root.Headers)
+	assert.Equal(t, 2*time.Second, root.ReadTimeout)
+	assert.Equal(t, int64(16<<10), root.MaxBodySize)
+	assert.True(t, root.Buffering)
+
+	stream := byLocation["/stream/"]
+	assert.Equal(t, time.Hour, stream.ReadTimeout)
+	assert.False(t, stream.Buffering)
+	assert.Equal(t, []string{"X-Server: api"}, byLocation["^~ /static/"].Headers)
+	assert.Equal(t, int64(0), byLocation["^~ /static/"].MaxBodySize)
+	assert.Equal(t, "return 204", byLocation["= /health"].Upstream)
+
+	_, err := Parse("server { location / { proxy_set_header X-Id $request_id; } }")
+	assert.ErrorContains(t, err, "unsupported variable $request_id")
+	_, err = Parse("server { listen 80 }")
+	assert.Error(t, err)
+}
+
+func TestConfig_SelectsServerAndLocation(t *testing.T) {
+	t.Parallel()
+
+	c := loadSample(t)
+	api := c.Server("API.example.com", 443)
+	require.NotNil(t, api)
+	assert.Equal(t, 444, c.Server("other.example.com", 443).Return)
+	assert.Nil(t, c.Server("api.example.com", 80))
+
+	testCases := []struct {
+		path     string
+		location string
+		upstream string
+	}{
+		{"/chat?x=1", "/", "https://backend.internal/v1/chat?x=1"},
+		{"/stream/events", "/stream/", "https://backend.internal/stream/events?x=1"},
+		{"/health", "= /health", ""},
+		{"/health/deep", "/", "https://backend.internal/v1/health/deep?x=1"},
+		{"/logo.PNG", "~* \\.(png|css)$", ""},
+		{"/static/logo.png", "^~ /static/", "https://assets.internal/logo.png?x=1"},
+	}
+	for _, tc := range testCases {
+		path, _, _ := strings.Cut(tc.path, "?")
+		loc := api.Location(path)
+		require.NotNil(t, loc, tc.path)
+		assert.Equal(t, tc.location, strings.TrimSpace(loc.Match+" "+loc.Pattern), tc.path)
+		if tc.upstream != "" {
+			assert.Equal(t, tc.upstream, loc.UpstreamURL(path, "x=1").String(), tc.path)
+		}
+	}
+}
+
+// upstream is a TLS server standing in for every proxy_pass host.
+type upstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	sni      []string
+}
+
+func newUpstream(t *testing.T, h http.HandlerFunc) *upstream {
+	u := &upstream{}
+	u.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.requests = append(u.requests, r)
+		u.sni = append(u.sni, r.TLS.ServerName)
+		u.mu.Unlock()
+		h(w, r)
+	}))
+	u.StartTLS()
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *upstream) last() (*http.Request, string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[len(u.requests)-1], u.sni[len(u.sni)-1]
+}
+
+func newEmulator(t *testing.T, c *Config, up *upstream) (*Emulator, *http.Client, string) {
+	e := &Emulator{
+		Config:    c,
+		TimeScale: 0.01,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, up.Listener.Addr().String())
+		},
+	}
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return e, srv.Client(), srv.URL
+}
+
+func TestEmulator_ProxiesWithRewriteAndHeaders(t *testing.T) {
+	t.Parallel()
+
+	up := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Path", r.URL.RequestURI())
+		_, _ = io.WriteString(w, "ok")
+	})
+	e, client, base := newEmulator(t, loadSample(t), up)
+
+	req, _ := http.NewRequest(http.MethodPost, base+"/chat/completions?stream=false", strings.NewReader(`{}`))
+	req.Host = "api.example.com"
+	req.Header.Set("Authorization", "Bearer k")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, "/v1/chat/completions?stream=false", resp.Header.Get("X-Upstream-Path"))
+
+	got, sni := up.last()
+	assert.Equal(t, "backend.internal", got.Host)
+	assert.Equal(t, "backend.internal", sni)
+	assert.Equal(t, "Bearer k", got.Header.Get("Authorization"), "client headers are passed")
+	assert.Equal(t, "127.0.0.1", got.Header.Get("X-Real-IP"))
+	assert.Equal(t, "https", got.Header.Get("X-Forwarded-Proto"))
+	assert.Empty(t, got.Header.Get("Upgrade"), "hop-by-hop headers are dropped")
+	assert.Empty(t, got.Header.Get("X-Server"), "server-level headers are replaced by the location's")
+
+	// Without proxy_ssl_server_name no SNI is sent.
+	req, _ = http.NewRequest(http.MethodGet, base+"/stream/x", nil)
+	req.Host = "api.example.com"
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	got, sni = up.last()
+	assert.Equal(t, "", sni)
+	assert.Equal(t, "upgrade", got.Header.Get("Connection"))
+	assert.Empty(t, got.Header.Get("Upgrade"), "empty header values are not sent")
+
+	log := e.Log()
+	require.Len(t, log, 2)
+	assert.Equal(t, "https://backend.internal/v1/chat/completions?stream=false", log[0].Upstream)
+}
+
+func TestEmulator_ReturnsAndLimits(t *testing.T) {
+	t.Parallel()
+
+	up := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, client, base := newEmulator(t, loadSample(t), up)
+	do := func(host, path string, body string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodPost, base+path, strings.NewReader(body))
+		req.Host = host
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	_, err := do("unknown.example.com", "/", "")
+	assert.Error(t, err, "444 closes the connection")
+
+	resp, err := do("api.example.com", "/health", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = do("api.example.com", "/chat", strings.Repeat("x", 16<<10+1))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, err = do("api.example.com", "/static/upload", strings.Repeat("x", 64<<10))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "client_max_body_size 0 disables the limit")
+}
+
+func TestEmulator_ReadTimeoutAndStreaming(t *testing.T) {
+	t.Parallel()
+
+	var released atomic.Bool
+	release := make(chan struct{})
+	up := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/slow", "/stream/slow":
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+		}
+		_, _ = io.WriteString(w, "data: 2\n\n")
+	})
+	_, client, base := newEmulator(t, loadSample(t), up)
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		req.Host = "api.example.com"
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// 2s scaled to 20ms is too short for the slow upstream; 1h is not.
+	resp := get("/slow")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	// With buffering off, the first event arrives before the upstream
+	// finishes.
+	resp = get("/stream/slow")
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: 1\n", line)
+	released.Store(true)
+	close(release)
+	rest, _ := io.ReadAll(r)
+	resp.Body.Close()
+	assert.Equal(t, "\ndata: 2\n\n", string(rest))
+
+	// With buffering on, nothing is sent until the upstream is done, which
+	// here is after the read timeout.
+	resp = get("/events")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, released.Load())
+	assert.Equal(t, fmt.Sprint(len("data: 1\n\ndata: 2\n\n")), resp.Header.Get("Content-Length"))
+}
+
+func TestEmulator_TunnelsUpgrades(t *testing.T) {
+	t.Parallel()
+
+	up := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		line, _ := buf.ReadString('\n')
+		_, _ = io.WriteString(conn, line)
+	})
+	_, _, base := newEmulator(t, loadSample(t), up)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /stream/ws HTTP/1.1\r\nHost: api.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+}
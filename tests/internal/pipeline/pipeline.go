@@ -0,0 +1,369 @@
+// Package pipeline builds the event-driven delivery graph of a Terraform
+// tree: S3 bucket notifications and EventBridge targets into Lambda
+// functions, SQS queues and SNS topics, and what Lambda does with the
+// asynchronous invocations that fail. It checks that every edge into a
+// function is permitted, that the notifications of a bucket do not
+// overlap, and that every asynchronous path ends in a dead-letter queue or
+// an on-failure destination.
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/eventbridge"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Edge kinds.
+const (
+	ViaNotification = "s3-notification"
+	ViaEventTarget  = "event-target"
+	// ViaPermission is an invoker outside the tree, known only from an
+	// aws_lambda_permission for an asynchronous service, such as a bucket
+	// whose notification is managed elsewhere.
+	ViaPermission = "permission"
+	ViaDeadLetter = "dead-letter"
+	ViaOnFailure  = "on-failure"
+	ViaOnSuccess  = "on-success"
+	// ViaEventSource is an event source mapping: the function polls the
+	// queue.
+	ViaEventSource = "event-source"
+)
+
+// asyncPrincipals are the services that invoke Lambda functions
+// asynchronously, by the edge kinds they deliver through.
+var asyncPrincipals = map[string]string{
+	"s3.amazonaws.com":     ViaNotification,
+	"events.amazonaws.com": ViaEventTarget,
+	"sns.amazonaws.com":    "",
+}
+
+// Edge is a delivery from a source to a destination.
+type Edge struct {
+	// From and To are node IDs, e.g.
+	// "environments/app-layer/bedrock-rag:aws_s3_bucket.rtl_codes", or the
+	// source or destination ARN when it is not a resource of the tree.
+	From string
+	To   string
+	Via  string
+	// Owner is the node ID of the resource that declares the edge, and Block
+	// the block within it: a lambda_function, queue or topic block of a
+	// notification, an event target, a permission, a dead_letter_config, or
+	// an on_failure or on_success block of an event invoke config.
+	Owner string
+	Block *tfconfig.Block
+
+	// Events, Prefix and Suffix filter the objects of S3 notifications.
+	Events []string
+	Prefix string
+	Suffix string
+}
+
+func (e *Edge) String() string {
+	return fmt.Sprintf("%s -[%s]-> %s", e.From, e.Via, e.To)
+}
+
+// Permission is an aws_lambda_permission.
+type Permission struct {
+	ID        string
+	Functions []tfconfig.Node
+	Principal string
+	// SourceARN is the source_arn with unknown references kept symbolic, or
+	// "" when the permission is not limited to a source.
+	SourceARN string
+	Sources   []tfconfig.Node // the nodes source_arn resolves to
+	Block     *tfconfig.Block
+}
+
+// Notification is an aws_s3_bucket_notification.
+type Notification struct {
+	ID     string
+	Bucket string // node ID of the bucket, or its name when not a resource of the tree
+	Edges  []*Edge
+	Block  *tfconfig.Block
+}
+
+// Graph is the delivery graph of a tree.
+type Graph struct {
+	Edges         []*Edge
+	Notifications []*Notification
+	Permissions   []*Permission
+	Queues        []string // node IDs of the SQS queues
+
+	// Unresolved lists edges whose destination is not statically known.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+
+	blocks        map[string]*tfconfig.Block // resources by node ID
+	invokeConfigs map[string]*tfconfig.Block // event invoke configs by function
+}
+
+// Build collects the notifications, event targets, permissions, queues,
+// event source mappings and failure handling of every module in the tree.
+func Build(tree *tfconfig.Tree) *Graph {
+	g := &Graph{Tree: tree, Refs: tree.RefGraph(), blocks: map[string]*tfconfig.Block{}, invokeConfigs: map[string]*tfconfig.Block{}}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("")) {
+			g.blocks[nodeID(m, b)] = b
+		}
+		g.collectNotifications(m)
+		g.collectPermissions(m)
+		g.collectFailureHandling(m)
+		g.collectQueues(m)
+		g.collectEventSources(m)
+	}
+	for _, t := range eventbridge.Build(tree).Targets {
+		for _, d := range t.Destinations {
+			g.Edges = append(g.Edges, &Edge{From: t.Rule.ID, To: d.String(), Via: ViaEventTarget, Owner: t.ID, Block: t.Block})
+		}
+	}
+	g.collectExternalInvokers()
+	sort.SliceStable(g.Edges, func(i, j int) bool { return g.Edges[i].From < g.Edges[j].From })
+	sort.Strings(g.Queues)
+	sort.Strings(g.Unresolved)
+	return g
+}
+
+// notificationTargets are the blocks of a notification, with the
+// attribute naming their destination.
+var notificationTargets = []struct {
+	block, attr, kind string
+}{
+	{"lambda_function", "lambda_function_arn", "aws_lambda_function"},
+	{"queue", "queue_arn", "aws_sqs_queue"},
+	{"topic", "topic_arn", "aws_sns_topic"},
+}
+
+func (g *Graph) collectNotifications(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_s3_bucket_notification")) {
+		n := &Notification{ID: nodeID(m, b), Bucket: g.target(m, b, "bucket", "aws_s3_bucket"), Block: b}
+		for _, nt := range notificationTargets {
+			for _, nb := range b.Nested(nt.block) {
+				e := &Edge{From: n.Bucket, To: g.target(m, nb, nt.attr, nt.kind), Via: ViaNotification, Owner: n.ID, Block: nb}
+				e.Events, _ = nb.Strings("events")
+				e.Prefix, _ = nb.String("filter_prefix")
+				e.Suffix, _ = nb.String("filter_suffix")
+				if e.To == "" {
+					g.Unresolved = append(g.Unresolved, fmt.Sprintf("%s: %s.%s is not statically known", n.ID, nt.block, nt.attr))
+					continue
+				}
+				n.Edges = append(n.Edges, e)
+				g.Edges = append(g.Edges, e)
+			}
+		}
+		g.Notifications = append(g.Notifications, n)
+	}
+}
+
+func (g *Graph) collectPermissions(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_permission")) {
+		p := &Permission{ID: nodeID(m, b), Block: b}
+		p.Principal, _ = b.String("principal")
+		if s, ok := m.Symbolic(b.Expr("source_arn")).(string); ok {
+			p.SourceARN = s
+		}
+		p.Sources = g.Refs.ResolveExpr(m, b.Expr("source_arn"))
+		for _, n := range g.Refs.ResolveExpr(m, b.Expr("function_name")) {
+			if n.Kind() == "aws_lambda_function" {
+				p.Functions = append(p.Functions, n)
+			}
+		}
+		g.Permissions = append(g.Permissions, p)
+	}
+}
+
+// collectFailureHandling adds the dead_letter_config of functions and the
+// destinations of event invoke configs.
+func (g *Graph) collectFailureHandling(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_function")) {
+		for _, dl := range b.Nested("dead_letter_config") {
+			g.addFailureEdge(m, nodeID(m, b), nodeID(m, b), ViaDeadLetter, dl, "target_arn")
+		}
+	}
+	for _, b := range instantiated(m.Resources("aws_lambda_function_event_invoke_config")) {
+		for _, fn := range g.Refs.ResolveExpr(m, b.Expr("function_name")) {
+			if fn.Kind() != "aws_lambda_function" {
+				continue
+			}
+			g.invokeConfigs[fn.String()] = b
+			for _, dc := range b.Nested("destination_config") {
+				for _, of := range dc.Nested("on_failure") {
+					g.addFailureEdge(m, fn.String(), nodeID(m, b), ViaOnFailure, of, "destination")
+				}
+				for _, os := range dc.Nested("on_success") {
+					g.addFailureEdge(m, fn.String(), nodeID(m, b), ViaOnSuccess, os, "destination")
+				}
+			}
+		}
+	}
+}
+
+func (g *Graph) addFailureEdge(m *tfconfig.Module, from, owner, via string, b *tfconfig.Block, attr string) {
+	to := g.target(m, b, attr, "")
+	if to == "" {
+		g.Unresolved = append(g.Unresolved, fmt.Sprintf("%s: %s %s is not statically known", from, via, attr))
+		return
+	}
+	g.Edges = append(g.Edges, &Edge{From: from, To: to, Via: via, Owner: owner, Block: b})
+}
+
+// collectQueues records the queues and their redrive policies, which are
+// dead-letter edges from queue to queue.
+func (g *Graph) collectQueues(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_sqs_queue")) {
+		id := nodeID(m, b)
+		g.Queues = append(g.Queues, id)
+		for _, n := range g.Refs.ResolveExpr(m, b.Expr("redrive_policy")) {
+			if n.Kind() == "aws_sqs_queue" {
+				g.Edges = append(g.Edges, &Edge{From: id, To: n.String(), Via: ViaDeadLetter, Owner: id, Block: b})
+			}
+		}
+	}
+}
+
+// collectEventSources adds an edge from every SQS queue a function polls.
+func (g *Graph) collectEventSources(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_event_source_mapping")) {
+		for _, q := range g.Refs.ResolveExpr(m, b.Expr("event_source_arn")) {
+			if q.Kind() != "aws_sqs_queue" {
+				continue
+			}
+			for _, fn := range g.Refs.ResolveExpr(m, b.Expr("function_name")) {
+				if fn.Kind() == "aws_lambda_function" {
+					g.Edges = append(g.Edges, &Edge{From: q.String(), To: fn.String(), Via: ViaEventSource, Owner: nodeID(m, b), Block: b})
+				}
+			}
+		}
+	}
+}
+
+// collectExternalInvokers adds an edge for every permission of an
+// asynchronous service that no edge of the tree needs and whose source is
+// not a resource of the tree: its invoker is configured outside the tree.
+func (g *Graph) collectExternalInvokers() {
+	var external []*Edge
+	for _, p := range g.Permissions {
+		via, ok := asyncPrincipals[p.Principal]
+		if !ok || len(p.Sources) > 0 {
+			continue
+		}
+		for _, fn := range p.Functions {
+			used := false
+			for _, e := range g.Edges {
+				used = used || (e.Via == via && e.To == fn.String() && g.covers(p, e))
+			}
+			if !used {
+				from := p.SourceARN
+				if from == "" {
+					from = p.Principal
+				}
+				external = append(external, &Edge{From: from, To: fn.String(), Via: ViaPermission, Owner: p.ID, Block: p.Block})
+			}
+		}
+	}
+	g.Edges = append(g.Edges, external...)
+}
+
+// target resolves an attribute to the node ID of a resource of the given
+// kind, or of any kind when kind is "". Values that are not resources of
+// the tree are returned as they are.
+func (g *Graph) target(m *tfconfig.Module, b *tfconfig.Block, attr, kind string) string {
+	for _, n := range g.Refs.ResolveExpr(m, b.Expr(attr)) {
+		if kind == "" || n.Kind() == kind {
+			return n.String()
+		}
+	}
+	if !b.Has(attr) {
+		return ""
+	}
+	s, _ := m.Symbolic(b.Expr(attr)).(string)
+	if strings.Contains(s, "${") {
+		return ""
+	}
+	return s
+}
+
+// covers reports whether a permission lets the source of an edge invoke
+// its destination: it names the source, or is open to any source. A
+// literal source ARN of a bucket names it by its bucket attribute.
+func (g *Graph) covers(p *Permission, e *Edge) bool {
+	found := false
+	for _, fn := range p.Functions {
+		found = found || fn.String() == e.To
+	}
+	if !found {
+		return false
+	}
+	if p.SourceARN == "" {
+		return true
+	}
+	for _, n := range p.Sources {
+		if n.String() == e.From {
+			return true
+		}
+	}
+	return p.SourceARN == g.arn(e.From)
+}
+
+// arn returns the literal ARN of a bucket, or "".
+func (g *Graph) arn(id string) string {
+	if strings.HasPrefix(id, "arn:") {
+		return id
+	}
+	if b := g.blocks[id]; b != nil && Kind(id) == "aws_s3_bucket" {
+		if name, ok := b.String("bucket"); ok {
+			return "arn:aws:s3:::" + name
+		}
+	}
+	return ""
+}
+
+// Kind returns the resource type of a node ID, or "" for an ARN or a name.
+func Kind(id string) string {
+	if strings.HasPrefix(id, "arn:") || !strings.Contains(id, ":") {
+		return ""
+	}
+	addr := id[strings.LastIndex(id, ":")+1:]
+	return strings.SplitN(addr, ".", 2)[0]
+}
+
+// To returns the edges entering a node.
+func (g *Graph) To(id string) []*Edge {
+	var out []*Edge
+	for _, e := range g.Edges {
+		if e.To == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// From returns the edges leaving a node.
+func (g *Graph) From(id string) []*Edge {
+	var out []*Edge
+	for _, e := range g.Edges {
+		if e.From == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
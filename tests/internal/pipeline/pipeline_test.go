@@ -0,0 +1,431 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// samplePipeline has an uploads bucket that notifies an indexer with a
+// dead-letter queue, a thumbnailer whose prefix overlaps the indexer's and
+// whose permission names another bucket, and an audit queue with a redrive
+// policy. The thumbnailer sends failures to a cleanup function that has no
+// dead-letter queue. A reports bucket is managed by two notifications and
+// invokes a summarizer without a permission. A nightly rule invokes a
+// reporter whose on-failure destination is a bucket, and a partner bucket
+// outside the tree invokes a sync function.
+const samplePipeline = `
+resource "aws_s3_bucket" "uploads" {
+  bucket = "uploads"
+}
+
+resource "aws_s3_bucket" "other" {
+  bucket = "other"
+}
+
+resource "aws_s3_bucket" "reports" {
+  bucket = "reports"
+}
+
+resource "aws_s3_bucket_notification" "uploads" {
+  bucket = aws_s3_bucket.uploads.id
+
+  lambda_function {
+    lambda_function_arn = aws_lambda_function.indexer.arn
+    events              = ["s3:ObjectCreated:*"]
+    filter_prefix       = "docs/"
+    filter_suffix       = ".pdf"
+  }
+
+  lambda_function {
+    lambda_function_arn = aws_lambda_function.thumbnailer.arn
+    events              = ["s3:ObjectCreated:Put"]
+    filter_prefix       = "docs/images/"
+  }
+
+  queue {
+    queue_arn     = aws_sqs_queue.audit.arn
+    events        = ["s3:ObjectCreated:*"]
+    filter_prefix = "logs/"
+  }
+}
+
+resource "aws_s3_bucket_notification" "reports" {
+  bucket = aws_s3_bucket.reports.id
+
+  lambda_function {
+    lambda_function_arn = aws_lambda_function.summarizer.arn
+    events              = ["s3:ObjectCreated:*"]
+  }
+}
+
+resource "aws_s3_bucket_notification" "reports_extra" {
+  bucket = aws_s3_bucket.reports.id
+
+  queue {
+    queue_arn = aws_sqs_queue.audit.arn
+    events    = ["s3:ObjectRemoved:*"]
+  }
+}
+
+resource "aws_lambda_function" "indexer" {
+  function_name = "indexer"
+
+  dead_letter_config {
+    target_arn = aws_sqs_queue.indexer_dlq.arn
+  }
+}
+
+resource "aws_lambda_permission" "indexer" {
+  function_name = aws_lambda_function.indexer.function_name
+  principal     = "s3.amazonaws.com"
+  source_arn    = aws_s3_bucket.uploads.arn
+}
+
+resource "aws_lambda_function" "thumbnailer" {
+  function_name = "thumbnailer"
+}
+
+resource "aws_lambda_permission" "thumbnailer" {
+  function_name = aws_lambda_function.thumbnailer.function_name
+  principal     = "s3.amazonaws.com"
+  source_arn    = aws_s3_bucket.other.arn
+}
+
+resource "aws_lambda_function_event_invoke_config" "thumbnailer" {
+  function_name = aws_lambda_function.thumbnailer.function_name
+
+  destination_config {
+    on_failure {
+      destination = aws_lambda_function.cleanup.arn
+    }
+  }
+}
+
+resource "aws_lambda_function" "cleanup" {
+  function_name = "cleanup"
+}
+
+resource "aws_lambda_function" "summarizer" {
+  function_name = "summarizer"
+}
+
+resource "aws_sqs_queue" "indexer_dlq" {
+  name = "indexer-dlq"
+}
+
+resource "aws_sqs_queue" "audit" {
+  name = "audit"
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.audit_dlq.arn
+    maxReceiveCount     = 3
+  })
+}
+
+resource "aws_sqs_queue" "audit_dlq" {
+  name = "audit-dlq"
+}
+
+resource "aws_cloudwatch_event_rule" "nightly" {
+  schedule_expression = "cron(0 18 * * ? *)"
+}
+
+resource "aws_cloudwatch_event_target" "reporter" {
+  rule = aws_cloudwatch_event_rule.nightly.name
+  arn  = aws_lambda_function.reporter.arn
+}
+
+resource "aws_lambda_function" "reporter" {
+  function_name = "reporter"
+}
+
+resource "aws_lambda_permission" "reporter" {
+  function_name = aws_lambda_function.reporter.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.nightly.arn
+}
+
+resource "aws_lambda_function_event_invoke_config" "reporter" {
+  function_name = aws_lambda_function.reporter.function_name
+
+  destination_config {
+    on_failure {
+      destination = aws_s3_bucket.reports.arn
+    }
+  }
+}
+
+resource "aws_lambda_function" "partner_sync" {
+  function_name = "partner-sync"
+}
+
+resource "aws_lambda_permission" "partner_sync" {
+  function_name = aws_lambda_function.partner_sync.function_name
+  principal     = "s3.amazonaws.com"
+  source_arn    = "arn:aws:s3:::partner-drop"
+}
+`
+
+// sampleQueues has a jobs queue that a worker polls, with a visibility
+// timeout tied to a variable timeout, a low maxReceiveCount and a
+// dead-letter queue that keeps messages no longer than it does under
+// another key. A sender function's dead-letter queue uses SSE-SQS and
+// expires messages with its maximum event age, and one queue has a
+// visibility timeout that is not statically known.
+const sampleQueues = `
+variable "timeout" {
+  default = 60
+}
+
+variable "unset" {}
+
+resource "aws_kms_key" "project" {}
+
+resource "aws_kms_key" "other" {}
+
+resource "aws_lambda_function" "worker" {
+  function_name = "worker"
+  timeout       = var.timeout
+}
+
+resource "aws_lambda_event_source_mapping" "jobs" {
+  event_source_arn = aws_sqs_queue.jobs.arn
+  function_name    = aws_lambda_function.worker.arn
+}
+
+resource "aws_sqs_queue" "jobs" {
+  visibility_timeout_seconds = 300
+  message_retention_seconds  = 1209600
+  kms_master_key_id          = aws_kms_key.project.arn
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.jobs_dlq.arn
+    maxReceiveCount     = 2
+  })
+}
+
+resource "aws_sqs_queue" "jobs_dlq" {
+  message_retention_seconds = 1209600
+  kms_master_key_id         = aws_kms_key.other.arn
+}
+
+resource "aws_lambda_function" "sender" {
+  function_name = "sender"
+  timeout       = 900
+
+  dead_letter_config {
+    target_arn = aws_sqs_queue.sender_dlq.arn
+  }
+}
+
+resource "aws_lambda_function_event_invoke_config" "sender" {
+  function_name                = aws_lambda_function.sender.function_name
+  maximum_event_age_in_seconds = 3600
+}
+
+resource "aws_sqs_queue" "sender_dlq" {
+  visibility_timeout_seconds = 5400
+  message_retention_seconds  = 3600
+  sqs_managed_sse_enabled    = true
+}
+
+resource "aws_sqs_queue" "unknown" {
+  visibility_timeout_seconds = var.unset
+  kms_master_key_id          = aws_kms_key.project.id
+}
+`
+
+func load(t *testing.T, src string) (*tfconfig.Tree, *Graph) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(src), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	g := Build(tree)
+	require.Empty(t, g.Unresolved)
+	return tree, g
+}
+
+func loadSample(t *testing.T) *Graph {
+	t.Helper()
+	_, g := load(t, samplePipeline)
+	require.Len(t, g.Notifications, 3)
+	return g
+}
+
+func TestBuild_CollectsEdges(t *testing.T) {
+	t.Parallel()
+
+	g := loadSample(t)
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.String())
+	}
+	assert.Equal(t, []string{
+		"arn:aws:s3:::partner-drop -[permission]-> environments/app:aws_lambda_function.partner_sync",
+		"environments/app:aws_cloudwatch_event_rule.nightly -[event-target]-> environments/app:aws_lambda_function.reporter",
+		"environments/app:aws_lambda_function.indexer -[dead-letter]-> environments/app:aws_sqs_queue.indexer_dlq",
+		"environments/app:aws_lambda_function.reporter -[on-failure]-> environments/app:aws_s3_bucket.reports",
+		"environments/app:aws_lambda_function.thumbnailer -[on-failure]-> environments/app:aws_lambda_function.cleanup",
+		"environments/app:aws_s3_bucket.reports -[s3-notification]-> environments/app:aws_lambda_function.summarizer",
+		"environments/app:aws_s3_bucket.reports -[s3-notification]-> environments/app:aws_sqs_queue.audit",
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_lambda_function.indexer",
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_lambda_function.thumbnailer",
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_sqs_queue.audit",
+		"environments/app:aws_sqs_queue.audit -[dead-letter]-> environments/app:aws_sqs_queue.audit_dlq",
+	}, edges)
+
+	var uploads *Notification
+	for _, n := range g.Notifications {
+		if n.ID == "environments/app:aws_s3_bucket_notification.uploads" {
+			uploads = n
+		}
+	}
+	require.NotNil(t, uploads)
+	require.Len(t, uploads.Edges, 3)
+	assert.Equal(t, []string{"s3:ObjectCreated:*"}, uploads.Edges[0].Events)
+	assert.Equal(t, "docs/", uploads.Edges[0].Prefix)
+	assert.Equal(t, ".pdf", uploads.Edges[0].Suffix)
+	assert.Equal(t, uploads.ID, uploads.Edges[0].Owner)
+
+	assert.Equal(t, []string{
+		"environments/app:aws_sqs_queue.audit",
+		"environments/app:aws_sqs_queue.audit_dlq",
+		"environments/app:aws_sqs_queue.indexer_dlq",
+	}, g.Queues)
+	assert.Equal(t, "aws_lambda_function", Kind("environments/app/module.x:aws_lambda_function.f"))
+	assert.Equal(t, "", Kind("arn:aws:sqs:us-east-1:123456789012:q"))
+}
+
+func TestPaths(t *testing.T) {
+	t.Parallel()
+
+	g := loadSample(t)
+	handled := map[string]bool{}
+	for _, p := range g.Paths() {
+		handled[p.String()] = p.Handled()
+	}
+	assert.Equal(t, map[string]bool{
+		"arn:aws:s3:::partner-drop -[permission]-> environments/app:aws_lambda_function.partner_sync":                                                                               false,
+		"environments/app:aws_cloudwatch_event_rule.nightly -[event-target]-> environments/app:aws_lambda_function.reporter -[on-failure]-> environments/app:aws_s3_bucket.reports": true,
+		"environments/app:aws_s3_bucket.reports -[s3-notification]-> environments/app:aws_lambda_function.summarizer":                                                               false,
+		"environments/app:aws_s3_bucket.reports -[s3-notification]-> environments/app:aws_sqs_queue.audit":                                                                          true,
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_lambda_function.indexer -[dead-letter]-> environments/app:aws_sqs_queue.indexer_dlq":      true,
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_lambda_function.thumbnailer -[on-failure]-> environments/app:aws_lambda_function.cleanup": false,
+		"environments/app:aws_s3_bucket.uploads -[s3-notification]-> environments/app:aws_sqs_queue.audit":                                                                          true,
+	}, handled)
+}
+
+func TestWiring(t *testing.T) {
+	t.Parallel()
+
+	g := loadSample(t)
+	var keys []string
+	for _, f := range g.Wiring() {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		"environments/app:aws_s3_bucket_notification.reports permission",
+		"environments/app:aws_s3_bucket_notification.uploads source-arn",
+		"environments/app:aws_s3_bucket_notification.reports_extra overlap",
+		"environments/app:aws_s3_bucket_notification.uploads overlap",
+		"environments/app:aws_lambda_function.partner_sync dead-letter",
+		"environments/app:aws_lambda_function.summarizer dead-letter",
+		"environments/app:aws_lambda_function.cleanup dead-letter",
+		"environments/app:aws_lambda_function_event_invoke_config.reporter destination",
+	}, keys)
+
+	assert.Contains(t, g.Wiring(), finding.Finding{
+		Subject: "environments/app:aws_s3_bucket_notification.uploads",
+		Check:   CheckOverlap,
+		Detail: "the configurations for environments/app:aws_lambda_function.indexer and environments/app:aws_lambda_function.thumbnailer " +
+			`on environments/app:aws_s3_bucket.uploads overlap (prefix "docs/"/"docs/images/", suffix ".pdf"/"")`,
+	})
+
+	// A permission open to any source covers every bucket.
+	for _, p := range g.Permissions {
+		if p.ID == "environments/app:aws_lambda_permission.thumbnailer" {
+			p.SourceARN = ""
+		}
+	}
+	for _, f := range g.Wiring() {
+		assert.NotEqual(t, CheckSourceARN, f.Check, f.String())
+	}
+}
+
+func TestEventMatches(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, eventMatches("s3:ObjectCreated:*", "s3:ObjectCreated:Put"))
+	assert.True(t, eventMatches("s3:ObjectCreated:Put", "s3:ObjectCreated:Put"))
+	assert.False(t, eventMatches("s3:ObjectCreated:Put", "s3:ObjectCreated:*"))
+	assert.False(t, eventsOverlap([]string{"s3:ObjectCreated:*"}, []string{"s3:ObjectRemoved:*"}))
+	assert.True(t, affixOverlap("docs/", "docs/images/", func(s, p string) bool { return len(s) >= len(p) && s[:len(p)] == p }))
+	assert.False(t, affixOverlap("docs/", "logs/", func(s, p string) bool { return len(s) >= len(p) && s[:len(p)] == p }))
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	_, g := load(t, sampleQueues)
+	jobs := g.Queue("environments/app:aws_sqs_queue.jobs")
+	require.NotNil(t, jobs)
+	assert.Equal(t, &Queue{
+		ID:                "environments/app:aws_sqs_queue.jobs",
+		VisibilityTimeout: 300,
+		Retention:         1209600,
+		MaxReceiveCount:   2,
+		DeadLetter:        "environments/app:aws_sqs_queue.jobs_dlq",
+		Keys:              []string{"environments/app:aws_kms_key.project"},
+		Pollers:           []string{"environments/app:aws_lambda_function.worker"},
+	}, jobs)
+
+	dlq := g.Queue("environments/app:aws_sqs_queue.sender_dlq")
+	assert.True(t, dlq.ManagedSSE)
+	assert.Equal(t, []string{"environments/app:aws_lambda_function.sender"}, dlq.Consumers())
+	assert.Equal(t, []string{"visibility_timeout_seconds"}, g.Queue("environments/app:aws_sqs_queue.unknown").Unknown)
+	assert.Equal(t, DefaultVisibilityTimeout, g.Queue("environments/app:aws_sqs_queue.jobs_dlq").VisibilityTimeout)
+	assert.Nil(t, g.Queue("environments/app:aws_lambda_function.sender"))
+}
+
+func TestTuning(t *testing.T) {
+	t.Parallel()
+
+	tree, g := load(t, sampleQueues)
+	keys := func() []string {
+		var out []string
+		for _, f := range g.Tuning("environments/app:aws_kms_key.project") {
+			out = append(out, f.Key())
+		}
+		return out
+	}
+	assert.Equal(t, []string{
+		"environments/app:aws_sqs_queue.jobs visibility-timeout",
+		"environments/app:aws_sqs_queue.jobs max-receive-count",
+		"environments/app:aws_sqs_queue.jobs_dlq retention",
+		"environments/app:aws_sqs_queue.jobs_dlq encryption",
+		"environments/app:aws_sqs_queue.sender_dlq retention",
+		"environments/app:aws_sqs_queue.sender_dlq encryption",
+		"environments/app:aws_sqs_queue.unknown unknown-value",
+	}, keys())
+
+	assert.Contains(t, g.Tuning("environments/app:aws_kms_key.project"), finding.Finding{
+		Subject: "environments/app:aws_sqs_queue.jobs",
+		Check:   CheckVisibility,
+		Detail:  "the visibility timeout is 300s, less than 6 times the 60s timeout of environments/app:aws_lambda_function.worker",
+	})
+
+	// Settings are evaluated on every call, so a shorter timeout fixes the
+	// visibility timeout.
+	tree.Stack("environments/app").SetVars(map[string]cty.Value{"timeout": cty.NumberIntVal(50)})
+	assert.NotContains(t, keys(), "environments/app:aws_sqs_queue.jobs visibility-timeout")
+}
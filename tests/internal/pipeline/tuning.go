@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Checks reported by Tuning.
+const (
+	CheckVisibility   = "visibility-timeout"
+	CheckMaxReceive   = "max-receive-count"
+	CheckRetention    = "retention"
+	CheckEncryption   = "encryption"
+	CheckUnknownValue = "unknown-value"
+)
+
+// SQS and Lambda defaults and limits, in seconds where they are durations.
+const (
+	DefaultVisibilityTimeout = 30
+	DefaultRetention         = 345600 // 4 days
+	DefaultLambdaTimeout     = 3
+	DefaultMaximumEventAge   = 21600 // 6 hours
+
+	// VisibilityFactor is how many function timeouts a queue must hide a
+	// message for, so that a batch is not delivered again while the function
+	// and its retries are still processing it.
+	VisibilityFactor = 6
+	// MinReceiveCount is the lowest maxReceiveCount of a queue that a
+	// function polls: throttled batches count as receives, and fewer than 5
+	// send messages to the dead-letter queue that were never processed.
+	MinReceiveCount = 5
+	MaxReceiveCount = 1000
+)
+
+// Queue is the evaluated settings of an SQS queue. Values are evaluated
+// when the queue is looked up, so they follow Module.SetVars.
+type Queue struct {
+	ID                string
+	VisibilityTimeout int
+	Retention         int
+	MaxReceiveCount   int    // 0 without a redrive policy
+	DeadLetter        string // node ID of the redrive target, or ""
+	ManagedSSE        bool
+	Keys              []string // node IDs of the aws_kms_key kms_master_key_id resolves to
+	Unknown           []string // attributes that are set but not statically known
+
+	// Pollers are the functions with an event source mapping on the queue,
+	// and Producers the functions that send their failed events to it.
+	Pollers   []string
+	Producers []string
+}
+
+// Consumers returns the functions that process the messages of the queue:
+// its pollers or, for a dead-letter queue nothing polls, the functions whose
+// failed events are redriven from it.
+func (q *Queue) Consumers() []string {
+	if len(q.Pollers) > 0 {
+		return q.Pollers
+	}
+	return q.Producers
+}
+
+// Queue returns the evaluated settings of a queue, or nil.
+func (g *Graph) Queue(id string) *Queue {
+	b := g.blocks[id]
+	if b == nil || Kind(id) != "aws_sqs_queue" {
+		return nil
+	}
+	q := &Queue{ID: id}
+	q.VisibilityTimeout = q.int(b, "visibility_timeout_seconds", DefaultVisibilityTimeout)
+	q.Retention = q.int(b, "message_retention_seconds", DefaultRetention)
+	q.ManagedSSE, _ = b.Bool("sqs_managed_sse_enabled")
+	for _, n := range g.Refs.ResolveExpr(b.Module(), b.Expr("kms_master_key_id")) {
+		if n.Kind() == "aws_kms_key" {
+			q.Keys = append(q.Keys, n.String())
+		}
+	}
+	if b.Has("redrive_policy") {
+		q.MaxReceiveCount = q.redriveCount(b)
+	}
+	for _, e := range g.From(id) {
+		if e.Via == ViaDeadLetter {
+			q.DeadLetter = e.To
+		}
+	}
+	for _, e := range g.To(id) {
+		switch e.Via {
+		case ViaDeadLetter, ViaOnFailure:
+			if Kind(e.From) == "aws_lambda_function" {
+				q.Producers = append(q.Producers, e.From)
+			}
+		}
+	}
+	for _, e := range g.From(id) {
+		if e.Via == ViaEventSource {
+			q.Pollers = append(q.Pollers, e.To)
+		}
+	}
+	return q
+}
+
+func (q *Queue) int(b *tfconfig.Block, attr string, def int) int {
+	if !b.Has(attr) {
+		return def
+	}
+	n, ok := b.Int(attr)
+	if !ok {
+		q.Unknown = append(q.Unknown, attr)
+	}
+	return n
+}
+
+// redriveCount reads maxReceiveCount from a redrive policy written as a
+// jsonencode object or a JSON string.
+func (q *Queue) redriveCount(b *tfconfig.Block) int {
+	v := b.Partial("redrive_policy")
+	if s, ok := v.(string); ok {
+		_ = json.Unmarshal([]byte(s), &v)
+	}
+	policy, _ := v.(map[string]interface{})
+	switch n := policy["maxReceiveCount"].(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case string:
+		var i int
+		if _, err := fmt.Sscan(n, &i); err == nil {
+			return i
+		}
+	}
+	q.Unknown = append(q.Unknown, "redrive_policy.maxReceiveCount")
+	return 0
+}
+
+// timeout returns the evaluated timeout of a function, and whether it is
+// statically known.
+func (g *Graph) timeout(fn string) (int, bool) {
+	b := g.blocks[fn]
+	if b == nil {
+		return 0, false
+	}
+	if !b.Has("timeout") {
+		return DefaultLambdaTimeout, true
+	}
+	return b.Int("timeout")
+}
+
+// maximumEventAge returns how long Lambda keeps retrying an asynchronous
+// event of a function before it sends the event to its destination.
+func (g *Graph) maximumEventAge(fn string) (int, bool) {
+	b := g.invokeConfigs[fn]
+	if b == nil || !b.Has("maximum_event_age_in_seconds") {
+		return DefaultMaximumEventAge, true
+	}
+	return b.Int("maximum_event_age_in_seconds")
+}
+
+// Tuning checks the evaluated settings of every queue against its
+// consumers:
+//
+//   - The visibility timeout is at least VisibilityFactor times the timeout
+//     of every consumer.
+//   - The maxReceiveCount of a redrive policy is between 1 and
+//     MaxReceiveCount, and at least MinReceiveCount when a function polls the
+//     queue.
+//   - A dead-letter queue keeps messages longer than its source: a redriven
+//     queue's retention, or the maximum event age of a function.
+//   - The queue is encrypted with one of the project keys, given as
+//     aws_kms_key node IDs, rather than SSE-SQS or not at all.
+//
+// Values that are not statically known are reported as CheckUnknownValue
+// and skip the checks that depend on them.
+func (g *Graph) Tuning(projectKeys ...string) []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+	project := map[string]bool{}
+	for _, k := range projectKeys {
+		project[k] = true
+	}
+
+	for _, id := range g.Queues {
+		q := g.Queue(id)
+		unknown := map[string]bool{}
+		for _, attr := range q.Unknown {
+			unknown[attr] = true
+			add(id, CheckUnknownValue, "%s is not statically known", attr)
+		}
+
+		if !unknown["visibility_timeout_seconds"] {
+			for _, fn := range q.Consumers() {
+				timeout, ok := g.timeout(fn)
+				if !ok {
+					add(id, CheckUnknownValue, "the timeout of %s is not statically known", fn)
+					continue
+				}
+				if q.VisibilityTimeout < VisibilityFactor*timeout {
+					add(id, CheckVisibility, "the visibility timeout is %ds, less than %d times the %ds timeout of %s",
+						q.VisibilityTimeout, VisibilityFactor, timeout, fn)
+				}
+			}
+		}
+
+		if q.DeadLetter != "" && !unknown["redrive_policy.maxReceiveCount"] {
+			min := 1
+			if len(q.Pollers) > 0 {
+				min = MinReceiveCount
+			}
+			if q.MaxReceiveCount < min || q.MaxReceiveCount > MaxReceiveCount {
+				add(id, CheckMaxReceive, "maxReceiveCount is %d, not between %d and %d", q.MaxReceiveCount, min, MaxReceiveCount)
+			}
+			if dlq := g.Queue(q.DeadLetter); dlq != nil && !unknown["message_retention_seconds"] && !contains(dlq.Unknown, "message_retention_seconds") &&
+				dlq.Retention <= q.Retention {
+				add(q.DeadLetter, CheckRetention, "keeps messages for %ds, not longer than the %ds of its source %s", dlq.Retention, q.Retention, id)
+			}
+		}
+
+		if !unknown["message_retention_seconds"] {
+			for _, fn := range q.Producers {
+				age, ok := g.maximumEventAge(fn)
+				if ok && q.Retention <= age {
+					add(id, CheckRetention, "keeps messages for %ds, not longer than the %ds maximum event age of %s", q.Retention, age, fn)
+				}
+			}
+		}
+
+		var keys []string
+		for _, k := range q.Keys {
+			if project[k] {
+				keys = nil
+				break
+			}
+			keys = append(keys, k)
+		}
+		switch {
+		case len(q.Keys) == 0 && q.ManagedSSE:
+			add(id, CheckEncryption, "is encrypted with SSE-SQS, not a project key")
+		case len(q.Keys) == 0:
+			add(id, CheckEncryption, "is not encrypted with a project key")
+		case len(keys) > 0:
+			sort.Strings(keys)
+			add(id, CheckEncryption, "is encrypted with %s, not a project key", strings.Join(keys, ", "))
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+)
+
+// Checks reported by Wiring.
+const (
+	CheckPermission  = "permission"
+	CheckSourceARN   = "source-arn"
+	CheckOverlap     = "overlap"
+	CheckDeadLetter  = "dead-letter"
+	CheckDestination = "destination"
+)
+
+// Path is an asynchronous delivery: an edge from a bucket, rule or external
+// invoker, followed by the dead-letter and on-failure edges of the
+// functions it reaches.
+type Path []*Edge
+
+func (p Path) String() string {
+	if len(p) == 0 {
+		return ""
+	}
+	parts := []string{p[0].From}
+	for _, e := range p {
+		parts = append(parts, fmt.Sprintf("-[%s]-> %s", e.Via, e.To))
+	}
+	return strings.Join(parts, " ")
+}
+
+// End returns the last node of the path.
+func (p Path) End() string {
+	return p[len(p)-1].To
+}
+
+// Handled reports whether the path ends somewhere failed events are kept: a
+// dead-letter queue or an on-failure destination that is not itself a
+// function, or a queue or topic the source delivers to directly.
+func (p Path) Handled() bool {
+	return Kind(p.End()) != "aws_lambda_function"
+}
+
+// Paths returns every asynchronous path of the graph. Consumers of SQS
+// queues are not part of the graph, so a path into a queue ends there.
+func (g *Graph) Paths() []Path {
+	var out []Path
+	var walk func(p Path, seen map[string]bool)
+	walk = func(p Path, seen map[string]bool) {
+		end := p.End()
+		extended := false
+		if Kind(end) == "aws_lambda_function" && !seen[end] {
+			seen[end] = true
+			for _, e := range g.From(end) {
+				if e.Via == ViaDeadLetter || e.Via == ViaOnFailure {
+					walk(append(append(Path(nil), p...), e), seen)
+					extended = true
+				}
+			}
+			delete(seen, end)
+		}
+		if !extended {
+			out = append(out, p)
+		}
+	}
+	for _, e := range g.Edges {
+		switch e.Via {
+		case ViaNotification, ViaEventTarget, ViaPermission:
+			walk(Path{e}, map[string]bool{})
+		}
+	}
+	return out
+}
+
+// failureDestinations are the kinds a function can send failed events to.
+var failureDestinations = map[string][]string{
+	ViaDeadLetter: {"aws_sqs_queue", "aws_sns_topic"},
+	ViaOnFailure:  {"aws_sqs_queue", "aws_sns_topic", "aws_lambda_function", "aws_cloudwatch_event_bus"},
+	ViaOnSuccess:  {"aws_sqs_queue", "aws_sns_topic", "aws_lambda_function", "aws_cloudwatch_event_bus"},
+}
+
+// Wiring checks the delivery graph:
+//
+//   - Every notification and event target into a Lambda function has an
+//     aws_lambda_permission for its service that names its bucket or rule,
+//     or is open to any source.
+//   - A bucket has one aws_s3_bucket_notification, and no two of its
+//     configurations overlap: S3 rejects configurations whose events,
+//     prefixes and suffixes overlap, where a prefix overlaps the prefixes
+//     it starts with and a suffix the suffixes it ends with.
+//   - Every asynchronous path ends in a dead-letter queue or an on-failure
+//     destination, which must be a queue, topic, function or event bus of
+//     the tree.
+func (g *Graph) Wiring() []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	for _, e := range g.Edges {
+		var principal string
+		switch e.Via {
+		case ViaNotification:
+			principal = "s3.amazonaws.com"
+		case ViaEventTarget:
+			principal = "events.amazonaws.com"
+		}
+		if principal == "" || Kind(e.To) != "aws_lambda_function" {
+			continue
+		}
+		var sources []string
+		covered := false
+		for _, p := range g.Permissions {
+			if p.Principal != principal || !g.names(p, e.To) {
+				continue
+			}
+			sources = append(sources, p.SourceARN)
+			covered = covered || g.covers(p, e)
+		}
+		switch {
+		case covered:
+		case len(sources) == 0:
+			add(e.Owner, CheckPermission, "no aws_lambda_permission lets %s invoke %s", principal, e.To)
+		default:
+			add(e.Owner, CheckSourceARN, "the permissions for %s to invoke %s name %s, not %s", principal, e.To, strings.Join(sources, ", "), e.From)
+		}
+	}
+
+	out = append(out, g.overlaps()...)
+
+	reported := map[string]bool{}
+	for _, p := range g.Paths() {
+		if p.Handled() || reported[p.End()] {
+			continue
+		}
+		reported[p.End()] = true
+		add(p.End(), CheckDeadLetter, "%s is invoked asynchronously (%s) but has no dead_letter_config or on_failure destination", p.End(), p)
+	}
+
+	for _, e := range g.Edges {
+		kinds, ok := failureDestinations[e.Via]
+		if !ok || Kind(e.From) != "aws_lambda_function" {
+			continue
+		}
+		valid := false
+		for _, k := range kinds {
+			valid = valid || Kind(e.To) == k
+		}
+		if !valid {
+			add(e.Owner, CheckDestination, "the %s destination %s is not one of the %s of the tree", e.Via, e.To, strings.Join(kinds, ", "))
+		}
+	}
+	return out
+}
+
+func (g *Graph) names(p *Permission, fn string) bool {
+	for _, n := range p.Functions {
+		if n.String() == fn {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Graph) overlaps() []finding.Finding {
+	var out []finding.Finding
+	byBucket := map[string][]*Notification{}
+	var buckets []string
+	for _, n := range g.Notifications {
+		if byBucket[n.Bucket] == nil {
+			buckets = append(buckets, n.Bucket)
+		}
+		byBucket[n.Bucket] = append(byBucket[n.Bucket], n)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		ns := byBucket[bucket]
+		for _, n := range ns[1:] {
+			out = append(out, finding.Finding{Subject: n.ID, Check: CheckOverlap,
+				Detail: fmt.Sprintf("%s also manages the notifications of %s; S3 keeps one configuration per bucket, so the last one applied wins", ns[0].ID, bucket)})
+		}
+		var edges []*Edge
+		owner := map[*Edge]string{}
+		for _, n := range ns {
+			for _, e := range n.Edges {
+				edges = append(edges, e)
+				owner[e] = n.ID
+			}
+		}
+		for i, a := range edges {
+			for _, b := range edges[i+1:] {
+				if eventsOverlap(a.Events, b.Events) && affixOverlap(a.Prefix, b.Prefix, strings.HasPrefix) && affixOverlap(a.Suffix, b.Suffix, strings.HasSuffix) {
+					out = append(out, finding.Finding{Subject: owner[b], Check: CheckOverlap,
+						Detail: fmt.Sprintf("the configurations for %s and %s on %s overlap (prefix %q/%q, suffix %q/%q)", a.To, b.To, bucket, a.Prefix, b.Prefix, a.Suffix, b.Suffix)})
+				}
+			}
+		}
+	}
+	return out
+}
+
+func eventsOverlap(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if eventMatches(x, y) || eventMatches(y, x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// eventMatches reports whether an event type, which may end in *, covers
+// another, e.g. s3:ObjectCreated:* covers s3:ObjectCreated:Put.
+func eventMatches(pattern, event string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(event, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == event
+}
+
+func affixOverlap(a, b string, has func(s, affix string) bool) bool {
+	return has(a, b) || has(b, a)
+}
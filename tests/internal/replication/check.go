@@ -0,0 +1,201 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+)
+
+// Checks reported by Check.
+const (
+	CheckOverlap      = "overlap"
+	CheckVersioning   = "versioning"
+	CheckKMS          = "kms"
+	CheckDeleteMarker = "delete-marker"
+	CheckRolePolicy   = "role-policy"
+)
+
+// Grant is an action the replication role needs on a canonical resource ARN.
+type Grant struct {
+	Action   string
+	Resource string
+}
+
+func (g Grant) String() string {
+	return g.Action + " on " + g.Resource
+}
+
+// Required returns what the role of a configuration must allow for its
+// enabled rules: reading the replication configuration and object versions
+// of the source, replicating objects and tags (and deletes, when delete
+// markers are replicated) to every destination, and for SSE-KMS objects
+// decrypting with the source bucket key and encrypting with the replica
+// key.
+func (md *Model) Required(c *Config) []Grant {
+	var out []Grant
+	seen := map[Grant]bool{}
+	add := func(resource string, actions ...string) {
+		for _, a := range actions {
+			g := Grant{Action: a, Resource: resource}
+			if !seen[g] && resource != "" {
+				seen[g] = true
+				out = append(out, g)
+			}
+		}
+	}
+	add(c.Source, "s3:GetReplicationConfiguration", "s3:ListBucket")
+	add(c.Source+"/*", "s3:GetObjectVersionForReplication", "s3:GetObjectVersionAcl", "s3:GetObjectVersionTagging")
+	for _, r := range c.Rules {
+		if !r.Enabled {
+			continue
+		}
+		add(r.Destination+"/*", "s3:ReplicateObject", "s3:ReplicateTags")
+		if r.DeleteMarker == "Enabled" {
+			add(r.Destination+"/*", "s3:ReplicateDelete")
+		}
+		if r.SSEKMS {
+			if src, ok := md.Bucket(c.Source); ok {
+				add(md.EncryptionKey(src), "kms:Decrypt")
+			}
+			add(r.ReplicaKey, "kms:Encrypt")
+		}
+	}
+	return out
+}
+
+// Check checks every replication configuration:
+//
+//   - No two enabled rules with the same priority have overlapping filters:
+//     a prefix overlaps the prefixes it starts with, and tag filters
+//     overlap unless they require different values for one key.
+//   - Versioning is enabled on the source and on every destination, which
+//     must be a bucket of the tree.
+//   - Rules that select SSE-KMS objects name a replica_kms_key_id and the
+//     other way round, and a source encrypted with SSE-KMS selects SSE-KMS
+//     objects so that they are replicated at all.
+//   - Rules with a filter set delete_marker_replication, which S3 requires
+//     of them and does not support with tag filters; legacy prefix rules do
+//     not set it.
+//   - A role allows every grant of Required.
+func (md *Model) Check() []finding.Finding {
+	var out []finding.Finding
+	for _, c := range md.Configs {
+		add := func(check, format string, args ...interface{}) {
+			out = append(out, finding.Finding{Subject: c.ID, Check: check, Detail: fmt.Sprintf(format, args...)})
+		}
+
+		for i, a := range c.Rules {
+			for _, b := range c.Rules[i+1:] {
+				if a.Enabled && b.Enabled && a.Priority == b.Priority && filtersOverlap(a, b) {
+					add(CheckOverlap, "rules %q and %q have priority %d and overlapping filters (prefix %q/%q)", a.ID, b.ID, a.Priority, a.Prefix, b.Prefix)
+				}
+			}
+		}
+
+		sides := []string{c.Source}
+		for _, r := range c.Rules {
+			sides = append(sides, r.Destination)
+		}
+		checked := map[string]bool{}
+		for _, arn := range sides {
+			if checked[arn] || arn == "" {
+				continue
+			}
+			checked[arn] = true
+			bucket, ok := md.Bucket(arn)
+			if !ok {
+				add(CheckVersioning, "cannot check versioning of %s: it is not a bucket of the tree", arn)
+				continue
+			}
+			if v := md.Versioning(bucket); v != "Enabled" {
+				add(CheckVersioning, "versioning of %s is %q, not \"Enabled\"", bucket, v)
+			}
+		}
+
+		sourceKey := ""
+		if src, ok := md.Bucket(c.Source); ok {
+			sourceKey = md.EncryptionKey(src)
+		}
+		for _, r := range c.Rules {
+			switch {
+			case r.SSEKMS && r.ReplicaKey == "":
+				add(CheckKMS, "rule %q replicates SSE-KMS objects without a replica_kms_key_id", r.ID)
+			case !r.SSEKMS && r.ReplicaKey != "":
+				add(CheckKMS, "rule %q sets replica_kms_key_id without selecting sse_kms_encrypted_objects", r.ID)
+			case !r.SSEKMS && sourceKey != "":
+				add(CheckKMS, "rule %q does not select sse_kms_encrypted_objects, so objects encrypted with %s are not replicated", r.ID, sourceKey)
+			}
+
+			switch {
+			case r.Filtered && r.DeleteMarker == "":
+				add(CheckDeleteMarker, "rule %q has a filter but no delete_marker_replication", r.ID)
+			case r.Filtered && r.DeleteMarker == "Enabled" && len(r.Tags) > 0:
+				add(CheckDeleteMarker, "rule %q replicates delete markers with a tag filter", r.ID)
+			case r.Legacy && r.DeleteMarker != "":
+				add(CheckDeleteMarker, "rule %q sets delete_marker_replication with a legacy prefix", r.ID)
+			}
+		}
+
+		if len(c.Roles) == 0 {
+			add(CheckRolePolicy, "the role is not a role of the tree")
+			continue
+		}
+		for _, role := range c.Roles {
+			policies, errs := md.RolePolicies(role)
+			for _, err := range errs {
+				add(CheckRolePolicy, "%v", err)
+			}
+			var missing []string
+			for _, g := range md.Required(c) {
+				if !allows(policies, g) {
+					missing = append(missing, g.String())
+				}
+			}
+			if len(missing) > 0 {
+				add(CheckRolePolicy, "%s does not allow %s", role, strings.Join(missing, ", "))
+			}
+		}
+	}
+	return out
+}
+
+// allows reports whether the policies grant g. A Deny statement for the
+// grant wins; conditions are assumed to hold.
+func allows(policies []*Policy, g Grant) bool {
+	allowed := false
+	for _, p := range policies {
+		for _, st := range p.Doc.Statements {
+			if !matchAny(st.Actions, g.Action, iampolicy.MatchAction) || !matchAny(st.Resources, g.Resource, iampolicy.Wildcard) {
+				continue
+			}
+			if st.Effect == iampolicy.Deny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func matchAny(patterns []string, s string, match func(pattern, s string) bool) bool {
+	for _, p := range patterns {
+		if match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func filtersOverlap(a, b *Rule) bool {
+	if !strings.HasPrefix(a.Prefix, b.Prefix) && !strings.HasPrefix(b.Prefix, a.Prefix) {
+		return false
+	}
+	for k, v := range a.Tags {
+		if w, ok := b.Tags[k]; ok && w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,367 @@
+// Package replication models the aws_s3_bucket_replication_configuration
+// resources of a Terraform tree: their rules, the buckets and KMS keys on
+// both sides, and the role S3 replicates with. Buckets, keys and policy
+// resources are compared by canonical ARN, so a destination written as
+// "arn:aws:s3:::${var.destination_bucket_name}" matches the bucket of the
+// tree with that name, and a key passed through module variables matches
+// the aws_kms_key it comes from.
+package replication
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Config is an aws_s3_bucket_replication_configuration.
+type Config struct {
+	ID     string
+	Block  *tfconfig.Block
+	Module *tfconfig.Module
+	// Staged is set for a configuration with count = 0 that is kept in the
+	// tree until its destination exists. It is checked like the others.
+	Staged bool
+
+	Source string // canonical ARN of the source bucket
+	Roles  []tfconfig.Node
+	Rules  []*Rule
+}
+
+// Destinations returns the canonical destination ARNs of a configuration,
+// sorted.
+func (c *Config) Destinations() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, r := range c.Rules {
+		if !seen[r.Destination] {
+			seen[r.Destination] = true
+			out = append(out, r.Destination)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Rule is a rule of a replication configuration.
+type Rule struct {
+	ID       string
+	Enabled  bool
+	Priority int
+	// Filtered is set for a rule with a filter block (the V2 schema), and
+	// Legacy for one with the deprecated rule-level prefix.
+	Filtered bool
+	Legacy   bool
+	Prefix   string
+	Tags     map[string]string
+
+	Destination  string // canonical ARN of the destination bucket
+	ReplicaKey   string // canonical ARN of replica_kms_key_id, or ""
+	SSEKMS       bool   // source_selection_criteria.sse_kms_encrypted_objects is enabled
+	DeleteMarker string // status of delete_marker_replication, or "" when unset
+
+	Block *tfconfig.Block
+}
+
+// Model is every replication configuration of a tree.
+type Model struct {
+	Configs []*Config
+
+	// Unresolved lists sources and destinations that are not statically
+	// known.
+	Unresolved []string
+
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+
+	buckets map[string]tfconfig.Node // bucket nodes by canonical ARN
+}
+
+// Build collects the replication configurations of every module in the
+// tree, including staged ones.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, Refs: tree.RefGraph(), buckets: map[string]tfconfig.Node{}}
+	for _, m := range tree.Modules {
+		for _, b := range m.Resources("aws_s3_bucket") {
+			n := tfconfig.Node{Module: m, Addr: b.Address()}
+			md.buckets[md.bucketARN(n)] = n
+		}
+	}
+	for _, m := range tree.Modules {
+		for _, b := range m.Resources("aws_s3_bucket_replication_configuration") {
+			md.Configs = append(md.Configs, md.config(m, b))
+		}
+	}
+	sort.Slice(md.Configs, func(i, j int) bool { return md.Configs[i].ID < md.Configs[j].ID })
+	sort.Strings(md.Unresolved)
+	return md
+}
+
+func (md *Model) config(m *tfconfig.Module, b *tfconfig.Block) *Config {
+	c := &Config{ID: nodeID(m, b), Block: b, Module: m}
+	if n, ok := b.Count(); ok && n == 0 {
+		c.Staged = true
+	}
+	for _, n := range md.Refs.ResolveExpr(m, b.Expr("bucket")) {
+		if n.Kind() == "aws_s3_bucket" {
+			c.Source = md.bucketARN(n)
+		}
+	}
+	if c.Source == "" {
+		md.Unresolved = append(md.Unresolved, fmt.Sprintf("%s: bucket is not a bucket of the tree", c.ID))
+	}
+	for _, n := range md.Refs.ResolveExpr(m, b.Expr("role")) {
+		if n.Kind() == "aws_iam_role" {
+			c.Roles = append(c.Roles, n)
+		}
+	}
+
+	for _, rb := range b.Nested("rule") {
+		r := &Rule{Block: rb, Tags: map[string]string{}}
+		r.ID, _ = rb.String("id")
+		status, _ := rb.String("status")
+		r.Enabled = status == "Enabled"
+		r.Priority, _ = rb.Int("priority")
+		if rb.Has("prefix") {
+			r.Legacy = true
+			r.Prefix, _ = rb.String("prefix")
+		}
+		for _, fb := range rb.Nested("filter") {
+			r.Filtered = true
+			r.readFilter(fb)
+			for _, and := range fb.Nested("and") {
+				r.readFilter(and)
+			}
+		}
+		for _, db := range rb.Nested("destination") {
+			r.Destination = md.Canonical(m, db.Expr("bucket"))
+			for _, eb := range db.Nested("encryption_configuration") {
+				r.ReplicaKey = md.Canonical(m, eb.Expr("replica_kms_key_id"))
+			}
+		}
+		if r.Destination == "" || strings.Contains(r.Destination, tfconfig.Unknown) {
+			md.Unresolved = append(md.Unresolved, fmt.Sprintf("%s: the destination of rule %q is not statically known", c.ID, r.ID))
+		}
+		for _, ss := range rb.Nested("source_selection_criteria") {
+			for _, kb := range ss.Nested("sse_kms_encrypted_objects") {
+				s, _ := kb.String("status")
+				r.SSEKMS = s == "Enabled"
+			}
+		}
+		for _, dm := range rb.Nested("delete_marker_replication") {
+			r.DeleteMarker, _ = dm.String("status")
+		}
+		c.Rules = append(c.Rules, r)
+	}
+	return c
+}
+
+func (r *Rule) readFilter(b *tfconfig.Block) {
+	if p, ok := b.String("prefix"); ok {
+		r.Prefix = p
+	}
+	for _, tb := range b.Nested("tag") {
+		k, _ := tb.String("key")
+		v, _ := tb.String("value")
+		r.Tags[k] = v
+	}
+	if v, ok := b.Partial("tags").(map[string]interface{}); ok {
+		for k, tv := range v {
+			r.Tags[k] = fmt.Sprint(tv)
+		}
+	}
+}
+
+var interpolation = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Canonical returns an ARN-valued expression of a module with every
+// reference replaced by what it resolves to: the literal ARN of a bucket
+// whose name is known, or "${<node ID>}" for any other resource. It returns
+// "" for an unset attribute.
+func (md *Model) Canonical(m *tfconfig.Module, expr hclsyntax.Expression) string {
+	if expr == nil {
+		return ""
+	}
+	for _, n := range md.Refs.ResolveExpr(m, expr) {
+		if n.Kind() == "aws_s3_bucket" && len(tfconfig.Traversals(expr)) == 1 {
+			if _, ok := expr.(*hclsyntax.ScopeTraversalExpr); ok {
+				return md.bucketARN(n)
+			}
+		}
+	}
+	s, _ := m.Symbolic(expr).(string)
+	return md.canonicalString(m, s)
+}
+
+// canonicalString canonicalizes the references left in a symbolic string.
+func (md *Model) canonicalString(m *tfconfig.Module, s string) string {
+	return interpolation.ReplaceAllStringFunc(s, func(ref string) string {
+		path := interpolation.FindStringSubmatch(ref)[1]
+		expr, diags := hclsyntax.ParseExpression([]byte(path), "", hcl.InitialPos)
+		if diags.HasErrors() {
+			return ref
+		}
+		nodes := md.Refs.ResolveExpr(m, expr)
+		if len(nodes) != 1 {
+			return ref
+		}
+		if nodes[0].Kind() == "aws_s3_bucket" && strings.HasSuffix(path, ".arn") {
+			return md.bucketARN(nodes[0])
+		}
+		return "${" + nodes[0].String() + "}"
+	})
+}
+
+// bucketARN returns the literal ARN of a bucket whose name is statically
+// known, or "${<node ID>}".
+func (md *Model) bucketARN(n tfconfig.Node) string {
+	if b := n.Block(); b != nil {
+		if name, ok := b.String("bucket"); ok {
+			return "arn:aws:s3:::" + name
+		}
+	}
+	return "${" + n.String() + "}"
+}
+
+// Bucket returns the bucket of the tree with a canonical ARN.
+func (md *Model) Bucket(arn string) (tfconfig.Node, bool) {
+	n, ok := md.buckets[arn]
+	return n, ok
+}
+
+// Versioning returns the evaluated versioning status of a bucket of the
+// tree, from its aws_s3_bucket_versioning or the inline versioning block,
+// or "" when neither is set.
+func (md *Model) Versioning(bucket tfconfig.Node) string {
+	if b := bucket.Block(); b != nil {
+		for _, vb := range b.Nested("versioning") {
+			if on, ok := vb.Bool("enabled"); ok {
+				if on {
+					return "Enabled"
+				}
+				return "Suspended"
+			}
+		}
+	}
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_s3_bucket_versioning")) {
+			if !md.refersTo(m, b, "bucket", bucket) {
+				continue
+			}
+			for _, vc := range b.Nested("versioning_configuration") {
+				if s, ok := vc.String("status"); ok {
+					return s
+				}
+				return tfconfig.Unknown
+			}
+		}
+	}
+	return ""
+}
+
+// EncryptionKey returns the canonical ARN of the default KMS key of a
+// bucket of the tree, or "" when it is not encrypted with SSE-KMS.
+func (md *Model) EncryptionKey(bucket tfconfig.Node) string {
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_s3_bucket_server_side_encryption_configuration")) {
+			if !md.refersTo(m, b, "bucket", bucket) {
+				continue
+			}
+			for _, rb := range b.Nested("rule") {
+				for _, d := range rb.Nested("apply_server_side_encryption_by_default") {
+					if alg, _ := d.String("sse_algorithm"); strings.HasPrefix(alg, "aws:kms") {
+						return md.Canonical(m, d.Expr("kms_master_key_id"))
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (md *Model) refersTo(m *tfconfig.Module, b *tfconfig.Block, attr string, n tfconfig.Node) bool {
+	for _, r := range md.Refs.ResolveExpr(m, b.Expr(attr)) {
+		if r == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an identity policy of a replication role, with its resources
+// canonicalized.
+type Policy struct {
+	ID  string
+	Doc *iampolicy.Document
+}
+
+// RolePolicies returns the inline and attached policies of a role.
+func (md *Model) RolePolicies(role tfconfig.Node) ([]*Policy, []error) {
+	var out []*Policy
+	var errs []error
+	add := func(m *tfconfig.Module, id string, expr hclsyntax.Expression) {
+		var doc *iampolicy.Document
+		var err error
+		if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok && call.Name == "jsonencode" && len(call.Args) == 1 {
+			doc, err = iampolicy.Parse(id, m.Symbolic(call.Args[0]))
+		} else {
+			doc, err = iampolicy.FromExpr(md.Refs, m, id, expr)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		for i := range doc.Statements {
+			st := &doc.Statements[i]
+			for j, r := range st.Resources {
+				st.Resources[j] = md.canonicalString(m, r)
+			}
+		}
+		out = append(out, &Policy{ID: id, Doc: doc})
+	}
+
+	if rb := role.Block(); rb != nil {
+		for _, ib := range rb.Nested("inline_policy") {
+			add(role.Module, role.String()+".inline_policy", ib.Expr("policy"))
+		}
+	}
+	for _, m := range md.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_iam_role_policy")) {
+			if md.refersTo(m, b, "role", role) {
+				add(m, nodeID(m, b), b.Expr("policy"))
+			}
+		}
+		for _, b := range instantiated(m.Resources("aws_iam_role_policy_attachment")) {
+			if !md.refersTo(m, b, "role", role) {
+				continue
+			}
+			for _, n := range md.Refs.ResolveExpr(m, b.Expr("policy_arn")) {
+				if n.Kind() == "aws_iam_policy" {
+					add(n.Module, n.String(), n.Block().Expr("policy"))
+				}
+			}
+		}
+	}
+	return out, errs
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
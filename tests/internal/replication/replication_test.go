@@ -0,0 +1,294 @@
+package replication
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleReplication has a versioned, SSE-KMS source bucket replicated by
+// three rules: one to a destination named through a variable, one whose
+// prefix overlaps it at the same priority with a tag filter, and one that
+// selects SSE-KMS objects without a replica key and sets no
+// delete_marker_replication. The destination has versioning suspended and
+// the role lacks ReplicateDelete and the replica key. A legacy configuration
+// replicates an unversioned bucket outside the tree with a role that is not
+// in the tree.
+const sampleReplication = `
+variable "destination_name" {
+  default = "dst"
+}
+
+resource "aws_kms_key" "src" {}
+
+resource "aws_kms_key" "dst" {}
+
+resource "aws_s3_bucket" "src" {
+  bucket = "src"
+}
+
+resource "aws_s3_bucket_versioning" "src" {
+  bucket = aws_s3_bucket.src.id
+  versioning_configuration {
+    status = "Enabled"
+  }
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "src" {
+  bucket = aws_s3_bucket.src.id
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm     = "aws:kms"
+      kms_master_key_id = aws_kms_key.src.arn
+    }
+  }
+}
+
+resource "aws_s3_bucket" "dst" {
+  bucket = var.destination_name
+}
+
+resource "aws_s3_bucket_versioning" "dst" {
+  bucket = aws_s3_bucket.dst.id
+  versioning_configuration {
+    status = "Suspended"
+  }
+}
+
+resource "aws_s3_bucket" "other" {
+  bucket = "other"
+}
+
+resource "aws_iam_role" "repl" {
+  name = "repl"
+}
+
+resource "aws_iam_role_policy" "repl" {
+  role = aws_iam_role.repl.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetReplicationConfiguration", "s3:ListBucket"]
+        Resource = aws_s3_bucket.src.arn
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:GetObjectVersion*"]
+        Resource = "${aws_s3_bucket.src.arn}/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["s3:ReplicateObject", "s3:ReplicateTags"]
+        Resource = "arn:aws:s3:::${var.destination_name}/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = "kms:Decrypt"
+        Resource = aws_kms_key.src.arn
+      }
+    ]
+  })
+}
+
+resource "aws_s3_bucket_replication_configuration" "main" {
+  bucket = aws_s3_bucket.src.id
+  role   = aws_iam_role.repl.arn
+
+  rule {
+    id       = "docs"
+    status   = "Enabled"
+    priority = 1
+
+    filter {
+      prefix = "docs/"
+    }
+
+    destination {
+      bucket = "arn:aws:s3:::${var.destination_name}"
+      encryption_configuration {
+        replica_kms_key_id = aws_kms_key.dst.arn
+      }
+    }
+
+    source_selection_criteria {
+      sse_kms_encrypted_objects {
+        status = "Enabled"
+      }
+    }
+
+    delete_marker_replication {
+      status = "Enabled"
+    }
+  }
+
+  rule {
+    id       = "docs-pdf"
+    status   = "Enabled"
+    priority = 1
+
+    filter {
+      and {
+        prefix = "docs/pdf/"
+        tags   = { type = "pdf" }
+      }
+    }
+
+    destination {
+      bucket = aws_s3_bucket.dst.arn
+    }
+
+    delete_marker_replication {
+      status = "Enabled"
+    }
+  }
+
+  rule {
+    id       = "logs"
+    status   = "Enabled"
+    priority = 2
+
+    filter {
+      prefix = "logs/"
+    }
+
+    destination {
+      bucket = aws_s3_bucket.dst.arn
+    }
+
+    source_selection_criteria {
+      sse_kms_encrypted_objects {
+        status = "Enabled"
+      }
+    }
+  }
+}
+
+resource "aws_s3_bucket_replication_configuration" "legacy" {
+  count  = 0
+  bucket = aws_s3_bucket.other.id
+  role   = "arn:aws:iam::123456789012:role/replication"
+
+  rule {
+    id     = "all"
+    status = "Enabled"
+    prefix = ""
+
+    destination {
+      bucket = "arn:aws:s3:::elsewhere"
+    }
+
+    delete_marker_replication {
+      status = "Disabled"
+    }
+  }
+}
+`
+
+func loadSample(t *testing.T) *Model {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleReplication), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unresolved)
+	require.Len(t, md.Configs, 2)
+	return md
+}
+
+func TestBuild_ReadsRules(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	legacy, main := md.Configs[0], md.Configs[1]
+	assert.Equal(t, "environments/app:aws_s3_bucket_replication_configuration.main", main.ID)
+	assert.False(t, main.Staged)
+	assert.True(t, legacy.Staged)
+	assert.Equal(t, "arn:aws:s3:::src", main.Source)
+	assert.Equal(t, []tfconfig.Node{{Module: main.Module, Addr: "aws_iam_role.repl"}}, main.Roles)
+	assert.Empty(t, legacy.Roles)
+	assert.Equal(t, []string{"arn:aws:s3:::dst"}, main.Destinations(),
+		"a destination named through a variable is the bucket with that name")
+
+	require.Len(t, main.Rules, 3)
+	docs, pdf := main.Rules[0], main.Rules[1]
+	assert.Equal(t, "${environments/app:aws_kms_key.dst}", docs.ReplicaKey)
+	assert.True(t, docs.SSEKMS)
+	assert.Equal(t, 1, docs.Priority)
+	assert.Equal(t, "docs/pdf/", pdf.Prefix)
+	assert.Equal(t, map[string]string{"type": "pdf"}, pdf.Tags)
+	assert.True(t, legacy.Rules[0].Legacy)
+	assert.Equal(t, "Disabled", legacy.Rules[0].DeleteMarker)
+
+	dst, ok := md.Bucket("arn:aws:s3:::dst")
+	require.True(t, ok)
+	assert.Equal(t, "Suspended", md.Versioning(dst))
+	src, _ := md.Bucket(main.Source)
+	assert.Equal(t, "${environments/app:aws_kms_key.src}", md.EncryptionKey(src))
+	other, _ := md.Bucket("arn:aws:s3:::other")
+	assert.Equal(t, "", md.Versioning(other))
+	assert.Equal(t, "", md.EncryptionKey(other))
+}
+
+func TestRequired(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	assert.Equal(t, []Grant{
+		{"s3:GetReplicationConfiguration", "arn:aws:s3:::src"},
+		{"s3:ListBucket", "arn:aws:s3:::src"},
+		{"s3:GetObjectVersionForReplication", "arn:aws:s3:::src/*"},
+		{"s3:GetObjectVersionAcl", "arn:aws:s3:::src/*"},
+		{"s3:GetObjectVersionTagging", "arn:aws:s3:::src/*"},
+		{"s3:ReplicateObject", "arn:aws:s3:::dst/*"},
+		{"s3:ReplicateTags", "arn:aws:s3:::dst/*"},
+		{"s3:ReplicateDelete", "arn:aws:s3:::dst/*"},
+		{"kms:Decrypt", "${environments/app:aws_kms_key.src}"},
+		{"kms:Encrypt", "${environments/app:aws_kms_key.dst}"},
+	}, md.Required(md.Configs[1]))
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	md := loadSample(t)
+	var keys []string
+	for _, f := range md.Check() {
+		keys = append(keys, f.Key())
+	}
+	const legacy, main = "environments/app:aws_s3_bucket_replication_configuration.legacy", "environments/app:aws_s3_bucket_replication_configuration.main"
+	assert.Equal(t, []string{
+		legacy + " versioning",
+		legacy + " versioning",
+		legacy + " delete-marker",
+		legacy + " role-policy",
+		main + " overlap",
+		main + " versioning",
+		main + " kms",
+		main + " delete-marker",
+		main + " kms",
+		main + " delete-marker",
+		main + " role-policy",
+	}, keys)
+
+	findings := md.Check()
+	assert.Contains(t, findings, finding.Finding{Subject: main, Check: CheckOverlap,
+		Detail: `rules "docs" and "docs-pdf" have priority 1 and overlapping filters (prefix "docs/"/"docs/pdf/")`})
+	assert.Contains(t, findings, finding.Finding{Subject: main, Check: CheckKMS,
+		Detail: `rule "docs-pdf" does not select sse_kms_encrypted_objects, so objects encrypted with ${environments/app:aws_kms_key.src} are not replicated`})
+	assert.Contains(t, findings, finding.Finding{Subject: main, Check: CheckRolePolicy,
+		Detail: "environments/app:aws_iam_role.repl does not allow s3:ReplicateDelete on arn:aws:s3:::dst/*, kms:Encrypt on ${environments/app:aws_kms_key.dst}"})
+	assert.Contains(t, findings, finding.Finding{Subject: legacy, Check: CheckVersioning,
+		Detail: "cannot check versioning of arn:aws:s3:::elsewhere: it is not a bucket of the tree"})
+}
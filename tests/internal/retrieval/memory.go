@@ -0,0 +1,192 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Memory is an in-process stand-in for a knowledge base. It ranks whole
+// documents by the cosine similarity of their TF-IDF term weights to the
+// query's, where the terms of a text are its lower-cased words and
+// identifiers plus the underscore-separated parts of each identifier.
+type Memory struct {
+	docs    []Document
+	vectors []map[string]float64
+	idf     map[string]float64
+}
+
+// NewMemory indexes documents.
+func NewMemory(docs []Document) *Memory {
+	m := &Memory{docs: docs, idf: map[string]float64{}}
+	df := map[string]int{}
+	var counts []map[string]int
+	for _, d := range docs {
+		c := termCounts(d.Text)
+		counts = append(counts, c)
+		for t := range c {
+			df[t]++
+		}
+	}
+	for t, n := range df {
+		m.idf[t] = math.Log(1 + float64(len(docs))/float64(n))
+	}
+	for _, c := range counts {
+		m.vectors = append(m.vectors, m.weigh(c))
+	}
+	return m
+}
+
+// Retrieve returns the k documents most similar to the query, best first
+// and by URI among equals. Documents that share no term with it are not
+// returned.
+func (m *Memory) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	q := m.weigh(termCounts(query))
+	terms := sortedTerms(q)
+	var out []Result
+	for i, v := range m.vectors {
+		score := 0.0
+		for _, t := range terms {
+			score += q[t] * v[t]
+		}
+		if score > 0 {
+			out = append(out, Result{URI: m.docs[i].URI, Text: m.docs[i].Text, Score: score})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].URI < out[j].URI
+	})
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out, nil
+}
+
+// weigh returns the unit TF-IDF vector of term counts. Terms no document
+// has are dropped.
+func (m *Memory) weigh(counts map[string]int) map[string]float64 {
+	v := map[string]float64{}
+	for t, n := range counts {
+		if idf, ok := m.idf[t]; ok {
+			v[t] = (1 + math.Log(float64(n))) * idf
+		}
+	}
+	norm := 0.0
+	for _, t := range sortedTerms(v) {
+		norm += v[t] * v[t]
+	}
+	for t := range v {
+		v[t] /= math.Sqrt(norm)
+	}
+	return v
+}
+
+// sortedTerms returns the terms of a vector in order, so that sums over
+// them, and the ranking of documents that tie, do not vary between runs.
+func sortedTerms(v map[string]float64) []string {
+	out := make([]string, 0, len(v))
+	for t := range v {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func termCounts(text string) map[string]int {
+	out := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	})
+	for _, w := range words {
+		out[w]++
+		if parts := strings.Split(w, "_"); len(parts) > 1 {
+			for _, p := range parts {
+				if p != "" {
+					out[p]++
+				}
+			}
+		}
+	}
+	return out
+}
+
+// HierarchyDocuments builds one document per module of a module hierarchy
+// dump such as test_rtl/trinity_hierarchy.json: how often the module is
+// instantiated, by which modules under which instance names, and the
+// instances it has. Its URI is "hierarchy/<module>.md", so questions name
+// the module as the source.
+func HierarchyDocuments(data string) ([]Document, error) {
+	var h struct {
+		Root    string `json:"root"`
+		Records []struct {
+			Module       string `json:"module_name"`
+			Instance     string `json:"instance_name"`
+			ParentModule string `json:"parent_module"`
+		} `json:"records"`
+	}
+	if err := json.Unmarshal([]byte(data), &h); err != nil {
+		return nil, err
+	}
+	if h.Root == "" || len(h.Records) == 0 {
+		return nil, fmt.Errorf("no root or records")
+	}
+
+	type use struct{ module, instance string }
+	var modules []string
+	instances := map[string]int{}
+	parents := map[string][]use{}
+	children := map[string][]use{}
+	uses := map[use]map[string]int{} // (parent, instance) -> child -> count
+	for _, r := range h.Records {
+		if instances[r.Module] == 0 {
+			modules = append(modules, r.Module)
+		}
+		instances[r.Module]++
+		if r.ParentModule == "" {
+			continue
+		}
+		u := use{r.ParentModule, r.Instance}
+		if uses[u] == nil {
+			uses[u] = map[string]int{}
+		}
+		if uses[u][r.Module] == 0 {
+			parents[r.Module] = append(parents[r.Module], u)
+			children[r.ParentModule] = append(children[r.ParentModule], use{r.Module, r.Instance})
+		}
+		uses[u][r.Module]++
+	}
+
+	var docs []Document
+	for _, mod := range modules {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Module %s\n", mod)
+		if mod == h.Root {
+			fmt.Fprintf(&b, "%s is the top module of the hierarchy.\n", mod)
+		} else {
+			fmt.Fprintf(&b, "%s is instantiated %d times in the hierarchy of %s.\n", mod, instances[mod], h.Root)
+			b.WriteString("Instantiated by:\n")
+			for _, p := range parents[mod] {
+				fmt.Fprintf(&b, "- %s as %s (%d)\n", p.module, p.instance, uses[p][mod])
+			}
+		}
+		if len(children[mod]) == 0 {
+			fmt.Fprintf(&b, "%s instantiates no modules.\n", mod)
+		} else {
+			b.WriteString("Instances:\n")
+			for _, c := range children[mod] {
+				fmt.Fprintf(&b, "- %s: %s (%d)\n", c.instance, c.module, uses[use{mod, c.instance}][c.module])
+			}
+		}
+		docs = append(docs, Document{URI: "hierarchy/" + mod + ".md", Text: b.String()})
+	}
+	return docs, nil
+}
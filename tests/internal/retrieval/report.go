@@ -0,0 +1,148 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// QuestionReport is how well a backend answered one question.
+type QuestionReport struct {
+	ID             string   `json:"id"`
+	Retrieved      []string `json:"retrieved"` // sources of the results, best first
+	Recall         float64  `json:"recall"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	Citation       bool     `json:"citation"`
+}
+
+// Report is how well a backend answered a golden set, averaged over its
+// questions.
+type Report struct {
+	Golden    string           `json:"golden"`
+	Backend   string           `json:"backend"`
+	K         int              `json:"k"`
+	Recall    float64          `json:"recall_at_k"`
+	MRR       float64          `json:"mrr"`
+	Citation  float64          `json:"citation"`
+	Questions []QuestionReport `json:"questions"`
+}
+
+// Evaluate asks a backend every question of a golden set for g.K results
+// and scores them:
+//
+//   - Recall is the fraction of the question's sources among the results.
+//   - ReciprocalRank is 1/n for the first result, n counted from 1, that
+//     cites one of the sources, or 0.
+//   - Citation holds when the first result whose passage contains the
+//     evidence, the one an answer would cite, cites one of the sources.
+//     Without evidence, the first result must.
+func Evaluate(ctx context.Context, b Backend, name string, g *Golden) (*Report, error) {
+	r := &Report{Golden: g.Name, Backend: name, K: g.K}
+	for _, q := range g.Questions {
+		results, err := b.Retrieve(ctx, q.Text, g.K)
+		if err != nil {
+			return nil, fmt.Errorf("question %s: %w", q.ID, err)
+		}
+		if len(results) > g.K {
+			results = results[:g.K]
+		}
+		qr := QuestionReport{ID: q.ID, Retrieved: []string{}}
+		found := map[string]bool{}
+		cited := false
+		for i, res := range results {
+			src := res.Source()
+			qr.Retrieved = append(qr.Retrieved, src)
+			relevant := contains(q.Sources, src)
+			if relevant && !found[src] {
+				found[src] = true
+				qr.Recall += 1 / float64(len(q.Sources))
+			}
+			if relevant && qr.ReciprocalRank == 0 {
+				qr.ReciprocalRank = 1 / float64(i+1)
+			}
+			if !cited && strings.Contains(res.Text, q.Evidence) {
+				cited = true
+				qr.Citation = relevant
+			}
+		}
+		r.Recall += qr.Recall
+		r.MRR += qr.ReciprocalRank
+		if qr.Citation {
+			r.Citation++
+		}
+		r.Questions = append(r.Questions, qr)
+	}
+	if n := float64(len(g.Questions)); n > 0 {
+		r.Recall /= n
+		r.MRR /= n
+		r.Citation /= n
+	}
+	return r, nil
+}
+
+// ParseReport parses a report saved with JSON.
+func ParseReport(data string) (*Report, error) {
+	var r Report
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// JSON returns the report as indented JSON, the way baselines are saved.
+func (r *Report) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Compare describes how a report regressed from a baseline: recall@k, MRR
+// or citation correctness that fell by more than tolerance, and questions
+// that no longer retrieve any source or no longer cite one. Reports of
+// different golden sets or k are not comparable.
+func Compare(baseline, r *Report, tolerance float64) []string {
+	if baseline.Golden != r.Golden || baseline.K != r.K {
+		return []string{fmt.Sprintf("%s at k=%d is not comparable with a baseline of %s at k=%d", r.Golden, r.K, baseline.Golden, baseline.K)}
+	}
+	var out []string
+	for _, m := range []struct {
+		name     string
+		was, now float64
+	}{
+		{"recall@k", baseline.Recall, r.Recall},
+		{"MRR", baseline.MRR, r.MRR},
+		{"citation correctness", baseline.Citation, r.Citation},
+	} {
+		if m.was-m.now > tolerance {
+			out = append(out, fmt.Sprintf("%s fell from %.3f to %.3f", m.name, m.was, m.now))
+		}
+	}
+	now := map[string]QuestionReport{}
+	for _, q := range r.Questions {
+		now[q.ID] = q
+	}
+	for _, was := range baseline.Questions {
+		q, ok := now[was.ID]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("question %s was not asked", was.ID))
+		case was.Recall > 0 && q.Recall == 0:
+			out = append(out, fmt.Sprintf("question %s retrieved %s, none of its sources", was.ID, strings.Join(q.Retrieved, ", ")))
+		case was.Citation && !q.Citation:
+			out = append(out, fmt.Sprintf("question %s no longer cites one of its sources", was.ID))
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Package retrieval measures how well a knowledge base retrieves the
+// documents a golden set of questions expects: recall@k, mean reciprocal
+// rank and whether the passage an answer would cite is attributed to the
+// right document. A Backend is either the Bedrock Retrieve API or Memory,
+// an in-process stand-in built from the same documents, and a Report is
+// saved as JSON to compare later runs against.
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Question is a golden question and the documents that answer it.
+type Question struct {
+	ID       string   `yaml:"id"`
+	Layer    string   `yaml:"layer"` // measurement layer of the RAG review, e.g. "L1"
+	Text     string   `yaml:"question"`
+	Sources  []string `yaml:"sources"`  // documents that answer it, as Result.Source names them
+	Evidence string   `yaml:"evidence"` // text the cited passage must contain
+}
+
+// Golden is a golden set: the questions of one suite and how many results
+// each retrieves.
+type Golden struct {
+	Name      string     `yaml:"name"`
+	K         int        `yaml:"k"`
+	Questions []Question `yaml:"questions"`
+}
+
+// ParseGolden parses a golden set file.
+func ParseGolden(data string) (*Golden, error) {
+	var g Golden
+	if err := yaml.Unmarshal([]byte(data), &g); err != nil {
+		return nil, err
+	}
+	if g.K < 1 {
+		return nil, fmt.Errorf("k is %d", g.K)
+	}
+	seen := map[string]bool{}
+	for _, q := range g.Questions {
+		switch {
+		case q.ID == "" || q.Text == "":
+			return nil, fmt.Errorf("question without id or question")
+		case seen[q.ID]:
+			return nil, fmt.Errorf("duplicate question id %s", q.ID)
+		case len(q.Sources) == 0:
+			return nil, fmt.Errorf("question %s has no sources", q.ID)
+		}
+		seen[q.ID] = true
+	}
+	return &g, nil
+}
+
+// Document is a document of the knowledge base.
+type Document struct {
+	URI  string
+	Text string
+}
+
+// Result is a passage a backend retrieved, most relevant first.
+type Result struct {
+	URI   string // location of the document, e.g. s3://bucket/rtl/tt_tensix.sv
+	Text  string
+	Score float64
+}
+
+// Source returns the document a result cites: the base name of its URI
+// without extension, e.g. "tt_tensix" for s3://bucket/rtl/tt_tensix.sv.
+// Golden questions name their sources this way, so the same set scores the
+// knowledge base's RTL files and the stand-in's documents alike.
+func (r Result) Source() string {
+	base := path.Base(r.URI)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// Backend retrieves the k passages most relevant to a query.
+type Backend interface {
+	Retrieve(ctx context.Context, query string, k int) ([]Result, error)
+}
@@ -0,0 +1,200 @@
+package retrieval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleHierarchy = `{
+  "root": "top",
+  "records": [
+    {"module_name": "top", "instance_name": "top", "parent_module": ""},
+    {"module_name": "core", "instance_name": "u_core0", "parent_module": "top"},
+    {"module_name": "core", "instance_name": "u_core1", "parent_module": "top"},
+    {"module_name": "sram_macro", "instance_name": "u_sram", "parent_module": "core"},
+    {"module_name": "sram_macro", "instance_name": "u_sram", "parent_module": "core"},
+    {"module_name": "clk_gater", "instance_name": "u_cg", "parent_module": "top"}
+  ]
+}`
+
+const sampleGolden = `
+name: sample
+k: 2
+questions:
+  - id: cores
+    layer: L1
+    question: How many core instances does top have?
+    sources: [core, top]
+    evidence: core is instantiated 2 times
+  - id: sram
+    layer: L1
+    question: Which memory macro does core use?
+    sources: [core]
+    evidence: "u_sram: sram_macro"
+  - id: gater
+    layer: L1
+    question: Where is the clock gater clk_gater?
+    sources: [clk_gater]
+`
+
+// stub returns fixed results per query.
+type stub map[string][]Result
+
+func (s stub) Retrieve(_ context.Context, query string, _ int) ([]Result, error) {
+	return s[query], nil
+}
+
+func sample(t *testing.T) (*Golden, []Document) {
+	t.Helper()
+	g, err := ParseGolden(sampleGolden)
+	require.NoError(t, err)
+	docs, err := HierarchyDocuments(sampleHierarchy)
+	require.NoError(t, err)
+	return g, docs
+}
+
+func TestParseGolden(t *testing.T) {
+	t.Parallel()
+
+	g, _ := sample(t)
+	assert.Equal(t, "sample", g.Name)
+	assert.Equal(t, 2, g.K)
+	require.Len(t, g.Questions, 3)
+	assert.Equal(t, Question{
+		ID:       "sram",
+		Layer:    "L1",
+		Text:     "Which memory macro does core use?",
+		Sources:  []string{"core"},
+		Evidence: "u_sram: sram_macro",
+	}, g.Questions[1])
+
+	_, err := ParseGolden("k: 0\n")
+	assert.ErrorContains(t, err, "k is 0")
+	_, err = ParseGolden("k: 1\nquestions:\n  - {id: a, question: q, sources: [x]}\n  - {id: a, question: r, sources: [y]}\n")
+	assert.ErrorContains(t, err, "duplicate question id a")
+	_, err = ParseGolden("k: 1\nquestions:\n  - {id: a, question: q}\n")
+	assert.ErrorContains(t, err, "question a has no sources")
+}
+
+func TestHierarchyDocuments(t *testing.T) {
+	t.Parallel()
+
+	_, docs := sample(t)
+	var uris []string
+	for _, d := range docs {
+		uris = append(uris, d.URI)
+	}
+	assert.Equal(t, []string{"hierarchy/top.md", "hierarchy/core.md", "hierarchy/sram_macro.md", "hierarchy/clk_gater.md"}, uris)
+	assert.Equal(t, "Module top\n"+
+		"top is the top module of the hierarchy.\n"+
+		"Instances:\n"+
+		"- u_core0: core (1)\n"+
+		"- u_core1: core (1)\n"+
+		"- u_cg: clk_gater (1)\n", docs[0].Text)
+	assert.Equal(t, "Module core\n"+
+		"core is instantiated 2 times in the hierarchy of top.\n"+
+		"Instantiated by:\n"+
+		"- top as u_core0 (1)\n"+
+		"- top as u_core1 (1)\n"+
+		"Instances:\n"+
+		"- u_sram: sram_macro (2)\n", docs[1].Text)
+	assert.Contains(t, docs[3].Text, "clk_gater instantiates no modules.")
+
+	_, err := HierarchyDocuments(`{"records": []}`)
+	assert.ErrorContains(t, err, "no root or records")
+}
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	_, docs := sample(t)
+	m := NewMemory(docs)
+	results, err := m.Retrieve(context.Background(), "Where is the clock gater clk_gater?", 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "hierarchy/clk_gater.md", results[0].URI)
+	assert.Equal(t, "hierarchy/top.md", results[1].URI)
+	assert.Greater(t, results[0].Score, results[1].Score)
+
+	// "sram" matches the parts of sram_macro and u_sram.
+	results, err = m.Retrieve(context.Background(), "sram", 5)
+	require.NoError(t, err)
+	var sources []string
+	for _, r := range results {
+		sources = append(sources, r.Source())
+	}
+	assert.Equal(t, []string{"sram_macro", "core"}, sources)
+
+	results, err = m.Retrieve(context.Background(), "unrelated words", 5)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Retrieve(ctx, "core", 5)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	g, _ := sample(t)
+	b := stub{
+		// Both sources, the second one citing the evidence.
+		g.Questions[0].Text: {
+			{URI: "s3://docs/rtl/top.sv", Text: "module top; core u_core0(); core u_core1();"},
+			{URI: "s3://docs/rtl/core.sv", Text: "core is instantiated 2 times"},
+		},
+		// The source second; the first result holds the evidence but is
+		// another document.
+		g.Questions[1].Text: {
+			{URI: "s3://docs/notes/memories.md", Text: "u_sram: sram_macro"},
+			{URI: "s3://docs/rtl/core.sv", Text: "u_sram: sram_macro"},
+			{URI: "s3://docs/rtl/extra.sv", Text: "beyond k"},
+		},
+		// Nothing.
+	}
+	r, err := Evaluate(context.Background(), b, "stub", g)
+	require.NoError(t, err)
+	assert.Equal(t, []QuestionReport{
+		{ID: "cores", Retrieved: []string{"top", "core"}, Recall: 1, ReciprocalRank: 1, Citation: true},
+		{ID: "sram", Retrieved: []string{"memories", "core"}, Recall: 1, ReciprocalRank: 0.5, Citation: false},
+		{ID: "gater", Retrieved: []string{}, Recall: 0, ReciprocalRank: 0, Citation: false},
+	}, r.Questions)
+	assert.Equal(t, "sample", r.Golden)
+	assert.Equal(t, "stub", r.Backend)
+	assert.InDelta(t, 2.0/3, r.Recall, 1e-9)
+	assert.InDelta(t, 0.5, r.MRR, 1e-9)
+	assert.InDelta(t, 1.0/3, r.Citation, 1e-9)
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	g, docs := sample(t)
+	baseline, err := Evaluate(context.Background(), NewMemory(docs), "memory", g)
+	require.NoError(t, err)
+	data, err := baseline.JSON()
+	require.NoError(t, err)
+	saved, err := ParseReport(string(data))
+	require.NoError(t, err)
+	assert.Equal(t, baseline, saved)
+	assert.Empty(t, Compare(saved, baseline, 0))
+
+	worse := *baseline
+	worse.Questions = append([]QuestionReport(nil), baseline.Questions...)
+	worse.Questions[2] = QuestionReport{ID: "gater", Retrieved: []string{"top"}}
+	worse.Recall -= 0.1
+	worse.MRR -= 0.01
+	assert.Equal(t, []string{
+		"recall@k fell from 1.000 to 0.900",
+		"question gater retrieved top, none of its sources",
+	}, Compare(baseline, &worse, 0.05))
+
+	other := *baseline
+	other.K = 5
+	assert.Equal(t, []string{"sample at k=5 is not comparable with a baseline of sample at k=2"}, Compare(baseline, &other, 0))
+}
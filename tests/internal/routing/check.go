@@ -0,0 +1,538 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// OnPrem is the Path.Delivered value for traffic handed to a VPN.
+const OnPrem = "on-prem"
+
+// Path is the result of tracing a destination address from a route table.
+type Path struct {
+	Hops      []Target
+	Delivered string // VPC ID, OnPrem or "internet"; "" when dropped
+	Blackhole string // reason the packet was dropped
+}
+
+func (p Path) String() string {
+	var hops []string
+	for _, h := range p.Hops {
+		hops = append(hops, h.String())
+	}
+	if p.Delivered == "" {
+		return fmt.Sprintf("%s -> dropped: %s", strings.Join(hops, " -> "), p.Blackhole)
+	}
+	return fmt.Sprintf("%s -> %s", strings.Join(hops, " -> "), p.Delivered)
+}
+
+// Via reports whether the path passes through the target.
+func (p Path) Via(t Target) bool {
+	for _, h := range p.Hops {
+		if h == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the longest-prefix match for dst in the route table,
+// including the implicit local route and propagated VGW routes.
+func (n *Network) Lookup(rt *RouteTable, dst net.IP) *Route {
+	routes := append([]*Route(nil), rt.Routes...)
+	if rt.VPC.CIDR != nil {
+		routes = append(routes, &Route{Dest: rt.VPC.CIDR, Target: Target{Kind: Local}, Source: "local"})
+	}
+	for _, g := range rt.Propagates {
+		for _, p := range n.onPrem {
+			routes = append(routes, &Route{Dest: p, Target: Target{VGW, g}, Source: "propagated"})
+		}
+	}
+
+	var best *Route
+	for _, r := range routes {
+		if !r.Dest.Contains(dst) {
+			continue
+		}
+		if best == nil || prefixLen(r.Dest) > prefixLen(best.Dest) {
+			best = r
+		}
+	}
+	return best
+}
+
+// Trace follows dst from a route table through peering connections, transit
+// gateways and VPN gateways until it is delivered or dropped.
+func (n *Network) Trace(rt *RouteTable, dst net.IP) Path {
+	var p Path
+	r := n.Lookup(rt, dst)
+	if r == nil {
+		p.Blackhole = fmt.Sprintf("no route in %s", rt.ID)
+		return p
+	}
+	p.Hops = append(p.Hops, r.Target)
+
+	switch r.Target.Kind {
+	case Local:
+		p.Delivered = rt.VPC.ID
+	case Peering:
+		peer := n.peerings[r.Target.ID].Peer(rt.VPC)
+		switch {
+		case peer == nil:
+			p.Blackhole = fmt.Sprintf("%s does not connect %s", r.Target.ID, rt.VPC.ID)
+		case peer.CIDR == nil || !peer.CIDR.Contains(dst):
+			p.Blackhole = fmt.Sprintf("%s does not contain %s and peering is not transitive", peer.ID, dst)
+		default:
+			p.Delivered = peer.ID
+		}
+	case TGW:
+		tgw := n.tgws[r.Target.ID]
+		var best *TGWRoute
+		for _, tr := range tgw.Routes() {
+			if tr.Dest.Contains(dst) && (best == nil || prefixLen(tr.Dest) > prefixLen(best.Dest)) {
+				best = tr
+			}
+		}
+		switch {
+		case best == nil:
+			p.Blackhole = fmt.Sprintf("no route in %s", tgw.ID)
+		case best.Attachment == nil:
+			p.Blackhole = fmt.Sprintf("blackhole route %s in %s", best.Source, tgw.ID)
+		case best.Attachment.VPN:
+			p.Hops = append(p.Hops, Target{VGW, best.Attachment.ID})
+			p.Delivered = OnPrem
+		default:
+			p.Hops = append(p.Hops, Target{Local, best.Attachment.ID})
+			p.Delivered = best.Attachment.VPC.ID
+		}
+	case VGW:
+		p.Delivered = OnPrem
+	case Internet, NAT:
+		p.Delivered = "internet"
+	default:
+		p.Blackhole = fmt.Sprintf("unsupported target %s", r.Target)
+	}
+	return p
+}
+
+// Finding is a routing problem reported by Analyze.
+type Finding struct {
+	Kind       string // "asymmetric", "missing-route", "blackhole", "wrong-target"
+	Connection string // peering, transit gateway or VPN gateway ID
+	Table      string // route table the failing direction starts from, if any
+	Dest       string // destination CIDR the direction or route should reach
+	Message    string
+}
+
+// Key identifies a finding in the tree by its kind, the route table it
+// starts from, or the connection for findings without one, and its
+// destination, e.g. "blackhole
+// environments/network-layer/module.vpc_logging:aws_route_table.private 192.128.0.0/16".
+func (f Finding) Key() string {
+	from := f.Table
+	if from == "" {
+		from = f.Connection
+	}
+	return f.Kind + " " + from + " " + f.Dest
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Kind, f.Message)
+}
+
+// Analyze checks that every peering connection, transit gateway VPC
+// attachment pair and VPN path routes in both directions, and that routes
+// into peering connections and transit gateways do not blackhole.
+func (n *Network) Analyze() []Finding {
+	var findings []Finding
+	for _, p := range n.Peerings {
+		findings = append(findings, n.checkPair(Target{Peering, p.ID}, p.Requester, p.Accepter)...)
+	}
+	for _, tgw := range n.TransitGateways {
+		target := Target{TGW, tgw.ID}
+		for i, a := range tgw.Attachments {
+			if a.VPN {
+				continue
+			}
+			for _, b := range tgw.Attachments[i+1:] {
+				if !b.VPN {
+					findings = append(findings, n.checkPair(target, a.VPC, b.VPC)...)
+				}
+			}
+		}
+		findings = append(findings, n.checkVPN(tgw)...)
+	}
+	for _, g := range n.VPNGateways {
+		findings = append(findings, n.checkVGW(g)...)
+	}
+	findings = append(findings, n.checkBlackholes()...)
+	return findings
+}
+
+// tablesFor returns the route tables of v that take part in a connection:
+// those associated with subnets and those that already route some traffic
+// through the connection.
+func (n *Network) tablesFor(v *VPC, via Target) []*RouteTable {
+	var out []*RouteTable
+	for _, rt := range v.RouteTables {
+		uses := rt.Associated
+		for _, r := range rt.Routes {
+			if r.Target == via {
+				uses = true
+			}
+		}
+		for _, g := range rt.Propagates {
+			if (Target{VGW, g}) == via {
+				uses = true
+			}
+		}
+		if uses {
+			out = append(out, rt)
+		}
+	}
+	return out
+}
+
+// direction is the result of tracing the CIDR of one VPC from the route
+// tables of another.
+type direction struct {
+	from, to *VPC
+	tables   []*RouteTable
+	failed   map[*RouteTable][]*net.IPNet // parts of the CIDR that are not delivered through via
+	paths    map[*RouteTable]Path         // path of the first failed part
+	ok       bool
+}
+
+func (n *Network) trace(via Target, from, to *VPC) direction {
+	d := direction{from: from, to: to, failed: map[*RouteTable][]*net.IPNet{}, paths: map[*RouteTable]Path{}, ok: true}
+	if to.CIDR != nil {
+		d.tables = n.tablesFor(from, via)
+	}
+	if len(d.tables) == 0 {
+		d.ok = false
+		return d
+	}
+	for _, rt := range d.tables {
+		failed, p := n.traceCIDR(rt, to.CIDR, func(p Path) bool { return p.Delivered == to.ID && p.Via(via) })
+		if len(failed) > 0 {
+			d.failed[rt], d.paths[rt] = failed, p
+			d.ok = false
+		}
+	}
+	return d
+}
+
+// traceCIDR traces every part of dst that the route tables of the network
+// may route differently, and returns the parts whose path delivered
+// rejects, with the path of the first of them.
+func (n *Network) traceCIDR(rt *RouteTable, dst *net.IPNet, delivered func(Path) bool) ([]*net.IPNet, Path) {
+	var failed []*net.IPNet
+	var first Path
+	for _, part := range parts(dst, n.prefixes(rt)) {
+		p := n.Trace(rt, firstHost(part))
+		if delivered(p) {
+			continue
+		}
+		if len(failed) == 0 {
+			first = p
+		}
+		failed = append(failed, part)
+	}
+	return merge(failed), first
+}
+
+// prefixes returns the destinations that can route parts of a CIDR
+// differently from rt: its routes and the implicit ones, the CIDRs of the
+// VPCs and the routes of the transit gateways.
+func (n *Network) prefixes(rt *RouteTable) []*net.IPNet {
+	out := append([]*net.IPNet(nil), n.onPrem...)
+	for _, r := range rt.Routes {
+		out = append(out, r.Dest)
+	}
+	for _, v := range n.VPCs {
+		if v.CIDR != nil {
+			out = append(out, v.CIDR)
+		}
+	}
+	for _, tgw := range n.TransitGateways {
+		for _, r := range tgw.Routes() {
+			out = append(out, r.Dest)
+		}
+	}
+	return out
+}
+
+// checkPair traces both directions between two VPCs that should reach each
+// other through via.
+func (n *Network) checkPair(via Target, a, b *VPC) []Finding {
+	fwd := n.trace(via, a, b)
+	rev := n.trace(via, b, a)
+
+	var findings []Finding
+	for _, d := range []direction{fwd, rev} {
+		other := rev
+		if d.from == b {
+			other = fwd
+		}
+		if len(d.tables) == 0 {
+			findings = append(findings, Finding{Kind: "missing-route", Connection: via.ID, Dest: fmt.Sprint(d.to.CIDR),
+				Message: fmt.Sprintf("%s has no route table that uses %s", d.from.ID, via)})
+			continue
+		}
+		for _, rt := range sortedTables(d.paths) {
+			p := d.paths[rt]
+			kind := "missing-route"
+			switch {
+			case other.ok:
+				kind = "asymmetric"
+			case p.Delivered == d.to.ID:
+				kind = "wrong-target"
+			case len(p.Hops) > 0:
+				kind = "blackhole"
+			}
+			findings = append(findings, Finding{Kind: kind, Connection: via.ID, Table: rt.ID, Dest: d.to.CIDR.String(),
+				Message: fmt.Sprintf("%s to %s via %s: %s", rt.ID, summarize(d.failed[rt]), via, p)})
+		}
+	}
+	return findings
+}
+
+// checkVPN verifies that every VPC attached to the transit gateway routes
+// the on-premises prefixes, and the destinations of static routes to VPN
+// attachments, through the transit gateway to a VPN, and that the transit
+// gateway routes each VPC CIDR back to its attachment.
+func (n *Network) checkVPN(tgw *TransitGateway) []Finding {
+	var prefixes []*net.IPNet
+	hasVPN := false
+	for _, a := range tgw.Attachments {
+		if a.VPN {
+			hasVPN = true
+			if a.Propagates {
+				prefixes = append(prefixes, n.onPrem...)
+			}
+		}
+	}
+	for _, r := range tgw.Static {
+		if r.Attachment != nil && r.Attachment.VPN {
+			prefixes = append(prefixes, r.Dest)
+		}
+	}
+	if !hasVPN {
+		return nil
+	}
+
+	via := Target{TGW, tgw.ID}
+	var findings []Finding
+	for _, a := range tgw.Attachments {
+		if a.VPN {
+			continue
+		}
+		back := false
+		for _, r := range tgw.Routes() {
+			if r.Attachment == a && a.VPC.CIDR != nil && r.Dest.Contains(a.VPC.CIDR.IP) {
+				back = true
+			}
+		}
+
+		forward := false
+		for _, prefix := range prefixes {
+			for _, rt := range n.tablesFor(a.VPC, via) {
+				failed, p := n.traceCIDR(rt, prefix, func(p Path) bool { return p.Delivered == OnPrem && p.Via(via) })
+				if len(failed) == 0 {
+					forward = true
+					continue
+				}
+				kind := "missing-route"
+				switch {
+				case back:
+					kind = "asymmetric"
+				case p.Delivered == OnPrem:
+					kind = "wrong-target"
+				case len(p.Hops) > 0:
+					kind = "blackhole"
+				}
+				findings = append(findings, Finding{Kind: kind, Connection: tgw.ID, Table: rt.ID, Dest: prefix.String(),
+					Message: fmt.Sprintf("%s to on-prem %s via %s: %s", rt.ID, summarize(failed), via, p)})
+			}
+		}
+
+		if !back {
+			kind := "missing-route"
+			if forward {
+				kind = "asymmetric"
+			}
+			findings = append(findings, Finding{Kind: kind, Connection: tgw.ID, Dest: fmt.Sprint(a.VPC.CIDR),
+				Message: fmt.Sprintf("%s has no route back to %s (%s)", tgw.ID, a.VPC.ID, a.VPC.CIDR)})
+		}
+	}
+	return findings
+}
+
+// checkVGW verifies that the route tables of a VGW's VPC route the
+// on-premises prefixes to it.
+func (n *Network) checkVGW(g *VPNGateway) []Finding {
+	if g.VPC == nil {
+		return nil
+	}
+	via := Target{VGW, g.ID}
+	var findings []Finding
+	for _, rt := range n.tablesFor(g.VPC, via) {
+		for _, prefix := range n.onPrem {
+			failed, p := n.traceCIDR(rt, prefix, func(p Path) bool { return p.Delivered == OnPrem && p.Via(via) })
+			if len(failed) == 0 {
+				continue
+			}
+			findings = append(findings, Finding{Kind: "missing-route", Connection: g.ID, Table: rt.ID, Dest: prefix.String(),
+				Message: fmt.Sprintf("%s to on-prem %s via %s: %s", rt.ID, summarize(failed), via, p)})
+		}
+	}
+	return findings
+}
+
+// checkBlackholes reports routes whose destination is not, or only partly,
+// reachable beyond the next hop: peering routes to addresses outside the
+// peer VPC and transit gateway routes to prefixes the transit gateway route
+// table does not cover.
+func (n *Network) checkBlackholes() []Finding {
+	var findings []Finding
+	for _, rt := range n.RouteTables {
+		for _, r := range rt.Routes {
+			switch r.Target.Kind {
+			case Peering:
+				peer := n.peerings[r.Target.ID].Peer(rt.VPC)
+				if peer == nil || peer.CIDR == nil || !contains(peer.CIDR, r.Dest) {
+					findings = append(findings, Finding{Kind: "blackhole", Connection: r.Target.ID, Table: rt.ID, Dest: r.Dest.String(),
+						Message: fmt.Sprintf("%s routes %s to %s, which only reaches %v", rt.ID, r.Dest, r.Target, cidrOrNil(peer))})
+				}
+			case TGW:
+				var covered []*net.IPNet
+				for _, tr := range n.tgws[r.Target.ID].Routes() {
+					if tr.Attachment != nil {
+						covered = append(covered, tr.Dest)
+					}
+				}
+				if gaps := uncovered(r.Dest, covered); len(gaps) > 0 {
+					findings = append(findings, Finding{Kind: "blackhole", Connection: r.Target.ID, Table: rt.ID, Dest: r.Dest.String(),
+						Message: fmt.Sprintf("%s routes %s (%s) to %s, which has no route for %s", rt.ID, r.Dest, r.Source, r.Target, summarize(gaps))})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// uncovered returns the parts of p that no prefix in covers contains, as
+// a list of CIDRs.
+func uncovered(p *net.IPNet, covers []*net.IPNet) []*net.IPNet {
+	for _, c := range covers {
+		if contains(c, p) {
+			return nil
+		}
+	}
+	inside := false
+	for _, c := range covers {
+		if contains(p, c) {
+			inside = true
+		}
+	}
+	bits := len(p.IP) * 8
+	ones := prefixLen(p)
+	if !inside || ones == bits {
+		return []*net.IPNet{p}
+	}
+	lo, hi := split(p)
+	return append(uncovered(lo, covers), uncovered(hi, covers)...)
+}
+
+// parts splits p at every prefix of bounds inside it, so that no prefix
+// covers only part of a result.
+func parts(p *net.IPNet, bounds []*net.IPNet) []*net.IPNet {
+	for _, b := range bounds {
+		if prefixLen(b) > prefixLen(p) && p.Contains(b.IP) {
+			lo, hi := split(p)
+			return append(parts(lo, bounds), parts(hi, bounds)...)
+		}
+	}
+	return []*net.IPNet{p}
+}
+
+// merge joins adjacent halves of the sorted CIDRs back into their parent.
+func merge(cidrs []*net.IPNet) []*net.IPNet {
+	var out []*net.IPNet
+	for _, c := range cidrs {
+		out = append(out, c)
+		for len(out) > 1 {
+			lo, hi := out[len(out)-2], out[len(out)-1]
+			ones, bits := lo.Mask.Size()
+			if ones == 0 || prefixLen(hi) != ones {
+				break
+			}
+			mask := net.CIDRMask(ones-1, bits)
+			parent := &net.IPNet{IP: lo.IP.Mask(mask), Mask: mask}
+			if l, h := split(parent); l.String() != lo.String() || h.String() != hi.String() {
+				break
+			}
+			out = append(out[:len(out)-2], parent)
+		}
+	}
+	return out
+}
+
+func split(p *net.IPNet) (*net.IPNet, *net.IPNet) {
+	ones, bits := p.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	lo := &net.IPNet{IP: p.IP.Mask(mask), Mask: mask}
+	hiIP := make(net.IP, len(p.IP))
+	copy(hiIP, p.IP)
+	hiIP[ones/8] |= 0x80 >> (ones % 8)
+	return lo, &net.IPNet{IP: hiIP.Mask(mask), Mask: mask}
+}
+
+// summarize formats a list of gaps, collapsing long lists.
+func summarize(gaps []*net.IPNet) string {
+	var s []string
+	for _, g := range gaps {
+		s = append(s, g.String())
+	}
+	if len(s) > 4 {
+		return fmt.Sprintf("%s and %d more", strings.Join(s[:4], ", "), len(s)-4)
+	}
+	return strings.Join(s, ", ")
+}
+
+// contains reports whether outer contains all of inner.
+func contains(outer, inner *net.IPNet) bool {
+	return prefixLen(outer) <= prefixLen(inner) && outer.Contains(inner.IP)
+}
+
+func prefixLen(p *net.IPNet) int {
+	ones, _ := p.Mask.Size()
+	return ones
+}
+
+func firstHost(p *net.IPNet) net.IP {
+	ip := make(net.IP, len(p.IP))
+	copy(ip, p.IP)
+	if prefixLen(p) < len(ip)*8 {
+		ip[len(ip)-1]++
+	}
+	return ip
+}
+
+func cidrOrNil(v *VPC) interface{} {
+	if v == nil || v.CIDR == nil {
+		return nil
+	}
+	return v.CIDR
+}
+
+func sortedTables(paths map[*RouteTable]Path) []*RouteTable {
+	out := make([]*RouteTable, 0, len(paths))
+	for rt := range paths {
+		out = append(out, rt)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
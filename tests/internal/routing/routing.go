@@ -0,0 +1,517 @@
+// Package routing models the VPC route tables, peering connections, transit
+// gateways and VPN gateways of a Terraform tree and traces packets through
+// them.
+//
+// Route tables are modelled per resource rather than per count instance: an
+// aws_route whose route_table_id indexes a module output list is assumed to
+// cover every table in the list, which is how this repository writes them.
+// Prefixes that on-premises networks advertise over BGP are not visible in
+// the configuration and are passed in through Options.
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// TargetKind is the kind of next hop of a route.
+type TargetKind string
+
+const (
+	Local    TargetKind = "local"
+	Peering  TargetKind = "peering"
+	TGW      TargetKind = "tgw"
+	VGW      TargetKind = "vgw"
+	Internet TargetKind = "igw"
+	NAT      TargetKind = "nat"
+	Other    TargetKind = "other" // endpoints, ENIs and targets that did not resolve
+)
+
+// Target is the next hop of a route. ID is the node of the target resource,
+// e.g. "environments/network-layer:aws_ec2_transit_gateway.main".
+type Target struct {
+	Kind TargetKind
+	ID   string
+}
+
+func (t Target) String() string {
+	if t.ID == "" {
+		return string(t.Kind)
+	}
+	return fmt.Sprintf("%s(%s)", t.Kind, t.ID)
+}
+
+// VPC is an aws_vpc resource with its primary CIDR.
+type VPC struct {
+	ID          string
+	CIDR        *net.IPNet
+	RouteTables []*RouteTable
+}
+
+// RouteTable is an aws_route_table resource.
+type RouteTable struct {
+	ID         string
+	VPC        *VPC
+	Associated bool     // has an aws_route_table_association or main route table association
+	Propagates []string // VGW IDs whose routes are propagated into the table
+	Routes     []*Route
+}
+
+// Route is a static route in a route table.
+type Route struct {
+	Dest   *net.IPNet
+	Target Target
+	Source string // "inline" or the address of the aws_route resource
+}
+
+// PeeringConnection is an aws_vpc_peering_connection between two VPCs.
+type PeeringConnection struct {
+	ID        string
+	Requester *VPC
+	Accepter  *VPC
+}
+
+// Peer returns the VPC on the other side of the connection from v, or nil
+// if v is not part of it.
+func (p *PeeringConnection) Peer(v *VPC) *VPC {
+	switch v {
+	case p.Requester:
+		return p.Accepter
+	case p.Accepter:
+		return p.Requester
+	}
+	return nil
+}
+
+// Attachment is a transit gateway attachment. VPC attachments propagate the
+// VPC CIDR; VPN attachments propagate the on-premises prefixes.
+type Attachment struct {
+	ID         string
+	VPN        bool
+	VPC        *VPC // nil for VPN attachments
+	Propagates bool
+}
+
+// TGWRoute is a route in a transit gateway's default route table.
+type TGWRoute struct {
+	Dest       *net.IPNet
+	Attachment *Attachment // nil for blackhole routes
+	Source     string      // address of the static route, or "propagated"
+}
+
+// TransitGateway is an aws_ec2_transit_gateway with its attachments and the
+// routes of its default route table.
+type TransitGateway struct {
+	ID          string
+	Attachments []*Attachment
+	Static      []*TGWRoute
+
+	// Propagation is the default_route_table_propagation setting; new
+	// attachments propagate into the default route table when it is set.
+	Propagation bool
+
+	onPrem []*net.IPNet
+}
+
+// Routes returns the static and propagated routes of the default route
+// table.
+func (t *TransitGateway) Routes() []*TGWRoute {
+	routes := append([]*TGWRoute(nil), t.Static...)
+	for _, a := range t.Attachments {
+		if !a.Propagates {
+			continue
+		}
+		if a.VPN {
+			for _, p := range t.onPrem {
+				routes = append(routes, &TGWRoute{Dest: p, Attachment: a, Source: "propagated"})
+			}
+		} else if a.VPC != nil && a.VPC.CIDR != nil {
+			routes = append(routes, &TGWRoute{Dest: a.VPC.CIDR, Attachment: a, Source: "propagated"})
+		}
+	}
+	return routes
+}
+
+// VPNGateway is an aws_vpn_gateway attached to a VPC.
+type VPNGateway struct {
+	ID  string
+	VPC *VPC
+}
+
+// Options configures Build.
+type Options struct {
+	// OnPremPrefixes are the prefixes the customer gateways advertise over
+	// BGP. They are propagated into transit gateways through VPN
+	// attachments and into route tables that propagate a VGW.
+	OnPremPrefixes []string
+}
+
+// Network is the routing model of a tree.
+type Network struct {
+	VPCs            []*VPC
+	RouteTables     []*RouteTable
+	Peerings        []*PeeringConnection
+	TransitGateways []*TransitGateway
+	VPNGateways     []*VPNGateway
+
+	// Unresolved lists routes and attachments whose route table, VPC or
+	// target could not be resolved statically.
+	Unresolved []string
+
+	onPrem []*net.IPNet
+	tree   *tfconfig.Tree
+	refs   *tfconfig.Graph
+
+	vpcs     map[string]*VPC
+	tables   map[string]*RouteTable
+	peerings map[string]*PeeringConnection
+	tgws     map[string]*TransitGateway
+	attach   map[string]*Attachment
+	vgws     map[string]*VPNGateway
+}
+
+// Build collects the routing model of every stack in the tree.
+func Build(tree *tfconfig.Tree, opts Options) (*Network, error) {
+	n := &Network{
+		tree:     tree,
+		refs:     tree.RefGraph(),
+		vpcs:     map[string]*VPC{},
+		tables:   map[string]*RouteTable{},
+		peerings: map[string]*PeeringConnection{},
+		tgws:     map[string]*TransitGateway{},
+		attach:   map[string]*Attachment{},
+		vgws:     map[string]*VPNGateway{},
+	}
+	for _, p := range opts.OnPremPrefixes {
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("on-premises prefix: %w", err)
+		}
+		n.onPrem = append(n.onPrem, cidr)
+	}
+
+	// Objects first, so that routes and attachments can refer to them
+	// regardless of module order.
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_vpc")) {
+			v := &VPC{ID: nodeID(m, b)}
+			if c, ok := b.String("cidr_block"); ok {
+				_, v.CIDR, _ = net.ParseCIDR(c)
+			}
+			n.vpcs[v.ID] = v
+			n.VPCs = append(n.VPCs, v)
+		}
+		for _, b := range instantiated(m.Resources("aws_ec2_transit_gateway")) {
+			t := &TransitGateway{ID: nodeID(m, b), Propagation: true, onPrem: n.onPrem}
+			if v, ok := b.String("default_route_table_propagation"); ok {
+				t.Propagation = v == "enable"
+			}
+			n.tgws[t.ID] = t
+			n.TransitGateways = append(n.TransitGateways, t)
+		}
+	}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_route_table")) {
+			rt := &RouteTable{ID: nodeID(m, b), VPC: n.VPCOf(m, b.Expr("vpc_id"))}
+			if rt.VPC == nil {
+				n.Unresolved = append(n.Unresolved, rt.ID+": vpc_id")
+				continue
+			}
+			n.tables[rt.ID] = rt
+			n.RouteTables = append(n.RouteTables, rt)
+			rt.VPC.RouteTables = append(rt.VPC.RouteTables, rt)
+		}
+		for _, b := range instantiated(m.Resources("aws_vpc_peering_connection")) {
+			p := &PeeringConnection{ID: nodeID(m, b), Requester: n.VPCOf(m, b.Expr("vpc_id")), Accepter: n.VPCOf(m, b.Expr("peer_vpc_id"))}
+			if p.Requester == nil || p.Accepter == nil {
+				n.Unresolved = append(n.Unresolved, p.ID+": vpc_id/peer_vpc_id")
+				continue
+			}
+			n.peerings[p.ID] = p
+			n.Peerings = append(n.Peerings, p)
+		}
+		for _, b := range instantiated(m.Resources("aws_vpn_gateway")) {
+			g := &VPNGateway{ID: nodeID(m, b), VPC: n.VPCOf(m, b.Expr("vpc_id"))}
+			n.vgws[g.ID] = g
+			n.VPNGateways = append(n.VPNGateways, g)
+		}
+		n.collectAttachments(m)
+	}
+	for _, m := range tree.Modules {
+		n.collectRoutes(m)
+	}
+
+	sort.Strings(n.Unresolved)
+	return n, nil
+}
+
+func (n *Network) collectAttachments(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_ec2_transit_gateway_vpc_attachment")) {
+		id := nodeID(m, b)
+		tgw := n.tgwOf(m, b.Expr("transit_gateway_id"))
+		vpc := n.VPCOf(m, b.Expr("vpc_id"))
+		if tgw == nil || vpc == nil {
+			n.Unresolved = append(n.Unresolved, id+": transit_gateway_id/vpc_id")
+			continue
+		}
+		propagates := true
+		if v, ok := b.Bool("transit_gateway_default_route_table_propagation"); ok {
+			propagates = v
+		}
+		a := &Attachment{ID: id, VPC: vpc, Propagates: propagates && tgw.Propagation}
+		n.attach[id] = a
+		tgw.Attachments = append(tgw.Attachments, a)
+	}
+
+	// VPN attachments are either looked up for an existing connection or
+	// created implicitly by an aws_vpn_connection with a transit gateway.
+	var vpns []*tfconfig.Block
+	vpns = append(vpns, instantiated(m.DataSources("aws_ec2_transit_gateway_vpn_attachment"))...)
+	vpns = append(vpns, instantiated(m.Resources("aws_vpn_connection"))...)
+	for _, b := range vpns {
+		if !b.Has("transit_gateway_id") {
+			continue
+		}
+		id := nodeID(m, b)
+		tgw := n.tgwOf(m, b.Expr("transit_gateway_id"))
+		if tgw == nil {
+			n.Unresolved = append(n.Unresolved, id+": transit_gateway_id")
+			continue
+		}
+		a := &Attachment{ID: id, VPN: true, Propagates: tgw.Propagation}
+		n.attach[id] = a
+		tgw.Attachments = append(tgw.Attachments, a)
+	}
+}
+
+func (n *Network) collectRoutes(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_route_table")) {
+		rt := n.tables[nodeID(m, b)]
+		if rt == nil {
+			continue
+		}
+		for _, rb := range b.Nested("route") {
+			dest, ok := n.cidrOf(m, rb.Expr("cidr_block"))
+			if !ok {
+				n.Unresolved = append(n.Unresolved, rt.ID+": inline route destination")
+				continue
+			}
+			rt.Routes = append(rt.Routes, &Route{Dest: dest, Target: n.targetOf(m, rb), Source: "inline"})
+		}
+		for _, g := range n.nodesOf(m, b.Expr("propagating_vgws")) {
+			if _, ok := n.vgws[g]; ok {
+				rt.Propagates = append(rt.Propagates, g)
+			}
+		}
+	}
+
+	for _, b := range instantiated(m.Resources("aws_route")) {
+		id := nodeID(m, b)
+		tables := n.RouteTablesOf(m, b.Expr("route_table_id"))
+		if len(tables) == 0 {
+			n.Unresolved = append(n.Unresolved, id+": route_table_id")
+			continue
+		}
+		dest, ok := n.cidrOf(m, b.Expr("destination_cidr_block"))
+		if !ok {
+			n.Unresolved = append(n.Unresolved, id+": destination_cidr_block")
+			continue
+		}
+		target := n.targetOf(m, b)
+		for _, rt := range tables {
+			rt.Routes = append(rt.Routes, &Route{Dest: dest, Target: target, Source: b.Address()})
+		}
+	}
+
+	for _, typ := range []string{"aws_route_table_association", "aws_main_route_table_association"} {
+		for _, b := range instantiated(m.Resources(typ)) {
+			for _, rt := range n.RouteTablesOf(m, b.Expr("route_table_id")) {
+				rt.Associated = true
+			}
+		}
+	}
+
+	for _, b := range instantiated(m.Resources("aws_vpn_gateway_route_propagation")) {
+		gws := n.nodesOf(m, b.Expr("vpn_gateway_id"))
+		for _, rt := range n.RouteTablesOf(m, b.Expr("route_table_id")) {
+			for _, g := range gws {
+				if _, ok := n.vgws[g]; ok {
+					rt.Propagates = append(rt.Propagates, g)
+				}
+			}
+		}
+	}
+
+	for _, b := range instantiated(m.Resources("aws_ec2_transit_gateway_route")) {
+		id := nodeID(m, b)
+		tgw := n.tgwOf(m, b.Expr("transit_gateway_route_table_id"))
+		dest, ok := n.cidrOf(m, b.Expr("destination_cidr_block"))
+		if tgw == nil || !ok {
+			n.Unresolved = append(n.Unresolved, id+": transit_gateway_route_table_id/destination_cidr_block")
+			continue
+		}
+		r := &TGWRoute{Dest: dest, Source: b.Address()}
+		if blackhole, _ := b.Bool("blackhole"); !blackhole {
+			for _, a := range n.nodesOf(m, b.Expr("transit_gateway_attachment_id")) {
+				if r.Attachment = n.attach[a]; r.Attachment != nil {
+					break
+				}
+			}
+			if r.Attachment == nil {
+				n.Unresolved = append(n.Unresolved, id+": transit_gateway_attachment_id")
+				continue
+			}
+		}
+		tgw.Static = append(tgw.Static, r)
+	}
+}
+
+func (n *Network) targetOf(m *tfconfig.Module, b *tfconfig.Block) Target {
+	attrs := []struct {
+		name string
+		kind TargetKind
+	}{
+		{"vpc_peering_connection_id", Peering},
+		{"transit_gateway_id", TGW},
+		{"nat_gateway_id", NAT},
+		{"gateway_id", ""}, // internet or VPN gateway
+	}
+	for _, a := range attrs {
+		if !b.Has(a.name) {
+			continue
+		}
+		for _, id := range n.nodesOf(m, b.Expr(a.name)) {
+			switch {
+			case a.kind == Peering && n.peerings[id] != nil:
+				return Target{Peering, id}
+			case a.kind == TGW && n.tgws[id] != nil:
+				return Target{TGW, id}
+			case a.kind == NAT:
+				return Target{NAT, id}
+			case a.kind == "" && n.vgws[id] != nil:
+				return Target{VGW, id}
+			case a.kind == "" && strings.Contains(id, ":aws_internet_gateway."):
+				return Target{Internet, id}
+			}
+		}
+		if v, ok := b.String(a.name); ok && v == "local" {
+			return Target{Kind: Local}
+		}
+		return Target{Kind: Other, ID: a.name}
+	}
+	return Target{Kind: Other}
+}
+
+// nodesOf resolves an expression to the IDs of the resources and data
+// sources it ultimately refers to.
+func (n *Network) nodesOf(m *tfconfig.Module, expr hclsyntax.Expression) []string {
+	if expr == nil {
+		return nil
+	}
+	var out []string
+	for _, node := range n.refs.ResolveExpr(m, expr) {
+		out = append(out, node.String())
+	}
+	return out
+}
+
+// VPCOf resolves an expression in m to the VPC it refers to: a VPC, a
+// data "aws_vpc" looked up by the ID of a managed VPC, or a subnet.
+func (n *Network) VPCOf(m *tfconfig.Module, expr hclsyntax.Expression) *VPC {
+	for _, id := range n.nodesOf(m, expr) {
+		if v := n.vpcs[id]; v != nil {
+			return v
+		}
+		b := n.block(id)
+		switch {
+		case b == nil:
+		case b.Type == "data" && b.Kind() == "aws_vpc":
+			if v := n.VPCOf(b.Module(), b.Expr("id")); v != nil {
+				return v
+			}
+		case b.Type == "resource" && b.Kind() == "aws_subnet":
+			if v := n.VPCOf(b.Module(), b.Expr("vpc_id")); v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// VPC returns a VPC by ID.
+func (n *Network) VPC(id string) *VPC {
+	return n.vpcs[id]
+}
+
+func (n *Network) tgwOf(m *tfconfig.Module, expr hclsyntax.Expression) *TransitGateway {
+	for _, id := range n.nodesOf(m, expr) {
+		if t := n.tgws[id]; t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// RouteTablesOf resolves an expression in m to the route tables it refers
+// to.
+func (n *Network) RouteTablesOf(m *tfconfig.Module, expr hclsyntax.Expression) []*RouteTable {
+	var out []*RouteTable
+	for _, id := range n.nodesOf(m, expr) {
+		if rt := n.tables[id]; rt != nil {
+			out = append(out, rt)
+		}
+	}
+	return out
+}
+
+// cidrOf evaluates a destination CIDR, falling back to the CIDR of the VPC
+// the expression refers to (e.g. data.aws_vpc.requester.cidr_block).
+func (n *Network) cidrOf(m *tfconfig.Module, expr hclsyntax.Expression) (*net.IPNet, bool) {
+	if expr == nil {
+		return nil, false
+	}
+	if s, ok := m.PartialString(expr); ok && !strings.Contains(s, tfconfig.Unknown) {
+		_, cidr, err := net.ParseCIDR(s)
+		return cidr, err == nil
+	}
+	if v := n.VPCOf(m, expr); v != nil && v.CIDR != nil {
+		return v.CIDR, true
+	}
+	return nil, false
+}
+
+// block returns the block of a node ID.
+func (n *Network) block(id string) *tfconfig.Block {
+	i := strings.LastIndex(id, ":")
+	if i < 0 {
+		return nil
+	}
+	for _, m := range n.tree.Modules {
+		if m.Name == id[:i] {
+			return tfconfig.Node{Module: m, Addr: id[i+1:]}.Block()
+		}
+	}
+	return nil
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+// instantiated drops blocks whose count is known to be zero.
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
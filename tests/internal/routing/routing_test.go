@@ -0,0 +1,220 @@
+package routing
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleNetwork has three VPCs: a and b are peered, but b only routes the
+// lower half of a back;
+// a and c are attached to a transit gateway with a VPN; d has a VGW whose
+// routes are propagated into its route table.
+const sampleNetwork = `
+resource "aws_vpc" "a" {
+  cidr_block = "10.1.0.0/16"
+}
+
+resource "aws_vpc" "b" {
+  cidr_block = "10.2.0.0/16"
+}
+
+resource "aws_vpc" "c" {
+  cidr_block = "10.3.0.0/16"
+}
+
+resource "aws_vpc" "d" {
+  cidr_block = "10.4.0.0/16"
+}
+
+resource "aws_route_table" "a" {
+  vpc_id = aws_vpc.a.id
+}
+
+resource "aws_route_table" "b" {
+  vpc_id = aws_vpc.b.id
+}
+
+resource "aws_route_table" "c" {
+  vpc_id = aws_vpc.c.id
+
+  route {
+    cidr_block         = "10.1.0.0/16"
+    transit_gateway_id = aws_ec2_transit_gateway.hub.id
+  }
+}
+
+resource "aws_route_table" "d" {
+  vpc_id           = aws_vpc.d.id
+  propagating_vgws = [aws_vpn_gateway.d.id]
+}
+
+resource "aws_route_table_association" "a" {
+  route_table_id = aws_route_table.a.id
+}
+
+resource "aws_route_table_association" "b" {
+  route_table_id = aws_route_table.b.id
+}
+
+resource "aws_vpc_peering_connection" "ab" {
+  vpc_id      = aws_vpc.a.id
+  peer_vpc_id = aws_vpc.b.id
+}
+
+resource "aws_route" "a_to_b" {
+  route_table_id            = aws_route_table.a.id
+  destination_cidr_block    = aws_vpc.b.cidr_block
+  vpc_peering_connection_id = aws_vpc_peering_connection.ab.id
+}
+
+resource "aws_ec2_transit_gateway" "hub" {}
+
+resource "aws_ec2_transit_gateway_vpc_attachment" "a" {
+  transit_gateway_id = aws_ec2_transit_gateway.hub.id
+  vpc_id             = aws_vpc.a.id
+}
+
+resource "aws_ec2_transit_gateway_vpc_attachment" "c" {
+  transit_gateway_id = aws_ec2_transit_gateway.hub.id
+  vpc_id             = aws_vpc.c.id
+
+  transit_gateway_default_route_table_propagation = false
+}
+
+resource "aws_vpn_connection" "onprem" {
+  transit_gateway_id = aws_ec2_transit_gateway.hub.id
+}
+
+resource "aws_ec2_transit_gateway_route" "dead" {
+  destination_cidr_block         = "10.9.0.0/16"
+  blackhole                      = true
+  transit_gateway_route_table_id = aws_ec2_transit_gateway.hub.association_default_route_table_id
+}
+
+resource "aws_route" "b_to_a" {
+  route_table_id            = aws_route_table.b.id
+  destination_cidr_block    = "10.1.0.0/17"
+  vpc_peering_connection_id = aws_vpc_peering_connection.ab.id
+}
+
+resource "aws_route" "a_to_hub" {
+  route_table_id         = aws_route_table.a.id
+  destination_cidr_block = "10.0.0.0/8"
+  transit_gateway_id     = aws_ec2_transit_gateway.hub.id
+}
+
+resource "aws_route" "a_to_onprem" {
+  route_table_id         = aws_route_table.a.id
+  destination_cidr_block = "192.168.0.0/16"
+  transit_gateway_id     = aws_ec2_transit_gateway.hub.id
+}
+
+resource "aws_vpn_gateway" "d" {
+  vpc_id = aws_vpc.d.id
+}
+`
+
+func loadSample(t *testing.T) *Network {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "net")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleNetwork), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	n, err := Build(tree, Options{OnPremPrefixes: []string{"192.168.0.0/16"}})
+	require.NoError(t, err)
+	require.Empty(t, n.Unresolved)
+	return n
+}
+
+func (n *Network) table(name string) *RouteTable {
+	return n.tables["environments/net:aws_route_table."+name]
+}
+
+func TestTrace_FollowsHops(t *testing.T) {
+	t.Parallel()
+
+	n := loadSample(t)
+
+	p := n.Trace(n.table("a"), net.ParseIP("10.2.0.5"))
+	assert.Equal(t, "environments/net:aws_vpc.b", p.Delivered, "%s", p)
+	assert.True(t, p.Via(Target{Peering, "environments/net:aws_vpc_peering_connection.ab"}), "more specific peering route wins over 10/8")
+
+	p = n.Trace(n.table("a"), net.ParseIP("10.3.0.5"))
+	assert.Empty(t, p.Delivered, "c does not propagate into the transit gateway: %s", p)
+
+	p = n.Trace(n.table("a"), net.ParseIP("10.9.0.5"))
+	assert.Contains(t, p.Blackhole, "blackhole route")
+
+	p = n.Trace(n.table("c"), net.ParseIP("10.1.0.5"))
+	assert.Equal(t, "environments/net:aws_vpc.a", p.Delivered, "%s", p)
+
+	p = n.Trace(n.table("a"), net.ParseIP("192.168.1.1"))
+	assert.Equal(t, OnPrem, p.Delivered, "%s", p)
+
+	p = n.Trace(n.table("d"), net.ParseIP("192.168.1.1"))
+	assert.Equal(t, OnPrem, p.Delivered, "VGW routes are propagated: %s", p)
+
+	p = n.Trace(n.table("b"), net.ParseIP("10.1.0.5"))
+	assert.Equal(t, "environments/net:aws_vpc.a", p.Delivered, "%s", p)
+
+	p = n.Trace(n.table("b"), net.ParseIP("10.1.200.5"))
+	assert.Contains(t, p.Blackhole, "no route")
+}
+
+func TestAnalyze_ReportsAsymmetricAndBlackholedPaths(t *testing.T) {
+	t.Parallel()
+
+	n := loadSample(t)
+
+	var got []string
+	for _, f := range n.Analyze() {
+		got = append(got, f.Key())
+	}
+
+	assert.Equal(t, []string{
+		// b has no route back to the upper half of a over the peering
+		// connection.
+		"asymmetric environments/net:aws_route_table.b 10.1.0.0/16",
+		// c does not propagate into the hub: a cannot reach it, and the
+		// on-prem path out of c has neither a route nor a return route.
+		"asymmetric environments/net:aws_route_table.a 10.3.0.0/16",
+		"missing-route environments/net:aws_route_table.c 192.168.0.0/16",
+		"missing-route environments/net:aws_ec2_transit_gateway.hub 10.3.0.0/16",
+		// 10.0.0.0/8 via the hub is only partly covered by its routes.
+		"blackhole environments/net:aws_route_table.a 10.0.0.0/8",
+	}, got)
+	assert.Contains(t, n.Analyze()[0].Message, "to 10.1.128.0/17 via", "only the part without a route is reported")
+}
+
+func TestUncovered(t *testing.T) {
+	t.Parallel()
+
+	cidr := func(s string) *net.IPNet {
+		_, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return n
+	}
+	str := func(list []*net.IPNet) []string {
+		var out []string
+		for _, n := range list {
+			out = append(out, n.String())
+		}
+		return out
+	}
+
+	assert.Empty(t, uncovered(cidr("10.1.0.0/16"), []*net.IPNet{cidr("10.0.0.0/8")}))
+	assert.Equal(t, []string{"10.1.0.0/16"}, str(uncovered(cidr("10.1.0.0/16"), nil)))
+	assert.Equal(t, []string{"10.1.0.0/17"}, str(uncovered(cidr("10.1.0.0/16"), []*net.IPNet{cidr("10.1.128.0/17")})))
+	assert.Equal(t, []string{"10.1.0.0/18", "10.1.128.0/17"},
+		str(uncovered(cidr("10.1.0.0/16"), []*net.IPNet{cidr("10.1.64.0/18")})))
+}
@@ -0,0 +1,628 @@
+// Package secgroup builds the security group graph of a Terraform tree and
+// analyzes it.
+//
+// The graph covers aws_security_group resources with their inline
+// ingress/egress blocks, standalone aws_security_group_rule and
+// aws_vpc_security_group_{ingress,egress}_rule resources, and pre-existing
+// groups that the configuration only manages tags for (aws_ec2_tag on an
+// "sg-" ID). Rule targets are resolved through locals, module outputs and
+// terraform_remote_state, so a rule in one stack attaches to the group
+// created in another.
+package secgroup
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Group is a security group node.
+type Group struct {
+	ID       string // "<module>:<address>" for managed groups, the sg- ID for external ones
+	Name     string // evaluated name attribute or Name tag, when known
+	Node     tfconfig.Node
+	External bool // known only by ID (tagged or looked up with a data source)
+	Rules    []*Rule
+
+	refs []*Group // groups referenced by this group's rules
+}
+
+func (g *Group) String() string {
+	if g.Name != "" && g.Name != g.ID {
+		return fmt.Sprintf("%s (%s)", g.ID, g.Name)
+	}
+	return g.ID
+}
+
+// PortRange is an inclusive port range.
+type PortRange struct {
+	From, To int
+}
+
+func (p PortRange) String() string {
+	if p.From == p.To {
+		return fmt.Sprint(p.From)
+	}
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+// Rule is a single ingress or egress permission.
+type Rule struct {
+	Group       *Group
+	Source      string // "inline" or the address of the standalone rule resource
+	Egress      bool
+	Protocol    string // "-1", "tcp", "udp", "icmp", ...
+	Ports       PortRange
+	CIDRs       []string // tfconfig.Unknown for CIDRs not statically known
+	Peers       []*Group // referenced security groups
+	Self        bool
+	PrefixLists []string
+	Description string
+}
+
+func (r *Rule) direction() string {
+	if r.Egress {
+		return "egress"
+	}
+	return "ingress"
+}
+
+func (r *Rule) targets() []string {
+	var out []string
+	out = append(out, r.CIDRs...)
+	for _, p := range r.Peers {
+		out = append(out, p.ID)
+	}
+	if r.Self {
+		out = append(out, "self")
+	}
+	return append(out, r.PrefixLists...)
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s %s %s %s [%s] (%s)", r.Group.ID, r.direction(), r.Protocol, r.Ports, strings.Join(r.targets(), ", "), r.Source)
+}
+
+// key identifies the rule within its group by direction, protocol, ports
+// and target, e.g. "egress -1 0-65535 0.0.0.0/0".
+func (r *Rule) key(target string) string {
+	return fmt.Sprintf("%s %s %s %s", r.direction(), r.Protocol, r.Ports, target)
+}
+
+// AllPorts reports whether the rule applies to every port.
+func (r *Rule) AllPorts() bool {
+	return r.Protocol == "-1" || (r.Ports.From <= 0 && r.Ports.To >= 65535)
+}
+
+// Graph is the set of security groups in a tree.
+type Graph struct {
+	Groups []*Group
+
+	// Unresolved lists standalone rules whose security_group_id could not be
+	// resolved to a group, e.g. because it reads a remote state output that
+	// the producing stack does not declare.
+	Unresolved []string
+
+	tree  *tfconfig.Tree
+	refs  *tfconfig.Graph
+	byKey map[string]*Group
+}
+
+// Build collects every security group and rule in the tree.
+func Build(tree *tfconfig.Tree) *Graph {
+	g := &Graph{tree: tree, refs: tree.RefGraph(), byKey: map[string]*Group{}}
+
+	for _, m := range tree.Modules {
+		for _, b := range m.Resources("aws_security_group") {
+			node := tfconfig.Node{Module: m, Addr: b.Address()}
+			grp := g.add(&Group{ID: node.String(), Node: node})
+			grp.Name, _ = b.String("name")
+		}
+		for _, b := range m.DataSources("aws_security_group") {
+			node := tfconfig.Node{Module: m, Addr: b.Address()}
+			grp := g.add(&Group{ID: node.String(), Node: node, External: true})
+			grp.Name, _ = b.String("name")
+		}
+		for _, b := range m.Resources("aws_ec2_tag") {
+			id, ok := b.String("resource_id")
+			if !ok || !strings.HasPrefix(id, "sg-") {
+				continue
+			}
+			grp := g.byKey[id]
+			if grp == nil {
+				grp = g.add(&Group{ID: id, Node: tfconfig.Node{Module: m, Addr: b.Address()}, External: true})
+			}
+			if key, _ := b.String("key"); key == "Name" {
+				grp.Name, _ = b.String("value")
+			}
+		}
+	}
+
+	for _, m := range tree.Modules {
+		for _, b := range m.Resources("aws_security_group") {
+			grp := g.byKey[tfconfig.Node{Module: m, Addr: b.Address()}.String()]
+			for _, dir := range []string{"ingress", "egress"} {
+				for _, nb := range b.Nested(dir) {
+					r := g.ruleFromBlock(m, nb, dir == "egress", "security_groups", "inline")
+					r.Group = grp
+					grp.Rules = append(grp.Rules, r)
+				}
+			}
+		}
+		for _, b := range m.Resources("aws_security_group_rule") {
+			if n, ok := b.Count(); ok && n == 0 {
+				continue
+			}
+			typ, _ := b.String("type")
+			g.attach(m, b, typ == "egress", "source_security_group_id")
+		}
+		for _, typ := range []string{"aws_vpc_security_group_ingress_rule", "aws_vpc_security_group_egress_rule"} {
+			for _, b := range m.Resources(typ) {
+				if n, ok := b.Count(); ok && n == 0 {
+					continue
+				}
+				g.attach(m, b, strings.Contains(typ, "egress"), "referenced_security_group_id")
+			}
+		}
+	}
+
+	sort.Slice(g.Groups, func(i, j int) bool { return g.Groups[i].ID < g.Groups[j].ID })
+	return g
+}
+
+func (g *Graph) add(grp *Group) *Group {
+	g.byKey[grp.ID] = grp
+	g.Groups = append(g.Groups, grp)
+	return grp
+}
+
+// Group returns a group by ID ("<module>:<address>" or "sg-...").
+func (g *Graph) Group(id string) *Group {
+	return g.byKey[id]
+}
+
+// attach adds a standalone rule resource to the group its security_group_id
+// resolves to.
+func (g *Graph) attach(m *tfconfig.Module, b *tfconfig.Block, egress bool, peerAttr string) {
+	owners := g.groupsOf(m, b.Expr("security_group_id"))
+	if len(owners) == 0 {
+		g.Unresolved = append(g.Unresolved, fmt.Sprintf("%s:%s", m.Name, b.Address()))
+		return
+	}
+	for _, owner := range owners {
+		r := g.ruleFromBlock(m, b, egress, peerAttr, b.Address())
+		r.Group = owner
+		owner.Rules = append(owner.Rules, r)
+	}
+}
+
+// groupsOf resolves an expression to the security groups it refers to:
+// resource and data source references followed through the reference graph,
+// or literal sg- IDs.
+func (g *Graph) groupsOf(m *tfconfig.Module, expr hclsyntax.Expression) []*Group {
+	if expr == nil {
+		return nil
+	}
+	var out []*Group
+	for _, n := range g.refs.ResolveExpr(m, expr) {
+		if grp := g.byKey[n.String()]; grp != nil {
+			out = append(out, grp)
+		}
+	}
+	for _, id := range literalIDs(expr) {
+		grp := g.byKey[id]
+		if grp == nil {
+			grp = g.add(&Group{ID: id, External: true})
+		}
+		out = append(out, grp)
+	}
+	return out
+}
+
+func (g *Graph) ruleFromBlock(m *tfconfig.Module, b *tfconfig.Block, egress bool, peerAttr, source string) *Rule {
+	r := &Rule{Source: source, Egress: egress}
+	r.Description, _ = b.String("description")
+
+	proto, ok := b.String("protocol")
+	if !ok {
+		proto, _ = b.String("ip_protocol")
+	}
+	r.Protocol = normalizeProtocol(proto)
+
+	from, fromOK := b.Int("from_port")
+	to, toOK := b.Int("to_port")
+	switch {
+	case r.Protocol == "-1" || r.Protocol == "":
+		r.Protocol = "-1"
+		r.Ports = PortRange{0, 65535}
+	case fromOK && toOK:
+		r.Ports = PortRange{from, to}
+	default:
+		r.Ports = PortRange{0, 65535}
+	}
+
+	for _, attr := range []string{"cidr_blocks", "ipv6_cidr_blocks", "cidr_ipv4", "cidr_ipv6"} {
+		expr := b.Expr(attr)
+		if expr == nil {
+			continue
+		}
+		switch p := m.Partial(expr).(type) {
+		case string:
+			r.CIDRs = append(r.CIDRs, p)
+		case []interface{}:
+			for _, c := range p {
+				if s, ok := c.(string); ok {
+					r.CIDRs = append(r.CIDRs, s)
+				} else {
+					r.CIDRs = append(r.CIDRs, tfconfig.Unknown)
+				}
+			}
+		}
+	}
+	r.PrefixLists, _ = b.Strings("prefix_list_ids")
+	if pl, ok := b.String("prefix_list_id"); ok {
+		r.PrefixLists = append(r.PrefixLists, pl)
+	}
+	r.Self, _ = b.Bool("self")
+	r.Peers = g.groupsOf(m, b.Expr(peerAttr))
+	return r
+}
+
+func literalIDs(expr hclsyntax.Expression) []string {
+	var ids []string
+	hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+		if lit, ok := node.(*hclsyntax.LiteralValueExpr); ok && lit.Val.Type() == cty.String {
+			if s := lit.Val.AsString(); strings.HasPrefix(s, "sg-") {
+				ids = append(ids, s)
+			}
+		}
+		return nil
+	})
+	return ids
+}
+
+func normalizeProtocol(p string) string {
+	switch strings.ToLower(p) {
+	case "-1", "all":
+		return "-1"
+	case "6":
+		return "tcp"
+	case "17":
+		return "udp"
+	case "1":
+		return "icmp"
+	}
+	return strings.ToLower(p)
+}
+
+// Options configures Analyze.
+type Options struct {
+	// MinPrefixLen flags ingress CIDRs, and data-tier egress CIDRs, with a
+	// shorter prefix, e.g. 8 flags anything wider than a /8.
+	MinPrefixLen int
+
+	// DataTier lists substrings of group IDs or names that identify
+	// data-tier groups (databases, vector stores), which must not have
+	// egress to wide CIDRs or on every port.
+	DataTier []string
+
+	// ServicePorts maps a substring of a group ID or name to the ingress
+	// ports the service behind it needs.
+	ServicePorts map[string][]PortRange
+}
+
+// Finding is a problem reported by Analyze.
+type Finding struct {
+	Kind    string // "unattached", "broad-cidr", "open-egress", "broad-ports", "cycle", "unresolved"
+	Group   string // group ID, or rule address for unresolved rules
+	Rule    string // direction, protocol, ports and target of the rule, for rule findings
+	Message string
+}
+
+// Key identifies a finding in the tree, e.g. "open-egress
+// environments/app-layer:aws_security_group.opensearch egress -1 0-65535 0.0.0.0/0".
+func (f Finding) Key() string {
+	if f.Rule == "" {
+		return f.Kind + " " + f.Group
+	}
+	return f.Kind + " " + f.Group + " " + f.Rule
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Kind, f.Message)
+}
+
+// Analyze runs every check over the graph.
+func (g *Graph) Analyze(opts Options) []Finding {
+	var findings []Finding
+	findings = append(findings, g.Unattached()...)
+	findings = append(findings, g.BroadCIDRs(opts.MinPrefixLen)...)
+	findings = append(findings, g.OpenEgress(opts.DataTier, opts.MinPrefixLen)...)
+	findings = append(findings, g.BroadPorts(opts.ServicePorts)...)
+	findings = append(findings, g.Cycles()...)
+	findings = append(findings, g.Undeclared()...)
+	for _, u := range g.Unresolved {
+		findings = append(findings, Finding{Kind: "unresolved", Group: u, Message: u + ": security_group_id does not resolve to any security group"})
+	}
+	return findings
+}
+
+// ruleKinds are resources that only describe security groups; a reference
+// from them does not attach a group to anything.
+var ruleKinds = map[string]bool{
+	"aws_security_group":                  true,
+	"aws_security_group_rule":             true,
+	"aws_vpc_security_group_ingress_rule": true,
+	"aws_vpc_security_group_egress_rule":  true,
+	"aws_ec2_tag":                         true,
+}
+
+// Unattached reports managed groups that no resource outside the security
+// group definitions refers to, directly or through locals, outputs and
+// remote state. External groups are attached outside this configuration and
+// are not reported.
+func (g *Graph) Unattached() []Finding {
+	var sinks []tfconfig.Node
+	for _, m := range g.tree.Modules {
+		for _, b := range m.Resources("") {
+			if !ruleKinds[b.Kind()] {
+				sinks = append(sinks, tfconfig.Node{Module: m, Addr: b.Address()})
+			}
+		}
+	}
+	reached := g.refs.Reachable(sinks, func(n tfconfig.Node) bool { return ruleKinds[n.Kind()] })
+
+	var findings []Finding
+	for _, grp := range g.Groups {
+		if !grp.External && !reached[grp.Node] {
+			findings = append(findings, Finding{Kind: "unattached", Group: grp.ID, Message: fmt.Sprintf("%s is not attached to any resource", grp)})
+		}
+	}
+	return findings
+}
+
+// Undeclared reports sg- IDs used in rules that are neither created, looked
+// up nor tagged anywhere in the tree, typically placeholders that were never
+// replaced.
+func (g *Graph) Undeclared() []Finding {
+	var findings []Finding
+	for _, grp := range g.Groups {
+		if grp.Node.Module != nil {
+			continue
+		}
+		var users []string
+		for _, other := range g.Groups {
+			for _, r := range other.Rules {
+				for _, p := range r.Peers {
+					if p == grp {
+						users = append(users, other.ID)
+					}
+				}
+			}
+		}
+		findings = append(findings, Finding{Kind: "unresolved", Group: grp.ID,
+			Message: fmt.Sprintf("%s is referenced by %s but not declared in the tree", grp.ID, strings.Join(users, ", "))})
+	}
+	return findings
+}
+
+// BroadCIDRs reports ingress rules open to CIDRs wider than /minPrefixLen.
+func (g *Graph) BroadCIDRs(minPrefixLen int) []Finding {
+	var findings []Finding
+	for _, grp := range g.Groups {
+		for _, r := range grp.Rules {
+			if r.Egress {
+				continue
+			}
+			for _, c := range r.CIDRs {
+				_, n, err := net.ParseCIDR(c)
+				if err != nil {
+					continue
+				}
+				if ones, _ := n.Mask.Size(); ones < minPrefixLen {
+					findings = append(findings, Finding{Kind: "broad-cidr", Group: grp.ID, Rule: r.key(c),
+						Message: fmt.Sprintf("%s allows %s, wider than /%d", r, c, minPrefixLen)})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// OpenEgress reports egress rules of data-tier groups that allow every
+// port, or any port to a CIDR wider than /minPrefixLen, such as
+// 0.0.0.0/0. Each CIDR of a rule is reported on its own.
+func (g *Graph) OpenEgress(dataTier []string, minPrefixLen int) []Finding {
+	var findings []Finding
+	for _, grp := range g.Groups {
+		if !matchesAny(grp, dataTier) {
+			continue
+		}
+		for _, r := range grp.Rules {
+			if !r.Egress {
+				continue
+			}
+			for _, c := range r.CIDRs {
+				wide := false
+				if _, n, err := net.ParseCIDR(c); err == nil {
+					ones, _ := n.Mask.Size()
+					wide = ones < minPrefixLen
+				}
+				switch {
+				case wide:
+					findings = append(findings, Finding{Kind: "open-egress", Group: grp.ID, Rule: r.key(c),
+						Message: fmt.Sprintf("data-tier %s allows egress to %s, wider than /%d", r, c, minPrefixLen)})
+				case r.AllPorts():
+					findings = append(findings, Finding{Kind: "open-egress", Group: grp.ID, Rule: r.key(c),
+						Message: fmt.Sprintf("data-tier %s allows egress to %s on every port", r, c)})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// BroadPorts reports ingress rules on groups with known service ports whose
+// port range includes ports the service does not need.
+func (g *Graph) BroadPorts(servicePorts map[string][]PortRange) []Finding {
+	keys := make([]string, 0, len(servicePorts))
+	for k := range servicePorts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var findings []Finding
+	for _, grp := range g.Groups {
+		var need []PortRange
+		for _, k := range keys {
+			if matchesAny(grp, []string{k}) {
+				need = append(need, servicePorts[k]...)
+			}
+		}
+		if len(need) == 0 {
+			continue
+		}
+		for _, r := range grp.Rules {
+			if r.Egress {
+				continue
+			}
+			if extra := uncovered(r.Ports, need); len(extra) > 0 || r.Protocol == "-1" {
+				findings = append(findings, Finding{Kind: "broad-ports", Group: grp.ID, Rule: r.key(strings.Join(r.targets(), ",")),
+					Message: fmt.Sprintf("%s opens ports the service does not need (needs %v)", r, need)})
+			}
+		}
+	}
+	return findings
+}
+
+// uncovered returns the parts of r not covered by any range in need.
+func uncovered(r PortRange, need []PortRange) []PortRange {
+	sorted := append([]PortRange(nil), need...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+
+	var out []PortRange
+	next := r.From
+	for _, n := range sorted {
+		if n.To < next {
+			continue
+		}
+		if n.From > r.To {
+			break
+		}
+		if n.From > next {
+			out = append(out, PortRange{next, n.From - 1})
+		}
+		next = n.To + 1
+		if next > r.To {
+			return out
+		}
+	}
+	if next <= r.To {
+		out = append(out, PortRange{next, r.To})
+	}
+	return out
+}
+
+// Cycles reports groups whose inline rules reference each other in a
+// cycle, which Terraform cannot create: each group needs the ID of the
+// next. Standalone rules are created after the groups, so an ingress rule
+// answered by an egress rule of the peer is not a cycle, and neither is a
+// group that refers to itself.
+func (g *Graph) Cycles() []Finding {
+	for _, grp := range g.Groups {
+		grp.refs = nil
+		for _, r := range grp.Rules {
+			if r.Source != "inline" {
+				continue
+			}
+			for _, p := range r.Peers {
+				if p != grp {
+					grp.refs = append(grp.refs, p)
+				}
+			}
+		}
+	}
+
+	var findings []Finding
+	for _, scc := range stronglyConnected(g.Groups) {
+		if len(scc) == 1 {
+			continue
+		}
+		var ids []string
+		for _, grp := range scc {
+			ids = append(ids, grp.ID)
+		}
+		sort.Strings(ids)
+		findings = append(findings, Finding{Kind: "cycle", Group: ids[0],
+			Message: "security groups reference each other: " + strings.Join(ids, " -> ")})
+	}
+	return findings
+}
+
+// stronglyConnected returns the strongly connected components of the group
+// reference graph (Tarjan's algorithm).
+func stronglyConnected(groups []*Group) [][]*Group {
+	index := map[*Group]int{}
+	low := map[*Group]int{}
+	onStack := map[*Group]bool{}
+	var stack []*Group
+	var out [][]*Group
+	next := 0
+
+	var visit func(v *Group)
+	visit = func(v *Group) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range v.refs {
+			if _, seen := index[w]; !seen {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+
+		if low[v] == index[v] {
+			var scc []*Group
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			out = append(out, scc)
+		}
+	}
+
+	for _, v := range groups {
+		if _, seen := index[v]; !seen {
+			visit(v)
+		}
+	}
+	return out
+}
+
+func matchesAny(grp *Group, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.Contains(grp.ID, p) || (grp.Name != "" && strings.Contains(grp.Name, p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package secgroup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleTree is a network stack that creates groups through a child module
+// and exports them, and an app stack that consumes one of them through
+// remote state.
+var sampleTree = map[string]string{
+	"environments/network/main.tf": `
+terraform {
+  backend "s3" {
+    key = "network/terraform.tfstate"
+  }
+}
+
+module "sg" {
+  source = "../../modules/sg"
+  vpc_id = "vpc-1"
+}
+
+resource "aws_security_group" "unused" {
+  name = "unused"
+  ingress {
+    from_port   = 22
+    to_port     = 22
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_security_group" "a" {
+  name = "a"
+  ingress {
+    from_port       = 443
+    to_port         = 443
+    protocol        = "tcp"
+    security_groups = [aws_security_group.b.id]
+  }
+}
+
+resource "aws_security_group" "b" {
+  name = "b"
+  ingress {
+    from_port       = 443
+    to_port         = 443
+    protocol        = "tcp"
+    security_groups = ["sg-placeholder"]
+  }
+}
+
+resource "aws_security_group_rule" "b_from_a" {
+  type                     = "ingress"
+  from_port                = 443
+  to_port                  = 443
+  protocol                 = "tcp"
+  security_group_id        = aws_security_group.b.id
+  source_security_group_id = aws_security_group.a.id
+}
+
+resource "aws_security_group_rule" "a_self" {
+  type                     = "ingress"
+  from_port                = 443
+  to_port                  = 443
+  protocol                 = "tcp"
+  security_group_id        = aws_security_group.a.id
+  source_security_group_id = aws_security_group.a.id
+}
+
+resource "aws_security_group" "c" {
+  name = "c"
+  ingress {
+    from_port       = 8080
+    to_port         = 8080
+    protocol        = "tcp"
+    security_groups = [aws_security_group.d.id]
+  }
+}
+
+resource "aws_security_group" "d" {
+  name = "d"
+  egress {
+    from_port       = 8080
+    to_port         = 8080
+    protocol        = "tcp"
+    security_groups = [aws_security_group.c.id]
+  }
+}
+
+resource "aws_ec2_tag" "legacy" {
+  resource_id = "sg-0123"
+  key         = "Name"
+  value       = "legacy-ssh"
+}
+
+resource "aws_instance" "web" {
+  vpc_security_group_ids = [aws_security_group.a.id, aws_security_group.b.id, aws_security_group.c.id, aws_security_group.d.id]
+}
+
+output "db_sg_id" {
+  value = module.sg.db_sg_id
+}
+`,
+	"environments/app/main.tf": `
+terraform {
+  backend "s3" {
+    key = "app/terraform.tfstate"
+  }
+}
+
+data "terraform_remote_state" "network" {
+  backend = "s3"
+  config = {
+    key = "network/terraform.tfstate"
+  }
+}
+
+locals {
+  db_sg = data.terraform_remote_state.network.outputs.db_sg_id
+}
+
+resource "aws_db_instance" "db" {
+  vpc_security_group_ids = [local.db_sg]
+}
+
+resource "aws_vpc_security_group_ingress_rule" "db_from_vpc" {
+  security_group_id = local.db_sg
+  ip_protocol       = "tcp"
+  from_port         = 5000
+  to_port           = 5500
+  cidr_ipv4         = "10.0.0.0/16"
+}
+
+resource "aws_security_group" "cache" {
+  name = "db-tier-cache"
+
+  egress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+  egress {
+    from_port   = 6379
+    to_port     = 6379
+    protocol    = "tcp"
+    cidr_blocks = ["10.0.0.0/16"]
+  }
+  egress {
+    from_port   = 0
+    to_port     = 65535
+    protocol    = "tcp"
+    cidr_blocks = ["10.1.0.0/16"]
+  }
+}
+
+resource "aws_elasticache_cluster" "cache" {
+  security_group_ids = [aws_security_group.cache.id]
+}
+
+resource "aws_security_group_rule" "dangling" {
+  type              = "egress"
+  from_port         = 443
+  to_port           = 443
+  protocol          = "tcp"
+  cidr_blocks       = ["0.0.0.0/0"]
+  security_group_id = data.terraform_remote_state.network.outputs.missing_sg_id
+}
+`,
+	"modules/sg/main.tf": `
+variable "vpc_id" {}
+
+resource "aws_security_group" "db" {
+  name   = "db-tier"
+  vpc_id = var.vpc_id
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+output "db_sg_id" {
+  value = aws_security_group.db.id
+}
+`,
+}
+
+func loadSample(t *testing.T) *Graph {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range sampleTree {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	return Build(tree)
+}
+
+func ruleFrom(g *Group, source string) *Rule {
+	for _, r := range g.Rules {
+		if r.Source == source {
+			return r
+		}
+	}
+	return nil
+}
+
+func groupsOf(findings []Finding, kind string) []string {
+	var out []string
+	for _, f := range findings {
+		if f.Kind == kind {
+			out = append(out, f.Group)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func keysOf(findings []Finding, kind string) []string {
+	var out []string
+	for _, f := range findings {
+		if f.Kind == kind {
+			out = append(out, f.Key())
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestBuild_ResolvesRulesAcrossStacks(t *testing.T) {
+	t.Parallel()
+
+	g := loadSample(t)
+
+	db := g.Group("environments/network/module.sg:aws_security_group.db")
+	require.NotNil(t, db, "module group should be collected")
+	assert.Equal(t, "db-tier", db.Name)
+	require.Len(t, db.Rules, 2, "inline egress plus the remote-state ingress rule")
+	ingress := ruleFrom(db, "aws_vpc_security_group_ingress_rule.db_from_vpc")
+	require.NotNil(t, ingress)
+	assert.False(t, ingress.Egress)
+	assert.Equal(t, PortRange{5000, 5500}, ingress.Ports)
+	assert.Equal(t, []string{"10.0.0.0/16"}, ingress.CIDRs)
+
+	b := g.Group("environments/network:aws_security_group.b")
+	require.Len(t, b.Rules, 2)
+	fromA := ruleFrom(b, "aws_security_group_rule.b_from_a")
+	require.NotNil(t, fromA)
+	require.Len(t, fromA.Peers, 1)
+	assert.Equal(t, "environments/network:aws_security_group.a", fromA.Peers[0].ID)
+
+	legacy := g.Group("sg-0123")
+	require.NotNil(t, legacy)
+	assert.True(t, legacy.External)
+	assert.Equal(t, "legacy-ssh", legacy.Name)
+
+	assert.Equal(t, []string{"environments/app:aws_security_group_rule.dangling"}, g.Unresolved)
+}
+
+func TestAnalyze_Findings(t *testing.T) {
+	t.Parallel()
+
+	g := loadSample(t)
+	findings := g.Analyze(Options{
+		MinPrefixLen: 8,
+		DataTier:     []string{"db-tier"},
+		ServicePorts: map[string][]PortRange{"db-tier": {{5432, 5432}}},
+	})
+
+	assert.Equal(t, []string{"environments/network:aws_security_group.unused"}, groupsOf(findings, "unattached"),
+		"groups used by the instance, or by the db through remote state, are attached; tagged groups are external")
+	assert.Equal(t, []string{"environments/network:aws_security_group.unused"}, groupsOf(findings, "broad-cidr"))
+	assert.Equal(t, []string{
+		"broad-cidr environments/network:aws_security_group.unused ingress tcp 22 0.0.0.0/0",
+	}, keysOf(findings, "broad-cidr"))
+	assert.Equal(t, []string{
+		"open-egress environments/app:aws_security_group.cache egress tcp 0-65535 10.1.0.0/16",
+		"open-egress environments/app:aws_security_group.cache egress tcp 443 0.0.0.0/0",
+		"open-egress environments/network/module.sg:aws_security_group.db egress -1 0-65535 0.0.0.0/0",
+	}, keysOf(findings, "open-egress"), "egress to a wide CIDR or on every port; tcp 6379 to 10.0.0.0/16 is fine")
+	assert.Equal(t, []string{
+		"broad-ports environments/network/module.sg:aws_security_group.db ingress tcp 5000-5500 10.0.0.0/16",
+	}, keysOf(findings, "broad-ports"))
+	assert.Equal(t, []string{"environments/network:aws_security_group.c"}, groupsOf(findings, "cycle"),
+		"a and b only reference each other through a standalone rule, and a's self reference is not a cycle")
+	assert.Equal(t, []string{"environments/app:aws_security_group_rule.dangling", "sg-placeholder"}, groupsOf(findings, "unresolved"))
+}
+
+func TestUncovered(t *testing.T) {
+	t.Parallel()
+
+	need := []PortRange{{6333, 6334}, {443, 443}}
+	assert.Empty(t, uncovered(PortRange{6333, 6334}, need))
+	assert.Empty(t, uncovered(PortRange{443, 443}, need))
+	assert.Equal(t, []PortRange{{6335, 6340}}, uncovered(PortRange{6333, 6340}, need))
+	assert.Equal(t, []PortRange{{0, 442}, {444, 6332}, {6335, 65535}}, uncovered(PortRange{0, 65535}, need))
+}
@@ -0,0 +1,186 @@
+package squid
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Proxy is an http.Handler that applies a Config to CONNECT and plain HTTP
+// requests the way the Squid instance does: denied requests get 403, allowed
+// CONNECT requests get 200 and a tunnel to the upstream.
+type Proxy struct {
+	Config *Config
+
+	// Src returns the client address used for src ACLs. It defaults to the
+	// remote address of the request.
+	Src func(r *http.Request) net.IP
+
+	// Dial connects to upstreams. Tests use it to keep traffic in process.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	decided []Decision
+}
+
+// Decision records a request the proxy evaluated, like a line in Squid's
+// access.log.
+type Decision struct {
+	Request Request
+	Allowed bool
+	Rule    string // the http_access line that decided, or "" for the implicit default
+}
+
+// Log returns the decisions made so far.
+func (p *Proxy) Log() []Decision {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Decision(nil), p.decided...)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := Request{Src: p.src(r), Method: r.Method, Host: r.URL.Hostname(), Port: 80}
+	if r.Method == http.MethodConnect {
+		host, port, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			http.Error(w, "bad CONNECT target", http.StatusBadRequest)
+			return
+		}
+		req.Host = host
+		req.Port, _ = strconv.Atoi(port)
+	} else if port := r.URL.Port(); port != "" {
+		req.Port, _ = strconv.Atoi(port)
+	}
+
+	allowed, rule := p.Config.Check(req)
+	d := Decision{Request: req, Allowed: allowed}
+	if rule != nil {
+		d.Rule = rule.Line
+	}
+	p.mu.Lock()
+	p.decided = append(p.decided, d)
+	p.mu.Unlock()
+
+	if !allowed {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, net.JoinHostPort(req.Host, strconv.Itoa(req.Port)))
+		return
+	}
+	p.forward(w, r)
+}
+
+func (p *Proxy) src(r *http.Request) net.IP {
+	if p.Src != nil {
+		return p.Src(r)
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return net.ParseIP(host)
+}
+
+func (p *Proxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, addr string) {
+	upstream, err := p.dial(r.Context(), "tcp", addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// Tear the tunnel down as soon as either side finishes.
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	transport := &http.Transport{DialContext: p.dial}
+	defer transport.CloseIdleConnections()
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// Connect sends CONNECT target to the proxy at addr and returns the response
+// status code. On 200 the returned connection is the established tunnel;
+// otherwise it is closed and nil.
+func Connect(addr, target string) (int, net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target); err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return resp.StatusCode, nil, nil
+	}
+	return resp.StatusCode, &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn reads through the reader used for the CONNECT response, so
+// tunnel bytes that arrived with it are not lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
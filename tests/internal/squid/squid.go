@@ -0,0 +1,262 @@
+// Package squid evaluates the access rules of the Squid forward proxy
+// configured by environments/network-layer/templates/squid-user-data.sh.tpl.
+//
+// It extracts squid.conf and the files it includes from the rendered user
+// data, parses the acl and http_access directives, and answers whether a
+// request from a client to host:port is allowed, using Squid's first-match
+// semantics. Proxy serves the same decisions as an in-process CONNECT proxy.
+package squid
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/userdata"
+)
+
+// ConfPath is where the user data writes squid.conf.
+const ConfPath = "/etc/squid/squid.conf"
+
+// ACL is an acl directive. Lines for the same name are merged.
+type ACL struct {
+	Name   string
+	Type   string // "src", "port", "method", "dstdomain"
+	Values []string
+}
+
+// Cond is an ACL reference in an http_access rule, optionally negated with !.
+type Cond struct {
+	ACL    string
+	Negate bool
+}
+
+// Rule is an http_access directive.
+type Rule struct {
+	Allow bool
+	Conds []Cond
+	Line  string
+}
+
+// Config is a parsed squid.conf.
+type Config struct {
+	ACLs   map[string]*ACL
+	Access []Rule
+	Ports  []int // http_port
+}
+
+// Request is a proxied request as seen by the access rules.
+type Request struct {
+	Src    net.IP
+	Method string
+	Host   string
+	Port   int
+}
+
+// FromUserData parses the squid.conf written by a user data script,
+// resolving dstdomain files written by the same script.
+func FromUserData(script string) (*Config, error) {
+	files := userdata.ParseScript(script).Files
+	conf, ok := files[ConfPath]
+	if !ok {
+		return nil, fmt.Errorf("user data does not write %s", ConfPath)
+	}
+	return Parse(conf, files)
+}
+
+// Parse parses squid.conf. Quoted ACL values name files, which are looked up
+// in files and read one value per line.
+func Parse(conf string, files map[string]string) (*Config, error) {
+	c := &Config{ACLs: map[string]*ACL{"all": {Name: "all", Type: "src", Values: []string{"0.0.0.0/0", "::/0"}}}}
+	for i, line := range strings.Split(conf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "acl":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: malformed acl: %s", i+1, line)
+			}
+			values, err := aclValues(fields[3:], files)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			acl := c.ACLs[fields[1]]
+			if acl == nil || acl.Name == "all" {
+				acl = &ACL{Name: fields[1], Type: fields[2]}
+				c.ACLs[acl.Name] = acl
+			}
+			if acl.Type != fields[2] {
+				return nil, fmt.Errorf("line %d: acl %s redefined as %s", i+1, acl.Name, fields[2])
+			}
+			acl.Values = append(acl.Values, values...)
+		case "http_access":
+			if len(fields) < 3 || (fields[1] != "allow" && fields[1] != "deny") {
+				return nil, fmt.Errorf("line %d: malformed http_access: %s", i+1, line)
+			}
+			r := Rule{Allow: fields[1] == "allow", Line: strings.TrimSpace(line)}
+			for _, f := range fields[2:] {
+				cond := Cond{ACL: strings.TrimPrefix(f, "!"), Negate: strings.HasPrefix(f, "!")}
+				if c.ACLs[cond.ACL] == nil {
+					return nil, fmt.Errorf("line %d: undefined acl %s", i+1, cond.ACL)
+				}
+				r.Conds = append(r.Conds, cond)
+			}
+			c.Access = append(c.Access, r)
+		case "http_port":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: malformed http_port: %s", i+1, line)
+			}
+			port, err := strconv.Atoi(fields[1][strings.LastIndex(fields[1], ":")+1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed http_port: %s", i+1, line)
+			}
+			c.Ports = append(c.Ports, port)
+		}
+	}
+	for _, acl := range c.ACLs {
+		if err := acl.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func aclValues(fields []string, files map[string]string) ([]string, error) {
+	var out []string
+	for _, f := range fields {
+		if !strings.HasPrefix(f, `"`) {
+			out = append(out, f)
+			continue
+		}
+		path := strings.Trim(f, `"`)
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("acl file %s is not written by the user data", path)
+		}
+		for _, line := range strings.Split(content, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				out = append(out, line)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (a *ACL) validate() error {
+	for _, v := range a.Values {
+		var err error
+		switch a.Type {
+		case "src":
+			_, _, err = net.ParseCIDR(v)
+		case "port":
+			_, _, err = portRange(v)
+		case "method", "dstdomain":
+		default:
+			err = fmt.Errorf("unsupported acl type %s", a.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("acl %s: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+func portRange(v string) (int, int, error) {
+	lo, hi, found := strings.Cut(v, "-")
+	from, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return from, from, nil
+	}
+	to, err := strconv.Atoi(hi)
+	return from, to, err
+}
+
+// Matches reports whether the ACL matches a request.
+func (a *ACL) Matches(req Request) bool {
+	for _, v := range a.Values {
+		switch a.Type {
+		case "src":
+			if _, cidr, _ := net.ParseCIDR(v); cidr.Contains(req.Src) {
+				return true
+			}
+		case "port":
+			if from, to, _ := portRange(v); req.Port >= from && req.Port <= to {
+				return true
+			}
+		case "method":
+			if strings.EqualFold(v, req.Method) {
+				return true
+			}
+		case "dstdomain":
+			if DomainMatches(v, req.Host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DomainMatches implements dstdomain matching: ".example.com" matches
+// example.com and all its subdomains, "example.com" only itself.
+func DomainMatches(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(host, "."))
+	if strings.HasPrefix(pattern, ".") {
+		return host == pattern[1:] || strings.HasSuffix(host, pattern)
+	}
+	return host == pattern
+}
+
+// Check returns whether a request is allowed and the rule that decided it.
+// When no rule matches, Squid applies the opposite of the last rule; the
+// returned rule is nil in that case.
+func (c *Config) Check(req Request) (bool, *Rule) {
+	for i := range c.Access {
+		r := &c.Access[i]
+		if c.ruleMatches(r, req) {
+			return r.Allow, r
+		}
+	}
+	if len(c.Access) == 0 {
+		return false, nil
+	}
+	return !c.Access[len(c.Access)-1].Allow, nil
+}
+
+func (c *Config) ruleMatches(r *Rule, req Request) bool {
+	for _, cond := range r.Conds {
+		if c.ACLs[cond.ACL].Matches(req) == cond.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Domains returns the values of all dstdomain ACLs used in allow rules.
+func (c *Config) Domains() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, r := range c.Access {
+		if !r.Allow {
+			continue
+		}
+		for _, cond := range r.Conds {
+			acl := c.ACLs[cond.ACL]
+			if acl.Type != "dstdomain" || cond.Negate {
+				continue
+			}
+			for _, v := range acl.Values {
+				if !seen[v] {
+					seen[v] = true
+					out = append(out, v)
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package squid
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleUserData writes a whitelist and a squid.conf that only lets the
+// office network CONNECT to whitelisted domains on 443 and 8443.
+const sampleUserData = `#!/bin/bash
+cat > /etc/squid/allowed.txt <<'LIST'
+# providers
+.example.org
+api.example.com
+LIST
+
+cat > /etc/squid/squid.conf <<'CONF'
+acl office src 10.0.0.0/8
+acl SSL_ports port 443
+acl SSL_ports port 8443-8444
+acl CONNECT method CONNECT
+acl allowed dstdomain "/etc/squid/allowed.txt"
+
+http_access deny !office
+http_access deny CONNECT !SSL_ports
+http_access allow office allowed
+http_port 3128
+CONF
+systemctl enable --now squid
+`
+
+var office = net.ParseIP("10.1.2.3")
+
+func loadSample(t *testing.T) *Config {
+	t.Helper()
+	c, err := FromUserData(sampleUserData)
+	require.NoError(t, err)
+	return c
+}
+
+func TestFromUserData_ParsesACLsAndRules(t *testing.T) {
+	t.Parallel()
+
+	c := loadSample(t)
+	assert.Equal(t, []string{".example.org", "api.example.com"}, c.ACLs["allowed"].Values)
+	assert.Equal(t, []string{"443", "8443-8444"}, c.ACLs["SSL_ports"].Values)
+	assert.Len(t, c.Access, 3)
+	assert.Equal(t, []int{3128}, c.Ports)
+	assert.Equal(t, []string{".example.org", "api.example.com"}, c.Domains())
+
+	_, err := Parse("acl a dstdomain \"/missing.txt\"\n", nil)
+	assert.Error(t, err)
+	_, err = Parse("http_access allow nowhere\n", nil)
+	assert.Error(t, err)
+	_, err = Parse("http_port\n", nil)
+	assert.ErrorContains(t, err, "line 1: malformed http_port")
+}
+
+func TestCheck_FirstMatchWithImplicitDefault(t *testing.T) {
+	t.Parallel()
+
+	c := loadSample(t)
+	testCases := []struct {
+		name    string
+		req     Request
+		allowed bool
+		rule    string
+	}{
+		{"whitelisted host", Request{office, "CONNECT", "api.example.com", 443}, true, "http_access allow office allowed"},
+		{"subdomain wildcard", Request{office, "CONNECT", "a.b.example.org", 8444}, true, "http_access allow office allowed"},
+		{"wildcard covers the apex", Request{office, "CONNECT", "EXAMPLE.org", 443}, true, "http_access allow office allowed"},
+		{"exact entry is not a wildcard", Request{office, "CONNECT", "v2.api.example.com", 443}, false, ""},
+		{"suffix is not a subdomain", Request{office, "CONNECT", "badexample.org", 443}, false, ""},
+		{"non-SSL port", Request{office, "CONNECT", "api.example.com", 22}, false, "http_access deny CONNECT !SSL_ports"},
+		{"outside the office", Request{net.ParseIP("192.0.2.1"), "CONNECT", "api.example.com", 443}, false, "http_access deny !office"},
+		{"plain HTTP to a whitelisted host", Request{office, "GET", "api.example.com", 80}, true, "http_access allow office allowed"},
+	}
+	for _, tc := range testCases {
+		allowed, rule := c.Check(tc.req)
+		assert.Equal(t, tc.allowed, allowed, tc.name)
+		line := ""
+		if rule != nil {
+			line = rule.Line
+		}
+		assert.Equal(t, tc.rule, line, tc.name)
+	}
+}
+
+func TestProxy_TunnelsAllowedConnects(t *testing.T) {
+	t.Parallel()
+
+	var dialed []string
+	p := &Proxy{
+		Config: loadSample(t),
+		Src:    func(*http.Request) net.IP { return office },
+		Dial: func(_ context.Context, _, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				_, _ = io.Copy(server, server)
+			}()
+			return client, nil
+		},
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	status, conn, err := Connect(addr, "api.example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf), "tunnel should carry bytes both ways")
+	conn.Close()
+
+	status, _, err = Connect(addr, "example.net:443")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	assert.Equal(t, []string{"api.example.com:443"}, dialed, "denied requests never reach an upstream")
+	log := p.Log()
+	require.Len(t, log, 2)
+	assert.False(t, log[1].Allowed)
+	assert.Equal(t, "", log[1].Rule, "denied by the implicit default")
+}
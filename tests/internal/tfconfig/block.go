@@ -0,0 +1,360 @@
+package tfconfig
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Name returns the block name: the second label of resource and data blocks,
+// the first label of every other labelled block.
+func (b *Block) Name() string {
+	switch {
+	case (b.Type == "resource" || b.Type == "data") && len(b.Labels) > 1:
+		return b.Labels[1]
+	case len(b.Labels) > 0:
+		return b.Labels[0]
+	}
+	return ""
+}
+
+// Kind returns the resource or data source type, e.g. "aws_security_group".
+func (b *Block) Kind() string {
+	if (b.Type == "resource" || b.Type == "data") && len(b.Labels) > 0 {
+		return b.Labels[0]
+	}
+	return ""
+}
+
+// Address returns the Terraform address of a top-level block, e.g.
+// "aws_security_group.lambda", "data.aws_iam_policy_document.x" or
+// "module.vpc".
+func (b *Block) Address() string {
+	switch b.Type {
+	case "resource":
+		return b.Labels[0] + "." + b.Labels[1]
+	case "data":
+		return "data." + b.Labels[0] + "." + b.Labels[1]
+	case "module":
+		return "module." + b.Labels[0]
+	}
+	return b.Type + "." + strings.Join(b.Labels, ".")
+}
+
+// Module returns the module the block belongs to.
+func (b *Block) Module() *Module {
+	return b.module
+}
+
+// Has reports whether the block sets the attribute.
+func (b *Block) Has(name string) bool {
+	_, ok := b.Body.Attributes[name]
+	return ok
+}
+
+// Expr returns the expression of an attribute, or nil when it is not set.
+func (b *Block) Expr(name string) hclsyntax.Expression {
+	if attr, ok := b.Body.Attributes[name]; ok {
+		return attr.Expr
+	}
+	return nil
+}
+
+// Value evaluates an attribute. It returns cty.NilVal when the attribute is
+// not set and an unknown value when it cannot be evaluated statically.
+func (b *Block) Value(name string) cty.Value {
+	expr := b.Expr(name)
+	if expr == nil {
+		return cty.NilVal
+	}
+	return b.module.Eval(expr)
+}
+
+// String returns a statically known string attribute.
+func (b *Block) String(name string) (string, bool) {
+	v := b.Value(name)
+	if v == cty.NilVal || !v.IsWhollyKnown() || v.IsNull() {
+		return "", false
+	}
+	switch v.Type() {
+	case cty.String:
+		return v.AsString(), true
+	case cty.Number:
+		return v.AsBigFloat().Text('f', -1), true
+	case cty.Bool:
+		if v.True() {
+			return "true", true
+		}
+		return "false", true
+	}
+	return "", false
+}
+
+// Int returns a statically known whole-number attribute.
+func (b *Block) Int(name string) (int, bool) {
+	return AsInt(b.Value(name))
+}
+
+// Bool returns a statically known boolean attribute.
+func (b *Block) Bool(name string) (bool, bool) {
+	v := b.Value(name)
+	if v == cty.NilVal || !v.IsKnown() || v.IsNull() || v.Type() != cty.Bool {
+		return false, false
+	}
+	return v.True(), true
+}
+
+// Strings returns the known string elements of a list or set attribute.
+// Unknown elements are skipped; the second result is false when any element
+// (or the whole value) is unknown.
+func (b *Block) Strings(name string) ([]string, bool) {
+	return AsStrings(b.Value(name))
+}
+
+// JSON decodes a policy-style attribute that holds either a JSON string
+// (jsonencode or a heredoc) or an object. The second result is false when the
+// value is not statically known.
+func (b *Block) JSON(name string) (interface{}, bool) {
+	expr := b.Expr(name)
+	if expr == nil {
+		return nil, false
+	}
+	return b.module.JSON(expr)
+}
+
+// Partial converts an attribute to plain Go data, keeping the known parts of
+// values that are only partially known. See Module.Partial.
+func (b *Block) Partial(name string) interface{} {
+	expr := b.Expr(name)
+	if expr == nil {
+		return nil
+	}
+	return b.module.Partial(expr)
+}
+
+// Count returns the number of instances the block creates: 1 without count,
+// the evaluated count otherwise. The second result is false when count is not
+// statically known.
+func (b *Block) Count() (int, bool) {
+	if !b.Has("count") {
+		return 1, true
+	}
+	return b.Int("count")
+}
+
+// Nested returns nested blocks of the given type. A dynamic block of that
+// type contributes its content body once, unless its for_each is statically
+// known to be empty.
+func (b *Block) Nested(typ string) []*Block {
+	var out []*Block
+	for _, nb := range b.Body.Blocks {
+		switch {
+		case nb.Type == typ:
+			out = append(out, &Block{Type: nb.Type, Labels: nb.Labels, Body: nb.Body, File: b.File, module: b.module})
+		case nb.Type == "dynamic" && len(nb.Labels) == 1 && nb.Labels[0] == typ:
+			if attr, ok := nb.Body.Attributes["for_each"]; ok {
+				v := b.module.Eval(attr.Expr)
+				if v.IsKnown() && !v.IsNull() && v.CanIterateElements() && v.LengthInt() == 0 {
+					continue
+				}
+			}
+			for _, content := range nb.Body.Blocks {
+				if content.Type == "content" {
+					out = append(out, &Block{Type: typ, Labels: []string{"dynamic"}, Body: content.Body, File: b.File, module: b.module})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// IsDynamic reports whether a nested block came from a dynamic block.
+func (b *Block) IsDynamic() bool {
+	return len(b.Labels) == 1 && b.Labels[0] == "dynamic" && b.Type != "module"
+}
+
+// Refs returns the addresses referenced by an attribute, e.g.
+// "aws_security_group.lambda", "var.vpc_cidr", "module.vpc",
+// "data.aws_caller_identity.current" or "local.common_tags".
+func (b *Block) Refs(name string) []string {
+	expr := b.Expr(name)
+	if expr == nil {
+		return nil
+	}
+	return References(expr)
+}
+
+// AllRefs returns the addresses referenced anywhere in the block body.
+func (b *Block) AllRefs() []string {
+	seen := map[string]bool{}
+	var out []string
+	hclsyntax.VisitAll(b.Body, func(node hclsyntax.Node) hcl.Diagnostics {
+		if expr, ok := node.(hclsyntax.Expression); ok {
+			for _, ref := range References(expr) {
+				if !seen[ref] {
+					seen[ref] = true
+					out = append(out, ref)
+				}
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// References returns the de-duplicated addresses referenced by an
+// expression, in source order.
+func References(expr hcl.Expression) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, tr := range expr.Variables() {
+		ref := refAddress(tr)
+		if ref != "" && !seen[ref] {
+			seen[ref] = true
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// refAddress reduces a traversal to the address of the object it refers to.
+func refAddress(tr hcl.Traversal) string {
+	var parts []string
+	for _, step := range tr {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			parts = append(parts, s.Name)
+		case hcl.TraverseAttr:
+			parts = append(parts, s.Name)
+		default:
+			// Index steps (count.index, [0]) end the address.
+			return addressPrefix(parts)
+		}
+	}
+	return addressPrefix(parts)
+}
+
+func addressPrefix(parts []string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	n := 2
+	switch parts[0] {
+	case "data":
+		n = 3
+	case "count", "each", "self", "path", "terraform":
+		n = 1
+	}
+	if len(parts) < n {
+		n = len(parts)
+	}
+	return strings.Join(parts[:n], ".")
+}
+
+// AsInt converts a known whole number value.
+func AsInt(v cty.Value) (int, bool) {
+	if v == cty.NilVal || !v.IsKnown() || v.IsNull() {
+		return 0, false
+	}
+	if v.Type() == cty.String {
+		n, ok := new(big.Float).SetString(v.AsString())
+		if !ok {
+			return 0, false
+		}
+		v = cty.NumberVal(n)
+	}
+	if v.Type() != cty.Number {
+		return 0, false
+	}
+	i, acc := v.AsBigFloat().Int64()
+	if acc != big.Exact {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// AsStrings converts a list, set or tuple of strings.
+func AsStrings(v cty.Value) ([]string, bool) {
+	if v == cty.NilVal || !v.IsKnown() || v.IsNull() {
+		return nil, false
+	}
+	if v.Type() == cty.String {
+		return []string{v.AsString()}, true
+	}
+	if !v.CanIterateElements() {
+		return nil, false
+	}
+	complete := true
+	var out []string
+	for it := v.ElementIterator(); it.Next(); {
+		_, ev := it.Element()
+		if !ev.IsKnown() || ev.IsNull() || ev.Type() != cty.String {
+			complete = false
+			continue
+		}
+		out = append(out, ev.AsString())
+	}
+	return out, complete
+}
+
+// AsJSON converts a value to plain Go data. JSON strings are decoded; objects
+// and maps are converted directly. Unknown parts of an otherwise known object
+// become the string "${unknown}" so callers can still inspect its shape.
+func AsJSON(v cty.Value) (interface{}, bool) {
+	if v == cty.NilVal || !v.IsKnown() || v.IsNull() {
+		return nil, false
+	}
+	if v.Type() == cty.String {
+		var out interface{}
+		if err := json.Unmarshal([]byte(v.AsString()), &out); err != nil {
+			return nil, false
+		}
+		return out, true
+	}
+	return ToGo(v), true
+}
+
+// Unknown is the placeholder ToGo uses for values that are not known
+// statically.
+const Unknown = "${unknown}"
+
+// ToGo converts a cty value to plain Go data (string, float64, bool,
+// []interface{}, map[string]interface{}). Unknown values become Unknown and
+// nulls become nil.
+func ToGo(v cty.Value) interface{} {
+	switch {
+	case !v.IsKnown():
+		return Unknown
+	case v.IsNull():
+		return nil
+	}
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return v.AsString()
+	case t == cty.Number:
+		f, _ := v.AsBigFloat().Float64()
+		return f
+	case t == cty.Bool:
+		return v.True()
+	case t.IsObjectType() || t.IsMapType():
+		out := map[string]interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+			out[k.AsString()] = ToGo(ev)
+		}
+		return out
+	case v.CanIterateElements():
+		out := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			out = append(out, ToGo(ev))
+		}
+		return out
+	}
+	return nil
+}
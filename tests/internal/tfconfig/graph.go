@@ -0,0 +1,249 @@
+package tfconfig
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// Node identifies an object in a module instance: a resource
+// ("aws_security_group.lambda"), data source ("data.aws_vpc.frontend"),
+// input variable ("var.vpc_id"), local ("local.common_tags") or output
+// ("output.vpc_id").
+type Node struct {
+	Module *Module
+	Addr   string
+}
+
+func (n Node) String() string {
+	return n.Module.Name + ":" + n.Addr
+}
+
+// Block returns the resource or data block of the node, or nil for
+// variables, locals and outputs.
+func (n Node) Block() *Block {
+	parts := strings.Split(n.Addr, ".")
+	switch {
+	case parts[0] == "data" && len(parts) == 3:
+		for _, b := range n.Module.DataSources(parts[1]) {
+			if b.Name() == parts[2] {
+				return b
+			}
+		}
+	case len(parts) == 2 && parts[0] != "var" && parts[0] != "local" && parts[0] != "output":
+		return n.Module.Resource(parts[0], parts[1])
+	}
+	return nil
+}
+
+// Defined reports whether the node is declared in its module. References to
+// outputs that a remote stack does not declare resolve to undefined nodes.
+func (n Node) Defined() bool {
+	parts := strings.SplitN(n.Addr, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	switch parts[0] {
+	case "var":
+		return n.Module.blockNamed("variable", parts[1]) != nil
+	case "output":
+		return n.Module.blockNamed("output", parts[1]) != nil
+	case "local":
+		_, ok := n.Module.locals[parts[1]]
+		return ok
+	}
+	return n.Block() != nil
+}
+
+// Kind returns the resource or data source type of the node, or "" for
+// variables, locals and outputs.
+func (n Node) Kind() string {
+	if b := n.Block(); b != nil {
+		return b.Kind()
+	}
+	return ""
+}
+
+// Graph is the reference graph of a tree: an edge A -> B means the
+// definition of A refers to B. Module inputs, module outputs and
+// terraform_remote_state outputs are followed across module and stack
+// boundaries.
+type Graph struct {
+	tree  *Tree
+	edges map[Node][]Node
+}
+
+// RefGraph builds the reference graph of every module instance in the tree.
+func (t *Tree) RefGraph() *Graph {
+	g := &Graph{tree: t, edges: map[Node][]Node{}}
+	for _, m := range t.Modules {
+		for _, b := range m.Blocks {
+			switch b.Type {
+			case "resource", "data":
+				from := Node{m, b.Address()}
+				for _, e := range exprs(b.Body) {
+					g.link(from, m, e)
+				}
+			case "output":
+				if e := b.Expr("value"); e != nil {
+					g.link(Node{m, "output." + b.Name()}, m, e)
+				}
+			case "locals":
+				for _, name := range sortedAttrNames(b.Body) {
+					g.link(Node{m, "local." + name}, m, b.Body.Attributes[name].Expr)
+				}
+			case "module":
+				child := m.Children[b.Name()]
+				if child == nil {
+					continue
+				}
+				for _, name := range sortedAttrNames(b.Body) {
+					switch name {
+					case "source", "version", "providers", "depends_on":
+						continue
+					}
+					g.link(Node{child, "var." + name}, m, b.Body.Attributes[name].Expr)
+				}
+			}
+		}
+	}
+	return g
+}
+
+func (g *Graph) link(from Node, m *Module, expr hclsyntax.Expression) {
+	for _, path := range Traversals(expr) {
+		for _, to := range g.resolve(m, strings.Split(path, ".")) {
+			if to != from {
+				g.edges[from] = appendUnique(g.edges[from], to)
+			}
+		}
+	}
+}
+
+// resolve maps a traversal in m to the nodes it refers to.
+func (g *Graph) resolve(m *Module, parts []string) []Node {
+	if len(parts) < 2 {
+		return nil
+	}
+	switch parts[0] {
+	case "count", "each", "self", "path", "terraform":
+		return nil
+	case "var", "local":
+		return []Node{{m, parts[0] + "." + parts[1]}}
+	case "module":
+		child := m.Children[parts[1]]
+		if child == nil {
+			return nil
+		}
+		if len(parts) >= 3 {
+			return []Node{{child, "output." + parts[2]}}
+		}
+		var all []Node
+		for _, o := range child.Outputs() {
+			all = append(all, Node{child, "output." + o.Name()})
+		}
+		return all
+	case "data":
+		if len(parts) < 3 {
+			return nil
+		}
+		if parts[1] == "terraform_remote_state" && len(parts) >= 5 && parts[3] == "outputs" {
+			if stack := g.tree.RemoteStateStack(m, parts[2]); stack != nil {
+				return []Node{{stack, "output." + parts[4]}}
+			}
+		}
+		return []Node{{m, "data." + parts[1] + "." + parts[2]}}
+	}
+	return []Node{{m, parts[0] + "." + parts[1]}}
+}
+
+// Refs returns the nodes a node refers to directly.
+func (g *Graph) Refs(n Node) []Node {
+	return g.edges[n]
+}
+
+// Referrers returns the nodes that refer directly to n.
+func (g *Graph) Referrers(n Node) []Node {
+	var out []Node
+	for from, tos := range g.edges {
+		for _, to := range tos {
+			if to == n {
+				out = append(out, from)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// Reachable returns every node reachable from the start nodes. Traversal
+// does not continue past nodes for which stop returns true, although those
+// nodes are included in the result.
+func (g *Graph) Reachable(start []Node, stop func(Node) bool) map[Node]bool {
+	seen := map[Node]bool{}
+	queue := append([]Node(nil), start...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		if stop != nil && stop(n) {
+			continue
+		}
+		queue = append(queue, g.edges[n]...)
+	}
+	return seen
+}
+
+// ResolveExpr follows an expression in m through variables, locals, module
+// outputs and remote state outputs, and returns the resource and data source
+// nodes it ultimately refers to. A nil expression (an unset attribute)
+// resolves to nothing.
+func (g *Graph) ResolveExpr(m *Module, expr hclsyntax.Expression) []Node {
+	if expr == nil {
+		return nil
+	}
+	var start []Node
+	for _, path := range Traversals(expr) {
+		start = append(start, g.resolve(m, strings.Split(path, "."))...)
+	}
+	isObject := func(n Node) bool { return n.Block() != nil }
+	var out []Node
+	for n := range g.Reachable(start, isObject) {
+		if isObject(n) {
+			out = append(out, n)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+func sortedAttrNames(body *hclsyntax.Body) []string {
+	names := make([]string, 0, len(body.Attributes))
+	for name := range body.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendUnique(list []Node, n Node) []Node {
+	for _, x := range list {
+		if x == n {
+			return list
+		}
+	}
+	return append(list, n)
+}
+
+func (m *Module) blockNamed(blockType, name string) *Block {
+	for _, b := range m.blocksOfType(blockType) {
+		if b.Name() == name {
+			return b
+		}
+	}
+	return nil
+}
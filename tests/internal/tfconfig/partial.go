@@ -0,0 +1,145 @@
+package tfconfig
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Partial converts an expression to plain Go data like ToGo, but walks object
+// and tuple constructors, string templates and jsonencode calls so that an
+// unknown interpolation only blanks out its own part. For example
+// "arn:aws:s3:::${aws_s3_bucket.docs.id}/*" becomes
+// "arn:aws:s3:::${unknown}/*" instead of a wholly unknown string.
+func (m *Module) Partial(expr hcl.Expression) interface{} {
+	return m.partial(expr, false)
+}
+
+// Symbolic is Partial, except that an unknown reference is kept as
+// "${<path>}", e.g. "${local.frontend_execute_api_endpoint_id}", so that two
+// expressions naming the same unknown value can be compared.
+func (m *Module) Symbolic(expr hcl.Expression) interface{} {
+	return m.partial(expr, true)
+}
+
+func (m *Module) partial(expr hcl.Expression, symbolic bool) interface{} {
+	switch e := expr.(type) {
+	case *hclsyntax.ObjectConsExpr:
+		out := map[string]interface{}{}
+		for _, item := range e.Items {
+			key := hcl.ExprAsKeyword(item.KeyExpr)
+			if key == "" {
+				kv := m.Eval(item.KeyExpr)
+				if !kv.IsKnown() || kv.IsNull() || kv.Type() != cty.String {
+					continue
+				}
+				key = kv.AsString()
+			}
+			out[key] = m.partial(item.ValueExpr, symbolic)
+		}
+		return out
+	case *hclsyntax.TupleConsExpr:
+		out := []interface{}{}
+		for _, ex := range e.Exprs {
+			out = append(out, m.partial(ex, symbolic))
+		}
+		return out
+	case *hclsyntax.TemplateWrapExpr:
+		return m.partial(e.Wrapped, symbolic)
+	case *hclsyntax.TemplateExpr:
+		v := m.Eval(e)
+		if v.IsWhollyKnown() {
+			return ToGo(v)
+		}
+		var sb strings.Builder
+		for _, part := range e.Parts {
+			pv := m.Eval(part)
+			if s, ok := partString(pv); ok {
+				sb.WriteString(s)
+			} else if ref, ok := symbol(part, symbolic); ok {
+				sb.WriteString(ref)
+			} else {
+				sb.WriteString(Unknown)
+			}
+		}
+		return sb.String()
+	case *hclsyntax.FunctionCallExpr:
+		if e.Name == "jsonencode" && len(e.Args) == 1 {
+			v := m.Eval(e)
+			if v.IsWhollyKnown() {
+				return ToGo(v)
+			}
+			data, err := json.Marshal(m.partial(e.Args[0], symbolic))
+			if err != nil {
+				return Unknown
+			}
+			return string(data)
+		}
+	}
+	v := m.Eval(expr)
+	if !v.IsKnown() {
+		if ref, ok := symbol(expr, symbolic); ok {
+			return ref
+		}
+	}
+	return ToGo(v)
+}
+
+// symbol renders a bare reference as "${<path>}" for Symbolic.
+func symbol(expr hcl.Expression, symbolic bool) (string, bool) {
+	tr, ok := expr.(*hclsyntax.ScopeTraversalExpr)
+	if !symbolic || !ok {
+		return "", false
+	}
+	return "${" + strings.Join(traversalParts(tr.Traversal), ".") + "}", true
+}
+
+// PartialString is Partial for expressions expected to produce a string. The
+// second result is false when the expression is not a string at all.
+func (m *Module) PartialString(expr hcl.Expression) (string, bool) {
+	s, ok := m.Partial(expr).(string)
+	return s, ok
+}
+
+// JSON decodes a policy-style expression: a jsonencode call, a JSON string
+// or heredoc, or an object. Unknown interpolations become Unknown.
+func (m *Module) JSON(expr hcl.Expression) (interface{}, bool) {
+	if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok && call.Name == "jsonencode" && len(call.Args) == 1 {
+		return m.Partial(call.Args[0]), true
+	}
+	switch p := m.Partial(expr).(type) {
+	case string:
+		if p == Unknown {
+			return nil, false
+		}
+		var out interface{}
+		if err := json.Unmarshal([]byte(p), &out); err != nil {
+			return nil, false
+		}
+		return out, true
+	case map[string]interface{}:
+		return p, true
+	}
+	return nil, false
+}
+
+func partString(v cty.Value) (string, bool) {
+	if !v.IsKnown() || v.IsNull() {
+		return "", false
+	}
+	switch v.Type() {
+	case cty.String:
+		return v.AsString(), true
+	case cty.Number:
+		return v.AsBigFloat().Text('f', -1), true
+	case cty.Bool:
+		if v.True() {
+			return "true", true
+		}
+		return "false", true
+	}
+	return "", false
+}
@@ -0,0 +1,414 @@
+// Package tfconfig loads Terraform configuration directories for static
+// analysis in tests.
+//
+// Every *.tf file in a directory is parsed with the HCL native syntax parser.
+// Expressions are evaluated against variable defaults (optionally overridden
+// by tfvars values) and locals. References to managed resources, data
+// sources, modules and other runtime values evaluate to unknown, so partially
+// known attributes (for example a jsonencode policy that embeds a bucket ARN)
+// still resolve to a value that callers can inspect.
+package tfconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/tryfunc"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// Module is a parsed Terraform configuration directory.
+type Module struct {
+	Dir    string
+	Name   string // display name, e.g. "environments/network-layer/module.vpc_us"
+	Blocks []*Block
+
+	// Parent, Call and Children link module instances loaded by LoadTree.
+	Parent   *Module
+	Call     *Block
+	Children map[string]*Module
+
+	vars   map[string]cty.Value
+	locals map[string]cty.Value
+	ctx    *hcl.EvalContext
+	root   string // directory of the root module, path.root
+}
+
+// Block is a top-level or nested configuration block.
+type Block struct {
+	Type   string
+	Labels []string
+	Body   *hclsyntax.Body
+	File   string
+
+	module *Module
+}
+
+// Load parses every *.tf file in dir and prepares an evaluation context from
+// variable defaults and locals.
+func Load(dir string) (*Module, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Terraform files in %s", dir)
+	}
+	sort.Strings(files)
+
+	m := &Module{Dir: dir, Name: dir, root: dir}
+	parser := hclparse.NewParser()
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("parse %s: %s", path, diags.Error())
+		}
+		body := file.Body.(*hclsyntax.Body)
+		for _, b := range body.Blocks {
+			m.Blocks = append(m.Blocks, &Block{
+				Type:   b.Type,
+				Labels: b.Labels,
+				Body:   b.Body,
+				File:   filepath.Base(path),
+				module: m,
+			})
+		}
+	}
+
+	m.SetVars(nil)
+	return m, nil
+}
+
+// LoadVarsFile reads a .tfvars (or .tfvars.example) file into a value map
+// suitable for SetVars.
+func LoadVarsFile(path string) (map[string]cty.Value, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("parse %s: %s", path, diags.Error())
+	}
+
+	vals := map[string]cty.Value{}
+	for name, attr := range file.Body.(*hclsyntax.Body).Attributes {
+		v, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("evaluate %s in %s: %s", name, path, diags.Error())
+		}
+		vals[name] = v
+	}
+	return vals, nil
+}
+
+// SetVars replaces input variable values and re-evaluates locals. Variables
+// not present in vals fall back to their declared default, or unknown when
+// the variable has no default.
+func (m *Module) SetVars(vals map[string]cty.Value) {
+	m.vars = map[string]cty.Value{}
+	for _, b := range m.blocksOfType("variable") {
+		name := b.Labels[0]
+		if v, ok := vals[name]; ok {
+			m.vars[name] = v
+			continue
+		}
+		m.vars[name] = cty.DynamicVal
+		if attr, ok := b.Body.Attributes["default"]; ok {
+			if v, diags := attr.Expr.Value(nil); !diags.HasErrors() {
+				m.vars[name] = v
+			}
+		}
+	}
+
+	m.ctx = &hcl.EvalContext{
+		Variables: map[string]cty.Value{},
+		Functions: m.functions(),
+	}
+	m.bindUnknownRoots()
+	m.ctx.Variables["var"] = cty.ObjectVal(m.vars)
+	m.ctx.Variables["path"] = cty.ObjectVal(map[string]cty.Value{
+		"module": cty.StringVal(m.Dir),
+		"root":   cty.StringVal(m.root),
+		"cwd":    cty.StringVal(m.root),
+	})
+	m.ctx.Variables["terraform"] = cty.ObjectVal(map[string]cty.Value{
+		"workspace": cty.StringVal("default"),
+	})
+	m.evalLocals()
+}
+
+// Var returns the effective value of an input variable.
+func (m *Module) Var(name string) cty.Value {
+	if v, ok := m.vars[name]; ok {
+		return v
+	}
+	return cty.NilVal
+}
+
+// Local returns the evaluated value of a local.
+func (m *Module) Local(name string) cty.Value {
+	if v, ok := m.locals[name]; ok {
+		return v
+	}
+	return cty.NilVal
+}
+
+// Eval evaluates an expression in the module context. Evaluation errors
+// yield an unknown value rather than failing, since most analyses only care
+// about the statically known parts of a configuration.
+func (m *Module) Eval(expr hcl.Expression) cty.Value {
+	return m.EvalWith(expr, nil)
+}
+
+// EvalWith evaluates an expression with extra root variables such as each,
+// count or a dynamic block iterator.
+func (m *Module) EvalWith(expr hcl.Expression, extra map[string]cty.Value) cty.Value {
+	ctx := m.ctx
+	if len(extra) > 0 {
+		ctx = m.ctx.NewChild()
+		ctx.Variables = extra
+	}
+	v, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return cty.DynamicVal
+	}
+	return v
+}
+
+// Resources returns managed resources of the given type, or all managed
+// resources when typ is empty.
+func (m *Module) Resources(typ string) []*Block {
+	return m.labelled("resource", typ)
+}
+
+// Resource returns a single managed resource by type and name.
+func (m *Module) Resource(typ, name string) *Block {
+	for _, b := range m.Resources(typ) {
+		if b.Labels[1] == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// DataSources returns data blocks of the given type, or all of them when typ
+// is empty.
+func (m *Module) DataSources(typ string) []*Block {
+	return m.labelled("data", typ)
+}
+
+// ModuleCalls returns every module block.
+func (m *Module) ModuleCalls() []*Block {
+	return m.blocksOfType("module")
+}
+
+// ModuleCall returns the module block with the given name.
+func (m *Module) ModuleCall(name string) *Block {
+	for _, b := range m.ModuleCalls() {
+		if b.Labels[0] == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// Outputs returns every output block.
+func (m *Module) Outputs() []*Block {
+	return m.blocksOfType("output")
+}
+
+// LoadModuleCall loads the local module referenced by a module block and
+// sets its variables from the block arguments evaluated in this module.
+func (m *Module) LoadModuleCall(call *Block) (*Module, error) {
+	source, ok := call.String("source")
+	if !ok || !(strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")) {
+		return nil, fmt.Errorf("module %s: only local sources are supported", call.Labels[0])
+	}
+	child, err := Load(filepath.Join(m.Dir, source))
+	if err != nil {
+		return nil, err
+	}
+	child.root = m.root
+
+	inputs := map[string]cty.Value{}
+	for name, attr := range call.Body.Attributes {
+		switch name {
+		case "source", "version", "providers", "depends_on", "count", "for_each":
+			continue
+		}
+		inputs[name] = m.Eval(attr.Expr)
+	}
+	child.SetVars(inputs)
+	return child, nil
+}
+
+func (m *Module) labelled(blockType, typ string) []*Block {
+	var out []*Block
+	for _, b := range m.blocksOfType(blockType) {
+		if typ == "" || b.Labels[0] == typ {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func (m *Module) blocksOfType(blockType string) []*Block {
+	var out []*Block
+	for _, b := range m.Blocks {
+		if b.Type == blockType {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// bindUnknownRoots makes every root name referenced anywhere in the module
+// (resource types, data, module, each, count, self, ...) evaluate to an
+// unknown value so that expressions mixing them with literals still resolve.
+func (m *Module) bindUnknownRoots() {
+	for _, b := range m.Blocks {
+		hclsyntax.VisitAll(b.Body, func(node hclsyntax.Node) hcl.Diagnostics {
+			expr, ok := node.(hclsyntax.Expression)
+			if !ok {
+				return nil
+			}
+			for _, tr := range expr.Variables() {
+				root := tr.RootName()
+				if _, bound := m.ctx.Variables[root]; !bound {
+					m.ctx.Variables[root] = cty.DynamicVal
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// evalLocals evaluates locals in repeated passes until no value changes, so
+// locals that reference other locals resolve regardless of file order.
+func (m *Module) evalLocals() {
+	exprs := map[string]hcl.Expression{}
+	for _, b := range m.blocksOfType("locals") {
+		for name, attr := range b.Body.Attributes {
+			exprs[name] = attr.Expr
+		}
+	}
+
+	m.locals = map[string]cty.Value{}
+	for name := range exprs {
+		m.locals[name] = cty.DynamicVal
+	}
+	m.ctx.Variables["local"] = cty.ObjectVal(m.locals)
+
+	for pass := 0; pass <= len(exprs); pass++ {
+		changed := false
+		next := map[string]cty.Value{}
+		for name, expr := range exprs {
+			v := m.Eval(expr)
+			if !v.RawEquals(m.locals[name]) {
+				changed = true
+			}
+			next[name] = v
+		}
+		m.locals = next
+		m.ctx.Variables["local"] = cty.ObjectVal(m.locals)
+		if !changed {
+			return
+		}
+	}
+}
+
+// functions returns the Terraform functions supported during evaluation.
+// Functions that are called in the module but not implemented here are bound
+// to a stub returning an unknown value.
+func (m *Module) functions() map[string]function.Function {
+	funcs := Functions()
+	for _, b := range m.Blocks {
+		hclsyntax.VisitAll(b.Body, func(node hclsyntax.Node) hcl.Diagnostics {
+			if call, ok := node.(*hclsyntax.FunctionCallExpr); ok {
+				if _, known := funcs[call.Name]; !known {
+					funcs[call.Name] = unknownFunc
+				}
+			}
+			return nil
+		})
+	}
+	return funcs
+}
+
+// Functions returns a new table of the Terraform functions implemented
+// here, for evaluating expressions outside a module, such as templatefile
+// templates.
+func Functions() map[string]function.Function {
+	return map[string]function.Function{
+		"abs":        stdlib.AbsoluteFunc,
+		"can":        tryfunc.CanFunc,
+		"ceil":       stdlib.CeilFunc,
+		"chomp":      stdlib.ChompFunc,
+		"coalesce":   stdlib.CoalesceFunc,
+		"compact":    stdlib.CompactFunc,
+		"concat":     stdlib.ConcatFunc,
+		"contains":   stdlib.ContainsFunc,
+		"distinct":   stdlib.DistinctFunc,
+		"element":    stdlib.ElementFunc,
+		"flatten":    stdlib.FlattenFunc,
+		"floor":      stdlib.FloorFunc,
+		"format":     stdlib.FormatFunc,
+		"formatlist": stdlib.FormatListFunc,
+		"indent":     stdlib.IndentFunc,
+		"join":       stdlib.JoinFunc,
+		"jsondecode": stdlib.JSONDecodeFunc,
+		"jsonencode": stdlib.JSONEncodeFunc,
+		"keys":       stdlib.KeysFunc,
+		"length":     stdlib.LengthFunc,
+		"lookup":     stdlib.LookupFunc,
+		"lower":      stdlib.LowerFunc,
+		"max":        stdlib.MaxFunc,
+		"merge":      stdlib.MergeFunc,
+		"min":        stdlib.MinFunc,
+		"range":      stdlib.RangeFunc,
+		"replace":    stdlib.ReplaceFunc,
+		"reverse":    stdlib.ReverseListFunc,
+		"setunion":   stdlib.SetUnionFunc,
+		"slice":      stdlib.SliceFunc,
+		"sort":       stdlib.SortFunc,
+		"split":      stdlib.SplitFunc,
+		"substr":     stdlib.SubstrFunc,
+		"title":      stdlib.TitleFunc,
+		"tolist":     stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+		"tomap":      stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+		"tonumber":   stdlib.MakeToFunc(cty.Number),
+		"toset":      stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+		"tostring":   stdlib.MakeToFunc(cty.String),
+		"trimprefix": stdlib.TrimPrefixFunc,
+		"trimspace":  stdlib.TrimSpaceFunc,
+		"trimsuffix": stdlib.TrimSuffixFunc,
+		"try":        tryfunc.TryFunc,
+		"upper":      stdlib.UpperFunc,
+		"values":     stdlib.ValuesFunc,
+		"zipmap":     stdlib.ZipmapFunc,
+	}
+}
+
+var unknownFunc = function.New(&function.Spec{
+	VarParam: &function.Parameter{
+		Name:             "args",
+		Type:             cty.DynamicPseudoType,
+		AllowNull:        true,
+		AllowUnknown:     true,
+		AllowDynamicType: true,
+		AllowMarked:      true,
+	},
+	Type: function.StaticReturnType(cty.DynamicPseudoType),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.DynamicVal, nil
+	},
+})
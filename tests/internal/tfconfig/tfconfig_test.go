@@ -0,0 +1,242 @@
+package tfconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const sampleConfig = `
+variable "vpc_cidr" {
+  type    = string
+  default = "10.10.0.0/16"
+}
+
+variable "peer_vpc_cidr" {
+  type    = string
+  default = ""
+}
+
+locals {
+  name   = "${local.prefix}-nacl"
+  prefix = "bos-ai"
+}
+
+resource "aws_s3_bucket" "docs" {
+  bucket = "${local.prefix}-docs"
+}
+
+resource "aws_network_acl_rule" "peer" {
+  count      = var.peer_vpc_cidr != "" ? 1 : 0
+  cidr_block = var.peer_vpc_cidr
+}
+
+resource "aws_iam_policy" "read" {
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+      Action   = ["s3:GetObject"]
+      Resource = "${aws_s3_bucket.docs.arn}/*"
+    }]
+  })
+}
+
+resource "aws_security_group" "lambda" {
+  dynamic "egress" {
+    for_each = [443]
+    content {
+      from_port = egress.value
+    }
+  }
+}
+`
+
+func writeSample(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleConfig), 0o644))
+	return dir
+}
+
+func TestLoad_EvaluatesVariablesAndLocals(t *testing.T) {
+	t.Parallel()
+
+	m, err := Load(writeSample(t))
+	require.NoError(t, err)
+
+	assert.Equal(t, cty.StringVal("10.10.0.0/16"), m.Var("vpc_cidr"))
+	assert.Equal(t, cty.StringVal("bos-ai-nacl"), m.Local("name"), "locals should resolve regardless of order")
+
+	bucket, ok := m.Resource("aws_s3_bucket", "docs").String("bucket")
+	require.True(t, ok)
+	assert.Equal(t, "bos-ai-docs", bucket)
+}
+
+func TestCount_FollowsVariables(t *testing.T) {
+	t.Parallel()
+
+	m, err := Load(writeSample(t))
+	require.NoError(t, err)
+
+	rule := m.Resource("aws_network_acl_rule", "peer")
+	n, ok := rule.Count()
+	require.True(t, ok)
+	assert.Equal(t, 0, n, "peer rule should be disabled without a peer CIDR")
+
+	m.SetVars(map[string]cty.Value{"peer_vpc_cidr": cty.StringVal("10.20.0.0/16")})
+	n, ok = m.Resource("aws_network_acl_rule", "peer").Count()
+	require.True(t, ok)
+	assert.Equal(t, 1, n)
+}
+
+func TestJSON_KeepsKnownPartsOfPolicies(t *testing.T) {
+	t.Parallel()
+
+	m, err := Load(writeSample(t))
+	require.NoError(t, err)
+
+	doc, ok := m.Resource("aws_iam_policy", "read").JSON("policy")
+	require.True(t, ok)
+
+	stmt := doc.(map[string]interface{})["Statement"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Allow", stmt["Effect"])
+	assert.Equal(t, Unknown+"/*", stmt["Resource"])
+}
+
+func TestSymbolic_KeepsUnknownReferences(t *testing.T) {
+	t.Parallel()
+
+	m, err := Load(writeSample(t))
+	require.NoError(t, err)
+
+	policy := m.Resource("aws_iam_policy", "read")
+	call := policy.Expr("policy").(*hclsyntax.FunctionCallExpr)
+	doc := m.Symbolic(call.Args[0]).(map[string]interface{})
+	stmt := doc["Statement"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "${aws_s3_bucket.docs.arn}/*", stmt["Resource"])
+	assert.Equal(t, "bos-ai-docs", m.Symbolic(m.Resource("aws_s3_bucket", "docs").Expr("bucket")))
+}
+
+func TestRefsAndNestedBlocks(t *testing.T) {
+	t.Parallel()
+
+	m, err := Load(writeSample(t))
+	require.NoError(t, err)
+
+	policy := m.Resource("aws_iam_policy", "read")
+	assert.Equal(t, []string{"aws_s3_bucket.docs"}, policy.AllRefs())
+
+	egress := m.Resource("aws_security_group", "lambda").Nested("egress")
+	require.Len(t, egress, 1)
+	assert.True(t, egress[0].IsDynamic())
+}
+
+func TestLoadModuleCall_PassesInputs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	child := filepath.Join(root, "child")
+	require.NoError(t, os.MkdirAll(child, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(child, "main.tf"), []byte(sampleConfig), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.tf"), []byte(`
+module "acl" {
+  source        = "./child"
+  peer_vpc_cidr = "10.20.0.0/16"
+}
+`), 0o644))
+
+	m, err := Load(root)
+	require.NoError(t, err)
+
+	c, err := m.LoadModuleCall(m.ModuleCall("acl"))
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("10.20.0.0/16"), c.Var("peer_vpc_cidr"))
+	assert.Equal(t, cty.StringVal("10.10.0.0/16"), c.Var("vpc_cidr"))
+}
+
+func TestLoadModuleCall_KeepsPathRoot(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	files := map[string]string{
+		"main.tf":   "module \"a\" {\n  source = \"./a\"\n}\n",
+		"a/main.tf": "module \"b\" {\n  source = \"../b\"\n}\n",
+		"b/main.tf": "locals {\n  root   = path.root\n  module = path.module\n}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	m, err := Load(root)
+	require.NoError(t, err)
+	a, err := m.LoadModuleCall(m.ModuleCall("a"))
+	require.NoError(t, err)
+	b, err := a.LoadModuleCall(a.ModuleCall("b"))
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal(root), b.Local("root"))
+	assert.Equal(t, cty.StringVal(filepath.Join(root, "a", "../b")), b.Local("module"))
+}
+
+func TestLoadTree_FollowsRemoteState(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	files := map[string]string{
+		// a.tf sorts before the nested stack and z.tf after it; the parent
+		// directory must still load as a single stack.
+		"environments/net/a.tf": `
+terraform {
+  backend "s3" {
+    key = "net/terraform.tfstate"
+  }
+}
+resource "aws_vpc" "main" {}
+`,
+		"environments/net/z.tf": `
+output "vpc_id" {
+  value = aws_vpc.main.id
+}
+`,
+		"environments/net/app/main.tf": `
+data "terraform_remote_state" "net" {
+  config = {
+    key = "net/terraform.tfstate"
+  }
+}
+locals {
+  vpc_id = data.terraform_remote_state.net.outputs.vpc_id
+}
+resource "aws_subnet" "a" {
+  vpc_id = local.vpc_id
+}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	tree, err := LoadTree(root)
+	require.NoError(t, err)
+	require.Len(t, tree.Stacks, 2)
+
+	net := tree.Stack("environments/net")
+	app := tree.Stack("environments/net/app")
+	require.NotNil(t, net)
+	require.NotNil(t, app)
+	assert.Same(t, net, tree.RemoteStateStack(app, "net"))
+
+	g := tree.RefGraph()
+	subnet := app.Resource("aws_subnet", "a")
+	assert.Equal(t, []Node{{net, "aws_vpc.main"}}, g.ResolveExpr(app, subnet.Expr("vpc_id")))
+	assert.Equal(t, []Node{{app, "local.vpc_id"}}, g.Referrers(Node{net, "output.vpc_id"}))
+}
@@ -0,0 +1,206 @@
+package tfconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// Tree is every Terraform root module (stack) under a repository root,
+// together with the local child modules they instantiate. Stacks reference
+// each other through terraform_remote_state data sources, which the tree
+// resolves by matching the S3 backend state key.
+type Tree struct {
+	Root    string
+	Stacks  []*Module
+	Modules []*Module // stacks and all child module instances
+}
+
+// LoadTree loads every directory under root/environments that contains *.tf
+// files as a stack, applies terraform.tfvars (or terraform.tfvars.example)
+// when present, and loads each local module call as a child instance.
+func LoadTree(root string) (*Tree, error) {
+	var dirs []string
+	seen := map[string]bool{}
+	err := filepath.Walk(filepath.Join(root, "environments"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".tf") {
+			dir := filepath.Dir(path)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+
+	t := &Tree{Root: root}
+	for _, dir := range dirs {
+		m, err := Load(dir)
+		if err != nil {
+			return nil, err
+		}
+		m.Name, _ = filepath.Rel(root, dir)
+		for _, name := range []string{"terraform.tfvars", "terraform.tfvars.example"} {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			vals, err := LoadVarsFile(path)
+			if err != nil {
+				return nil, err
+			}
+			m.SetVars(vals)
+			break
+		}
+		t.Stacks = append(t.Stacks, m)
+		if err := t.addWithChildren(m); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *Tree) addWithChildren(m *Module) error {
+	t.Modules = append(t.Modules, m)
+	for _, call := range m.ModuleCalls() {
+		source, _ := call.String("source")
+		if !strings.HasPrefix(source, "./") && !strings.HasPrefix(source, "../") {
+			continue
+		}
+		child, err := m.LoadModuleCall(call)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		child.Name = m.Name + "/module." + call.Labels[0]
+		child.Parent = m
+		child.Call = call
+		if m.Children == nil {
+			m.Children = map[string]*Module{}
+		}
+		m.Children[call.Labels[0]] = child
+		if err := t.addWithChildren(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stack returns the stack loaded from root/dir, e.g.
+// Stack("environments/network-layer").
+func (t *Tree) Stack(dir string) *Module {
+	for _, m := range t.Stacks {
+		if m.Name == filepath.Clean(dir) {
+			return m
+		}
+	}
+	return nil
+}
+
+// StackForStateKey returns the stack whose S3 backend uses the state key.
+func (t *Tree) StackForStateKey(key string) *Module {
+	for _, m := range t.Stacks {
+		if m.StateKey() == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// RemoteStateStack returns the stack a terraform_remote_state data source in
+// m reads from, or nil when its key does not match any stack in the tree.
+func (t *Tree) RemoteStateStack(m *Module, name string) *Module {
+	for _, ds := range m.DataSources("terraform_remote_state") {
+		if ds.Name() != name {
+			continue
+		}
+		config := ds.Value("config")
+		if !config.IsKnown() || config.IsNull() || !config.Type().IsObjectType() || !config.Type().HasAttribute("key") {
+			return nil
+		}
+		key := config.GetAttr("key")
+		if !key.IsKnown() || key.IsNull() {
+			return nil
+		}
+		return t.StackForStateKey(key.AsString())
+	}
+	return nil
+}
+
+// StateKey returns the key of the module's S3 backend, or "" when it has
+// none.
+func (m *Module) StateKey() string {
+	for _, b := range m.blocksOfType("terraform") {
+		for _, nb := range b.Body.Blocks {
+			if nb.Type != "backend" {
+				continue
+			}
+			if attr, ok := nb.Body.Attributes["key"]; ok {
+				if v := m.Eval(attr.Expr); v.IsKnown() && !v.IsNull() {
+					return v.AsString()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Traversals returns the attribute paths referenced by an expression as
+// dotted strings, e.g. "module.vpc.private_subnet_ids" or
+// "data.terraform_remote_state.network.outputs.us_vpc_id". Paths stop at
+// the first index step.
+func Traversals(expr hcl.Expression) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, tr := range expr.Variables() {
+		p := strings.Join(traversalParts(tr), ".")
+		if p != "" && !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func traversalParts(tr hcl.Traversal) []string {
+	var parts []string
+	for _, step := range tr {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			parts = append(parts, s.Name)
+		case hcl.TraverseAttr:
+			parts = append(parts, s.Name)
+		default:
+			// Index steps (count.index, [0], ["key"]) end the path.
+			return parts
+		}
+	}
+	return parts
+}
+
+// exprs returns every expression in a body, used to collect references
+// from nested blocks.
+func exprs(body *hclsyntax.Body) []hclsyntax.Expression {
+	var out []hclsyntax.Expression
+	for _, name := range sortedAttrNames(body) {
+		out = append(out, body.Attributes[name].Expr)
+	}
+	for _, b := range body.Blocks {
+		out = append(out, exprs(b.Body)...)
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+// Package userdata renders the templates passed to templatefile() in a
+// Terraform tree, the way Terraform would, and extracts the files the
+// resulting shell scripts write so their contents can be checked.
+package userdata
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// CallSite is a templatefile(path, vars) call in a module.
+type CallSite struct {
+	Module   *tfconfig.Module
+	Block    *tfconfig.Block // top-level block containing the call
+	Template string          // template path, as evaluated
+	Vars     map[string]cty.Value
+	Range    hcl.Range
+}
+
+func (c CallSite) String() string {
+	return fmt.Sprintf("%s:%s templatefile(%s)", c.Module.Name, c.Block.Address(), filepath.Base(c.Template))
+}
+
+// Render renders the call site's template with its variables.
+func (c CallSite) Render() (string, error) {
+	out, err := Render(c.Template, c.Vars)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", c, err)
+	}
+	return out, nil
+}
+
+// CallSites returns every templatefile call in the tree whose path and
+// variables evaluate statically. Calls that do not are returned as errors.
+func CallSites(tree *tfconfig.Tree) ([]CallSite, []error) {
+	var sites []CallSite
+	var errs []error
+	for _, m := range tree.Modules {
+		for _, b := range m.Blocks {
+			hclsyntax.VisitAll(b.Body, func(node hclsyntax.Node) hcl.Diagnostics {
+				call, ok := node.(*hclsyntax.FunctionCallExpr)
+				if !ok || call.Name != "templatefile" {
+					return nil
+				}
+				site, err := callSite(m, b, call)
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					sites = append(sites, site)
+				}
+				return nil
+			})
+		}
+	}
+	return sites, errs
+}
+
+func callSite(m *tfconfig.Module, b *tfconfig.Block, call *hclsyntax.FunctionCallExpr) (CallSite, error) {
+	site := CallSite{Module: m, Block: b, Range: call.Range()}
+	where := fmt.Sprintf("%s:%s (%s)", m.Name, b.Address(), call.Range())
+	if len(call.Args) != 2 {
+		return site, fmt.Errorf("%s: templatefile takes 2 arguments", where)
+	}
+	path := m.Eval(call.Args[0])
+	if !path.IsKnown() || path.IsNull() || path.Type() != cty.String {
+		return site, fmt.Errorf("%s: template path is not statically known", where)
+	}
+	site.Template = path.AsString()
+
+	vars := m.Eval(call.Args[1])
+	if !vars.IsKnown() || vars.IsNull() || !(vars.Type().IsObjectType() || vars.Type().IsMapType()) {
+		return site, fmt.Errorf("%s: template variables are not statically known", where)
+	}
+	site.Vars = map[string]cty.Value{}
+	for k, v := range vars.AsValueMap() {
+		site.Vars[k] = v
+	}
+	return site, nil
+}
+
+// Render renders a template file with Terraform template semantics (${},
+// %{ for }, %{ if }, and the functions of tfconfig.Functions). Like
+// Terraform it fails when the template references a variable that is not
+// passed. Values that are not known statically are
+// rendered as tfconfig.Unknown.
+func Render(path string, vars map[string]cty.Value) (string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	expr, diags := hclsyntax.ParseTemplate(src, path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", diags
+	}
+
+	var undefined []string
+	for _, tr := range expr.Variables() {
+		if _, ok := vars[tr.RootName()]; !ok {
+			undefined = append(undefined, tr.RootName())
+		}
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return "", fmt.Errorf("%s: undefined template variables: %s", path, strings.Join(undefined, ", "))
+	}
+
+	known := map[string]cty.Value{}
+	for k, v := range vars {
+		known[k] = withUnknownPlaceholders(v)
+	}
+	v, diags := expr.Value(&hcl.EvalContext{Variables: known, Functions: tfconfig.Functions()})
+	if diags.HasErrors() {
+		return "", diags
+	}
+	if !v.IsKnown() || v.Type() != cty.String {
+		return "", fmt.Errorf("%s: template did not render to a string", path)
+	}
+	return v.AsString(), nil
+}
+
+func withUnknownPlaceholders(v cty.Value) cty.Value {
+	out, _ := cty.Transform(v, func(_ cty.Path, v cty.Value) (cty.Value, error) {
+		if !v.IsKnown() {
+			return cty.StringVal(tfconfig.Unknown), nil
+		}
+		return v, nil
+	})
+	return out
+}
+
+// Script is a rendered shell script.
+type Script struct {
+	Text string
+
+	// Files maps the paths written with cat heredocs to their contents.
+	// Unquoted heredocs are expanded like the shell would, using the
+	// variables assigned earlier in the script.
+	Files map[string]string
+
+	// Vars holds top-level NAME=value assignments with literal values.
+	Vars map[string]string
+}
+
+var (
+	assignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+	catHeredoc = regexp.MustCompile(`^\s*cat\s+(?:>\s*(\S+)\s+<<(-?)\s*(['"]?)(\w+)['"]?|<<(-?)\s*(['"]?)(\w+)['"]?\s+>\s*(\S+))\s*$`)
+	shellVar   = regexp.MustCompile(`\\[$\\` + "`" + `]|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// ParseScript extracts the files a script writes and its variables.
+func ParseScript(text string) *Script {
+	s := &Script{Text: text, Files: map[string]string{}, Vars: map[string]string{}}
+	assigned := map[string]bool{}
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := assignment.FindStringSubmatch(line); m != nil {
+			assigned[m[1]] = true
+			if value, quoted, ok := literal(m[2]); ok {
+				if !quoted {
+					value = s.expand(value, assigned)
+				}
+				s.Vars[m[1]] = value
+			} else {
+				delete(s.Vars, m[1])
+			}
+			continue
+		}
+		m := catHeredoc.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		path, strip, quote, tag := m[1], m[2], m[3], m[4]
+		if path == "" {
+			path, strip, quote, tag = m[8], m[5], m[6], m[7]
+		}
+		var body strings.Builder
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if strip == "-" {
+				l = strings.TrimLeft(l, "\t")
+			}
+			if l == tag {
+				break
+			}
+			body.WriteString(l)
+			body.WriteString("\n")
+		}
+		content := body.String()
+		if quote == "" {
+			content = s.expand(content, assigned)
+		}
+		s.Files[path] = content
+	}
+	return s
+}
+
+// literal returns the value of an assignment right-hand side that does not
+// run a command, and whether it is single-quoted and so not expanded.
+func literal(rhs string) (string, bool, bool) {
+	switch {
+	case len(rhs) >= 2 && rhs[0] == '\'' && rhs[len(rhs)-1] == '\'':
+		return rhs[1 : len(rhs)-1], true, true
+	case strings.Contains(rhs, "$(") || strings.Contains(rhs, "`"):
+		return "", false, false
+	case len(rhs) >= 2 && rhs[0] == '"' && rhs[len(rhs)-1] == '"':
+		return rhs[1 : len(rhs)-1], false, true
+	case strings.ContainsAny(rhs, " \t;|&"):
+		return "", false, false
+	}
+	return rhs, false, true
+}
+
+// expand substitutes $NAME, ${NAME} and ${NAME:-default} and removes
+// backslash escapes. Variables assigned a value that is not a literal keep
+// their reference, as does the placeholder for unknown template values.
+func (s *Script) expand(text string, assigned map[string]bool) string {
+	return shellVar.ReplaceAllStringFunc(text, func(ref string) string {
+		if ref[0] == '\\' {
+			return ref[1:]
+		}
+		if ref == tfconfig.Unknown {
+			return ref
+		}
+		m := shellVar.FindStringSubmatch(ref)
+		name := m[1] + m[3]
+		if v, ok := s.Vars[name]; ok {
+			return v
+		}
+		if assigned[name] {
+			return ref
+		}
+		return strings.TrimPrefix(m[2], ":-")
+	})
+}
+
+// Unit is a parsed systemd unit file: section to key to values, in order.
+type Unit map[string]map[string][]string
+
+// ParseUnit parses a systemd unit file.
+func ParseUnit(text string) Unit {
+	u := Unit{}
+	section := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+			if u[section] == nil {
+				u[section] = map[string][]string{}
+			}
+		default:
+			if k, v, ok := strings.Cut(line, "="); ok && section != "" {
+				u[section][strings.TrimSpace(k)] = append(u[section][strings.TrimSpace(k)], strings.TrimSpace(v))
+			}
+		}
+	}
+	return u
+}
+
+// Get returns the last value of a key, which is the one systemd uses for
+// single-valued settings.
+func (u Unit) Get(section, key string) string {
+	vs := u[section][key]
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[len(vs)-1]
+}
@@ -0,0 +1,152 @@
+package userdata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleTemplate writes an nginx config with one server per upstream and a
+// systemd unit, exercising ${}, %{ for }, %{ if } and $${} escapes.
+const sampleTemplate = `#!/bin/bash
+PORT="${port}"
+TOKEN=$(cat /run/token)
+cat > /etc/nginx/conf.d/app.conf <<EOF
+%{ for name, host in upstreams ~}
+server {
+    listen $PORT;
+    server_name ${name}.example.com;
+    location / { proxy_pass https://${host}/; }
+}
+%{ endfor ~}
+EOF
+cat <<-'UNIT' > /etc/systemd/system/app.service
+	[Unit]
+	Description=App
+	[Service]
+	Environment=PORT=$PORT
+	ExecStart=/usr/bin/app%{ if debug } --debug%{ endif }
+	Restart=on-failure
+	Restart=always
+	UNIT
+cat > /etc/app.env << ENV
+TOKEN=$TOKEN
+PORT=$${PORT}
+MODE=$${MODE:-prod}
+HOME=\$HOME
+ENV
+`
+
+const sampleConfig = `
+variable "debug" {
+  default = true
+}
+
+resource "aws_instance" "app" {
+  user_data = base64encode(templatefile("${path.module}/app.sh.tpl", {
+    port      = 8443
+    debug     = var.debug
+    upstreams = { api = "api.internal", web = "web.internal" }
+  }))
+}
+
+resource "aws_instance" "dynamic" {
+  user_data = templatefile("${path.module}/app.sh.tpl", local.unknown_vars)
+}
+`
+
+func loadSample(t *testing.T) []CallSite {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleConfig), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.sh.tpl"), []byte(sampleTemplate), 0o644))
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+
+	sites, errs := CallSites(tree)
+	require.Len(t, errs, 1, "local.unknown_vars cannot be evaluated")
+	assert.Contains(t, errs[0].Error(), "aws_instance.dynamic")
+	return sites
+}
+
+func TestCallSites_RendersWithEvaluatedVars(t *testing.T) {
+	t.Parallel()
+
+	sites := loadSample(t)
+	require.Len(t, sites, 1)
+	site := sites[0]
+	assert.Equal(t, "aws_instance.app", site.Block.Address())
+	assert.Equal(t, "app.sh.tpl", filepath.Base(site.Template))
+	assert.Equal(t, cty.True, site.Vars["debug"])
+
+	out, err := site.Render()
+	require.NoError(t, err)
+	s := ParseScript(out)
+	assert.Equal(t, map[string]string{"PORT": "8443"}, s.Vars)
+
+	assert.Equal(t, `server {
+    listen 8443;
+    server_name api.example.com;
+    location / { proxy_pass https://api.internal/; }
+}
+server {
+    listen 8443;
+    server_name web.example.com;
+    location / { proxy_pass https://web.internal/; }
+}
+`, s.Files["/etc/nginx/conf.d/app.conf"])
+
+	// Quoted heredocs are not expanded, and <<- strips leading tabs.
+	u := ParseUnit(s.Files["/etc/systemd/system/app.service"])
+	assert.Equal(t, "PORT=$PORT", u.Get("Service", "Environment"))
+	assert.Equal(t, "/usr/bin/app --debug", u.Get("Service", "ExecStart"))
+	assert.Equal(t, []string{"on-failure", "always"}, u["Service"]["Restart"])
+	assert.Equal(t, "always", u.Get("Service", "Restart"))
+	assert.Equal(t, "", u.Get("Install", "WantedBy"))
+
+	// Commands substitutions are kept, unset variables take their default.
+	assert.Equal(t, "TOKEN=$TOKEN\nPORT=8443\nMODE=prod\nHOME=$HOME\n", s.Files["/etc/app.env"])
+}
+
+func TestRender_FailsOnUndefinedVariables(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "t.tpl")
+	require.NoError(t, os.WriteFile(path, []byte(sampleTemplate), 0o644))
+
+	_, err := Render(path, map[string]cty.Value{"port": cty.NumberIntVal(80)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined template variables: debug, upstreams")
+
+	// Unknown values render as a placeholder instead of failing.
+	out, err := Render(path, map[string]cty.Value{
+		"port":      cty.UnknownVal(cty.Number),
+		"debug":     cty.False,
+		"upstreams": cty.MapValEmpty(cty.String),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, tfconfig.Unknown, ParseScript(out).Vars["PORT"])
+}
+
+func TestRender_CallsTerraformFunctions(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "t.tpl")
+	require.NoError(t, os.WriteFile(path, []byte("echo '${jsonencode({hosts = hosts})}' > /etc/app.json\nNAME=${upper(name)}\n"), 0o644))
+
+	out, err := Render(path, map[string]cty.Value{
+		"hosts": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+		"name":  cty.StringVal("proxy"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "echo '{\"hosts\":[\"a\",\"b\"]}' > /etc/app.json\nNAME=PROXY\n", out)
+}
@@ -0,0 +1,239 @@
+package vectorindex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+)
+
+// Checks reported by Check.
+const (
+	CheckMapping      = "mapping"
+	CheckDrift        = "drift"
+	CheckFieldMapping = "field-mapping"
+	CheckEngine       = "engine"
+	CheckDimension    = "dimension"
+	CheckModel        = "model"
+	CheckStore        = "store"
+	CheckUnknownValue = "unknown-value"
+)
+
+// Bedrock knowledge bases on OpenSearch Serverless only search faiss HNSW
+// indexes with these space types.
+var (
+	bedrockEngine = "faiss"
+	bedrockMethod = "hnsw"
+	bedrockSpaces = []string{"l2", "innerproduct"}
+)
+
+// qdrantDistances are the distance functions Qdrant accepts.
+var qdrantDistances = []string{"Cosine", "Euclid", "Dot", "Manhattan"}
+
+// user is a knowledge base or writer of a store.
+type user struct {
+	id    string
+	model string
+	dims  int
+}
+
+// dimension returns the dimension of the vectors the user writes or
+// queries with, or 0 when the model is not in the table.
+func (u user) dimension() int {
+	if u.dims > 0 {
+		return u.dims
+	}
+	if em, ok := LookupModel(u.model); ok {
+		return em.Default()
+	}
+	return 0
+}
+
+// Check checks every store of the model against its definitions and users:
+//
+//   - Every index body enables k-NN and gives its knn_vector fields a valid
+//     dimension, engine, method and space type; every collection a size
+//     and a distance Qdrant accepts.
+//   - The bodies a module generates for one index, and the scripts that
+//     create one collection, agree with each other.
+//   - A knowledge base's field mapping names a knn_vector field and text
+//     fields of its index, and the vector field uses an engine, method and
+//     space type Bedrock can search.
+//   - Every user's embedding model is in EmbeddingModels, can produce the
+//     dimension it asks for, and produces vectors of the store's dimension.
+//     Users of a store without a definition agree with each other.
+//   - Every store a knowledge base or writer uses is defined in the tree.
+//
+// Values that are not statically known are reported as CheckUnknownValue.
+func (md *Model) Check() []finding.Finding {
+	var out []finding.Finding
+	add := func(subject, check, format string, args ...interface{}) {
+		out = append(out, finding.Finding{Subject: subject, Check: check, Detail: fmt.Sprintf(format, args...)})
+	}
+	for _, u := range md.Unknown {
+		i := strings.Index(u, ": ")
+		add(u[:i], CheckUnknownValue, "%s is not statically known", u[i+2:])
+	}
+
+	for _, ix := range md.Indexes {
+		for _, p := range ix.Problems() {
+			add(ix.Source, CheckMapping, "%s", p)
+		}
+	}
+	for _, c := range md.Collections {
+		if c.Size < 1 {
+			add(c.Source, CheckMapping, "collection %q has no vector size", c.Name)
+		}
+		if !contains(qdrantDistances, c.Distance) {
+			add(c.Source, CheckMapping, "collection %q uses distance %q, not one of %s", c.Name, c.Distance, strings.Join(qdrantDistances, ", "))
+		}
+	}
+
+	// Drift: bodies of one index, and scripts of one collection.
+	for _, store := range md.Stores() {
+		defs := md.Definitions(store)
+		for i := 1; i < len(defs); i++ {
+			if d := defs[0].Diff(defs[i]); d != "" {
+				add(defs[i].Source, CheckDrift, "defines %s differently: %s", store, d)
+			}
+		}
+		cols := md.CollectionsNamed(store)
+		for i := 1; i < len(cols); i++ {
+			if c := cols[i]; c.Size != cols[0].Size || c.Distance != cols[0].Distance {
+				add(c.Source, CheckDrift, "collection %q is %d/%s here but %d/%s in %s", c.Name, c.Size, c.Distance, cols[0].Size, cols[0].Distance, cols[0].Source)
+			}
+		}
+	}
+
+	users := map[string][]user{}
+	for _, kb := range md.KnowledgeBases {
+		if kb.Store == "" {
+			continue
+		}
+		users[kb.Store] = append(users[kb.Store], user{kb.ID, kb.Model, kb.Dimensions})
+		// The other definitions of the index are compared with the
+		// first as drift.
+		if defs := md.Definitions(kb.Store); len(defs) > 0 {
+			ix := defs[0]
+			for _, p := range fieldMapping(kb, ix) {
+				add(kb.ID, CheckFieldMapping, "%s in %s", p, ix.Source)
+			}
+			if f, ok := ix.Fields[kb.VectorField]; ok && f.Type == "knn_vector" && kb.StorageType == "OPENSEARCH_SERVERLESS" &&
+				(f.Engine != bedrockEngine || f.Method != bedrockMethod || !contains(bedrockSpaces, f.SpaceType)) {
+				add(kb.ID, CheckEngine, "%s is %s/%s/%s in %s; Bedrock searches %s/%s with %s", kb.VectorField, f.Engine, f.Method, f.SpaceType,
+					ix.Source, bedrockEngine, bedrockMethod, strings.Join(bedrockSpaces, " or "))
+			}
+		}
+	}
+	for _, w := range md.Writers {
+		users[w.Store] = append(users[w.Store], user{w.ID, w.Model, w.Dimensions})
+	}
+
+	for _, store := range sortedKeys(users) {
+		dim, from := md.storeDimension(store)
+		if from == "" {
+			add(store, CheckStore, "used by %s, but no index body or script in the tree creates it", ids(users[store]))
+		}
+		for _, u := range users[store] {
+			em, ok := LookupModel(u.model)
+			switch {
+			case !ok:
+				add(u.id, CheckModel, "embedding model %q is not in the table of embedding models", u.model)
+				continue
+			case u.dims > 0 && !em.Supports(u.dims):
+				add(u.id, CheckDimension, "%s cannot produce %d-dimensional vectors, only %s", em.ID, u.dims, dimensions(em.Dimensions))
+				continue
+			}
+			if from != "" && u.dimension() != dim {
+				add(u.id, CheckDimension, "%s produces %d-dimensional vectors, but %s is %d-dimensional in %s", em.ID, u.dimension(), store, dim, from)
+			}
+		}
+		if from == "" {
+			first := users[store][0]
+			for _, u := range users[store][1:] {
+				if a, b := first.dimension(), u.dimension(); a > 0 && b > 0 && a != b {
+					add(u.id, CheckDimension, "writes %d-dimensional vectors to %s, but %s writes %d", b, store, first.id, a)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Stores returns the IDs of every store with a definition, sorted.
+func (md *Model) Stores() []string {
+	seen := map[string]bool{}
+	for _, ix := range md.Indexes {
+		if ix.Name != "" {
+			seen[StoreID(OpenSearch, ix.Name)] = true
+		}
+	}
+	for _, c := range md.Collections {
+		seen[StoreID(Qdrant, c.Name)] = true
+	}
+	return sortedKeys(seen)
+}
+
+// storeDimension returns the vector dimension of a store and the source it
+// is read from, or "" when the store has no definition. An index body with
+// several knn_vector fields gives the dimension of the first.
+func (md *Model) storeDimension(store string) (int, string) {
+	for _, ix := range md.Definitions(store) {
+		if vf := ix.VectorFields(); len(vf) > 0 {
+			return ix.Fields[vf[0]].Dimension, ix.Source
+		}
+	}
+	if cols := md.CollectionsNamed(store); len(cols) > 0 {
+		return cols[0].Size, cols[0].Source
+	}
+	return 0, ""
+}
+
+// fieldMapping describes the field_mapping fields of a knowledge base that
+// the index body does not map as Bedrock needs them.
+func fieldMapping(kb *KnowledgeBase, ix *Index) []string {
+	var out []string
+	for _, want := range []struct{ role, name, typ string }{
+		{"vector_field", kb.VectorField, "knn_vector"},
+		{"text_field", kb.TextField, "text"},
+		{"metadata_field", kb.MetadataField, "text"},
+	} {
+		if want.name == "" {
+			continue
+		}
+		f, ok := ix.Fields[want.name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("%s %q is not mapped", want.role, want.name))
+		case f.Type != want.typ:
+			out = append(out, fmt.Sprintf("%s %q is %s, not %s", want.role, want.name, f.Type, want.typ))
+		}
+	}
+	return out
+}
+
+func ids(us []user) string {
+	var out []string
+	for _, u := range us {
+		out = append(out, u.id)
+	}
+	return strings.Join(out, ", ")
+}
+
+func dimensions(dims []int) string {
+	var out []string
+	for _, d := range dims {
+		out = append(out, fmt.Sprint(d))
+	}
+	return strings.Join(out, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,200 @@
+package vectorindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MaxDimension is the largest knn_vector dimension OpenSearch accepts.
+const MaxDimension = 16000
+
+// DefaultSpaceType is the space type of a knn_vector method that sets none.
+const DefaultSpaceType = "l2"
+
+// engines lists the methods and space types each k-NN engine supports.
+var engines = map[string]struct {
+	methods []string
+	spaces  []string
+}{
+	"faiss":  {[]string{"hnsw", "ivf"}, []string{"l2", "innerproduct"}},
+	"nmslib": {[]string{"hnsw"}, []string{"l2", "innerproduct", "cosinesimil", "l1", "linf"}},
+	"lucene": {[]string{"hnsw"}, []string{"l2", "innerproduct", "cosinesimil"}},
+}
+
+// Index is an OpenSearch index body: its settings and field mappings.
+type Index struct {
+	Name   string // index name, or "" when the body does not say
+	Source string // where the body is defined
+	KNN    bool   // settings.index.knn
+	Fields map[string]Field
+}
+
+// Field is a field mapping. Engine, Method and SpaceType are only set for
+// knn_vector fields.
+type Field struct {
+	Type      string
+	Dimension int
+	Engine    string
+	Method    string
+	SpaceType string
+	Indexed   bool // index is not false
+}
+
+// ParseMapping reads an index body decoded from JSON, as a jsonencode
+// argument or a JSON document.
+func ParseMapping(source string, body interface{}) (*Index, error) {
+	root, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: index body is not an object", source)
+	}
+	ix := &Index{Source: source, Fields: map[string]Field{}}
+	switch knn := dig(root, "settings", "index", "knn").(type) {
+	case bool:
+		ix.KNN = knn
+	case string:
+		ix.KNN = knn == "true"
+	}
+	if knn, ok := dig(root, "settings", "index.knn").(bool); ok {
+		ix.KNN = knn
+	}
+	props, ok := dig(root, "mappings", "properties").(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: index body has no mappings.properties", source)
+	}
+	for name, v := range props {
+		p, _ := v.(map[string]interface{})
+		f := Field{Indexed: true}
+		f.Type, _ = p["type"].(string)
+		if idx, ok := p["index"].(bool); ok {
+			f.Indexed = idx
+		}
+		if f.Type == "knn_vector" {
+			dim, ok := p["dimension"].(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s: dimension of %s is not a number", source, name)
+			}
+			f.Dimension = int(dim)
+			f.Engine, _ = dig(p, "method", "engine").(string)
+			f.Method, _ = dig(p, "method", "name").(string)
+			f.SpaceType, _ = dig(p, "method", "space_type").(string)
+			if f.SpaceType == "" {
+				f.SpaceType, _ = p["space_type"].(string)
+			}
+			if f.SpaceType == "" {
+				f.SpaceType = DefaultSpaceType
+			}
+		}
+		ix.Fields[name] = f
+	}
+	return ix, nil
+}
+
+// ParseMappingFile reads an index body from a JSON file.
+func ParseMappingFile(path, source string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return ParseMapping(source, body)
+}
+
+func dig(v interface{}, path ...string) interface{} {
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[p]
+	}
+	return v
+}
+
+// VectorFields returns the names of the knn_vector fields, sorted.
+func (ix *Index) VectorFields() []string {
+	var out []string
+	for name, f := range ix.Fields {
+		if f.Type == "knn_vector" {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Problems returns what OpenSearch would reject or ignore in the index body:
+// k-NN that is not enabled, knn_vector fields with a dimension outside 1 to
+// MaxDimension, or an engine, method or space type the engine does not
+// support.
+func (ix *Index) Problems() []string {
+	var out []string
+	vectors := ix.VectorFields()
+	if len(vectors) > 0 && !ix.KNN {
+		out = append(out, "settings.index.knn is not true")
+	}
+	for _, name := range vectors {
+		f := ix.Fields[name]
+		if f.Dimension < 1 || f.Dimension > MaxDimension {
+			out = append(out, fmt.Sprintf("%s has dimension %d, not between 1 and %d", name, f.Dimension, MaxDimension))
+		}
+		e, ok := engines[f.Engine]
+		if !ok {
+			out = append(out, fmt.Sprintf("%s uses unknown engine %q", name, f.Engine))
+			continue
+		}
+		if !contains(e.methods, f.Method) {
+			out = append(out, fmt.Sprintf("%s uses method %q, which %s does not support", name, f.Method, f.Engine))
+		}
+		if !contains(e.spaces, f.SpaceType) {
+			out = append(out, fmt.Sprintf("%s uses space type %q, which %s does not support", name, f.SpaceType, f.Engine))
+		}
+	}
+	return out
+}
+
+// Diff describes how the fields of two index bodies differ, or returns ""
+// when they define the same fields.
+func (ix *Index) Diff(other *Index) string {
+	names := map[string]bool{}
+	for name := range ix.Fields {
+		names[name] = true
+	}
+	for name := range other.Fields {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		a, inA := ix.Fields[name]
+		b, inB := other.Fields[name]
+		switch {
+		case !inA:
+			return fmt.Sprintf("%s is only in %s", name, other.Source)
+		case !inB:
+			return fmt.Sprintf("%s is only in %s", name, ix.Source)
+		case a != b:
+			return fmt.Sprintf("%s is %+v in %s but %+v in %s", name, a, ix.Source, b, other.Source)
+		}
+	}
+	if ix.KNN != other.KNN {
+		return fmt.Sprintf("knn is %v in %s but %v in %s", ix.KNN, ix.Source, other.KNN, other.Source)
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
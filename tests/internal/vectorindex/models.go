@@ -0,0 +1,61 @@
+package vectorindex
+
+import "strings"
+
+// EmbeddingModel is a Bedrock embedding model, the vector dimensions it
+// can produce and the longest text it embeds.
+type EmbeddingModel struct {
+	ID         string
+	Dimensions []int // the default first
+	MaxTokens  int
+}
+
+// Default returns the dimension the model produces unless asked otherwise.
+func (em EmbeddingModel) Default() int {
+	return em.Dimensions[0]
+}
+
+// Supports reports whether the model can produce vectors of a dimension.
+func (em EmbeddingModel) Supports(dim int) bool {
+	for _, d := range em.Dimensions {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
+
+// EmbeddingModels is the bundled table of Bedrock embedding models, by
+// model ID.
+var EmbeddingModels = map[string]EmbeddingModel{}
+
+func init() {
+	for _, em := range []EmbeddingModel{
+		{ID: "amazon.titan-embed-text-v1", Dimensions: []int{1536}, MaxTokens: 8192},
+		{ID: "amazon.titan-embed-g1-text-02", Dimensions: []int{1536}, MaxTokens: 8192},
+		{ID: "amazon.titan-embed-text-v2:0", Dimensions: []int{1024, 512, 256}, MaxTokens: 8192},
+		{ID: "amazon.titan-embed-image-v1", Dimensions: []int{1024, 384, 256}, MaxTokens: 128},
+		{ID: "cohere.embed-english-v3", Dimensions: []int{1024}, MaxTokens: 512},
+		{ID: "cohere.embed-multilingual-v3", Dimensions: []int{1024}, MaxTokens: 512},
+		{ID: "cohere.embed-v4:0", Dimensions: []int{1536, 1024, 512, 256}, MaxTokens: 128000},
+	} {
+		EmbeddingModels[em.ID] = em
+	}
+}
+
+// ModelID returns the model ID of a foundation model ARN, e.g.
+// "amazon.titan-embed-text-v1" for
+// "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v1",
+// or s itself when it is not an ARN.
+func ModelID(s string) string {
+	if i := strings.Index(s, ":foundation-model/"); i >= 0 && strings.HasPrefix(s, "arn:") {
+		return s[i+len(":foundation-model/"):]
+	}
+	return s
+}
+
+// LookupModel returns the embedding model of a model ID or ARN.
+func LookupModel(s string) (EmbeddingModel, bool) {
+	em, ok := EmbeddingModels[ModelID(s)]
+	return em, ok
+}
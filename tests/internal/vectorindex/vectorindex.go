@@ -0,0 +1,409 @@
+// Package vectorindex models the vector stores of a Terraform tree and what
+// writes to them: the OpenSearch index bodies the tree generates or checks
+// in, the Qdrant collections its scripts create, the Bedrock knowledge
+// bases that read them and the Lambda functions that embed documents into
+// them. Stores are compared with the embedding models of their users
+// through a bundled table of model dimensions.
+package vectorindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Store IDs are "<engine>:<name>": an OpenSearch index or a Qdrant
+// collection, by name. Stores of the same name in different collections or
+// Qdrant instances are treated as one store.
+const (
+	OpenSearch = "opensearch"
+	Qdrant     = "qdrant"
+)
+
+// StoreID returns the ID of an OpenSearch index or Qdrant collection.
+func StoreID(engine, name string) string {
+	return engine + ":" + name
+}
+
+// KnowledgeBase is a Bedrock knowledge base with a vector store.
+type KnowledgeBase struct {
+	ID          string // node ID
+	Model       string // embedding model ID
+	Dimensions  int    // bedrock_embedding_model_configuration dimensions, or 0
+	StorageType string
+	Store       string // store ID
+
+	VectorField   string
+	TextField     string
+	MetadataField string
+}
+
+// Writer is a Lambda function that embeds documents into a store, as its
+// environment variables tell.
+type Writer struct {
+	ID         string // node ID of the function
+	Model      string // embedding model ID
+	Dimensions int    // *_DIMENSION(S) environment variable, or 0
+	Store      string // store ID
+}
+
+// Collection is a Qdrant collection created by a script.
+type Collection struct {
+	Name     string
+	Source   string // script the collection is created in
+	Size     int
+	Distance string
+}
+
+// Model is every vector store definition and every user of a store in a
+// tree.
+type Model struct {
+	KnowledgeBases []*KnowledgeBase // sorted by ID
+	Writers        []*Writer        // sorted by ID
+	Indexes        []*Index         // sorted by source
+	Collections    []*Collection    // sorted by name and source
+
+	// Unknown lists attributes that are set but not statically known.
+	Unknown []string
+
+	Tree *tfconfig.Tree
+
+	// modules groups indexes generated by the same module instance, which
+	// describe the same index even when only one of them is named.
+	modules map[*Index]string
+}
+
+// storeVariables are the Lambda environment variables that name the store a
+// function writes to.
+var storeVariables = map[string]string{
+	"OPENSEARCH_INDEX":  OpenSearch,
+	"QDRANT_COLLECTION": Qdrant,
+}
+
+// Build collects the knowledge bases, index bodies, Lambda writers and
+// Qdrant collections of every module in the tree. Index bodies come from
+// null_resource provisioner heredocs, local_file contents and the files
+// local_file writes; collections from the shell scripts next to the .tf
+// files. Resources with count = 0 are skipped.
+func Build(tree *tfconfig.Tree) *Model {
+	md := &Model{Tree: tree, modules: map[*Index]string{}}
+	scripts := map[string]bool{}
+	for _, m := range tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_bedrockagent_knowledge_base")) {
+			md.KnowledgeBases = append(md.KnowledgeBases, md.knowledgeBase(m, b))
+		}
+		for _, b := range instantiated(m.Resources("aws_lambda_function")) {
+			if w := md.writer(m, b); w != nil {
+				md.Writers = append(md.Writers, w)
+			}
+		}
+		for _, b := range instantiated(m.Resources("null_resource")) {
+			md.provisioned(m, b)
+		}
+		for _, b := range instantiated(m.Resources("local_file")) {
+			md.localFile(m, b)
+		}
+		paths, _ := filepath.Glob(filepath.Join(m.Dir, "*.sh"))
+		for _, p := range paths {
+			rel, err := filepath.Rel(tree.Root, p)
+			if err == nil && !scripts[rel] {
+				scripts[rel] = true
+				if err := md.AddScripts(rel); err != nil {
+					md.Unknown = append(md.Unknown, err.Error())
+				}
+			}
+		}
+	}
+	sort.Slice(md.KnowledgeBases, func(i, j int) bool { return md.KnowledgeBases[i].ID < md.KnowledgeBases[j].ID })
+	sort.Slice(md.Writers, func(i, j int) bool { return md.Writers[i].ID < md.Writers[j].ID })
+	md.sort()
+	return md
+}
+
+func (md *Model) sort() {
+	sort.Slice(md.Indexes, func(i, j int) bool { return md.Indexes[i].Source < md.Indexes[j].Source })
+	sort.Slice(md.Collections, func(i, j int) bool {
+		a, b := md.Collections[i], md.Collections[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Source < b.Source
+	})
+	sort.Strings(md.Unknown)
+}
+
+func (md *Model) knowledgeBase(m *tfconfig.Module, b *tfconfig.Block) *KnowledgeBase {
+	kb := &KnowledgeBase{ID: nodeID(m, b)}
+	for _, kc := range b.Nested("knowledge_base_configuration") {
+		for _, vc := range kc.Nested("vector_knowledge_base_configuration") {
+			if arn, ok := vc.String("embedding_model_arn"); ok {
+				kb.Model = ModelID(arn)
+			} else {
+				md.unknown(kb.ID, vc, "embedding_model_arn")
+			}
+			for _, ec := range vc.Nested("embedding_model_configuration") {
+				for _, bc := range ec.Nested("bedrock_embedding_model_configuration") {
+					if !bc.Has("dimensions") {
+						continue
+					}
+					dims, ok := bc.Int("dimensions")
+					if !ok {
+						md.unknown(kb.ID, bc, "dimensions")
+					}
+					kb.Dimensions = dims
+				}
+			}
+		}
+	}
+	for _, sc := range b.Nested("storage_configuration") {
+		kb.StorageType, _ = sc.String("type")
+		for _, oc := range sc.Nested("opensearch_serverless_configuration") {
+			if name, ok := oc.String("vector_index_name"); ok {
+				kb.Store = StoreID(OpenSearch, name)
+			} else {
+				md.unknown(kb.ID, oc, "vector_index_name")
+			}
+			for _, fm := range oc.Nested("field_mapping") {
+				kb.VectorField, _ = fm.String("vector_field")
+				kb.TextField, _ = fm.String("text_field")
+				kb.MetadataField, _ = fm.String("metadata_field")
+			}
+		}
+	}
+	return kb
+}
+
+// writer returns the function as a Writer when its environment names both
+// an embedding model and a store.
+func (md *Model) writer(m *tfconfig.Module, b *tfconfig.Block) *Writer {
+	w := &Writer{ID: nodeID(m, b)}
+	for _, env := range b.Nested("environment") {
+		vars, _ := env.Partial("variables").(map[string]interface{})
+		for name, v := range vars {
+			s, ok := v.(string)
+			if !ok {
+				if n, ok := v.(float64); ok && isDimension(name) {
+					w.Dimensions = int(n)
+				}
+				continue
+			}
+			switch {
+			case storeVariables[name] != "":
+				w.Store = StoreID(storeVariables[name], s)
+			case isDimension(name):
+				w.Dimensions, _ = strconv.Atoi(s)
+			case strings.Contains(ModelID(s), "embed"):
+				w.Model = ModelID(s)
+			}
+		}
+	}
+	if w.Model == "" || w.Store == "" {
+		return nil
+	}
+	return w
+}
+
+func isDimension(name string) bool {
+	return strings.HasSuffix(name, "_DIMENSION") || strings.HasSuffix(name, "_DIMENSIONS")
+}
+
+// provisioned reads the index bodies written by heredocs of the
+// provisioner commands of a null_resource, named by its index_name trigger.
+func (md *Model) provisioned(m *tfconfig.Module, b *tfconfig.Block) {
+	var name string
+	if triggers, ok := b.Partial("triggers").(map[string]interface{}); ok {
+		name, _ = triggers["index_name"].(string)
+	}
+	for _, pb := range b.Nested("provisioner") {
+		command, ok := pb.Partial("command").(string)
+		if !ok {
+			continue
+		}
+		for _, doc := range heredocs(command) {
+			var body interface{}
+			if json.Unmarshal([]byte(doc), &body) != nil || dig(body, "mappings") == nil {
+				continue
+			}
+			md.addIndex(m, name, nodeID(m, b), body)
+		}
+	}
+}
+
+// localFile reads the index body a local_file writes, and the checked-in
+// copy of the file when there is one.
+func (md *Model) localFile(m *tfconfig.Module, b *tfconfig.Block) {
+	body, ok := b.JSON("content")
+	if !ok || dig(body, "mappings") == nil {
+		return
+	}
+	md.addIndex(m, "", nodeID(m, b), body)
+	filename, ok := b.String("filename")
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+	source, err := filepath.Rel(md.Tree.Root, filename)
+	if err != nil {
+		source = filename
+	}
+	ix, err := ParseMappingFile(filename, source)
+	if err != nil {
+		md.Unknown = append(md.Unknown, err.Error())
+		return
+	}
+	md.Indexes = append(md.Indexes, ix)
+	md.modules[ix] = m.Name
+}
+
+func (md *Model) addIndex(m *tfconfig.Module, name, source string, body interface{}) {
+	ix, err := ParseMapping(source, body)
+	if err != nil {
+		md.Unknown = append(md.Unknown, err.Error())
+		return
+	}
+	ix.Name = name
+	md.Indexes = append(md.Indexes, ix)
+	md.modules[ix] = m.Name
+}
+
+// Bind adds an index body checked in at path, relative to the tree root,
+// as the definition of an OpenSearch index that is created by hand.
+func (md *Model) Bind(name, path string) error {
+	ix, err := ParseMappingFile(filepath.Join(md.Tree.Root, path), path)
+	if err != nil {
+		return err
+	}
+	ix.Name = name
+	md.Indexes = append(md.Indexes, ix)
+	md.sort()
+	return nil
+}
+
+var (
+	heredocStart   = regexp.MustCompile(`<<-?\s*['"]?(\w+)['"]?[^\n]*\n`)
+	collectionPUT  = regexp.MustCompile(`collections/([\w.-]+)`)
+	vectorSize     = regexp.MustCompile(`"size"\s*:\s*(\d+)`)
+	vectorDistance = regexp.MustCompile(`"distance"\s*:\s*"(\w+)"`)
+)
+
+// heredocs returns the bodies of the shell heredocs in a command.
+func heredocs(command string) []string {
+	var out []string
+	for {
+		loc := heredocStart.FindStringSubmatchIndex(command)
+		if loc == nil {
+			return out
+		}
+		marker := command[loc[2]:loc[3]]
+		rest := command[loc[1]:]
+		end := regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(marker) + `\s*$`).FindStringIndex(rest)
+		if end == nil {
+			return out
+		}
+		out = append(out, rest[:end[0]])
+		command = rest[end[1]:]
+	}
+}
+
+// AddScripts reads the Qdrant collections created by scripts: every PUT of
+// collections/<name> followed by a vectors size and distance. Paths are
+// relative to the tree root.
+func (md *Model) AddScripts(paths ...string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(filepath.Join(md.Tree.Root, path))
+		if err != nil {
+			return err
+		}
+		text := string(data)
+		for _, loc := range collectionPUT.FindAllStringSubmatchIndex(text, -1) {
+			line := text[strings.LastIndex(text[:loc[0]], "\n")+1 : loc[0]]
+			if !strings.Contains(line, "PUT") {
+				continue
+			}
+			// The request body follows the URL, up to the next request.
+			body := text[loc[1]:]
+			if next := strings.Index(body, "curl "); next >= 0 {
+				body = body[:next]
+			}
+			c := &Collection{Name: text[loc[2]:loc[3]], Source: filepath.ToSlash(path)}
+			if sm := vectorSize.FindStringSubmatch(body); sm != nil {
+				c.Size, _ = strconv.Atoi(sm[1])
+			}
+			if sm := vectorDistance.FindStringSubmatch(body); sm != nil {
+				c.Distance = sm[1]
+			}
+			md.Collections = append(md.Collections, c)
+		}
+	}
+	md.sort()
+	return nil
+}
+
+// Definitions returns the index bodies named by a store ID, or nil for a
+// Qdrant collection.
+func (md *Model) Definitions(store string) []*Index {
+	var out []*Index
+	names := map[string]map[string]bool{} // index names by module
+	for _, ix := range md.Indexes {
+		if ix.Name == "" {
+			continue
+		}
+		if names[md.modules[ix]] == nil {
+			names[md.modules[ix]] = map[string]bool{}
+		}
+		names[md.modules[ix]][StoreID(OpenSearch, ix.Name)] = true
+		if StoreID(OpenSearch, ix.Name) == store {
+			out = append(out, ix)
+		}
+	}
+	// An unnamed body describes the index of its module, when the module
+	// names only one.
+	for _, ix := range md.Indexes {
+		if n := names[md.modules[ix]]; ix.Name == "" && md.modules[ix] != "" && len(n) == 1 && n[store] {
+			out = append(out, ix)
+		}
+	}
+	return out
+}
+
+// CollectionsNamed returns the scripts' definitions of a Qdrant store.
+func (md *Model) CollectionsNamed(store string) []*Collection {
+	var out []*Collection
+	for _, c := range md.Collections {
+		if StoreID(Qdrant, c.Name) == store {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// unknown records an attribute of a nested block as "<id>: <block>.<attr>
+// at <file>:<line>".
+func (md *Model) unknown(id string, b *tfconfig.Block, attr string) {
+	rng := b.Body.SrcRange
+	md.Unknown = append(md.Unknown, fmt.Sprintf("%s: %s.%s at %s:%d", id, b.Type, attr, filepath.Base(rng.Filename), rng.Start.Line))
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
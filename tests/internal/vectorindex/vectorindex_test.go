@@ -0,0 +1,359 @@
+package vectorindex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleVectorIndex has a knowledge base that embeds with Titan v2 into
+// docs-index, which a null_resource creates with var.dimension dimensions
+// and a keyword metadata field, and a local_file writes out as
+// mapping.json. The ingest function writes 512-dimensional Titan v2 vectors
+// to the chunks collection of setup.sh, and the legacy function writes with
+// a model outside the table to an index nothing creates.
+const sampleVectorIndex = `
+variable "dimension" {
+  default = 1024
+}
+
+resource "aws_bedrockagent_knowledge_base" "docs" {
+  name = "docs"
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v2:0"
+    }
+  }
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = aws_opensearchserverless_collection.main.arn
+      vector_index_name = "docs-index"
+      field_mapping {
+        vector_field   = "vec"
+        text_field     = "text"
+        metadata_field = "meta"
+      }
+    }
+  }
+}
+
+resource "null_resource" "index" {
+  triggers = {
+    endpoint   = aws_opensearchserverless_collection.main.collection_endpoint
+    index_name = "docs-index"
+  }
+
+  provisioner "local-exec" {
+    command = <<-EOT
+      cat > /tmp/mapping.json <<'EOF'
+      {
+        "settings": {"index": {"knn": true}},
+        "mappings": {
+          "properties": {
+            "vec": {
+              "type": "knn_vector",
+              "dimension": ${var.dimension},
+              "method": {"name": "hnsw", "engine": "faiss"}
+            },
+            "text": {"type": "text"},
+            "meta": {"type": "keyword"}
+          }
+        }
+      }
+      EOF
+      curl -X PUT "${aws_opensearchserverless_collection.main.collection_endpoint}/docs-index" -d @/tmp/mapping.json
+    EOT
+  }
+}
+
+resource "local_file" "mapping" {
+  filename = "${path.module}/mapping.json"
+  content = jsonencode({
+    settings = {
+      index = {
+        knn = true
+      }
+    }
+    mappings = {
+      properties = {
+        vec = {
+          type      = "knn_vector"
+          dimension = var.dimension
+          method = {
+            name   = "hnsw"
+            engine = "faiss"
+          }
+        }
+        text = {
+          type = "text"
+        }
+        meta = {
+          type = "keyword"
+        }
+      }
+    }
+  })
+}
+
+resource "aws_lambda_function" "ingest" {
+  function_name = "ingest"
+  environment {
+    variables = {
+      EMBED_MODEL       = "amazon.titan-embed-text-v2:0"
+      EMBED_DIMENSIONS  = "512"
+      QDRANT_COLLECTION = "chunks"
+    }
+  }
+}
+
+resource "aws_lambda_function" "legacy" {
+  function_name = "legacy"
+  environment {
+    variables = {
+      MODEL_ID         = "acme.embed-v1"
+      OPENSEARCH_INDEX = "manual-index"
+    }
+  }
+}
+
+resource "aws_lambda_function" "api" {
+  function_name = "api"
+  environment {
+    variables = {
+      OPENSEARCH_INDEX = "docs-index"
+    }
+  }
+}
+`
+
+// sampleSearch is a second stack whose cosine knowledge base asks Titan v1
+// for 512 dimensions and searches an nmslib cosine index.
+const sampleSearch = `
+resource "aws_bedrockagent_knowledge_base" "cosine" {
+  name = "cosine"
+  knowledge_base_configuration {
+    type = "VECTOR"
+    vector_knowledge_base_configuration {
+      embedding_model_arn = "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v1"
+      embedding_model_configuration {
+        bedrock_embedding_model_configuration {
+          dimensions = 512
+        }
+      }
+    }
+  }
+  storage_configuration {
+    type = "OPENSEARCH_SERVERLESS"
+    opensearch_serverless_configuration {
+      collection_arn    = aws_opensearchserverless_collection.main.arn
+      vector_index_name = "cosine-index"
+      field_mapping {
+        vector_field   = "vec"
+        text_field     = "text"
+        metadata_field = "meta"
+      }
+    }
+  }
+}
+
+resource "null_resource" "cosine_index" {
+  triggers = {
+    index_name = "cosine-index"
+  }
+
+  provisioner "local-exec" {
+    command = <<-EOT
+      curl -X PUT "$ENDPOINT/cosine-index" -d @- <<EOF
+      {
+        "settings": {"index": {"knn": true}},
+        "mappings": {
+          "properties": {
+            "vec": {
+              "type": "knn_vector",
+              "dimension": 1536,
+              "method": {"name": "hnsw", "engine": "nmslib", "space_type": "cosinesimil"}
+            },
+            "text": {"type": "text"},
+            "meta": {"type": "text", "index": false}
+          }
+        }
+      }
+      EOF
+    EOT
+  }
+}
+`
+
+// sampleMappingFile is the checked-in mapping.json, generated when
+// var.dimension was 1536.
+const sampleMappingFile = `{"mappings":{"properties":{"meta":{"type":"keyword"},"text":{"type":"text"},"vec":{"dimension":1536,"method":{"engine":"faiss","name":"hnsw"},"type":"knn_vector"}}},"settings":{"index":{"knn":true}}}`
+
+const sampleSetup = `#!/bin/bash
+curl -X PUT "http://localhost:6333/collections/chunks" \
+  -H "Content-Type: application/json" \
+  -d '{"vectors": {"size": 1024, "distance": "Cosine"}}'
+curl -s "http://localhost:6333/collections/chunks"
+`
+
+// sampleScript creates chunks again with another distance, and a
+// collection with a misspelled distance.
+const sampleScript = `COMMANDS = [
+    'curl -X PUT "http://localhost:6333/collections/chunks" -d \'{"vectors":{"size":1024,"distance":"Dot"}}\'',
+    'curl -X PUT "http://localhost:6333/collections/broken" -d \'{"vectors":{"size":1024,"distance":"Cosin"}}\'',
+]
+`
+
+const (
+	docsKB   = "environments/app:aws_bedrockagent_knowledge_base.docs"
+	cosineKB = "environments/search:aws_bedrockagent_knowledge_base.cosine"
+	ingest   = "environments/app:aws_lambda_function.ingest"
+	legacy   = "environments/app:aws_lambda_function.legacy"
+)
+
+func loadSample(t *testing.T) (*tfconfig.Tree, *Model) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	for _, d := range []string{dir, filepath.Join(root, "environments", "search"), filepath.Join(root, "scripts")} {
+		require.NoError(t, os.MkdirAll(d, 0o755))
+	}
+	for path, content := range map[string]string{
+		filepath.Join(dir, "main.tf"):                            sampleVectorIndex,
+		filepath.Join(dir, "mapping.json"):                       sampleMappingFile,
+		filepath.Join(root, "environments", "search", "main.tf"): sampleSearch,
+		filepath.Join(dir, "setup.sh"):                           sampleSetup,
+		filepath.Join(root, "scripts", "create.py"):              sampleScript,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	md := Build(tree)
+	require.Empty(t, md.Unknown)
+	require.Len(t, md.KnowledgeBases, 2)
+	return tree, md
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadSample(t)
+	assert.Equal(t, &KnowledgeBase{
+		ID: docsKB, Model: "amazon.titan-embed-text-v2:0", StorageType: "OPENSEARCH_SERVERLESS", Store: "opensearch:docs-index",
+		VectorField: "vec", TextField: "text", MetadataField: "meta",
+	}, md.KnowledgeBases[0])
+	assert.Equal(t, 512, md.KnowledgeBases[1].Dimensions)
+	assert.Equal(t, []*Writer{
+		{ID: ingest, Model: "amazon.titan-embed-text-v2:0", Dimensions: 512, Store: "qdrant:chunks"},
+		{ID: legacy, Model: "acme.embed-v1", Store: "opensearch:manual-index"},
+	}, md.Writers, "functions without an embedding model do not write vectors")
+
+	var sources []string
+	for _, ix := range md.Indexes {
+		sources = append(sources, ix.Source)
+	}
+	assert.Equal(t, []string{
+		"environments/app/mapping.json",
+		"environments/app:local_file.mapping",
+		"environments/app:null_resource.index",
+		"environments/search:null_resource.cosine_index",
+	}, sources)
+	assert.Len(t, md.Definitions("opensearch:docs-index"), 3, "the local_file and its file describe the index of their module")
+	assert.Equal(t, Field{Type: "knn_vector", Dimension: 1024, Engine: "faiss", Method: "hnsw", SpaceType: "l2", Indexed: true},
+		md.Definitions("opensearch:docs-index")[0].Fields["vec"])
+	assert.Equal(t, []*Collection{{Name: "chunks", Source: "environments/app/setup.sh", Size: 1024, Distance: "Cosine"}}, md.Collections,
+		"a GET of a collection does not create it")
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tree, md := loadSample(t)
+	require.NoError(t, md.AddScripts("scripts/create.py"))
+	findings := md.Check()
+	var keys []string
+	for _, f := range findings {
+		keys = append(keys, f.Key())
+	}
+	assert.Equal(t, []string{
+		"scripts/create.py mapping",
+		"environments/app/mapping.json drift",
+		"scripts/create.py drift",
+		docsKB + " field-mapping",
+		cosineKB + " engine",
+		cosineKB + " dimension",
+		"opensearch:manual-index store",
+		legacy + " model",
+		ingest + " dimension",
+	}, keys)
+
+	assert.Contains(t, findings, finding.Finding{Subject: "scripts/create.py", Check: CheckMapping,
+		Detail: `collection "broken" uses distance "Cosin", not one of Cosine, Euclid, Dot, Manhattan`})
+	assert.Contains(t, findings, finding.Finding{Subject: "environments/app/mapping.json", Check: CheckDrift,
+		Detail: `defines opensearch:docs-index differently: vec is {Type:knn_vector Dimension:1024 Engine:faiss Method:hnsw SpaceType:l2 Indexed:true} in environments/app:null_resource.index but {Type:knn_vector Dimension:1536 Engine:faiss Method:hnsw SpaceType:l2 Indexed:true} in environments/app/mapping.json`})
+	assert.Contains(t, findings, finding.Finding{Subject: cosineKB, Check: CheckEngine,
+		Detail: `vec is nmslib/hnsw/cosinesimil in environments/search:null_resource.cosine_index; Bedrock searches faiss/hnsw with l2 or innerproduct`})
+	assert.Contains(t, findings, finding.Finding{Subject: docsKB, Check: CheckFieldMapping,
+		Detail: `metadata_field "meta" is keyword, not text in environments/app:null_resource.index`})
+	assert.Contains(t, findings, finding.Finding{Subject: cosineKB, Check: CheckDimension,
+		Detail: `amazon.titan-embed-text-v1 cannot produce 512-dimensional vectors, only 1536`})
+	assert.Contains(t, findings, finding.Finding{Subject: ingest, Check: CheckDimension,
+		Detail: `amazon.titan-embed-text-v2:0 produces 512-dimensional vectors, but qdrant:chunks is 1024-dimensional in environments/app/setup.sh`})
+
+	// At 512 dimensions the index no longer matches Titan v2's default
+	// output, and mapping.json still lags behind.
+	m := tree.Stack("environments/app")
+	m.SetVars(map[string]cty.Value{"dimension": cty.NumberIntVal(512)})
+	md = Build(tree)
+	assert.Contains(t, md.Check(), finding.Finding{Subject: docsKB, Check: CheckDimension,
+		Detail: `amazon.titan-embed-text-v2:0 produces 1024-dimensional vectors, but opensearch:docs-index is 512-dimensional in environments/app:null_resource.index`})
+}
+
+func TestParseMapping(t *testing.T) {
+	t.Parallel()
+
+	ix, err := ParseMapping("body", map[string]interface{}{
+		"mappings": map[string]interface{}{"properties": map[string]interface{}{
+			"v": map[string]interface{}{"type": "knn_vector", "dimension": float64(20000),
+				"method": map[string]interface{}{"name": "ivf", "engine": "lucene", "space_type": "cosinesimil"}},
+			"w": map[string]interface{}{"type": "knn_vector", "dimension": float64(8),
+				"method": map[string]interface{}{"engine": "annoy"}},
+		}},
+	})
+	require.NoError(t, err)
+	assert.False(t, ix.KNN)
+	assert.Equal(t, []string{"v", "w"}, ix.VectorFields())
+	assert.Equal(t, []string{
+		"settings.index.knn is not true",
+		"v has dimension 20000, not between 1 and 16000",
+		`v uses method "ivf", which lucene does not support`,
+		`w uses unknown engine "annoy"`,
+	}, ix.Problems())
+
+	_, err = ParseMapping("body", map[string]interface{}{"settings": map[string]interface{}{}})
+	assert.EqualError(t, err, "body: index body has no mappings.properties")
+}
+
+func TestModels(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "amazon.titan-embed-text-v2:0", ModelID("arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v2:0"))
+	assert.Equal(t, "cohere.embed-v4:0", ModelID("cohere.embed-v4:0"))
+	em, ok := LookupModel("arn:aws:bedrock:ap-northeast-2::foundation-model/amazon.titan-embed-text-v2:0")
+	require.True(t, ok)
+	assert.Equal(t, 1024, em.Default())
+	assert.True(t, em.Supports(256))
+	assert.False(t, em.Supports(1536))
+	_, ok = LookupModel("amazon.titan-text-express-v1")
+	assert.False(t, ok)
+}
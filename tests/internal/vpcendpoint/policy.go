@@ -0,0 +1,103 @@
+package vpcendpoint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// RoleARN returns the ARN of the workload's role. The account ID is never
+// known statically and is left as tfconfig.Unknown.
+func (w *Workload) RoleARN() string {
+	name := tfconfig.Unknown
+	if b := w.Role.Block(); b != nil {
+		if s, ok := b.Module().PartialString(b.Expr("name")); ok {
+			name = s
+		}
+	}
+	return "arn:aws:iam::" + tfconfig.Unknown + ":role/" + name
+}
+
+// Request builds the request a workload makes for an action on a resource.
+func (w *Workload) Request(action, resource string) iampolicy.Request {
+	return iampolicy.Request{
+		Principal: w.RoleARN(),
+		Action:    action,
+		Resource:  resource,
+		Context:   map[string]string{"aws:PrincipalAccount": tfconfig.Unknown},
+	}
+}
+
+// BucketPolicy returns the aws_s3_bucket_policy of the bucket with the given
+// name, or nil when the bucket has none.
+func (inv *Inventory) BucketPolicy(bucket string) (*iampolicy.Document, error) {
+	for _, m := range inv.Tree.Modules {
+		for _, b := range instantiated(m.Resources("aws_s3_bucket_policy")) {
+			if !inv.namesBucket(m, b, bucket) {
+				continue
+			}
+			return iampolicy.FromExpr(inv.Refs, m, nodeID(m, b), b.Expr("policy"))
+		}
+	}
+	return nil, nil
+}
+
+func (inv *Inventory) namesBucket(m *tfconfig.Module, b *tfconfig.Block, bucket string) bool {
+	if s, ok := b.String("bucket"); ok {
+		return s == bucket
+	}
+	for _, n := range inv.Refs.ResolveExpr(m, b.Expr("bucket")) {
+		if bb := n.Block(); bb.Kind() == "aws_s3_bucket" {
+			if s, ok := bb.Module().PartialString(bb.Expr("bucket")); ok && iampolicy.Wildcard(s, bucket) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bucketOf returns the bucket name of an S3 ARN, or "" for other ARNs.
+func bucketOf(arn string) string {
+	if !strings.HasPrefix(arn, "arn:aws:s3:::") {
+		return ""
+	}
+	name := strings.TrimPrefix(arn, "arn:aws:s3:::")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// Evaluate evaluates an action of a workload on a resource through an
+// endpoint, combining the workload's identity policies, the endpoint policy
+// and, for S3 objects and buckets, the bucket policy. It does not check that
+// the workload can reach the endpoint; see Coverage for that.
+func (inv *Inventory) Evaluate(w *Workload, ep *Endpoint, action, resource string) (iampolicy.Decision, error) {
+	var resourcePolicy *iampolicy.Document
+	if bucket := bucketOf(resource); bucket != "" {
+		doc, err := inv.BucketPolicy(bucket)
+		if err != nil {
+			return iampolicy.Decision{}, fmt.Errorf("%s: %v", resource, err)
+		}
+		resourcePolicy = doc
+	}
+	return iampolicy.EvaluateThrough(w.Request(action, resource), w.Policies, ep.Policy, resourcePolicy), nil
+}
+
+// Endpoint returns the endpoint with the given ID, or nil.
+func (inv *Inventory) Endpoint(id string) *Endpoint {
+	for _, ep := range inv.Endpoints {
+		if ep.ID == id {
+			return ep
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the endpoint policy alone allows a request. An
+// endpoint without a policy allows everything.
+func (ep *Endpoint) Allows(req iampolicy.Request) bool {
+	return ep.Policy == nil || ep.Policy.Evaluate(req) == iampolicy.Allow
+}
@@ -0,0 +1,378 @@
+// Package vpcendpoint inventories the VPC endpoints and the VPC-attached
+// workloads (Lambda functions and EC2 instances) of a Terraform tree, and
+// checks that every AWS API a workload's role allows it to call has a way
+// out of its VPC: an endpoint, a route to the internet, or a proxy.
+package vpcendpoint
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// Endpoint is an aws_vpc_endpoint or aws_opensearchserverless_vpc_endpoint.
+type Endpoint struct {
+	ID          string
+	Service     string // service part of the service name, e.g. "bedrock-runtime", "s3", "aoss"
+	Type        string // "Interface", "Gateway" or "GatewayLoadBalancer"
+	VPC         *routing.VPC
+	RouteTables []*routing.RouteTable // gateway endpoints only
+	Policy      *iampolicy.Document   // nil when the endpoint has no policy (full access)
+	Block       *tfconfig.Block
+}
+
+// Workload is a Lambda function or EC2 instance running in a VPC.
+type Workload struct {
+	ID       string
+	VPC      *routing.VPC
+	Role     tfconfig.Node
+	Policies []*iampolicy.Document
+	Proxy    bool // HTTPS_PROXY is set in the function environment
+	Block    *tfconfig.Block
+}
+
+// Kind returns "lambda" or "instance".
+func (w *Workload) Kind() string {
+	if w.Block.Kind() == "aws_lambda_function" {
+		return "lambda"
+	}
+	return "instance"
+}
+
+// Inventory holds the endpoints and workloads of a tree.
+type Inventory struct {
+	Endpoints []*Endpoint
+	Workloads []*Workload
+
+	// Unresolved lists workloads and endpoints whose VPC, role or policies
+	// could not be resolved statically.
+	Unresolved []string
+
+	Net  *routing.Network
+	Tree *tfconfig.Tree
+	Refs *tfconfig.Graph
+}
+
+// Build collects endpoints and workloads from every module in the tree.
+func Build(tree *tfconfig.Tree, network *routing.Network) *Inventory {
+	inv := &Inventory{Net: network, Tree: tree, Refs: tree.RefGraph()}
+	for _, m := range tree.Modules {
+		inv.collectEndpoints(m)
+	}
+	for _, m := range tree.Modules {
+		inv.collectWorkloads(m)
+	}
+	sort.Strings(inv.Unresolved)
+	return inv
+}
+
+func (inv *Inventory) collectEndpoints(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_vpc_endpoint")) {
+		id := nodeID(m, b)
+		ep := &Endpoint{ID: id, Type: "Gateway", Block: b, VPC: inv.Net.VPCOf(m, b.Expr("vpc_id"))}
+		if t, ok := b.String("vpc_endpoint_type"); ok {
+			ep.Type = t
+		}
+		if name, ok := m.PartialString(b.Expr("service_name")); ok {
+			ep.Service = ServiceOf(name)
+		}
+		if ep.Type == "Gateway" {
+			ep.RouteTables = inv.Net.RouteTablesOf(m, b.Expr("route_table_ids"))
+		}
+		if b.Has("policy") {
+			doc, err := iampolicy.FromExpr(inv.Refs, m, id, b.Expr("policy"))
+			if err != nil {
+				inv.Unresolved = append(inv.Unresolved, err.Error())
+			}
+			ep.Policy = doc
+		}
+		if ep.VPC == nil || ep.Service == "" {
+			inv.Unresolved = append(inv.Unresolved, id+": vpc_id/service_name")
+		}
+		inv.Endpoints = append(inv.Endpoints, ep)
+	}
+	for _, b := range instantiated(m.Resources("aws_opensearchserverless_vpc_endpoint")) {
+		id := nodeID(m, b)
+		ep := &Endpoint{ID: id, Service: "aoss", Type: "Interface", Block: b, VPC: inv.Net.VPCOf(m, b.Expr("vpc_id"))}
+		if ep.VPC == nil {
+			inv.Unresolved = append(inv.Unresolved, id+": vpc_id")
+		}
+		inv.Endpoints = append(inv.Endpoints, ep)
+	}
+}
+
+func (inv *Inventory) collectWorkloads(m *tfconfig.Module) {
+	for _, b := range instantiated(m.Resources("aws_lambda_function")) {
+		vpcConfig := b.Nested("vpc_config")
+		if len(vpcConfig) == 0 {
+			continue
+		}
+		w := &Workload{ID: nodeID(m, b), Block: b, VPC: inv.Net.VPCOf(m, vpcConfig[0].Expr("subnet_ids"))}
+		for _, env := range b.Nested("environment") {
+			if vars, ok := env.Partial("variables").(map[string]interface{}); ok {
+				_, upper := vars["HTTPS_PROXY"]
+				_, lower := vars["https_proxy"]
+				w.Proxy = upper || lower
+			}
+		}
+		inv.addWorkload(w, m, b.Expr("role"))
+	}
+	for _, b := range instantiated(m.Resources("aws_instance")) {
+		w := &Workload{ID: nodeID(m, b), Block: b}
+		var roleModule *tfconfig.Module
+		var roleExpr hclsyntax.Expression
+		for _, src := range inv.instanceSettings(b) {
+			if w.VPC == nil {
+				w.VPC = inv.Net.VPCOf(src.Module(), src.Expr("subnet_id"))
+				for _, eni := range src.Nested("network_interfaces") {
+					if w.VPC == nil {
+						w.VPC = inv.Net.VPCOf(src.Module(), eni.Expr("subnet_id"))
+					}
+				}
+			}
+			profile := src.Expr("iam_instance_profile")
+			for _, nb := range src.Nested("iam_instance_profile") {
+				if profile = nb.Expr("name"); profile == nil {
+					profile = nb.Expr("arn")
+				}
+			}
+			for _, n := range inv.Refs.ResolveExpr(src.Module(), profile) {
+				if pb := n.Block(); roleExpr == nil && pb.Kind() == "aws_iam_instance_profile" {
+					roleModule, roleExpr = pb.Module(), pb.Expr("role")
+				}
+			}
+		}
+		inv.addWorkload(w, roleModule, roleExpr)
+	}
+}
+
+// instanceSettings returns the blocks an instance takes its subnet and
+// instance profile from: the instance itself, then its launch template.
+func (inv *Inventory) instanceSettings(b *tfconfig.Block) []*tfconfig.Block {
+	out := []*tfconfig.Block{b}
+	for _, lt := range b.Nested("launch_template") {
+		for _, n := range inv.Refs.ResolveExpr(b.Module(), lt.Expr("id")) {
+			if tb := n.Block(); tb.Kind() == "aws_launch_template" {
+				out = append(out, tb)
+			}
+		}
+	}
+	return out
+}
+
+func (inv *Inventory) addWorkload(w *Workload, m *tfconfig.Module, roleExpr hclsyntax.Expression) {
+	if w.VPC == nil {
+		inv.Unresolved = append(inv.Unresolved, w.ID+": subnets do not resolve to a VPC")
+	}
+	if roleExpr != nil {
+		for _, n := range inv.Refs.ResolveExpr(m, roleExpr) {
+			if n.Kind() == "aws_iam_role" {
+				w.Role = n
+			}
+		}
+	}
+	if w.Role.Module == nil {
+		inv.Unresolved = append(inv.Unresolved, w.ID+": role does not resolve to an aws_iam_role")
+	} else {
+		var errs []error
+		w.Policies, errs = iampolicy.RolePolicies(inv.Tree, inv.Refs, w.Role)
+		for _, err := range errs {
+			inv.Unresolved = append(inv.Unresolved, fmt.Sprintf("%s: %v", w.ID, err))
+		}
+	}
+	inv.Workloads = append(inv.Workloads, w)
+}
+
+// ServiceOf returns the service part of an endpoint service name:
+// "com.amazonaws.us-east-1.bedrock-runtime" -> "bedrock-runtime".
+func ServiceOf(serviceName string) string {
+	parts := strings.SplitN(serviceName, ".", 4)
+	if len(parts) == 4 {
+		return parts[3]
+	}
+	return serviceName
+}
+
+// EndpointService returns the endpoint service an IAM action is called
+// through, or "" for actions whose data plane is inside the VPC (Neptune)
+// or that are performed by AWS on the workload's behalf.
+func EndpointService(action string) string {
+	prefix := iampolicy.ServicePrefix(action)
+	name := strings.ToLower(action[strings.Index(action, ":")+1:])
+	switch prefix {
+	case "bedrock":
+		switch {
+		case strings.HasPrefix(name, "invokemodel"), strings.HasPrefix(name, "converse"),
+			name == "applyguardrail", name == "counttokens":
+			return "bedrock-runtime"
+		case strings.HasPrefix(name, "retrieve"), name == "invokeagent", name == "invokeflow",
+			name == "invokeinlineagent", name == "rerank":
+			return "bedrock-agent-runtime"
+		case strings.Contains(name, "ingestionjob"), strings.Contains(name, "knowledgebase"),
+			strings.Contains(name, "datasource"), strings.Contains(name, "agent"):
+			return "bedrock-agent"
+		}
+		return "bedrock"
+	case "neptune-db":
+		return ""
+	case "cloudwatch":
+		return "monitoring"
+	case "firehose":
+		return "kinesis-firehose"
+	case "kinesis":
+		return "kinesis-streams"
+	case "ecr":
+		return "ecr.api"
+	case "es":
+		return "es"
+	}
+	return prefix
+}
+
+// serviceSideActions are granted to the workload role but called by AWS on
+// its behalf, outside the VPC: Lambda writes function logs and manages the
+// function's network interfaces itself.
+var serviceSideActions = map[string][]string{
+	"lambda": {
+		"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents",
+		"ec2:CreateNetworkInterface", "ec2:DescribeNetworkInterfaces", "ec2:DescribeSubnets",
+		"ec2:DeleteNetworkInterface", "ec2:AssignPrivateIpAddresses", "ec2:UnassignPrivateIpAddresses",
+		"xray:PutTraceSegments", "xray:PutTelemetryRecords",
+		"sqs:ReceiveMessage", "sqs:DeleteMessage", "sqs:GetQueueAttributes", "sqs:ChangeMessageVisibility",
+		"kms:Decrypt",
+	},
+}
+
+// Calls returns the actions a workload's role allows it to call, grouped by
+// endpoint service, excluding actions AWS performs on its behalf.
+func (w *Workload) Calls() map[string][]string {
+	calls := map[string][]string{}
+	for _, action := range iampolicy.AllowedActions(w.Policies) {
+		if action == "*" || serviceSide(w.Kind(), action) {
+			continue
+		}
+		if svc := EndpointService(action); svc != "" {
+			calls[svc] = append(calls[svc], action)
+		}
+	}
+	return calls
+}
+
+func serviceSide(kind, action string) bool {
+	for _, a := range serviceSideActions[kind] {
+		if strings.EqualFold(a, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finding is a service call with no way out of the workload's VPC.
+type Finding struct {
+	Workload string
+	Service  string
+	Actions  []string
+}
+
+// Key identifies a finding in the tree, e.g.
+// "environments/app-layer:aws_lambda_function.document_processor s3".
+func (f Finding) Key() string {
+	return f.Workload + " " + f.Service
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s calls %s (%s) with no VPC endpoint, internet route or proxy",
+		f.Workload, f.Service, strings.Join(f.Actions, ", "))
+}
+
+// internetProbe is a public address used to test whether a VPC's route
+// tables reach the internet.
+var internetProbe = net.ParseIP("52.94.0.10")
+
+// EndpointsFor returns the endpoints of a service that a workload can use:
+// interface endpoints in its VPC, and gateway endpoints associated with one
+// of its VPC's route tables.
+func (inv *Inventory) EndpointsFor(w *Workload, service string) []*Endpoint {
+	var out []*Endpoint
+	for _, ep := range inv.Endpoints {
+		if ep.Service != service || ep.VPC == nil || ep.VPC != w.VPC {
+			continue
+		}
+		if ep.Type == "Gateway" && len(ep.RouteTables) == 0 {
+			continue
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+// HasInternetRoute reports whether any route table of the workload's VPC
+// that is associated with subnets, or any table when none is associated in
+// the configuration, routes to the internet through a NAT or internet
+// gateway.
+func (inv *Inventory) HasInternetRoute(w *Workload) bool {
+	if w.VPC == nil {
+		return false
+	}
+	var tables []*routing.RouteTable
+	for _, rt := range w.VPC.RouteTables {
+		if rt.Associated {
+			tables = append(tables, rt)
+		}
+	}
+	if len(tables) == 0 {
+		tables = w.VPC.RouteTables
+	}
+	for _, rt := range tables {
+		if inv.Net.Trace(rt, internetProbe).Delivered == "internet" {
+			return true
+		}
+	}
+	return false
+}
+
+// Coverage reports, for every workload with a resolved VPC, the services it
+// calls that have no endpoint in its VPC while the VPC has no internet
+// route and the workload no proxy.
+func (inv *Inventory) Coverage() []Finding {
+	var findings []Finding
+	for _, w := range inv.Workloads {
+		if w.VPC == nil || w.Proxy || inv.HasInternetRoute(w) {
+			continue
+		}
+		calls := w.Calls()
+		services := make([]string, 0, len(calls))
+		for svc := range calls {
+			services = append(services, svc)
+		}
+		sort.Strings(services)
+		for _, svc := range services {
+			if len(inv.EndpointsFor(w, svc)) == 0 {
+				findings = append(findings, Finding{Workload: w.ID, Service: svc, Actions: calls[svc]})
+			}
+		}
+	}
+	return findings
+}
+
+func nodeID(m *tfconfig.Module, b *tfconfig.Block) string {
+	return tfconfig.Node{Module: m, Addr: b.Address()}.String()
+}
+
+func instantiated(blocks []*tfconfig.Block) []*tfconfig.Block {
+	var out []*tfconfig.Block
+	for _, b := range blocks {
+		if c, ok := b.Count(); ok && c == 0 {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,288 @@
+package vpcendpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// sampleWorkloads has a closed VPC with a Bedrock runtime endpoint and an S3
+// gateway endpoint, and a VPC with a NAT gateway. The closed VPC runs a
+// Lambda that also calls DynamoDB, a Lambda that goes through a proxy, and
+// an instance launched from a template whose role uses SSM. The docs bucket
+// policy denies reads under private/.
+const sampleWorkloads = `
+resource "aws_vpc" "closed" {
+  cidr_block = "10.1.0.0/16"
+}
+
+resource "aws_subnet" "closed" {
+  vpc_id     = aws_vpc.closed.id
+  cidr_block = "10.1.1.0/24"
+}
+
+resource "aws_route_table" "closed" {
+  vpc_id = aws_vpc.closed.id
+}
+
+resource "aws_route_table_association" "closed" {
+  subnet_id      = aws_subnet.closed.id
+  route_table_id = aws_route_table.closed.id
+}
+
+resource "aws_vpc" "open" {
+  cidr_block = "10.2.0.0/16"
+}
+
+resource "aws_subnet" "open" {
+  vpc_id     = aws_vpc.open.id
+  cidr_block = "10.2.1.0/24"
+}
+
+resource "aws_nat_gateway" "open" {
+  subnet_id = aws_subnet.open.id
+}
+
+resource "aws_route_table" "open" {
+  vpc_id = aws_vpc.open.id
+
+  route {
+    cidr_block     = "0.0.0.0/0"
+    nat_gateway_id = aws_nat_gateway.open.id
+  }
+}
+
+resource "aws_route_table_association" "open" {
+  subnet_id      = aws_subnet.open.id
+  route_table_id = aws_route_table.open.id
+}
+
+resource "aws_vpc_endpoint" "bedrock_runtime" {
+  vpc_id            = aws_vpc.closed.id
+  service_name      = "com.amazonaws.us-east-1.bedrock-runtime"
+  vpc_endpoint_type = "Interface"
+}
+
+resource "aws_vpc_endpoint" "s3" {
+  vpc_id          = aws_vpc.closed.id
+  service_name    = "com.amazonaws.us-east-1.s3"
+  route_table_ids = [aws_route_table.closed.id]
+  policy = jsonencode({
+    Statement = [{ Effect = "Allow", Principal = "*", Action = "s3:GetObject", Resource = "*" }]
+  })
+}
+
+resource "aws_iam_role" "app" {
+  name                = "app"
+  managed_policy_arns = ["arn:aws:iam::aws:policy/service-role/AWSLambdaVPCAccessExecutionRole"]
+
+  inline_policy {
+    name = "app"
+    policy = jsonencode({
+      Statement = [{
+        Effect   = "Allow"
+        Action   = ["bedrock:InvokeModel", "bedrock:Retrieve", "s3:GetObject", "dynamodb:PutItem", "neptune-db:ReadDataViaQuery"]
+        Resource = "*"
+      }]
+    })
+  }
+}
+
+resource "aws_lambda_function" "closed" {
+  function_name = "closed"
+  role          = aws_iam_role.app.arn
+
+  vpc_config {
+    subnet_ids         = [aws_subnet.closed.id]
+    security_group_ids = []
+  }
+}
+
+resource "aws_lambda_function" "proxied" {
+  function_name = "proxied"
+  role          = aws_iam_role.app.arn
+
+  vpc_config {
+    subnet_ids         = [aws_subnet.closed.id]
+    security_group_ids = []
+  }
+
+  environment {
+    variables = {
+      HTTPS_PROXY = "http://proxy.internal:3128"
+    }
+  }
+}
+
+resource "aws_lambda_function" "open" {
+  function_name = "open"
+  role          = aws_iam_role.app.arn
+
+  vpc_config {
+    subnet_ids         = [aws_subnet.open.id]
+    security_group_ids = []
+  }
+}
+
+resource "aws_lambda_function" "outside" {
+  function_name = "outside"
+  role          = aws_iam_role.app.arn
+}
+
+resource "aws_iam_role" "ssm" {
+  name                = "ssm"
+  managed_policy_arns = ["arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"]
+}
+
+resource "aws_iam_instance_profile" "ssm" {
+  role = aws_iam_role.ssm.name
+}
+
+resource "aws_launch_template" "ssm" {
+  iam_instance_profile {
+    name = aws_iam_instance_profile.ssm.name
+  }
+
+  network_interfaces {
+    subnet_id = aws_subnet.closed.id
+  }
+}
+
+resource "aws_instance" "ssm" {
+  launch_template {
+    id = aws_launch_template.ssm.id
+  }
+}
+
+resource "aws_s3_bucket" "docs" {
+  bucket = "${var.project}-docs"
+}
+
+variable "project" {
+  default = "proj"
+}
+
+resource "aws_s3_bucket_policy" "docs" {
+  bucket = aws_s3_bucket.docs.id
+  policy = jsonencode({
+    Statement = [{
+      Effect    = "Deny"
+      Principal = "*"
+      Action    = "s3:GetObject"
+      Resource  = "${aws_s3_bucket.docs.arn}/private/*"
+    }]
+  })
+}
+`
+
+func loadSample(t *testing.T) *Inventory {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "environments", "app")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(sampleWorkloads), 0o644))
+
+	tree, err := tfconfig.LoadTree(root)
+	require.NoError(t, err)
+	n, err := routing.Build(tree, routing.Options{})
+	require.NoError(t, err)
+	inv := Build(tree, n)
+	require.Empty(t, inv.Unresolved)
+	return inv
+}
+
+func TestBuild_ResolvesEndpointsAndWorkloads(t *testing.T) {
+	t.Parallel()
+
+	inv := loadSample(t)
+	require.Len(t, inv.Endpoints, 2)
+	for _, ep := range inv.Endpoints {
+		assert.Equal(t, "environments/app:aws_vpc.closed", ep.VPC.ID)
+	}
+	s3 := inv.Endpoints[1]
+	assert.Equal(t, "s3", s3.Service)
+	assert.Equal(t, "Gateway", s3.Type)
+	assert.Len(t, s3.RouteTables, 1)
+	require.NotNil(t, s3.Policy)
+
+	require.Len(t, inv.Workloads, 4, "the Lambda without vpc_config is not a VPC workload")
+	ssm := inv.Workloads[3]
+	assert.Equal(t, "instance", ssm.Kind())
+	assert.Equal(t, "environments/app:aws_vpc.closed", ssm.VPC.ID, "subnet comes from the launch template")
+	assert.Equal(t, "aws_iam_role.ssm", ssm.Role.Addr)
+}
+
+func TestCoverage_ReportsCallsWithNoWayOut(t *testing.T) {
+	t.Parallel()
+
+	inv := loadSample(t)
+	var got []string
+	for _, f := range inv.Coverage() {
+		got = append(got, f.Workload+" "+f.Service)
+	}
+	// bedrock-runtime and s3 have endpoints; neptune-db stays in the VPC; the
+	// VPC access policy's logs and ec2 actions are made by Lambda itself.
+	assert.Equal(t, []string{
+		"environments/app:aws_lambda_function.closed bedrock-agent-runtime",
+		"environments/app:aws_lambda_function.closed dynamodb",
+		"environments/app:aws_instance.ssm ec2messages",
+		"environments/app:aws_instance.ssm ssm",
+		"environments/app:aws_instance.ssm ssmmessages",
+	}, got)
+}
+
+func TestEndpointService(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"bedrock:InvokeModelWithResponseStream": "bedrock-runtime",
+		"bedrock:Converse":                      "bedrock-runtime",
+		"bedrock:Retrieve":                      "bedrock-agent-runtime",
+		"bedrock:RetrieveAndGenerate":           "bedrock-agent-runtime",
+		"bedrock:StartIngestionJob":             "bedrock-agent",
+		"bedrock:ListFoundationModels":          "bedrock",
+		"neptune-db:connect":                    "",
+		"cloudwatch:PutMetricData":              "monitoring",
+		"aoss:APIAccessAll":                     "aoss",
+		"states:StartExecution":                 "states",
+	}
+	for action, want := range testCases {
+		assert.Equal(t, want, EndpointService(action), action)
+	}
+	assert.Equal(t, "bedrock-runtime", ServiceOf("com.amazonaws.us-east-1.bedrock-runtime"))
+	assert.Equal(t, "ecr.api", ServiceOf("com.amazonaws.ap-northeast-2.ecr.api"))
+}
+
+func TestEvaluate_AppliesEndpointAndBucketPolicies(t *testing.T) {
+	t.Parallel()
+
+	inv := loadSample(t)
+	closed := inv.Workloads[0]
+	s3 := inv.Endpoint("environments/app:aws_vpc_endpoint.s3")
+	require.NotNil(t, s3)
+
+	testCases := []struct {
+		action, resource, want string
+	}{
+		{"s3:GetObject", "arn:aws:s3:::proj-docs/a.pdf", "allowed"},
+		{"s3:GetObject", "arn:aws:s3:::proj-docs/private/a.pdf", "explicitly denied by the resource policy"},
+		{"s3:PutObject", "arn:aws:s3:::proj-docs/a.pdf", "not allowed by the endpoint policy"},
+	}
+	for _, tc := range testCases {
+		d, err := inv.Evaluate(closed, s3, tc.action, tc.resource)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, d.String(), "%s %s", tc.action, tc.resource)
+	}
+
+	fullAccess := &Endpoint{ID: "no policy", Service: "dynamodb"}
+	d, err := inv.Evaluate(closed, fullAccess, "dynamodb:PutItem", "arn:aws:dynamodb:us-east-1:111122223333:table/t")
+	require.NoError(t, err)
+	assert.True(t, d.Allowed(), "an endpoint without a policy allows what the role allows")
+	assert.Equal(t, "arn:aws:iam::"+tfconfig.Unknown+":role/app", closed.RoleARN())
+}
@@ -0,0 +1,260 @@
+package properties
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/apigw"
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vpcendpoint"
+)
+
+const (
+	privateRAGAPI            = "environments/app-layer/bedrock-rag:aws_api_gateway_rest_api.private_rag"
+	frontendExecuteAPIEP     = "environments/network-layer:aws_vpc_endpoint.execute_api"
+	frontendExecuteAPISymbol = "${local.frontend_execute_api_endpoint_id}"
+
+	privateReadRoute  = "read-only route of the private API: reachable only through the Frontend execute-api endpoint (aws:sourceVpce policy), i.e. from on-premises over the VPN"
+	privateWriteRoute = "mutating route of the private API: no per-user identity yet, access rests on the Frontend execute-api endpoint and aws:sourceVpce policy; revisit when an authorizer is added"
+	mcpProxyRoute     = "MCP clients connect without credentials; the proxy is reachable only through the Frontend execute-api endpoint"
+
+	nginxInstance = "environments/app-layer/bedrock-rag:aws_instance.nginx"
+
+	// checkUnauthenticated is the check of methods anyone who reaches the
+	// API can call.
+	checkUnauthenticated = "unauthenticated"
+
+	mcpDirectToInstance  = "the MCP proxy calls the MCP server instance's private IP directly instead of going through nginx; move it behind a VPC link and NLB"
+	mcpTriggerMissing    = "the MCP proxy method was added after the main deployment's triggers were written; add it so method changes redeploy"
+	llmGatewayDeployment = "llm_gateway only redeploys on MCP changes and no stage points at it; stage prod follows main, which tracks the /rag routes"
+	stageSettingsBacklog = "stage prod has no access logging, X-Ray or */* throttling yet; tracked as API Gateway stage hardening"
+)
+
+// knownUnauthenticatedRoutes waives methods with authorization NONE and no
+// API key, keyed by Finding.Key().
+var knownUnauthenticatedRoutes = map[string]string{
+	privateRAGAPI + " ANY /mcp/{proxy+} " + checkUnauthenticated:                 mcpProxyRoute,
+	privateRAGAPI + " GET /rag/categories " + checkUnauthenticated:               privateReadRoute,
+	privateRAGAPI + " GET /rag/documents " + checkUnauthenticated:                privateReadRoute,
+	privateRAGAPI + " GET /rag/documents/extract-status " + checkUnauthenticated: privateReadRoute,
+	privateRAGAPI + " GET /rag/health " + checkUnauthenticated:                   privateReadRoute,
+	privateRAGAPI + " GET /rag/upload " + checkUnauthenticated:                   privateReadRoute,
+	privateRAGAPI + " POST /rag/claims " + checkUnauthenticated:                  privateWriteRoute,
+	privateRAGAPI + " POST /rag/claims/approve " + checkUnauthenticated:          privateWriteRoute,
+	privateRAGAPI + " POST /rag/claims/reject " + checkUnauthenticated:           privateWriteRoute,
+	privateRAGAPI + " POST /rag/claims/update-status " + checkUnauthenticated:    privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/complete " + checkUnauthenticated:      privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/confirm " + checkUnauthenticated:       privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/delete " + checkUnauthenticated:        privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/extract " + checkUnauthenticated:       privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/initiate " + checkUnauthenticated:      privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/presign " + checkUnauthenticated:       privateWriteRoute,
+	privateRAGAPI + " POST /rag/documents/upload-part " + checkUnauthenticated:   privateWriteRoute,
+	privateRAGAPI + " POST /rag/find-clock-crossings " + checkUnauthenticated:    privateReadRoute,
+	privateRAGAPI + " POST /rag/find-instantiation-tree " + checkUnauthenticated: privateReadRoute,
+	privateRAGAPI + " POST /rag/generate-hdd " + checkUnauthenticated:            privateWriteRoute,
+	privateRAGAPI + " POST /rag/get-evidence " + checkUnauthenticated:            privateReadRoute,
+	privateRAGAPI + " POST /rag/graph-export " + checkUnauthenticated:            privateReadRoute,
+	privateRAGAPI + " POST /rag/hdd/regenerate-stale " + checkUnauthenticated:    privateWriteRoute,
+	privateRAGAPI + " POST /rag/list-verified-claims " + checkUnauthenticated:    privateReadRoute,
+	privateRAGAPI + " POST /rag/publish-markdown " + checkUnauthenticated:        privateWriteRoute,
+	privateRAGAPI + " POST /rag/query " + checkUnauthenticated:                   privateReadRoute,
+	privateRAGAPI + " POST /rag/search-archive " + checkUnauthenticated:          privateReadRoute,
+	privateRAGAPI + " POST /rag/trace-signal-path " + checkUnauthenticated:       privateReadRoute,
+}
+
+// knownIntegrationFindings waives consistency findings, keyed by
+// Finding.Key().
+var knownIntegrationFindings = map[string]string{
+	privateRAGAPI + " ANY /mcp/{proxy+} connection-type":                        mcpDirectToInstance,
+	privateRAGAPI + " ANY /mcp/{proxy+} http-target":                            mcpDirectToInstance,
+	privateRAGAPI + " aws_api_gateway_deployment.llm_gateway redeploy-triggers": llmGatewayDeployment,
+	privateRAGAPI + " aws_api_gateway_deployment.main redeploy-triggers":        mcpTriggerMissing,
+	privateRAGAPI + " aws_api_gateway_stage.prod access-logs":                   stageSettingsBacklog,
+	privateRAGAPI + " aws_api_gateway_stage.prod throttling":                    stageSettingsBacklog,
+	privateRAGAPI + " aws_api_gateway_stage.prod xray":                          stageSettingsBacklog,
+}
+
+func loadAPIInventory(t *testing.T) *apigw.Inventory {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	inv := apigw.Build(tree)
+	require.Empty(t, inv.Unresolved, "Every API Gateway resource, method and integration should attach to its API")
+	return inv
+}
+
+func privateRAG(t *testing.T, inv *apigw.Inventory) *apigw.API {
+	t.Helper()
+	for _, api := range inv.APIs {
+		if api.ID == privateRAGAPI {
+			return api
+		}
+	}
+	require.Failf(t, "API not found", "%s", privateRAGAPI)
+	return nil
+}
+
+// TestAPIGateway_RouteInventory verifies that the REST API tree rebuilds
+// from api-gateway.tf and llm-gateway-apigw.tf with every method backed by
+// an integration.
+func TestAPIGateway_RouteInventory(t *testing.T) {
+	t.Parallel()
+
+	inv := loadAPIInventory(t)
+	require.Len(t, inv.APIs, 1)
+	api := privateRAG(t, inv)
+
+	assert.Len(t, api.Resources, 31)
+	assert.Len(t, api.Methods, 28)
+	assert.Empty(t, api.Root.Methods, "Nothing should be served on /")
+
+	for _, m := range api.Methods {
+		m := m
+		t.Run(m.Route(), func(t *testing.T) {
+			require.NotNil(t, m.Integration, "Method should have an integration")
+			if strings.HasPrefix(m.Resource.Path, "/rag/") {
+				assert.Equal(t, "AWS_PROXY", m.Integration.Type)
+				assert.Equal(t, []string{"aws_lambda_function.document_processor"}, m.Integration.Targets)
+			}
+		})
+	}
+}
+
+// TestAPIGateway_PrivateEndpointPolicy verifies that the API is private to
+// the Frontend execute-api endpoint and that its resource policy lets every
+// route be called through that endpoint and no other.
+func TestAPIGateway_PrivateEndpointPolicy(t *testing.T) {
+	t.Parallel()
+
+	api := privateRAG(t, loadAPIInventory(t))
+	require.True(t, api.Private())
+	assert.Equal(t, []string{frontendExecuteAPISymbol}, api.EndpointIDs)
+
+	require.Len(t, api.Endpoints, 1)
+	assert.Equal(t, frontendExecuteAPIEP, api.Endpoints[0].String())
+	ep := api.Endpoints[0].Block()
+	name, ok := ep.Module().PartialString(ep.Expr("service_name"))
+	require.True(t, ok)
+	assert.Equal(t, "execute-api", vpcendpoint.ServiceOf(name))
+
+	require.NotNil(t, api.Policy, "Private API should have a resource policy")
+	assert.Empty(t, api.CheckPolicy())
+}
+
+// TestAPIGateway_OpenAPIExport verifies that the API exports as a valid
+// OpenAPI 3 document. Set APIGW_OPENAPI_DIR to write it to <dir>/<name>.json.
+func TestAPIGateway_OpenAPIExport(t *testing.T) {
+	t.Parallel()
+
+	api := privateRAG(t, loadAPIInventory(t))
+	doc := api.OpenAPI()
+	require.NoError(t, doc.Validate())
+
+	assert.Len(t, doc.Paths, 31, "Every resource should be a path")
+	ops := 0
+	for _, item := range doc.Paths {
+		ops += len(item)
+	}
+	assert.Equal(t, 28, ops, "Every method should be an operation")
+	require.NotNil(t, doc.Endpoint)
+	assert.Equal(t, []string{frontendExecuteAPISymbol}, doc.Endpoint.VPCEndpointIDs)
+	assert.NotNil(t, doc.Paths["/mcp/{proxy+}"][apigw.AnyMethod])
+
+	if dir := os.Getenv("APIGW_OPENAPI_DIR"); dir != "" {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, api.Block.Name()+".json"), append(data, '\n'), 0o644))
+	}
+}
+
+// TestAPIGateway_UnauthenticatedMethodsAreWaived flags methods that anyone
+// who reaches the API can call, unless they are waived as intentional.
+func TestAPIGateway_UnauthenticatedMethodsAreWaived(t *testing.T) {
+	t.Parallel()
+
+	inv := loadAPIInventory(t)
+
+	var findings []finding.Finding
+	for _, api := range inv.APIs {
+		for _, m := range api.Unauthenticated() {
+			findings = append(findings, finding.Finding{Scope: api.ID, Subject: m.Route(), Check: checkUnauthenticated,
+				Detail: fmt.Sprintf("authorization %s and no API key requirement", m.Authorization)})
+		}
+	}
+	checkWaived(t, findings, knownUnauthenticatedRoutes)
+}
+
+// TestAPIGateway_IntegrationConsistency ties every integration to its
+// target, every deployment to the methods it must redeploy on and every
+// stage to its logging, tracing and throttling, unless the finding is
+// waived. Only the nginx instance is a known private HTTP target.
+func TestAPIGateway_IntegrationConsistency(t *testing.T) {
+	t.Parallel()
+
+	inv := loadAPIInventory(t)
+
+	findings := inv.Consistency(apigw.Options{PrivateTargets: []string{nginxInstance}})
+	checkWaived(t, findings, knownIntegrationFindings)
+}
+
+// TestAPIGateway_LambdaPermissionCoversRoutes verifies that
+// aws_lambda_permission.api_gateway lets the API invoke the document
+// processor for every /rag route on the deployed stage.
+func TestAPIGateway_LambdaPermissionCoversRoutes(t *testing.T) {
+	t.Parallel()
+
+	inv := loadAPIInventory(t)
+	api := privateRAG(t, inv)
+	require.Equal(t, []string{"dev"}, api.StageNames())
+
+	var perm *apigw.Permission
+	for _, p := range inv.Permissions {
+		if p.Block.Module() == api.Block.Module() && p.Principal == "apigateway.amazonaws.com" {
+			require.Nil(t, perm, "API Gateway should have a single Lambda permission")
+			perm = p
+		}
+	}
+	require.NotNil(t, perm)
+
+	for _, m := range api.Methods {
+		if !strings.HasPrefix(m.Resource.Path, "/rag/") {
+			continue
+		}
+		require.Len(t, m.Integration.Backends, 1, m.Route())
+		fn := m.Integration.Backends[0]
+		for _, verb := range m.Verbs() {
+			arn := api.SourceARN("dev", verb, m.Resource.Path)
+			assert.True(t, perm.Covers(fn, api, arn), "%s should be covered", arn)
+		}
+	}
+}
+
+// TestAPIGateway_StageFollowsMainDeployment verifies that stage prod is
+// deployed by the main deployment, that its triggers only miss the MCP
+// proxy method, and that llm_gateway tracks every MCP object.
+func TestAPIGateway_StageFollowsMainDeployment(t *testing.T) {
+	t.Parallel()
+
+	api := privateRAG(t, loadAPIInventory(t))
+	require.Len(t, api.Stages, 1)
+	require.Len(t, api.Deployments, 2)
+	llm, main := api.Deployments[0], api.Deployments[1]
+	require.Equal(t, "aws_api_gateway_deployment.main", main.Block.Address())
+	assert.Same(t, main, api.Stages[0].Deployment)
+
+	assert.Equal(t, []string{"aws_api_gateway_method.mcp_proxy_any"}, main.Untracked(api))
+	for _, addr := range llm.Untracked(api) {
+		assert.NotContains(t, addr, "mcp", "llm_gateway should track the MCP objects")
+	}
+}
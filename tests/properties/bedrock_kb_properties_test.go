@@ -0,0 +1,141 @@
+package properties
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/bedrockkb"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vectorindex"
+)
+
+const (
+	bedrockRAGDataSource = bedrockRAGModule + ":aws_bedrockagent_data_source.s3"
+	virginiaDataSource   = "environments/app-layer:aws_bedrockagent_data_source.virginia_s3"
+
+	// The document Lambda uploads under S3_PREFIX of lambda_src/index.py to
+	// the bucket of its S3_BUCKET_SEOUL variable.
+	uploadBucket = "bos-ai-documents-seoul-v3"
+	uploadPrefix = "documents/"
+
+	moduleReadsWholeBucket = "the module leaves inclusion_prefixes commented out, so it also ingests published/ metadata replicated from Seoul"
+	noDataSourceCMK        = "no server_side_encryption_configuration yet; transient ingestion data uses an AWS owned key"
+	defaultDeletionPolicy  = "data_deletion_policy is left to the provider default"
+)
+
+// knownBedrockKBFindings waives knowledge base findings, keyed by
+// Finding.Key().
+var knownBedrockKBFindings = map[string]string{
+	bedrockRAGDataSource + " inclusion-prefix": moduleReadsWholeBucket,
+	bedrockRAGDataSource + " encryption":       noDataSourceCMK,
+	bedrockRAGDataSource + " deletion-policy":  defaultDeletionPolicy,
+	virginiaDataSource + " encryption":         noDataSourceCMK,
+	virginiaDataSource + " deletion-policy":    defaultDeletionPolicy,
+}
+
+func loadBedrockKB(t *testing.T) (*tfconfig.Tree, *bedrockkb.Model) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := bedrockkb.Build(tree)
+	require.Empty(t, md.Unknown, "Every knowledge base and data source setting should be statically known")
+	md.AddUpload(uploadBucket, uploadPrefix)
+	return tree, md
+}
+
+// TestBedrockKB_DataSources checks that every knowledge base and data source
+// of the tree is found, and that uploads reach the Virginia bucket through
+// replication.
+func TestBedrockKB_DataSources(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadBedrockKB(t)
+	var sources []string
+	for _, kb := range md.KnowledgeBases {
+		for _, ds := range kb.DataSources {
+			sources = append(sources, kb.ID+" "+ds.ID+" "+ds.Bucket)
+		}
+	}
+	assert.Equal(t, []string{
+		bedrockRAGKB + " " + bedrockRAGDataSource + " arn:aws:s3:::bos-ai-documents-us",
+		seoulKB + " " + virginiaDataSource + " arn:aws:s3:::bos-ai-documents-us",
+	}, sources, "the Seoul data source has count = 0 unless enable_seoul_data_source is set")
+
+	var uploads []string
+	for _, u := range md.Uploads("arn:aws:s3:::bos-ai-documents-us") {
+		uploads = append(uploads, u.Prefix+" via "+u.Via)
+	}
+	assert.Equal(t, []string{
+		uploadPrefix + " via environments/app-layer/bedrock-rag:aws_s3_bucket_replication_configuration.seoul_to_virginia",
+	}, uploads)
+}
+
+// TestBedrockKB_Check audits every knowledge base and data source, unless
+// waived.
+func TestBedrockKB_Check(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadBedrockKB(t)
+	checkWaived(t, md.Check(), knownBedrockKBFindings)
+}
+
+// TestBedrockKB_EmbeddingModelFollowsRegionAndInputLimit sets the embedding
+// model of the bedrock-rag module to any model of the table in any of a few
+// regions, and checks that Check reports an embedding-model finding exactly
+// when the region is not the stack's us-east-1, and a chunking finding for
+// the module's data source exactly when its 300-token chunks are longer
+// than the model embeds.
+func TestBedrockKB_EmbeddingModelFollowsRegionAndInputLimit(t *testing.T) {
+	tree, _ := loadBedrockKB(t)
+	var module *tfconfig.Module
+	for _, m := range tree.Modules {
+		if m.Name == bedrockRAGModule {
+			module = m
+		}
+	}
+	require.NotNil(t, module)
+	restoreVars(t, module)
+	var models []string
+	for id := range vectorindex.EmbeddingModels {
+		models = append(models, id)
+	}
+	sort.Strings(models)
+	regions := []string{"us-east-1", "us-west-2", "ap-northeast-2", "eu-central-1"}
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("embedding model findings follow the region and the model table", prop.ForAll(
+		func(model, region int) string {
+			em := vectorindex.EmbeddingModels[models[model]]
+			arn := fmt.Sprintf("arn:aws:bedrock:%s::foundation-model/%s", regions[region], em.ID)
+			setVars(module, map[string]cty.Value{"embedding_model_arn": cty.StringVal(arn)})
+
+			md := bedrockkb.Build(tree)
+			md.AddUpload(uploadBucket, uploadPrefix)
+			regionReported, chunkingReported := false, false
+			for _, f := range md.Check() {
+				regionReported = regionReported || f.Subject == bedrockRAGKB && f.Check == bedrockkb.CheckEmbeddingModel
+				chunkingReported = chunkingReported || f.Subject == bedrockRAGDataSource && f.Check == bedrockkb.CheckChunking
+			}
+			if want := regions[region] != "us-east-1"; want != regionReported {
+				return fmt.Sprintf("%s in %s: embedding-model finding reported: %v", em.ID, regions[region], regionReported)
+			}
+			if want := em.MaxTokens < 300; want != chunkingReported {
+				return fmt.Sprintf("%s embeds %d tokens: chunking finding reported: %v", em.ID, em.MaxTokens, chunkingReported)
+			}
+			return ""
+		},
+		gen.IntRange(0, len(models)-1),
+		gen.IntRange(0, len(regions)-1),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
@@ -0,0 +1,120 @@
+package properties
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// onPremBGPPrefixes are the prefixes the on-premises firewall advertises over
+// the Site-to-Site VPN. Per llm-gateway-routes.tf only the closed network
+// (192.128.1.0/24) is advertised; its NAT subnet needs a static TGW route.
+var onPremBGPPrefixes = []string{"192.128.1.0/24"}
+
+const (
+	frontendRouteTable = "environments/network-layer/module.vpc_frontend:aws_route_table.private"
+	usRouteTable       = "environments/network-layer/module.vpc_us:aws_route_table.private"
+	loggingRouteTable  = "environments/network-layer/module.vpc_logging:aws_route_table.private"
+	transitGateway     = "environments/network-layer:aws_ec2_transit_gateway.main"
+
+	onPremPartlyRouted = "the TGW only routes the advertised and static on-prem prefixes of 192.128.0.0/16"
+)
+
+// knownRoutingFindings are findings present in the current configuration,
+// keyed by Finding.Key().
+var knownRoutingFindings = map[string]string{
+	"blackhole " + frontendRouteTable + " 0.0.0.0/0":      "the TGW has no default route to the Logging VPC NAT",
+	"blackhole " + frontendRouteTable + " 192.128.0.0/16": onPremPartlyRouted,
+	"blackhole " + loggingRouteTable + " 192.128.0.0/16":  onPremPartlyRouted,
+}
+
+func loadRoutingModel(t *testing.T) *routing.Network {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	n, err := routing.Build(tree, routing.Options{OnPremPrefixes: onPremBGPPrefixes})
+	require.NoError(t, err)
+	return n
+}
+
+func routeTable(t *testing.T, n *routing.Network, id string) *routing.RouteTable {
+	t.Helper()
+	for _, rt := range n.RouteTables {
+		if rt.ID == id {
+			return rt
+		}
+	}
+	require.Failf(t, "route table not found", "%s", id)
+	return nil
+}
+
+// TestBidirectionalRouting_RoutesResolve verifies that every route, TGW
+// attachment and static TGW route resolves to a route table, VPC and target.
+func TestBidirectionalRouting_RoutesResolve(t *testing.T) {
+	t.Parallel()
+
+	n := loadRoutingModel(t)
+	assert.Empty(t, n.Unresolved)
+	assert.Len(t, n.Peerings, 1, "Seoul frontend <-> US backend peering")
+	require.Len(t, n.TransitGateways, 1)
+	assert.Len(t, n.TransitGateways[0].Attachments, 3, "Logging VPC, Frontend VPC and the on-prem VPN")
+}
+
+// TestBidirectionalRouting_KnownFindings checks every peering connection, TGW
+// VPC attachment pair and VPN path in both directions.
+func TestBidirectionalRouting_KnownFindings(t *testing.T) {
+	t.Parallel()
+
+	n := loadRoutingModel(t)
+
+	checkWaived(t, n.Analyze(), knownRoutingFindings)
+}
+
+// TestBidirectionalRouting_Paths traces representative flows in both
+// directions, including the Seoul <-> Virginia path used by the QuickSight
+// VPC connection and the return path to the on-prem NAT subnet.
+func TestBidirectionalRouting_Paths(t *testing.T) {
+	t.Parallel()
+
+	n := loadRoutingModel(t)
+
+	testCases := []struct {
+		name      string
+		table     string
+		dst       string
+		delivered string
+		via       routing.Target
+	}{
+		{"Seoul to Virginia", frontendRouteTable, "10.20.1.10", "environments/network-layer/module.vpc_us:aws_vpc.main",
+			routing.Target{Kind: routing.Peering, ID: "environments/network-layer/module.vpc_peering:aws_vpc_peering_connection.main"}},
+		{"Virginia to Seoul", usRouteTable, "10.10.1.10", "environments/network-layer/module.vpc_frontend:aws_vpc.main",
+			routing.Target{Kind: routing.Peering, ID: "environments/network-layer/module.vpc_peering:aws_vpc_peering_connection.main"}},
+		{"Logging to Frontend", loggingRouteTable, "10.10.1.10", "environments/network-layer/module.vpc_frontend:aws_vpc.main",
+			routing.Target{Kind: routing.TGW, ID: transitGateway}},
+		{"Frontend to Logging", frontendRouteTable, "10.200.1.10", "environments/network-layer/module.vpc_logging:aws_vpc.main",
+			routing.Target{Kind: routing.TGW, ID: transitGateway}},
+		{"Frontend to closed network", frontendRouteTable, "192.128.1.208", routing.OnPrem,
+			routing.Target{Kind: routing.TGW, ID: transitGateway}},
+		{"Frontend to on-prem NAT subnet", frontendRouteTable, "192.128.2.254", routing.OnPrem,
+			routing.Target{Kind: routing.TGW, ID: transitGateway}},
+		{"Logging to closed network", loggingRouteTable, "192.128.1.208", routing.OnPrem,
+			routing.Target{Kind: routing.TGW, ID: transitGateway}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := n.Trace(routeTable(t, n, tc.table), net.ParseIP(tc.dst))
+			assert.Equal(t, tc.delivered, p.Delivered, "%s", p)
+			assert.True(t, p.Via(tc.via), "%s should pass through %s", p, tc.via)
+		})
+	}
+}
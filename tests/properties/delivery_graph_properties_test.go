@@ -0,0 +1,153 @@
+package properties
+
+import (
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/pipeline"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	documentsUSNoDestination = "bos-ai-documents-us notifies document_processor from the Virginia account outside this tree; " +
+		"document_processor_async disables retries but has no on_failure destination yet"
+	kiroProcessorNoDestination = "kiro_prompt_processor is a placeholder until the Kiro prompt handler ships; " +
+		"failed events are only visible through the kiro_prompt_error rule"
+
+	parserDLQManualRedrive = "parser DLQs are inspected and redriven by hand with maximum_retry_attempts = 0; " +
+		"the visibility timeout matters once a redrive mapping is added"
+	dlqKeyPending = "DLQ payloads are S3 event metadata without document contents; moving them to the project CMK is tracked with the Seoul key rollout"
+)
+
+// projectKeys are the customer managed keys SQS queues may be encrypted
+// with: the RAG key of the kms module and the Seoul documents key.
+var projectKeys = []string{
+	"environments/app-layer/bedrock-rag/module.kms:aws_kms_key.main",
+	"environments/app-layer/bedrock-rag:aws_kms_key.s3_seoul",
+}
+
+// knownQueueFindings waives queue tuning findings, keyed by Finding.Key().
+var knownQueueFindings = map[string]string{
+	"environments/app-layer/bedrock-rag/module.s3_pipeline:aws_sqs_queue.lambda_dlq encryption": dlqKeyPending,
+	"environments/app-layer/bedrock-rag:aws_sqs_queue.rtl_parser_dlq encryption":                dlqKeyPending,
+	"environments/app-layer/bedrock-rag:aws_sqs_queue.rtl_parser_dlq visibility-timeout":        parserDLQManualRedrive,
+	"environments/app-layer/bedrock-rag:aws_sqs_queue.tool_guide_parser_dlq encryption":         dlqKeyPending,
+	"environments/app-layer/bedrock-rag:aws_sqs_queue.tool_guide_parser_dlq visibility-timeout": parserDLQManualRedrive,
+}
+
+// knownPipelineFindings waives delivery graph findings, keyed by
+// Finding.Key().
+var knownPipelineFindings = map[string]string{
+	"environments/app-layer:aws_lambda_function.document_processor dead-letter":             documentsUSNoDestination,
+	"environments/app-layer/bedrock-rag:aws_lambda_function.document_processor dead-letter": documentsUSNoDestination,
+	"environments/kiro-subscription:aws_lambda_function.kiro_prompt_processor dead-letter":  kiroProcessorNoDestination,
+}
+
+func loadDeliveryGraph(t *testing.T) *pipeline.Graph {
+	t.Helper()
+
+	_, g := loadDeliveryTree(t)
+	return g
+}
+
+func loadDeliveryTree(t *testing.T) (*tfconfig.Tree, *pipeline.Graph) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	g := pipeline.Build(tree)
+	require.Empty(t, g.Unresolved, "Every notification and destination should be statically known")
+	return tree, g
+}
+
+// TestDeliveryGraph_UploadPathsEndInDeadLetterQueues checks that objects
+// uploaded to the pipeline buckets reach their parser, and that what the
+// parser fails on ends in its dead-letter queue.
+func TestDeliveryGraph_UploadPathsEndInDeadLetterQueues(t *testing.T) {
+	t.Parallel()
+
+	paths := map[string]bool{}
+	for _, p := range loadDeliveryGraph(t).Paths() {
+		paths[p.String()] = p.Handled()
+	}
+
+	for _, want := range []string{
+		"environments/app-layer/bedrock-rag:aws_s3_bucket.rtl_codes -[s3-notification]-> " +
+			"environments/app-layer/bedrock-rag:aws_lambda_function.rtl_parser -[on-failure]-> " +
+			"environments/app-layer/bedrock-rag:aws_sqs_queue.rtl_parser_dlq",
+		"environments/app-layer/bedrock-rag:aws_s3_bucket.tool_guide_docs_seoul -[s3-notification]-> " +
+			"environments/app-layer/bedrock-rag:aws_lambda_function.tool_guide_parser -[on-failure]-> " +
+			"environments/app-layer/bedrock-rag:aws_sqs_queue.tool_guide_parser_dlq",
+		"environments/app-layer/bedrock-rag/module.s3_pipeline:aws_s3_bucket.destination -[s3-notification]-> " +
+			"environments/app-layer/bedrock-rag/module.s3_pipeline:aws_lambda_function.document_processor -[dead-letter]-> " +
+			"environments/app-layer/bedrock-rag/module.s3_pipeline:aws_sqs_queue.lambda_dlq",
+	} {
+		handled, ok := paths[want]
+		if assert.True(t, ok, "missing path %s", want) {
+			assert.True(t, handled, want)
+		}
+	}
+}
+
+// TestDeliveryGraph_Wiring checks every notification, event target and
+// Lambda destination across the stacks for missing or mismatched
+// permissions, overlapping notifications and asynchronous paths without a
+// dead-letter queue, unless waived.
+func TestDeliveryGraph_Wiring(t *testing.T) {
+	t.Parallel()
+
+	checkWaived(t, loadDeliveryGraph(t).Wiring(), knownPipelineFindings)
+}
+
+// TestDeliveryGraph_QueueTuning checks the evaluated settings of every SQS
+// queue against the functions that consume it, unless waived.
+func TestDeliveryGraph_QueueTuning(t *testing.T) {
+	t.Parallel()
+
+	g := loadDeliveryGraph(t)
+	require.NotEmpty(t, g.Queues)
+	checkWaived(t, g.Tuning(projectKeys...), knownQueueFindings)
+}
+
+// TestDeliveryGraph_QueueTuningHoldsForAnyLambdaTimeout sets lambda_timeout
+// on every module that takes it to any value Lambda and the variable
+// validation accept, and checks that the visibility timeouts of the
+// module's queues keep up with their consumers.
+func TestDeliveryGraph_QueueTuningHoldsForAnyLambdaTimeout(t *testing.T) {
+	tree, g := loadDeliveryTree(t)
+	var modules []*tfconfig.Module
+	for _, m := range tree.Modules {
+		if m.Var("lambda_timeout") != cty.NilVal {
+			modules = append(modules, m)
+		}
+	}
+	require.NotEmpty(t, modules)
+	restoreVars(t, modules...)
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("visibility timeouts follow lambda_timeout", prop.ForAll(
+		func(timeout int) string {
+			for _, m := range modules {
+				setVars(m, map[string]cty.Value{"lambda_timeout": cty.NumberIntVal(int64(timeout))})
+			}
+			for _, f := range g.Tuning(projectKeys...) {
+				if f.Check != pipeline.CheckVisibility && f.Check != pipeline.CheckUnknownValue {
+					continue
+				}
+				if _, ok := knownQueueFindings[f.Key()]; !ok {
+					return f.String()
+				}
+			}
+			return ""
+		},
+		gen.IntRange(300, 900),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
@@ -0,0 +1,140 @@
+package properties
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/eventbridge"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	kiroBus            = "environments/kiro-subscription:aws_cloudwatch_event_bus.kiro_prompts"
+	kiroPromptReceived = "environments/kiro-subscription:aws_cloudwatch_event_rule.kiro_prompt_received"
+	kiroPromptError    = "environments/kiro-subscription:aws_cloudwatch_event_rule.kiro_prompt_error"
+	kiroAllEvents      = "environments/kiro-subscription:aws_cloudwatch_event_rule.kiro_all_events"
+
+	kiroLogsResourcePolicy = "the kiro_eventbridge_logs role is not used by log group targets; EventBridge needs an aws_cloudwatch_log_resource_policy for /aws/events/kiro-*"
+)
+
+// eventRuleCases lists, for every rule in the tree, the fixture events
+// under testdata/eventbridge that must and must not trigger it. Every rule
+// needs an entry, so new rules come with the events they are meant for.
+var eventRuleCases = map[string]struct {
+	triggers    []string
+	notTriggers []string
+}{
+	kiroPromptReceived: {
+		triggers:    []string{"prompt_received"},
+		notTriggers: []string{"prompt_received_failed", "prompt_received_without_status", "prompt_error", "other_source"},
+	},
+	kiroPromptError: {
+		triggers:    []string{"prompt_error"},
+		notTriggers: []string{"prompt_received", "prompt_received_failed", "other_source"},
+	},
+	kiroAllEvents: {
+		triggers:    []string{"prompt_received", "prompt_received_failed", "prompt_received_without_status", "prompt_error"},
+		notTriggers: []string{"other_source"},
+	},
+}
+
+// knownEventFindings waives wiring findings, keyed by Finding.Key().
+var knownEventFindings = map[string]string{
+	kiroAllEvents + " aws_cloudwatch_event_target.kiro_logs resource-policy": kiroLogsResourcePolicy,
+}
+
+func loadEventRules(t *testing.T) *eventbridge.Model {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := eventbridge.Build(tree)
+	require.Empty(t, md.Unresolved, "Every event pattern should compile and every target should attach to its rule")
+	return md
+}
+
+func loadEvent(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "eventbridge", name+".json"))
+	require.NoError(t, err)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &event), name)
+	return event
+}
+
+// TestEventBridge_RulesMatchFixtureEvents evaluates every rule's pattern
+// against the fixture events it must and must not be triggered by.
+func TestEventBridge_RulesMatchFixtureEvents(t *testing.T) {
+	t.Parallel()
+
+	md := loadEventRules(t)
+	var ids []string
+	for _, r := range md.Rules {
+		ids = append(ids, r.ID)
+	}
+	var want []string
+	for id := range eventRuleCases {
+		want = append(want, id)
+	}
+	sort.Strings(want)
+	require.Equal(t, want, ids, "Every rule should have fixture events, and every case a rule")
+
+	for id, tc := range eventRuleCases {
+		id, tc := id, tc
+		t.Run(id, func(t *testing.T) {
+			t.Parallel()
+
+			r := md.Rule(id)
+			assert.Equal(t, kiroBus, r.Bus)
+			require.NotNil(t, r.Pattern, "%s should have an event pattern", id)
+			for _, name := range tc.triggers {
+				assert.True(t, r.Pattern.Match(loadEvent(t, name)), "%s should trigger %s", name, id)
+			}
+			for _, name := range tc.notTriggers {
+				assert.False(t, r.Pattern.Match(loadEvent(t, name)), "%s should not trigger %s", name, id)
+			}
+		})
+	}
+}
+
+// TestEventBridge_TargetsAreWired checks that every rule has a target on
+// its bus and that each target lets EventBridge in, unless waived.
+func TestEventBridge_TargetsAreWired(t *testing.T) {
+	t.Parallel()
+
+	md := loadEventRules(t)
+	received := md.Rule(kiroPromptReceived)
+	require.NotNil(t, received)
+	require.Len(t, received.Targets, 1)
+	require.Len(t, received.Targets[0].Destinations, 1)
+	assert.Equal(t, "environments/kiro-subscription:aws_lambda_function.kiro_prompt_processor",
+		received.Targets[0].Destinations[0].String(), "Received prompts should go to the prompt processor")
+
+	checkWaived(t, md.Wiring(), knownEventFindings)
+}
+
+// TestEventBridge_InputTransformersFindTheirPaths renders the input of every
+// target for each fixture event that triggers its rule, so that an
+// input_paths entry the events do not carry is caught before Lambda gets an
+// empty field.
+func TestEventBridge_InputTransformersFindTheirPaths(t *testing.T) {
+	t.Parallel()
+
+	md := loadEventRules(t)
+	for _, target := range md.Targets {
+		for _, name := range eventRuleCases[target.Rule.ID].triggers {
+			input, err := target.Input(loadEvent(t, name))
+			if assert.NoError(t, err, "%s with %s", target.ID, name) && target.InputTemplate != "" {
+				var v interface{}
+				assert.NoError(t, json.Unmarshal([]byte(input), &v), "%s should render JSON, got %s", target.ID, input)
+			}
+		}
+	}
+}
@@ -1,5 +1,76 @@
 package properties
 
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// waivable is a finding of an analyzer under internal/ that a property
+// test can waive by its key.
+type waivable interface {
+	Key() string
+	String() string
+}
+
+// checkWaived fails the test for findings that are not waived, and for
+// waivers whose finding is fixed. known maps the key of each waived finding
+// to the reason it is waived.
+func checkWaived[F waivable](t *testing.T, findings []F, known map[string]string) {
+	t.Helper()
+
+	seen := map[string]bool{}
+	for _, f := range findings {
+		seen[f.Key()] = true
+		if _, ok := known[f.Key()]; !ok {
+			t.Errorf("%s", f)
+		}
+	}
+
+	var stale []string
+	for key := range known {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	assert.Empty(t, stale, "Waivers for findings that are fixed should be removed")
+}
+
+// setVars sets the given variables of a module and keeps the others.
+func setVars(m *tfconfig.Module, vals map[string]cty.Value) {
+	all := moduleVars(m)
+	for name, v := range vals {
+		all[name] = v
+	}
+	m.SetVars(all)
+}
+
+// restoreVars restores the variables of the modules to their current values
+// when the test ends, for tests that change them with setVars.
+func restoreVars(t *testing.T, modules ...*tfconfig.Module) {
+	t.Helper()
+
+	for _, m := range modules {
+		m, vals := m, moduleVars(m)
+		t.Cleanup(func() { m.SetVars(vals) })
+	}
+}
+
+func moduleVars(m *tfconfig.Module) map[string]cty.Value {
+	vals := map[string]cty.Value{}
+	for _, b := range m.Blocks {
+		if b.Type == "variable" {
+			vals[b.Labels[0]] = m.Var(b.Labels[0])
+		}
+	}
+	return vals
+}
+
 // findResourceBlockEnd finds the end of a resource block in Terraform configuration
 func findResourceBlockEnd(content string, startPos int) int {
 	// If startPos is invalid, return -1
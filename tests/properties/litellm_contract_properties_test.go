@@ -0,0 +1,302 @@
+package properties
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/litellm"
+)
+
+// litellmRequestTimeout bounds each request, streamed body included, so a
+// live gateway that stops responding fails the test instead of hanging it.
+const litellmRequestTimeout = 60 * time.Second
+
+// litellmTarget is the gateway a contract test runs against.
+type litellmTarget struct {
+	base   string
+	key    string
+	live   bool
+	config *litellm.Config
+	client *http.Client
+}
+
+// newLiteLLMTarget returns LITELLM_ENDPOINT with LITELLM_API_KEY when set,
+// and otherwise an in-process fake serving the rendered config.yaml.
+func newLiteLLMTarget(t *testing.T) *litellmTarget {
+	t.Helper()
+
+	cfg := loadLiteLLMConfig(t)
+	if endpoint := os.Getenv("LITELLM_ENDPOINT"); endpoint != "" {
+		key := os.Getenv("LITELLM_API_KEY")
+		if key == "" {
+			t.Skip("Skipping: LITELLM_ENDPOINT is set but LITELLM_API_KEY is not")
+		}
+		return &litellmTarget{base: strings.TrimRight(endpoint, "/"), key: key, live: true, config: cfg, client: &http.Client{Timeout: litellmRequestTimeout}}
+	}
+	const key = "sk-contract-test"
+	srv := httptest.NewServer(&litellm.Fake{Config: cfg, MasterKey: key})
+	t.Cleanup(srv.Close)
+	return &litellmTarget{base: srv.URL, key: key, config: cfg, client: &http.Client{Timeout: litellmRequestTimeout}}
+}
+
+// do sends a request with the given Authorization header value ("" for
+// none) and returns the status, Content-Type and body.
+func (lt *litellmTarget) do(t *testing.T, method, path, auth string, body any) (int, string, []byte) {
+	t.Helper()
+
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		require.NoError(t, err)
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, lt.base+path, r)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := lt.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), data
+}
+
+func (lt *litellmTarget) bearer() string { return "Bearer " + lt.key }
+
+// models returns the chat or embedding models to exercise. Against a live
+// gateway LITELLM_CONTRACT_MODELS narrows the list, since every call is
+// billed and OpenAI models need a real OPENAI_API_KEY on the instance.
+func (lt *litellmTarget) models(embedding bool) []litellm.Model {
+	only := map[string]bool{}
+	if lt.live {
+		for _, name := range strings.Split(os.Getenv("LITELLM_CONTRACT_MODELS"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				only[name] = true
+			}
+		}
+	}
+	var out []litellm.Model
+	for _, m := range lt.config.ModelList {
+		if m.Embedding() == embedding && (len(only) == 0 || only[m.Name]) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// assertServedModel checks model aliasing: a request for a model_name is
+// answered by the configured upstream model (possibly with a version
+// suffix), or reports the alias itself.
+func assertServedModel(t *testing.T, m litellm.Model, got string) {
+	t.Helper()
+	assert.True(t, got == m.Name || strings.HasPrefix(got, m.Upstream()),
+		"%s is served by %s, response reports %s", m.Name, m.Params.Model, got)
+}
+
+func TestLiteLLMContract_Models(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	status, _, body := lt.do(t, http.MethodGet, "/v1/models", lt.bearer(), nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	list, err := litellm.ParseModelList(body)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	assert.Subset(t, ids, lt.config.Names(), "every model_name in config.yaml is listed")
+}
+
+func TestLiteLLMContract_ChatCompletions(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	for _, m := range lt.models(false) {
+		m := m
+		t.Run(m.Name, func(t *testing.T) {
+			t.Parallel()
+
+			status, ctype, body := lt.do(t, http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{
+				"model":    m.Name,
+				"messages": []map[string]string{{"role": "system", "content": "Answer briefly."}, {"role": "user", "content": "Say hello."}},
+			})
+			require.Equal(t, http.StatusOK, status, string(body))
+			assert.Contains(t, ctype, "application/json")
+			c, err := litellm.ParseChatCompletion(body)
+			require.NoError(t, err)
+			assertServedModel(t, m, c.Model)
+			assert.NotEmpty(t, c.Choices[0].Message.Content)
+
+			// max_tokens cuts the answer short and says so.
+			status, _, body = lt.do(t, http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{
+				"model":      m.Name,
+				"messages":   []map[string]string{{"role": "user", "content": "Count from one to twenty in words."}},
+				"max_tokens": 1,
+			})
+			require.Equal(t, http.StatusOK, status, string(body))
+			c, err = litellm.ParseChatCompletion(body)
+			require.NoError(t, err)
+			assert.Equal(t, "length", c.Choices[0].FinishReason)
+			assert.LessOrEqual(t, c.Usage.CompletionTokens, 1)
+		})
+	}
+}
+
+func TestLiteLLMContract_Streaming(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	for _, m := range lt.models(false) {
+		m := m
+		t.Run(m.Name, func(t *testing.T) {
+			t.Parallel()
+
+			status, ctype, body := lt.do(t, http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{
+				"model":          m.Name,
+				"messages":       []map[string]string{{"role": "user", "content": "Say hello."}},
+				"stream":         true,
+				"stream_options": map[string]bool{"include_usage": true},
+			})
+			require.Equal(t, http.StatusOK, status, string(body))
+			assert.True(t, strings.HasPrefix(ctype, "text/event-stream"), "Content-Type is %s", ctype)
+
+			chunks, content, err := litellm.ParseStream(body)
+			require.NoError(t, err)
+			assert.NotEmpty(t, content)
+			assertServedModel(t, m, chunks[0].Model)
+
+			// include_usage adds a final chunk with usage and no choices.
+			last := chunks[len(chunks)-1]
+			require.NotNil(t, last.Usage, "the last chunk should carry usage")
+			assert.Empty(t, last.Choices)
+			assert.Positive(t, last.Usage.CompletionTokens)
+		})
+	}
+}
+
+func TestLiteLLMContract_Embeddings(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	models := lt.models(true)
+	if !lt.live {
+		require.NotEmpty(t, models, "config.yaml should serve an embedding model")
+	}
+	for _, m := range models {
+		m := m
+		t.Run(m.Name, func(t *testing.T) {
+			t.Parallel()
+
+			status, _, body := lt.do(t, http.MethodPost, "/v1/embeddings", lt.bearer(), map[string]any{
+				"model": m.Name,
+				"input": []string{"반도체 설계 문서", "RTL parser"},
+			})
+			require.Equal(t, http.StatusOK, status, string(body))
+			e, err := litellm.ParseEmbeddings(body)
+			require.NoError(t, err)
+			require.Len(t, e.Data, 2)
+			assertServedModel(t, m, e.Model)
+			if dims := litellm.EmbeddingDimensions(m); dims != 0 {
+				assert.Len(t, e.Data[0].Embedding, dims, "default dimensions of %s", m.Params.Model)
+			}
+
+			// A single string is accepted as well as an array.
+			status, _, body = lt.do(t, http.MethodPost, "/v1/embeddings", lt.bearer(), map[string]any{
+				"model": m.Name,
+				"input": "RTL parser",
+			})
+			require.Equal(t, http.StatusOK, status, string(body))
+			e, err = litellm.ParseEmbeddings(body)
+			require.NoError(t, err)
+			assert.Len(t, e.Data, 1)
+		})
+	}
+}
+
+// TestLiteLLMContract_Errors checks that rejected requests get the error
+// envelope, with 401 for credentials and 400 for requests naming a model the
+// gateway does not serve.
+func TestLiteLLMContract_Errors(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	chat := lt.models(false)
+	require.NotEmpty(t, chat)
+	model := chat[0].Name
+	hello := []map[string]string{{"role": "user", "content": "hello"}}
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   any
+		status int // 0 for any 4xx
+	}{
+		{"no credentials", http.MethodPost, "/v1/chat/completions", "", map[string]any{"model": model, "messages": hello}, http.StatusUnauthorized},
+		{"wrong key", http.MethodPost, "/v1/chat/completions", "Bearer sk-wrong", map[string]any{"model": model, "messages": hello}, http.StatusUnauthorized},
+		{"key without Bearer", http.MethodPost, "/v1/chat/completions", lt.key, map[string]any{"model": model, "messages": hello}, http.StatusUnauthorized},
+		{"models without credentials", http.MethodGet, "/v1/models", "", nil, http.StatusUnauthorized},
+		{"unknown model", http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{"model": "gpt-5-turbo", "messages": hello}, http.StatusBadRequest},
+		{"provider model instead of alias", http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{"model": chat[0].Params.Model, "messages": hello}, http.StatusBadRequest},
+		{"missing messages", http.MethodPost, "/v1/chat/completions", lt.bearer(), map[string]any{"model": model}, 0},
+		{"malformed JSON", http.MethodPost, "/v1/chat/completions", lt.bearer(), `{"model":`, 0},
+	}
+	for _, tc := range testCases {
+		status, ctype, body := lt.do(t, tc.method, tc.path, tc.auth, tc.body)
+		if tc.status != 0 {
+			assert.Equal(t, tc.status, status, "%s: %s", tc.name, body)
+		} else {
+			assert.True(t, status >= 400 && status < 500, "%s: got %d", tc.name, status)
+		}
+		assert.Contains(t, ctype, "application/json", tc.name)
+		e, err := litellm.ParseError(body)
+		if assert.NoError(t, err, "%s: %s", tc.name, body) {
+			assert.NotContains(t, e.Message, lt.key, "%s: errors must not echo the key", tc.name)
+		}
+	}
+}
+
+// TestLiteLLMContract_Health checks the endpoints the load balancer and the
+// existing connectivity test use: liveliness is public, /health needs a key.
+func TestLiteLLMContract_Health(t *testing.T) {
+	t.Parallel()
+
+	lt := newLiteLLMTarget(t)
+	status, _, _ := lt.do(t, http.MethodGet, "/health/liveliness", "", nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _, body := lt.do(t, http.MethodGet, "/health", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	_, err := litellm.ParseError(body)
+	assert.NoError(t, err)
+
+	status, _, body = lt.do(t, http.MethodGet, "/health", lt.bearer(), nil)
+	require.Equal(t, http.StatusOK, status)
+	var health struct {
+		HealthyCount int `json:"healthy_count"`
+	}
+	require.NoError(t, json.Unmarshal(body, &health))
+	if !lt.live {
+		assert.Equal(t, len(lt.config.ModelList), health.HealthyCount)
+	}
+}
@@ -0,0 +1,506 @@
+package properties
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/mcp"
+)
+
+// mcpBridge is an MCP bridge of the repository: its server.js, and the
+// variable naming its endpoint for live runs.
+type mcpBridge struct {
+	name        string
+	serverPath  string
+	endpointEnv string
+}
+
+// The RTL RAG bridge and the IP document bridge run as separate services
+// with disjoint tool sets.
+var (
+	ragBridge       = mcpBridge{"mcp-bridge", "../../mcp-bridge/server.js", "MCP_ENDPOINT"}
+	toolGuideBridge = mcpBridge{"mcp-bridge-toolguide", "../../mcp-bridge-toolguide/server.js", "MCP_TOOLGUIDE_ENDPOINT"}
+	mcpBridges      = []mcpBridge{ragBridge, toolGuideBridge}
+)
+
+// bridgeStartTimeout bounds how long a bridge run with node may take to
+// answer /health.
+const bridgeStartTimeout = 15 * time.Second
+
+// legacyToolError describes handlers that still build their own isError
+// text ("오류: ...", "... 실패: ...") next to the lib/errors path.
+const legacyToolError = "returns inline isError text instead of lib/errors.renderError; clients must still parse both forms"
+
+// knownLegacyToolErrors are the tools of the bridges whose error results
+// are not the Error_Schema. New ones fail
+// TestMCPBridge_ToolErrorsUseErrorSchema, and so do entries that no longer
+// match.
+var knownLegacyToolErrors = map[string]string{
+	"rag_query":               legacyToolError,
+	"rag_list_documents":      legacyToolError,
+	"rag_categories":          legacyToolError,
+	"rag_upload_status":       legacyToolError,
+	"rag_extract_status":      legacyToolError,
+	"rag_delete_document":     legacyToolError,
+	"search_rtl":              legacyToolError,
+	"search_archive":          legacyToolError,
+	"get_evidence":            legacyToolError,
+	"list_verified_claims":    legacyToolError,
+	"generate_hdd_section":    legacyToolError,
+	"publish_markdown":        legacyToolError,
+	"trace_signal_path":       legacyToolError,
+	"find_instantiation_tree": legacyToolError,
+	"find_clock_crossings":    legacyToolError,
+	"graph_export":            legacyToolError,
+	"ip_doc_search":           legacyToolError,
+	"ip_doc_query":            legacyToolError,
+}
+
+// mutatingTools change state or bill per call, so conformance runs do not
+// call them.
+var mutatingTools = map[string]string{
+	"rag_delete_document":  "deletes the S3 object named by s3_key",
+	"publish_markdown":     "writes a document to the archive",
+	"regenerate_stale_hdd": "dispatches a regeneration job",
+	"generate_hdd_section": "runs a Bedrock generation per call",
+}
+
+func loadBridgeTools(t *testing.T, b mcpBridge) (mcp.Implementation, []mcp.ToolSpec) {
+	t.Helper()
+	src, err := os.ReadFile(b.serverPath)
+	require.NoError(t, err)
+	info, err := mcp.ParseServerInfo(string(src))
+	require.NoError(t, err)
+	specs, err := mcp.ParseServerJS(string(src))
+	require.NoError(t, err)
+	return info, specs
+}
+
+// forEachBridge runs f as a parallel subtest per bridge.
+func forEachBridge(t *testing.T, f func(t *testing.T, b mcpBridge)) {
+	for _, b := range mcpBridges {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			t.Parallel()
+			f(t, b)
+		})
+	}
+}
+
+// mcpTarget is the MCP server a conformance test runs against.
+type mcpTarget struct {
+	info      mcp.Implementation
+	specs     []mcp.ToolSpec
+	transport func() mcp.Transport
+}
+
+// startBridge runs the bridge's server.js with node on a free port and
+// returns its base URL. It skips the test when node or the bridge's
+// node_modules are missing.
+func startBridge(t *testing.T, b mcpBridge) string {
+	t.Helper()
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skipf("Skipping: node is not installed; install it, or set %s or MCP_REPLAY_DIR", b.endpointEnv)
+	}
+	dir := filepath.Dir(b.serverPath)
+	if _, err := os.Stat(filepath.Join(dir, "node_modules")); err != nil {
+		t.Skipf("Skipping: %s has no node_modules; run npm ci there, or set %s or MCP_REPLAY_DIR", dir, b.endpointEnv)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	var output bytes.Buffer
+	cmd := exec.Command(node, filepath.Base(b.serverPath))
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%d", port))
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	require.NoError(t, cmd.Start())
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-exited
+	})
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	deadline := time.Now().Add(bridgeStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			exited <- err
+			require.FailNow(t, "the bridge exited", "%v\n%s", err, output.String())
+		default:
+		}
+		if resp, err := http.Get(base + "/health"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return base
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.FailNow(t, "the bridge did not become healthy", "%s\n%s", bridgeStartTimeout, output.String())
+	return ""
+}
+
+// newMCPTarget returns the bridge at its endpoint variable when set, a
+// replay of the test's session from MCP_REPLAY_DIR when set, and otherwise
+// the bridge's server.js run with node. Live sessions are recorded to
+// MCP_RECORD_DIR when set, one file per test.
+func newMCPTarget(t *testing.T, b mcpBridge) *mcpTarget {
+	t.Helper()
+
+	mt := &mcpTarget{}
+	mt.info, mt.specs = loadBridgeTools(t, b)
+	file := strings.ReplaceAll(t.Name(), "/", "_") + ".json"
+	endpoint, replay := os.Getenv(b.endpointEnv), os.Getenv("MCP_REPLAY_DIR")
+	if endpoint == "" && replay != "" {
+		rec, err := mcp.LoadRecording(filepath.Join(replay, file))
+		if errors.Is(err, os.ErrNotExist) {
+			t.Skipf("Skipping: no recorded session %s in MCP_REPLAY_DIR", file)
+		}
+		require.NoError(t, err)
+		mt.transport = func() mcp.Transport { return mcp.NewReplay(rec) }
+		return mt
+	}
+	if endpoint == "" {
+		endpoint = startBridge(t, b)
+	}
+
+	url := strings.TrimRight(endpoint, "/") + "/mcp"
+	rec := &mcp.Recording{}
+	mt.transport = func() mcp.Transport {
+		return &mcp.Recorder{Transport: &mcp.HTTPTransport{URL: url}, Log: rec}
+	}
+	if dir := os.Getenv("MCP_RECORD_DIR"); dir != "" {
+		t.Cleanup(func() {
+			if err := rec.Save(filepath.Join(dir, file)); err != nil {
+				t.Errorf("saving the MCP session: %v", err)
+			}
+		})
+	}
+	return mt
+}
+
+// client returns a client that has completed the handshake.
+func (mt *mcpTarget) client(t *testing.T) *mcp.Client {
+	t.Helper()
+	c := mcp.NewClient(mt.transport())
+	_, err := c.Initialize(context.Background())
+	require.NoError(t, err)
+	return c
+}
+
+func (mt *mcpTarget) spec(t *testing.T, name string) mcp.ToolSpec {
+	t.Helper()
+	for _, s := range mt.specs {
+		if s.Name == name {
+			return s
+		}
+	}
+	require.FailNow(t, "server.js does not register "+name)
+	return mcp.ToolSpec{}
+}
+
+// stringArg returns a tool that does not mutate state and a string argument
+// it requires, to send malformed arguments to.
+func (mt *mcpTarget) stringArg(t *testing.T) (tool, arg string) {
+	t.Helper()
+	for _, s := range mt.specs {
+		if mutatingTools[s.Name] != "" {
+			continue
+		}
+		schema := s.InputSchema()
+		for _, name := range schema.Required {
+			if types := schema.Properties[name].Types(); len(types) == 1 && types[0] == "string" {
+				return s.Name, name
+			}
+		}
+	}
+	require.FailNow(t, "server.js registers no tool requiring a string argument")
+	return "", ""
+}
+
+func TestMCPConformance_Handshake(t *testing.T) {
+	t.Parallel()
+
+	forEachBridge(t, func(t *testing.T, b mcpBridge) {
+		ctx := context.Background()
+		mt := newMCPTarget(t, b)
+
+		// Without initialize there is no session to answer in.
+		_, err := mcp.NewClient(mt.transport()).ListTools(ctx)
+		assert.Error(t, err, "tools/list before initialize should be rejected")
+
+		c := mcp.NewClient(mt.transport())
+		info, err := c.Initialize(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, mt.info.Name, info.ServerInfo.Name, "the name server.js gives McpServer")
+		assert.NotNil(t, info.Capabilities.Tools, "the server must advertise the tools capability")
+		assert.Equal(t, mcp.ProtocolVersion, info.ProtocolVersion, "a supported requested version is echoed back")
+		assert.NotEmpty(t, c.Transport.Session(), "initialize should open a session")
+
+		var pong map[string]any
+		require.NoError(t, c.Call(ctx, "ping", nil, &pong))
+		assert.Empty(t, pong)
+	})
+}
+
+// TestMCPConformance_ToolSchemas checks tools/list against the zod shapes
+// registered in server.js: the same tools, each with a valid object schema
+// declaring the same arguments, types, enums, defaults and required set.
+func TestMCPConformance_ToolSchemas(t *testing.T) {
+	t.Parallel()
+
+	forEachBridge(t, func(t *testing.T, b mcpBridge) {
+		mt := newMCPTarget(t, b)
+		tools, err := mt.client(t).ListTools(context.Background())
+		require.NoError(t, err)
+
+		listed := map[string]mcp.Tool{}
+		for _, tool := range tools {
+			assert.NotContains(t, listed, tool.Name, "tools/list names %s twice", tool.Name)
+			listed[tool.Name] = tool
+		}
+		var want []string
+		for _, s := range mt.specs {
+			want = append(want, s.Name)
+		}
+		assert.ElementsMatch(t, want, keys(listed))
+
+		for _, spec := range mt.specs {
+			spec := spec
+			tool, ok := listed[spec.Name]
+			if !ok {
+				continue
+			}
+			t.Run(spec.Name, func(t *testing.T) {
+				assert.NotEmpty(t, strings.TrimSpace(tool.Description), "tools need a description")
+				require.NoError(t, mcp.CheckInputSchema(tool.InputSchema))
+
+				expected := spec.InputSchema()
+				got := tool.InputSchema
+				assert.ElementsMatch(t, keys(expected.Properties), keys(got.Properties))
+				assert.ElementsMatch(t, expected.Required, got.Required)
+				for name, p := range expected.Properties {
+					q := got.Properties[name]
+					if q == nil {
+						continue
+					}
+					assert.Equal(t, p.Types(), q.Types(), "%s type", name)
+					assert.Equal(t, p.Enum, q.Enum, "%s enum", name)
+					assert.JSONEq(t, orNull(p.Default), orNull(q.Default), "%s default", name)
+				}
+			})
+		}
+	})
+}
+
+// TestMCPConformance_ToolResults calls every tool with the minimal
+// arguments its schema allows. Successes through withEnvelope carry a valid
+// envelope, other structured blocks carry a request_id, and errors use the
+// Error_Schema unless the tool is a known legacy one.
+func TestMCPConformance_ToolResults(t *testing.T) {
+	t.Parallel()
+
+	forEachBridge(t, func(t *testing.T, b mcpBridge) {
+		mt := newMCPTarget(t, b)
+		c := mt.client(t)
+		for _, spec := range mt.specs {
+			spec := spec
+			t.Run(spec.Name, func(t *testing.T) {
+				if reason := mutatingTools[spec.Name]; reason != "" {
+					t.Skipf("Skipping: %s %s", spec.Name, reason)
+				}
+				args, _ := spec.InputSchema().Example().(map[string]any)
+				res, err := c.CallTool(context.Background(), spec.Name, args)
+				require.NoError(t, err)
+				text := res.Text()
+
+				if res.IsError {
+					if _, err := mcp.ParseToolError(res); err != nil {
+						if knownLegacyToolErrors[spec.Name] == "" {
+							t.Errorf("%s: %v", spec.Name, err)
+						}
+					}
+					return
+				}
+				switch {
+				case spec.Envelope:
+					e, _, err := mcp.ParseEnvelope(text)
+					require.NoError(t, err)
+					assert.NotEmpty(t, e.RequestID)
+				case spec.Structured:
+					s, err := mcp.ParseStructured(text)
+					require.NoError(t, err)
+					id, ok := s.String("request_id")
+					assert.True(t, ok && id != "", "structured block needs a request_id: %v", s.Keys)
+				default:
+					assert.NotContains(t, text, mcp.Separator, "%s appends a structured block server.js does not declare", spec.Name)
+				}
+			})
+		}
+	})
+}
+
+// TestMCPConformance_ToolErrors checks the error codes of lib/errors on the
+// tools that render them: malformed URIs, absent resources and unknown jobs.
+// The tool guide bridge renders none.
+func TestMCPConformance_ToolErrors(t *testing.T) {
+	t.Parallel()
+
+	mt := newMCPTarget(t, ragBridge)
+	c := mt.client(t)
+	testCases := []struct {
+		tool string
+		args map[string]any
+		code string
+	}{
+		{"rag_read_resource", map[string]any{"resource_uri": "not a uri"}, mcp.ErrInvalidURI},
+		{"rag_read_resource", map[string]any{"resource_uri": "s3://bucket/key"}, mcp.ErrInvalidURI},
+		{"rag_read_resource", map[string]any{"resource_uri": "rag://conformance/does-not-exist"}, mcp.ErrNotFound},
+		{"rag_task_status", map[string]any{"job_id": "conformance-unknown-job"}, mcp.ErrNotFound},
+	}
+	for _, tc := range testCases {
+		assert.Contains(t, mt.spec(t, tc.tool).ErrorCodes, tc.code, "server.js renders %s from %s", tc.code, tc.tool)
+
+		res, err := c.CallTool(context.Background(), tc.tool, tc.args)
+		require.NoError(t, err)
+		e, err := mcp.ParseToolError(res)
+		if assert.NoError(t, err, "%s %v", tc.tool, tc.args) {
+			assert.Equal(t, tc.code, e.ErrorCode, "%s %v: %s", tc.tool, tc.args, e.Message)
+		}
+	}
+}
+
+// TestMCPConformance_ProtocolErrors checks the JSON-RPC error codes. The SDK
+// reports a request without a method as a parse error rather than -32600,
+// and express.json() answers unparsable bodies with its own 400 before the
+// transport sees them, so those cases accept either.
+func TestMCPConformance_ProtocolErrors(t *testing.T) {
+	t.Parallel()
+
+	forEachBridge(t, func(t *testing.T, b mcpBridge) {
+		ctx := context.Background()
+		mt := newMCPTarget(t, b)
+		c := mt.client(t)
+
+		reply, err := c.Raw(ctx, `{"jsonrpc":"2.0","id":1,"method":"tools/list"`)
+		require.NoError(t, err)
+		assert.Equal(t, 400, reply.Status, "malformed JSON")
+		if e := reply.Error(); e != nil {
+			assert.Equal(t, mcp.CodeParseError, e.Code)
+		}
+
+		reply, err = c.Raw(ctx, `{"jsonrpc":"2.0","id":2}`)
+		require.NoError(t, err)
+		if e := reply.Error(); assert.NotNil(t, e, "request without a method: HTTP %d", reply.Status) {
+			assert.Contains(t, []int{mcp.CodeInvalidRequest, mcp.CodeParseError}, e.Code)
+		}
+
+		var rpcErr *mcp.RPCError
+		err = c.Call(ctx, "tools/unknown", nil, nil)
+		if assert.ErrorAs(t, err, &rpcErr) {
+			assert.Equal(t, mcp.CodeMethodNotFound, rpcErr.Code)
+		}
+		err = c.Call(ctx, "tools/call", map[string]any{"name": "conformance_no_such_tool", "arguments": map[string]any{}}, nil)
+		if assert.ErrorAs(t, err, &rpcErr) {
+			assert.Equal(t, mcp.CodeInvalidParams, rpcErr.Code)
+		}
+
+		// Newer SDKs report argument errors as a tool error instead of -32602.
+		tool, arg := mt.stringArg(t)
+		for _, args := range []map[string]any{{}, {arg: 42}} {
+			var res mcp.CallToolResult
+			err := c.Call(ctx, "tools/call", map[string]any{"name": tool, "arguments": args}, &res)
+			if errors.As(err, &rpcErr) {
+				assert.Equal(t, mcp.CodeInvalidParams, rpcErr.Code, "arguments %v", args)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, res.IsError, "arguments %v should be rejected", args)
+			}
+		}
+	})
+}
+
+// TestMCPBridge_ToolErrorsUseErrorSchema tracks the handlers of the bridges
+// that still build error results inline, reading server.js statically.
+func TestMCPBridge_ToolErrorsUseErrorSchema(t *testing.T) {
+	t.Parallel()
+
+	seen := map[string]bool{}
+	for _, b := range mcpBridges {
+		_, specs := loadBridgeTools(t, b)
+		for _, spec := range specs {
+			if spec.LegacyErrors == 0 {
+				continue
+			}
+			seen[spec.Name] = true
+			if _, ok := knownLegacyToolErrors[spec.Name]; !ok {
+				t.Errorf("%s: %s: %d error results bypass lib/errors.renderError", b.name, spec.Name, spec.LegacyErrors)
+			}
+		}
+	}
+
+	var stale []string
+	for name := range knownLegacyToolErrors {
+		if !seen[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	assert.Empty(t, stale, "Waivers for findings that are fixed should be removed")
+}
+
+// TestMCPBridge_ToolNamesAreDisjoint checks that no two bridges register a
+// tool of the same name, so a client connected to both can tell them apart.
+func TestMCPBridge_ToolNamesAreDisjoint(t *testing.T) {
+	t.Parallel()
+
+	owner := map[string]string{}
+	for _, b := range mcpBridges {
+		_, specs := loadBridgeTools(t, b)
+		for _, spec := range specs {
+			if other, ok := owner[spec.Name]; ok {
+				t.Errorf("%s registers %s, as %s does", b.name, spec.Name, other)
+			}
+			owner[spec.Name] = b.name
+		}
+	}
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func orNull(raw []byte) string {
+	if len(raw) == 0 {
+		return "null"
+	}
+	return string(raw)
+}
@@ -0,0 +1,197 @@
+package properties
+
+import (
+	"net"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/nacl"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+// loadNetworkACLs evaluates the network-acls module with the CIDRs the
+// app layer passes to it (US backend VPC, peered with the Seoul frontend VPC),
+// taken from the network layer tfvars example.
+func loadNetworkACLs(t *testing.T, withPeer bool) []*nacl.ACL {
+	t.Helper()
+
+	tfvars, err := tfconfig.LoadVarsFile("../../environments/network-layer/terraform.tfvars.example")
+	require.NoError(t, err, "Should be able to read network-layer tfvars example")
+
+	m, err := tfconfig.Load("../../modules/network/network-acls")
+	require.NoError(t, err, "Should be able to load network-acls module")
+
+	vars := map[string]cty.Value{
+		"vpc_cidr": tfvars["us_vpc_cidr"],
+	}
+	if withPeer {
+		vars["peer_vpc_cidr"] = tfvars["seoul_vpc_cidr"]
+	}
+	m.SetVars(vars)
+
+	acls, err := nacl.FromModule(m)
+	require.NoError(t, err, "Network ACL rules should be statically evaluable")
+	require.Len(t, acls, 1, "Module should define exactly one network ACL")
+	return acls
+}
+
+func tcpFlow(src, dst string, srcPort, dstPort int) nacl.Flow {
+	return nacl.Flow{Protocol: "tcp", Src: net.ParseIP(src), Dst: net.ParseIP(dst), SrcPort: srcPort, DstPort: dstPort}
+}
+
+// TestNetworkACLSimulation_SampleFlows evaluates representative flows against
+// the private subnet ACL with first-match semantics.
+func TestNetworkACLSimulation_SampleFlows(t *testing.T) {
+	t.Parallel()
+
+	acl := loadNetworkACLs(t, true)[0]
+
+	testCases := []struct {
+		name    string
+		dir     nacl.Direction
+		flow    nacl.Flow
+		allowed bool
+		rule    int // 0 means the implicit default rule
+	}{
+		{"VPC-internal traffic", nacl.Ingress, tcpFlow("10.20.1.10", "10.20.2.10", 40000, 5432), true, 100},
+		{"Seoul frontend to backend", nacl.Ingress, tcpFlow("10.10.1.10", "10.20.1.10", 40000, 8080), true, 110},
+		{"On-prem HTTPS to VPC endpoints", nacl.Ingress, tcpFlow("192.128.1.10", "10.20.1.10", 40000, 443), true, 120},
+		{"Internet SSH", nacl.Ingress, tcpFlow("203.0.113.10", "10.20.1.10", 40000, 22), false, 32766},
+		{"Internet UDP", nacl.Ingress, nacl.Flow{Protocol: "udp", Src: net.ParseIP("203.0.113.10"), Dst: net.ParseIP("10.20.1.10"), SrcPort: 53, DstPort: 53}, false, 32766},
+		{"Lambda HTTPS to AWS APIs", nacl.Egress, tcpFlow("10.20.1.10", "52.94.0.10", 40000, 443), true, 120},
+		{"Lambda to internet HTTP", nacl.Egress, tcpFlow("10.20.1.10", "52.94.0.10", 40000, 80), false, 32766},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := acl.Evaluate(tc.dir, tc.flow)
+			assert.Equal(t, tc.allowed, d.Allowed, "%s %s: %s", tc.dir, tc.flow, d)
+			if tc.rule == 0 {
+				assert.Nil(t, d.Rule, "%s should fall through to the default rule", tc.flow)
+			} else if assert.NotNil(t, d.Rule, "%s should match an explicit rule", tc.flow) {
+				assert.Equal(t, tc.rule, d.Rule.Number, "%s matched %s", tc.flow, d)
+			}
+		})
+	}
+}
+
+// TestNetworkACLSimulation_PeerRuleFollowsCount verifies that the peer rules
+// disappear when no peer CIDR is configured and that peer traffic is then
+// only admitted by the broad HTTPS/ephemeral rules.
+func TestNetworkACLSimulation_PeerRuleFollowsCount(t *testing.T) {
+	t.Parallel()
+
+	withPeer := loadNetworkACLs(t, true)[0]
+	withoutPeer := loadNetworkACLs(t, false)[0]
+
+	assert.Len(t, withPeer.Rules, 10, "Should have 5 inbound and 5 outbound rules with a peer")
+	assert.Len(t, withoutPeer.Rules, 8, "Peer rules should be dropped when peer_vpc_cidr is empty")
+
+	flow := tcpFlow("10.10.1.10", "10.20.1.10", 40000, 8080)
+	assert.True(t, withPeer.Evaluate(nacl.Ingress, flow).Allowed)
+	assert.True(t, withoutPeer.Evaluate(nacl.Ingress, flow).Allowed, "Ephemeral-range rule still admits port 8080")
+
+	flow.DstPort = 80
+	assert.True(t, withPeer.Evaluate(nacl.Ingress, flow).Allowed)
+	assert.False(t, withoutPeer.Evaluate(nacl.Ingress, flow).Allowed, "Port 80 from the peer needs the peer rule")
+}
+
+// TestNetworkACLSimulation_NoUnreachableRules verifies that no rule is
+// shadowed or made redundant by lower-numbered rules.
+func TestNetworkACLSimulation_NoUnreachableRules(t *testing.T) {
+	t.Parallel()
+
+	for _, withPeer := range []bool{true, false} {
+		for _, acl := range loadNetworkACLs(t, withPeer) {
+			for _, f := range acl.Analyze() {
+				t.Errorf("peer=%v: %s", withPeer, f)
+			}
+		}
+	}
+}
+
+// TestNetworkACLSimulation_RuleQuota warns when a direction is within four
+// rules of the default quota of 20 rules per direction.
+func TestNetworkACLSimulation_RuleQuota(t *testing.T) {
+	t.Parallel()
+
+	for _, acl := range loadNetworkACLs(t, true) {
+		for _, f := range acl.CheckQuota(nacl.DefaultRuleQuota, 4) {
+			t.Errorf("%s", f)
+		}
+	}
+}
+
+// TestNetworkACLSimulation_ReturnTraffic verifies that the response to every
+// permitted flow is permitted for the whole ephemeral port range, for inbound
+// connections to the subnet and outbound connections from it.
+func TestNetworkACLSimulation_ReturnTraffic(t *testing.T) {
+	t.Parallel()
+
+	acl := loadNetworkACLs(t, true)[0]
+
+	flows := []struct {
+		dir  nacl.Direction
+		flow nacl.Flow
+	}{
+		{nacl.Ingress, tcpFlow("10.20.1.10", "10.20.2.10", 0, 5432)},
+		{nacl.Ingress, tcpFlow("10.10.1.10", "10.20.1.10", 0, 8080)},
+		{nacl.Ingress, tcpFlow("192.128.1.10", "10.20.1.10", 0, 443)},
+		{nacl.Egress, tcpFlow("10.20.1.10", "52.94.0.10", 0, 443)},
+		{nacl.Egress, tcpFlow("10.20.1.10", "10.10.1.10", 0, 6333)},
+	}
+
+	for _, f := range flows {
+		require.True(t, acl.Evaluate(f.dir, f.flow).Allowed, "%s %s should be allowed", f.dir, f.flow)
+		for _, finding := range acl.CheckReturnTraffic(f.dir, f.flow, nacl.EphemeralFrom, nacl.EphemeralTo) {
+			t.Errorf("%s", finding)
+		}
+	}
+}
+
+// TestNetworkACLSimulation_ReturnTrafficProperty checks the return-traffic
+// invariant over generated TCP flows: whenever the ACL permits a flow in one
+// direction, it also permits the response to the client's ephemeral port.
+func TestNetworkACLSimulation_ReturnTrafficProperty(t *testing.T) {
+	acl := loadNetworkACLs(t, true)[0]
+
+	properties := gopter.NewProperties(nil)
+
+	properties.Property("responses to permitted flows are permitted", prop.ForAll(
+		func(a, b, c, d uint8, dstPort, ephemeral int, egress bool) bool {
+			remote := net.IPv4(a, b, c, d)
+			local := net.ParseIP("10.20.1.10")
+
+			dir := nacl.Ingress
+			flow := nacl.Flow{Protocol: "tcp", Src: remote, Dst: local, SrcPort: ephemeral, DstPort: dstPort}
+			if egress {
+				dir = nacl.Egress
+				flow = nacl.Flow{Protocol: "tcp", Src: local, Dst: remote, SrcPort: ephemeral, DstPort: dstPort}
+			}
+
+			if !acl.Evaluate(dir, flow).Allowed {
+				return true
+			}
+			resp := acl.Evaluate(!dir, flow.Reverse())
+			if !resp.Allowed {
+				t.Logf("%s %s allowed but response %s", dir, flow, resp)
+			}
+			return resp.Allowed
+		},
+		gen.UInt8(), gen.UInt8(), gen.UInt8(), gen.UInt8(),
+		gen.OneConstOf(22, 80, 443, 5432, 6333, 8080),
+		gen.IntRange(nacl.EphemeralFrom, nacl.EphemeralTo),
+		gen.Bool(),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
@@ -0,0 +1,310 @@
+package properties
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/nginx"
+)
+
+const (
+	llmGatewayHost = "llm.corp.bos-semi.com"
+	mcpGatewayHost = "mcp.corp.bos-semi.com"
+)
+
+func loadNginxConfig(t *testing.T) (*nginx.Config, string) {
+	t.Helper()
+
+	site := loadCallSites(t)["nginx-user-data.sh.tpl"]
+	script := renderUserData(t, "nginx-user-data.sh.tpl")
+	c, err := nginx.Parse(script.Files[nginxConfPath])
+	require.NoError(t, err)
+	return c, site.Vars["apigw_host"].AsString()
+}
+
+// TestNginxRouting_Table pins the routing table of the gateway reverse proxy.
+// Neither location sets client_max_body_size or proxy_buffering, so both use
+// nginx's defaults of 1m and buffered responses.
+func TestNginxRouting_Table(t *testing.T) {
+	t.Parallel()
+
+	c, apigw := loadNginxConfig(t)
+	assert.Equal(t, []nginx.Route{
+		{
+			Server:   "_",
+			Listen:   "443 ssl default_server",
+			Upstream: "return 444",
+		},
+		{
+			Server:      llmGatewayHost,
+			Listen:      "443 ssl",
+			Location:    "/",
+			Upstream:    "https://" + apigw + "/prod/llm/",
+			Headers:     []string{"Host: " + apigw},
+			ReadTimeout: nginx.DefaultTimeout,
+			MaxBodySize: nginx.DefaultMaxBodySize,
+			Buffering:   true,
+		},
+		{
+			Server:      mcpGatewayHost,
+			Listen:      "443 ssl",
+			Location:    "/",
+			Upstream:    "https://" + apigw + "/prod/mcp/",
+			Headers:     []string{"Host: " + apigw, "Upgrade: $http_upgrade", "Connection: upgrade"},
+			ReadTimeout: time.Hour,
+			MaxBodySize: nginx.DefaultMaxBodySize,
+			Buffering:   true,
+		},
+	}, c.Routes())
+
+	for _, host := range []string{llmGatewayHost, mcpGatewayHost} {
+		loc := c.Server(host, 443).Location("/")
+		assert.True(t, loc.SSLServerName, "%s: API Gateway needs SNI", host)
+	}
+	assert.Equal(t, "1.1", c.Server(mcpGatewayHost, 443).Location("/").HTTPVersion)
+}
+
+// fakeAPIGateway stands in for the private REST API: it checks the Host and
+// SNI nginx sends and dispatches /prod/llm/* and /prod/mcp/* to fake LiteLLM
+// and MCP servers with the prefix removed, as the proxy integrations do.
+type fakeAPIGateway struct {
+	*httptest.Server
+	host string
+
+	mu   sync.Mutex
+	seen []*http.Request
+}
+
+func newFakeAPIGateway(t *testing.T, host string, llm, mcp http.Handler) *fakeAPIGateway {
+	g := &fakeAPIGateway{host: host}
+	mux := http.NewServeMux()
+	mux.Handle("/prod/llm/", http.StripPrefix("/prod/llm", llm))
+	mux.Handle("/prod/mcp/", http.StripPrefix("/prod/mcp", mcp))
+	g.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		g.seen = append(g.seen, r)
+		g.mu.Unlock()
+		if r.Host != host || r.TLS.ServerName != host {
+			http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	g.StartTLS()
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *fakeAPIGateway) last() *http.Request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.seen[len(g.seen)-1]
+}
+
+// fakeLiteLLM serves the OpenAI-compatible routes the gateway clients use.
+func fakeLiteLLM() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"healthy"}`)
+	})
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"object":"list","data":[{"id":"gpt-4o"}],"auth":%q}`, r.Header.Get("Authorization"))
+	})
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"object":"chat.completion","choices":[{"message":{"role":"assistant","content":"hi"}}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.URL.Query().Get("unbuffered") != "" {
+			w.Header().Set("X-Accel-Buffering", "no")
+		}
+		for _, chunk := range []string{`{"choices":[{"delta":{"content":"h"}}]}`, `{"choices":[{"delta":{"content":"i"}}]}`, "[DONE]"} {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+	mux.HandleFunc("/v1/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	return mux
+}
+
+// fakeMCP serves the MCP server's health check and a JSON-RPC endpoint
+// that answers slowly, like a long-running tool call.
+func fakeMCP() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID any `json:"id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": map[string]any{}})
+	})
+	return mux
+}
+
+// newGatewayEmulator serves the rendered nginx config with every upstream
+// connection sent to the fake API Gateway. Timeouts are scaled down 1000x:
+// the 60s default becomes 60ms and MCP's 3600s becomes 3.6s.
+func newGatewayEmulator(t *testing.T) (*nginx.Emulator, *fakeAPIGateway, func(method, host, path, body string) (*http.Response, error)) {
+	t.Helper()
+
+	c, apigw := loadNginxConfig(t)
+	g := newFakeAPIGateway(t, apigw, fakeLiteLLM(), fakeMCP())
+	e := &nginx.Emulator{
+		Config:    c,
+		TimeScale: 0.001,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != apigw+":443" {
+				return nil, fmt.Errorf("unexpected upstream %s", addr)
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, g.Listener.Addr().String())
+		},
+	}
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	do := func(method, host, path, body string) (*http.Response, error) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Host = host
+		req.Header.Set("Authorization", "Bearer sk-test")
+		return srv.Client().Do(req)
+	}
+	return e, g, do
+}
+
+func readAll(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+// TestNginxRouting_Emulator exercises both gateway hosts end to end through
+// the emulated reverse proxy and API Gateway.
+func TestNginxRouting_Emulator(t *testing.T) {
+	t.Parallel()
+
+	e, g, do := newGatewayEmulator(t)
+
+	testCases := []struct {
+		host, method, path, body string
+		upstreamPath             string
+		want                     string
+	}{
+		{llmGatewayHost, http.MethodGet, "/health", "", "/prod/llm/health", `"healthy"`},
+		{llmGatewayHost, http.MethodGet, "/v1/models?limit=1", "", "/prod/llm/v1/models", `"auth":"Bearer sk-test"`},
+		{llmGatewayHost, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`, "/prod/llm/v1/chat/completions", `"chat.completion"`},
+		{mcpGatewayHost, http.MethodGet, "/health", "", "/prod/mcp/health", `"ok"`},
+		{mcpGatewayHost, http.MethodPost, "/", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "/prod/mcp/", `"jsonrpc":"2.0"`},
+	}
+	for _, tc := range testCases {
+		name := tc.host + tc.path
+		resp, err := do(tc.method, tc.host, tc.path, tc.body)
+		require.NoError(t, err, name)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, name)
+		assert.Contains(t, readAll(t, resp), tc.want, name)
+
+		got := g.last()
+		assert.Equal(t, tc.upstreamPath, got.URL.Path, name)
+		assert.Equal(t, "Bearer sk-test", got.Header.Get("Authorization"), "%s: client credentials reach the gateway", name)
+	}
+	assert.Equal(t, "https://"+g.host+"/prod/llm/v1/models?limit=1", e.Log()[1].Upstream, "query strings are passed")
+
+	// Hosts other than the two gateway names are dropped without a response.
+	_, err := do(http.MethodGet, "llm.corp.bos-semi.com.evil.io", "/health", "")
+	assert.Error(t, err)
+	assert.Equal(t, 0, e.Log()[len(e.Log())-1].Status)
+}
+
+// TestNginxRouting_Limits checks the limits nginx puts on requests: 1m
+// request bodies on both hosts, and a read timeout that only MCP raises.
+func TestNginxRouting_Limits(t *testing.T) {
+	t.Parallel()
+
+	_, _, do := newGatewayEmulator(t)
+
+	big := `{"messages":[{"role":"user","content":"` + strings.Repeat("x", nginx.DefaultMaxBodySize) + `"}]}`
+	for _, host := range []string{llmGatewayHost, mcpGatewayHost} {
+		resp, err := do(http.MethodPost, host, "/v1/chat/completions", big)
+		require.NoError(t, err)
+		readAll(t, resp)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, "%s: bodies over 1m are rejected", host)
+	}
+
+	// A 200ms upstream exceeds the scaled 60s default but not MCP's 3600s.
+	resp, err := do(http.MethodGet, llmGatewayHost, "/v1/slow", "")
+	require.NoError(t, err)
+	readAll(t, resp)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	resp, err = do(http.MethodPost, mcpGatewayHost, "/", `{"jsonrpc":"2.0","id":7,"method":"tools/call"}`)
+	require.NoError(t, err)
+	assert.Contains(t, readAll(t, resp), `"id":7`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// TestNginxRouting_Streaming checks chat completion streaming through the
+// proxy. With proxy_buffering at its default, events are delivered together
+// once the upstream finishes unless the upstream sends X-Accel-Buffering: no.
+func TestNginxRouting_Streaming(t *testing.T) {
+	t.Parallel()
+
+	_, _, do := newGatewayEmulator(t)
+	events := func(resp *http.Response) []string {
+		var out []string
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if line := sc.Text(); strings.HasPrefix(line, "data: ") {
+				out = append(out, strings.TrimPrefix(line, "data: "))
+			}
+		}
+		return out
+	}
+
+	resp, err := do(http.MethodPost, llmGatewayHost, "/v1/chat/completions", `{"model":"gpt-4o","stream":true}`)
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get("Content-Length"), "buffered responses are sent with a length")
+	got := events(resp)
+	resp.Body.Close()
+	require.Len(t, got, 3)
+	assert.Equal(t, "[DONE]", got[2])
+
+	resp, err = do(http.MethodPost, llmGatewayHost, "/v1/chat/completions?unbuffered=1", `{"model":"gpt-4o","stream":true}`)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Length"), "unbuffered responses are streamed")
+	assert.Empty(t, resp.Header.Get("X-Accel-Buffering"), "nginx consumes the buffering header")
+	got = events(resp)
+	resp.Body.Close()
+	assert.Len(t, got, 3)
+}
@@ -0,0 +1,150 @@
+package properties
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/aoss"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	seoulCollection        = "environments/app-layer:aws_opensearchserverless_collection.main"
+	bedrockRAGCollection   = bedrockRAGModule + ":aws_opensearchserverless_collection.main"
+	seoulDocumentLambda    = "environments/app-layer:aws_lambda_function.document_processor"
+	virginiaDocumentLambda = "environments/app-layer/bedrock-rag:aws_lambda_function.document_processor"
+	rtlIndexPolicy         = "environments/app-layer/bedrock-rag:aws_opensearchserverless_access_policy.rtl_index"
+
+	awsOwnedKeyCollection = "created with an AWS owned key; the encryption policy of an existing collection cannot change, so the CMK needs a new collection"
+	vpcePlaceholder       = "SourceVPCEs holds a placeholder ID instead of aws_opensearchserverless_vpc_endpoint.main"
+	modulePublicNetwork   = "the bedrock-rag module's network policy allows public access; the stack's VPC endpoints are not passed to it"
+	sharedDataStatement   = "one data access statement grants both roles the knowledge base's permissions plus aoss:DeleteIndex"
+	virginiaWriterGrant   = "no data access policy of the stack names the Lambda role on bos-ai-vectors/bos-ai-documents"
+	servicePrincipal      = "bedrock.amazonaws.com is a service principal, which data access policies do not accept"
+)
+
+// knownOpenSearchPolicyFindings waives OpenSearch Serverless policy
+// findings, keyed by Finding.Key().
+var knownOpenSearchPolicyFindings = map[string]string{
+	seoulCollection + " encryption":          awsOwnedKeyCollection,
+	seoulCollection + " network":             vpcePlaceholder,
+	bedrockRAGCollection + " network":        modulePublicNetwork,
+	seoulKB + " least-privilege":             sharedDataStatement,
+	seoulDocumentLambda + " least-privilege": sharedDataStatement,
+	bedrockRAGKB + " least-privilege":        sharedDataStatement,
+	virginiaDocumentLambda + " data-access":  virginiaWriterGrant,
+	rtlIndexPolicy + " principal":            servicePrincipal,
+}
+
+func loadOpenSearchPolicies(t *testing.T) (*tfconfig.Tree, *aoss.Model) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := aoss.Build(tree)
+	require.Empty(t, md.Unknown, "Every collection, policy and consumer should be statically known")
+	return tree, md
+}
+
+// TestOpenSearchPolicy_Consumers checks that every collection and every
+// knowledge base and ingestion Lambda function of the tree is found, with
+// the role it uses.
+func TestOpenSearchPolicy_Consumers(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadOpenSearchPolicies(t)
+	var collections []string
+	for _, c := range md.Collections {
+		collections = append(collections, c.ID+" "+c.Name)
+	}
+	assert.Equal(t, []string{
+		bedrockRAGCollection + " bos-ai-vectors",
+		seoulCollection + " bos-ai-rag-vectors-prod",
+	}, collections)
+
+	var consumers []string
+	for _, c := range md.Consumers {
+		consumers = append(consumers, c.ID+" "+c.Role+" "+c.Collection+"/"+c.Index)
+	}
+	assert.Equal(t, []string{
+		bedrockRAGKB + " ${environments/app-layer/bedrock-rag/module.iam:aws_iam_role.bedrock_kb} bos-ai-vectors/bedrock-knowledge-base-index",
+		virginiaDocumentLambda + " ${environments/app-layer/bedrock-rag:aws_iam_role.lambda} bos-ai-vectors/" + documentsIndexName,
+		seoulKB + " ${environments/app-layer:aws_iam_role.bedrock_kb} bos-ai-rag-vectors-prod/" + documentsIndexName,
+		seoulDocumentLambda + " ${environments/app-layer:aws_iam_role.lambda} bos-ai-rag-vectors-prod/" + documentsIndexName,
+	}, consumers)
+}
+
+// TestOpenSearchPolicy_Check checks the encryption, network and data access
+// policies of every collection and consumer, unless waived.
+func TestOpenSearchPolicy_Check(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadOpenSearchPolicies(t)
+	checkWaived(t, md.Check(), knownOpenSearchPolicyFindings)
+}
+
+// TestOpenSearchPolicy_ModulePoliciesFollowCollectionName renames the
+// bedrock-rag module's collection and checks that the findings for the
+// collection and its knowledge base do not change: the module's policies
+// select the collection and index by its name, whatever it is.
+func TestOpenSearchPolicy_ModulePoliciesFollowCollectionName(t *testing.T) {
+	tree, md := loadOpenSearchPolicies(t)
+	var module *tfconfig.Module
+	for _, m := range tree.Modules {
+		if m.Name == bedrockRAGModule {
+			module = m
+		}
+	}
+	require.NotNil(t, module)
+	restoreVars(t, module)
+	moduleKeys := func(md *aoss.Model) string {
+		var keys []string
+		for _, f := range md.Check() {
+			if strings.HasPrefix(f.Subject, bedrockRAGModule+":") {
+				keys = append(keys, f.Key())
+			}
+		}
+		return strings.Join(keys, "\n")
+	}
+	want := moduleKeys(md)
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("module policies select the collection by name", prop.ForAll(
+		func(id string) string {
+			name := "c" + strings.ToLower(id)
+			if len(name) > 32 {
+				name = name[:32]
+			}
+			setVars(module, map[string]cty.Value{"opensearch_collection_name": cty.StringVal(name)})
+
+			md := aoss.Build(tree)
+			var collection *aoss.Collection
+			for _, c := range md.Collections {
+				if c.ID == bedrockRAGCollection {
+					collection = c
+				}
+			}
+			if collection == nil {
+				return bedrockRAGCollection + " not found"
+			}
+			if collection.Name != name {
+				return fmt.Sprintf("%s is named %q, want %q", collection.ID, collection.Name, name)
+			}
+			if got := moduleKeys(md); got != want {
+				return fmt.Sprintf("collection %q: findings\n%s\nwant\n%s", name, got, want)
+			}
+			return ""
+		},
+		gen.Identifier(),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
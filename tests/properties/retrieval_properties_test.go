@@ -0,0 +1,86 @@
+package properties
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/retrieval"
+)
+
+const (
+	trinityHierarchy = "../../test_rtl/trinity_hierarchy.json"
+	goldenSet        = "../../test_rtl/rag_result/golden.yaml"
+	memoryBaseline   = "../../test_rtl/rag_result/baseline/memory.json"
+)
+
+func loadRetrieval(t *testing.T) (*retrieval.Golden, []retrieval.Document) {
+	t.Helper()
+
+	data, err := os.ReadFile(goldenSet)
+	require.NoError(t, err)
+	golden, err := retrieval.ParseGolden(string(data))
+	require.NoError(t, err)
+	data, err = os.ReadFile(trinityHierarchy)
+	require.NoError(t, err)
+	docs, err := retrieval.HierarchyDocuments(string(data))
+	require.NoError(t, err)
+	return golden, docs
+}
+
+// TestRetrieval_GoldenSetMatchesHierarchy checks that every golden question
+// names modules of the hierarchy as its sources, and that one of their
+// documents holds its evidence, so the golden set stays answerable when the
+// hierarchy dump is regenerated.
+func TestRetrieval_GoldenSetMatchesHierarchy(t *testing.T) {
+	t.Parallel()
+
+	golden, docs := loadRetrieval(t)
+	text := map[string]string{}
+	for _, d := range docs {
+		text[retrieval.Result{URI: d.URI}.Source()] = d.Text
+	}
+	for _, q := range golden.Questions {
+		answered := false
+		for _, src := range q.Sources {
+			doc, ok := text[src]
+			if !ok {
+				t.Errorf("question %s: %s is not a module of %s", q.ID, src, trinityHierarchy)
+			}
+			answered = answered || strings.Contains(doc, q.Evidence)
+		}
+		assert.True(t, answered, "question %s: no source document contains %q", q.ID, q.Evidence)
+	}
+}
+
+// TestRetrieval_MemoryBaseline asks the in-memory stand-in the golden
+// questions and checks the results against the saved baseline. The
+// stand-in is deterministic, so results that improve on the baseline fail
+// too, until it is saved again by running the test with
+// RETRIEVAL_UPDATE_BASELINE set.
+func TestRetrieval_MemoryBaseline(t *testing.T) {
+	t.Parallel()
+
+	golden, docs := loadRetrieval(t)
+	report, err := retrieval.Evaluate(context.Background(), retrieval.NewMemory(docs), "memory", golden)
+	require.NoError(t, err)
+	if os.Getenv("RETRIEVAL_UPDATE_BASELINE") != "" {
+		out, err := report.JSON()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(memoryBaseline, out, 0o644))
+		return
+	}
+
+	data, err := os.ReadFile(memoryBaseline)
+	require.NoError(t, err)
+	baseline, err := retrieval.ParseReport(string(data))
+	require.NoError(t, err)
+	for _, r := range retrieval.Compare(baseline, report, 0) {
+		t.Error(r)
+	}
+	assert.Equal(t, baseline, report, "The baseline should be saved again when retrieval improves")
+}
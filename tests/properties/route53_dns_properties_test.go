@@ -0,0 +1,147 @@
+package properties
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/dnszone"
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	corpZone        = "environments/app-layer/bedrock-rag:aws_route53_zone.corp"
+	corpZoneLookup  = "environments/network-layer:data.aws_route53_zone.private"
+	inboundResolver = "environments/network-layer:aws_route53_resolver_endpoint.inbound"
+	seoulVPC        = "environments/network-layer/module.vpc_frontend:aws_vpc.main"
+	virginiaVPC     = "environments/network-layer/module.vpc_us:aws_vpc.main"
+
+	llmDomain   = "llm.corp.bos-semi.com"
+	mcpDomain   = "mcp.corp.bos-semi.com"
+	quickDomain = "quick.rag.corp.bos-semi.com"
+	ragDomain   = "rag.corp.bos-semi.com"
+)
+
+// executeAPIENIs are the ENI addresses of the Frontend execute-api endpoint,
+// as recorded in llm-gateway-dns.tf.
+var executeAPIENIs = map[string][]string{
+	frontendExecuteAPIEP: {"10.10.1.21", "10.10.2.75"},
+}
+
+func loadDNSModel(t *testing.T, opts dnszone.Options) (*dnszone.Model, *routing.Network) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	n, err := routing.Build(tree, routing.Options{OnPremPrefixes: onPremBGPPrefixes})
+	require.NoError(t, err)
+	md := dnszone.Build(tree, n, opts)
+	require.Empty(t, md.Unresolved, "Every zone, record and Resolver endpoint should resolve")
+	return md, n
+}
+
+// TestRoute53_PrivateZone verifies that corp.bos-semi.com is a single
+// private zone associated with the Seoul VPC, which the QuickSight record in
+// network-layer reaches through its lookup.
+func TestRoute53_PrivateZone(t *testing.T) {
+	t.Parallel()
+
+	md, n := loadDNSModel(t, dnszone.Options{})
+	zone := md.Zone(corpZone)
+	require.NotNil(t, zone)
+	assert.Equal(t, "corp.bos-semi.com", zone.Name)
+	assert.True(t, zone.Private)
+	assert.Equal(t, []*routing.VPC{n.VPC(seoulVPC)}, zone.VPCs)
+	assert.Same(t, zone, md.Zone(corpZoneLookup), "The network-layer lookup should find the bedrock-rag zone")
+
+	var names []string
+	for _, r := range zone.Records {
+		names = append(names, r.Name)
+	}
+	assert.ElementsMatch(t, []string{llmDomain, mcpDomain, quickDomain, ragDomain}, names)
+
+	require.Len(t, md.Inbound(), 1)
+	assert.Same(t, n.VPC(seoulVPC), md.Inbound()[0].VPC, "On-premises queries should enter through the Seoul VPC")
+}
+
+// TestRoute53_NamesResolveFromEachVantagePoint resolves the service names
+// from the Seoul VPC, from the Virginia VPC, and from on-premises through
+// the inbound Resolver endpoint. The zone is only associated with the Seoul
+// VPC: Virginia workloads do not use these names, so they get no private
+// answer there.
+func TestRoute53_NamesResolveFromEachVantagePoint(t *testing.T) {
+	t.Parallel()
+
+	md, n := loadDNSModel(t, dnszone.Options{Addresses: executeAPIENIs})
+	seoul, virginia := n.VPC(seoulVPC), n.VPC(virginiaVPC)
+	require.NotNil(t, seoul)
+	require.NotNil(t, virginia)
+	inbound := md.Resolver(inboundResolver)
+	require.NotNil(t, inbound)
+
+	for _, name := range []string{llmDomain, mcpDomain, quickDomain, ragDomain} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a := md.Resolve(seoul, name)
+			assert.Equal(t, dnszone.FromZone, a.Source, "%s", a)
+			assert.True(t, a.In(seoul), "From the Seoul VPC %s should be a Seoul VPC address", a)
+			for _, ip := range a.Addresses {
+				assert.True(t, ip.IsPrivate(), "%s should be private", ip)
+			}
+
+			onPrem := md.ResolveInbound(inbound, name)
+			assert.Equal(t, a.String(), onPrem.String(), "On-premises clients should get the Seoul VPC answer")
+
+			assert.Equal(t, dnszone.Public, md.Resolve(virginia, name).Source,
+				"corp.bos-semi.com is not associated with the Virginia VPC")
+		})
+	}
+
+	assert.Equal(t, md.Resolve(seoul, ragDomain).Addresses, md.Resolve(seoul, llmDomain).Addresses,
+		"llm and rag should both reach the execute-api endpoint")
+}
+
+// TestRoute53_LLMRecordsMatchExecuteAPISubnets verifies, without assuming
+// the ENI addresses, that the literal llm and mcp records name one address
+// in each subnet of the execute-api endpoint they stand in for.
+func TestRoute53_LLMRecordsMatchExecuteAPISubnets(t *testing.T) {
+	t.Parallel()
+
+	md, _ := loadDNSModel(t, dnszone.Options{})
+	var endpoint *dnszone.Target
+	for _, r := range md.Records {
+		if r.Name == ragDomain && len(r.Targets) == 1 {
+			endpoint = r.Targets[0]
+		}
+	}
+	require.NotNil(t, endpoint, "rag.corp.bos-semi.com should alias the execute-api endpoint")
+	assert.Equal(t, frontendExecuteAPIEP, endpoint.ID)
+	require.NotEmpty(t, endpoint.Subnets)
+
+	for _, r := range md.Records {
+		if r.Name != llmDomain && r.Name != mcpDomain {
+			continue
+		}
+		used := map[string]bool{}
+		for _, v := range r.Values {
+			ip := net.ParseIP(v)
+			require.NotNil(t, ip, "%s: %s should be an address", r.Name, v)
+			var subnet *net.IPNet
+			for _, s := range endpoint.Subnets {
+				if s.Contains(ip) {
+					subnet = s
+				}
+			}
+			if assert.NotNil(t, subnet, "%s: %s is in no subnet of the execute-api endpoint", r.Name, v) {
+				assert.False(t, used[subnet.String()], "%s: two addresses in %s", r.Name, subnet)
+				used[subnet.String()] = true
+			}
+		}
+		assert.Len(t, used, len(endpoint.Subnets), "%s should have an address in every endpoint subnet", r.Name)
+	}
+}
@@ -0,0 +1,205 @@
+package properties
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/lifecycle"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	pipelineSource      = "environments/app-layer/bedrock-rag/module.s3_pipeline:aws_s3_bucket.source"
+	pipelineDestination = "environments/app-layer/bedrock-rag/module.s3_pipeline:aws_s3_bucket.destination"
+	rtlCodesBucket      = "environments/app-layer/bedrock-rag:aws_s3_bucket.rtl_codes"
+	quicksightBucket    = "environments/app-layer/quicksight:aws_s3_bucket.quicksight_data"
+	kiroLogsBucket      = "environments/kiro-subscription:aws_s3_bucket.kiro_prompts_logs"
+
+	noncurrentGlacierMinimum = "noncurrent versions are billed the 90-day GLACIER minimum; shortening the transition or lengthening the expiration changes the document retention and needs sign-off"
+	uploadTieringOnly        = "kept for objects uploaded as INTELLIGENT_TIERING; the lifecycle rule moves STANDARD uploads to GLACIER instead"
+)
+
+// knownLifecycleFindings waives lifecycle findings, keyed by Finding.Key().
+var knownLifecycleFindings = map[string]string{
+	pipelineSource + " noncurrent-expiration":      noncurrentGlacierMinimum,
+	pipelineDestination + " noncurrent-expiration": noncurrentGlacierMinimum,
+	pipelineSource + " intelligent-tiering":        uploadTieringOnly,
+	pipelineDestination + " intelligent-tiering":   uploadTieringOnly,
+}
+
+// documentUploads is a year of document uploads: 1,000 2 MB documents and
+// 1,000 16 KB sidecar files a month, and the noncurrent versions 100
+// re-uploads a month leave behind.
+func documentUploads() []lifecycle.Cohort {
+	var out []lifecycle.Cohort
+	for month := 0; month < 12; month++ {
+		age := 30 * month
+		out = append(out,
+			lifecycle.Cohort{Key: "documents/report.pdf", Count: 1000, Size: 2 << 20, Age: age},
+			lifecycle.Cohort{Key: "documents/report.pdf.metadata.json", Count: 1000, Size: 16 << 10, Age: age},
+		)
+		if age > 0 {
+			out = append(out, lifecycle.Cohort{Key: "documents/report.pdf", Count: 100, Size: 2 << 20, Age: age, Replaced: age / 2})
+		}
+	}
+	return out
+}
+
+// bucketPrices returns the prices of the region a bucket is in: the
+// pipeline destination replicates to Virginia, the others are in Seoul.
+func bucketPrices(id string) lifecycle.Prices {
+	if id == pipelineDestination {
+		return lifecycle.VirginiaPrices
+	}
+	return lifecycle.SeoulPrices
+}
+
+func loadLifecycle(t *testing.T) (*tfconfig.Tree, *lifecycle.Model) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := lifecycle.Build(tree)
+	require.Empty(t, md.Unresolved, "Every lifecycle, Intelligent-Tiering and object lock configuration should configure a bucket of the tree")
+	return tree, md
+}
+
+// TestS3Lifecycle_Buckets checks that every bucket with storage-class
+// settings is found, and that the locked RTL bucket has no rule that could
+// expire versions under retention.
+func TestS3Lifecycle_Buckets(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadLifecycle(t)
+	require.Equal(t, []string{pipelineDestination, pipelineSource, rtlCodesBucket, quicksightBucket, kiroLogsBucket}, md.Buckets)
+
+	rtl := md.Bucket(rtlCodesBucket)
+	assert.Equal(t, &lifecycle.Lock{Mode: "GOVERNANCE", Days: 365}, rtl.Lock)
+	assert.Empty(t, rtl.Rules)
+
+	for _, id := range []string{pipelineSource, pipelineDestination} {
+		b := md.Bucket(id)
+		assert.Empty(t, b.Unknown, id)
+		require.Len(t, b.Rules, 1, id)
+		assert.Equal(t, []lifecycle.Transition{{Days: 90, StorageClass: lifecycle.Glacier}, {Days: 180, StorageClass: lifecycle.DeepArchive}},
+			b.Rules[0].Transitions, id)
+	}
+}
+
+// TestS3Lifecycle_Projection projects a year of document uploads on every
+// bucket with lifecycle rules, today and a year from now, and logs the
+// monthly storage cost of each.
+func TestS3Lifecycle_Projection(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadLifecycle(t)
+	dist := documentUploads()
+	total := 0
+	for _, c := range dist {
+		total += c.Count
+	}
+
+	for _, id := range md.Buckets {
+		b := md.Bucket(id)
+		if len(b.Rules) == 0 {
+			continue
+		}
+		for _, day := range []int{0, 365} {
+			p := b.Project(dist, day)
+			held := p.Expired
+			for _, u := range p.Usage {
+				held += u.Objects
+			}
+			assert.Equal(t, total, held, "%s on day %d should hold or have expired every object", id, day)
+			t.Logf("%s on day %d: %d objects expired, $%.2f a month", id, day, p.Expired, p.MonthlyCost(bucketPrices(id)))
+		}
+	}
+
+	source := md.Bucket(pipelineSource)
+	now, later := source.Project(dist, 0), source.Project(dist, 365)
+	assert.Equal(t, 3000+12000+100, now.Objects(lifecycle.Standard),
+		"documents younger than 90 days, sidecar files too small to transition and fresh noncurrent versions stay in STANDARD")
+	assert.Equal(t, 600, now.Expired, "noncurrent versions expire after 90 days")
+	assert.Equal(t, 12000, later.Objects(lifecycle.DeepArchive), "a year on, every document is in DEEP_ARCHIVE")
+	assert.Less(t, later.MonthlyCost(lifecycle.SeoulPrices), now.MonthlyCost(lifecycle.SeoulPrices))
+
+	qs := md.Bucket(quicksightBucket).Project(dist, 0)
+	assert.Equal(t, 6000, qs.Usage[lifecycle.Placement{Class: lifecycle.IntelligentTiering, Tier: lifecycle.ArchiveInstantAccess}].Objects,
+		"documents moved to INTELLIGENT_TIERING at day 90 reach Archive Instant Access 90 days later")
+
+	logs := md.Bucket(kiroLogsBucket).Project(dist, 365)
+	assert.Equal(t, total, logs.Expired, "access logs are deleted after log_retention_days")
+	assert.Zero(t, logs.MonthlyCost(lifecycle.SeoulPrices))
+}
+
+// TestS3Lifecycle_Check checks transitions against minimum storage
+// durations, noncurrent version expiration, object lock retention and
+// Intelligent-Tiering configurations of every bucket, unless waived.
+func TestS3Lifecycle_Check(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadLifecycle(t)
+	checkWaived(t, md.Check(), knownLifecycleFindings)
+}
+
+// TestS3Lifecycle_CheckMatchesProjectionForAnyTransitionDays sets the transition
+// days of the s3-pipeline module to any values its variable validation
+// accepts, and checks that Check reports a transition finding exactly when
+// the projection shows documents leaving GLACIER before its minimum storage
+// duration. The validation does not order the two variables, so values
+// that cut the stay short are expected.
+func TestS3Lifecycle_CheckMatchesProjectionForAnyTransitionDays(t *testing.T) {
+	tree, md := loadLifecycle(t)
+	var modules []*tfconfig.Module
+	for _, m := range tree.Modules {
+		if m.Var("lifecycle_glacier_transition_days") != cty.NilVal {
+			modules = append(modules, m)
+		}
+	}
+	require.NotEmpty(t, modules)
+	restoreVars(t, modules...)
+	doc := lifecycle.Cohort{Key: "documents/report.pdf", Count: 1, Size: 2 << 20}
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("GLACIER stays are reported when they are too short", prop.ForAll(
+		func(glacier, deep int) string {
+			for _, m := range modules {
+				setVars(m, map[string]cty.Value{
+					"lifecycle_glacier_transition_days":      cty.NumberIntVal(int64(glacier)),
+					"lifecycle_deep_archive_transition_days": cty.NumberIntVal(int64(deep)),
+				})
+			}
+			reported := map[string]bool{}
+			for _, f := range md.Check() {
+				if f.Check == lifecycle.CheckTransition {
+					reported[f.Subject] = true
+				}
+			}
+			for _, id := range []string{pipelineSource, pipelineDestination} {
+				b := md.Bucket(id)
+				stay := 0
+				for day := 0; day <= glacier+deep; day++ {
+					if p, _ := b.Place(doc, day); p.Class == lifecycle.Glacier {
+						stay++
+					}
+				}
+				short := stay < lifecycle.MinimumDuration[lifecycle.Glacier]
+				if short != reported[id] {
+					return fmt.Sprintf("%s: documents stay %d days in GLACIER, transition finding reported: %v", id, stay, reported[id])
+				}
+			}
+			return ""
+		},
+		gen.IntRange(30, 365),
+		gen.IntRange(90, 730),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
@@ -0,0 +1,74 @@
+package properties
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/replication"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	seoulToVirginia = "environments/app-layer/bedrock-rag:aws_s3_bucket_replication_configuration.seoul_to_virginia"
+	rtlToVirginia   = "environments/app-layer/bedrock-rag:aws_s3_bucket_replication_configuration.rtl_seoul_to_virginia"
+	toolGuideToUS   = "environments/app-layer/bedrock-rag:aws_s3_bucket_replication_configuration.tool_guide_seoul_to_virginia"
+	pipelineToUS    = "environments/app-layer/bedrock-rag/module.s3_pipeline:aws_s3_bucket_replication_configuration.source_to_destination"
+
+	virginiaBucketPending = "staged with count = 0 until the Virginia bucket, named after the account ID, is created outside this tree"
+)
+
+// knownReplicationFindings waives replication findings, keyed by
+// Finding.Key().
+var knownReplicationFindings = map[string]string{
+	rtlToVirginia + " versioning": virginiaBucketPending,
+	toolGuideToUS + " versioning": virginiaBucketPending,
+}
+
+func loadReplication(t *testing.T) *replication.Model {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := replication.Build(tree)
+	require.Empty(t, md.Unresolved, "Every replication source and destination should be statically known")
+	return md
+}
+
+// TestS3Replication_Configurations checks that every replication
+// configuration is found, and that destinations written by name resolve to
+// the buckets of the tree they name.
+func TestS3Replication_Configurations(t *testing.T) {
+	t.Parallel()
+
+	md := loadReplication(t)
+	ids := map[string]*replication.Config{}
+	for _, c := range md.Configs {
+		ids[c.ID] = c
+	}
+	require.Len(t, ids, 4)
+	for _, id := range []string{seoulToVirginia, rtlToVirginia, toolGuideToUS, pipelineToUS} {
+		require.Contains(t, ids, id)
+	}
+	assert.True(t, ids[rtlToVirginia].Staged)
+	assert.True(t, ids[toolGuideToUS].Staged)
+
+	for _, id := range []string{seoulToVirginia, pipelineToUS} {
+		c := ids[id]
+		assert.False(t, c.Staged, id)
+		require.Equal(t, []string{"arn:aws:s3:::bos-ai-documents-us"}, c.Destinations(), id)
+		dst, ok := md.Bucket(c.Destinations()[0])
+		require.True(t, ok, "%s should replicate to a bucket of the tree", id)
+		assert.Equal(t, "environments/app-layer/bedrock-rag/module.s3_pipeline:aws_s3_bucket.destination", dst.String())
+	}
+}
+
+// TestS3Replication_Check checks rule priorities and filters, versioning on
+// both sides, SSE-KMS selection and replica keys, delete marker replication
+// and the replication role policies of every configuration, unless waived.
+func TestS3Replication_Check(t *testing.T) {
+	t.Parallel()
+
+	checkWaived(t, loadReplication(t).Check(), knownReplicationFindings)
+}
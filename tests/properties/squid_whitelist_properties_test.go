@@ -0,0 +1,176 @@
+package properties
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/squid"
+)
+
+// onPremClient is a host in the on-prem closed network that uses the proxy.
+var onPremClient = net.ParseIP("192.128.1.208")
+
+// llmProviderHosts are the hosts the gateway clients reach through Squid,
+// one per whitelist entry.
+var llmProviderHosts = []string{
+	"api.openai.com",
+	"api.anthropic.com",
+	"kiro.dev",
+	"prod.us-east-1.auth.desktop.kiro.dev",
+	"amazoncognito.com",
+	"bos-ai.auth.ap-northeast-2.amazoncognito.com",
+}
+
+// blockedHosts must never be reachable through the proxy: other providers,
+// lookalikes of whitelisted entries, code and paste hosts, and raw IPs.
+var blockedHosts = []string{
+	"example.com",
+	"openai.com",
+	"chat.openai.com",
+	"v2.api.openai.com",
+	"api.openai.com.attacker.net",
+	"evil-api.openai.com",
+	"anthropic.com",
+	"console.anthropic.com",
+	"api.anthropic.com.evil.io",
+	"notkiro.dev",
+	"kiro.dev.attacker.net",
+	"amazoncognito.com.evil.io",
+	"generativelanguage.googleapis.com",
+	"api.mistral.ai",
+	"huggingface.co",
+	"github.com",
+	"raw.githubusercontent.com",
+	"pastebin.com",
+	"pypi.org",
+	"s3.amazonaws.com",
+	"169.254.169.254",
+	"8.8.8.8",
+}
+
+func loadSquidConfig(t *testing.T) *squid.Config {
+	t.Helper()
+
+	script := renderUserData(t, "squid-user-data.sh.tpl")
+	c, err := squid.FromUserData(script.Text)
+	require.NoError(t, err)
+	return c
+}
+
+// TestSquidWhitelist_Config verifies the parsed ACL set: one listening port,
+// the whitelist file and the CONNECT restriction to SSL ports.
+func TestSquidWhitelist_Config(t *testing.T) {
+	t.Parallel()
+
+	c := loadSquidConfig(t)
+	assert.Equal(t, []int{3128}, c.Ports)
+	assert.ElementsMatch(t, []string{".kiro.dev", "api.openai.com", "api.anthropic.com", ".amazoncognito.com"}, c.Domains())
+	assert.Equal(t, []string{"443"}, c.ACLs["SSL_ports"].Values)
+
+	// Every whitelist entry is exercised by llmProviderHosts.
+	for _, d := range c.Domains() {
+		covered := false
+		for _, h := range llmProviderHosts {
+			covered = covered || squid.DomainMatches(d, h)
+		}
+		assert.True(t, covered, "llmProviderHosts should include a host for %s", d)
+	}
+}
+
+// TestSquidWhitelist_Evaluate checks whitelisted and blocked hosts, ports and
+// client networks against the ACLs.
+func TestSquidWhitelist_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	c := loadSquidConfig(t)
+	connect := func(src net.IP, host string, port int) squid.Request {
+		return squid.Request{Src: src, Method: http.MethodConnect, Host: host, Port: port}
+	}
+
+	for _, h := range llmProviderHosts {
+		allowed, _ := c.Check(connect(onPremClient, h, 443))
+		assert.True(t, allowed, "CONNECT %s:443 should be allowed", h)
+
+		allowed, rule := c.Check(connect(onPremClient, h, 80))
+		assert.False(t, allowed, "CONNECT %s:80 should be denied", h)
+		require.NotNil(t, rule)
+		assert.Equal(t, "http_access deny CONNECT !SSL_ports", rule.Line)
+	}
+	for _, h := range blockedHosts {
+		allowed, rule := c.Check(connect(onPremClient, h, 443))
+		assert.False(t, allowed, "CONNECT %s:443 should be denied", h)
+		require.NotNil(t, rule)
+		assert.Equal(t, "http_access deny all", rule.Line)
+	}
+
+	// Only the on-prem network may use the proxy, including from the VPCs.
+	for _, src := range []string{"10.10.1.10", "10.200.1.10", "203.0.113.10"} {
+		allowed, rule := c.Check(connect(net.ParseIP(src), "api.openai.com", 443))
+		assert.False(t, allowed, "%s should not be able to use the proxy", src)
+		require.NotNil(t, rule)
+		assert.Equal(t, "http_access deny !onprem_network", rule.Line)
+	}
+}
+
+// TestSquidWhitelist_Proxy runs the allow/deny suite through an in-process
+// CONNECT proxy built from the same ACLs, with upstreams replaced by local
+// echo connections.
+func TestSquidWhitelist_Proxy(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	dialed := map[string]bool{}
+	p := &squid.Proxy{
+		Config: loadSquidConfig(t),
+		Src:    func(*http.Request) net.IP { return onPremClient },
+		Dial: func(_ context.Context, _, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed[addr] = true
+			mu.Unlock()
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				_, _ = io.Copy(server, server)
+			}()
+			return client, nil
+		},
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, h := range llmProviderHosts {
+		status, conn, err := squid.Connect(addr, net.JoinHostPort(h, "443"))
+		require.NoError(t, err)
+		if assert.Equal(t, http.StatusOK, status, "CONNECT %s:443", h) {
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+			conn.Close()
+		}
+	}
+	for _, h := range blockedHosts {
+		status, _, err := squid.Connect(addr, net.JoinHostPort(h, "443"))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, status, "CONNECT %s:443", h)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, dialed, len(llmProviderHosts), "only whitelisted hosts should reach an upstream")
+	for _, h := range blockedHosts {
+		assert.False(t, dialed[net.JoinHostPort(h, "443")], "%s should not be dialed", h)
+	}
+}
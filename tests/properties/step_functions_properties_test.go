@@ -0,0 +1,280 @@
+package properties
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/asl"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+)
+
+const (
+	analysisOrchestrator = "environments/app-layer/bedrock-rag:aws_sfn_state_machine.analysis_orchestrator"
+	rtlParserARN         = "${aws_lambda_function.rtl_parser.arn}"
+
+	sfnDynamoDBUnused = "sfn_dynamodb grants rag-extraction-tasks access for status tracking the orchestrator does not do yet; no state has a DynamoDB task"
+)
+
+// knownStepFunctionFindings waives consistency findings, keyed by
+// Finding.Key().
+var knownStepFunctionFindings = map[string]string{
+	analysisOrchestrator + " aws_iam_role_policy.sfn_dynamodb extra-permission": sfnDynamoDBUnused,
+}
+
+// analysisStages are the Task states of the analysis orchestrator in
+// order, with the prefix of their <prefix>_result and <prefix>_error
+// fields.
+var analysisStages = []struct {
+	state, field string
+}{
+	{"HierarchyExtraction", "hierarchy"},
+	{"ClockDomainAnalysis", "clock_domain"},
+	{"DataflowTracking", "dataflow"},
+	{"TopicClassification", "topic"},
+	{"ClaimGeneration", "claim"},
+	{"HDDGeneration", "hdd"},
+}
+
+// analysisInput is an execution input with every field the stages read.
+func analysisInput() map[string]interface{} {
+	return map[string]interface{}{
+		"pipeline_id":   "p-20260101",
+		"chip_type":     "soc-a",
+		"snapshot_date": "2026-01-01",
+		"s3_prefix":     "rtl/soc-a/2026-01-01/",
+	}
+}
+
+// analysisStubs returns a Lambda result per stage that echoes the stage
+// name the state sends.
+func analysisStubs(failures map[string][]asl.Failure) asl.TaskFunc {
+	return func(st *asl.State, params interface{}, attempt int) (interface{}, error) {
+		if fs := failures[st.Name]; attempt <= len(fs) {
+			f := fs[attempt-1]
+			return nil, &f
+		}
+		payload := params.(map[string]interface{})["Payload"].(map[string]interface{})
+		return map[string]interface{}{
+			"ExecutedVersion": "$LATEST",
+			"StatusCode":      float64(200),
+			"Payload":         map[string]interface{}{"stage": payload["stage"], "pipeline_id": payload["pipeline_id"], "status": "completed"},
+		}, nil
+	}
+}
+
+func loadStateMachines(t *testing.T) *asl.Model {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := asl.Build(tree)
+	require.Empty(t, md.Unresolved, "Every state machine definition should be statically known")
+	return md
+}
+
+func analysisDefinition(t *testing.T) *asl.StateMachine {
+	t.Helper()
+
+	mc := loadStateMachines(t).Machine(analysisOrchestrator)
+	require.NotNil(t, mc, "The analysis orchestrator should exist")
+	return mc.Definition
+}
+
+// TestStepFunctions_DefinitionsAreValid checks every state machine in the
+// tree for broken transitions, unreachable or non-terminating states,
+// invalid paths and misordered Retry and Catch.
+func TestStepFunctions_DefinitionsAreValid(t *testing.T) {
+	t.Parallel()
+
+	md := loadStateMachines(t)
+	require.NotEmpty(t, md.Machines)
+	for _, mc := range md.Machines {
+		mc := mc
+		t.Run(mc.ID, func(t *testing.T) {
+			t.Parallel()
+
+			for _, p := range mc.Definition.Validate() {
+				t.Errorf("%s", p)
+			}
+		})
+	}
+}
+
+// TestStepFunctions_AnalysisOrchestratorShape checks that every stage is a
+// Lambda task that retries and then skips to the next stage on failure.
+func TestStepFunctions_AnalysisOrchestratorShape(t *testing.T) {
+	t.Parallel()
+
+	sm := analysisDefinition(t)
+	assert.Equal(t, analysisStages[0].state, sm.StartAt)
+	require.Len(t, sm.States, len(analysisStages)+1)
+
+	for i, stage := range analysisStages {
+		st := sm.States[stage.state]
+		require.NotNil(t, st, stage.state)
+		next := "PipelineComplete"
+		if i+1 < len(analysisStages) {
+			next = analysisStages[i+1].state
+		}
+
+		assert.Equal(t, "Task", st.Type, stage.state)
+		assert.Equal(t, "arn:aws:states:::lambda:invoke", st.Resource, stage.state)
+		assert.Equal(t, next, st.Next, stage.state)
+		assert.Equal(t, "$."+stage.field+"_result", st.ResultPath, stage.state)
+		require.Len(t, st.Retry, 1, stage.state)
+		assert.Equal(t, 2, st.Retry[0].MaxAttempts, stage.state)
+		require.Len(t, st.Catch, 1, stage.state)
+		assert.Equal(t, next, st.Catch[0].Next, "%s should skip to the next stage on failure", stage.state)
+		assert.Equal(t, "$."+stage.field+"_error", st.Catch[0].ResultPath, stage.state)
+	}
+	assert.Equal(t, "Succeed", sm.States["PipelineComplete"].Type)
+}
+
+func TestStepFunctions_AnalysisOrchestratorRuns(t *testing.T) {
+	t.Parallel()
+
+	sm := analysisDefinition(t)
+	var path []string
+	for _, stage := range analysisStages {
+		path = append(path, stage.state)
+	}
+	path = append(path, "PipelineComplete")
+
+	t.Run("every stage succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		ex, err := sm.Simulate(analysisInput(), analysisStubs(nil))
+		require.NoError(t, err)
+		require.Equal(t, asl.Succeeded, ex.Status, ex.String())
+		assert.Equal(t, path, ex.Path())
+
+		out := ex.Output.(map[string]interface{})
+		for k, v := range analysisInput() {
+			assert.Equal(t, v, out[k], "The input field %s should be kept", k)
+		}
+		for _, stage := range analysisStages {
+			result, ok := out[stage.field+"_result"].(map[string]interface{})
+			require.True(t, ok, "%s should record its result", stage.state)
+			payload := result["Payload"].(map[string]interface{})
+			assert.Equal(t, "p-20260101", payload["pipeline_id"])
+			assert.NotEmpty(t, payload["stage"], "%s should tell the parser which stage to run", stage.state)
+		}
+		doc, err := json.MarshalIndent(ex.Output, "", "  ")
+		require.NoError(t, err)
+		t.Logf("%s\n%s", ex, doc)
+	})
+
+	t.Run("a transient failure is retried", func(t *testing.T) {
+		t.Parallel()
+
+		ex, err := sm.Simulate(analysisInput(), analysisStubs(map[string][]asl.Failure{
+			"DataflowTracking": {{Name: "Lambda.TooManyRequestsException", Cause: "Rate exceeded"}},
+		}))
+		require.NoError(t, err)
+		require.Equal(t, asl.Succeeded, ex.Status, ex.String())
+		assert.Equal(t, path, ex.Path())
+		assert.Contains(t, ex.String(), "DataflowTracking (Lambda.TooManyRequestsException, retry in 10s) -> DataflowTracking -> TopicClassification")
+		assert.Contains(t, ex.Output, "dataflow_result")
+		assert.NotContains(t, ex.Output, "dataflow_error")
+	})
+
+	t.Run("a stage that keeps failing is skipped", func(t *testing.T) {
+		t.Parallel()
+
+		fail := asl.Failure{Name: "Lambda.Unknown", Cause: "parser crashed"}
+		ex, err := sm.Simulate(analysisInput(), analysisStubs(map[string][]asl.Failure{
+			"TopicClassification": {fail, fail, fail},
+		}))
+		require.NoError(t, err)
+		require.Equal(t, asl.Succeeded, ex.Status, ex.String())
+		assert.Contains(t, ex.String(),
+			"TopicClassification (Lambda.Unknown, retry in 10s) -> TopicClassification (Lambda.Unknown, retry in 20s) -> TopicClassification (Lambda.Unknown, caught) -> ClaimGeneration")
+
+		out := ex.Output.(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"Error": "Lambda.Unknown", "Cause": "parser crashed"}, out["topic_error"])
+		assert.NotContains(t, out, "topic_result")
+		t.Log(ex)
+	})
+}
+
+// TestStepFunctions_AnalysisOrchestratorSkipsFailedStages checks, for any
+// set of stages that fail for good, that the pipeline still completes with
+// an error recorded for exactly those stages and a result for the others.
+func TestStepFunctions_AnalysisOrchestratorSkipsFailedStages(t *testing.T) {
+	sm := analysisDefinition(t)
+	properties := gopter.NewProperties(nil)
+
+	properties.Property("failed stages are skipped and recorded", prop.ForAll(
+		func(failing []bool) bool {
+			failures := map[string][]asl.Failure{}
+			for i, stage := range analysisStages {
+				if failing[i] {
+					failures[stage.state] = []asl.Failure{{Name: "States.TaskFailed", Cause: stage.state}, {Name: "States.TaskFailed"}, {Name: "States.TaskFailed"}}
+				}
+			}
+			ex, err := sm.Simulate(analysisInput(), analysisStubs(failures))
+			if err != nil || ex.Status != asl.Succeeded {
+				return false
+			}
+			out := ex.Output.(map[string]interface{})
+			for i, stage := range analysisStages {
+				_, hasResult := out[stage.field+"_result"]
+				_, hasError := out[stage.field+"_error"]
+				if hasError != failing[i] || hasResult == failing[i] {
+					return false
+				}
+			}
+			return true
+		},
+		gen.SliceOfN(len(analysisStages), gen.Bool()),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
+
+// TestStepFunctions_RoleAllowsExactlyTheIntegrations lists the service
+// calls of the analysis orchestrator and checks that its role allows
+// exactly those calls on those resources, plus log delivery, unless waived.
+func TestStepFunctions_RoleAllowsExactlyTheIntegrations(t *testing.T) {
+	t.Parallel()
+
+	md := loadStateMachines(t)
+	mc := md.Machine(analysisOrchestrator)
+	require.NotNil(t, mc)
+	require.Len(t, mc.Roles, 1)
+	assert.Equal(t, "aws_iam_role.sfn_analysis", mc.Roles[0].Addr)
+
+	integrations := mc.Definition.Integrations()
+	require.Len(t, integrations, len(analysisStages))
+	for _, in := range integrations {
+		assert.Equal(t, "lambda:InvokeFunction", in.Action, in.State)
+		assert.Equal(t, rtlParserARN, in.Target, "%s should invoke the RTL parser", in.State)
+	}
+	assert.Contains(t, mc.Required(), asl.Grant{Action: "lambda:InvokeFunction", Resource: rtlParserARN})
+
+	checkWaived(t, md.Consistency(), knownStepFunctionFindings)
+}
+
+// TestStepFunctions_ErrorRateAlarmWatchesOrchestrator checks that the
+// error rate alarm computes its rate from this state machine's metrics.
+func TestStepFunctions_ErrorRateAlarmWatchesOrchestrator(t *testing.T) {
+	t.Parallel()
+
+	mc := loadStateMachines(t).Machine(analysisOrchestrator)
+	require.NotNil(t, mc)
+	var alarm *asl.Alarm
+	for _, a := range mc.Alarms {
+		if a.ID == "environments/app-layer/bedrock-rag:aws_cloudwatch_metric_alarm.analysis_error_rate" {
+			alarm = a
+		}
+	}
+	require.NotNil(t, alarm, "analysis_error_rate should watch the analysis orchestrator")
+	assert.ElementsMatch(t, []string{"ExecutionsFailed", "ExecutionsStarted"}, alarm.Metrics,
+		"Both terms of the error rate should come from the orchestrator")
+}
@@ -0,0 +1,225 @@
+package properties
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/litellm"
+	"github.com/bos-ai/infrastructure/tests/internal/nginx"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/userdata"
+)
+
+const (
+	nginxConfPath   = "/etc/nginx/conf.d/llm-gateway.conf"
+	mcpUnitPath     = "/etc/systemd/system/mcp-server.service"
+	litellmConfPath = "/data/litellm/config.yaml"
+
+	// litellmTemplate is not referenced by any templatefile call; LiteLLM is
+	// deployed by hand from it, so it is rendered with placeholder values.
+	litellmTemplate = "../../environments/app-layer/bedrock-rag/templates/litellm-user-data.sh.tpl"
+)
+
+// templateCallSites lists every templatefile call in the tree by template
+// file name, with the block that makes it.
+var templateCallSites = map[string]string{
+	"squid-user-data.sh.tpl":      "environments/network-layer:aws_launch_template.squid_proxy",
+	"mcp-server-user-data.sh.tpl": "environments/app-layer/bedrock-rag:aws_launch_template.mcp_server",
+	"nginx-user-data.sh.tpl":      "environments/app-layer/bedrock-rag:aws_launch_template.nginx",
+}
+
+func loadCallSites(t *testing.T) map[string]userdata.CallSite {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err)
+	sites, errs := userdata.CallSites(tree)
+	require.Empty(t, errs)
+
+	byTemplate := map[string]userdata.CallSite{}
+	for _, s := range sites {
+		name := filepath.Base(s.Template)
+		require.NotContains(t, byTemplate, name, "%s is rendered from more than one call site", name)
+		byTemplate[name] = s
+	}
+	return byTemplate
+}
+
+// renderUserData renders a template through its templatefile call site and
+// parses the resulting script.
+func renderUserData(t *testing.T, template string) *userdata.Script {
+	t.Helper()
+
+	site, ok := loadCallSites(t)[template]
+	require.True(t, ok, "no templatefile call renders %s", template)
+	out, err := site.Render()
+	require.NoError(t, err)
+	return userdata.ParseScript(out)
+}
+
+// TestTemplatefile_CallSitesRender verifies every templatefile call resolves
+// to an existing template and renders with the variables it is passed.
+func TestTemplatefile_CallSitesRender(t *testing.T) {
+	t.Parallel()
+
+	sites := loadCallSites(t)
+	got := map[string]string{}
+	for name, s := range sites {
+		got[name] = s.Module.Name + ":" + s.Block.Address()
+	}
+	assert.Equal(t, templateCallSites, got)
+
+	for name, s := range sites {
+		out, err := s.Render()
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.NotContains(t, out, tfconfig.Unknown, "%s should render from static values", name)
+		assert.NotEmpty(t, userdata.ParseScript(out).Files, "%s should write its configuration files", name)
+	}
+}
+
+// TestTemplatefile_NginxUpstreams checks the reverse proxy on-prem clients
+// use: TLS on 443 for each gateway host, proxied to the API Gateway stage
+// passed to the template, and a catch-all that drops unknown hosts.
+func TestTemplatefile_NginxUpstreams(t *testing.T) {
+	t.Parallel()
+
+	c, apigwHost := loadNginxConfig(t)
+	require.Len(t, c.Servers, 3)
+
+	upstreams := map[string]string{
+		llmGatewayHost: "/prod/llm/",
+		mcpGatewayHost: "/prod/mcp/",
+	}
+	for _, s := range c.Servers {
+		name := strings.Join(s.Names, ",")
+		var certs []string
+		for _, d := range s.Directives {
+			if d.Name == "ssl_certificate" {
+				certs = append(certs, d.Args...)
+			}
+		}
+		assert.Equal(t, []string{"/etc/nginx/ssl/server.crt"}, certs, name)
+		if len(s.Names) == 0 {
+			assert.Equal(t, []nginx.Listen{{Port: 443, SSL: true, Default: true}}, s.Listen)
+			assert.Equal(t, 444, s.Return, "unknown hosts should be dropped")
+			continue
+		}
+		assert.Equal(t, []nginx.Listen{{Port: 443, SSL: true}}, s.Listen, name)
+
+		path, ok := upstreams[name]
+		if !assert.True(t, ok, "unexpected server %s", name) {
+			continue
+		}
+		loc := s.Location("/")
+		require.NotNil(t, loc, name)
+		require.NotNil(t, loc.ProxyPass, name)
+		assert.Equal(t, "https", loc.ProxyPass.Scheme, name)
+		assert.Equal(t, apigwHost, loc.ProxyPass.Host, name)
+		assert.Equal(t, path, loc.ProxyPass.Path, name)
+		assert.Contains(t, loc.Headers, nginx.Header{Name: "Host", Value: apigwHost}, name)
+		assert.True(t, loc.SSLServerName, name)
+		delete(upstreams, name)
+	}
+	assert.Empty(t, upstreams, "every gateway host should have a server block")
+
+	// MCP uses long-lived streaming connections.
+	loc := c.Server(mcpGatewayHost, 443).Location("/")
+	assert.Contains(t, loc.Headers, nginx.Header{Name: "Upgrade", Value: "$http_upgrade"})
+	assert.Equal(t, time.Hour, loc.ReadTimeout)
+}
+
+// TestTemplatefile_MCPServerUnit checks the systemd unit written for the MCP
+// server.
+func TestTemplatefile_MCPServerUnit(t *testing.T) {
+	t.Parallel()
+
+	site := loadCallSites(t)["mcp-server-user-data.sh.tpl"]
+	script := renderUserData(t, "mcp-server-user-data.sh.tpl")
+	unitText, ok := script.Files[mcpUnitPath]
+	require.True(t, ok, "user data should write %s", mcpUnitPath)
+	unit := userdata.ParseUnit(unitText)
+
+	assert.Equal(t, "network.target", unit.Get("Unit", "After"))
+	assert.Equal(t, "/usr/local/bin/node /opt/mcp-server/server.js", unit.Get("Service", "ExecStart"))
+	assert.Equal(t, "/opt/mcp-server", unit.Get("Service", "WorkingDirectory"))
+	assert.Equal(t, "always", unit.Get("Service", "Restart"))
+	assert.Equal(t, "multi-user.target", unit.Get("Install", "WantedBy"))
+	assert.ElementsMatch(t, []string{
+		"NODE_ENV=production",
+		"MCP_API_KEY=",
+		"AWS_REGION=" + site.Vars["aws_region"].AsString(),
+	}, unit["Service"]["Environment"])
+	assert.Contains(t, script.Text, "systemctl enable mcp-server")
+}
+
+// renderLiteLLMUserData renders the LiteLLM template with placeholder
+// secret ARNs.
+func renderLiteLLMUserData(t *testing.T) *userdata.Script {
+	t.Helper()
+
+	out, err := userdata.Render(litellmTemplate, map[string]cty.Value{
+		"region":                 cty.StringVal("ap-northeast-2"),
+		"litellm_master_key_arn": cty.StringVal("arn:aws:secretsmanager:ap-northeast-2:123456789012:secret:litellm-master-key"),
+		"postgres_password_arn":  cty.StringVal("arn:aws:secretsmanager:ap-northeast-2:123456789012:secret:postgres-password"),
+	})
+	require.NoError(t, err)
+	return userdata.ParseScript(out)
+}
+
+func loadLiteLLMConfig(t *testing.T) *litellm.Config {
+	t.Helper()
+
+	raw, ok := renderLiteLLMUserData(t).Files[litellmConfPath]
+	require.True(t, ok, "user data should write %s", litellmConfPath)
+	cfg, err := litellm.ParseConfig(raw)
+	require.NoError(t, err)
+	return cfg
+}
+
+// TestTemplatefile_LiteLLMModels renders the LiteLLM template and checks the
+// model list: OpenAI models read their key from the environment, Bedrock
+// models are served from us-east-1.
+func TestTemplatefile_LiteLLMModels(t *testing.T) {
+	t.Parallel()
+
+	_, err := userdata.Render(litellmTemplate, map[string]cty.Value{})
+	require.Error(t, err, "the template needs its variables")
+	assert.Contains(t, err.Error(), "litellm_master_key_arn, postgres_password_arn, region")
+
+	cfg := loadLiteLLMConfig(t)
+	assert.Equal(t, "os.environ/LITELLM_MASTER_KEY", cfg.GeneralSettings.MasterKey)
+
+	var names []string
+	for _, m := range cfg.ModelList {
+		names = append(names, m.Name)
+		p := m.Params
+		switch m.Provider() {
+		case "openai":
+			assert.Equal(t, "os.environ/OPENAI_API_KEY", p.APIKey, m.Name)
+		case "bedrock":
+			assert.Equal(t, "us-east-1", p.AWSRegionName, m.Name)
+			assert.Empty(t, p.APIKey, "%s should use the instance role", m.Name)
+		default:
+			t.Errorf("%s: unexpected provider in %s", m.Name, p.Model)
+		}
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{
+		"claude-3-5-sonnet", "claude-3-haiku", "claude-3-opus",
+		"gpt-4o", "gpt-4o-mini", "o3-mini", "titan-embed-text-v2",
+	}, names)
+
+	// Secrets are fetched at boot and only referenced by docker-compose.
+	compose := renderLiteLLMUserData(t).Files["/data/docker-compose.yml"]
+	assert.Contains(t, compose, "LITELLM_MASTER_KEY: ${LITELLM_MASTER_KEY}")
+	assert.Contains(t, compose, "OPENAI_API_KEY: placeholder")
+}
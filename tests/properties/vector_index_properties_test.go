@@ -0,0 +1,147 @@
+package properties
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vectorindex"
+)
+
+const (
+	bedrockRAGModule   = "environments/app-layer/bedrock-rag/module.bedrock_rag"
+	bedrockRAGKB       = bedrockRAGModule + ":aws_bedrockagent_knowledge_base.main"
+	seoulKB            = "environments/app-layer:aws_bedrockagent_knowledge_base.main"
+	indexMappingFile   = "modules/ai-workload/bedrock-rag/opensearch_index_mapping.json"
+	toolGuideStore     = "qdrant:tool-guide-knowledge-base"
+	documentsIndexName = "bos-ai-documents"
+
+	toolGuideCreatedByHand = "created by hand on the Qdrant instance; scripts/check_progress.ps1 only reads it"
+)
+
+// knownVectorIndexFindings waives vector index findings, keyed by
+// Finding.Key().
+var knownVectorIndexFindings = map[string]string{
+	toolGuideStore + " store": toolGuideCreatedByHand,
+}
+
+// qdrantScripts create the RTL collection over SSM when the instance's user
+// data did not.
+var qdrantScripts = []string{
+	"scripts/ssm_install_qdrant.py",
+	"scripts/ssm_start_qdrant.py",
+}
+
+// loadVectorIndex builds the vector index model of the tree. The
+// bos-ai-documents index is created by hand from the mapping file the
+// bedrock-rag module writes.
+func loadVectorIndex(t *testing.T) (*tfconfig.Tree, *vectorindex.Model) {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	md := vectorindex.Build(tree)
+	require.NoError(t, md.Bind(documentsIndexName, indexMappingFile))
+	require.NoError(t, md.AddScripts(qdrantScripts...))
+	require.Empty(t, md.Unknown, "Every knowledge base, index body and collection should be statically known")
+	return tree, md
+}
+
+// TestVectorIndex_Stores checks that every knowledge base, writer and store
+// definition of the tree is found.
+func TestVectorIndex_Stores(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadVectorIndex(t)
+	var kbs []string
+	for _, kb := range md.KnowledgeBases {
+		kbs = append(kbs, kb.ID+" "+kb.Store)
+	}
+	assert.Equal(t, []string{
+		bedrockRAGKB + " opensearch:bedrock-knowledge-base-index",
+		seoulKB + " opensearch:" + documentsIndexName,
+	}, kbs)
+
+	var writers []string
+	for _, w := range md.Writers {
+		writers = append(writers, w.Model+" "+w.Store)
+	}
+	assert.ElementsMatch(t, []string{
+		"amazon.titan-embed-text-v1 opensearch:" + documentsIndexName,
+		"amazon.titan-embed-text-v1 opensearch:" + documentsIndexName,
+		"amazon.titan-embed-text-v2:0 qdrant:rtl-knowledge-base",
+		"amazon.titan-embed-text-v2:0 " + toolGuideStore,
+	}, writers)
+
+	assert.Len(t, md.Definitions("opensearch:bedrock-knowledge-base-index"), 3,
+		"the null_resource heredoc, the local_file content and the checked-in mapping file should describe the module's index")
+	assert.Len(t, md.CollectionsNamed("qdrant:rtl-knowledge-base"), 1+len(qdrantScripts))
+}
+
+// TestVectorIndex_Check checks every OpenSearch index and Qdrant collection
+// against its definitions, the field mapping of its knowledge bases and the
+// embedding models of its users, unless waived.
+func TestVectorIndex_Check(t *testing.T) {
+	t.Parallel()
+
+	_, md := loadVectorIndex(t)
+	checkWaived(t, md.Check(), knownVectorIndexFindings)
+}
+
+// TestVectorIndex_DimensionMatchesModelForAnyVectorDimension sets the
+// embedding model and vector dimension of the bedrock-rag module to any
+// model of the table and any dimension its variable validation accepts,
+// and checks that Check reports a dimension finding for the knowledge base
+// exactly when the model's default output differs from the index. The
+// knowledge base sets no dimensions, so a model that could produce the
+// dimension on request still mismatches.
+func TestVectorIndex_DimensionMatchesModelForAnyVectorDimension(t *testing.T) {
+	tree, _ := loadVectorIndex(t)
+	var module *tfconfig.Module
+	for _, m := range tree.Modules {
+		if m.Name == bedrockRAGModule {
+			module = m
+		}
+	}
+	require.NotNil(t, module)
+	restoreVars(t, module)
+	var models []string
+	for id := range vectorindex.EmbeddingModels {
+		models = append(models, id)
+	}
+	sort.Strings(models)
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("dimension findings follow the embedding model table", prop.ForAll(
+		func(model, dim int) string {
+			em := vectorindex.EmbeddingModels[models[model]]
+			setVars(module, map[string]cty.Value{
+				"embedding_model_arn": cty.StringVal("arn:aws:bedrock:us-east-1::foundation-model/" + em.ID),
+				"vector_dimension":    cty.NumberIntVal(int64(dim)),
+			})
+
+			reported := false
+			for _, f := range vectorindex.Build(tree).Check() {
+				if f.Subject == bedrockRAGKB && f.Check == vectorindex.CheckDimension {
+					reported = true
+				}
+			}
+			if mismatch := em.Default() != dim; mismatch != reported {
+				return fmt.Sprintf("%s at %d dimensions: dimension finding reported: %v", em.ID, dim, reported)
+			}
+			return ""
+		},
+		gen.IntRange(0, len(models)-1),
+		gen.IntRange(1, 2048),
+	))
+
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}
@@ -0,0 +1,121 @@
+package properties
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/routing"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vpcendpoint"
+)
+
+const (
+	ragDocumentProcessor = "environments/app-layer/bedrock-rag:aws_lambda_function.document_processor"
+	rtlParser            = "environments/app-layer/bedrock-rag:aws_lambda_function.rtl_parser"
+	toolGuideParser      = "environments/app-layer/bedrock-rag:aws_lambda_function.tool_guide_parser"
+	pipelineProcessor    = "environments/app-layer/bedrock-rag/module.s3_pipeline:aws_lambda_function.document_processor"
+	quicksightConnector  = "environments/app-layer/quicksight:aws_lambda_function.qs_rag_connector"
+	qdrantInstance       = "environments/app-layer/bedrock-rag:aws_instance.qdrant"
+	frontendVPCNoWayOut  = "the Frontend VPC has no endpoint for this service and its 0.0.0.0/0 route ends at the TGW, which has no default route"
+	usVPCNoWayOut        = "the US VPC has no endpoint for this service and no NAT or internet gateway route"
+	usVPCNoSSMEndpoints  = "the US VPC has no ssm/ssmmessages/ec2messages endpoints and no NAT or internet gateway route"
+	frontendVPCNoBedrock = "the Bedrock endpoints live in the US VPC only; the Frontend VPC has none and no route out"
+)
+
+// knownEndpointFindings are calls with no way out in the current
+// configuration, keyed by Finding.Key(): "<workload> <endpoint service>".
+var knownEndpointFindings = map[string]string{
+	ragDocumentProcessor + " bedrock":               frontendVPCNoBedrock,
+	ragDocumentProcessor + " bedrock-agent":         frontendVPCNoBedrock,
+	ragDocumentProcessor + " bedrock-agent-runtime": frontendVPCNoBedrock,
+	ragDocumentProcessor + " bedrock-runtime":       frontendVPCNoBedrock,
+	ragDocumentProcessor + " dynamodb":              frontendVPCNoWayOut,
+	ragDocumentProcessor + " kms":                   frontendVPCNoWayOut,
+	ragDocumentProcessor + " lambda":                frontendVPCNoWayOut,
+	ragDocumentProcessor + " monitoring":            frontendVPCNoWayOut,
+	rtlParser + " bedrock-runtime":                  frontendVPCNoBedrock,
+	rtlParser + " dynamodb":                         frontendVPCNoWayOut,
+	rtlParser + " kms":                              frontendVPCNoWayOut,
+	rtlParser + " sqs":                              frontendVPCNoWayOut,
+	rtlParser + " states":                           frontendVPCNoWayOut,
+	toolGuideParser + " bedrock-runtime":            frontendVPCNoBedrock,
+	toolGuideParser + " dynamodb":                   frontendVPCNoWayOut,
+	toolGuideParser + " kms":                        frontendVPCNoWayOut,
+	toolGuideParser + " sqs":                        frontendVPCNoWayOut,
+	quicksightConnector + " kms":                    frontendVPCNoWayOut,
+	pipelineProcessor + " bedrock-agent":            usVPCNoWayOut,
+	pipelineProcessor + " kms":                      usVPCNoWayOut,
+	pipelineProcessor + " sqs":                      usVPCNoWayOut,
+	qdrantInstance + " ec2messages":                 usVPCNoSSMEndpoints,
+	qdrantInstance + " ssm":                         usVPCNoSSMEndpoints,
+	qdrantInstance + " ssmmessages":                 usVPCNoSSMEndpoints,
+}
+
+func loadEndpointInventory(t *testing.T) *vpcendpoint.Inventory {
+	t.Helper()
+
+	tree, err := tfconfig.LoadTree("../..")
+	require.NoError(t, err, "Should be able to load every stack under environments/")
+	n, err := routing.Build(tree, routing.Options{OnPremPrefixes: onPremBGPPrefixes})
+	require.NoError(t, err)
+	return vpcendpoint.Build(tree, n)
+}
+
+func workload(t *testing.T, inv *vpcendpoint.Inventory, id string) *vpcendpoint.Workload {
+	t.Helper()
+	for _, w := range inv.Workloads {
+		if w.ID == id {
+			return w
+		}
+	}
+	require.Failf(t, "workload not found", "%s", id)
+	return nil
+}
+
+// TestVPCEndpointCoverage_Resolves verifies that endpoints, workload subnets
+// and roles resolve, apart from resources pinned to literal IDs.
+func TestVPCEndpointCoverage_Resolves(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+	assert.Equal(t, []string{
+		// Launch template subnet is looked up by a literal VPC ID.
+		"environments/app-layer/bedrock-rag:aws_instance.mcp_server: subnets do not resolve to a VPC",
+		// Launch template uses a literal subnet ID.
+		"environments/app-layer/bedrock-rag:aws_instance.nginx: subnets do not resolve to a VPC",
+		// Legacy stack: literal subnet and VPC IDs.
+		"environments/app-layer:aws_lambda_function.document_processor: subnets do not resolve to a VPC",
+		"environments/app-layer:aws_opensearchserverless_vpc_endpoint.main: vpc_id",
+	}, inv.Unresolved)
+}
+
+// TestVPCEndpointCoverage_KnownFindings checks every service call of every
+// VPC workload against the endpoints and routes of its VPC.
+func TestVPCEndpointCoverage_KnownFindings(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+
+	checkWaived(t, inv.Coverage(), knownEndpointFindings)
+}
+
+// TestVPCEndpointCoverage_RAGPipeline verifies that the US VPC endpoints
+// cover the calls the S3 pipeline makes to S3, and that workloads in the
+// Logging VPC reach the internet through its NAT gateway.
+func TestVPCEndpointCoverage_RAGPipeline(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+
+	pipeline := workload(t, inv, pipelineProcessor)
+	assert.Contains(t, pipeline.Calls(), "s3")
+	assert.NotEmpty(t, inv.EndpointsFor(pipeline, "s3"))
+	assert.NotEmpty(t, inv.EndpointsFor(pipeline, "bedrock-runtime"))
+	assert.NotEmpty(t, inv.EndpointsFor(pipeline, "bedrock-agent-runtime"))
+	assert.NotEmpty(t, inv.EndpointsFor(pipeline, "aoss"))
+
+	squid := workload(t, inv, "environments/network-layer:aws_instance.squid_proxy")
+	assert.True(t, inv.HasInternetRoute(squid))
+}
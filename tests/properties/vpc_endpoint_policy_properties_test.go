@@ -0,0 +1,231 @@
+package properties
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bos-ai/infrastructure/tests/internal/finding"
+	"github.com/bos-ai/infrastructure/tests/internal/iampolicy"
+	"github.com/bos-ai/infrastructure/tests/internal/tfconfig"
+	"github.com/bos-ai/infrastructure/tests/internal/vpcendpoint"
+)
+
+const (
+	usEndpoints   = "environments/app-layer/bedrock-rag/module.vpc_endpoints:aws_vpc_endpoint."
+	kbBucket      = "bos-ai-documents-us" // Bedrock KB data source (module.s3_pipeline destination)
+	knowledgeBase = "arn:aws:bedrock:us-east-1:533335672315:knowledge-base/KB0000001"
+
+	kbBucketNotGranted = "module.iam grants lambda_processor read access to the Seoul source bucket only, " +
+		"but the function is notified by the destination bucket"
+)
+
+// knownDeniedCalls waives needed calls that the policies do not allow yet,
+// keyed by test case name.
+var knownDeniedCalls = map[string]string{
+	"GetObject on the KB bucket":  kbBucketNotGranted,
+	"ListBucket on the KB bucket": kbBucketNotGranted,
+}
+
+// TestVPCEndpointPolicy_NeededCalls evaluates the calls the RAG pipeline
+// depends on against the caller's identity policies, the US VPC endpoint
+// policy and the target's resource policy, and checks that they are allowed
+// unless waived. Whether the caller can reach the endpoint is covered by
+// TestVPCEndpointCoverage_KnownFindings.
+func TestVPCEndpointPolicy_NeededCalls(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+
+	testCases := []struct {
+		name     string
+		caller   string
+		endpoint string
+		action   string
+		resource string
+	}{
+		{"InvokeModel on the embedding model", ragDocumentProcessor, usEndpoints + "bedrock_runtime",
+			"bedrock:InvokeModel", "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-embed-text-v1"},
+		{"InvokeModel on the Claude inference profile", ragDocumentProcessor, usEndpoints + "bedrock_runtime",
+			"bedrock:InvokeModel", "arn:aws:bedrock:us-east-1:533335672315:inference-profile/us.anthropic.claude-haiku-4-5-20251001-v1:0"},
+		{"Retrieve on the knowledge base", ragDocumentProcessor, usEndpoints + "bedrock_agent_runtime",
+			"bedrock:Retrieve", knowledgeBase},
+		{"RetrieveAndGenerate on the knowledge base", ragDocumentProcessor, usEndpoints + "bedrock_agent_runtime",
+			"bedrock:RetrieveAndGenerate", knowledgeBase},
+		{"GetObject on the KB bucket", pipelineProcessor, usEndpoints + "s3",
+			"s3:GetObject", "arn:aws:s3:::" + kbBucket + "/docs/spec.pdf"},
+		{"ListBucket on the KB bucket", pipelineProcessor, usEndpoints + "s3",
+			"s3:ListBucket", "arn:aws:s3:::" + kbBucket},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ep := inv.Endpoint(tc.endpoint)
+			require.NotNil(t, ep, tc.endpoint)
+			require.NotNil(t, ep.Policy, "%s should have an endpoint policy", tc.endpoint)
+
+			d, err := inv.Evaluate(workload(t, inv, tc.caller), ep, tc.action, tc.resource)
+			require.NoError(t, err)
+			assert.Equal(t, iampolicy.Allow, d.Endpoint, "the endpoint policy should allow the call")
+			if reason, ok := knownDeniedCalls[tc.name]; ok {
+				assert.NotEqual(t, "allowed", d.String(), "The call is allowed now; remove its waiver (%s)", reason)
+				return
+			}
+			assert.Equal(t, "allowed", d.String(), "identity=%q endpoint=%q resource=%q", d.Identity, d.Endpoint, d.Resource)
+		})
+	}
+}
+
+// Checks of TestVPCEndpointPolicy_S3OutsideProject.
+const (
+	checkNoPolicy       = "no-policy"
+	checkOutside        = "outside-project"
+	checkUnresolvedS3   = "unresolved-resource"
+	projectBucketPrefix = "bos-ai-" // the project_name prefix of the tree's buckets
+
+	defaultS3Policy = "the gateway endpoint keeps the default full-access policy; " +
+		"restrict it to the project buckets like module.vpc_endpoints does"
+)
+
+// knownS3EndpointFindings waives S3 endpoint findings, keyed by
+// Finding.Key().
+var knownS3EndpointFindings = map[string]string{
+	"environments/network-layer:aws_vpc_endpoint.s3_gateway " + checkNoPolicy:         defaultS3Policy,
+	"environments/network-layer:aws_vpc_endpoint.logging_s3_gateway " + checkNoPolicy: defaultS3Policy,
+}
+
+// outsideBuckets are buckets outside the project: near misses of the
+// project prefix, buckets AWS services create in an account, and the
+// project's buckets under another prefix.
+func outsideBuckets(inside []string) []string {
+	out := []string{
+		"bos-ai", "bosai-documents", "bos_ai-documents", "bos.ai-documents", "bos-aix-documents",
+		"xbos-ai-documents", "documents-bos-ai-", "other-bos-ai-documents",
+		"amzn-s3-demo-bucket",
+		"aws-glue-assets-533335672315-us-east-1",
+		"aws-cloudtrail-logs-533335672315-a1b2c3d4",
+		"cdk-hnb659fds-assets-533335672315-us-east-1",
+		"elasticbeanstalk-us-east-1-533335672315",
+		"sagemaker-us-east-1-533335672315",
+	}
+	for _, b := range inside {
+		out = append(out, "other-"+strings.TrimPrefix(b, projectBucketPrefix))
+	}
+	return out
+}
+
+// s3Requests are the requests on a bucket the outside-project check makes.
+func s3Requests(bucket string, knownResources bool) []iampolicy.Request {
+	var out []iampolicy.Request
+	for _, r := range []struct{ action, resource string }{
+		{"s3:GetObject", "arn:aws:s3:::" + bucket + "/docs/spec.pdf"},
+		{"s3:GetObjectVersion", "arn:aws:s3:::" + bucket + "/docs/spec.pdf"},
+		{"s3:PutObject", "arn:aws:s3:::" + bucket + "/docs/spec.pdf"},
+		{"s3:ListBucket", "arn:aws:s3:::" + bucket},
+	} {
+		out = append(out, iampolicy.Request{
+			Principal:      "arn:aws:iam::" + tfconfig.Unknown + ":role/any",
+			Action:         r.action,
+			Resource:       r.resource,
+			Context:        map[string]string{"aws:PrincipalAccount": tfconfig.Unknown},
+			KnownResources: knownResources,
+		})
+	}
+	return out
+}
+
+// TestVPCEndpointPolicy_S3OutsideProject verifies that S3 endpoints do not
+// let callers in the account reach buckets outside the project, while still
+// allowing the project's own buckets. An endpoint without a policy reaches
+// every bucket. Resources that are not statically known may match any
+// bucket, so an outside request they allow is reported as unresolved rather
+// than dropped.
+func TestVPCEndpointPolicy_S3OutsideProject(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+	inside := []string{kbBucket, "bos-ai-documents-seoul-v3"}
+
+	var findings []finding.Finding
+	checked := 0
+	for _, ep := range inv.Endpoints {
+		if ep.Service != "s3" {
+			continue
+		}
+		if ep.Policy == nil {
+			findings = append(findings, finding.Finding{Subject: ep.ID, Check: checkNoPolicy,
+				Detail: "without a policy the endpoint allows every bucket, inside the project or not"})
+			continue
+		}
+		checked++
+		reached := map[string][]string{}
+		for _, bucket := range outsideBuckets(inside) {
+			for _, req := range s3Requests(bucket, false) {
+				if !ep.Allows(req) {
+					continue
+				}
+				known := req
+				known.KnownResources = true
+				check := checkUnresolvedS3
+				if ep.Allows(known) {
+					check = checkOutside
+				}
+				reached[check] = append(reached[check], req.Action+" on "+req.Resource)
+			}
+		}
+		for _, check := range keys(reached) {
+			findings = append(findings, finding.Finding{Subject: ep.ID, Check: check,
+				Detail: "allows " + strings.Join(reached[check], ", ")})
+		}
+		for _, bucket := range inside {
+			for _, req := range s3Requests(bucket, true) {
+				assert.True(t, ep.Allows(req), "%s should allow %s on %s", ep.ID, req.Action, req.Resource)
+			}
+		}
+	}
+	assert.Equal(t, 1, checked, "the US VPC S3 endpoint has a policy")
+	checkWaived(t, findings, knownS3EndpointFindings)
+}
+
+// TestVPCEndpointPolicy_S3PoliciesDenyAnyOutsideBucket checks the S3
+// endpoints that have a policy against random bucket names without the
+// project prefix, letting unknown resources match them.
+func TestVPCEndpointPolicy_S3PoliciesDenyAnyOutsideBucket(t *testing.T) {
+	t.Parallel()
+
+	inv := loadEndpointInventory(t)
+	var eps []*vpcendpoint.Endpoint
+	for _, ep := range inv.Endpoints {
+		if ep.Service == "s3" && ep.Policy != nil {
+			eps = append(eps, ep)
+		}
+	}
+	require.NotEmpty(t, eps)
+
+	properties := gopter.NewProperties(nil)
+	properties.Property("no request on a bucket outside the project is allowed", prop.ForAll(
+		func(bucket string) bool {
+			for _, ep := range eps {
+				for _, req := range s3Requests(bucket, false) {
+					if ep.Allows(req) {
+						t.Logf("%s allows %s on %s", ep.ID, req.Action, req.Resource)
+						return false
+					}
+				}
+			}
+			return true
+		},
+		gen.RegexMatch(`[a-z0-9][a-z0-9.-]{2,62}`).SuchThat(func(b string) bool {
+			return !strings.HasPrefix(b, projectBucketPrefix)
+		}),
+	))
+	properties.TestingRun(t, gopter.ConsoleReporter(false))
+}